@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// abuseBatch is the most IPs looked up in AbuseIPDB each run. Free accounts
+// can make 1,000 checks a day.
+const abuseBatch = 50
+
+// abuseClient checks IPs with AbuseIPDB.
+type abuseClient struct {
+	// url is the check endpoint
+	url     string
+	key     string
+	refresh time.Duration
+	client  *http.Client
+}
+
+func newAbuseClient(checkURL, key string, refresh time.Duration) *abuseClient {
+	return &abuseClient{url: checkURL, key: key, refresh: refresh, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// abuseCheck is the part of an AbuseIPDB check response which is used.
+type abuseCheck struct {
+	Data struct {
+		AbuseConfidenceScore int `json:"abuseConfidenceScore"`
+		TotalReports         int `json:"totalReports"`
+	} `json:"data"`
+}
+
+// query checks ip against reports from the last 90 days.
+func (c *abuseClient) query(ip string) (scan.AbuseScore, error) {
+	score := scan.AbuseScore{IP: ip}
+	req, err := http.NewRequest("GET", c.url+"?"+url.Values{"ipAddress": {ip}, "maxAgeInDays": {"90"}}.Encode(), nil)
+	if err != nil {
+		return score, err
+	}
+	req.Header.Set("Key", c.key)
+	req.Header.Set("Accept", "application/json")
+	res, err := c.client.Do(req)
+	if err != nil {
+		return score, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return score, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	var check abuseCheck
+	if err := json.NewDecoder(res.Body).Decode(&check); err != nil {
+		return score, err
+	}
+	score.Score = check.Data.AbuseConfidenceScore
+	score.Reports = check.Data.TotalReports
+	return score, nil
+}
+
+// enrichAbuseIPDB checks the external IPs never checked, or last checked
+// longer ago than the refresh interval. Failed checks aren't saved, so
+// they're tried again on the next run.
+func (app *App) enrichAbuseIPDB(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	ips, err := app.db.LoadStaleAbuseIPs(now.Add(-app.abuseIPDB.refresh))
+	if err != nil {
+		log.Println("abuseipdb: error loading IPs to check:", err)
+		return
+	}
+	var scores []scan.AbuseScore
+	for _, ip := range ips {
+		if addr := net.ParseIP(ip); addr == nil || !isPublicIP(addr) {
+			continue
+		}
+		if len(scores) == abuseBatch {
+			break
+		}
+		score, err := app.abuseIPDB.query(ip)
+		if err != nil {
+			log.Printf("abuseipdb: error checking %s: %v", ip, err)
+			continue
+		}
+		score.Updated = scan.Time{Time: now.UTC()}
+		scores = append(scores, score)
+	}
+	if err := app.db.SaveAbuseScores(scores); err != nil {
+		log.Println("abuseipdb: error saving scores:", err)
+		return
+	}
+	if verbose && len(scores) > 0 {
+		log.Printf("abuseipdb: checked %d IPs", len(scores))
+	}
+}
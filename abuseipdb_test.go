@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestAbuseIPDB(t *testing.T) {
+	db := createDB("TestAbuseIPDB")
+	defer db.Close()
+
+	var checked []string
+	abuseIPDB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Key") != "secret" {
+			http.Error(w, "unauthorised", http.StatusUnauthorized)
+			return
+		}
+		ip := r.URL.Query().Get("ipAddress")
+		checked = append(checked, ip)
+		score := "0"
+		if ip == "192.0.2.1" {
+			score = "87"
+		}
+		w.Write([]byte(`{"data":{"ipAddress":"` + ip + `","isPublic":true,"abuseConfidenceScore":` + score + `,"totalReports":` + score + `}}`))
+	}))
+	defer abuseIPDB.Close()
+
+	app := App{db: db, abuseIPDB: newAbuseClient(abuseIPDB.URL, "secret", 24*time.Hour)}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "10.0.0.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	app.enrichAbuseIPDB(now)
+	if !reflect.DeepEqual(checked, []string{"192.0.2.1", "198.51.100.1"}) {
+		t.Errorf("expected only external IPs checked, got %v", checked)
+	}
+	scores, err := db.LoadAbuseScores(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 2 || scores[0].Score != 87 || scores[0].Reports != 87 || scores[1].Score != 0 {
+		t.Errorf("unexpected scores %+v", scores)
+	}
+
+	checked = nil
+	app.enrichAbuseIPDB(now.Add(time.Hour))
+	if len(checked) != 0 {
+		t.Errorf("expected no checks before the refresh, got %v", checked)
+	}
+
+	for _, tt := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"abuse": {"50"}}, 1},
+		{url.Values{"q": {"abuse:90"}}, 0},
+		{url.Values{}, 3},
+	} {
+		query, err := resultQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _, err := db.ResultPage(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results) != tt.want {
+			t.Errorf("%v: expected %d results, got %d", tt.query, tt.want, len(data.Results))
+			continue
+		}
+		if tt.want == 1 && data.Results[0].AbuseScore != 87 {
+			t.Errorf("%v: expected the abuse score on the result, got %+v", tt.query, data.Results[0])
+		}
+	}
+	for _, abuse := range []string{"x", "101", "-1"} {
+		if _, err := resultQuery(url.Values{"abuse": {abuse}}); err == nil {
+			t.Errorf("expected an error for abuse=%s", abuse)
+		}
+	}
+
+	res, err := http.Get(ts.URL + "/host/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(b), "Abuse 87%") {
+		t.Error("expected the abuse score on the host page")
+	}
+}
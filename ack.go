@@ -0,0 +1,243 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// ackedPorts returns the set of ports, by portKey, which are acknowledged at
+// now.
+func (app *App) ackedPorts(now time.Time) map[string]bool {
+	acks, err := app.db.LoadAcks(now)
+	if err != nil {
+		log.Println("ackedPorts: error loading acknowledgements:", err)
+		return nil
+	}
+	acked := make(map[string]bool, len(acks))
+	for _, a := range acks {
+		acked[portKey(a.IP, a.Port, a.Proto)] = true
+	}
+	return acked
+}
+
+// validateAck checks an acknowledgement names a single port and, if it
+// expires, that it hasn't already.
+func validateAck(a scan.Ack, now time.Time) error {
+	if net.ParseIP(a.IP) == nil {
+		return fmt.Errorf("invalid IP %q", a.IP)
+	}
+	if a.Port < 1 || a.Port > 65535 {
+		return fmt.Errorf("invalid port %d", a.Port)
+	}
+	switch a.Proto {
+	case "tcp", "udp":
+	default:
+		return fmt.Errorf("invalid proto %q", a.Proto)
+	}
+	if a.Expires != nil && !a.Expires.After(now) {
+		return errors.New("expiry must be in the future")
+	}
+	return nil
+}
+
+// auditAck records a change to an acknowledgement in the audit log.
+func (app *App) auditAck(user *User, event string, a scan.Ack) {
+	info, _ := json.Marshal(a)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditAck: error saving %s for %s: %v", event, portKey(a.IP, a.Port, a.Proto), err)
+	}
+}
+
+// Handler for GET /api/v1/acks
+func (app *App) listAcks(w http.ResponseWriter, r *http.Request) {
+	acks, err := app.db.LoadAcks(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, acks)
+}
+
+// Handler for POST /api/v1/acks
+// An existing acknowledgement for the same port is replaced.
+func (app *App) newAck(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var a scan.Ack
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.IP = scan.NormalizeIP(a.IP)
+	now := time.Now().UTC()
+	if err := validateAck(a, now); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.User = user.Email
+	a.Created = scan.Time{Time: now}
+
+	if err := app.db.SaveAcks(a); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditAck(user, "add_ack", a)
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, a)
+}
+
+// Handler for DELETE /api/v1/acks/{ip}/{port}/{proto}
+func (app *App) deleteAck(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	a := scan.Ack{IP: chi.URLParam(r, "ip"), Proto: chi.URLParam(r, "proto")}
+	port, err := strconv.Atoi(chi.URLParam(r, "port"))
+	if err != nil {
+		http.Error(w, "Invalid port", http.StatusBadRequest)
+		return
+	}
+	a.Port = port
+
+	err = app.db.DeleteAck(a.IP, a.Port, a.Proto)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Acknowledgement not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditAck(user, "delete_ack", a)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ackFilter selects the open ports to acknowledge in one go, such as port
+// 443 across a network.
+type ackFilter struct {
+	CIDR    string     `json:"cidr,omitempty"`
+	Port    int        `json:"port,omitempty"`
+	Proto   string     `json:"proto,omitempty"`
+	Note    string     `json:"note,omitempty"`
+	Expires *scan.Time `json:"expires,omitempty"`
+
+	network *net.IPNet
+}
+
+// validate checks the filter and parses its CIDR. A filter must name a network
+// or a port so everything isn't acknowledged by mistake.
+func (f *ackFilter) validate(now time.Time) error {
+	if f.CIDR == "" && f.Port == 0 {
+		return errors.New("a cidr or port is required")
+	}
+	if f.CIDR != "" {
+		_, network, err := net.ParseCIDR(f.CIDR)
+		if err != nil {
+			return err
+		}
+		f.network = network
+	}
+	if f.Port < 0 || f.Port > 65535 {
+		return fmt.Errorf("invalid port %d", f.Port)
+	}
+	switch f.Proto {
+	case "", "tcp", "udp":
+	default:
+		return fmt.Errorf("invalid proto %q", f.Proto)
+	}
+	if f.Expires != nil && !f.Expires.After(now) {
+		return errors.New("expiry must be in the future")
+	}
+	return nil
+}
+
+// matchAcks returns acknowledgements for the open ports in data which match
+// the filter.
+func (f *ackFilter) matchAcks(data []scan.IPInfo, user string, now time.Time) []scan.Ack {
+	acks := []scan.Ack{}
+	for _, r := range data {
+		if r.Gone {
+			continue
+		}
+		if f.network != nil && !f.network.Contains(net.ParseIP(r.IP)) {
+			continue
+		}
+		acks = append(acks, scan.Ack{
+			IP: r.IP, Port: r.Port, Proto: r.Proto,
+			User: user, Note: f.Note, Created: scan.Time{Time: now}, Expires: f.Expires,
+		})
+	}
+	return acks
+}
+
+// Handler for POST /api/v1/ack
+// Every open port matching the filter is acknowledged in one transaction,
+// with a single audit entry.
+func (app *App) batchAck(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var f ackFilter
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC()
+	if err := f.validate(now); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var filter sqlite.SQLFilter
+	if f.Port != 0 {
+		filter.Where = append(filter.Where, "port=?")
+		filter.Values = append(filter.Values, f.Port)
+	}
+	if f.Proto != "" {
+		filter.Where = append(filter.Where, "proto=?")
+		filter.Values = append(filter.Values, f.Proto)
+	}
+	data, err := app.db.LoadData(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	acks := f.matchAcks(data, user.Email, now)
+	if len(acks) == 0 {
+		http.Error(w, "No open ports match", http.StatusNotFound)
+		return
+	}
+
+	if err := app.db.SaveAcks(acks...); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	info, _ := json.Marshal(struct {
+		ackFilter
+		Count int `json:"count"`
+	}{f, len(acks)})
+	if err := app.audit(user.Email, "add_acks", string(info)); err != nil {
+		log.Printf("batchAck: error saving add_acks for %d ports: %v", len(acks), err)
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, acks)
+}
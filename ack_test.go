@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestAcks(t *testing.T) {
+	db := createDB("TestAcks")
+	defer db.Close()
+
+	slack := testNotifier{"slack", make(chan event, 10)}
+	app := App{db: db, notifiers: []notifier{slack}}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "admin@example.com"}}}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, now); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := now.Add(-time.Hour).Format(time.RFC3339)
+	for _, tt := range []struct {
+		ack  string
+		code int
+	}{
+		{`{"ip": "192.0.2.1", "port": 22, "proto": "tcp", "note": "bastion"}`, http.StatusCreated},
+		{`{"ip": "192.0.2.1", "port": 80, "proto": "tcp", "expires": "` + expired + `"}`, http.StatusBadRequest},
+		{`{"ip": "bastion", "port": 22, "proto": "tcp"}`, http.StatusBadRequest},
+		{`{"ip": "192.0.2.1", "port": 22, "proto": "icmp"}`, http.StatusBadRequest},
+	} {
+		res, err := http.Post(ts.URL+"/api/v1/acks", "application/json", bytes.NewBufferString(tt.ack))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.ack, tt.code, res.StatusCode)
+		}
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/acks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var acks []scan.Ack
+	json.NewDecoder(res.Body).Decode(&acks)
+	res.Body.Close()
+	if len(acks) != 1 || acks[0].User != "admin@example.com" || acks[0].Note != "bastion" {
+		t.Fatalf("unexpected acks %+v", acks)
+	}
+
+	// The acknowledged port isn't new, and isn't alerted on
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range data {
+		acked := r.Port == 22
+		if acked != (r.Ack != nil) || acked == r.New {
+			t.Errorf("port %d: unexpected new %v and ack %+v", r.Port, r.New, r.Ack)
+		}
+	}
+	app.notify([]event{
+		{Type: eventNewPort, Time: now, IP: "192.0.2.1", Port: 22, Proto: "tcp"},
+		{Type: eventNewPort, Time: now, IP: "192.0.2.1", Port: 80, Proto: "tcp"},
+	})
+	select {
+	case e := <-slack.events:
+		if e.Port != 80 {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	select {
+	case e := <-slack.events:
+		t.Errorf("unexpected event %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	req, _ := http.NewRequest("DELETE", ts.URL+"/api/v1/acks/192.0.2.1/22/tcp", nil)
+	for _, code := range []int{http.StatusNoContent, http.StatusNotFound} {
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("expected status %d deleting ack, got %d", code, res.StatusCode)
+		}
+	}
+
+	// Expired acknowledgements are ignored
+	past := scan.Time{Time: now.Add(-time.Minute)}
+	err = db.SaveAcks(scan.Ack{IP: "192.0.2.1", Port: 80, Proto: "tcp", User: "admin@example.com", Created: scan.Time{Time: now.Add(-time.Hour)}, Expires: &past})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acks, err := db.LoadAcks(now); err != nil || len(acks) != 0 {
+		t.Errorf("expected no current acks, got %+v (%v)", acks, err)
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT count(*) FROM audit WHERE user = 'admin@example.com' AND action IN ('add_ack', 'delete_ack')`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 audited changes, got %d", count)
+	}
+}
+
+func TestBatchAck(t *testing.T) {
+	db := createDB("TestBatchAck")
+	defer db.Close()
+
+	app := App{db: db}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "admin@example.com"}}}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var results []scan.Result
+	for _, r := range []struct {
+		ip   string
+		port int
+	}{{"10.1.0.1", 443}, {"10.1.2.3", 443}, {"10.1.2.3", 22}, {"10.2.0.1", 443}} {
+		results = append(results, scan.Result{IP: r.ip, Ports: []scan.Port{{Port: r.port, Proto: "tcp", Status: "open"}}})
+	}
+	if _, _, err := db.SaveData(results, now); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		filter string
+		code   int
+	}{
+		{`{"note": "everything"}`, http.StatusBadRequest},
+		{`{"cidr": "10.1.0.0/33", "port": 443}`, http.StatusBadRequest},
+		{`{"cidr": "10.1.0.0/16", "port": 443, "proto": "icmp"}`, http.StatusBadRequest},
+		{`{"cidr": "10.3.0.0/16"}`, http.StatusNotFound},
+	} {
+		res, err := http.Post(ts.URL+"/api/v1/ack", "application/json", bytes.NewBufferString(tt.filter))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.filter, tt.code, res.StatusCode)
+		}
+	}
+
+	filter := `{"cidr": "10.1.0.0/16", "port": 443, "note": "load balancers"}`
+	res, err := http.Post(ts.URL+"/api/v1/ack", "application/json", bytes.NewBufferString(filter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", res.StatusCode)
+	}
+
+	acks, err := db.LoadAcks(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acks) != 2 || acks[0].IP != "10.1.0.1" || acks[1].IP != "10.1.2.3" || acks[1].Port != 443 {
+		t.Errorf("unexpected acks %+v", acks)
+	}
+	for _, a := range acks {
+		if a.Note != "load balancers" || a.User != "admin@example.com" {
+			t.Errorf("unexpected ack %+v", a)
+		}
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT count(*) FROM audit WHERE action = 'add_acks'`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected one audit entry, got %d", count)
+	}
+}
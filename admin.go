@@ -23,29 +23,12 @@ func (app *App) adminHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user User
-	session, err := store.Get(r, "user")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	u := currentUser(r)
+	if u == nil {
+		tmpl.ExecuteTemplate(w, "index", loginData(w, r))
 		return
 	}
-	if _, ok := session.Values["user"]; !ok {
-		data := indexData{URI: r.RequestURI}
-		if flash := session.Flashes("unauth_flash"); len(flash) > 0 {
-			data.NotAuth = flash[0].(string)
-			w.WriteHeader(http.StatusUnauthorized)
-			session.Save(r, w)
-		}
-		tmpl.ExecuteTemplate(w, "index", data)
-		return
-	}
-	v := session.Values["user"]
-	switch v := v.(type) {
-	case string:
-		user.Email = v
-	case User:
-		user = v
-	}
+	user := *u
 
 	users, err := app.db.LoadUsers()
 	if err != nil {
@@ -75,6 +58,9 @@ func (app *App) adminHandler(w http.ResponseWriter, r *http.Request) {
 		case err == errSelfDeletion:
 			data.AddError(selfDeletion)
 			w.WriteHeader(http.StatusBadRequest)
+		case err == errApprovalRequested:
+			data.Notice = approvalRequested
+			w.WriteHeader(http.StatusAccepted)
 		case err != nil:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -117,6 +103,11 @@ func (app *App) adminFormProcess(f url.Values, user User, users []string) error
 		if user.Email == delete {
 			return errSelfDeletion
 		}
+		if app.approvals {
+			return app.requestApproval(user.Email, "delete_user", struct {
+				Email string `json:"email"`
+			}{delete})
+		}
 		if err := app.db.DeleteUser(delete); err != nil {
 			return err
 		}
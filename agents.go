@@ -0,0 +1,212 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// agentName matches the names agents may identify themselves with.
+var agentName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// maxAgentDetail is the longest agent version or scanner accepted.
+const maxAgentDetail = 256
+
+// remoteIP returns the IP address a request came from.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// peerAddr returns the address of the connection's peer. Unlike remoteIP it
+// can't be set by the client with X-Forwarded-For.
+func peerAddr(r *http.Request) string {
+	if ip := peerIP(r); ip != nil {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+// Handler for POST /agents
+//
+// Records a heartbeat from an agent, registering it from the address it came
+// from the first time. The body is a JSON object with the agent's name,
+// version and scanner.
+func (app *App) agentHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var a scan.Agent
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !agentName.MatchString(a.Name) {
+		http.Error(w, "Invalid agent name", http.StatusBadRequest)
+		return
+	}
+	if len(a.Version) > maxAgentDetail || len(a.Scanner) > maxAgentDetail {
+		http.Error(w, "Agent version and scanner are too long", http.StatusBadRequest)
+		return
+	}
+	a.Addr = peerAddr(r)
+	now := time.Now().UTC()
+	if err := dbError("save_agent", app.db.SaveAgentHeartbeat(a, now)); err != nil {
+		log.Println("agentHeartbeat: error saving heartbeat:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	gaugeAgentSeen.WithLabelValues(a.Name).Set(float64(now.Unix()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestAgent returns the agent named in the request's X-Agent header if
+// it's registered at the address the request came from, or else "". Agents
+// are only registered by authenticated heartbeats, so a submission can't
+// claim to be from an agent it isn't.
+func (app *App) requestAgent(r *http.Request) string {
+	name := r.Header.Get("X-Agent")
+	if name == "" {
+		return ""
+	}
+	agents, err := app.db.LoadAgents()
+	if err != nil {
+		log.Println("requestAgent: error loading agents:", err)
+		return ""
+	}
+	addr := peerAddr(r)
+	for _, a := range agents {
+		if a.Name == name && a.Addr == addr {
+			return name
+		}
+	}
+	return ""
+}
+
+// agentScan records that agent, if any, submitted results at now.
+func (app *App) agentScan(agent string, now time.Time) {
+	if agent == "" {
+		return
+	}
+	if err := dbError("save_agent", app.db.SaveAgentScan(agent, now)); err != nil {
+		log.Printf("agentScan: error recording scan from %s: %v", agent, err)
+		return
+	}
+	gaugeAgentSeen.WithLabelValues(agent).Set(float64(now.Unix()))
+	gaugeAgentScan.WithLabelValues(agent).Set(float64(now.Unix()))
+}
+
+// loadAgents returns the registered agents, marking those which haven't
+// been heard from for longer than the agent timeout as down.
+func (app *App) loadAgents(now time.Time) ([]scan.Agent, error) {
+	agents, err := app.db.LoadAgents()
+	if err != nil {
+		return nil, err
+	}
+	for i := range agents {
+		agents[i].Down = now.Sub(agents[i].LastSeen.Time) > app.agentTimeout
+	}
+	return agents, nil
+}
+
+// Handler for GET /api/v1/agents
+func (app *App) listAgents(w http.ResponseWriter, r *http.Request) {
+	agents, err := app.loadAgents(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, agents)
+}
+
+// Handler for PUT /api/v1/agents/{name}
+//
+// Moves a registered agent to a new address. Heartbeats don't change it, so
+// this is how an agent which has moved is attributed its results again. The
+// body is a JSON object with the agent's addr.
+func (app *App) updateAgent(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var a scan.Agent
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ip := net.ParseIP(a.Addr)
+	if ip == nil {
+		http.Error(w, "Invalid agent address", http.StatusBadRequest)
+		return
+	}
+	a.Name = chi.URLParam(r, "name")
+	a.Addr = ip.String()
+	err := app.db.UpdateAgentAddr(a.Name, a.Addr)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	info, _ := json.Marshal(struct {
+		Name string `json:"name"`
+		Addr string `json:"addr"`
+	}{a.Name, a.Addr})
+	if err := app.audit(user.Email, "update_agent", string(info)); err != nil {
+		log.Println("updateAgent: error writing audit log:", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type agentsData struct {
+	indexData
+	Agents  []scan.Agent
+	Timeout time.Duration
+}
+
+// Handler for GET /agents
+//
+// Shows the registered agents, with those which have stopped sending
+// heartbeats first.
+func (app *App) agentsPage(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if !authDisabled {
+		u := currentUser(r)
+		if u == nil {
+			tmpl.ExecuteTemplate(w, "index", loginData(w, r))
+			return
+		}
+		user = *u
+	}
+
+	agents, err := app.loadAgents(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var down, up []scan.Agent
+	for _, a := range agents {
+		if a.Down {
+			down = append(down, a)
+		} else {
+			up = append(up, a)
+		}
+	}
+	data := agentsData{
+		indexData: indexData{Authenticated: true, User: user, URI: r.URL.Path},
+		Agents:    append(down, up...),
+		Timeout:   app.agentTimeout,
+	}
+	tmpl.ExecuteTemplate(w, "agents", data)
+}
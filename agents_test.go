@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestAgents(t *testing.T) {
+	db := createDB("TestAgents")
+	defer db.Close()
+	app := App{db: db, agentTimeout: time.Minute}
+
+	for _, body := range []string{
+		`{"name": "scanner1", "version": "1.0", "scanner": "masscan 1.3.2"}`,
+		`{"name": "scanner2", "version": "1.1"}`,
+	} {
+		r := httptest.NewRequest("POST", "/agents", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		app.agentHeartbeat(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d: %s", w.Code, w.Body)
+		}
+	}
+	for _, body := range []string{
+		`{"name": ""}`,
+		`{"name": "bad name"}`,
+		`{"name": "scanner3", "version": "` + strings.Repeat("1", maxAgentDetail+1) + `"}`,
+		`not json`,
+	} {
+		r := httptest.NewRequest("POST", "/agents", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		app.agentHeartbeat(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, w.Code)
+		}
+	}
+
+	// A heartbeat from elsewhere doesn't move a registered agent
+	r := httptest.NewRequest("POST", "/agents", strings.NewReader(`{"name": "scanner1", "version": "1.0", "scanner": "masscan 1.3.2"}`))
+	r.RemoteAddr = "198.51.100.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.1")
+	w := httptest.NewRecorder()
+	app.agentHeartbeat(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", w.Code, w.Body)
+	}
+
+	// Results from an agent record its last scan
+	r = httptest.NewRequest("POST", "/results", bytes.NewBufferString(`[
+		{"ip": "192.0.2.1", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}
+	]`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Agent", "scanner1")
+	w = httptest.NewRecorder()
+	app.recvResults(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	// Results don't register an agent
+	r = httptest.NewRequest("POST", "/results", bytes.NewBufferString(`[
+		{"ip": "192.0.2.2", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}
+	]`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Agent", "scanner3")
+	w = httptest.NewRecorder()
+	app.recvResults(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	// An agent which has stopped sending heartbeats is down
+	old := scan.Agent{Name: "scanner2", Version: "1.1"}
+	if err := db.SaveAgentHeartbeat(old, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	r = httptest.NewRequest("GET", "/api/v1/agents", nil)
+	w = httptest.NewRecorder()
+	app.listAgents(w, r)
+	var agents []scan.Agent
+	if err := json.NewDecoder(w.Body).Decode(&agents); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]scan.Agent)
+	for _, a := range agents {
+		got[a.Name] = a
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 agents, got %+v", agents)
+	}
+	if a := got["scanner1"]; a.Down || a.Version != "1.0" || a.Scanner != "masscan 1.3.2" || a.Addr != "192.0.2.1" || a.LastScan == nil {
+		t.Errorf("unexpected agent %+v", a)
+	}
+	if a := got["scanner2"]; !a.Down || a.LastScan != nil {
+		t.Errorf("expected scanner2 down with no scans, got %+v", a)
+	}
+
+	r = httptest.NewRequest("GET", "/agents", nil)
+	w = httptest.NewRecorder()
+	app.agentsPage(w, r)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || strings.Index(body, "scanner2") > strings.Index(body, "scanner1") {
+		t.Errorf("expected the down agent listed first, got %d: %s", w.Code, body)
+	}
+}
+
+func TestAgentHeartbeatAuth(t *testing.T) {
+	db := createDB("TestAgentHeartbeatAuth")
+	defer db.Close()
+	app := App{db: db, agentTimeout: time.Minute}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+
+	// Nobody can register an agent without authenticating
+	res, err := http.Post(ts.URL+"/agents", "application/json", strings.NewReader(`{"name": "scanner1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for an anonymous heartbeat, got %d", res.StatusCode)
+	}
+	if agents, err := db.LoadAgents(); err != nil || len(agents) != 0 {
+		t.Errorf("expected no agents registered, got %+v %v", agents, err)
+	}
+}
+
+func TestUpdateAgent(t *testing.T) {
+	db := createDB("TestUpdateAgent")
+	defer db.Close()
+	app := App{db: db, agentTimeout: time.Minute}
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "admin@example.com"}}}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	if err := db.SaveAgentHeartbeat(scan.Agent{Name: "scanner1", Addr: "192.0.2.1"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name, body string
+		code       int
+	}{
+		{"scanner1", `{"addr": "not an address"}`, http.StatusBadRequest},
+		{"scanner2", `{"addr": "198.51.100.1"}`, http.StatusNotFound},
+		{"scanner1", `{"addr": "198.51.100.1"}`, http.StatusNoContent},
+	} {
+		req, _ := http.NewRequest("PUT", ts.URL+"/api/v1/agents/"+tt.name, strings.NewReader(tt.body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s %s: expected status %d, got %d", tt.name, tt.body, tt.code, res.StatusCode)
+		}
+	}
+	agents, err := db.LoadAgents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 1 || agents[0].Addr != "198.51.100.1" {
+		t.Errorf("expected scanner1 moved, got %+v", agents)
+	}
+}
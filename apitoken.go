@@ -0,0 +1,304 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// Scopes of API tokens
+const (
+	scopeIngest = "ingest"
+	scopeRead   = "read"
+	scopeAdmin  = "admin"
+)
+
+// ingestRoutes are the methods and paths tokens with the ingest scope may
+// use: those scanners and agents need to submit results and claim jobs.
+var ingestRoutes = map[string]bool{
+	"POST /results":    true,
+	"GET /jobs":        true,
+	"POST /jobs/claim": true,
+	"POST /agents":     true,
+	"POST /pdns":       true,
+	"POST /traceroute": true,
+}
+
+// apiTokenAuth authenticates requests with the API tokens kept in the
+// database, giving the user the token's scope and CIDRs.
+type apiTokenAuth struct {
+	app *App
+}
+
+func (a apiTokenAuth) authenticate(r *http.Request) (*User, error) {
+	tok, ok := bearerToken(r)
+	if !ok {
+		return nil, errNoCredentials
+	}
+	sum := sha256.Sum256([]byte(tok))
+	t, err := a.app.db.APIToken(sum[:])
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, errNoCredentials
+	case err != nil:
+		return nil, err
+	}
+	return &User{Email: t.Email, Token: t.Name, Scope: t.Scope, CIDRs: t.CIDRs}, nil
+}
+
+// tokenScopes is a middleware which refuses requests an API token's scope
+// doesn't allow. Read tokens may only make GET requests and ingest tokens may
+// only use the ingest routes. Users who logged in, and tokens from the
+// -auth.tokens file, may do anything.
+func tokenScopes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := currentUser(r)
+		if user == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		switch user.Scope {
+		case scopeRead:
+			if (r.Method != "GET" && r.Method != "HEAD") || strings.HasPrefix(path, "/api/v1/tokens") {
+				http.Error(w, "Token is read-only", http.StatusForbidden)
+				return
+			}
+		case scopeIngest:
+			if !ingestRoutes[r.Method+" "+path] && !(r.Method == "PUT" && strings.HasPrefix(path, "/results/")) {
+				http.Error(w, "Token may only submit results", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unrestricted is a middleware which refuses requests with an API token
+// restricted to CIDRs, for anything which isn't limited to them.
+func unrestricted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(requestCIDRs(r)) > 0 {
+			http.Error(w, "Not available to tokens restricted to CIDRs", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestCIDRs returns the CIDRs the API token making the request is
+// restricted to, if any.
+func requestCIDRs(r *http.Request) []string {
+	if u := currentUser(r); u != nil {
+		return u.CIDRs
+	}
+	return nil
+}
+
+// checkAPIToken validates a new API token and tidies its name, email and
+// CIDRs. Admin tokens can't be restricted to CIDRs, as most of what they're
+// for spans all of the results.
+func checkAPIToken(t *scan.APIToken) error {
+	t.Name = strings.TrimSpace(t.Name)
+	if !networkName.MatchString(t.Name) {
+		return errors.New("token names must be letters, digits, spaces, dots, dashes and underscores")
+	}
+	if t.Email = strings.TrimSpace(t.Email); t.Email == "" {
+		return errors.New("tokens must have an email address")
+	}
+	switch t.Scope {
+	case scopeIngest, scopeRead:
+	case scopeAdmin:
+		if len(t.CIDRs) > 0 {
+			return errors.New("admin tokens can't be restricted to CIDRs")
+		}
+	default:
+		return fmt.Errorf("scope must be %s, %s or %s", scopeIngest, scopeRead, scopeAdmin)
+	}
+	for i, cidr := range t.CIDRs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		t.CIDRs[i] = network.String()
+	}
+	if t.CIDRs == nil {
+		t.CIDRs = []string{}
+	}
+	return nil
+}
+
+// auditAPIToken records a change to an API token in the audit log. The
+// token itself is never recorded.
+func (app *App) auditAPIToken(user *User, event string, t scan.APIToken) {
+	t.Token = ""
+	info, _ := json.Marshal(t)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditAPIToken: error saving %s for %d: %v", event, t.ID, err)
+	}
+}
+
+// ownAPITokens returns the tokens a user may see and revoke: every token
+// for operators, or without authentication, and only the tokens they act as
+// or created for a tenant's users.
+func ownAPITokens(user *User, tokens []scan.APIToken) []scan.APIToken {
+	if user == nil || user.Tenant == "" {
+		return tokens
+	}
+	own := []scan.APIToken{}
+	for _, t := range tokens {
+		if t.Email == user.Email || t.CreatedBy == user.Email {
+			own = append(own, t)
+		}
+	}
+	return own
+}
+
+// Handler for GET /api/v1/tokens
+func (app *App) listAPITokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := app.db.LoadAPITokens()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, ownAPITokens(currentUser(r), tokens))
+}
+
+// Handler for POST /api/v1/tokens
+// The token acts as the user with its email, or the user creating it if
+// there isn't one. Any user can create tokens for themselves. A token for
+// someone else needs another admin's approval, so it's only allowed with
+// approvals enabled, and works once approved. A tenant's users can only ask
+// for tokens for its users, restricted to CIDRs in its address space. The
+// response is the only time the token is shown.
+func (app *App) newAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var t scan.APIToken
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if t.Email == "" {
+		t.Email = user.Email
+	}
+	if err := checkAPIToken(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	forOther := t.Email != user.Email
+	if forOther && !app.approvals {
+		http.Error(w, "Tokens can only be created for yourself unless -approvals is enabled", http.StatusForbidden)
+		return
+	}
+	if user.Tenant != "" {
+		if forOther {
+			tenant, err := app.db.UserTenant(t.Email)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if tenant != user.Tenant {
+				http.Error(w, "Tokens can only be created for your tenant's users", http.StatusForbidden)
+				return
+			}
+		}
+		for _, cidr := range t.CIDRs {
+			if code, err := app.checkTenantCIDR(user.Tenant, cidr); err != nil {
+				http.Error(w, err.Error(), code)
+				return
+			}
+		}
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256([]byte(token))
+	t.CreatedBy = user.Email
+	if forOther {
+		err := app.requestApproval(user.Email, "add_api_token", pendingAPIToken{t, sum[:]})
+		if err != errApprovalRequested {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		t.Token = token
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, t)
+		return
+	}
+	t.Created = scan.Time{Time: time.Now().UTC()}
+	if err := app.db.SaveAPIToken(&t, sum[:]); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditAPIToken(user, "add_api_token", t)
+
+	t.Token = token
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, t)
+}
+
+// pendingAPIToken is an API token for another user waiting for approval,
+// with the hash of its secret.
+type pendingAPIToken struct {
+	scan.APIToken
+	Hash []byte `json:"hash"`
+}
+
+// Handler for DELETE /api/v1/tokens/{id}
+func (app *App) deleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+	// A tenant's users can only revoke their own tokens
+	if user.Tenant != "" {
+		tokens, err := app.db.LoadAPITokens()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var own bool
+		for _, t := range ownAPITokens(user, tokens) {
+			own = own || t.ID == id
+		}
+		if !own {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+	}
+	err = app.db.DeleteAPIToken(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditAPIToken(user, "delete_api_token", scan.APIToken{ID: id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
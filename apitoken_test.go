@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestAPITokens(t *testing.T) {
+	db := createDB("TestAPITokens")
+	defer db.Close()
+	app := App{db: db, jobTimeout: time.Hour}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{apiTokenAuth{app: &app}, staticAuth{user: &User{Email: "admin@example.com"}}}
+	do := func(token, method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var b bytes.Buffer
+		b.ReadFrom(res.Body)
+		return res.StatusCode, b.String()
+	}
+
+	tokens := make(map[string]scan.APIToken)
+	for _, tt := range []struct {
+		token string
+		code  int
+	}{
+		{`{"name": "scanner", "scope": "ingest", "cidrs": ["192.0.2.7/24"]}`, http.StatusCreated},
+		{`{"name": "dashboard", "scope": "read"}`, http.StatusCreated},
+		{`{"name": "web", "scope": "read", "cidrs": ["192.0.2.0/24"]}`, http.StatusCreated},
+		{`{"name": "automation", "scope": "admin"}`, http.StatusCreated},
+		{`{"name": "everything", "scope": "admin", "cidrs": ["192.0.2.0/24"]}`, http.StatusBadRequest},
+		{`{"name": "unknown", "scope": "write"}`, http.StatusBadRequest},
+		{`{"name": "bad", "scope": "read", "cidrs": ["192.0.2.0/33"]}`, http.StatusBadRequest},
+	} {
+		code, body := do("", "POST", "/api/v1/tokens", tt.token)
+		if code != tt.code {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.token, tt.code, code, body)
+			continue
+		}
+		var tok scan.APIToken
+		json.Unmarshal([]byte(body), &tok)
+		if code == http.StatusCreated {
+			if tok.Token == "" || tok.Email != "admin@example.com" || tok.CreatedBy != "admin@example.com" {
+				t.Errorf("%s: expected a token for the creator, got %s", tt.token, body)
+			}
+			tokens[tok.Name] = tok
+		}
+	}
+	if got := tokens["scanner"].CIDRs; len(got) != 1 || got[0] != "192.0.2.0/24" {
+		t.Errorf("expected the CIDR tidied, got %v", got)
+	}
+	code, body := do("", "GET", "/api/v1/tokens", "")
+	if code != http.StatusOK || strings.Contains(body, tokens["scanner"].Token) || strings.Contains(body, `"token"`) {
+		t.Errorf("expected the tokens listed without secrets, got %d %s", code, body)
+	}
+
+	for _, tt := range []struct {
+		token, method, path string
+		code                int
+	}{
+		{"scanner", "GET", "/api/v1/results", http.StatusForbidden},
+		{"scanner", "GET", "/jobs", http.StatusOK},
+		{"scanner", "POST", "/jobs/claim", http.StatusBadRequest}, // no X-Agent
+		{"scanner", "DELETE", "/api/v1/tags/192.0.2.1/web", http.StatusForbidden},
+		{"scanner", "POST", "/api/v1/tokens", http.StatusForbidden},
+		{"dashboard", "GET", "/api/v1/networks", http.StatusOK},
+		{"dashboard", "DELETE", "/api/v1/tags/192.0.2.1/web", http.StatusForbidden},
+		{"dashboard", "GET", "/api/v1/tokens", http.StatusForbidden},
+		{"web", "GET", "/api/v1/networks", http.StatusForbidden},
+		{"web", "GET", "/api/v1/targets", http.StatusForbidden},
+		{"web", "GET", "/host/192.0.2.1", http.StatusOK},
+		{"web", "GET", "/host/198.51.100.1", http.StatusNotFound},
+		{"automation", "GET", "/api/v1/tokens", http.StatusOK},
+	} {
+		if code, body := do(tokens[tt.token].Token, tt.method, tt.path, ""); code != tt.code {
+			t.Errorf("%s %s with %s token: expected status %d, got %d: %s", tt.method, tt.path, tt.token, tt.code, code, body)
+		}
+	}
+
+	// Restricted tokens only see results in their CIDRs
+	_, body = do(tokens["web"].Token, "GET", "/api/v1/results", "")
+	var got []scan.IPInfo
+	json.Unmarshal([]byte(body), &got)
+	if len(got) != 1 || got[0].IP != "192.0.2.1" {
+		t.Errorf("expected only results in the token's CIDRs, got %s", body)
+	}
+
+	// and results they submit outside them are dropped
+	submission := `[{"ip":"198.51.100.2","ports":[{"port":25,"proto":"tcp","status":"open"}]},{"ip":"192.0.2.2","ports":[{"port":25,"proto":"tcp","status":"open"}]}]`
+	if code, body := do(tokens["scanner"].Token, "POST", "/results", submission); code != http.StatusOK {
+		t.Errorf("expected status 200 submitting results, got %d %s", code, body)
+	}
+	_, body = do(tokens["dashboard"].Token, "GET", "/api/v1/results", "")
+	got = nil
+	json.Unmarshal([]byte(body), &got)
+	if len(got) != 3 {
+		t.Errorf("expected the result outside the token's CIDRs dropped, got %s", body)
+	}
+
+	// Revoked tokens no longer authenticate
+	app.authProviders = []authProvider{apiTokenAuth{app: &app}}
+	path := "/api/v1/tokens/" + strconv.FormatInt(tokens["dashboard"].ID, 10)
+	for _, code := range []int{http.StatusNoContent, http.StatusNotFound} {
+		if got, _ := do(tokens["automation"].Token, "DELETE", path, ""); got != code {
+			t.Errorf("expected status %d revoking token, got %d", code, got)
+		}
+	}
+	if code, _ := do(tokens["dashboard"].Token, "GET", "/api/v1/results", ""); code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 with a revoked token, got %d", code)
+	}
+}
+
+func TestAPITokensTenant(t *testing.T) {
+	db := createDB("TestAPITokensTenant")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	as := func(email string) {
+		app.authProviders = []authProvider{staticAuth{user: &User{Email: email}}}
+	}
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var b bytes.Buffer
+		b.ReadFrom(res.Body)
+		return res.StatusCode, b.String()
+	}
+
+	as("operator@example.com")
+	for _, tenant := range []string{
+		`{"name": "Web team", "cidrs": ["192.0.2.0/24"], "users": ["web@example.com", "deploy@example.com"]}`,
+		`{"name": "Mail team", "cidrs": ["198.51.100.0/24"], "users": ["mail@example.com"]}`,
+	} {
+		if code, body := do("POST", "/api/v1/tenants", tenant); code != http.StatusCreated {
+			t.Fatalf("expected status 201 adding tenant, got %d: %s", code, body)
+		}
+	}
+	code, body := do("POST", "/api/v1/tokens", `{"name": "operator", "scope": "read"}`)
+	var operator scan.APIToken
+	json.Unmarshal([]byte(body), &operator)
+	if code != http.StatusCreated {
+		t.Fatalf("expected status 201 creating the operator's token, got %d: %s", code, body)
+	}
+
+	// A tenant's users can create tokens for themselves in its address space
+	as("web@example.com")
+	for _, tt := range []struct {
+		token string
+		code  int
+	}{
+		{`{"name": "web", "scope": "ingest", "cidrs": ["192.0.2.0/25"]}`, http.StatusCreated},
+		{`{"name": "dashboard", "scope": "read"}`, http.StatusCreated},
+		{`{"name": "mail", "scope": "read", "cidrs": ["198.51.100.0/24"]}`, http.StatusForbidden},
+		{`{"name": "deploy", "scope": "ingest", "email": "deploy@example.com"}`, http.StatusForbidden},
+	} {
+		code, body := do("POST", "/api/v1/tokens", tt.token)
+		if code != tt.code {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.token, tt.code, code, body)
+		}
+	}
+
+	// Tokens for someone else need approval, and only for the tenant's users
+	app.approvals = true
+	for _, tt := range []struct {
+		token string
+		code  int
+	}{
+		{`{"name": "deploy", "scope": "ingest", "email": "deploy@example.com"}`, http.StatusAccepted},
+		{`{"name": "mail", "scope": "ingest", "email": "mail@example.com"}`, http.StatusForbidden},
+		{`{"name": "operator", "scope": "admin", "email": "operator@example.com"}`, http.StatusForbidden},
+	} {
+		code, body := do("POST", "/api/v1/tokens", tt.token)
+		if code != tt.code {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.token, tt.code, code, body)
+		}
+	}
+	if approvals, err := db.LoadApprovals(sqlite.SQLFilter{}); err != nil || len(approvals) != 1 || approvals[0].Action != "add_api_token" {
+		t.Errorf("expected the token for the other user waiting for approval, got %+v %v", approvals, err)
+	}
+
+	// They only see and revoke their own tokens
+	code, body = do("GET", "/api/v1/tokens", "")
+	var tokens []scan.APIToken
+	json.Unmarshal([]byte(body), &tokens)
+	if code != http.StatusOK || len(tokens) != 2 {
+		t.Fatalf("expected only the user's own tokens, got %d %s", code, body)
+	}
+	if code, _ := do("DELETE", "/api/v1/tokens/"+strconv.FormatInt(operator.ID, 10), ""); code != http.StatusNotFound {
+		t.Errorf("expected status 404 revoking the operator's token, got %d", code)
+	}
+	if code, _ := do("DELETE", "/api/v1/tokens/"+strconv.FormatInt(tokens[0].ID, 10), ""); code != http.StatusNoContent {
+		t.Errorf("expected status 204 revoking their own token, got %d", code)
+	}
+}
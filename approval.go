@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// approvalActions are the destructive or sensitive actions which need a
+// second admin's approval when -approvals is enabled. Each applies the action
+// described by its params and returns the info recorded in the audit log,
+// matching what's recorded when approvals are disabled.
+var approvalActions = map[string]func(app *App, params json.RawMessage) (string, error){
+	"delete_user": func(app *App, params json.RawMessage) (string, error) {
+		var p struct {
+			Email string `json:"email"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return "", err
+		}
+		return p.Email, app.db.DeleteUser(p.Email)
+	},
+	"delete_exemption": func(app *App, params json.RawMessage) (string, error) {
+		var e scan.Exemption
+		if err := json.Unmarshal(params, &e); err != nil {
+			return "", err
+		}
+		return portKey(e.IP, e.Port, e.Proto), app.db.DeleteExemption(e.IP, e.Port, e.Proto)
+	},
+	"purge_results": func(app *App, params json.RawMessage) (string, error) {
+		var p purge
+		if err := json.Unmarshal(params, &p); err != nil {
+			return "", err
+		}
+		return app.purgeResults(&p)
+	},
+	"delete_field": func(app *App, params json.RawMessage) (string, error) {
+		var f scan.Field
+		if err := json.Unmarshal(params, &f); err != nil {
+			return "", err
+		}
+		info, _ := json.Marshal(f)
+		return string(info), app.db.DeleteField(f.Name)
+	},
+	"add_api_token": func(app *App, params json.RawMessage) (string, error) {
+		var p pendingAPIToken
+		if err := json.Unmarshal(params, &p); err != nil {
+			return "", err
+		}
+		p.Created = scan.Time{Time: time.Now().UTC()}
+		if err := app.db.SaveAPIToken(&p.APIToken, p.Hash); err != nil {
+			return "", err
+		}
+		info, _ := json.Marshal(p.APIToken)
+		return string(info), nil
+	},
+}
+
+var (
+	approvalRequested    = "Another admin needs to approve this before it takes effect"
+	errApprovalRequested = errors.New("approval requested")
+)
+
+// requestApproval records that user wants to carry out action, to be applied
+// once another admin approves it. It returns errApprovalRequested if the
+// request was saved.
+func (app *App) requestApproval(user, action string, params interface{}) error {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	a := scan.Approval{
+		Action:      action,
+		Params:      b,
+		RequestedBy: user,
+		Requested:   scan.Time{Time: time.Now()},
+	}
+	id, err := app.db.SaveApproval(a)
+	if err != nil {
+		return err
+	}
+	app.auditApproval(user, "request_approval", id, action)
+	return errApprovalRequested
+}
+
+func (app *App) auditApproval(user, event string, id int64, action string) {
+	info := fmt.Sprintf("%d %s", id, action)
+	if err := app.audit(user, event, info); err != nil {
+		log.Printf("auditApproval: error saving %s for approval %d: %v", event, id, err)
+	}
+}
+
+// pendingApproval loads the approval with the ID in the URL, writing an error
+// response if there is no such approval or it's already been resolved.
+func (app *App) pendingApproval(w http.ResponseWriter, r *http.Request) (scan.Approval, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid approval ID", http.StatusBadRequest)
+		return scan.Approval{}, false
+	}
+	approvals, err := app.db.LoadApprovals(sqlite.SQLFilter{
+		Where:  []string{"id = ?", "resolved IS NULL"},
+		Values: []interface{}{id},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return scan.Approval{}, false
+	}
+	if len(approvals) == 0 {
+		http.Error(w, "Approval not found", http.StatusNotFound)
+		return scan.Approval{}, false
+	}
+	return approvals[0], true
+}
+
+// Handler for GET /api/v1/approvals
+// Only pending approvals are listed; resolved ones are in the audit log.
+func (app *App) listApprovals(w http.ResponseWriter, r *http.Request) {
+	approvals, err := app.db.LoadApprovals(sqlite.SQLFilter{Where: []string{"resolved IS NULL"}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, approvals)
+}
+
+// Handler for POST /api/v1/approvals/{id}
+// The action is carried out and attributed to the admin who requested it. The
+// approver must be someone else.
+func (app *App) approve(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	a, ok := app.pendingApproval(w, r)
+	if !ok {
+		return
+	}
+	if a.RequestedBy == user.Email {
+		http.Error(w, "Actions must be approved by another admin", http.StatusForbidden)
+		return
+	}
+	apply, ok := approvalActions[a.Action]
+	if !ok {
+		http.Error(w, "Unknown action "+a.Action, http.StatusInternalServerError)
+		return
+	}
+
+	// Resolve the approval first so concurrent approvals can't apply the
+	// action twice
+	err := app.db.ResolveApproval(a.ID, user.Email, true, time.Now())
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Approval not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditApproval(user.Email, "approve_approval", a.ID, a.Action)
+
+	info, err := apply(app, a.Params)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Nothing left to "+a.Action, http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := app.audit(a.RequestedBy, a.Action, info); err != nil {
+		log.Printf("approve: error saving %s for approval %d: %v", a.Action, a.ID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for DELETE /api/v1/approvals/{id}
+// Either admin can reject an action, so requesters can withdraw their own.
+func (app *App) reject(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	a, ok := app.pendingApproval(w, r)
+	if !ok {
+		return
+	}
+	err := app.db.ResolveApproval(a.ID, user.Email, false, time.Now())
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Approval not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditApproval(user.Email, "reject_approval", a.ID, a.Action)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestApprovals(t *testing.T) {
+	db := createDB("TestApprovals")
+	defer db.Close()
+
+	app := App{db: db, approvals: true}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	user := &User{Email: "admin@example.com"}
+	app.authProviders = []authProvider{staticAuth{user: user}}
+
+	for _, f := range []scan.Field{{Name: "scope"}, {Name: "owner"}} {
+		if err := db.SaveField(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	do := func(method, path string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, &bytes.Buffer{})
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	pending := func() []scan.Approval {
+		t.Helper()
+		res, err := http.Get(ts.URL + "/api/v1/approvals")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var approvals []scan.Approval
+		if err := json.NewDecoder(res.Body).Decode(&approvals); err != nil {
+			t.Fatal(err)
+		}
+		return approvals
+	}
+
+	if res := do("DELETE", "/api/v1/fields/missing"); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d deleting a missing field, got %d", http.StatusNotFound, res.StatusCode)
+	}
+	if res := do("DELETE", "/api/v1/fields/scope"); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", http.StatusAccepted, res.StatusCode)
+	}
+	if fields, _ := db.LoadFields(); len(fields) != 2 {
+		t.Fatalf("field deleted before approval: %+v", fields)
+	}
+
+	approvals := pending()
+	if len(approvals) != 1 || approvals[0].Action != "delete_field" || approvals[0].RequestedBy != user.Email {
+		t.Fatalf("unexpected approvals %+v", approvals)
+	}
+	path := fmt.Sprintf("/api/v1/approvals/%d", approvals[0].ID)
+
+	// The requester can't approve their own action
+	if res := do("POST", path); res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, res.StatusCode)
+	}
+
+	user.Email = "second@example.com"
+	if res := do("POST", path); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+	if fields, _ := db.LoadFields(); len(fields) != 1 || fields[0].Name != "owner" {
+		t.Errorf("field not deleted after approval: %+v", fields)
+	}
+	if res := do("POST", path); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d approving twice, got %d", http.StatusNotFound, res.StatusCode)
+	}
+	for _, event := range []string{"request_approval", "approve_approval", "delete_field"} {
+		if ts, err := db.LoadLastAudit(event); err != nil || ts.IsZero() {
+			t.Errorf("%s not audited: %v", event, err)
+		}
+	}
+
+	// Rejected actions aren't applied
+	do("DELETE", "/api/v1/fields/owner")
+	approvals = pending()
+	if len(approvals) != 1 {
+		t.Fatalf("unexpected approvals %+v", approvals)
+	}
+	if res := do("DELETE", fmt.Sprintf("/api/v1/approvals/%d", approvals[0].ID)); res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+	if fields, _ := db.LoadFields(); len(fields) != 1 {
+		t.Errorf("field deleted after rejection: %+v", fields)
+	}
+	if approvals := pending(); len(approvals) != 0 {
+		t.Errorf("expected no pending approvals, got %+v", approvals)
+	}
+}
+
+func TestApproveAPIToken(t *testing.T) {
+	db := createDB("TestApproveAPIToken")
+	defer db.Close()
+
+	app := App{db: db}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	user := &User{Email: "admin@example.com"}
+	app.authProviders = []authProvider{apiTokenAuth{app: &app}, staticAuth{user: user}}
+
+	create := func() (int, scan.APIToken) {
+		t.Helper()
+		body := `{"name": "other", "scope": "read", "email": "other@example.com"}`
+		res, err := http.Post(ts.URL+"/api/v1/tokens", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var tok scan.APIToken
+		json.NewDecoder(res.Body).Decode(&tok)
+		return res.StatusCode, tok
+	}
+
+	// Without approvals admins can only create tokens for themselves
+	if code, _ := create(); code != http.StatusForbidden {
+		t.Errorf("expected status %d creating a token for someone else, got %d", http.StatusForbidden, code)
+	}
+
+	app.approvals = true
+	code, tok := create()
+	if code != http.StatusAccepted || tok.Token == "" || tok.CreatedBy != user.Email {
+		t.Fatalf("expected a token waiting for approval, got %d %+v", code, tok)
+	}
+	if tokens, _ := db.LoadAPITokens(); len(tokens) != 0 {
+		t.Fatalf("token saved before approval: %+v", tokens)
+	}
+
+	approvals, err := db.LoadApprovals(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approvals) != 1 || approvals[0].Action != "add_api_token" {
+		t.Fatalf("unexpected approvals %+v", approvals)
+	}
+	user.Email = "second@example.com"
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/approvals/%d", ts.URL, approvals[0].ID), nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status %d approving, got %d", http.StatusNoContent, res.StatusCode)
+	}
+
+	// Once approved the token works, and records who created it
+	tokens, err := db.LoadAPITokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Email != "other@example.com" || tokens[0].CreatedBy != "admin@example.com" {
+		t.Errorf("unexpected tokens %+v", tokens)
+	}
+	app.authProviders = []authProvider{apiTokenAuth{app: &app}}
+	req, _ = http.NewRequest("GET", ts.URL+"/api/v1/networks", nil)
+	req.Header.Set("Authorization", "Bearer "+tok.Token)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected the approved token to authenticate, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// Handler for GET /api/v1/payloads
+func (app *App) payloads(w http.ResponseWriter, r *http.Request) {
+	payloads, err := app.db.LoadPayloads()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, payloads)
+}
+
+// Handler for GET /api/v1/payloads/{id}
+// The payload is served exactly as archived, gzip-compressed.
+func (app *App) payload(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid payload ID", http.StatusBadRequest)
+		return
+	}
+
+	payload, t, err := app.db.LoadPayload(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Payload not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="scan-%d-%s.json.gz"`, id, t.Format("20060102T150405Z")))
+	w.Write(payload)
+}
+
+// payloadArchive compresses a copy of everything written to it.
+type payloadArchive struct {
+	buf bytes.Buffer
+	gz  *gzip.Writer
+}
+
+func newPayloadArchive() *payloadArchive {
+	a := new(payloadArchive)
+	a.gz = gzip.NewWriter(&a.buf)
+	return a
+}
+
+func (a *payloadArchive) Write(p []byte) (int, error) {
+	return a.gz.Write(p)
+}
+
+// Bytes returns the compressed payload.
+func (a *payloadArchive) Bytes() ([]byte, error) {
+	if err := a.gz.Close(); err != nil {
+		return nil, err
+	}
+	return a.buf.Bytes(), nil
+}
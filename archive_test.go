@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestPayloadArchive(t *testing.T) {
+	db := createDB("TestPayloadArchive")
+	defer db.Close()
+	app := App{db: db, archivePayloads: true}
+
+	data := `[{"ip":"192.0.2.1","ports":[{"port":80,"proto":"tcp","status":"open","reason":"syn-ack","ttl":57}]}]` + "\n"
+
+	// Two scanners submitting in the same second each get their own payload
+	for _, host := range []string{"192.0.2.100", "192.0.2.101"} {
+		r := httptest.NewRequest("POST", "/results", bytes.NewBufferString(data))
+		r.Header.Set("Content-Type", "application/json")
+		r.RemoteAddr = host + ":1234"
+		w := httptest.NewRecorder()
+		app.recvResults(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body)
+		}
+	}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/api/v1/payloads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payloads []scan.Payload
+	if err := json.NewDecoder(res.Body).Decode(&payloads); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(payloads) != 2 {
+		t.Fatalf("expected 2 payloads, got %d: %+v", len(payloads), payloads)
+	}
+	if payloads[0].Host != "192.0.2.101" || payloads[1].Host != "192.0.2.100" {
+		t.Errorf("expected hosts 192.0.2.101 and 192.0.2.100, got %q and %q", payloads[0].Host, payloads[1].Host)
+	}
+
+	res, err = http.Get(fmt.Sprintf("%s/api/v1/payloads/%d", ts.URL, payloads[0].ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("expected Content-Type application/gzip, got %q", ct)
+	}
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data {
+		t.Errorf("expected archived payload %q, got %q", data, got)
+	}
+
+	res, err = http.Get(ts.URL + "/api/v1/payloads/99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown payload, got %v", res.StatusCode)
+	}
+}
@@ -1,8 +1,95 @@
 package main
 
-import "time"
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+)
 
 // audit logs events to the audit table
 func (app *App) audit(user, event, info string) error {
-	return app.db.SaveAudit(time.Now(), user, event, info)
+	return app.db.SaveAudit(time.Now().UTC(), user, event, info)
+}
+
+// ingestAudit is what's recorded about a results submission.
+type ingestAudit struct {
+	Host   string `json:"host"`
+	Agent  string `json:"agent,omitempty"`
+	Job    int64  `json:"job,omitempty"`
+	Ports  int64  `json:"ports"`
+	Queued bool   `json:"queued,omitempty"`
+}
+
+// auditIngest records a results submission in the audit log, attributed to
+// the user who made it, if any.
+func (app *App) auditIngest(r *http.Request, a ingestAudit) {
+	var email string
+	if u := currentUser(r); u != nil {
+		email = u.Email
+	}
+	info, _ := json.Marshal(a)
+	if err := app.audit(email, "ingest_results", string(info)); err != nil {
+		log.Printf("auditIngest: error saving submission from %s: %v", a.Host, err)
+	}
+}
+
+// Handler for GET /api/v1/audit
+// The log can be filtered by user and action, and to entries between from
+// and to. It's returned a page at a time, newest first, or all at once with
+// format=csv.
+func (app *App) listAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var filter sqlite.SQLFilter
+	for _, param := range []string{"user", "action"} {
+		if v := q.Get(param); v != "" {
+			filter.Where = append(filter.Where, param+" = ?")
+			filter.Values = append(filter.Values, v)
+		}
+	}
+	for param, cond := range map[string]string{"from": "time >= ?", "to": "time <= ?"} {
+		s := q.Get(param)
+		if s == "" {
+			continue
+		}
+		t, err := parseTime(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Where = append(filter.Where, cond)
+		filter.Values = append(filter.Values, t)
+	}
+	csvFormat := q.Get("format") == "csv"
+	if !csvFormat {
+		page, perPage := pageParams(q)
+		filter.Limit, filter.Offset = perPage, (page-1)*perPage
+	}
+
+	entries, err := app.db.LoadAudit(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !csvFormat {
+		render.JSON(w, r, entries)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "time", "user", "action", "info"})
+	for _, e := range entries {
+		cw.Write([]string{strconv.FormatInt(e.ID, 10), e.Time.UTC().Format(time.RFC3339), e.User, e.Action, e.Info})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Println("listAudit: error writing CSV:", err)
+	}
 }
@@ -1,6 +1,17 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
 
 func TestAudit(t *testing.T) {
 	db := createDB("TestAudit")
@@ -10,3 +21,86 @@ func TestAudit(t *testing.T) {
 		t.Errorf("couldn't write audit log: %v", err)
 	}
 }
+
+func TestAuditLog(t *testing.T) {
+	db := createDB("TestAuditLog")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "scanner@example.com"}}}
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Agent", "dmz")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var b bytes.Buffer
+		b.ReadFrom(res.Body)
+		return res.StatusCode, b.String()
+	}
+
+	start := time.Now().UTC().Add(-time.Second)
+	submission := `[{"ip":"192.0.2.1","ports":[{"port":22,"proto":"tcp","status":"open"}]},{"ip":"192.0.2.1","ports":[{"port":80,"proto":"tcp","status":"open"}]}]`
+	if code, body := do("POST", "/results", submission); code != http.StatusOK {
+		t.Fatalf("expected status 200 submitting results, got %d %s", code, body)
+	}
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "admin@example.com"}}}
+	if code, body := do("POST", "/api/v1/tags", `{"ip": "192.0.2.1", "name": "web"}`); code != http.StatusCreated {
+		t.Fatalf("expected status 201 adding tag, got %d %s", code, body)
+	}
+
+	var entries []scan.AuditEntry
+	code, body := do("GET", "/api/v1/audit", "")
+	json.Unmarshal([]byte(body), &entries)
+	if code != http.StatusOK || len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries, got %d %s", code, body)
+	}
+	if e := entries[0]; e.Action != "add_tag" || e.User != "admin@example.com" {
+		t.Errorf("expected the newest entry first, got %+v", e)
+	}
+	var ingest ingestAudit
+	json.Unmarshal([]byte(entries[1].Info), &ingest)
+	if e := entries[1]; e.Action != "ingest_results" || e.User != "scanner@example.com" || ingest.Ports != 2 || ingest.Agent != "dmz" {
+		t.Errorf("expected the submission recorded, got %+v", e)
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  int
+	}{
+		{"?user=scanner@example.com", 1},
+		{"?action=add_tag", 1},
+		{"?action=delete_tag", 0},
+		{"?from=" + start.Format(time.RFC3339), 2},
+		{"?to=" + start.Format(time.RFC3339), 0},
+		{"?per_page=1&page=2", 1},
+	} {
+		entries = nil
+		_, body := do("GET", "/api/v1/audit"+tt.query, "")
+		json.Unmarshal([]byte(body), &entries)
+		if len(entries) != tt.want {
+			t.Errorf("%s: expected %d entries, got %s", tt.query, tt.want, body)
+		}
+	}
+	if code, _ := do("GET", "/api/v1/audit?from=yesterday", ""); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid time, got %d", code)
+	}
+
+	_, body = do("GET", "/api/v1/audit?format=csv", "")
+	rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][3] != "action" || rows[1][3] != "add_tag" {
+		t.Errorf("unexpected CSV %q", rows)
+	}
+}
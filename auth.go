@@ -21,7 +21,7 @@ import (
 
 var conf *oauth2.Config
 
-var store *sessions.CookieStore
+var store sessions.Store
 
 // User is a Google user
 type User struct {
@@ -30,6 +30,14 @@ type User struct {
 	FamilyName string `json:"family_name"`
 	Email      string `json:"email"`
 	Picture    string `json:"picture"`
+	// Tenant is the tenant the user belongs to, if any. It's looked up on
+	// each request rather than kept in the session.
+	Tenant string `json:"-"`
+	// Token, Scope and CIDRs are the name, scope and CIDRs of the API token
+	// the user authenticated with, if any
+	Token string   `json:"-"`
+	Scope string   `json:"-"`
+	CIDRs []string `json:"-"`
 }
 
 // GroupMember defines whether the user is a member of a group
@@ -42,21 +50,60 @@ func init() {
 	gob.Register(User{})
 }
 
-func oauthConfig() {
-	keyFile := filepath.Join(dataDir, ".cookie_key")
-	if key, err := ioutil.ReadFile(keyFile); err == nil {
-		store = sessions.NewCookieStore(key)
-	} else {
-		// TODO(jamesog): Add a second parameter for encryption
-		// This makes it more complicated to write to the cache file
-		// It should probably be saved in the database instead
-		key := securecookie.GenerateRandomKey(64)
-		err := ioutil.WriteFile(keyFile, key, 0600)
+// decodeSessionKey decodes a base64 session key, which must be at least 32
+// bytes.
+func decodeSessionKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < 32 {
+		return nil, fmt.Errorf("key is %d bytes, need at least 32", len(key))
+	}
+	return key, nil
+}
+
+// sessionConfig sets up the store for login sessions, with a key kept in the
+// data directory so sessions survive restarts. Without kv, sessions are kept
+// entirely in the cookie, so replicas given the same -session.key can serve
+// any user. With kv, such as Redis shared by the replicas, the cookie only
+// holds the session's ID.
+func sessionConfig(kv kvStore) {
+	key := sessionCookieKey()
+	if kv != nil {
+		store = newKVSessionStore(kv, key)
+		return
+	}
+	store = sessions.NewCookieStore(key)
+}
+
+// sessionCookieKey returns the key for signing session cookies, from
+// -session.key or the data directory.
+func sessionCookieKey() []byte {
+	if sessionKey != "" {
+		key, err := decodeSessionKey(sessionKey)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("invalid -session.key: %v", err)
 		}
-		store = sessions.NewCookieStore(key)
+		return key
+	}
+	keyFile := filepath.Join(dataDir, ".cookie_key")
+	if key, err := ioutil.ReadFile(keyFile); err == nil {
+		return key
 	}
+	// TODO(jamesog): Add a second parameter for encryption
+	// This makes it more complicated to write to the cache file
+	// It should probably be saved in the database instead
+	key := securecookie.GenerateRandomKey(64)
+	err := ioutil.WriteFile(keyFile, key, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return key
+}
+
+func oauthConfig(kv kvStore) {
+	sessionConfig(kv)
 
 	f, err := ioutil.ReadFile(credsFile)
 	if err != nil {
@@ -86,6 +133,10 @@ func randToken() string {
 
 // loginHandler is just a redirect to the Google login page
 func (app *App) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if conf == nil {
+		http.Error(w, "No login page is configured; log in with the authenticating proxy or use an API token", http.StatusNotFound)
+		return
+	}
 	tok := randToken()
 	state, err := store.Get(r, "state")
 	if err != nil {
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// errNoCredentials is returned by an authProvider when the request doesn't
+// carry any credentials it understands, so the next provider should be tried.
+var errNoCredentials = errors.New("no credentials")
+
+// authProvider identifies the user making a request. Providers are tried in
+// order, so new mechanisms can be added without changing the handlers.
+type authProvider interface {
+	authenticate(r *http.Request) (*User, error)
+}
+
+type userKey struct{}
+
+// authenticate is a middleware which identifies the user with the first
+// provider which recognises the request's credentials and stores the user,
+// with their tenant, in the request context. Requests with invalid
+// credentials are rejected.
+func (app *App) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range app.authProviders {
+			user, err := p.authenticate(r)
+			if err == errNoCredentials {
+				continue
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			// Look the tenant up each time, so moving a user between
+			// tenants takes effect straight away
+			if app.db != nil {
+				if user.Tenant, err = app.db.UserTenant(user.Email); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			r = r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+			break
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireUser is a middleware which rejects requests without an
+// authenticated user, in the same way the HTML pages only show results to
+// logged in users. Every request is allowed when authentication is disabled.
+func requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authDisabled && currentUser(r) == nil {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loginPage is a middleware for HTML pages which shows the login page to
+// requests without an authenticated user, rather than refusing them like
+// requireUser.
+func loginPage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authDisabled && currentUser(r) == nil {
+			tmpl.ExecuteTemplate(w, "index", loginData(w, r))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// currentUser returns the user authenticated for the request, or nil if
+// there isn't one.
+func currentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userKey{}).(*User)
+	return user
+}
+
+// loginData is the page data for prompting the user to log in, including any
+// message explaining why an earlier login failed.
+func loginData(w http.ResponseWriter, r *http.Request) indexData {
+	data := indexData{URI: r.RequestURI}
+	if store == nil {
+		return data
+	}
+	session, err := store.Get(r, "user")
+	if err != nil {
+		return data
+	}
+	if flash := session.Flashes("unauth_flash"); len(flash) > 0 {
+		data.NotAuth = flash[0].(string)
+		session.Save(r, w)
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	return data
+}
+
+// authorised reports whether a user authenticated elsewhere, such as by LDAP
+// or a proxy, may log in. Like Google logins, they must either be in the
+// users table or a member of one of the groups in the groups table.
+func (app *App) authorised(user *User, groups []string) (bool, error) {
+	ok, err := app.validateUser(user)
+	if err != nil || ok {
+		return ok, err
+	}
+	allowed, err := app.db.LoadGroups()
+	if err != nil {
+		return false, err
+	}
+	for _, a := range allowed {
+		for _, g := range groups {
+			if strings.EqualFold(a, g) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// sessionAuth authenticates users who have logged in with Google.
+type sessionAuth struct{}
+
+func (sessionAuth) authenticate(r *http.Request) (*User, error) {
+	session, err := store.Get(r, "user")
+	if err != nil {
+		return nil, errNoCredentials
+	}
+	switch v := session.Values["user"].(type) {
+	case string:
+		return &User{Email: v}, nil
+	case User:
+		return &v, nil
+	}
+	return nil, errNoCredentials
+}
+
+// tokenAuth authenticates requests with a static bearer token in the
+// Authorization header. Tokens are stored hashed and mapped to the email
+// address of the user they belong to.
+type tokenAuth map[[sha256.Size]byte]string
+
+// loadTokens reads static tokens from a file. Each line holds a token and
+// the email address it authenticates as, separated by whitespace. Blank
+// lines and lines starting with # are ignored.
+func loadTokens(path string) (tokenAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := make(tokenAuth)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected token and email", path, n)
+		}
+		tokens[sha256.Sum256([]byte(fields[0]))] = fields[1]
+	}
+	return tokens, s.Err()
+}
+
+// generateToken returns a new random token.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// addToken generates a new token for email and appends it to the tokens file,
+// creating it if needed.
+func addToken(path, email string) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s\n", token, email); err != nil {
+		f.Close()
+		return "", err
+	}
+	return token, f.Close()
+}
+
+// bearerToken returns the bearer token from the Authorization header, if
+// there is one.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(prefix):]), true
+}
+
+func (t tokenAuth) authenticate(r *http.Request) (*User, error) {
+	tok, ok := bearerToken(r)
+	if !ok {
+		return nil, errNoCredentials
+	}
+	sum := sha256.Sum256([]byte(tok))
+	for hash, email := range t {
+		if subtle.ConstantTimeCompare(hash[:], sum[:]) == 1 {
+			return &User{Email: email}, nil
+		}
+	}
+	return nil, errors.New("invalid token")
+}
+
+type peerKey struct{}
+
+// rememberPeer is a middleware which stores the address of the connection's
+// peer in the request context, before RealIP replaces RemoteAddr with an
+// address taken from the request headers.
+func rememberPeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), peerKey{}, r.RemoteAddr)))
+	})
+}
+
+// peerIP returns the IP of the connection's peer.
+func peerIP(r *http.Request) net.IP {
+	addr, ok := r.Context().Value(peerKey{}).(string)
+	if !ok {
+		addr = r.RemoteAddr
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
+
+// headerAuth trusts the username set in a header by an authenticating
+// reverse proxy, such as oauth2-proxy or Authelia. The header is only
+// trusted from the proxies' addresses, so clients can't set it themselves.
+type headerAuth struct {
+	app     *App
+	header  string
+	groups  string // header listing the user's groups, comma-separated
+	proxies []*net.IPNet
+}
+
+// newHeaderAuth creates a headerAuth trusting proxies, a comma-separated
+// list of IPs and CIDRs.
+func newHeaderAuth(app *App, header, groups, proxies string) (*headerAuth, error) {
+	h := &headerAuth{app: app, header: header, groups: groups}
+	for _, p := range strings.Split(proxies, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		h.proxies = append(h.proxies, network)
+	}
+	if len(h.proxies) == 0 {
+		return nil, errors.New("no trusted proxies")
+	}
+	return h, nil
+}
+
+func (h *headerAuth) trusted(ip net.IP) bool {
+	for _, network := range h.proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *headerAuth) authenticate(r *http.Request) (*User, error) {
+	name := strings.TrimSpace(r.Header.Get(h.header))
+	if name == "" {
+		return nil, errNoCredentials
+	}
+	if !h.trusted(peerIP(r)) {
+		return nil, fmt.Errorf("%s header not accepted from this address", h.header)
+	}
+
+	user := &User{Email: name}
+	var groups []string
+	if h.groups != "" {
+		for _, g := range strings.Split(r.Header.Get(h.groups), ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				groups = append(groups, g)
+			}
+		}
+	}
+	ok, err := h.app.authorised(user, groups)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s is not authorised", name)
+	}
+	return user, nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTokens(t *testing.T, contents string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "tokens")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTokenAuth(t *testing.T) {
+	tokens, err := loadTokens(writeTokens(t, "# API tokens\n\nsecret1 ci@example.com\n  secret2   ops@example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		email  string
+		err    error
+	}{
+		{"Valid", "Bearer secret1", "ci@example.com", nil},
+		{"ValidCaseInsensitive", "bearer secret2", "ops@example.com", nil},
+		{"Invalid", "Bearer wrong", "", errors.New("invalid token")},
+		{"Missing", "", "", errNoCredentials},
+		{"OtherScheme", "Basic dXNlcjpwYXNz", "", errNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			user, err := tokens.authenticate(r)
+			switch {
+			case tt.err == errNoCredentials && err != errNoCredentials:
+				t.Fatalf("expected errNoCredentials, got %v", err)
+			case tt.err != nil && err == nil:
+				t.Fatalf("expected error %v, got user %+v", tt.err, user)
+			case tt.err == nil && err != nil:
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.err == nil && user.Email != tt.email {
+				t.Errorf("expected %s, got %s", tt.email, user.Email)
+			}
+		})
+	}
+}
+
+func TestLoadTokensErrors(t *testing.T) {
+	for _, contents := range []string{
+		"secret-without-email\n",
+		"secret email@example.com extra\n",
+	} {
+		if _, err := loadTokens(writeTokens(t, contents)); err == nil {
+			t.Errorf("expected error loading %q", contents)
+		}
+	}
+	if _, err := loadTokens(filepath.Join(os.TempDir(), "does-not-exist")); err == nil {
+		t.Error("expected error loading missing file")
+	}
+}
+
+// staticAuth is a provider which always returns the same result.
+type staticAuth struct {
+	user *User
+	err  error
+}
+
+func (s staticAuth) authenticate(r *http.Request) (*User, error) { return s.user, s.err }
+
+func TestAuthProviderOrder(t *testing.T) {
+	first := &User{Email: "first@example.com"}
+	second := &User{Email: "second@example.com"}
+
+	tests := []struct {
+		name      string
+		providers []authProvider
+		code      int
+		email     string
+	}{
+		{"FirstWins", []authProvider{staticAuth{user: first}, staticAuth{user: second}}, http.StatusOK, "first@example.com"},
+		{"SkipNoCredentials", []authProvider{staticAuth{err: errNoCredentials}, staticAuth{user: second}}, http.StatusOK, "second@example.com"},
+		{"InvalidStops", []authProvider{staticAuth{err: errors.New("bad")}, staticAuth{user: second}}, http.StatusUnauthorized, ""},
+		{"NoneMatch", []authProvider{staticAuth{err: errNoCredentials}}, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := App{authProviders: tt.providers}
+			var email string
+			h := app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if u := currentUser(r); u != nil {
+					email = u.Email
+				}
+			}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if w.Code != tt.code {
+				t.Errorf("expected status %d, got %d", tt.code, w.Code)
+			}
+			if email != tt.email {
+				t.Errorf("expected user %q, got %q", tt.email, email)
+			}
+		})
+	}
+}
+
+func TestAPIRequiresUser(t *testing.T) {
+	db := createDB("TestAPIRequiresUser")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+
+	paths := []string{"/api/v1/closed", "/api/v1/payloads", "/api/v1/uptime", "/api/v1/reputation"}
+	for _, p := range paths {
+		res, err := http.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: expected status 401 without a user, got %d", p, res.StatusCode)
+		}
+	}
+
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "user@example.com"}}}
+	for _, p := range paths {
+		res, err := http.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected status 200 with a user, got %d", p, res.StatusCode)
+		}
+	}
+}
+
+func TestOperatorsOnlyRequiresUser(t *testing.T) {
+	db := createDB("TestOperatorsOnlyRequiresUser")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+
+	for _, req := range [][2]string{
+		{"POST", "/pdns"},
+		{"POST", "/traceroute"},
+		{"GET", "/traceroute/192.0.2.1"},
+		{"POST", "/host/192.0.2.1/tags"},
+		{"POST", "/searches"},
+	} {
+		r, _ := http.NewRequest(req[0], ts.URL+req[1], strings.NewReader(`[]`))
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected status 401 without a user, got %d", req[0], req[1], res.StatusCode)
+		}
+	}
+
+	// Pages show the login page instead
+	res, err := http.Get(ts.URL + "/agents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || strings.Contains(string(body), "<th>Agent</th>") {
+		t.Errorf("expected the login page, got %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestHeaderAuth(t *testing.T) {
+	db := createDB("TestHeaderAuth")
+	defer db.Close()
+	app := &App{db: db}
+	if err := db.SaveUser("alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO groups (group_name) VALUES ('scan-users')`); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := newHeaderAuth(app, "X-Remote-User", "X-Remote-Groups", "127.0.0.1, 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newHeaderAuth(app, "X-Remote-User", "", ""); err == nil {
+		t.Error("expected an error without trusted proxies")
+	}
+
+	tests := []struct {
+		peer   string
+		user   string
+		groups string
+		want   string
+		err    bool
+	}{
+		{"127.0.0.1:1234", "", "", "", false},
+		{"127.0.0.1:1234", "alice@example.com", "", "alice@example.com", false},
+		{"10.1.2.3:1234", "bob@example.com", "admins, scan-users", "bob@example.com", false},
+		{"10.1.2.3:1234", "bob@example.com", "admins", "", true},
+		{"192.0.2.1:1234", "alice@example.com", "", "", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.peer
+		if tt.user != "" {
+			r.Header.Set("X-Remote-User", tt.user)
+		}
+		r.Header.Set("X-Remote-Groups", tt.groups)
+
+		user, err := h.authenticate(r)
+		switch {
+		case tt.user == "":
+			if err != errNoCredentials {
+				t.Errorf("expected errNoCredentials without a header, got %v", err)
+			}
+		case tt.err:
+			if err == nil {
+				t.Errorf("%s from %s: expected an error", tt.user, tt.peer)
+			}
+		case err != nil:
+			t.Errorf("%s from %s: unexpected error %v", tt.user, tt.peer, err)
+		case user.Email != tt.want:
+			t.Errorf("%s from %s: expected user %s, got %s", tt.user, tt.peer, tt.want, user.Email)
+		}
+	}
+
+	// RealIP mustn't let a client pretend to be the proxy
+	h, err = newHeaderAuth(app, "X-Remote-User", "", "10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.authProviders = []authProvider{h}
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+	req, _ := http.NewRequest("POST", ts.URL+"/api/v1/acks", nil)
+	req.Header.Set("X-Remote-User", "alice@example.com")
+	req.Header.Set("X-Forwarded-For", "10.1.2.3")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for a spoofed proxy address, got %d", res.StatusCode)
+	}
+}
+
+func TestDecodeSessionKey(t *testing.T) {
+	for _, tt := range []struct {
+		key string
+		ok  bool
+	}{
+		{"MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=", true},
+		{"c2hvcnQ=", false},
+		{"not base64!", false},
+	} {
+		if _, err := decodeSessionKey(tt.key); (err == nil) != tt.ok {
+			t.Errorf("decodeSessionKey(%q): unexpected error %v", tt.key, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// checkBaselineEntry validates a baseline entry and tidies its CIDR and
+// ports.
+func checkBaselineEntry(b *scan.BaselineEntry) error {
+	b.CIDR = strings.TrimSpace(b.CIDR)
+	b.Ports = strings.ReplaceAll(b.Ports, " ", "")
+	if b.CIDR == "" && b.Ports == "" {
+		return errors.New("a baseline entry needs a CIDR, ports or both")
+	}
+	if b.CIDR != "" {
+		_, network, err := net.ParseCIDR(b.CIDR)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", b.CIDR)
+		}
+		b.CIDR = network.String()
+	}
+	if b.Ports != "" {
+		if _, err := scan.ParsePortSpec(b.Ports); err != nil {
+			return err
+		}
+	}
+	switch b.Proto {
+	case "", "tcp", "udp":
+	default:
+		return fmt.Errorf("invalid proto %q", b.Proto)
+	}
+	return nil
+}
+
+// auditBaseline records a change to the baseline in the audit log.
+func (app *App) auditBaseline(user *User, event string, b scan.BaselineEntry) {
+	info, _ := json.Marshal(b)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditBaseline: error saving %s: %v", event, err)
+	}
+}
+
+// Handler for GET /api/v1/baseline
+func (app *App) listBaseline(w http.ResponseWriter, r *http.Request) {
+	baseline, err := app.db.LoadBaseline()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, baseline)
+}
+
+// Handler for POST /api/v1/baseline
+func (app *App) newBaselineEntry(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var b scan.BaselineEntry
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkBaselineEntry(&b); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b.User = user.Email
+	b.Created = scan.Time{Time: time.Now().UTC()}
+	id, err := app.db.SaveBaselineEntry(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b.ID = id
+	app.auditBaseline(user, "add_baseline", b)
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, b)
+}
+
+// Handler for DELETE /api/v1/baseline/{id}
+func (app *App) deleteBaselineEntry(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid baseline entry ID", http.StatusBadRequest)
+		return
+	}
+	err = app.db.DeleteBaselineEntry(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Baseline entry not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditBaseline(user, "delete_baseline", scan.BaselineEntry{ID: id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for GET /api/v1/baseline/violations
+// This lists the open ports outside the baseline.
+func (app *App) baselineViolations(w http.ResponseWriter, r *http.Request) {
+	data, _, err := app.db.ResultPage(sqlite.ResultQuery{Violations: true})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	results := data.Results
+	if results == nil {
+		results = []scan.IPInfo{}
+	}
+	render.JSON(w, r, results)
+}
+
+// violationEvents returns a violation event for each of the ports added at
+// now which is outside the baseline.
+func (app *App) violationEvents(now time.Time, added []scan.IPInfo) []event {
+	if len(added) == 0 {
+		return nil
+	}
+	baseline, err := app.db.LoadBaseline()
+	if err != nil {
+		log.Println("violationEvents: error loading baseline:", err)
+		return nil
+	}
+	var events []event
+	for _, r := range added {
+		if scan.Violates(baseline, r.IP, r.Port, r.Proto) {
+			events = append(events, event{Type: eventViolation, Time: now, IP: r.IP, Port: r.Port, Proto: r.Proto})
+		}
+	}
+	return events
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestBaseline(t *testing.T) {
+	db := createDB("TestBaseline")
+	defer db.Close()
+
+	webhook := testNotifier{"webhook", make(chan event, 10)}
+	app := App{db: db, notifiers: []notifier{webhook}}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing violates an empty baseline
+	if events := app.violationEvents(time.Now(), []scan.IPInfo{{IP: "192.0.2.1", Port: 22, Proto: "tcp"}}); len(events) != 0 {
+		t.Errorf("expected no violations without a baseline, got %+v", events)
+	}
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "user@example.com"}}}
+
+	var webEntry int64
+	for _, tt := range []struct {
+		entry string
+		code  int
+	}{
+		{`{"ports": "80,443", "proto": "tcp", "reason": "Web servers"}`, http.StatusCreated},
+		{`{"cidr": "198.51.100.0/24", "ports": "22", "reason": "Bastions"}`, http.StatusCreated},
+		{`{"reason": "Everything"}`, http.StatusBadRequest},
+		{`{"cidr": "198.51.100.0/24", "proto": "icmp"}`, http.StatusBadRequest},
+	} {
+		res, err := http.Post(ts.URL+"/api/v1/baseline", "application/json", bytes.NewBufferString(tt.entry))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.entry, tt.code, res.StatusCode)
+		}
+		if res.StatusCode == http.StatusCreated && webEntry == 0 {
+			var b scan.BaselineEntry
+			if err := json.NewDecoder(res.Body).Decode(&b); err != nil {
+				t.Fatal(err)
+			}
+			webEntry = b.ID
+		}
+		res.Body.Close()
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/baseline/violations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var violations []scan.IPInfo
+	if err := json.NewDecoder(res.Body).Decode(&violations); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(violations) != 1 || violations[0].Port != 22 || !violations[0].Violation {
+		t.Errorf("expected 22/tcp to violate the baseline, got %+v", violations)
+	}
+
+	// New ports outside the baseline are alerted on as violations
+	now := time.Now().UTC().Add(time.Minute)
+	added := []scan.IPInfo{
+		{IP: "198.51.100.1", Port: 22, Proto: "tcp"},
+		{IP: "198.51.100.1", Port: 3389, Proto: "tcp"},
+	}
+	app.notifyChanges(now, added, nil)
+	got := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-webhook.events:
+			got[e.Type+" "+portKey(e.IP, e.Port, e.Proto)]++
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+	}
+	if got["baseline_violation 198.51.100.1:3389/tcp"] != 1 || got["new_port 198.51.100.1:22/tcp"] != 1 {
+		t.Errorf("expected a violation for 3389/tcp only, got %v", got)
+	}
+	if msg, _ := message("en", event{Type: eventViolation, IP: "198.51.100.1", Port: 3389, Proto: "tcp"}); msg != "Port 3389/tcp open on 198.51.100.1 is outside the baseline" {
+		t.Errorf("unexpected violation message %q", msg)
+	}
+
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/api/v1/baseline/%d", ts.URL, webEntry), nil)
+	for _, code := range []int{http.StatusNoContent, http.StatusNotFound} {
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("expected status %d deleting baseline entry, got %d", code, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// eventSummary replaces a batch of events which is too big to send one by
+// one.
+const eventSummary = "summary"
+
+// batchKey identifies the events for one notifier matched by one alert rule.
+// Events which matched no rule have a zero rule ID.
+type batchKey struct {
+	notifier string
+	rule     int64
+}
+
+// batch holds events waiting to be sent.
+type batch struct {
+	events   []event
+	seen     map[string]bool
+	due      time.Time // when the events are sent
+	throttle time.Duration
+	last     time.Time // when the batch was last sent
+}
+
+// batcher collects events into batches so a large scan doesn't send a message
+// per port. Events are held for the batch window, and for alert rules with a
+// throttle, until the throttle has passed since the rule's previous message.
+// When a batch is sent with more than max events, they're replaced with a
+// single summary event.
+type batcher struct {
+	window time.Duration
+	max    int
+
+	mu      sync.Mutex
+	batches map[batchKey]*batch
+}
+
+func newBatcher(window time.Duration, max int) *batcher {
+	return &batcher{window: window, max: max, batches: make(map[batchKey]*batch)}
+}
+
+// add queues an event for a notifier. rule is the alert rule the event
+// matched, or nil. Repeats of an event already waiting to be sent are
+// dropped.
+func (b *batcher) add(now time.Time, notifier string, rule *alertRule, e event) {
+	key := batchKey{notifier: notifier}
+	var throttle time.Duration
+	if rule != nil {
+		key.rule = rule.ID
+		throttle = time.Duration(rule.Throttle) * time.Second
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bt, ok := b.batches[key]
+	if !ok {
+		bt = &batch{}
+		b.batches[key] = bt
+	}
+	bt.throttle = throttle
+	if len(bt.events) == 0 {
+		bt.seen = make(map[string]bool)
+		bt.due = now.Add(b.window)
+		if next := bt.last.Add(throttle); next.After(bt.due) {
+			bt.due = next
+		}
+	}
+	id := e.Type + " " + portKey(e.IP, e.Port, e.Proto)
+	if bt.seen[id] {
+		return
+	}
+	bt.seen[id] = true
+	bt.events = append(bt.events, e)
+}
+
+// ready removes the batches due at now and returns their events for each
+// notifier, summarised if there are too many.
+func (b *batcher) ready(now time.Time) map[string][]event {
+	return b.take(now, false)
+}
+
+// drain removes every batch, whether or not it's due, for sending before
+// shutting down.
+func (b *batcher) drain(now time.Time) map[string][]event {
+	return b.take(now, true)
+}
+
+func (b *batcher) take(now time.Time, all bool) map[string][]event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out map[string][]event
+	for key, bt := range b.batches {
+		if len(bt.events) == 0 {
+			// Forget the rule once its throttle has passed
+			if now.Sub(bt.last) >= bt.throttle {
+				delete(b.batches, key)
+			}
+			continue
+		}
+		if !all && now.Before(bt.due) {
+			continue
+		}
+		if out == nil {
+			out = make(map[string][]event)
+		}
+		out[key.notifier] = append(out[key.notifier], b.summarise(now, bt.events)...)
+		bt.events = nil
+		bt.seen = nil
+		bt.last = now
+	}
+	return out
+}
+
+// pending returns the number of events waiting to be sent.
+func (b *batcher) pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var n int
+	for _, bt := range b.batches {
+		n += len(bt.events)
+	}
+	return n
+}
+
+// summarise returns the events to send for a batch: the events themselves if
+// there are no more than max, otherwise one summary event.
+func (b *batcher) summarise(now time.Time, events []event) []event {
+	if b.max <= 0 || len(events) <= b.max {
+		return events
+	}
+	s := event{Type: eventSummary, Time: now, Events: events}
+	for _, e := range events {
+		switch e.Type {
+		case eventNewPort:
+			s.New++
+		case eventPortGone:
+			s.Gone++
+		case eventBannerChanged:
+			s.Changed++
+		case eventViolation:
+			s.Violations++
+		case eventCertExpiring:
+			s.Expiring++
+		}
+		if e.Severity != "" {
+			s.Severity = e.Severity
+		}
+	}
+	return []event{s}
+}
+
+// flushNotifications sends the batches due at now in the background.
+func (app *App) flushNotifications(now time.Time) {
+	ready := app.batcher.ready(now)
+	gaugeNotifyQueue.Set(float64(app.batcher.pending()))
+	if len(ready) == 0 {
+		return
+	}
+	go app.sendBatches(ready)
+}
+
+// sendBatches sends each notifier its events.
+func (app *App) sendBatches(batches map[string][]event) {
+	for _, n := range app.notifiers {
+		for _, e := range batches[n.name()] {
+			if err := n.notify(e); err != nil {
+				log.Printf("notify: error sending %s event for %s:%d/%s: %v", e.Type, e.IP, e.Port, e.Proto, err)
+			}
+		}
+	}
+}
+
+// runNotifications sends batches as they become due.
+func (app *App) runNotifications() {
+	for now := range time.Tick(time.Second) {
+		app.flushNotifications(now)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestBatcher(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newBatcher(time.Minute, 2)
+
+	newPort := func(ip string) event {
+		return event{Type: eventNewPort, Time: now, IP: ip, Port: 22, Proto: "tcp"}
+	}
+
+	// Events wait for the window, and repeats are dropped
+	b.add(now, "slack", nil, newPort("192.0.2.1"))
+	b.add(now, "slack", nil, newPort("192.0.2.1"))
+	b.add(now, "webhook", nil, newPort("192.0.2.1"))
+	if got := b.ready(now.Add(time.Second)); len(got) != 0 {
+		t.Errorf("expected nothing before the window, got %+v", got)
+	}
+	if n := b.pending(); n != 2 {
+		t.Errorf("expected 2 pending events, got %d", n)
+	}
+	got := b.ready(now.Add(time.Minute))
+	if n := b.pending(); n != 0 {
+		t.Errorf("expected no pending events after sending, got %d", n)
+	}
+	if len(got["slack"]) != 1 || len(got["webhook"]) != 1 {
+		t.Fatalf("expected one event for each notifier, got %+v", got)
+	}
+	if got["slack"][0].Type != eventNewPort {
+		t.Errorf("expected a new_port event, got %+v", got["slack"][0])
+	}
+
+	// More than max events are summarised
+	now = now.Add(time.Hour)
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		b.add(now, "slack", nil, newPort(ip))
+	}
+	b.add(now, "slack", nil, event{Type: eventPortGone, Time: now, IP: "192.0.2.4", Port: 22, Proto: "tcp"})
+	got = b.ready(now.Add(time.Minute))
+	if len(got["slack"]) != 1 {
+		t.Fatalf("expected one summary, got %+v", got["slack"])
+	}
+	s := got["slack"][0]
+	if s.Type != eventSummary || s.New != 3 || s.Gone != 1 || len(s.Events) != 4 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	if msg, _ := message("en", s); msg != "3 new ports open, 1 ports closed" {
+		t.Errorf("unexpected summary message %q", msg)
+	}
+}
+
+func TestBatcherThrottle(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newBatcher(0, 0)
+	rule := &alertRule{AlertRule: scan.AlertRule{ID: 1, Action: actionNotify, Throttle: 3600}}
+
+	b.add(now, "slack", rule, event{Type: eventNewPort, IP: "192.0.2.1", Port: 22, Proto: "tcp"})
+	if got := b.ready(now); len(got["slack"]) != 1 {
+		t.Fatalf("expected the first event straight away, got %+v", got)
+	}
+
+	// Later events for the rule wait for the throttle, but other events
+	// don't
+	later := now.Add(time.Minute)
+	b.add(later, "slack", rule, event{Type: eventNewPort, IP: "192.0.2.2", Port: 22, Proto: "tcp"})
+	b.add(later, "slack", nil, event{Type: eventNewPort, IP: "192.0.2.3", Port: 80, Proto: "tcp"})
+	got := b.ready(later)
+	if len(got["slack"]) != 1 || got["slack"][0].IP != "192.0.2.3" {
+		t.Fatalf("expected only the unthrottled event, got %+v", got)
+	}
+	got = b.ready(now.Add(time.Hour))
+	if len(got["slack"]) != 1 || got["slack"][0].IP != "192.0.2.2" {
+		t.Errorf("expected the throttled event after an hour, got %+v", got)
+	}
+}
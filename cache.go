@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxCachedResponse is the largest response body cached, in bytes.
+const maxCachedResponse = 8 << 20
+
+// cachedHeaders are the response headers kept with a cached response.
+var cachedHeaders = []string{"Content-Type", "Content-Disposition"}
+
+// cachedResponse is a successful response kept in the store.
+type cachedResponse struct {
+	Header map[string]string `json:"header"`
+	Body   []byte            `json:"body"`
+}
+
+// cacheRecorder passes a response through, keeping a copy of it to cache.
+type cacheRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+	full bool
+}
+
+func (c *cacheRecorder) WriteHeader(code int) {
+	c.code = code
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cacheRecorder) Write(b []byte) (int, error) {
+	if !c.full {
+		if c.body.Len()+len(b) > maxCachedResponse {
+			c.full = true
+			c.body.Reset()
+		} else {
+			c.body.Write(b)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// cacheKey returns the key of the request's cached response. Tenants and
+// tokens restricted to CIDRs see different results for the same URL, so
+// they're cached separately.
+func cacheKey(r *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(requestTenant(r) + "\x00" + strings.Join(requestCIDRs(r), ",") + "\x00" + r.URL.RequestURI()))
+	return "cache:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// cached is a middleware answering GET requests from responses cached in
+// app.kv for app.cacheTTL, so repeated requests for expensive pages, from
+// any replica sharing Redis, don't each query the database.
+func (app *App) cached(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.kv == nil || app.cacheTTL <= 0 || r.Method != "GET" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := cacheKey(r)
+		b, err := app.kv.get(key)
+		if err != nil {
+			log.Println("cached: error fetching response:", err)
+		}
+		var res cachedResponse
+		if b != nil && json.Unmarshal(b, &res) == nil {
+			for k, v := range res.Header {
+				w.Header().Set(k, v)
+			}
+			w.Write(res.Body)
+			return
+		}
+
+		rec := &cacheRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.code != http.StatusOK || rec.full {
+			return
+		}
+		res = cachedResponse{Header: make(map[string]string), Body: rec.body.Bytes()}
+		for _, k := range cachedHeaders {
+			if v := w.Header().Get(k); v != "" {
+				res.Header[k] = v
+			}
+		}
+		if b, err = json.Marshal(res); err == nil {
+			err = app.kv.set(key, b, app.cacheTTL)
+		}
+		if err != nil {
+			log.Println("cached: error saving response:", err)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestCached(t *testing.T) {
+	db := createDB("TestCached")
+	defer db.Close()
+	app := App{db: db, kv: newMemStore(), cacheTTL: time.Hour}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	save := func(ip string) {
+		t.Helper()
+		results := []scan.Result{{IP: ip, Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}}}
+		if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(path string) (string, []scan.IPInfo) {
+		t.Helper()
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var results []scan.IPInfo
+		if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+		return res.Header.Get("Content-Type"), results
+	}
+
+	save("192.0.2.1")
+	if _, results := get("/api/v1/results"); len(results) != 1 {
+		t.Fatalf("expected 1 result, got %+v", results)
+	}
+	save("192.0.2.2")
+	ct, results := get("/api/v1/results")
+	if len(results) != 1 || !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("expected the cached response, got %q %+v", ct, results)
+	}
+	// Other searches aren't answered from it
+	if _, results := get("/api/v1/results?ip=192.0.2.2"); len(results) != 1 || results[0].IP != "192.0.2.2" {
+		t.Errorf("expected the new result, got %+v", results)
+	}
+
+	// Tenants have their own cache
+	if err := db.SaveTenant(scan.Tenant{Name: "Web team", CIDRs: []string{"192.0.2.2/32"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetUserTenant("web@example.com", "Web team"); err != nil {
+		t.Fatal(err)
+	}
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "web@example.com"}}}
+	if _, results := get("/api/v1/results"); len(results) != 1 || results[0].IP != "192.0.2.2" {
+		t.Errorf("expected only the tenant's result, got %+v", results)
+	}
+}
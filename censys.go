@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// censysBatch is the most IPs looked up in Censys each run. Censys accounts
+// have a monthly query allowance, so this is kept small.
+const censysBatch = 20
+
+// censysClient queries the Censys Search API for the services Censys has
+// seen on each IP.
+type censysClient struct {
+	// url is the hosts endpoint, to which the IP is appended
+	url     string
+	id      string
+	secret  string
+	refresh time.Duration
+	client  *http.Client
+}
+
+func newCensysClient(baseURL, id, secret string, refresh time.Duration) *censysClient {
+	return &censysClient{url: baseURL, id: id, secret: secret, refresh: refresh, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// censysHost is the part of a Censys host response which is used.
+type censysHost struct {
+	Result struct {
+		Services []struct {
+			Port                int    `json:"port"`
+			ServiceName         string `json:"service_name"`
+			ExtendedServiceName string `json:"extended_service_name"`
+			TransportProtocol   string `json:"transport_protocol"`
+			Banner              string `json:"banner"`
+			Certificate         string `json:"certificate"`
+		} `json:"services"`
+	} `json:"result"`
+}
+
+// query looks up ip. IPs Censys knows nothing about have no services.
+func (c *censysClient) query(ip string) (scan.Censys, error) {
+	rec := scan.Censys{IP: ip, Services: []scan.CensysService{}}
+	req, err := http.NewRequest("GET", c.url+url.PathEscape(ip), nil)
+	if err != nil {
+		return rec, err
+	}
+	req.SetBasicAuth(c.id, c.secret)
+	res, err := c.client.Do(req)
+	if err != nil {
+		return rec, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return rec, nil
+	default:
+		return rec, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	var host censysHost
+	if err := json.NewDecoder(res.Body).Decode(&host); err != nil {
+		return rec, err
+	}
+	for _, s := range host.Result.Services {
+		name := s.ExtendedServiceName
+		if name == "" || name == "UNKNOWN" {
+			name = s.ServiceName
+		}
+		rec.Services = append(rec.Services, scan.CensysService{
+			IP:          ip,
+			Port:        s.Port,
+			Proto:       strings.ToLower(s.TransportProtocol),
+			Service:     name,
+			Banner:      s.Banner,
+			Certificate: s.Certificate,
+		})
+	}
+	return rec, nil
+}
+
+// enrichCensys looks up the external IPs never looked up, or last looked up
+// longer ago than the refresh interval. Failed lookups aren't saved, so
+// they're tried again on the next run.
+func (app *App) enrichCensys(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	ips, err := app.db.LoadStaleCensysIPs(now.Add(-app.censys.refresh))
+	if err != nil {
+		log.Println("censys: error loading IPs to look up:", err)
+		return
+	}
+	var records []scan.Censys
+	for _, ip := range ips {
+		if addr := net.ParseIP(ip); addr == nil || !isPublicIP(addr) {
+			continue
+		}
+		if len(records) == censysBatch {
+			break
+		}
+		rec, err := app.censys.query(ip)
+		if err != nil {
+			log.Printf("censys: error looking up %s: %v", ip, err)
+			continue
+		}
+		rec.Updated = scan.Time{Time: now.UTC()}
+		records = append(records, rec)
+	}
+	if err := app.db.SaveCensys(records); err != nil {
+		log.Println("censys: error saving lookups:", err)
+		return
+	}
+	if verbose && len(records) > 0 {
+		log.Printf("censys: looked up %d IPs", len(records))
+	}
+}
+
+// censysComparison is what Censys has seen on an IP alongside our own scans.
+// CensysOnly are the services it has seen on ports we don't see open, and
+// ScanOnly the ports we see open which it hasn't seen, as port/proto.
+type censysComparison struct {
+	scan.Censys
+	CensysOnly []scan.CensysService `json:"censys_only"`
+	ScanOnly   []string             `json:"scan_only"`
+}
+
+// compareCensys compares rec with the open ports in results.
+func compareCensys(rec scan.Censys, results []scan.IPInfo) censysComparison {
+	c := censysComparison{Censys: rec, CensysOnly: []scan.CensysService{}, ScanOnly: []string{}}
+	open := make(map[string]bool)
+	for _, r := range results {
+		if r.IP == rec.IP && !r.Gone {
+			open[fmt.Sprintf("%d/%s", r.Port, r.Proto)] = true
+		}
+	}
+	seen := make(map[string]bool)
+	for _, s := range rec.Services {
+		k := fmt.Sprintf("%d/%s", s.Port, s.Proto)
+		seen[k] = true
+		if !open[k] {
+			c.CensysOnly = append(c.CensysOnly, s)
+		}
+	}
+	for _, r := range results {
+		k := fmt.Sprintf("%d/%s", r.Port, r.Proto)
+		if open[k] && !seen[k] {
+			c.ScanOnly = append(c.ScanOnly, k)
+			seen[k] = true
+		}
+	}
+	return c
+}
+
+// Handler for GET /api/v1/censys/{ip}
+// This shows what Censys has seen on the IP, compared with our scans.
+func (app *App) censysHost(w http.ResponseWriter, r *http.Request) {
+	ip := scan.NormalizeIP(chi.URLParam(r, "ip"))
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		http.Error(w, "Invalid IP", http.StatusBadRequest)
+		return
+	}
+	records, err := app.db.LoadCensys(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, "IP not looked up in Censys", http.StatusNotFound)
+		return
+	}
+	data, _, err := app.db.ResultPage(sqlite.ResultQuery{IP: hostNetwork(addr)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, compareCensys(records[0], data.Results))
+}
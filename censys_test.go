@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestCensys(t *testing.T) {
+	db := createDB("TestCensys")
+	defer db.Close()
+
+	var queried []string
+	censys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "id" || secret != "secret" {
+			http.Error(w, "unauthorised", http.StatusUnauthorized)
+			return
+		}
+		ip := strings.TrimPrefix(r.URL.Path, "/api/v2/hosts/")
+		queried = append(queried, ip)
+		if ip != "192.0.2.1" {
+			http.Error(w, `{"code":404,"status":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"code":200,"status":"OK","result":{"ip":"192.0.2.1","services":[
+			{"port":22,"service_name":"SSH","extended_service_name":"SSH","transport_protocol":"TCP","banner":"SSH-2.0-OpenSSH_8.0"},
+			{"port":443,"service_name":"HTTP","extended_service_name":"HTTPS","transport_protocol":"TCP","banner":"HTTP/1.1 200 OK","certificate":"0123456789abcdef0123456789abcdef"}
+		]}}`))
+	}))
+	defer censys.Close()
+
+	app := App{db: db, censys: newCensysClient(censys.URL+"/api/v2/hosts/", "id", "secret", 30*24*time.Hour)}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 8080, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "10.0.0.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	app.enrichCensys(now)
+	if !reflect.DeepEqual(queried, []string{"192.0.2.1", "198.51.100.1"}) {
+		t.Errorf("expected only external IPs looked up, got %v", queried)
+	}
+	records, err := db.LoadCensys(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || len(records[0].Services) != 2 || len(records[1].Services) != 0 {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	want := scan.CensysService{IP: "192.0.2.1", Port: 443, Proto: "tcp", Service: "HTTPS", Banner: "HTTP/1.1 200 OK", Certificate: "0123456789abcdef0123456789abcdef"}
+	if s := records[0].Services[1]; s != want {
+		t.Errorf("unexpected service:\n got %+v\nwant %+v", s, want)
+	}
+
+	queried = nil
+	app.enrichCensys(now.Add(time.Hour))
+	if len(queried) != 0 {
+		t.Errorf("expected no lookups before the refresh, got %v", queried)
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/censys/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c censysComparison
+	err = json.NewDecoder(res.Body).Decode(&c)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.CensysOnly) != 1 || c.CensysOnly[0].Port != 443 || !reflect.DeepEqual(c.ScanOnly, []string{"8080/tcp"}) {
+		t.Errorf("unexpected comparison %+v", c)
+	}
+
+	for path, code := range map[string]int{
+		"/api/v1/censys/10.0.0.1":     http.StatusNotFound,
+		"/api/v1/censys/not-an-ip":    http.StatusBadRequest,
+		"/api/v1/censys/198.51.100.1": http.StatusOK,
+	} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("%s: expected status %d, got %d", path, code, res.StatusCode)
+		}
+	}
+
+	res, err = http.Get(ts.URL + "/host/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	for _, want := range []string{"not in our scans: 443/tcp (HTTPS)", "not seen by Censys: 8080/tcp", "Censys:</span> <strong>SSH</strong>"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("expected %q on the host page", want)
+		}
+	}
+}
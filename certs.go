@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// certBatch is the most ports probed for certificates each run.
+const certBatch = 100
+
+// certWorkers is how many ports are probed at once.
+const certWorkers = 8
+
+// certExpirySoon is how close to expiry certificates are highlighted.
+const certExpirySoon = 30 * 24 * time.Hour
+
+// certProber collects the TLS certificates presented on open ports.
+type certProber struct {
+	// ports are the ranges of TCP ports probed
+	ports [][2]int
+	// refresh is how long certificates are kept before they're collected
+	// again
+	refresh time.Duration
+	timeout time.Duration
+	// warn are the periods, in days and longest first, before certificates
+	// expire that they're warned about
+	warn []int
+	// dial connects to addr and completes a TLS handshake
+	dial func(addr string, timeout time.Duration) (*tls.Conn, error)
+}
+
+func newCertProber(ports [][2]int, refresh, timeout time.Duration, warn []int) *certProber {
+	sort.Sort(sort.Reverse(sort.IntSlice(warn)))
+	return &certProber{
+		ports:   ports,
+		refresh: refresh,
+		timeout: timeout,
+		warn:    warn,
+		dial: func(addr string, timeout time.Duration) (*tls.Conn, error) {
+			// Certificates are recorded, not trusted, so any certificate is
+			// accepted. No server name is sent as only the IP is known.
+			return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		},
+	}
+}
+
+// probe collects the certificate on r's port. A port which doesn't complete
+// a TLS handshake has a certificate without a fingerprint, so it isn't
+// probed again until the refresh interval has passed.
+func (p *certProber) probe(r scan.IPInfo) scan.Certificate {
+	cert := scan.Certificate{IP: r.IP, Port: r.Port, Proto: r.Proto}
+	conn, err := p.dial(net.JoinHostPort(r.IP, strconv.Itoa(r.Port)), p.timeout)
+	if err != nil {
+		if verbose {
+			log.Printf("certs: error probing %s port %d: %v", r.IP, r.Port, err)
+		}
+		return cert
+	}
+	defer conn.Close()
+	peers := conn.ConnectionState().PeerCertificates
+	if len(peers) == 0 {
+		return cert
+	}
+	leaf := peers[0]
+	sum := sha256.Sum256(leaf.Raw)
+	cert.Fingerprint = hex.EncodeToString(sum[:])
+	cert.Subject = certName(leaf.Subject.CommonName, leaf.Subject.String())
+	cert.Issuer = certName(leaf.Issuer.CommonName, leaf.Issuer.String())
+	cert.SANs = certSANs(leaf)
+	cert.NotBefore = scan.Time{Time: leaf.NotBefore.UTC()}
+	cert.NotAfter = scan.Time{Time: leaf.NotAfter.UTC()}
+	return cert
+}
+
+// certName returns a certificate name's common name, or its full
+// distinguished name if it has none.
+func certName(cn, dn string) string {
+	if cn != "" {
+		return cn
+	}
+	return dn
+}
+
+// certSANs returns the DNS names and IPs a certificate is valid for, in lower
+// case.
+func certSANs(c *x509.Certificate) []string {
+	var sans []string
+	for _, name := range c.DNSNames {
+		sans = append(sans, strings.ToLower(name))
+	}
+	for _, ip := range c.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+// parseWarnDays parses a comma-separated list of warning periods in days.
+func parseWarnDays(s string) ([]int, error) {
+	var days []int
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid number of days %q", f)
+		}
+		days = append(days, n)
+	}
+	return days, nil
+}
+
+// warning returns the shortest warning period c expires within at now, or 0
+// if it's not within any of them.
+func (p *certProber) warning(now time.Time, c scan.Certificate) int {
+	var days int
+	for _, d := range p.warn {
+		if c.NotAfter.Sub(now) <= time.Duration(d)*24*time.Hour {
+			days = d
+		}
+	}
+	return days
+}
+
+// refreshCerts collects the certificates on new TLS ports, and those due a
+// refresh, then warns about those expiring.
+func (app *App) refreshCerts(now time.Time) {
+	app.enrichCerts(now)
+	app.warnCertExpiry(now)
+}
+
+// enrichCerts probes the TLS ports never probed, or last probed longer ago
+// than the refresh interval.
+func (app *App) enrichCerts(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	stale, err := app.db.LoadStaleCertificatePorts(now.Add(-app.certs.refresh))
+	if err != nil {
+		log.Println("certs: error loading ports to probe:", err)
+		return
+	}
+	var ports []scan.IPInfo
+	for _, r := range stale {
+		if len(ports) == certBatch {
+			break
+		}
+		if inPortRanges(app.certs.ports, r.Port) {
+			ports = append(ports, r)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	certs := make([]scan.Certificate, 0, len(ports))
+	work := make(chan scan.IPInfo)
+	for i := 0; i < certWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range work {
+				cert := app.certs.probe(r)
+				cert.Updated = scan.Time{Time: now.UTC()}
+				mu.Lock()
+				certs = append(certs, cert)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, r := range ports {
+		work <- r
+	}
+	close(work)
+	wg.Wait()
+
+	if err := app.db.SaveCertificates(certs); err != nil {
+		log.Println("certs: error saving certificates:", err)
+		return
+	}
+	if verbose && len(certs) > 0 {
+		log.Printf("certs: probed %d ports", len(certs))
+	}
+}
+
+// warnCertExpiry sends an event for each certificate which has come within a
+// shorter warning period than it was last warned about, so each certificate
+// is warned about once for each period.
+func (app *App) warnCertExpiry(now time.Time) {
+	if !app.leading() || len(app.notifiers) == 0 || len(app.certs.warn) == 0 {
+		return
+	}
+	certs, err := app.db.LoadExpiringCertificates(now.AddDate(0, 0, app.certs.warn[0]))
+	if err != nil {
+		log.Println("certs: error loading expiring certificates:", err)
+		return
+	}
+	var events []event
+	for i, c := range certs {
+		days := app.certs.warning(now, c)
+		if days == 0 {
+			continue
+		}
+		warned, err := app.db.LoadCertificateWarning(c)
+		switch {
+		case err == nil && warned <= days:
+			continue
+		case err != nil && !errors.Is(err, sql.ErrNoRows):
+			log.Printf("certs: error loading warning for %s port %d: %v", c.IP, c.Port, err)
+			continue
+		}
+		if err := app.db.SaveCertificateWarning(c, days, now); err != nil {
+			log.Printf("certs: error saving warning for %s port %d: %v", c.IP, c.Port, err)
+			continue
+		}
+		events = append(events, event{Type: eventCertExpiring, Time: now, IP: c.IP, Port: c.Port, Proto: c.Proto, Certificate: &certs[i]})
+	}
+	app.notify(events)
+}
+
+// Handler for GET /api/v1/certificates
+//
+// Lists the collected certificates, optionally only those on ip. With
+// expires, only certificates expiring within that many days are listed,
+// soonest first.
+func (app *App) listCertificates(w http.ResponseWriter, r *http.Request) {
+	filter := sqlite.SQLFilter{Where: []string{`fingerprint != ''`}}
+	q := r.URL.Query()
+	if ip := q.Get("ip"); ip != "" {
+		filter.Where = append(filter.Where, `ip = ?`)
+		filter.Values = append(filter.Values, scan.NormalizeIP(ip))
+	}
+	expires := q.Get("expires")
+	if expires != "" {
+		days, err := strconv.Atoi(expires)
+		if err != nil || days < 0 {
+			http.Error(w, "invalid expires", http.StatusBadRequest)
+			return
+		}
+		filter.Where = append(filter.Where, `not_after < ?`)
+		filter.Values = append(filter.Values, time.Now().AddDate(0, 0, days).UTC())
+	}
+	certs, err := app.db.LoadCertificates(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if expires != "" {
+		sort.SliceStable(certs, func(i, j int) bool { return certs[i].NotAfter.Before(certs[j].NotAfter.Time) })
+	}
+	render.JSON(w, r, certs)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestCerts(t *testing.T) {
+	db := createDB("TestCerts")
+	defer db.Close()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var probed []string
+	certs := newCertProber([][2]int{{443, 443}, {8443, 8443}}, 24*time.Hour, time.Second, nil)
+	certs.dial = func(addr string, timeout time.Duration) (*tls.Conn, error) {
+		mu.Lock()
+		probed = append(probed, addr)
+		mu.Unlock()
+		if strings.HasPrefix(addr, "192.0.2.2:") {
+			return nil, errors.New("connection refused")
+		}
+		return tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	}
+
+	app := App{db: db, certs: certs}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 8443, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.3", Ports: []scan.Port{{Port: 443, Proto: "udp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	app.enrichCerts(now)
+	mu.Lock()
+	got := append([]string(nil), probed...)
+	mu.Unlock()
+	if len(got) != 2 || !(reflect.DeepEqual(got, []string{"192.0.2.1:443", "192.0.2.2:8443"}) || reflect.DeepEqual(got, []string{"192.0.2.2:8443", "192.0.2.1:443"})) {
+		t.Errorf("expected only TLS ports over TCP probed, got %v", got)
+	}
+
+	all, err := db.LoadCertificates(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected 2 probed ports, got %+v", all)
+	}
+	cert, failed := all[0], all[1]
+	if failed.Fingerprint != "" || !failed.NotAfter.IsZero() {
+		t.Errorf("expected the refused port recorded without a certificate, got %+v", failed)
+	}
+	leaf := server.Certificate()
+	if cert.Fingerprint == "" || cert.Subject != leaf.Subject.String() || !reflect.DeepEqual(cert.SANs, certSANs(leaf)) || cert.SANs[0] != "example.com" {
+		t.Errorf("unexpected certificate %+v", cert)
+	}
+	if !cert.NotAfter.Equal(leaf.NotAfter) {
+		t.Errorf("expected expiry %v, got %v", leaf.NotAfter, cert.NotAfter)
+	}
+
+	mu.Lock()
+	probed = nil
+	mu.Unlock()
+	app.enrichCerts(now.Add(time.Hour))
+	if len(probed) != 0 {
+		t.Errorf("expected no probes before the refresh, got %v", probed)
+	}
+
+	for _, tt := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"cert": {"example.com"}}, 1},
+		{url.Values{"q": {"cert:example"}}, 1},
+		{url.Values{"cert": {"example.org"}}, 0},
+	} {
+		query, err := resultQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _, err := db.ResultPage(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results) != tt.want {
+			t.Errorf("%v: expected %d results, got %d", tt.query, tt.want, len(data.Results))
+			continue
+		}
+		if tt.want == 1 && (data.Results[0].Port != 443 || data.Results[0].Certificate == nil) {
+			t.Errorf("%v: expected the certificate on port 443, got %+v", tt.query, data.Results[0])
+		}
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/certificates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []scan.Certificate
+	err = json.NewDecoder(res.Body).Decode(&listed)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Fingerprint != cert.Fingerprint {
+		t.Errorf("expected only the collected certificate listed, got %+v", listed)
+	}
+
+	res, err = http.Get(ts.URL + "/host/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(b), "<strong>O=Acme Co</strong> example.com") {
+		t.Error("expected the certificate on the host page")
+	}
+}
+
+func TestCertExpiry(t *testing.T) {
+	db := createDB("TestCertExpiry")
+	defer db.Close()
+
+	slack := testNotifier{"slack", make(chan event, 10)}
+	email := testNotifier{"email", make(chan event, 10)}
+	app := App{db: db, notifiers: []notifier{slack, email}, certs: newCertProber(nil, 24*time.Hour, time.Second, []int{1, 30, 7})}
+	if !reflect.DeepEqual(app.certs.warn, []int{30, 7, 1}) {
+		t.Errorf("expected warning periods longest first, got %v", app.certs.warn)
+	}
+
+	if _, err := db.SaveAlertRule(scan.AlertRule{Expiry: 7, Action: actionNotify, Channels: []string{"slack"}, Severity: "critical"}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var results []scan.Result
+	var certs []scan.Certificate
+	for i, expires := range []time.Duration{20, 3, 60, -2} {
+		ip := fmt.Sprintf("192.0.2.%d", i+1)
+		results = append(results, scan.Result{IP: ip, Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}})
+		certs = append(certs, scan.Certificate{
+			IP: ip, Port: 443, Proto: "tcp",
+			Fingerprint: fmt.Sprintf("%064d", i),
+			Subject:     ip,
+			NotBefore:   scan.Time{Time: now.AddDate(-1, 0, 0)},
+			NotAfter:    scan.Time{Time: now.Add(expires * 24 * time.Hour)},
+			Updated:     scan.Time{Time: now},
+		})
+	}
+	if _, _, err := db.SaveData(results, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveCertificates(certs); err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func(n testNotifier, want int) []string {
+		t.Helper()
+		var ips []string
+		for i := 0; i < want; i++ {
+			select {
+			case e := <-n.events:
+				if e.Type != eventCertExpiring || e.Certificate == nil {
+					t.Errorf("%s: unexpected event %+v", n.n, e)
+					continue
+				}
+				ips = append(ips, e.IP+" "+e.Severity)
+			case <-time.After(time.Second):
+				t.Fatalf("%s: expected %d events, got %d", n.n, want, i)
+			}
+		}
+		select {
+		case e := <-n.events:
+			t.Errorf("%s: unexpected event %+v", n.n, e)
+		default:
+		}
+		return ips
+	}
+
+	// Each certificate within a warning period is warned about, and those
+	// within a week only go to Slack, as critical
+	app.warnCertExpiry(now)
+	if got := receive(slack, 3); !reflect.DeepEqual(got, []string{"192.0.2.4 critical", "192.0.2.2 critical", "192.0.2.1 "}) {
+		t.Errorf("unexpected Slack warnings %v", got)
+	}
+	if got := receive(email, 1); !reflect.DeepEqual(got, []string{"192.0.2.1 "}) {
+		t.Errorf("unexpected email warnings %v", got)
+	}
+
+	app.warnCertExpiry(now.Add(time.Hour))
+	receive(slack, 0)
+
+	msg, err := message("en", event{Type: eventCertExpiring, IP: "192.0.2.1", Port: 443, Proto: "tcp", Certificate: &certs[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Certificate for 192.0.2.1 on 192.0.2.1 port 443/tcp expires on " + certs[0].NotAfter.String(); msg != want {
+		t.Errorf("expected message %q, got %q", want, msg)
+	}
+
+	// Two weeks on, the certificates come within shorter periods
+	app.warnCertExpiry(now.AddDate(0, 0, 14))
+	if got := receive(slack, 2); !reflect.DeepEqual(got, []string{"192.0.2.2 critical", "192.0.2.1 critical"}) {
+		t.Errorf("unexpected Slack warnings %v", got)
+	}
+	receive(email, 0)
+
+	// A renewed certificate isn't warned about
+	certs[0].Fingerprint = fmt.Sprintf("%064d", 9)
+	certs[0].NotAfter = scan.Time{Time: now.AddDate(0, 0, 90)}
+	if err := db.SaveCertificates(certs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	app.warnCertExpiry(now.AddDate(0, 0, 15))
+	receive(slack, 0)
+
+	for _, tt := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"expires": {"7"}}, 2},
+		{url.Values{"q": {"expires:0"}}, 1},
+		{url.Values{"expires": {"365"}}, 4},
+	} {
+		query, err := resultQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _, err := db.ResultPage(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results) != tt.want {
+			t.Errorf("%v: expected %d results, got %d", tt.query, tt.want, len(data.Results))
+		}
+	}
+	if _, err := resultQuery(url.Values{"expires": {"-1"}}); err == nil {
+		t.Error("expected an error for expires=-1")
+	}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "/api/v1/certificates?expires=30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []scan.Certificate
+	err = json.NewDecoder(res.Body).Decode(&listed)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ips []string
+	for _, c := range listed {
+		ips = append(ips, c.IP)
+	}
+	if !reflect.DeepEqual(ips, []string{"192.0.2.4", "192.0.2.2"}) {
+		t.Errorf("expected expiring certificates soonest first, got %v", ips)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// slack sends events to a Slack incoming webhook.
+type slack struct {
+	url     string
+	channel string
+	msg     customMessage
+	client  *http.Client
+}
+
+func newSlack(url, channel string, msg customMessage) *slack {
+	return &slack{url: url, channel: channel, msg: msg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *slack) name() string { return "slack" }
+
+func (s *slack) notify(e event) error {
+	text, err := s.msg.render(e)
+	if err != nil {
+		return err
+	}
+	return postJSON(s.client, "slack", s.url, struct {
+		Channel string `json:"channel,omitempty"`
+		Text    string `json:"text"`
+	}{s.channel, text})
+}
+
+// discord sends events to a Discord channel webhook.
+type discord struct {
+	url    string
+	msg    customMessage
+	client *http.Client
+}
+
+func newDiscord(url string, msg customMessage) *discord {
+	return &discord{url: url, msg: msg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (d *discord) name() string { return "discord" }
+
+func (d *discord) notify(e event) error {
+	content, err := d.msg.render(e)
+	if err != nil {
+		return err
+	}
+	return postJSON(d.client, "discord", d.url, struct {
+		Content string `json:"content"`
+	}{content})
+}
+
+// teams sends events to a Microsoft Teams incoming webhook as an Adaptive
+// Card.
+type teams struct {
+	url    string
+	msg    customMessage
+	client *http.Client
+}
+
+func newTeams(url string, msg customMessage) *teams {
+	return &teams{url: url, msg: msg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type teamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+func (t *teams) name() string { return "teams" }
+
+func (t *teams) notify(e event) error {
+	text, err := t.msg.render(e)
+	if err != nil {
+		return err
+	}
+	color := "attention"
+	if e.Type == eventPortGone || (e.Type == eventSummary && e.New == 0) {
+		color = "good"
+	}
+	facts := []teamsFact{
+		{"IP", e.IP},
+		{"Port", fmt.Sprintf("%d/%s", e.Port, e.Proto)},
+		{"Time", e.Time.Format(time.RFC3339)},
+	}
+	switch e.Type {
+	case eventSummary:
+		facts = []teamsFact{
+			{"New", fmt.Sprint(e.New)},
+			{"Closed", fmt.Sprint(e.Gone)},
+			{"Time", e.Time.Format(time.RFC3339)},
+		}
+	case eventQuota:
+		facts = []teamsFact{
+			{"Size", fmt.Sprintf("%d MB", e.Size)},
+			{"Quota", fmt.Sprintf("%d MB", e.Quota)},
+			{"Time", e.Time.Format(time.RFC3339)},
+		}
+	}
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.2",
+		"body": []interface{}{
+			map[string]interface{}{
+				"type":   "TextBlock",
+				"text":   text,
+				"weight": "bolder",
+				"color":  color,
+				"wrap":   true,
+			},
+			map[string]interface{}{
+				"type":  "FactSet",
+				"facts": facts,
+			},
+		},
+	}
+	return postJSON(t.client, "teams", t.url, map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content":     card,
+			},
+		},
+	})
+}
+
+const telegramAPI = "https://api.telegram.org"
+
+// telegram sends new port, baseline violation and certificate expiry alerts,
+// summaries including any of them, and quota alerts to a chat via a Telegram
+// bot.
+type telegram struct {
+	api    string
+	token  string
+	chatID string
+	msg    customMessage
+	client *http.Client
+}
+
+func newTelegram(token, chatID string, msg customMessage) *telegram {
+	return &telegram{api: telegramAPI, token: token, chatID: chatID, msg: msg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (t *telegram) name() string { return "telegram" }
+
+func (t *telegram) notify(e event) error {
+	if e.Type != eventNewPort && e.Type != eventViolation && e.Type != eventCertExpiring && e.Type != eventQuota && (e.Type != eventSummary || e.New+e.Violations+e.Expiring == 0) {
+		return nil
+	}
+	text, err := t.msg.render(e)
+	if err != nil {
+		return err
+	}
+	return postJSON(t.client, "telegram", fmt.Sprintf("%s/bot%s/sendMessage", t.api, t.token), struct {
+		ChatID string `json:"chat_id"`
+		Text   string `json:"text"`
+	}{t.chatID, text})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestChatNotifiers(t *testing.T) {
+	db := createDB("TestChatNotifiers")
+	defer db.Close()
+
+	posts := make(chan map[string]string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		body["path"] = r.URL.Path
+		posts <- body
+	}))
+	defer ts.Close()
+
+	slackMsg, err := newCustomMessage("en", `{{ if eq .Type "port_gone" }}:white_check_mark:{{ else }}:rotating_light:{{ end }} {{ .IP }}:{{ .Port }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discordMsg, _ := newCustomMessage("de", "")
+	app := App{db: db, notifiers: []notifier{
+		newSlack(ts.URL+"/slack", "#alerts", slackMsg),
+		newDiscord(ts.URL+"/discord", discordMsg),
+	}}
+
+	first := time.Now().UTC().Truncate(time.Second)
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 3389, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, first, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second scan no longer sees 192.0.2.2
+	second := first.Add(time.Hour)
+	_, added, err := db.SaveData(results[:1], second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, second, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+	app.notifyChanges(second, added, nil)
+
+	want := []map[string]string{
+		{"path": "/slack", "channel": "#alerts", "text": ":white_check_mark: 192.0.2.2:3389"},
+		{"path": "/discord", "content": "Port 3389/tcp geschlossen auf 192.0.2.2"},
+	}
+	for _, w := range want {
+		select {
+		case got := <-posts:
+			for k, v := range w {
+				if got[k] != v {
+					t.Errorf("expected %s %q, got %q", k, v, got[k])
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for notification")
+		}
+	}
+}
+
+func TestTeams(t *testing.T) {
+	type card struct {
+		Attachments []struct {
+			ContentType string `json:"contentType"`
+			Content     struct {
+				Type string `json:"type"`
+				Body []struct {
+					Type  string      `json:"type"`
+					Text  string      `json:"text"`
+					Facts []teamsFact `json:"facts"`
+				} `json:"body"`
+			} `json:"content"`
+		} `json:"attachments"`
+	}
+	cards := make(chan card, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c card
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			t.Error(err)
+		}
+		cards <- c
+	}))
+	defer ts.Close()
+
+	msg, _ := newCustomMessage("en", "")
+	e := event{Type: eventNewPort, Time: time.Now().UTC(), IP: "192.0.2.1", Port: 22, Proto: "tcp"}
+	if err := newTeams(ts.URL, msg).notify(e); err != nil {
+		t.Fatal(err)
+	}
+
+	c := <-cards
+	if len(c.Attachments) != 1 || c.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" {
+		t.Fatalf("expected an adaptive card attachment, got %+v", c)
+	}
+	content := c.Attachments[0].Content
+	if content.Type != "AdaptiveCard" || len(content.Body) != 2 {
+		t.Fatalf("unexpected card %+v", content)
+	}
+	want, _ := message("en", e)
+	if content.Body[0].Text != want {
+		t.Errorf("expected text %q, got %q", want, content.Body[0].Text)
+	}
+	if facts := content.Body[1].Facts; len(facts) != 3 || facts[0].Value != "192.0.2.1" || facts[1].Value != "22/tcp" {
+		t.Errorf("unexpected facts %+v", facts)
+	}
+}
+
+func TestTelegram(t *testing.T) {
+	type post struct {
+		path string
+		body map[string]string
+	}
+	posts := make(chan post, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		posts <- post{r.URL.Path, body}
+	}))
+	defer ts.Close()
+
+	msg, _ := newCustomMessage("en", "")
+	tg := newTelegram("123:abc", "-100", msg)
+	tg.api = ts.URL
+
+	now := time.Now().UTC()
+	for _, e := range []event{
+		{Type: eventPortGone, Time: now, IP: "192.0.2.1", Port: 22, Proto: "tcp"},
+		{Type: eventNewPort, Time: now, IP: "192.0.2.1", Port: 80, Proto: "tcp"},
+	} {
+		if err := tg.notify(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(posts)
+
+	var got []post
+	for p := range posts {
+		got = append(got, p)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected only the new port to be sent, got %d messages", len(got))
+	}
+	if got[0].path != "/bot123:abc/sendMessage" {
+		t.Errorf("unexpected path %q", got[0].path)
+	}
+	if got[0].body["chat_id"] != "-100" {
+		t.Errorf("expected chat_id -100, got %q", got[0].body["chat_id"])
+	}
+	want, _ := message("en", event{Type: eventNewPort, IP: "192.0.2.1", Port: 80, Proto: "tcp"})
+	if got[0].body["text"] != want {
+		t.Errorf("expected text %q, got %q", want, got[0].body["text"])
+	}
+}
+
+func TestTelegramErrorsHideToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	msg, _ := newCustomMessage("en", "")
+	tg := newTelegram("123:secret", "-100", msg)
+	tg.api = ts.URL
+
+	e := event{Type: eventNewPort, Time: time.Now().UTC(), IP: "192.0.2.1", Port: 80, Proto: "tcp"}
+	err := tg.notify(e)
+	if err == nil {
+		t.Fatal("expected an error from a failed request")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error contains the bot token: %v", err)
+	}
+
+	// Connection errors mustn't include the URL either
+	ts.Close()
+	err = tg.notify(e)
+	if err == nil {
+		t.Fatal("expected an error from a closed server")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error contains the bot token: %v", err)
+	}
+}
@@ -0,0 +1,516 @@
+// Command scan-agent runs masscan on a scanning host and submits the results
+// to a scan server, retrying until the server has them.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// maxRetryWait is the longest the agent waits between attempts to submit.
+const maxRetryWait = 5 * time.Minute
+
+// Spooled results are named by when the scan started, and the job they're
+// for after the job separator if they're for one. They're written with the
+// partial suffix until masscan finishes, and renamed with the rejected suffix
+// if the server refuses them.
+const (
+	jobSeparator   = "-job"
+	spoolSuffix    = ".json"
+	partialSuffix  = ".partial"
+	rejectedSuffix = ".rejected"
+)
+
+var versionRE = regexp.MustCompile(`version ([0-9][0-9.]*)`)
+
+// version is the agent's version, reported in heartbeats. Releases set it
+// with -ldflags "-X main.version=...".
+var version = "dev"
+
+// agent runs masscan and submits its output. Output is kept in the spool
+// directory until the server has accepted it, so results survive the
+// server being down and the agent restarting.
+type agent struct {
+	server string
+	token  string
+	// name identifies the agent to the server
+	name    string
+	ports   string
+	rate    int
+	ranges  []string
+	extra   []string
+	timeout time.Duration
+	spool   string
+	// retries is how many more times a submission is tried after failing
+	retries   int
+	retryWait time.Duration
+	client    *http.Client
+	// scanner is masscan's name and version, as reported to the server
+	scanner string
+	// run runs masscan with args
+	run func(ctx context.Context, args []string) error
+}
+
+// args returns what to scan as masscan's arguments.
+func (a *agent) args() []string {
+	args := []string{"-p", a.ports, "--rate", strconv.Itoa(a.rate)}
+	args = append(args, a.extra...)
+	return append(args, a.ranges...)
+}
+
+// jobArgs returns what to scan for a job as masscan's arguments. Jobs
+// without a rate are scanned at the agent's.
+func (a *agent) jobArgs(j scan.Job) []string {
+	ports := j.Ports
+	if strings.EqualFold(j.Proto, "udp") {
+		// masscan scans UDP ports given as U:port
+		var udp []string
+		for _, p := range strings.Split(ports, ",") {
+			udp = append(udp, "U:"+strings.TrimSpace(p))
+		}
+		ports = strings.Join(udp, ",")
+	}
+	rate := a.rate
+	if j.Rate > 0 {
+		rate = j.Rate
+	}
+	args := []string{"-p", ports, "--rate", strconv.Itoa(rate)}
+	args = append(args, a.extra...)
+	return append(args, j.CIDR)
+}
+
+// scan runs masscan, spooling its output. Output with no results is
+// spooled too, as submitting it is how the server learns every port closed.
+func (a *agent) scan(ctx context.Context, start time.Time) error {
+	return a.masscan(ctx, strconv.FormatInt(start.UnixNano(), 10)+spoolSuffix, a.args())
+}
+
+// scanJob runs a job, spooling its output to be submitted for the job.
+func (a *agent) scanJob(ctx context.Context, start time.Time, j scan.Job) error {
+	name := strconv.FormatInt(start.UnixNano(), 10) + jobSeparator + strconv.Itoa(j.ID) + spoolSuffix
+	return a.masscan(ctx, name, a.jobArgs(j))
+}
+
+// masscan runs masscan with args, spooling its output as name.
+func (a *agent) masscan(ctx context.Context, name string, args []string) error {
+	name = filepath.Join(a.spool, name)
+	partial := name + partialSuffix
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+	if err := a.run(ctx, append([]string{"-oJ", partial}, args...)); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("masscan: %v", err)
+	}
+	if _, err := os.Stat(partial); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(partial, nil, 0600); err != nil {
+			return err
+		}
+	}
+	return os.Rename(partial, name)
+}
+
+// errRejected is returned when the server refuses a submission in a way
+// retrying won't fix.
+var errRejected = errors.New("rejected")
+
+// submit sends a spooled scan to the server, streaming it from disk. It
+// tries again after failures which may be temporary, waiting longer each
+// time or as long as the server asks.
+func (a *agent) submit(ctx context.Context, name string) error {
+	wait := a.retryWait
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := a.post(ctx, name)
+		if err == nil || errors.Is(err, errRejected) || attempt == a.retries {
+			return err
+		}
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		log.Printf("error submitting %s, retrying in %s: %v", filepath.Base(name), wait, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+	}
+}
+
+// post makes one attempt at submitting a spooled scan. If it fails it
+// returns how long the server asked the agent to wait, if it did.
+func (a *agent) post(ctx context.Context, name string) (time.Duration, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// Jobs' results are submitted to the job. The server knows what the
+	// job scanned, but needs to be told what other scans covered to close
+	// ports they didn't see.
+	method, target := "POST", a.server+"/results"
+	base := strings.TrimSuffix(filepath.Base(name), spoolSuffix)
+	if i := strings.Index(base, jobSeparator); i >= 0 {
+		method, target = "PUT", target+"/"+base[i+len(jobSeparator):]
+	}
+	req, err := http.NewRequest(method, target, scan.MasscanJSON(f))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "scan-agent")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	req.Header.Set("X-Agent", a.name)
+	req.Header.Set("X-Scanner", a.scanner)
+	if method == "POST" {
+		req.Header.Set("X-Scanner-Args", strings.Join(a.args(), " "))
+		req.Header.Set("X-Scanner-Rate", strconv.Itoa(a.rate))
+		req.Header.Set("X-Scan-Scope", strings.Join(a.ranges, ","))
+		req.Header.Set("X-Scan-Ports", a.ports)
+	}
+	res, err := a.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	msg := strings.TrimSpace(string(body))
+
+	switch {
+	case res.StatusCode < 300:
+		return 0, nil
+	case res.StatusCode == http.StatusTooManyRequests, res.StatusCode == http.StatusInsufficientStorage, res.StatusCode >= 500:
+		var retryAfter time.Duration
+		if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && secs > 0 {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		return retryAfter, fmt.Errorf("%s: %s", res.Status, msg)
+	default:
+		return 0, fmt.Errorf("%w: %s: %s", errRejected, res.Status, msg)
+	}
+}
+
+// heartbeat tells the server the agent is alive, registering it the first
+// time.
+func (a *agent) heartbeat(ctx context.Context) error {
+	body, err := json.Marshal(struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		Scanner string `json:"scanner"`
+	}{a.name, version, a.scanner})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", a.server+"/agents", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "scan-agent")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	res, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// heartbeats sends a heartbeat every interval until ctx is done, including
+// while masscan is running.
+func (a *agent) heartbeats(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := a.heartbeat(ctx); err != nil && ctx.Err() == nil {
+			log.Println("error sending heartbeat:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// claim asks the server for the next job, returning nil if there's none.
+func (a *agent) claim(ctx context.Context) (*scan.Job, error) {
+	req, err := http.NewRequest("POST", a.server+"/jobs/claim", nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "scan-agent")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	req.Header.Set("X-Agent", a.name)
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNoContent:
+		return nil, nil
+	case res.StatusCode >= 300:
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	var j scan.Job
+	if err := json.NewDecoder(res.Body).Decode(&j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// jobs claims and runs jobs, submitting each one's results, until the
+// server has none left.
+func (a *agent) jobs(ctx context.Context) error {
+	for {
+		j, err := a.claim(ctx)
+		if err != nil {
+			return fmt.Errorf("claiming job: %v", err)
+		}
+		if j == nil {
+			return nil
+		}
+		log.Printf("running job %d: %s %s/%s", j.ID, j.CIDR, j.Ports, j.Proto)
+		if err := a.scanJob(ctx, time.Now(), *j); err != nil {
+			return fmt.Errorf("job %d: %v", j.ID, err)
+		}
+		if err := a.flush(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// flush submits every spooled scan, oldest first. Scans the server rejects
+// are renamed out of the way, to be looked at by hand. It stops at the
+// first which can't be submitted, so results are sent in order.
+func (a *agent) flush(ctx context.Context) error {
+	names, err := filepath.Glob(filepath.Join(a.spool, "*"+spoolSuffix))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := a.submit(ctx, name)
+		switch {
+		case errors.Is(err, errRejected):
+			log.Printf("server rejected %s, keeping it as %s: %v", filepath.Base(name), filepath.Base(name)+rejectedSuffix, err)
+			if err := os.Rename(name, name+rejectedSuffix); err != nil {
+				return err
+			}
+		case err != nil:
+			return fmt.Errorf("submitting %s: %v", filepath.Base(name), err)
+		default:
+			log.Printf("submitted %s", filepath.Base(name))
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// once submits anything left from before, scans, and submits the results.
+func (a *agent) once(ctx context.Context, start time.Time) error {
+	if err := a.flush(ctx); err != nil {
+		return err
+	}
+	if err := a.scan(ctx, start); err != nil {
+		return err
+	}
+	return a.flush(ctx)
+}
+
+// masscanVersion returns masscan's name and version, as reported by
+// --version.
+func masscanVersion(path string) string {
+	out, _ := exec.Command(path, "--version").Output()
+	if m := versionRE.FindSubmatch(out); m != nil {
+		return "masscan " + string(m[1])
+	}
+	return "masscan"
+}
+
+func run(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("scan-agent", flag.ContinueOnError)
+	fs.SetOutput(out)
+	server := fs.String("server", "", "`URL` of the scan server")
+	name := fs.String("name", "", "`Name` to identify the agent to the server (default the hostname)")
+	heartbeat := fs.Duration("heartbeat", time.Minute, "How often to tell the server the agent is alive, or 0 not to")
+	token := fs.String("token", os.Getenv("SCAN_AGENT_TOKEN"), "API `token` to authenticate with (default $SCAN_AGENT_TOKEN)")
+	tokenFile := fs.String("token.file", "", "Read the API token from this `file`")
+	masscan := fs.String("masscan", "masscan", "`Path` to masscan")
+	ranges := fs.String("ranges", "", "Comma-separated IPs, CIDRs or first-last `ranges` to scan")
+	ports := fs.String("ports", "1-65535", "`Ports` to scan, as masscan's -p")
+	rate := fs.Int("rate", 1000, "Packets per second to send")
+	extra := fs.String("args", "", "Extra `arguments` for masscan, such as --banners")
+	interval := fs.Duration("interval", 0, "Scan every `interval`, or only once if 0")
+	jobs := fs.Duration("jobs", 0, "Ask the server for jobs every `interval`, or never if 0")
+	timeout := fs.Duration("timeout", 0, "How long each scan can take, or 0 for no limit")
+	spool := fs.String("spool", "", "`Directory` to keep results in until the server has them (default scan-agent in the user cache directory)")
+	retries := fs.Int("retries", 5, "How many more times to try submitting results after failing")
+	retryWait := fs.Duration("retry.wait", 10*time.Second, "How long to wait before the first retry, doubling each time")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *server == "" {
+		return errors.New("-server is required")
+	}
+	u, err := url.Parse(*server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid -server %q", *server)
+	}
+	if *tokenFile != "" {
+		b, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			return err
+		}
+		*token = strings.TrimSpace(string(b))
+	}
+	var rangeList []string
+	for _, r := range strings.Split(*ranges, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			rangeList = append(rangeList, r)
+		}
+	}
+	if len(rangeList) == 0 && *jobs == 0 {
+		return errors.New("-ranges or -jobs is required")
+	}
+	if *rate < 1 {
+		return errors.New("-rate must be at least 1")
+	}
+	if *name == "" {
+		if *name, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	if *spool == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return err
+		}
+		*spool = filepath.Join(dir, "scan-agent")
+	}
+	if err := os.MkdirAll(*spool, 0700); err != nil {
+		return err
+	}
+
+	a := &agent{
+		server:    strings.TrimSuffix(*server, "/"),
+		token:     *token,
+		name:      *name,
+		ports:     *ports,
+		rate:      *rate,
+		ranges:    rangeList,
+		extra:     strings.Fields(*extra),
+		timeout:   *timeout,
+		spool:     *spool,
+		retries:   *retries,
+		retryWait: *retryWait,
+		client:    &http.Client{Timeout: 10 * time.Minute},
+		scanner:   masscanVersion(*masscan),
+		run: func(ctx context.Context, args []string) error {
+			cmd := exec.CommandContext(ctx, *masscan, args...)
+			cmd.Stdout = os.Stderr
+			cmd.Stderr = os.Stderr
+			return cmd.Run()
+		},
+	}
+
+	// Stop masscan and any retries on SIGINT or SIGTERM. Spooled results
+	// are submitted next time.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	if *heartbeat > 0 {
+		go a.heartbeats(ctx, *heartbeat)
+	}
+	if *interval == 0 && *jobs == 0 {
+		return a.once(ctx, time.Now())
+	}
+
+	// Scans and jobs take turns, so only one masscan runs at a time
+	var scanned bool
+	nextScan := time.Now()
+	for {
+		now := time.Now()
+		if len(a.ranges) > 0 && (*interval > 0 || !scanned) && !now.Before(nextScan) {
+			if err := a.once(ctx, now); err != nil && ctx.Err() == nil {
+				log.Println(err)
+			}
+			scanned = true
+			nextScan = now.Add(*interval)
+		}
+		var wake time.Time
+		if *jobs > 0 {
+			if err := a.jobs(ctx); err != nil && ctx.Err() == nil {
+				log.Println(err)
+			}
+			wake = time.Now().Add(*jobs)
+		}
+		if len(a.ranges) > 0 && *interval > 0 && (wake.IsZero() || nextScan.Before(wake)) {
+			wake = nextScan
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(wake)):
+		}
+	}
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stderr); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+const masscanOutput = `[
+{   "ip": "192.0.2.1",   "timestamp": "1600000000", "ports": [ {"port": 22, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] }
+,
+{   "ip": "192.0.2.2",   "timestamp": "1600000001", "ports": [ {"port": 80, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] }
+,
+]
+`
+
+func TestAgent(t *testing.T) {
+	spool, err := ioutil.TempDir("", "scan-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+
+	var mu sync.Mutex
+	var attempts int
+	var received [][]scan.Result
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// The server is down at first
+		if attempts == 1 {
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		var results []scan.Result
+		if err := json.NewDecoder(r.Body).Decode(&results); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received = append(received, results)
+		headers = r.Header
+	}))
+	defer srv.Close()
+
+	var args []string
+	output := masscanOutput
+	a := &agent{
+		server:    srv.URL,
+		token:     "secret",
+		name:      "scanner1",
+		ports:     "22,80",
+		rate:      100,
+		ranges:    []string{"192.0.2.0/24"},
+		extra:     []string{"--banners"},
+		spool:     spool,
+		retries:   2,
+		retryWait: time.Millisecond,
+		client:    http.DefaultClient,
+		scanner:   "masscan 1.3.2",
+		run: func(ctx context.Context, a []string) error {
+			args = a
+			if output == "" {
+				// masscan writes nothing when it finds nothing
+				return nil
+			}
+			return ioutil.WriteFile(a[1], []byte(output), 0600)
+		},
+	}
+
+	if err := a.once(context.Background(), time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if args[0] != "-oJ" || filepath.Dir(args[1]) != spool {
+		t.Errorf("expected masscan to write to the spool, got %v", args)
+	}
+	if attempts != 2 || len(received) != 1 || len(received[0]) != 2 {
+		t.Fatalf("expected the results submitted on the second attempt, got %d attempts and %+v", attempts, received)
+	}
+	if got := received[0][1]; got.IP != "192.0.2.2" || got.Ports[0].Port != 80 || got.Timestamp.Unix() != 1600000001 {
+		t.Errorf("unexpected result %+v", got)
+	}
+	for k, want := range map[string]string{
+		"Content-Type":   "application/json",
+		"X-Agent":        "scanner1",
+		"X-Scanner":      "masscan 1.3.2",
+		"X-Scanner-Args": "-p 22,80 --rate 100 --banners 192.0.2.0/24",
+		"X-Scanner-Rate": "100",
+		"X-Scan-Scope":   "192.0.2.0/24",
+		"X-Scan-Ports":   "22,80",
+	} {
+		if got := headers.Get(k); got != want {
+			t.Errorf("expected %s %q, got %q", k, want, got)
+		}
+	}
+	if left, _ := filepath.Glob(filepath.Join(spool, "*")); len(left) != 0 {
+		t.Errorf("expected the spool emptied, got %v", left)
+	}
+
+	// Scans finding nothing are submitted so the server closes the ports
+	output = ""
+	if err := a.once(context.Background(), time.Unix(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 || len(received[1]) != 0 {
+		t.Errorf("expected an empty submission, got %+v", received)
+	}
+
+	// Results are kept until the server can be reached, and submitted in
+	// order
+	a.server = "http://127.0.0.1:1"
+	output = masscanOutput
+	if err := a.once(context.Background(), time.Unix(3, 0)); err == nil {
+		t.Fatal("expected an error submitting to a server which is down")
+	}
+	if err := a.scan(context.Background(), time.Unix(4, 0)); err != nil {
+		t.Fatal(err)
+	}
+	a.server = srv.URL
+	received = nil
+	if err := a.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 {
+		t.Errorf("expected both spooled scans submitted, got %+v", received)
+	}
+
+	// Rejected results are set aside
+	a.token = "wrong"
+	if err := a.once(context.Background(), time.Unix(5, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(spool, "5000000000.json.rejected")); err != nil {
+		t.Errorf("expected the rejected results kept: %v", err)
+	}
+
+	// A failed scan leaves nothing behind
+	a.run = func(ctx context.Context, args []string) error {
+		ioutil.WriteFile(args[1], []byte("[\n"), 0600)
+		return errors.New("exit status 1")
+	}
+	if err := a.once(context.Background(), time.Unix(6, 0)); err == nil {
+		t.Error("expected masscan's error")
+	}
+	if left, _ := filepath.Glob(filepath.Join(spool, "*.json*")); len(left) != 1 {
+		t.Errorf("expected only the rejected results left, got %v", left)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	spool, err := ioutil.TempDir("", "scan-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+
+	queue := []scan.Job{
+		{ID: 7, CIDR: "192.0.2.0/24", Ports: "22,80", Proto: "tcp", Rate: 50},
+		{ID: 8, CIDR: "198.51.100.0/24", Ports: "53, 161-162", Proto: "udp"},
+	}
+	var submitted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/jobs/claim":
+			if r.Header.Get("X-Agent") != "scanner1" {
+				http.Error(w, "Invalid agent name", http.StatusBadRequest)
+				return
+			}
+			if len(queue) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			json.NewEncoder(w).Encode(queue[0])
+			queue = queue[1:]
+		case r.Method == "PUT":
+			var results []scan.Result
+			if err := json.NewDecoder(r.Body).Decode(&results); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			submitted = append(submitted, r.URL.Path)
+		default:
+			http.Error(w, "Not Found", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	var runs [][]string
+	a := &agent{
+		server:    srv.URL,
+		name:      "scanner1",
+		rate:      100,
+		spool:     spool,
+		retryWait: time.Millisecond,
+		client:    http.DefaultClient,
+		run: func(ctx context.Context, args []string) error {
+			runs = append(runs, args[2:])
+			return ioutil.WriteFile(args[1], []byte(masscanOutput), 0600)
+		},
+	}
+	if err := a.jobs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"-p", "22,80", "--rate", "50", "192.0.2.0/24"},
+		{"-p", "U:53,U:161-162", "--rate", "100", "198.51.100.0/24"},
+	}
+	if !reflect.DeepEqual(runs, want) {
+		t.Errorf("expected masscan run with %v, got %v", want, runs)
+	}
+	if !reflect.DeepEqual(submitted, []string{"/results/7", "/results/8"}) {
+		t.Errorf("expected results submitted to each job, got %v", submitted)
+	}
+	if left, _ := filepath.Glob(filepath.Join(spool, "*")); len(left) != 0 {
+		t.Errorf("expected the spool emptied, got %v", left)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/agents" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	a := &agent{server: srv.URL, token: "secret", name: "scanner1", scanner: "masscan 1.3.2", client: http.DefaultClient}
+	if err := a.heartbeat(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"name": "scanner1", "version": version, "scanner": "masscan 1.3.2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected heartbeat %v, got %v", want, got)
+	}
+
+	a.token = "wrong"
+	if err := a.heartbeat(context.Background()); err == nil {
+		t.Error("expected an error when the server refuses the heartbeat")
+	}
+}
+
+func TestRunFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-server", "scan.example.com", "-ranges", "192.0.2.0/24"},
+		{"-server", "https://scan.example.com"},
+		{"-server", "https://scan.example.com", "-interval", "1h"},
+		{"-server", "https://scan.example.com", "-ranges", "192.0.2.0/24", "-rate", "0"},
+	} {
+		if err := run(args, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+// Command scanctl administers a scan server through its HTTP API, so it can
+// be run from anywhere the API can be reached.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+const usage = `Usage: scanctl [flags] command [arguments]
+
+Commands:
+  results [key=value...]              Search results, with the index page's search parameters
+  export [-format csv] [-o file] [key=value...]
+                                      Export results as JSON or CSV
+  import [-agent name] [-scope ranges] [-ports ports] file...
+                                      Submit masscan -oJ output, or - for stdin
+  purge cidr                          Delete every result in a CIDR
+  tokens                              List API tokens
+  tokens create -name name -scope scope [-email email] [-cidrs cidr,...]
+                                      Create an API token, printing it once
+  tokens revoke id                    Revoke an API token
+  tasks                               List scheduled tasks
+  tasks run name                      Run a scheduled task now
+  audit [-format csv] [key=value...]  Show the audit log
+
+Flags:
+`
+
+// client makes requests to the scan server's API.
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// do makes a request to the server, returning the response if it succeeded
+// or the server's error message if it didn't.
+func (c *client) do(method, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	u := c.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "scanctl")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return res, nil
+}
+
+// print makes a request and writes the response to out, indenting JSON.
+func (c *client) print(out io.Writer, method, path string, query url.Values, body io.Reader) error {
+	res, err := c.do(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		_, err := io.Copy(out, res.Body)
+		return err
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err = buf.WriteTo(out)
+	return err
+}
+
+// searchParams parses key=value arguments as query parameters.
+func searchParams(args []string) (url.Values, error) {
+	q := make(url.Values)
+	for _, arg := range args {
+		i := strings.Index(arg, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("expected key=value, got %q", arg)
+		}
+		q.Add(arg[:i], arg[i+1:])
+	}
+	return q, nil
+}
+
+// formatParams parses the flags of commands which take a -format and search
+// parameters.
+func formatParams(name string, args []string, out io.Writer, file *string) (url.Values, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	format := fs.String("format", "json", "Output `format`, json or csv")
+	if file != nil {
+		fs.StringVar(file, "o", "", "Write to `file` rather than stdout")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	q, err := searchParams(fs.Args())
+	if err != nil {
+		return nil, err
+	}
+	switch *format {
+	case "json":
+	case "csv":
+		q.Set("format", "csv")
+	default:
+		return nil, fmt.Errorf("unknown format %q", *format)
+	}
+	return q, nil
+}
+
+func (c *client) export(args []string, out io.Writer) error {
+	var file string
+	q, err := formatParams("export", args, out, &file)
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		return c.print(out, "GET", "/api/v1/results", q, nil)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := c.print(f, "GET", "/api/v1/results", q, nil); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// importFiles submits masscan output from each file in turn, or stdin for -.
+// Results without a timestamp are sent as seen when the file was written. The
+// server refuses results older than its -results.maxage, which are loaded with
+// scan import instead. Ports are only closed if the scans' scope is given.
+func (c *client) importFiles(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(out)
+	agent := fs.String("agent", "", "Agent `name` to submit the results as")
+	scope := fs.String("scope", "", "Comma-separated `ranges` the scans covered")
+	ports := fs.String("ports", "", "`Ports` the scans covered, in masscan's -p syntax, if not all")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("import: no files given")
+	}
+	for _, name := range fs.Args() {
+		f := os.Stdin
+		if name != "-" {
+			var err error
+			if f, err = os.Open(name); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequest("POST", c.server+"/results", scan.MasscanJSON(f))
+		if err != nil {
+			f.Close()
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "scanctl")
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		if *agent != "" {
+			req.Header.Set("X-Agent", *agent)
+		}
+		if *scope != "" {
+			req.Header.Set("X-Scan-Scope", *scope)
+		}
+		if *ports != "" {
+			req.Header.Set("X-Scan-Ports", *ports)
+		}
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			req.Header.Set("X-Scan-Time", strconv.FormatInt(info.ModTime().Unix(), 10))
+		}
+		res, err := c.http.Do(req)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		if res.StatusCode >= 300 {
+			return fmt.Errorf("%s: %s: %s", name, res.Status, strings.TrimSpace(string(msg)))
+		}
+		fmt.Fprintf(out, "%s: %s\n", name, res.Status)
+	}
+	return nil
+}
+
+func (c *client) tokens(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return c.print(out, "GET", "/api/v1/tokens", nil, nil)
+	}
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("tokens create", flag.ContinueOnError)
+		fs.SetOutput(out)
+		var t scan.APIToken
+		fs.StringVar(&t.Name, "name", "", "`Name` describing the token")
+		fs.StringVar(&t.Scope, "scope", "", "`Scope` of the token: ingest, read or admin")
+		fs.StringVar(&t.Email, "email", "", "`Email` of the user the token acts as (default you; anyone else needs another admin's approval)")
+		cidrs := fs.String("cidrs", "", "Comma-separated `CIDRs` to restrict the token to")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		for _, cidr := range strings.Split(*cidrs, ",") {
+			if cidr = strings.TrimSpace(cidr); cidr != "" {
+				t.CIDRs = append(t.CIDRs, cidr)
+			}
+		}
+		body, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return c.print(out, "POST", "/api/v1/tokens", nil, bytes.NewReader(body))
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("usage: tokens revoke id")
+		}
+		return c.print(out, "DELETE", "/api/v1/tokens/"+url.PathEscape(args[1]), nil, nil)
+	}
+	return fmt.Errorf("unknown tokens command %q", args[0])
+}
+
+func (c *client) tasks(args []string, out io.Writer) error {
+	switch {
+	case len(args) == 0:
+		return c.print(out, "GET", "/api/v1/tasks", nil, nil)
+	case len(args) == 2 && args[0] == "run":
+		return c.print(out, "POST", "/api/v1/tasks/"+url.PathEscape(args[1]), nil, nil)
+	}
+	return errors.New("usage: tasks [run name]")
+}
+
+func run(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("scanctl", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	server := fs.String("server", os.Getenv("SCAN_SERVER"), "`URL` of the scan server (default $SCAN_SERVER)")
+	token := fs.String("token", os.Getenv("SCAN_TOKEN"), "API `token` to authenticate with (default $SCAN_TOKEN)")
+	tokenFile := fs.String("token.file", "", "Read the API token from this `file`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *server == "" {
+		return errors.New("-server is required")
+	}
+	u, err := url.Parse(*server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid -server %q", *server)
+	}
+	if *tokenFile != "" {
+		b, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			return err
+		}
+		*token = strings.TrimSpace(string(b))
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	c := &client{
+		server: strings.TrimSuffix(*server, "/"),
+		token:  *token,
+		http:   &http.Client{Timeout: 10 * time.Minute},
+	}
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "results":
+		q, err := searchParams(args)
+		if err != nil {
+			return err
+		}
+		return c.print(out, "GET", "/api/v1/results", q, nil)
+	case "export":
+		return c.export(args, out)
+	case "import":
+		return c.importFiles(args, out)
+	case "purge":
+		if len(args) != 1 {
+			return errors.New("usage: purge cidr")
+		}
+		return c.print(out, "DELETE", "/api/v1/results", url.Values{"cidr": {args[0]}}, nil)
+	case "tokens":
+		return c.tokens(args, out)
+	case "tasks":
+		return c.tasks(args, out)
+	case "audit":
+		q, err := formatParams("audit", args, out, nil)
+		if err != nil {
+			return err
+		}
+		return c.print(out, "GET", "/api/v1/audit", q, nil)
+	}
+	fs.Usage()
+	return fmt.Errorf("unknown command %q", cmd)
+}
+
+func main() {
+	log.SetFlags(0)
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		log.Fatal(err)
+	}
+}
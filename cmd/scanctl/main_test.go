@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+const masscanOutput = `{   "ip": "192.0.2.1",   "timestamp": "1600000000", "ports": [ {"port": 22, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+{finished: 1}
+`
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scanctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "masscan.json")
+	if err := ioutil.WriteFile(input, []byte(masscanOutput), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	var body []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		got = append(got, r.Method+" "+r.URL.RequestURI())
+		body, _ = ioutil.ReadAll(r.Body)
+		header = r.Header
+		switch {
+		case r.URL.Path == "/api/v1/tasks/missing":
+			http.Error(w, "Task not found", http.StatusNotFound)
+		case r.URL.Query().Get("format") == "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Write([]byte("ip,port\n192.0.2.1,22\n"))
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write([]byte(`{"ok":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		args []string
+		want string
+		out  string
+	}{
+		{[]string{"results", "ip=192.0.2.1", "port=22"}, "GET /api/v1/results?ip=192.0.2.1&port=22", "{\n  \"ok\": true\n}\n"},
+		{[]string{"export", "-format", "csv", "tag=web"}, "GET /api/v1/results?format=csv&tag=web", "ip,port\n192.0.2.1,22\n"},
+		{[]string{"purge", "192.0.2.0/24"}, "DELETE /api/v1/results?cidr=192.0.2.0%2F24", ""},
+		{[]string{"tokens"}, "GET /api/v1/tokens", ""},
+		{[]string{"tokens", "create", "-name", "dmz", "-scope", "ingest", "-cidrs", "192.0.2.0/24, 198.51.100.0/24"}, "POST /api/v1/tokens", ""},
+		{[]string{"tokens", "revoke", "3"}, "DELETE /api/v1/tokens/3", ""},
+		{[]string{"tasks", "run", "prune"}, "POST /api/v1/tasks/prune", ""},
+		{[]string{"audit", "action=purge_results"}, "GET /api/v1/audit?action=purge_results", ""},
+		{[]string{"import", "-agent", "dmz", "-scope", "192.0.2.0/24", "-ports", "22", input}, "POST /results", ""},
+	} {
+		got = nil
+		var out bytes.Buffer
+		if err := run(append([]string{"-server", srv.URL, "-token", "secret"}, tt.args...), &out); err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		if len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%v: expected request %q, got %q", tt.args, tt.want, got)
+		}
+		if tt.out != "" && out.String() != tt.out {
+			t.Errorf("%v: expected output %q, got %q", tt.args, tt.out, out.String())
+		}
+		switch tt.args[0] {
+		case "tokens":
+			if len(tt.args) > 1 && tt.args[1] == "create" {
+				var tok scan.APIToken
+				json.Unmarshal(body, &tok)
+				if tok.Name != "dmz" || tok.Scope != "ingest" || len(tok.CIDRs) != 2 || tok.CIDRs[1] != "198.51.100.0/24" {
+					t.Errorf("unexpected token %s", body)
+				}
+			}
+		case "import":
+			var results []scan.Result
+			if err := json.Unmarshal(body, &results); err != nil || len(results) != 1 || results[0].IP != "192.0.2.1" {
+				t.Errorf("expected masscan output fixed up, got %s (%v)", body, err)
+			}
+			if header.Get("X-Scan-Scope") != "192.0.2.0/24" || header.Get("X-Scan-Ports") != "22" {
+				t.Errorf("expected the scan's scope sent, got %v", header)
+			}
+		}
+	}
+
+	err = run([]string{"-server", srv.URL, "-token", "secret", "tasks", "run", "missing"}, ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "Task not found") {
+		t.Errorf("expected the server's error, got %v", err)
+	}
+	err = run([]string{"-server", srv.URL, "results"}, ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+}
+
+func TestRunFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"results"},
+		{"-server", "scan.example.com", "results"},
+		{"-server", "https://scan.example.com"},
+		{"-server", "https://scan.example.com", "frobnicate"},
+		{"-server", "https://scan.example.com", "purge"},
+		{"-server", "https://scan.example.com", "results", "ip"},
+		{"-server", "https://scan.example.com", "export", "-format", "xml"},
+	} {
+		if err := run(args, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// compliancePolicy is a rule the open ports are checked against. A policy
+// either forbids Ports, optionally only for Proto, or with Baseline requires
+// every open port to be within the baseline.
+type compliancePolicy struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Ports       string `json:"ports,omitempty"`
+	Proto       string `json:"proto,omitempty"`
+	Baseline    bool   `json:"baseline,omitempty"`
+}
+
+// compliancePolicies are the built-in policies, by name.
+var compliancePolicies = map[string]compliancePolicy{
+	"no-telnet-smb-rdp": {
+		Name:        "no-telnet-smb-rdp",
+		Description: "No Telnet, SMB or RDP exposed",
+		Ports:       "23,139,445,3389",
+		Proto:       "tcp",
+	},
+	"no-databases": {
+		Name:        "no-databases",
+		Description: "No database servers exposed",
+		Ports:       "1433,1521,3306,5432,6379,9200,27017",
+		Proto:       "tcp",
+	},
+	"baseline": {
+		Name:        "baseline",
+		Description: "Every open port is within the baseline",
+		Baseline:    true,
+	},
+}
+
+// offends reports whether an open port fails the policy.
+func (p compliancePolicy) offends(r scan.IPInfo) bool {
+	if p.Baseline {
+		return r.Violation
+	}
+	if p.Proto != "" && p.Proto != r.Proto {
+		return false
+	}
+	return scan.PortInRange(p.Ports, r.Port)
+}
+
+// complianceHost is a host failing a policy, with the ports which fail it.
+type complianceHost struct {
+	IP    string   `json:"ip"`
+	Ports []string `json:"ports"`
+}
+
+// complianceNetwork is the result of a policy for one named network.
+type complianceNetwork struct {
+	Network string           `json:"network"`
+	Pass    bool             `json:"pass"`
+	Hosts   []complianceHost `json:"hosts"`
+}
+
+// complianceReport is the result of checking the open ports against a
+// policy, for each named network.
+type complianceReport struct {
+	Policy    compliancePolicy    `json:"policy"`
+	Generated time.Time           `json:"generated"`
+	Pass      bool                `json:"pass"`
+	Networks  []complianceNetwork `json:"networks"`
+}
+
+// unassignedNetwork collects results which aren't in any named network.
+const unassignedNetwork = "Unassigned"
+
+// compliance checks every open port against policy. Results are reported
+// under each named network they're in, or as unassigned. Every named network
+// is listed, but unassigned results only if any are open.
+func (app *App) compliance(policy compliancePolicy, now time.Time) (complianceReport, error) {
+	report := complianceReport{Policy: policy, Generated: now, Pass: true}
+	networks, err := app.db.LoadNetworks()
+	if err != nil {
+		return report, err
+	}
+	data, _, err := app.db.ResultPage(sqlite.ResultQuery{View: sqlite.ViewOpen})
+	if err != nil {
+		return report, err
+	}
+
+	// Offending ports by network, then host
+	offending := make(map[string]map[string][]string)
+	var unassigned bool
+	for _, r := range data.Results {
+		names := r.Networks
+		if len(names) == 0 {
+			names = []string{unassignedNetwork}
+			unassigned = true
+		}
+		if !policy.offends(r) {
+			continue
+		}
+		for _, name := range names {
+			if offending[name] == nil {
+				offending[name] = make(map[string][]string)
+			}
+			offending[name][r.IP] = append(offending[name][r.IP], fmt.Sprintf("%d/%s", r.Port, r.Proto))
+		}
+	}
+
+	var names []string
+	for _, n := range networks {
+		names = append(names, n.Name)
+	}
+	if unassigned {
+		names = append(names, unassignedNetwork)
+	}
+	for _, name := range names {
+		n := complianceNetwork{Network: name, Pass: len(offending[name]) == 0, Hosts: []complianceHost{}}
+		for ip, ports := range offending[name] {
+			n.Hosts = append(n.Hosts, complianceHost{IP: ip, Ports: ports})
+		}
+		sort.Slice(n.Hosts, func(i, j int) bool {
+			return bytes.Compare(scan.IPKey(n.Hosts[i].IP), scan.IPKey(n.Hosts[j].IP)) < 0
+		})
+		if !n.Pass {
+			report.Pass = false
+		}
+		report.Networks = append(report.Networks, n)
+	}
+	if report.Networks == nil {
+		report.Networks = []complianceNetwork{}
+	}
+	return report, nil
+}
+
+// Handler for GET /api/v1/compliance
+// The policy parameter chooses a built-in policy. Otherwise ports, and
+// optionally proto, give the ports which mustn't be open. Without either it
+// lists the built-in policies.
+func (app *App) complianceReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var policy compliancePolicy
+	switch name := q.Get("policy"); {
+	case name != "":
+		var ok bool
+		if policy, ok = compliancePolicies[name]; !ok {
+			http.Error(w, fmt.Sprintf("Unknown policy %q", name), http.StatusBadRequest)
+			return
+		}
+	case q.Get("ports") != "":
+		policy = compliancePolicy{Name: "custom", Ports: q.Get("ports"), Proto: q.Get("proto")}
+		if _, err := scan.ParsePortSpec(policy.Ports); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch policy.Proto {
+		case "", "tcp", "udp":
+		default:
+			http.Error(w, fmt.Sprintf("invalid proto %q", policy.Proto), http.StatusBadRequest)
+			return
+		}
+		policy.Description = "No ports " + policy.Ports + " exposed"
+	default:
+		var policies []compliancePolicy
+		for _, p := range compliancePolicies {
+			policies = append(policies, p)
+		}
+		sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+		render.JSON(w, r, policies)
+		return
+	}
+
+	report, err := app.compliance(policy, time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, report)
+}
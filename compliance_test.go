@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestCompliance(t *testing.T) {
+	db := createDB("TestCompliance")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	for _, n := range []scan.Network{
+		{Name: "DMZ", CIDRs: []string{"192.0.2.0/24"}},
+		{Name: "Office", CIDRs: []string{"198.51.100.0/24"}},
+	} {
+		if err := db.SaveNetwork(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results := []scan.Result{
+		{IP: "192.0.2.9", Ports: []scan.Port{{Port: 3389, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.9", Ports: []scan.Port{{Port: 23, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.10", Ports: []scan.Port{{Port: 445, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "203.0.113.1", Ports: []scan.Port{{Port: 3306, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) (*http.Response, complianceReport) {
+		t.Helper()
+		res, err := http.Get(ts.URL + "/api/v1/compliance?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var report complianceReport
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res, report
+	}
+
+	_, report := get("policy=no-telnet-smb-rdp")
+	if report.Pass || len(report.Networks) != 3 {
+		t.Fatalf("expected a failing report on DMZ, Office and unassigned, got %+v", report)
+	}
+	dmz, office, unassigned := report.Networks[0], report.Networks[1], report.Networks[2]
+	if dmz.Pass || len(dmz.Hosts) != 2 || dmz.Hosts[0].IP != "192.0.2.9" || len(dmz.Hosts[0].Ports) != 2 || dmz.Hosts[1].IP != "192.0.2.10" {
+		t.Errorf("expected DMZ to fail on 192.0.2.9 and 192.0.2.10, got %+v", dmz)
+	}
+	if !office.Pass || len(office.Hosts) != 0 {
+		t.Errorf("expected Office to pass, got %+v", office)
+	}
+	if unassigned.Network != unassignedNetwork || !unassigned.Pass {
+		t.Errorf("expected unassigned results to pass, got %+v", unassigned)
+	}
+
+	_, report = get("policy=no-databases")
+	if report.Pass || report.Networks[2].Pass || report.Networks[2].Hosts[0].IP != "203.0.113.1" {
+		t.Errorf("expected 203.0.113.1 to fail no-databases, got %+v", report)
+	}
+
+	_, report = get("ports=22&proto=tcp")
+	if report.Policy.Name != "custom" || report.Pass || report.Networks[1].Pass {
+		t.Errorf("expected Office to fail a custom policy forbidding SSH, got %+v", report)
+	}
+
+	// An empty baseline is never violated
+	_, report = get("policy=baseline")
+	if !report.Pass {
+		t.Errorf("expected an empty baseline to pass, got %+v", report)
+	}
+
+	for _, query := range []string{"policy=nonsense", "ports=22-", "ports=22&proto=icmp"} {
+		res, _ := get(query)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, res.StatusCode)
+		}
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/compliance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policies []compliancePolicy
+	if err := json.NewDecoder(res.Body).Decode(&policies); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(policies) != len(compliancePolicies) || policies[0].Name != "baseline" {
+		t.Errorf("expected the built-in policies in name order, got %+v", policies)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadConfig sets flags from a configuration file. The file is a simple
+// subset of TOML: each key is a flag name, and a [table] header prefixes the
+// keys after it, so
+//
+//	[ldap]
+//	url = "ldaps://ldap.example.com"
+//
+// sets -ldap.url. An array of strings sets a comma-separated flag. Flags given
+// on the command line override the file.
+func loadConfig(path string, fs *flag.FlagSet) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var table string
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		name := strings.TrimSpace(line[:eq])
+		if table != "" {
+			name = table + "." + name
+		}
+		value, err := configValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", path, n, name, err)
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s:%d: unknown setting %s", path, n, name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", path, n, name, err)
+		}
+	}
+	return s.Err()
+}
+
+// configValue parses a TOML value into a flag value. Strings may be quoted
+// with double quotes, with escapes, or single quotes, without. Arrays are
+// joined with commas. Anything else, such as a number or boolean, is used as
+// it is, less any trailing comment.
+func configValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		for i := 1; i < len(v); i++ {
+			switch v[i] {
+			case '\\':
+				i++
+			case '"':
+				return strconv.Unquote(v[:i+1])
+			}
+		}
+		return "", fmt.Errorf("unterminated string %s", v)
+	case strings.HasPrefix(v, "'"):
+		end := strings.Index(v[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		return v[1 : end+1], nil
+	case strings.HasPrefix(v, "["):
+		end := strings.LastIndex(v, "]")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated array %s", v)
+		}
+		var items []string
+		for _, item := range strings.Split(v[1:end], ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	if i := strings.Index(v, "#"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v, nil
+}
+
+// envName returns the environment variable for a flag, e.g. SCAN_HTTP_ADDR
+// for -http.addr.
+func envName(flag string) string {
+	return "SCAN_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(flag))
+}
+
+// loadEnv sets flags from SCAN_* environment variables, using lookup to read
+// them, or from the file named by SCAN_*_FILE. Flags given on the command line
+// override the environment.
+func loadEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		v, ok := lookup(envName(f.Name))
+		if !ok {
+			// Secrets mounted as files, as in Kubernetes, are read
+			// from the file named by SCAN_*_FILE
+			path, ok := lookup(envName(f.Name) + "_FILE")
+			if !ok {
+				return
+			}
+			b, e := ioutil.ReadFile(path)
+			if e != nil {
+				err = fmt.Errorf("%s_FILE: %v", envName(f.Name), e)
+				return
+			}
+			v = strings.TrimRight(string(b), "\r\n")
+		}
+		if e := fs.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("%s: %v", envName(f.Name), e)
+		}
+	})
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scan.toml")
+	config := `# Scan configuration
+retention = "2160h"
+no-auth = false
+
+[ldap]
+url = "ldaps://ldap.example.com" # the directory
+userdn = 'uid=%s,ou=people,dc=example,dc=com'
+
+[smtp]
+to = ["security@example.com", "noc@example.com"]
+port = 587
+`
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	retention := fs.Duration("retention", 0, "")
+	noAuth := fs.Bool("no-auth", true, "")
+	ldapURL := fs.String("ldap.url", "", "")
+	ldapUserDN := fs.String("ldap.userdn", "", "")
+	smtpTo := fs.String("smtp.to", "", "")
+	smtpPort := fs.Int("smtp.port", 25, "")
+	if err := fs.Parse([]string{"-smtp.port", "2525"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadConfig(path, fs); err != nil {
+		t.Fatal(err)
+	}
+	if *retention != 2160*time.Hour || *noAuth {
+		t.Errorf("unexpected retention %v and no-auth %v", *retention, *noAuth)
+	}
+	if *ldapURL != "ldaps://ldap.example.com" || *ldapUserDN != "uid=%s,ou=people,dc=example,dc=com" {
+		t.Errorf("unexpected LDAP settings %q %q", *ldapURL, *ldapUserDN)
+	}
+	if *smtpTo != "security@example.com,noc@example.com" {
+		t.Errorf("unexpected recipients %q", *smtpTo)
+	}
+	if *smtpPort != 2525 {
+		t.Errorf("expected the command line to override the file, got port %d", *smtpPort)
+	}
+
+	for _, bad := range []string{"unknown = 1", "[ldap]\nport = 1", "retention = soon", "retention", `ldap.url = "unterminated`} {
+		if err := ioutil.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+		fs.Duration("retention", 0, "")
+		fs.String("ldap.url", "", "")
+		if err := loadConfig(path, fs); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	env := map[string]string{
+		"SCAN_HTTP_ADDR":     ":8080",
+		"SCAN_NO_AUTH":       "true",
+		"SCAN_METRICS_ADDR":  ":9000",
+		"SCAN_WEBHOOK_URL":   "https://example.com/hook",
+		"SCAN_UNKNOWN_THING": "ignored",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	httpAddr := fs.String("http.addr", ":80", "")
+	noAuth := fs.Bool("no-auth", false, "")
+	metricsAddr := fs.String("metrics.addr", "localhost:3000", "")
+	retention := fs.Duration("retention", 0, "")
+	if err := fs.Parse([]string{"-metrics.addr", ":3001"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadEnv(fs, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if *httpAddr != ":8080" || !*noAuth || *retention != 0 {
+		t.Errorf("unexpected settings %q %v %v", *httpAddr, *noAuth, *retention)
+	}
+	if *metricsAddr != ":3001" {
+		t.Errorf("expected the command line to override the environment, got %q", *metricsAddr)
+	}
+
+	// Secrets can be read from files
+	f, err := ioutil.TempFile("", "scan-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("s3cret\n")
+	f.Close()
+	env["SCAN_SMTP_PASSWORD_FILE"] = f.Name()
+	fs = flag.NewFlagSet("scan", flag.ContinueOnError)
+	password := fs.String("smtp.password", "", "")
+	if err := loadEnv(fs, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if *password != "s3cret" {
+		t.Errorf("expected the password from the file, got %q", *password)
+	}
+	env["SCAN_SMTP_PASSWORD_FILE"] = f.Name() + ".missing"
+	fs = flag.NewFlagSet("scan", flag.ContinueOnError)
+	fs.String("smtp.password", "", "")
+	if err := loadEnv(fs, lookup); err == nil {
+		t.Error("expected an error for a missing secret file")
+	}
+	delete(env, "SCAN_SMTP_PASSWORD_FILE")
+
+	env["SCAN_RETENTION"] = "soon"
+	fs = flag.NewFlagSet("scan", flag.ContinueOnError)
+	fs.Duration("retention", 0, "")
+	if err := loadEnv(fs, lookup); err == nil {
+		t.Error("expected an error for an invalid duration")
+	}
+}
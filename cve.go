@@ -0,0 +1,438 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// bannerProduct recognises a product's version in banners. vendor and
+// product are as they appear in CPE names.
+type bannerProduct struct {
+	re      *regexp.Regexp
+	vendor  string
+	product string
+}
+
+// bannerProducts are the products whose versions are looked for in banners.
+// Each regexp's first group is the version.
+var bannerProducts = []bannerProduct{
+	{regexp.MustCompile(`OpenSSH[_ ]([0-9]+\.[0-9]+(?:\.[0-9]+)?(?:p[0-9]+)?)`), "openbsd", "openssh"},
+	{regexp.MustCompile(`dropbear[_ ]([0-9]+\.[0-9]+)`), "dropbear_ssh_project", "dropbear_ssh"},
+	{regexp.MustCompile(`Apache/([0-9]+\.[0-9]+\.[0-9]+)`), "apache", "http_server"},
+	{regexp.MustCompile(`nginx/([0-9]+\.[0-9]+\.[0-9]+)`), "nginx", "nginx"},
+	{regexp.MustCompile(`Microsoft-IIS/([0-9]+\.[0-9]+)`), "microsoft", "internet_information_services"},
+	{regexp.MustCompile(`lighttpd/([0-9]+\.[0-9]+\.[0-9]+)`), "lighttpd", "lighttpd"},
+	{regexp.MustCompile(`vsFTPd ([0-9]+\.[0-9]+\.[0-9]+)`), "beasts", "vsftpd"},
+	{regexp.MustCompile(`ProFTPD ([0-9]+\.[0-9]+\.[0-9]+[a-z]?)`), "proftpd", "proftpd"},
+	{regexp.MustCompile(`Exim ([0-9]+\.[0-9]+(?:\.[0-9]+)?)`), "exim", "exim"},
+	{regexp.MustCompile(`OpenSSL/([0-9]+\.[0-9]+\.[0-9]+[a-z]?)`), "openssl", "openssl"},
+	{regexp.MustCompile(`PHP/([0-9]+\.[0-9]+\.[0-9]+)`), "php", "php"},
+}
+
+// bannerVersion is a product and version found in a banner.
+type bannerVersion struct {
+	vendor, product, version string
+}
+
+// parseBanner returns the products and versions in banner.
+func parseBanner(banner string) []bannerVersion {
+	var found []bannerVersion
+	for _, p := range bannerProducts {
+		if m := p.re.FindStringSubmatch(banner); m != nil {
+			found = append(found, bannerVersion{p.vendor, p.product, m[1]})
+		}
+	}
+	return found
+}
+
+// versionParts splits a version into runs of digits and of other
+// characters, ignoring separators, so "7.4p1" is 7, 4, p, 1.
+func versionParts(v string) []string {
+	var parts []string
+	var cur strings.Builder
+	digit := false
+	for _, r := range v {
+		isDigit := r >= '0' && r <= '9'
+		isSep := r == '.' || r == '-' || r == '_' || r == '+'
+		if cur.Len() > 0 && (isSep || isDigit != digit) {
+			parts = append(parts, cur.String())
+			cur.Reset()
+		}
+		if !isSep {
+			cur.WriteRune(r)
+			digit = isDigit
+		}
+	}
+	if cur.Len() > 0 {
+		parts = append(parts, cur.String())
+	}
+	return parts
+}
+
+// compareVersions returns -1, 0 or 1 if a is older than, the same as or
+// newer than b. Numeric parts compare as numbers, and a version with extra
+// parts is newer, so 7.4p1 is newer than 7.4.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case errA == nil:
+			// Numbers sort after letters, so 1.0.2 is newer than 1.0a
+			return 1
+		case errB == nil:
+			return -1
+		case pa[i] != pb[i]:
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(pa) < len(pb):
+		return -1
+	case len(pa) > len(pb):
+		return 1
+	}
+	return 0
+}
+
+// cveRange is a range of a product's versions affected by a CVE. Version is
+// an exact version, or the range is given by the start and end bounds.
+type cveRange struct {
+	CVE      string
+	Severity string
+	Score    float64
+	Summary  string
+
+	Version                        string
+	StartIncluding, StartExcluding string
+	EndIncluding, EndExcluding     string
+}
+
+// affects reports whether version is in the range.
+func (r cveRange) affects(version string) bool {
+	if r.Version != "" {
+		return compareVersions(version, r.Version) == 0
+	}
+	if r.StartIncluding != "" && compareVersions(version, r.StartIncluding) < 0 {
+		return false
+	}
+	if r.StartExcluding != "" && compareVersions(version, r.StartExcluding) <= 0 {
+		return false
+	}
+	if r.EndIncluding != "" && compareVersions(version, r.EndIncluding) > 0 {
+		return false
+	}
+	if r.EndExcluding != "" && compareVersions(version, r.EndExcluding) >= 0 {
+		return false
+	}
+	return true
+}
+
+// nvdFeed is the part of an NVD JSON 1.1 feed which is used.
+type nvdFeed struct {
+	Items []struct {
+		CVE struct {
+			Meta struct {
+				ID string `json:"ID"`
+			} `json:"CVE_data_meta"`
+			Description struct {
+				Data []struct {
+					Value string `json:"value"`
+				} `json:"description_data"`
+			} `json:"description"`
+		} `json:"cve"`
+		Configurations struct {
+			Nodes []nvdNode `json:"nodes"`
+		} `json:"configurations"`
+		Impact struct {
+			V3 struct {
+				CVSS struct {
+					BaseScore    float64 `json:"baseScore"`
+					BaseSeverity string  `json:"baseSeverity"`
+				} `json:"cvssV3"`
+			} `json:"baseMetricV3"`
+			V2 struct {
+				CVSS struct {
+					BaseScore float64 `json:"baseScore"`
+				} `json:"cvssV2"`
+				Severity string `json:"severity"`
+			} `json:"baseMetricV2"`
+		} `json:"impact"`
+	} `json:"CVE_Items"`
+}
+
+// nvdNode is a node of a CVE's configurations, which may have children.
+type nvdNode struct {
+	Children []nvdNode `json:"children"`
+	CPEMatch []struct {
+		Vulnerable            bool   `json:"vulnerable"`
+		CPE                   string `json:"cpe23Uri"`
+		VersionStartIncluding string `json:"versionStartIncluding"`
+		VersionStartExcluding string `json:"versionStartExcluding"`
+		VersionEndIncluding   string `json:"versionEndIncluding"`
+		VersionEndExcluding   string `json:"versionEndExcluding"`
+	} `json:"cpe_match"`
+}
+
+// cveIndex holds the version ranges affected by CVEs, by vendor:product.
+type cveIndex map[string][]cveRange
+
+// add indexes the vulnerable CPEs in node and its children for a CVE.
+func (idx cveIndex) add(node nvdNode, cve cveRange) {
+	for _, child := range node.Children {
+		idx.add(child, cve)
+	}
+	for _, m := range node.CPEMatch {
+		// cpe:2.3:part:vendor:product:version:...
+		fields := strings.Split(m.CPE, ":")
+		if !m.Vulnerable || len(fields) < 6 {
+			continue
+		}
+		r := cve
+		r.StartIncluding, r.StartExcluding = m.VersionStartIncluding, m.VersionStartExcluding
+		r.EndIncluding, r.EndExcluding = m.VersionEndIncluding, m.VersionEndExcluding
+		switch version := fields[5]; version {
+		case "*", "-":
+			// Every version of the product is too broad to be useful
+			if r.StartIncluding == "" && r.StartExcluding == "" && r.EndIncluding == "" && r.EndExcluding == "" {
+				continue
+			}
+		default:
+			r.Version = version
+		}
+		key := fields[3] + ":" + fields[4]
+		idx[key] = append(idx[key], r)
+	}
+}
+
+// readFeed adds the CVEs in an NVD JSON 1.1 feed, which may be gzipped.
+func (idx cveIndex) readFeed(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var feed nvdFeed
+	if err := json.NewDecoder(r).Decode(&feed); err != nil {
+		return err
+	}
+	for _, item := range feed.Items {
+		cve := cveRange{CVE: item.CVE.Meta.ID}
+		if len(item.CVE.Description.Data) > 0 {
+			cve.Summary = item.CVE.Description.Data[0].Value
+		}
+		if v3 := item.Impact.V3.CVSS; v3.BaseSeverity != "" {
+			cve.Severity, cve.Score = v3.BaseSeverity, v3.BaseScore
+		} else {
+			cve.Severity, cve.Score = item.Impact.V2.Severity, item.Impact.V2.CVSS.BaseScore
+		}
+		for _, node := range item.Configurations.Nodes {
+			idx.add(node, cve)
+		}
+	}
+	return nil
+}
+
+// match returns the CVEs affecting a product's version.
+func (idx cveIndex) match(v bannerVersion) []cveRange {
+	var matches []cveRange
+	seen := make(map[string]bool)
+	for _, r := range idx[v.vendor+":"+v.product] {
+		if !seen[r.CVE] && r.affects(v.version) {
+			matches = append(matches, r)
+			seen[r.CVE] = true
+		}
+	}
+	return matches
+}
+
+// cveMatcher matches banners against the NVD feeds in a directory.
+type cveMatcher struct {
+	dir string
+
+	mu      sync.Mutex
+	index   cveIndex
+	modTime time.Time
+}
+
+func newCVEMatcher(dir string) *cveMatcher {
+	return &cveMatcher{dir: dir}
+}
+
+// feeds returns the NVD feeds in the directory, and when the newest was
+// modified.
+func (m *cveMatcher) feeds() ([]string, time.Time, error) {
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var paths []string
+	var newest time.Time
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || !(strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz")) {
+			continue
+		}
+		paths = append(paths, filepath.Join(m.dir, name))
+		if fi.ModTime().After(newest) {
+			newest = fi.ModTime()
+		}
+	}
+	return paths, newest, nil
+}
+
+// reload reads the feeds again if any have changed since they were last
+// read.
+func (m *cveMatcher) reload() error {
+	paths, newest, err := m.feeds()
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.index != nil && !newest.After(m.modTime) {
+		return nil
+	}
+	idx := make(cveIndex)
+	for _, path := range paths {
+		if err := idx.readFeed(path); err != nil {
+			return err
+		}
+	}
+	m.index = idx
+	m.modTime = newest
+	return nil
+}
+
+// match returns the vulnerabilities of the products in banners.
+func (m *cveMatcher) match(banners []scan.Banner) []scan.Vulnerability {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var vulns []scan.Vulnerability
+	for _, b := range banners {
+		for _, v := range parseBanner(b.Banner) {
+			for _, r := range m.index.match(v) {
+				vulns = append(vulns, scan.Vulnerability{
+					IP:       b.IP,
+					Port:     b.Port,
+					Proto:    b.Proto,
+					Product:  v.product,
+					Version:  v.version,
+					CVE:      r.CVE,
+					Severity: strings.ToUpper(r.Severity),
+					Score:    r.Score,
+					Summary:  r.Summary,
+				})
+			}
+		}
+	}
+	return vulns
+}
+
+// matchCVEs replaces the stored vulnerabilities with those found by matching
+// the latest banner of every port against the CVE feeds, reading the feeds
+// again first if they've changed.
+func (app *App) matchCVEs() {
+	if !app.leading() {
+		return
+	}
+	if err := app.cves.reload(); err != nil {
+		log.Println("cve: error reading feeds:", err)
+		return
+	}
+	banners, err := app.db.LoadCurrentBanners()
+	if err != nil {
+		log.Println("cve: error loading banners:", err)
+		return
+	}
+	vulns := app.cves.match(banners)
+	if err := app.db.SaveVulnerabilities(vulns); err != nil {
+		log.Println("cve: error saving vulnerabilities:", err)
+		return
+	}
+	if verbose {
+		log.Printf("cve: matched %d banners, found %d vulnerabilities", len(banners), len(vulns))
+	}
+}
+
+// Handler for GET /api/v1/vulnerabilities
+// This lists the CVEs open ports may be vulnerable to, optionally only those
+// at least as severe as severity, or those on ip.
+func (app *App) listVulnerabilities(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := sqlite.ResultQuery{IP: q.Get("ip")}
+	if severity := q.Get("severity"); severity != "" {
+		query.Severity = strings.ToUpper(severity)
+		if !sqlite.ValidSeverity(query.Severity) {
+			http.Error(w, "invalid severity", http.StatusBadRequest)
+			return
+		}
+	}
+	data, _, err := app.db.ResultPage(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	open := make(map[string]bool)
+	for _, res := range data.Results {
+		if res.CVEs > 0 {
+			open[portKey(res.IP, res.Port, res.Proto)] = true
+		}
+	}
+	all, err := app.db.LoadVulnerabilities(sqlite.SQLFilter{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	vulns := []scan.Vulnerability{}
+	for _, v := range all {
+		if open[portKey(v.IP, v.Port, v.Proto)] && (query.Severity == "" || severityAtLeast(v.Severity, query.Severity)) {
+			vulns = append(vulns, v)
+		}
+	}
+	render.JSON(w, r, vulns)
+}
+
+// severityRank orders CVSS severities.
+var severityRank = map[string]int{"LOW": 1, "MEDIUM": 2, "HIGH": 3, "CRITICAL": 4}
+
+// severityAtLeast reports whether severity s is at least min.
+func severityAtLeast(s, min string) bool {
+	return severityRank[s] >= severityRank[min]
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestParseBanner(t *testing.T) {
+	tests := []struct {
+		banner string
+		want   []bannerVersion
+	}{
+		{"SSH-2.0-OpenSSH_7.4p1 Debian-10+deb9u7", []bannerVersion{{"openbsd", "openssh", "7.4p1"}}},
+		{"Apache/2.4.29 (Ubuntu) OpenSSL/1.0.2k PHP/7.2.24", []bannerVersion{
+			{"apache", "http_server", "2.4.29"}, {"openssl", "openssl", "1.0.2k"}, {"php", "php", "7.2.24"}}},
+		{"220 (vsFTPd 2.3.4)", []bannerVersion{{"beasts", "vsftpd", "2.3.4"}}},
+		{"HTTP/1.1 200 OK\r\nServer: nginx", nil},
+	}
+	for _, tt := range tests {
+		if got := parseBanner(tt.banner); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseBanner(%q) = %v, want %v", tt.banner, got, tt.want)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"7.4", "7.4", 0},
+		{"7.4", "7.10", -1},
+		{"7.4p1", "7.4", 1},
+		{"7.4p1", "7.4p2", -1},
+		{"1.0.2k", "1.0.2l", -1},
+		{"1.0.2k", "1.0.2", 1},
+		{"2.4.29", "2.4.3", 1},
+		{"8.0", "7.9p1", 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// nvdFeedJSON is an NVD JSON 1.1 feed with an OpenSSH CVE affecting versions
+// before 7.7, and a vsftpd CVE affecting exactly 2.3.4.
+const nvdFeedJSON = `{"CVE_data_type":"CVE","CVE_Items":[
+	{"cve":{"CVE_data_meta":{"ID":"CVE-2018-15473"},"description":{"description_data":[{"lang":"en","value":"OpenSSH through 7.7 is prone to a user enumeration vulnerability."}]}},
+	 "configurations":{"nodes":[{"operator":"OR","cpe_match":[{"vulnerable":true,"cpe23Uri":"cpe:2.3:a:openbsd:openssh:*:*:*:*:*:*:*:*","versionEndIncluding":"7.7"}]}]},
+	 "impact":{"baseMetricV3":{"cvssV3":{"baseScore":5.3,"baseSeverity":"MEDIUM"}},"baseMetricV2":{"cvssV2":{"baseScore":5.0},"severity":"MEDIUM"}}},
+	{"cve":{"CVE_data_meta":{"ID":"CVE-2011-2523"},"description":{"description_data":[{"lang":"en","value":"vsftpd 2.3.4 contains a backdoor."}]}},
+	 "configurations":{"nodes":[{"operator":"OR","cpe_match":[{"vulnerable":true,"cpe23Uri":"cpe:2.3:a:beasts:vsftpd:2.3.4:*:*:*:*:*:*:*"}]}]},
+	 "impact":{"baseMetricV3":{"cvssV3":{"baseScore":9.8,"baseSeverity":"CRITICAL"}}}},
+	{"cve":{"CVE_data_meta":{"ID":"CVE-2000-0001"},"description":{"description_data":[{"lang":"en","value":"Every version."}]}},
+	 "configurations":{"nodes":[{"operator":"OR","cpe_match":[{"vulnerable":true,"cpe23Uri":"cpe:2.3:a:openbsd:openssh:*:*:*:*:*:*:*:*"}]}]},
+	 "impact":{"baseMetricV2":{"cvssV2":{"baseScore":10.0},"severity":"HIGH"}}}
+]}`
+
+func TestCVEMatch(t *testing.T) {
+	db := createDB("TestCVEMatch")
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "cve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "nvdcve-1.1-2018.json"), []byte(nvdFeedJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := App{db: db, cves: newCVEMatcher(dir)}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	// Masscan reports banners separately from open ports
+	service := func(port int, name, banner string) scan.Port {
+		p := scan.Port{Port: port, Proto: "tcp"}
+		p.Service.Name = name
+		p.Service.Banner = banner
+		return p
+	}
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{service(22, "ssh", "SSH-2.0-OpenSSH_7.4")}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 21, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{service(21, "ftp", "220 (vsFTPd 2.3.4)")}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{service(22, "ssh", "SSH-2.0-OpenSSH_8.0")}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	app.matchCVEs()
+	vulns, err := db.LoadVulnerabilities(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range vulns {
+		got = append(got, v.IP+" "+v.CVE+" "+v.Severity)
+	}
+	// CVE-2000-0001 affects every version so is too broad to match
+	want := []string{"192.0.2.1 CVE-2011-2523 CRITICAL", "192.0.2.1 CVE-2018-15473 MEDIUM"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected vulnerabilities %v, got %v", want, got)
+	}
+
+	for _, tt := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"cve": {"cve-2018-15473"}}, 1},
+		{url.Values{"severity": {"high"}}, 1},
+		{url.Values{"q": {"severity:medium"}}, 2},
+		{url.Values{}, 3},
+	} {
+		query, err := resultQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _, err := db.ResultPage(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results) != tt.want {
+			t.Errorf("%v: expected %d results, got %d", tt.query, tt.want, len(data.Results))
+		}
+		for _, r := range data.Results {
+			if r.Port == 21 && (r.CVEs != 1 || r.Severity != "CRITICAL") {
+				t.Errorf("%v: expected 21/tcp to have 1 critical CVE, got %+v", tt.query, r)
+			}
+		}
+	}
+	if _, err := resultQuery(url.Values{"severity": {"extreme"}}); err == nil {
+		t.Error("expected an error for an invalid severity")
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/vulnerabilities?severity=critical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(b), "CVE-2011-2523") || strings.Contains(string(b), "CVE-2018-15473") {
+		t.Errorf("expected only the critical vulnerability, got %s", b)
+	}
+
+	res, err = http.Get(ts.URL + "/host/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	for _, want := range []string{"CVE-2018-15473", "openssh 7.4", "vsftpd 2.3.4 contains a backdoor"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("expected %q on the host page", want)
+		}
+	}
+
+	// An upgrade clears the vulnerabilities
+	upgraded := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{service(22, "ssh", "SSH-2.0-OpenSSH_8.0")}},
+	}
+	if _, _, err := db.SaveData(upgraded, time.Now().UTC().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	app.matchCVEs()
+	vulns, _ = db.LoadVulnerabilities(sqlite.SQLFilter{Where: []string{`port=?`}, Values: []interface{}{22}})
+	if len(vulns) != 0 {
+		t.Errorf("expected no vulnerabilities after upgrading, got %+v", vulns)
+	}
+}
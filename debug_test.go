@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckLoopback(t *testing.T) {
+	for _, tt := range []struct {
+		addr string
+		ok   bool
+	}{
+		{"localhost:6060", false},
+		{"127.0.0.1:6060", true},
+		{"[::1]:6060", true},
+		{":6060", false},
+		{"0.0.0.0:6060", false},
+		{"192.0.2.1:6060", false},
+		{"localhost", false},
+	} {
+		if err := checkLoopback(tt.addr); (err == nil) != tt.ok {
+			t.Errorf("checkLoopback(%q): unexpected error %v", tt.addr, err)
+		}
+	}
+}
+
+func TestDebugMux(t *testing.T) {
+	w := httptest.NewRecorder()
+	debugMux().ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
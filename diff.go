@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+// parseTime parses a time given either in seconds since the Unix epoch, in
+// RFC 3339 format or as a date, which is the start of the day in UTC.
+func parseTime(s string) (time.Time, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(i, 0).UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: must be a Unix timestamp, RFC 3339 or a date", s)
+	}
+	return t.UTC(), nil
+}
+
+// Handler for GET /api/v1/diff
+// from is required, to defaults to now.
+func (app *App) diff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("from") == "" {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+	from, err := parseTime(q.Get("from"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to := time.Now().UTC()
+	if s := q.Get("to"); s != "" {
+		to, err = parseTime(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if to.Before(from) {
+		http.Error(w, "to must not be before from", http.StatusBadRequest)
+		return
+	}
+
+	diff, err := app.db.LoadDiff(from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, diff)
+}
+
+// Handler for GET /api/v1/banners
+// This lists every time a service's banner has changed, oldest first. Changes
+// can be narrowed with the ip, port and proto query parameters, and to those
+// since a time with since.
+func (app *App) bannerChanges(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := portFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s := q.Get("since"); s != "" {
+		since, err := parseTime(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Where = append(filter.Where, `changed >= ?`)
+		filter.Values = append(filter.Values, since)
+	}
+
+	changes, err := app.db.LoadBannerChanges(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, changes)
+}
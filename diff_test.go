@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestDiffHandler(t *testing.T) {
+	db := createDB("TestDiffHandler")
+	defer db.Close()
+	app := App{db: db}
+
+	first := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+
+	banner := func(ip, b string) scan.Result {
+		p := scan.Port{Port: 22, Proto: "tcp"}
+		p.Service.Name = "ssh"
+		p.Service.Banner = b
+		return scan.Result{IP: ip, Ports: []scan.Port{p}}
+	}
+	scans := map[time.Time][]scan.Result{
+		first: {
+			{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+			{IP: "192.0.2.2", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+			banner("192.0.2.1", "SSH-2.0-OpenSSH_7.4"),
+		},
+		second: {
+			{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+			{IP: "192.0.2.3", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+			banner("192.0.2.1", "SSH-2.0-OpenSSH_8.0"),
+		},
+	}
+	for _, now := range []time.Time{first, second} {
+		if _, _, err := db.SaveData(scans[now], now); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveSubmission("192.0.2.100", nil, now, scan.Run{Scope: scan.ScopeAll}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	url := fmt.Sprintf("/api/v1/diff?from=%d&to=%s", first.Unix(), second.Format(time.RFC3339))
+	r := httptest.NewRequest("GET", url, nil)
+	w := httptest.NewRecorder()
+	app.diff(w, r)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %v", resp.StatusCode)
+	}
+	var diff scan.Diff
+	if err := json.NewDecoder(resp.Body).Decode(&diff); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diff.Appeared) != 1 || diff.Appeared[0].IP != "192.0.2.3" {
+		t.Errorf("expected 192.0.2.3 to appear, got %+v", diff.Appeared)
+	}
+	if len(diff.Disappeared) != 1 || diff.Disappeared[0].IP != "192.0.2.2" {
+		t.Errorf("expected 192.0.2.2 to disappear, got %+v", diff.Disappeared)
+	}
+	want := scan.BannerChange{IP: "192.0.2.1", Port: 22, Proto: "tcp", Service: "ssh", Old: "SSH-2.0-OpenSSH_7.4", New: "SSH-2.0-OpenSSH_8.0"}
+	if len(diff.Changed) != 1 || diff.Changed[0] != want {
+		t.Errorf("expected banner change %+v, got %+v", want, diff.Changed)
+	}
+
+	for _, url := range []string{"/api/v1/diff", "/api/v1/diff?from=yesterday", fmt.Sprintf("/api/v1/diff?from=%d&to=%d", second.Unix(), first.Unix())} {
+		r := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		app.diff(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %v", url, w.Code)
+		}
+	}
+}
+
+func TestBannerChanges(t *testing.T) {
+	db := createDB("TestBannerChanges")
+	defer db.Close()
+	events := testNotifier{"webhook", make(chan event, 10)}
+	app := App{db: db, notifiers: []notifier{events}}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ssh := func(banner string) []scan.Result {
+		p := scan.Port{Port: 22, Proto: "tcp"}
+		p.Service.Name = "ssh"
+		p.Service.Banner = banner
+		return []scan.Result{
+			{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+			{IP: "192.0.2.1", Ports: []scan.Port{p}},
+		}
+	}
+	// The version is bumped, seen again unchanged, then rolled back
+	banners := []string{"SSH-2.0-OpenSSH_7.4", "SSH-2.0-OpenSSH_8.0", "SSH-2.0-OpenSSH_8.0", "SSH-2.0-OpenSSH_7.4"}
+	var times []time.Time
+	for i, banner := range banners {
+		now := start.Add(time.Duration(i) * time.Hour)
+		times = append(times, now)
+		if _, _, err := db.SaveData(ssh(banner), now); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveSubmission("192.0.2.100", nil, now, scan.Run{Scope: scan.ScopeAll}); err != nil {
+			t.Fatal(err)
+		}
+		app.notifyChanges(now, nil, nil)
+	}
+
+	r := httptest.NewRequest("GET", "/api/v1/banners?ip=192.0.2.1&port=22", nil)
+	w := httptest.NewRecorder()
+	app.bannerChanges(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	var changes []scan.BannerChange
+	if err := json.NewDecoder(w.Body).Decode(&changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", changes)
+	}
+	for i, want := range []struct {
+		old, new string
+		at       time.Time
+	}{
+		{banners[0], banners[1], times[1]},
+		{banners[2], banners[3], times[3]},
+	} {
+		c := changes[i]
+		if c.Service != "ssh" || c.Old != want.old || c.New != want.new || c.Changed == nil || !c.Changed.Equal(want.at) {
+			t.Errorf("change %d: expected %s to %s at %v, got %+v", i, want.old, want.new, want.at, c)
+		}
+	}
+
+	// Each change was sent by the submission which saw it. Notifications are
+	// sent in the background so may arrive in any order.
+	sent := make(map[time.Time]string)
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events.events:
+			if e.Type != eventBannerChanged || e.Change == nil {
+				t.Fatalf("expected banner change event, got %+v", e)
+			}
+			sent[e.Time] = e.Change.New
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for banner change event")
+		}
+	}
+	if sent[times[1]] != banners[1] || sent[times[3]] != banners[3] {
+		t.Errorf("expected changes to %s at %v and %s at %v, got %v", banners[1], times[1], banners[3], times[3], sent)
+	}
+	msg, err := message("en", event{Type: eventBannerChanged, IP: "192.0.2.1", Port: 22, Proto: "tcp", Change: &changes[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ssh banner changed on 192.0.2.1 port 22/tcp: SSH-2.0-OpenSSH_7.4 to SSH-2.0-OpenSSH_8.0"; msg != want {
+		t.Errorf("expected message %q, got %q", want, msg)
+	}
+
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || !data[0].BannerChanged {
+		t.Errorf("expected port 22 flagged as changed by the latest scan, got %+v", data)
+	}
+
+	r = httptest.NewRequest("GET", "/api/v1/banners?since="+times[2].Format(time.RFC3339), nil)
+	w = httptest.NewRecorder()
+	app.bannerChanges(w, r)
+	changes = nil
+	if err := json.NewDecoder(w.Body).Decode(&changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Errorf("expected 1 change since %v, got %d", times[2], len(changes))
+	}
+}
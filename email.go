@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// mailer sends email through an SMTP server.
+type mailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+
+	// send is smtp.SendMail, overridden in tests
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// newMailer configures a mailer. If user is set, PLAIN authentication is
+// used, which net/smtp only permits over TLS or to localhost.
+func newMailer(addr, user, password, from, to string) *mailer {
+	m := &mailer{addr: addr, from: from, send: smtp.SendMail}
+	if user != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.auth = smtp.PlainAuth("", user, password, host)
+	}
+	for _, rcpt := range strings.Split(to, ",") {
+		if rcpt = strings.TrimSpace(rcpt); rcpt != "" {
+			m.to = append(m.to, rcpt)
+		}
+	}
+	return m
+}
+
+// header writes the common message headers.
+func (m *mailer) header(msg *bytes.Buffer, subject string) {
+	fmt.Fprintf(msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+}
+
+// sendMail sends a plain text message to all recipients.
+func (m *mailer) sendMail(subject, body string) error {
+	var msg bytes.Buffer
+	m.header(&msg, subject)
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(crlf(body))
+
+	return m.send(m.addr, m.auth, m.from, m.to, msg.Bytes())
+}
+
+// sendHTMLMail sends a message with both HTML and plain text versions of the
+// body. Mail clients which can't display HTML will show the plain text.
+func (m *mailer) sendHTMLMail(subject, text, html string) error {
+	var msg bytes.Buffer
+	m.header(&msg, subject)
+
+	var parts bytes.Buffer
+	mw := multipart.NewWriter(&parts)
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n", mw.Boundary())
+	msg.WriteString("\r\n")
+
+	// Parts are in increasing order of preference
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(crlf(part.body))); err != nil {
+			return err
+		}
+		if err := qp.Close(); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	msg.Write(parts.Bytes())
+
+	return m.send(m.addr, m.auth, m.from, m.to, msg.Bytes())
+}
+
+// crlf converts line endings to CRLF as required by SMTP.
+func crlf(s string) string {
+	return strings.Replace(strings.Replace(s, "\r\n", "\n", -1), "\n", "\r\n", -1)
+}
+
+// emailAlert emails an alert for each new port as soon as it is seen.
+type emailAlert struct {
+	*mailer
+	lang string
+}
+
+func (a emailAlert) name() string { return "email" }
+
+func (a emailAlert) notify(e event) error {
+	// Only new exposures, baseline violations, expiring certificates and a
+	// full database are urgent; closed ports are left for the digest
+	switch {
+	case e.Type == eventSummary && (e.New > 0 || e.Violations > 0 || e.Expiring > 0):
+		return a.notifySummary(e)
+	case e.Type != eventNewPort && e.Type != eventViolation && e.Type != eventCertExpiring && e.Type != eventQuota:
+		return nil
+	}
+	msg, err := message(a.lang, e)
+	if err != nil {
+		return err
+	}
+	return a.sendMail(msg, msg+"\n\nSeen at "+e.Time.Format(time.RFC1123)+"\n")
+}
+
+// notifySummary sends one email listing the new ports, baseline violations
+// and expiring certificates in a summary.
+func (a emailAlert) notifySummary(e event) error {
+	subject, err := message(a.lang, e)
+	if err != nil {
+		return err
+	}
+	var body strings.Builder
+	for _, ne := range e.Events {
+		if ne.Type != eventNewPort && ne.Type != eventViolation && ne.Type != eventCertExpiring {
+			continue
+		}
+		msg, err := message(a.lang, ne)
+		if err != nil {
+			return err
+		}
+		body.WriteString(msg + "\n")
+	}
+	return a.sendMail(subject, body.String()+"\nSeen at "+e.Time.Format(time.RFC1123)+"\n")
+}
+
+// digestTmpl is the plain text version of the digest. The HTML version is
+// the "digest" view.
+var digestTmpl = template.Must(template.New("digest").Parse(`Changes between {{ .From }} and {{ .To }}
+{{ with .Appeared }}
+New ports:
+{{ range . }}  {{ .IP }} {{ .Port }}/{{ .Proto }}
+{{ end }}{{ end }}{{ with .Disappeared }}
+Closed ports:
+{{ range . }}  {{ .IP }} {{ .Port }}/{{ .Proto }}
+{{ end }}{{ end }}{{ with .Changed }}
+Changed banners:
+{{ range . }}  {{ .IP }} {{ .Port }}/{{ .Proto }} {{ .Service }}: {{ .Old }} -> {{ .New }}
+{{ end }}{{ end }}{{ if not (or .Appeared .Disappeared .Changed) }}
+No changes.
+{{ end }}`))
+
+// digestPeriods are the supported digest schedules.
+var digestPeriods = map[string]time.Duration{
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// sendDigest emails a summary of the changes between from and to.
+func (app *App) sendDigest(m *mailer, from, to time.Time) error {
+	diff, err := app.db.LoadDiff(from, to)
+	if err != nil {
+		return err
+	}
+	var text, html bytes.Buffer
+	if err := digestTmpl.Execute(&text, diff); err != nil {
+		return err
+	}
+	if err := tmpl.ExecuteTemplate(&html, "digest", diff); err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("Scan digest: %d new, %d closed, %d changed",
+		len(diff.Appeared), len(diff.Disappeared), len(diff.Changed))
+	return m.sendHTMLMail(subject, text.String(), html.String())
+}
+
+// auditDigest is the audit action recording each digest sent, so the next
+// digest can start where it left off after a restart.
+const auditDigest = "send_digest"
+
+// checkDigest sends a digest if one is due at now, covering the changes since
+// the previous digest, or the last period if no digest has been sent.
+func (app *App) checkDigest(m *mailer, now time.Time, period time.Duration) error {
+	if !app.leading() {
+		return nil
+	}
+	last, err := app.db.LoadLastAudit(auditDigest)
+	if err != nil {
+		return err
+	}
+	if last.IsZero() {
+		last = now.Add(-period)
+	}
+	if now.Sub(last) < period {
+		return nil
+	}
+	if err := app.sendDigest(m, last, now); err != nil {
+		return err
+	}
+	info := fmt.Sprintf("%s to %s", last.Format(time.RFC3339), now.Format(time.RFC3339))
+	return app.db.SaveAudit(now, "", auditDigest, info)
+}
+
+// digestTask returns a task which sends a digest through m if one is due.
+func (app *App) digestTask(m *mailer, period time.Duration) func(now time.Time) {
+	return func(now time.Time) {
+		if err := app.checkDigest(m, now.UTC(), period); err != nil {
+			log.Printf("digest: error sending digest: %v", err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// captureMail returns a mailer which records the messages it sends.
+func captureMail(sent *[]string) *mailer {
+	m := newMailer("localhost:25", "", "", "scan@example.com", "a@example.com, b@example.com")
+	m.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		*sent = append(*sent, string(msg))
+		return nil
+	}
+	return m
+}
+
+func TestEmailAlert(t *testing.T) {
+	var sent []string
+	a := emailAlert{mailer: captureMail(&sent), lang: "en"}
+
+	if err := a.notify(event{Type: eventPortGone, IP: "192.0.2.1", Port: 22, Proto: "tcp"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 0 {
+		t.Errorf("expected no email for closed port, got %d", len(sent))
+	}
+
+	if err := a.notify(event{Type: eventNewPort, IP: "192.0.2.1", Port: 22, Proto: "tcp"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 email, got %d", len(sent))
+	}
+	if !strings.Contains(sent[0], "Subject: New port 22/tcp open on 192.0.2.1\r\n") {
+		t.Errorf("unexpected email:\n%s", sent[0])
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	db := createDB("TestSendDigest")
+	defer db.Close()
+	app := App{db: db}
+
+	first := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+	scans := map[time.Time][]scan.Result{
+		first:  {{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}}},
+		second: {{IP: "192.0.2.2", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}}},
+	}
+	for _, now := range []time.Time{first, second} {
+		if _, _, err := db.SaveData(scans[now], now); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveSubmission("192.0.2.100", nil, now, scan.Run{Scope: scan.ScopeAll}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sent []string
+	if err := app.sendDigest(captureMail(&sent), first, second); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 email, got %d", len(sent))
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(sent[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Scan digest: 1 new, 1 closed, 0 changed"; msg.Header.Get("Subject") != want {
+		t.Errorf("expected subject %q, got %q", want, msg.Header.Get("Subject"))
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the plain text and HTML parts
+	bodies := make(map[string]string)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(p)
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		bodies[ct] = string(b)
+	}
+
+	for ct, want := range map[string][]string{
+		"text/plain": {"New ports:\r\n  192.0.2.2 80/tcp", "Closed ports:\r\n  192.0.2.1 22/tcp"},
+		"text/html":  {"<td>192.0.2.2</td><td>80</td>", "<td>192.0.2.1</td><td>22</td>"},
+	} {
+		for _, w := range want {
+			if !strings.Contains(bodies[ct], w) {
+				t.Errorf("expected %s part to contain %q:\n%s", ct, w, bodies[ct])
+			}
+		}
+	}
+}
+
+func TestCheckDigest(t *testing.T) {
+	db := createDB("TestCheckDigest")
+	defer db.Close()
+	app := App{db: db}
+
+	var sent []string
+	m := captureMail(&sent)
+	period := 24 * time.Hour
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The first check covers the last period, and later checks wait for a
+	// period since the previous digest, even across a restart
+	checks := []struct {
+		now  time.Time
+		want int
+	}{
+		{start, 1},
+		{start.Add(time.Hour), 1},
+		{start.Add(period - time.Minute), 1},
+		{start.Add(period), 2},
+		{start.Add(period + time.Minute), 2},
+	}
+	for _, c := range checks {
+		if err := app.checkDigest(m, c.now, period); err != nil {
+			t.Fatal(err)
+		}
+		if len(sent) != c.want {
+			t.Errorf("at %v: expected %d digests, got %d", c.now, c.want, len(sent))
+		}
+	}
+	last, err := db.LoadLastAudit(auditDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Equal(start.Add(period)) {
+		t.Errorf("expected last digest at %v, got %v", start.Add(period), last)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// enrichBatch is the most ports each enricher runs on each run.
+const enrichBatch = 200
+
+// enrichTimeout is how long an enricher has for each port.
+const enrichTimeout = 30 * time.Second
+
+// enrichTarget is a port for an enricher to look at, with the current banner
+// of each service seen on it.
+type enrichTarget struct {
+	IP      string
+	Port    int
+	Proto   string
+	Banners map[string]string
+}
+
+// enricher sets key/value attributes on ports. Integrations which only need
+// to look at a port and return attributes implement it and call
+// registerEnricher, rather than adding their own tables, flags and
+// background loops.
+type enricher interface {
+	// enrich returns the attributes for t, which replace those it set
+	// before. If it returns an error the previous attributes are kept.
+	enrich(ctx context.Context, t enrichTarget) (map[string]string, error)
+}
+
+// enricherFactory creates an enricher once flags have been parsed.
+type enricherFactory func() (enricher, error)
+
+type enricherRegistration struct {
+	name    string
+	usage   string
+	factory enricherFactory
+	enabled *bool
+}
+
+// enricherRegistry holds the registered enrichers in name order.
+var enricherRegistry []*enricherRegistration
+
+// registerEnricher makes an enricher available, enabled with
+// -enrich.<name>. It's called from init, where the enricher can define any
+// flags of its own, named -enrich.<name>.<option>.
+func registerEnricher(name, usage string, factory enricherFactory) {
+	for _, r := range enricherRegistry {
+		if r.name == name {
+			panic("enricher " + name + " registered twice")
+		}
+	}
+	enricherRegistry = append(enricherRegistry, &enricherRegistration{name: name, usage: usage, factory: factory})
+	sort.Slice(enricherRegistry, func(i, j int) bool { return enricherRegistry[i].name < enricherRegistry[j].name })
+}
+
+// defineEnricherFlags defines the -enrich.<name> flag enabling each
+// registered enricher.
+func defineEnricherFlags(fs *flag.FlagSet) {
+	for _, r := range enricherRegistry {
+		r.enabled = fs.Bool("enrich."+r.name, false, r.usage)
+	}
+}
+
+// namedEnricher is an enabled enricher with the name its attributes are
+// stored under.
+type namedEnricher struct {
+	name string
+	enricher
+}
+
+// enrichPipeline runs the enabled enrichers on the ports in the results.
+type enrichPipeline struct {
+	enrichers []namedEnricher
+	// refresh is how long attributes are kept before an enricher runs on
+	// the port again
+	refresh time.Duration
+}
+
+// newEnrichPipeline creates the enrichers enabled by flags, or returns nil if
+// none are.
+func newEnrichPipeline(refresh time.Duration) (*enrichPipeline, error) {
+	p := &enrichPipeline{refresh: refresh}
+	for _, r := range enricherRegistry {
+		if r.enabled == nil || !*r.enabled {
+			continue
+		}
+		e, err := r.factory()
+		if err != nil {
+			return nil, fmt.Errorf("-enrich.%s: %v", r.name, err)
+		}
+		p.enrichers = append(p.enrichers, namedEnricher{r.name, e})
+	}
+	if len(p.enrichers) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// enrichPorts runs each enricher on the ports it has never run on, or last
+// ran on longer ago than the refresh interval. A port an enricher fails on
+// keeps its previous attributes until the refresh interval has passed again,
+// so one bad port doesn't hold up the rest.
+func (app *App) enrichPorts(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	for _, e := range app.pipeline.enrichers {
+		ports, err := app.db.LoadStaleEnrichPorts(e.name, now.Add(-app.pipeline.refresh), enrichBatch)
+		if err != nil {
+			log.Printf("enrich: %s: error loading ports: %v", e.name, err)
+			continue
+		}
+		for _, r := range ports {
+			banners, err := app.db.LoadBanners(r.IP, r.Port, r.Proto)
+			if err != nil {
+				log.Printf("enrich: %s: error loading banners for %s port %d: %v", e.name, r.IP, r.Port, err)
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), enrichTimeout)
+			attrs, err := e.enrich(ctx, enrichTarget{IP: r.IP, Port: r.Port, Proto: r.Proto, Banners: banners})
+			cancel()
+			switch {
+			case err != nil:
+				log.Printf("enrich: %s: error enriching %s port %d: %v", e.name, r.IP, r.Port, err)
+				attrs = nil
+			case attrs == nil:
+				attrs = map[string]string{}
+			}
+			if err := app.db.SaveAttributes(e.name, r, attrs, now); err != nil {
+				log.Printf("enrich: %s: error saving attributes for %s port %d: %v", e.name, r.IP, r.Port, err)
+			}
+		}
+		if verbose && len(ports) > 0 {
+			log.Printf("enrich: %s: enriched %d ports", e.name, len(ports))
+		}
+	}
+}
+
+// Handler for GET /api/v1/attributes
+//
+// Lists the attributes set by enrichers, optionally only those on ip or set
+// by enricher.
+func (app *App) listAttributes(w http.ResponseWriter, r *http.Request) {
+	var filter sqlite.SQLFilter
+	q := r.URL.Query()
+	if ip := q.Get("ip"); ip != "" {
+		filter.Where = append(filter.Where, `ip = ?`)
+		filter.Values = append(filter.Values, scan.NormalizeIP(ip))
+	}
+	if e := q.Get("enricher"); e != "" {
+		filter.Where = append(filter.Where, `enricher = ?`)
+		filter.Values = append(filter.Values, e)
+	}
+	attrs, err := app.db.LoadAttributes(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, attrs)
+}
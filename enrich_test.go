@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+type testEnricher struct {
+	calls []string
+	fail  bool
+}
+
+func (e *testEnricher) enrich(ctx context.Context, t enrichTarget) (map[string]string, error) {
+	e.calls = append(e.calls, t.IP)
+	if e.fail {
+		return nil, errors.New("upstream unavailable")
+	}
+	return map[string]string{"owner": "team-" + t.IP[len(t.IP)-1:]}, nil
+}
+
+func TestEnrichers(t *testing.T) {
+	db := createDB("TestEnrichers")
+	defer db.Close()
+
+	defer func(registry []*enricherRegistration) { enricherRegistry = registry }(enricherRegistry)
+	test := &testEnricher{}
+	registerEnricher("test", "Test enricher", func() (enricher, error) { return test, nil })
+	fs := flag.NewFlagSet("TestEnrichers", flag.ContinueOnError)
+	defineEnricherFlags(fs)
+	if err := fs.Parse([]string{"-enrich.test", "-enrich.products"}); err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := newEnrichPipeline(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipeline.enrichers) != 2 {
+		t.Fatalf("expected 2 enrichers enabled, got %+v", pipeline.enrichers)
+	}
+
+	app := App{db: db, pipeline: pipeline}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	now := time.Now().UTC()
+	ssh := scan.Port{Port: 22, Proto: "tcp"}
+	ssh.Service.Name = "ssh"
+	ssh.Service.Banner = "SSH-2.0-OpenSSH_7.4"
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{ssh}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, now); err != nil {
+		t.Fatal(err)
+	}
+
+	app.enrichPorts(now)
+	if len(test.calls) != 2 {
+		t.Errorf("expected the test enricher run on both ports, got %v", test.calls)
+	}
+	test.calls = nil
+	app.enrichPorts(now.Add(time.Hour))
+	if len(test.calls) != 0 {
+		t.Errorf("expected no enrichment before the refresh, got %v", test.calls)
+	}
+
+	attrs, err := db.LoadAttributes(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{"192.0.2.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scan.Attribute{
+		{IP: "192.0.2.1", Port: 22, Proto: "tcp", Enricher: "products", Key: "openbsd:openssh", Value: "7.4"},
+		{IP: "192.0.2.1", Port: 22, Proto: "tcp", Enricher: "test", Key: "owner", Value: "team-1"},
+	}
+	if len(attrs) != len(want) {
+		t.Fatalf("expected %+v, got %+v", want, attrs)
+	}
+	for i := range want {
+		if attrs[i] != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], attrs[i])
+		}
+	}
+
+	// A failing enricher keeps the attributes it set before
+	test.fail = true
+	app.enrichPorts(now.Add(25 * time.Hour))
+	if len(test.calls) != 2 {
+		t.Errorf("expected the test enricher run again after the refresh, got %v", test.calls)
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/attributes?enricher=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []scan.Attribute
+	err = json.NewDecoder(res.Body).Decode(&listed)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 || listed[1].Value != "team-2" {
+		t.Errorf("expected the test enricher's attributes kept, got %+v", listed)
+	}
+
+	res, err = http.Get(ts.URL + "/host/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(b), "<strong>openbsd:openssh</strong> 7.4") {
+		t.Error("expected the attributes on the host page")
+	}
+}
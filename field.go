@@ -0,0 +1,280 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// fieldName restricts custom field names to ones which are safe in filters
+// and CSV headers.
+var fieldName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// validateFieldValue checks a value is set on a valid host, or a single port
+// on it.
+func validateFieldValue(v scan.FieldValue) error {
+	if net.ParseIP(v.IP) == nil {
+		return fmt.Errorf("invalid IP %q", v.IP)
+	}
+	if v.Port < 0 || v.Port > 65535 {
+		return fmt.Errorf("invalid port %d", v.Port)
+	}
+	switch v.Proto {
+	case "", "tcp", "udp":
+	default:
+		return fmt.Errorf("invalid proto %q", v.Proto)
+	}
+	if (v.Port == 0) != (v.Proto == "") {
+		return errors.New("port and proto must be set together")
+	}
+	if len(v.Value) > 1024 {
+		return errors.New("value is limited to 1024 bytes")
+	}
+	return nil
+}
+
+// hasField reports whether a field with the given name is defined.
+func hasField(fields []scan.Field, name string) bool {
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// auditField records a change to a custom field in the audit log.
+func (app *App) auditField(user *User, event string, v interface{}) {
+	info, _ := json.Marshal(v)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditField: error saving %s: %v", event, err)
+	}
+}
+
+// fieldFilters parses field=name:value query parameters.
+func fieldFilters(q map[string][]string) map[string]string {
+	filters := make(map[string]string)
+	for _, f := range q["field"] {
+		i := strings.Index(f, ":")
+		if i < 0 {
+			continue
+		}
+		filters[f[:i]] = f[i+1:]
+	}
+	return filters
+}
+
+// Handler for GET /api/v1/fields
+func (app *App) listFields(w http.ResponseWriter, r *http.Request) {
+	fields, err := app.db.LoadFields()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, fields)
+}
+
+// Handler for POST /api/v1/fields
+// Defining an existing field updates its description.
+func (app *App) newField(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var f scan.Field
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !fieldName.MatchString(f.Name) {
+		http.Error(w, "Field names must be lower case letters, digits and underscores", http.StatusBadRequest)
+		return
+	}
+	if err := app.db.SaveField(f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditField(user, "add_field", f)
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, f)
+}
+
+// Handler for DELETE /api/v1/fields/{name}
+// The field's values are deleted with it. With -approvals it's only deleted
+// once another admin approves it.
+func (app *App) deleteField(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	f := scan.Field{Name: chi.URLParam(r, "name")}
+	if app.approvals {
+		fields, err := app.db.LoadFields()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !hasField(fields, f.Name) {
+			http.Error(w, "Field not found", http.StatusNotFound)
+			return
+		}
+		err = app.requestApproval(user.Email, "delete_field", f)
+		if err != errApprovalRequested {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	err := app.db.DeleteField(f.Name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Field not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditField(user, "delete_field", f)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for POST /api/v1/fields/{name}/values
+func (app *App) setFieldValue(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var v scan.FieldValue
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v.Field = chi.URLParam(r, "name")
+	v.IP = scan.NormalizeIP(v.IP)
+	if err := validateFieldValue(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err := app.db.SaveFieldValue(v)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Field not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditField(user, "set_field", v)
+
+	render.JSON(w, r, v)
+}
+
+// Handler for DELETE /api/v1/fields/{name}/values?ip=...[&port=...&proto=...]
+func (app *App) deleteFieldValue(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	q := r.URL.Query()
+	v := scan.FieldValue{Field: chi.URLParam(r, "name"), IP: scan.NormalizeIP(q.Get("ip")), Proto: q.Get("proto")}
+	if port := q.Get("port"); port != "" {
+		var err error
+		if v.Port, err = strconv.Atoi(port); err != nil {
+			http.Error(w, "Invalid port", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := validateFieldValue(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err := app.db.DeleteFieldValue(v)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Field value not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditField(user, "unset_field", v)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for GET /api/v1/results
+// Results can be searched and sorted like the index page; see resultQuery. With format=csv they're
+// exported as CSV, with a column for each custom field.
+func (app *App) results(w http.ResponseWriter, r *http.Request) {
+	app.writeResults(w, r, r.URL.Query())
+}
+
+// writeResults responds with the results matching the search in q, as JSON or
+// CSV.
+func (app *App) writeResults(w http.ResponseWriter, r *http.Request, q url.Values) {
+	query, err := resultQuery(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.View = sqlite.ViewAll
+	query.Tenant, query.CIDRs = requestTenant(r), requestCIDRs(r)
+	data, _, err := app.db.ResultPage(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	results := data.Results
+	if results == nil {
+		results = []scan.IPInfo{}
+	}
+	if q.Get("format") != "csv" {
+		render.JSON(w, r, results)
+		return
+	}
+
+	fields, err := app.db.LoadFields()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="results.csv"`)
+	cw := csv.NewWriter(w)
+	header := []string{"ip", "port", "proto", "firstseen", "lastseen", "closed"}
+	for _, f := range fields {
+		header = append(header, f.Name)
+	}
+	cw.Write(header)
+	for _, res := range results {
+		row := []string{res.IP, strconv.Itoa(res.Port), res.Proto,
+			res.FirstSeen.UTC().Format(time.RFC3339), res.LastSeen.UTC().Format(time.RFC3339),
+			strconv.FormatBool(res.Gone)}
+		for _, f := range fields {
+			row = append(row, res.Fields[f.Name])
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Println("results: error writing CSV:", err)
+	}
+}
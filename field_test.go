@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestFields(t *testing.T) {
+	db := createDB("TestFields")
+	defer db.Close()
+
+	app := App{db: db}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "admin@example.com"}}}
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"POST", "/api/v1/fields", `{"name": "Cost Centre"}`, http.StatusBadRequest},
+		{"POST", "/api/v1/fields", `{"name": "cost_centre", "description": "Who pays"}`, http.StatusCreated},
+		{"POST", "/api/v1/fields", `{"name": "scope"}`, http.StatusCreated},
+		{"POST", "/api/v1/fields/cost_centre/values", `{"ip": "192.0.2.1", "value": "1234"}`, http.StatusOK},
+		{"POST", "/api/v1/fields/cost_centre/values", `{"ip": "192.0.2.1", "port": 443, "proto": "tcp", "value": "5678"}`, http.StatusOK},
+		{"POST", "/api/v1/fields/scope/values", `{"ip": "192.0.2.2", "value": "pci"}`, http.StatusOK},
+		{"POST", "/api/v1/fields/scope/values", `{"ip": "192.0.2.2", "port": 443, "value": "pci"}`, http.StatusBadRequest},
+		{"POST", "/api/v1/fields/owner/values", `{"ip": "192.0.2.2", "value": "bob"}`, http.StatusNotFound},
+	} {
+		res := do(tt.method, tt.path, tt.body)
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s %s %s: expected status %d, got %d", tt.method, tt.path, tt.body, tt.code, res.StatusCode)
+		}
+	}
+
+	// Port values override host values
+	res := do("GET", "/api/v1/results?field=cost_centre:1234", "")
+	var got []scan.IPInfo
+	json.NewDecoder(res.Body).Decode(&got)
+	res.Body.Close()
+	if len(got) != 1 || got[0].Port != 22 {
+		t.Errorf("unexpected results %+v", got)
+	}
+
+	res = do("GET", "/api/v1/results?format=csv", "")
+	rows, err := csv.NewReader(res.Body).ReadAll()
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || strings.Join(rows[0], ",") != "ip,port,proto,firstseen,lastseen,closed,cost_centre,scope" {
+		t.Fatalf("unexpected CSV %v", rows)
+	}
+	for _, row := range rows[1:] {
+		want := map[string]string{"192.0.2.1 22": "1234,", "192.0.2.1 443": "5678,", "192.0.2.2 443": ",pci"}[row[0]+" "+row[1]]
+		if got := row[6] + "," + row[7]; got != want {
+			t.Errorf("%s:%s: expected fields %q, got %q", row[0], row[1], want, got)
+		}
+	}
+
+	res = do("GET", "/", "")
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !bytes.Contains(body, []byte(`<th title="Who pays">cost_centre</th>`)) || !bytes.Contains(body, []byte(`<td>5678</td>`)) {
+		t.Error("expected the index to show the custom fields")
+	}
+
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{"/api/v1/fields/cost_centre/values?ip=192.0.2.1&port=443&proto=tcp", http.StatusNoContent},
+		{"/api/v1/fields/cost_centre/values?ip=192.0.2.1&port=443&proto=tcp", http.StatusNotFound},
+		{"/api/v1/fields/scope", http.StatusNoContent},
+		{"/api/v1/fields/scope", http.StatusNotFound},
+	} {
+		res := do("DELETE", tt.path, "")
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("DELETE %s: expected status %d, got %d", tt.path, tt.code, res.StatusCode)
+		}
+	}
+	res = do("GET", "/api/v1/results?field=cost_centre:1234", "")
+	json.NewDecoder(res.Body).Decode(&got)
+	res.Body.Close()
+	if len(got) != 2 {
+		t.Errorf("expected the host value for both ports once the port's was removed, got %+v", got)
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT count(*) FROM audit WHERE action IN ('add_field', 'delete_field', 'set_field', 'unset_field')`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Errorf("expected 7 audited changes, got %d", count)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// clientTemplates are the examples written by scan gen-client, by language.
+// Each submits a result and then queries the results for the same host.
+var clientTemplates = map[string]*template.Template{
+	"curl": template.Must(template.New("curl").Parse(`#!/bin/sh
+# Submit masscan JSON output (masscan -oJ results.json ...)
+curl -sS -X POST {{.URL}}/results \
+  -H 'Authorization: Bearer {{.Token}}' \
+  -H 'Content-Type: application/json' \
+  --data-binary '[{"ip": "192.0.2.1", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}]'
+
+# Query the results for a host
+curl -sS '{{.URL}}/api/v1/results?ip=192.0.2.1' \
+  -H 'Authorization: Bearer {{.Token}}'
+`)),
+	"python": template.Must(template.New("python").Parse(`#!/usr/bin/env python3
+import json
+import urllib.request
+
+URL = "{{.URL}}"
+TOKEN = "{{.Token}}"
+
+
+def request(path, data=None):
+    req = urllib.request.Request(URL + path, data=data)
+    req.add_header("Authorization", "Bearer " + TOKEN)
+    if data is not None:
+        req.add_header("Content-Type", "application/json")
+    with urllib.request.urlopen(req) as res:
+        return res.status, res.read()
+
+
+# Submit masscan JSON output (masscan -oJ results.json ...)
+results = [{"ip": "192.0.2.1", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}]
+status, _ = request("/results", json.dumps(results).encode())
+print("submitted:", status)
+
+# Query the results for a host
+status, body = request("/api/v1/results?ip=192.0.2.1")
+for r in json.loads(body):
+    print(r["ip"], r["port"], r["proto"], r["lastseen"])
+`)),
+	"go": template.Must(template.New("go").Parse(`package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	scanURL = "{{.URL}}"
+	token   = "{{.Token}}"
+)
+
+func request(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, scanURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func main() {
+	// Submit masscan JSON output (masscan -oJ results.json ...)
+	results := ` + "`" + `[{"ip": "192.0.2.1", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}]` + "`" + `
+	res, err := request("POST", "/results", strings.NewReader(results))
+	if err != nil {
+		log.Fatal(err)
+	}
+	res.Body.Close()
+	fmt.Println("submitted:", res.Status)
+
+	// Query the results for a host
+	res, err = request("GET", "/api/v1/results?ip=192.0.2.1", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer res.Body.Close()
+	var ports []struct {
+		IP       string ` + "`json:\"ip\"`" + `
+		Port     int    ` + "`json:\"port\"`" + `
+		Proto    string ` + "`json:\"proto\"`" + `
+		LastSeen string ` + "`json:\"lastseen\"`" + `
+	}
+	if err := json.NewDecoder(res.Body).Decode(&ports); err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range ports {
+		fmt.Println(p.IP, p.Port, p.Proto, p.LastSeen)
+	}
+}
+`)),
+}
+
+// runGenClient implements scan gen-client, which writes example code for
+// submitting and querying results, filled in with the server URL and a new
+// API token.
+func runGenClient(args []string, out io.Writer) error {
+	var langs []string
+	for lang := range clientTemplates {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	fs := flag.NewFlagSet("gen-client", flag.ContinueOnError)
+	fs.SetOutput(out)
+	lang := fs.String("lang", "curl", "Language of the examples: "+strings.Join(langs, ", "))
+	serverURL := fs.String("url", "http://localhost", "`URL` of the Scan server")
+	tokensFile := fs.String("auth.tokens", "", "`File` of static API tokens to add the new token to, as used by the server")
+	email := fs.String("user", "", "`Email` address the new token authenticates as")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	tmpl, ok := clientTemplates[*lang]
+	if !ok {
+		return fmt.Errorf("unknown -lang %q, expected one of %s", *lang, strings.Join(langs, ", "))
+	}
+	if *tokensFile == "" || *email == "" {
+		return errors.New("-auth.tokens and -user are required")
+	}
+	u, err := url.Parse(*serverURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid -url %q", *serverURL)
+	}
+
+	token, err := addToken(*tokensFile, *email)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(out, struct{ URL, Token string }{
+		URL:   strings.TrimSuffix(u.String(), "/"),
+		Token: token,
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// tokenPattern finds the token in the examples.
+var tokenPattern = regexp.MustCompile(`(?:Bearer |token += |TOKEN = )"?([0-9a-f]{64})`)
+
+func TestRunGenClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan-gen-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokensFile := filepath.Join(dir, "tokens.txt")
+
+	var out bytes.Buffer
+	if err := runGenClient([]string{"-lang", "perl", "-auth.tokens", tokensFile, "-user", "ci@example.com"}, &out); err == nil {
+		t.Error("expected an error for an unknown language")
+	}
+	if err := runGenClient([]string{"-auth.tokens", tokensFile}, &out); err == nil {
+		t.Error("expected an error without -user")
+	}
+
+	var printed []string
+	for _, lang := range []string{"curl", "python", "go"} {
+		out.Reset()
+		args := []string{"-lang", lang, "-url", "https://scan.example.com/", "-auth.tokens", tokensFile, "-user", "ci@example.com"}
+		if err := runGenClient(args, &out); err != nil {
+			t.Fatalf("%s: %v", lang, err)
+		}
+		if !strings.Contains(out.String(), "https://scan.example.com/results") &&
+			!strings.Contains(out.String(), `"https://scan.example.com"`) {
+			t.Errorf("%s: server URL not filled in:\n%s", lang, out.String())
+		}
+		if m := tokenPattern.FindStringSubmatch(out.String()); m != nil {
+			printed = append(printed, m[1])
+		}
+	}
+
+	// Each run adds a token which the server accepts
+	tokens, err := loadTokens(tokensFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 3 || len(printed) != 3 {
+		t.Fatalf("expected 3 tokens, got %d saved and %d printed", len(tokens), len(printed))
+	}
+	for _, token := range printed {
+		if tokens[sha256.Sum256([]byte(token))] != "ci@example.com" {
+			t.Errorf("printed token %s wasn't saved", token)
+		}
+	}
+}
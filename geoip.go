@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jamesog/scan/internal/mmdb"
+	"github.com/jamesog/scan/pkg/scan"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const geoipDownloadURL = "https://download.maxmind.com/geoip/databases"
+
+// geoipUpdater periodically downloads MaxMind GeoIP databases into the data
+// directory so enrichment data doesn't go stale. The account ID and license
+// key are sent with HTTP basic authentication so they never appear in URLs,
+// and so never in logged errors.
+type geoipUpdater struct {
+	accountID  string
+	licenseKey string
+	editions   []string
+	dir        string
+	baseURL    string
+	client     *http.Client
+}
+
+func newGeoIPUpdater(accountID, licenseKey, editions, dir string) *geoipUpdater {
+	u := &geoipUpdater{
+		accountID:  accountID,
+		licenseKey: licenseKey,
+		dir:        dir,
+		baseURL:    geoipDownloadURL,
+		client:     &http.Client{Timeout: 5 * time.Minute},
+	}
+	for _, e := range strings.Split(editions, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			u.editions = append(u.editions, e)
+		}
+	}
+	return u
+}
+
+// path returns the location of the database for the edition.
+func (u *geoipUpdater) path(edition string) string {
+	return filepath.Join(u.dir, edition+".mmdb")
+}
+
+// run updates all editions immediately and then every interval.
+func (u *geoipUpdater) run(interval time.Duration) {
+	u.updateAll()
+	for range time.Tick(interval) {
+		u.updateAll()
+	}
+}
+
+func (u *geoipUpdater) updateAll() {
+	for _, edition := range u.editions {
+		updated, err := u.update(edition)
+		if err != nil {
+			log.Printf("geoip: error updating %s: %v", edition, err)
+			continue
+		}
+		if fi, err := os.Stat(u.path(edition)); err == nil {
+			gaugeGeoIPUpdate.With(prometheus.Labels{"edition": edition}).Set(float64(fi.ModTime().Unix()))
+		}
+		if updated && verbose {
+			log.Printf("geoip: updated %s", edition)
+		}
+	}
+}
+
+// update downloads the edition if it has changed since the local copy was
+// downloaded. It reports whether a new database was installed.
+func (u *geoipUpdater) update(edition string) (bool, error) {
+	req, err := http.NewRequest("GET", u.baseURL+"/"+url.PathEscape(edition)+"/download?suffix=tar.gz", nil)
+	if err != nil {
+		return false, err
+	}
+	req.SetBasicAuth(u.accountID, u.licenseKey)
+	if fi, err := os.Stat(u.path(edition)); err == nil {
+		req.Header.Set("If-Modified-Since", fi.ModTime().UTC().Format(http.TimeFormat))
+	}
+
+	res, err := u.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return false, nil
+	default:
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return false, fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	// Write to a temporary file first so a partial download never replaces
+	// a working database
+	tmp, err := ioutil.TempFile(u.dir, edition)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = extractMMDB(res.Body, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(tmp.Name(), u.path(edition)); err != nil {
+		return false, err
+	}
+
+	// Use the server's modification time for the next If-Modified-Since
+	if lm, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(u.path(edition), lm, lm)
+	}
+
+	return true, nil
+}
+
+// extractMMDB copies the first .mmdb file found in the gzipped tar stream r
+// to w.
+func extractMMDB(r io.Reader, w io.Writer) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return errors.New("no .mmdb file found in archive")
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg && strings.HasSuffix(hdr.Name, ".mmdb") {
+			_, err := io.Copy(w, tr)
+			return err
+		}
+	}
+}
+
+// geoLocator looks up IPs in the GeoIP databases in the data directory,
+// reopening each when it's updated.
+type geoLocator struct {
+	editions []string
+	dir      string
+
+	mu      sync.Mutex
+	readers map[string]*mmdb.Reader
+	modTime map[string]time.Time
+}
+
+func newGeoLocator(editions, dir string) *geoLocator {
+	l := &geoLocator{
+		dir:     dir,
+		readers: make(map[string]*mmdb.Reader),
+		modTime: make(map[string]time.Time),
+	}
+	for _, e := range strings.Split(editions, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l.editions = append(l.editions, e)
+		}
+	}
+	return l
+}
+
+// reload opens any of the databases which have changed since they were last
+// opened. It returns when the newest database was written, or the zero time
+// if there are none.
+func (l *geoLocator) reload() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var newest time.Time
+	for _, edition := range l.editions {
+		path := filepath.Join(l.dir, edition+".mmdb")
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !fi.ModTime().Equal(l.modTime[edition]) {
+			r, err := mmdb.Open(path)
+			if err != nil {
+				// Keep using the previous copy, if there is one
+				log.Printf("geoip: error opening %s: %v", edition, err)
+				continue
+			}
+			l.readers[edition] = r
+			l.modTime[edition] = fi.ModTime()
+		}
+		if l.modTime[edition].After(newest) {
+			newest = l.modTime[edition]
+		}
+	}
+	return newest
+}
+
+// lookup finds ip in every database, taking the country and city from a
+// City or Country database and the organisation from an ASN one.
+func (l *geoLocator) lookup(ip string) scan.Geo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g := scan.Geo{IP: ip}
+	addr := net.ParseIP(ip)
+	for _, edition := range l.editions {
+		r := l.readers[edition]
+		if r == nil {
+			continue
+		}
+		v, err := r.Lookup(addr)
+		if err != nil {
+			log.Printf("geoip: error looking up %s in %s: %v", ip, edition, err)
+			continue
+		}
+		if s, ok := mmdb.Get(v, "country", "iso_code").(string); ok {
+			g.Country = s
+		}
+		if s, ok := mmdb.Get(v, "city", "names", "en").(string); ok {
+			g.City = s
+		}
+		if n, ok := mmdb.Get(v, "autonomous_system_number").(uint64); ok {
+			g.ASN = uint(n)
+		}
+		if s, ok := mmdb.Get(v, "autonomous_system_organization").(string); ok {
+			g.Org = s
+		}
+	}
+	return g
+}
+
+// enrichGeo looks up the IPs which haven't been looked up since the GeoIP
+// databases were last updated, which is every IP when they've changed.
+func (app *App) enrichGeo(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	updated := app.geoLocator.reload()
+	if updated.IsZero() {
+		return
+	}
+	ips, err := app.db.LoadStaleGeoIPs(updated)
+	if err != nil {
+		log.Println("geoip: error loading IPs to look up:", err)
+		return
+	}
+	geo := make([]scan.Geo, 0, len(ips))
+	for _, ip := range ips {
+		g := app.geoLocator.lookup(ip)
+		g.Updated = scan.Time{Time: now.UTC()}
+		geo = append(geo, g)
+	}
+	if err := app.db.SaveGeo(geo); err != nil {
+		log.Println("geoip: error saving lookups:", err)
+		return
+	}
+	if verbose && len(geo) > 0 {
+		log.Printf("geoip: looked up %d IPs", len(geo))
+	}
+}
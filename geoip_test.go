@@ -0,0 +1,252 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestGeoIPUpdate(t *testing.T) {
+	db := []byte("fake mmdb data")
+
+	// Build a tarball laid out like MaxMind's downloads
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "GeoLite2-City_20200101/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "GeoLite2-City_20200101/GeoLite2-City.mmdb", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(db))})
+	tw.Write(db)
+	tw.Close()
+	gz.Close()
+
+	lastModified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var downloads int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/GeoLite2-City/download" || r.URL.Query().Get("suffix") != "tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "1234" || pass != "secret" {
+			http.Error(w, "Invalid license key", http.StatusUnauthorized)
+			return
+		}
+		if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(ims) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		w.Write(archive.Bytes())
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "TestGeoIPUpdate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := newGeoIPUpdater("1234", "secret", "GeoLite2-City", dir)
+	u.baseURL = ts.URL
+
+	updated, err := u.update("GeoLite2-City")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated {
+		t.Error("expected database to be updated")
+	}
+	got, err := ioutil.ReadFile(u.path("GeoLite2-City"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, db) {
+		t.Errorf("expected %q, got %q", db, got)
+	}
+
+	// The second update should be skipped as the database hasn't changed
+	updated, err = u.update("GeoLite2-City")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated || downloads != 1 {
+		t.Errorf("expected no further download, got %d downloads", downloads)
+	}
+
+	u.licenseKey = "wrong"
+	_, err = u.update("GeoLite2-City")
+	if err == nil {
+		t.Fatal("expected error with invalid license key")
+	}
+	if strings.Contains(err.Error(), "wrong") {
+		t.Errorf("error contains the license key: %v", err)
+	}
+}
+
+// mmdbValue encodes strings shorter than 285 bytes, unsigned integers and
+// maps of them in the MaxMind DB data format.
+func mmdbValue(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		if len(v) >= 29 {
+			return append([]byte{2<<5 | 29, byte(len(v) - 29)}, v...)
+		}
+		return append([]byte{byte(2<<5 | len(v))}, v...)
+	case uint:
+		return []byte{6<<5 | 4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := []byte{byte(7<<5 | len(v))}
+		for _, k := range keys {
+			b = append(b, mmdbValue(k)...)
+			b = append(b, mmdbValue(v[k])...)
+		}
+		return b
+	}
+	panic("unsupported type")
+}
+
+// buildMMDB builds an IPv6 MaxMind DB with record data for a single IPv4
+// network.
+func buildMMDB(network string, record map[string]interface{}) []byte {
+	_, n, err := net.ParseCIDR(network)
+	if err != nil {
+		panic(err)
+	}
+	ones, _ := n.Mask.Size()
+	prefix := n.IP.To4()
+	// A chain of nodes down ::/96 then the network's bits. The other record
+	// of each node is empty, so only the network has data.
+	depth := 96 + ones
+	nodes := depth
+	var tree []byte
+	for d := 0; d < depth; d++ {
+		next := d + 1
+		if d == depth-1 {
+			next = nodes + 16
+		}
+		records := [2]int{next, nodes}
+		if d >= 96 && prefix[(d-96)/8]>>(7-uint((d-96)%8))&1 == 1 {
+			records = [2]int{nodes, next}
+		}
+		for _, r := range records {
+			tree = append(tree, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+	b := append(tree, make([]byte, 16)...)
+	b = append(b, mmdbValue(record)...)
+	b = append(b, "\xAB\xCD\xEFMaxMind.com"...)
+	return append(b, mmdbValue(map[string]interface{}{
+		"node_count":  uint(nodes),
+		"record_size": uint(24),
+		"ip_version":  uint(6),
+	})...)
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	db := createDB("TestGeoIPLookup")
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app := App{db: db, geoLocator: newGeoLocator("GeoLite2-City,GeoLite2-ASN", dir)}
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is looked up without databases
+	app.enrichGeo(time.Now())
+	if geo, _ := db.LoadGeo(sqlite.SQLFilter{}); len(geo) != 0 {
+		t.Errorf("expected no lookups without databases, got %+v", geo)
+	}
+
+	city := buildMMDB("192.0.2.0/24", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "NL"},
+		"city":    map[string]interface{}{"names": map[string]interface{}{"en": "Amsterdam"}},
+	})
+	asn := buildMMDB("192.0.2.0/24", map[string]interface{}{
+		"autonomous_system_number":       uint(64496),
+		"autonomous_system_organization": "Example Hosting",
+	})
+	built := time.Now().Add(-time.Hour)
+	for edition, b := range map[string][]byte{"GeoLite2-City": city, "GeoLite2-ASN": asn} {
+		path := filepath.Join(dir, edition+".mmdb")
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, built, built)
+	}
+
+	app.enrichGeo(time.Now())
+	geo, err := db.LoadGeo(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(geo) != 2 {
+		t.Fatalf("expected both IPs looked up, got %+v", geo)
+	}
+	if g := geo[0]; g.Country != "NL" || g.City != "Amsterdam" || g.ASN != 64496 || g.Org != "Example Hosting" {
+		t.Errorf("unexpected lookup for 192.0.2.1: %+v", g)
+	}
+	if g := geo[1]; g.IP != "198.51.100.1" || g.Country != "" {
+		t.Errorf("expected nothing found for 198.51.100.1, got %+v", g)
+	}
+
+	// IPs are only looked up again when the databases change
+	if ips, _ := db.LoadStaleGeoIPs(built); len(ips) != 0 {
+		t.Errorf("expected no IPs to look up again, got %v", ips)
+	}
+
+	for _, tt := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"country": {"nl"}}, 1},
+		{url.Values{"q": {`city:Amsterdam org:hosting`}}, 1},
+		{url.Values{"country": {"DE"}}, 0},
+	} {
+		query, err := resultQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query.View = sqlite.ViewAll
+		data, _, err := db.ResultPage(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results) != tt.want {
+			t.Errorf("%v: expected %d results, got %d", tt.query, tt.want, len(data.Results))
+			continue
+		}
+		if tt.want > 0 && (data.Results[0].Geo == nil || data.Results[0].Geo.Org != "Example Hosting") {
+			t.Errorf("%v: expected results with GeoIP data, got %+v", tt.query, data.Results[0])
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// greyNoiseBatch is the most IPs looked up in GreyNoise each run.
+const greyNoiseBatch = 50
+
+// greyNoiseUser is who tags added for GreyNoise are from, so only those are
+// removed when GreyNoise stops classifying an IP as a benign scanner.
+const greyNoiseUser = "greynoise"
+
+// greyNoiseClient queries GreyNoise's Community API, which classifies IPs
+// seen scanning the internet.
+type greyNoiseClient struct {
+	// url is the URL the IP is appended to
+	url string
+	// key is optional, but without one lookups are heavily rate limited
+	key string
+	// tag is the tag added to benign scanners
+	tag     string
+	refresh time.Duration
+	client  *http.Client
+}
+
+func newGreyNoiseClient(baseURL, key, tag string, refresh time.Duration) *greyNoiseClient {
+	return &greyNoiseClient{url: baseURL, key: key, tag: tag, refresh: refresh, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// query looks up ip. IPs GreyNoise knows nothing about are neither noise nor
+// RIOT.
+func (c *greyNoiseClient) query(ip string) (scan.GreyNoise, error) {
+	rec := scan.GreyNoise{IP: ip}
+	req, err := http.NewRequest("GET", c.url+url.PathEscape(ip), nil)
+	if err != nil {
+		return rec, err
+	}
+	if c.key != "" {
+		req.Header.Set("key", c.key)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return rec, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return rec, nil
+	default:
+		return rec, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&rec); err != nil {
+		return rec, err
+	}
+	rec.IP = ip
+	return rec, nil
+}
+
+// benignScanner reports whether GreyNoise classifies g as a benign scanner.
+func benignScanner(g scan.GreyNoise) bool {
+	return g.Noise && g.Classification == "benign"
+}
+
+// enrichGreyNoise looks up the external IPs never looked up, or last looked
+// up longer ago than the refresh interval, and tags those GreyNoise
+// classifies as benign scanners. The tag is removed again if a refresh finds
+// they no longer are, unless someone else added it. Failed lookups aren't
+// saved, so they're tried again on the next run.
+func (app *App) enrichGreyNoise(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	ips, err := app.db.LoadStaleGreyNoiseIPs(now.Add(-app.greyNoise.refresh))
+	if err != nil {
+		log.Println("greynoise: error loading IPs to look up:", err)
+		return
+	}
+	var records []scan.GreyNoise
+	for _, ip := range ips {
+		if addr := net.ParseIP(ip); addr == nil || !isPublicIP(addr) {
+			continue
+		}
+		if len(records) == greyNoiseBatch {
+			break
+		}
+		rec, err := app.greyNoise.query(ip)
+		if err != nil {
+			log.Printf("greynoise: error looking up %s: %v", ip, err)
+			continue
+		}
+		rec.Updated = scan.Time{Time: now.UTC()}
+		records = append(records, rec)
+	}
+	if err := app.db.SaveGreyNoise(records); err != nil {
+		log.Println("greynoise: error saving lookups:", err)
+		return
+	}
+
+	var tagged int
+	for _, rec := range records {
+		t := scan.Tag{IP: rec.IP, Name: app.greyNoise.tag, User: greyNoiseUser, Created: scan.Time{Time: now.UTC()}}
+		if benignScanner(rec) {
+			if err := app.db.SaveTag(t); err != nil {
+				log.Printf("greynoise: error tagging %s: %v", rec.IP, err)
+				continue
+			}
+			tagged++
+			continue
+		}
+		tags, err := app.db.LoadTags(sqlite.SQLFilter{Where: []string{`ip=?`, `name=?`, `user=?`}, Values: []interface{}{t.IP, t.Name, greyNoiseUser}})
+		if err != nil {
+			log.Printf("greynoise: error loading tags for %s: %v", rec.IP, err)
+			continue
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		if err := app.db.DeleteTag(t.IP, t.Name); err != nil && err != sql.ErrNoRows {
+			log.Printf("greynoise: error untagging %s: %v", rec.IP, err)
+		}
+	}
+	if verbose && len(records) > 0 {
+		log.Printf("greynoise: looked up %d IPs, %d benign scanners", len(records), tagged)
+	}
+}
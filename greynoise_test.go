@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestGreyNoise(t *testing.T) {
+	db := createDB("TestGreyNoise")
+	defer db.Close()
+
+	benign := map[string]bool{"192.0.2.1": true, "192.0.2.2": true}
+	var queried []string
+	greyNoise := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("key") != "secret" {
+			http.Error(w, "unauthorised", http.StatusUnauthorized)
+			return
+		}
+		ip := strings.TrimPrefix(r.URL.Path, "/")
+		queried = append(queried, ip)
+		switch {
+		case benign[ip]:
+			w.Write([]byte(`{"ip":"` + ip + `","noise":true,"riot":false,"classification":"benign","name":"Shodan.io","message":"Success"}`))
+		case ip == "192.0.2.2":
+			w.Write([]byte(`{"ip":"192.0.2.2","noise":true,"riot":false,"classification":"malicious","name":"unknown","message":"Success"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"ip":"` + ip + `","noise":false,"riot":false,"message":"IP not observed scanning the internet or contained in RIOT data set."}`))
+		}
+	}))
+	defer greyNoise.Close()
+
+	app := App{db: db, greyNoise: newGreyNoiseClient(greyNoise.URL+"/", "secret", "benign-scanner", 7*24*time.Hour)}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "10.0.0.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	// A tag someone added by hand is left alone
+	if err := db.SaveTag(scan.Tag{IP: "198.51.100.1", Name: "benign-scanner", User: "user@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tagged := func() []string {
+		tags, err := db.LoadTags(sqlite.SQLFilter{Where: []string{`name=?`}, Values: []interface{}{"benign-scanner"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ips []string
+		for _, tag := range tags {
+			ips = append(ips, tag.IP)
+		}
+		return ips
+	}
+
+	now := time.Now()
+	app.enrichGreyNoise(now)
+	if !reflect.DeepEqual(queried, []string{"192.0.2.1", "192.0.2.2", "198.51.100.1"}) {
+		t.Errorf("expected only external IPs looked up, got %v", queried)
+	}
+	if ips := tagged(); !reflect.DeepEqual(ips, []string{"192.0.2.1", "192.0.2.2", "198.51.100.1"}) {
+		t.Errorf("expected benign scanners tagged, got %v", ips)
+	}
+
+	// 192.0.2.2 turns malicious, so loses its tag when it's refreshed
+	delete(benign, "192.0.2.2")
+	queried = nil
+	app.enrichGreyNoise(now.Add(time.Hour))
+	if len(queried) != 0 {
+		t.Errorf("expected no lookups before the refresh, got %v", queried)
+	}
+	app.enrichGreyNoise(now.Add(8 * 24 * time.Hour))
+	if ips := tagged(); !reflect.DeepEqual(ips, []string{"192.0.2.1", "198.51.100.1"}) {
+		t.Errorf("expected 192.0.2.2 untagged, got %v", ips)
+	}
+
+	for ip, want := range map[string]string{
+		"192.0.2.1": "label-success\">benign</span> scanner (Shodan.io)",
+		"192.0.2.2": "label-danger\">malicious</span> scanner",
+	} {
+		res, err := http.Get(ts.URL + "/host/" + ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if !strings.Contains(string(b), want) {
+			t.Errorf("expected %q on the host page for %s", want, ip)
+		}
+	}
+}
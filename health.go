@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Handler for GET /healthz
+// The process is up if it can answer at all.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// Handler for GET /readyz
+// The service is ready once the database is reachable and migrated, and Redis
+// is reachable if it's used.
+func (app *App) readyz(w http.ResponseWriter, r *http.Request) {
+	if err := app.db.Ready(); err != nil {
+		log.Println("readyz: database not ready:", err)
+		http.Error(w, "database not ready", http.StatusServiceUnavailable)
+		return
+	}
+	if app.kv != nil {
+		if err := app.kv.ping(); err != nil {
+			log.Println("readyz: store not ready:", err)
+			http.Error(w, "store not ready", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	db := createDB("TestHealth")
+	app := App{db: db}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+
+	check := func(path string, code int) {
+		t.Helper()
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("%s: expected status %d, got %d", path, code, res.StatusCode)
+		}
+	}
+
+	check("/healthz", http.StatusOK)
+	check("/readyz", http.StatusOK)
+
+	// A rolled back database isn't ready
+	if _, err := db.Exec(`DELETE FROM goose_db_version WHERE version_id = (SELECT max(version_id) FROM goose_db_version)`); err != nil {
+		t.Fatal(err)
+	}
+	check("/readyz", http.StatusServiceUnavailable)
+
+	db.Close()
+	check("/healthz", http.StatusOK)
+	check("/readyz", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// hostPort is a port on the host page with its history, notes, what Censys
+// has seen on it, the CVEs it may be vulnerable to, nuclei's findings, its
+// screenshot and the attributes enrichers have set on it.
+type hostPort struct {
+	scan.IPInfo
+	Uptime     *scan.Window
+	History    []scan.Period
+	Banners    []scan.Banner
+	Notes      []scan.Note
+	Censys     *scan.CensysService
+	Vulns      []scan.Vulnerability
+	Findings   []scan.Finding
+	Screenshot *scan.Screenshot
+	Attributes []scan.Attribute
+}
+
+type hostData struct {
+	indexData
+	IP         string
+	Ports      []hostPort
+	PTR        []string
+	PassiveDNS []scan.PassiveDNS
+	Tags       []scan.Tag
+	Notes      []scan.Note
+	Networks   []string
+	Geo        *scan.Geo
+	Whois      *scan.Whois
+	InternetDB *scan.InternetDB
+	Shodan     *internetDBDiff
+	Censys     *censysComparison
+	GreyNoise  *scan.GreyNoise
+}
+
+// hostNetwork returns the network containing only ip, for searching the
+// results for exactly that host.
+func hostNetwork(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+	return ip.String() + "/128"
+}
+
+// Handler for GET /host/{ip}
+// This shows every port seen open on the host, whether or not it's closed
+// now or is ignored, with the periods it was open, the banners seen on it and
+// the notes left on it.
+func (app *App) host(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if !authDisabled {
+		u := currentUser(r)
+		if u == nil {
+			tmpl.ExecuteTemplate(w, "index", loginData(w, r))
+			return
+		}
+		user = *u
+	}
+
+	ip := scan.NormalizeIP(chi.URLParam(r, "ip"))
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		http.Error(w, "Invalid IP", http.StatusBadRequest)
+		return
+	}
+
+	results, _, err := app.db.ResultPage(sqlite.ResultQuery{IP: hostNetwork(addr), View: sqlite.ViewAll, Ignored: true, Tenant: user.Tenant, CIDRs: user.CIDRs})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Hosts outside a tenant's address space don't exist as far as it knows
+	if len(results.Results) == 0 {
+		http.Error(w, "Host not found", http.StatusNotFound)
+		return
+	}
+	app.reputationLists.annotate(results.Results)
+	app.self.annotate(results.Results)
+
+	windows, err := app.db.LoadUptime(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	uptime := make(map[string]*scan.Window)
+	for i, w := range windows {
+		uptime[portKey(w.IP, w.Port, w.Proto)] = &windows[i]
+	}
+
+	timelines, err := app.db.LoadTimelines(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	periods := make(map[string][]scan.Period)
+	for _, t := range timelines {
+		periods[portKey(t.IP, t.Port, t.Proto)] = t.Periods
+	}
+
+	bannerHistory, err := app.db.LoadBannerHistory(ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	banners := make(map[string][]scan.Banner)
+	for _, b := range bannerHistory {
+		k := portKey(b.IP, b.Port, b.Proto)
+		banners[k] = append(banners[k], b)
+	}
+
+	pdns, err := app.db.LoadPassiveDNS(ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	findingList, err := app.db.LoadFindings(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	findings := make(map[string][]scan.Finding)
+	for _, f := range findingList {
+		k := portKey(f.IP, f.Port, f.Proto)
+		findings[k] = append(findings[k], f)
+	}
+
+	screenshotList, err := app.db.LoadScreenshots(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	screenshots := make(map[string]*scan.Screenshot)
+	for i, s := range screenshotList {
+		screenshots[portKey(s.IP, s.Port, s.Proto)] = &screenshotList[i]
+	}
+
+	attrList, err := app.db.LoadAttributes(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	attrs := make(map[string][]scan.Attribute)
+	for _, a := range attrList {
+		k := portKey(a.IP, a.Port, a.Proto)
+		attrs[k] = append(attrs[k], a)
+	}
+
+	vulnList, err := app.db.LoadVulnerabilities(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	vulns := make(map[string][]scan.Vulnerability)
+	for _, v := range vulnList {
+		k := portKey(v.IP, v.Port, v.Proto)
+		vulns[k] = append(vulns[k], v)
+	}
+
+	greyNoise, err := app.db.LoadGreyNoise(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	censys, err := app.db.LoadCensys(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	internetDB, err := app.db.LoadInternetDB(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rdns, err := app.db.LoadRDNS(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tags, err := app.db.LoadTags(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	noteList, err := app.db.LoadNotes(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{ip}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var hostNotes []scan.Note
+	notes := make(map[string][]scan.Note)
+	for _, n := range noteList {
+		if n.Port == 0 {
+			hostNotes = append(hostNotes, n)
+			continue
+		}
+		k := portKey(n.IP, n.Port, n.Proto)
+		notes[k] = append(notes[k], n)
+	}
+
+	fields, err := app.db.LoadFields()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := hostData{
+		indexData: indexData{
+			Authenticated: true,
+			User:          user,
+			URI:           r.URL.Path,
+			Fields:        fields,
+			Data:          results,
+		},
+		IP:         ip,
+		PassiveDNS: pdns,
+		Tags:       tags,
+		Notes:      hostNotes,
+		Networks:   results.Results[0].Networks,
+		Geo:        results.Results[0].Geo,
+		Whois:      results.Results[0].Whois,
+	}
+	if len(rdns) > 0 {
+		data.PTR = rdns[0].Names
+	}
+	if len(internetDB) > 0 {
+		data.InternetDB = &internetDB[0]
+		data.Shodan = &diffInternetDB(internetDB, results.Results)[0]
+	}
+	if len(greyNoise) > 0 && (greyNoise[0].Noise || greyNoise[0].RIOT) {
+		data.GreyNoise = &greyNoise[0]
+	}
+	censysServices := make(map[string]*scan.CensysService)
+	if len(censys) > 0 {
+		c := compareCensys(censys[0], results.Results)
+		data.Censys = &c
+		for i, s := range censys[0].Services {
+			censysServices[portKey(ip, s.Port, s.Proto)] = &censys[0].Services[i]
+		}
+	}
+	for _, res := range results.Results {
+		k := portKey(res.IP, res.Port, res.Proto)
+		data.Ports = append(data.Ports, hostPort{IPInfo: res, Uptime: uptime[k], History: periods[k], Banners: banners[k], Notes: notes[k], Censys: censysServices[k], Vulns: vulns[k], Findings: findings[k], Screenshot: screenshots[k], Attributes: attrs[k]})
+	}
+	tmpl.ExecuteTemplate(w, "host", data)
+}
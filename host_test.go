@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestHost(t *testing.T) {
+	db := createDB("TestHost")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	first := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Second)
+	now := first.Add(time.Hour)
+	ssh := func(banner string) scan.Port {
+		p := scan.Port{Port: 22, Proto: "tcp"}
+		p.Service.Name = "ssh"
+		p.Service.Banner = banner
+		return p
+	}
+	for _, sub := range []struct {
+		results []scan.Result
+		at      time.Time
+	}{
+		{[]scan.Result{
+			{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+			{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+			{IP: "192.0.2.1", Ports: []scan.Port{ssh("SSH-2.0-OpenSSH_7.4")}},
+			{IP: "192.0.2.10", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		}, first},
+		{[]scan.Result{
+			{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+			{IP: "192.0.2.1", Ports: []scan.Port{ssh("SSH-2.0-OpenSSH_8.0")}},
+		}, now},
+	} {
+		if _, _, err := db.SaveData(sub.results, sub.at); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveSubmission("scanner", nil, sub.at, scan.Run{Scope: scan.ScopeAll}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := http.Get(ts.URL + "/host/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	body := string(b)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", res.StatusCode, body)
+	}
+	// Port 80 closed in the second scan, so was open for half of them
+	for _, want := range []string{"<td>22</td>", "<td>80</td>", "Closed", "50%", "SSH-2.0-OpenSSH_7.4", "SSH-2.0-OpenSSH_8.0"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q on the host page", want)
+		}
+	}
+	// Only the exact host is shown, not others it's a prefix of
+	if strings.Contains(body, "<td>443</td>") {
+		t.Error("expected only ports on 192.0.2.1")
+	}
+
+	for path, code := range map[string]int{
+		"/host/192.0.2.99":  http.StatusNotFound,
+		"/host/not-an-ip":   http.StatusBadRequest,
+		"/host/192.0.2.1/x": http.StatusNotFound,
+	} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("%s: expected status %d, got %d", path, code, res.StatusCode)
+		}
+	}
+}
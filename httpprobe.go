@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/tls"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// httpProbeBatch is the most ports probed over HTTP each run.
+const httpProbeBatch = 100
+
+// httpProbeWorkers is how many ports are probed at once.
+const httpProbeWorkers = 8
+
+// httpMaxRedirects is how many redirects are followed from each port.
+const httpMaxRedirects = 5
+
+// httpBodyLimit is how much of each page is read looking for its title.
+const httpBodyLimit = 64 << 10
+
+var titleRE = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// httpProber requests / from open web ports, as httpx does, to record what's
+// running on them.
+type httpProber struct {
+	// ports are the ranges of TCP ports probed
+	ports [][2]int
+	// refresh is how long responses are kept before ports are probed again
+	refresh time.Duration
+	timeout time.Duration
+	// transport doesn't verify certificates, as the response is recorded
+	// rather than trusted
+	transport *http.Transport
+}
+
+func newHTTPProber(ports [][2]int, refresh, timeout time.Duration) *httpProber {
+	return &httpProber{
+		ports:   ports,
+		refresh: refresh,
+		timeout: timeout,
+		transport: &http.Transport{
+			DialContext:       (&net.Dialer{Timeout: timeout}).DialContext,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+// probe requests / from r's port over HTTPS, then over HTTP if that fails.
+// A port which answers neither has a response with status 0, so it isn't
+// probed again until the refresh interval has passed.
+func (p *httpProber) probe(r scan.IPInfo) scan.HTTPResponse {
+	res := scan.HTTPResponse{IP: r.IP, Port: r.Port, Proto: r.Proto}
+	host := net.JoinHostPort(r.IP, strconv.Itoa(r.Port))
+	for _, scheme := range []string{"https", "http"} {
+		if err := p.fetch(scheme+"://"+host+"/", r.IP, &res); err != nil {
+			if verbose {
+				log.Printf("httpprobe: error probing %s port %d over %s: %v", r.IP, r.Port, scheme, err)
+			}
+			continue
+		}
+		break
+	}
+	return res
+}
+
+// fetch requests rawurl, following redirects which stay on ip, and records
+// the response in res.
+func (p *httpProber) fetch(rawurl, ip string, res *scan.HTTPResponse) error {
+	var redirects []string
+	client := &http.Client{
+		Transport: p.transport,
+		Timeout:   p.timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			redirects = append(redirects, req.URL.String())
+			if len(via) >= httpMaxRedirects || req.URL.Hostname() != ip {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	resp, err := client.Get(rawurl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, httpBodyLimit))
+	if err != nil {
+		return err
+	}
+	res.URL = rawurl
+	res.Status = resp.StatusCode
+	res.Server = resp.Header.Get("Server")
+	res.Title = pageTitle(body)
+	res.Redirects = redirects
+	return nil
+}
+
+// pageTitle returns the text of an HTML page's title element, with
+// whitespace collapsed.
+func pageTitle(body []byte) string {
+	m := titleRE.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+}
+
+// enrichHTTP probes the web ports never probed, or last probed longer ago
+// than the refresh interval.
+func (app *App) enrichHTTP(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	stale, err := app.db.LoadStaleHTTPPorts(now.Add(-app.httpProbe.refresh))
+	if err != nil {
+		log.Println("httpprobe: error loading ports to probe:", err)
+		return
+	}
+	var ports []scan.IPInfo
+	for _, r := range stale {
+		if len(ports) == httpProbeBatch {
+			break
+		}
+		if inPortRanges(app.httpProbe.ports, r.Port) {
+			ports = append(ports, r)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	responses := make([]scan.HTTPResponse, 0, len(ports))
+	work := make(chan scan.IPInfo)
+	for i := 0; i < httpProbeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range work {
+				res := app.httpProbe.probe(r)
+				res.Updated = scan.Time{Time: now.UTC()}
+				mu.Lock()
+				responses = append(responses, res)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, r := range ports {
+		work <- r
+	}
+	close(work)
+	wg.Wait()
+
+	if err := app.db.SaveHTTPResponses(responses); err != nil {
+		log.Println("httpprobe: error saving responses:", err)
+		return
+	}
+	if verbose && len(responses) > 0 {
+		log.Printf("httpprobe: probed %d ports", len(responses))
+	}
+}
+
+// Handler for GET /api/v1/http
+//
+// Lists the responses from web ports, optionally only those on ip.
+func (app *App) listHTTPResponses(w http.ResponseWriter, r *http.Request) {
+	filter := sqlite.SQLFilter{Where: []string{`status != 0`}}
+	if ip := r.URL.Query().Get("ip"); ip != "" {
+		filter.Where = append(filter.Where, `ip = ?`)
+		filter.Values = append(filter.Values, scan.NormalizeIP(ip))
+	}
+	responses, err := app.db.LoadHTTPResponses(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, responses)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestHTTPProbe(t *testing.T) {
+	db := createDB("TestHTTPProbe")
+	defer db.Close()
+
+	jenkins := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/login", http.StatusFound)
+		case "/login":
+			w.Header().Set("Server", "Jetty(9.4.z-SNAPSHOT)")
+			w.Write([]byte("<html><head><TITLE>\n  Sign in [Jenkins]\n</TITLE></head></html>"))
+		}
+	}))
+	defer jenkins.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://www.example.com/", http.StatusMovedPermanently)
+	}))
+	defer secure.Close()
+
+	servers := map[string]string{
+		"192.0.2.1:8080": jenkins.Listener.Addr().String(),
+		"192.0.2.2:443":  secure.Listener.Addr().String(),
+	}
+	httpProbe := newHTTPProber([][2]int{{443, 443}, {8080, 8080}}, 24*time.Hour, time.Second)
+	dial := httpProbe.transport.DialContext
+	var mu sync.Mutex
+	var dialled []string
+	httpProbe.transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialled = append(dialled, addr)
+		mu.Unlock()
+		if server, ok := servers[addr]; ok {
+			addr = server
+		} else {
+			addr = "127.0.0.1:1"
+		}
+		return dial(ctx, network, addr)
+	}
+
+	app := App{db: db, httpProbe: httpProbe}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 8080, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.3", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	app.enrichHTTP(now)
+	for _, addr := range dialled {
+		if strings.HasSuffix(addr, ":22") {
+			t.Errorf("expected only web ports probed, dialled %s", addr)
+		}
+	}
+
+	all, err := db.LoadHTTPResponses(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected 3 probed ports, got %+v", all)
+	}
+	if r := all[0]; r.URL != "http://192.0.2.1:8080/" || r.Status != 200 || r.Title != "Sign in [Jenkins]" || r.Server != "Jetty(9.4.z-SNAPSHOT)" ||
+		!reflect.DeepEqual(r.Redirects, []string{"http://192.0.2.1:8080/login"}) {
+		t.Errorf("unexpected response from plain HTTP %+v", r)
+	}
+	if r := all[1]; r.URL != "https://192.0.2.2:443/" || r.Status != 301 || !reflect.DeepEqual(r.Redirects, []string{"https://www.example.com/"}) {
+		t.Errorf("expected HTTPS redirect elsewhere recorded without following it, got %+v", r)
+	}
+	if r := all[2]; r.Status != 0 {
+		t.Errorf("expected no response from a closed port, got %+v", r)
+	}
+
+	dialled = nil
+	app.enrichHTTP(now.Add(time.Hour))
+	if len(dialled) != 0 {
+		t.Errorf("expected no probes before the refresh, got %v", dialled)
+	}
+
+	for _, tt := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"title": {"jenkins"}}, 1},
+		{url.Values{"q": {"server:jetty"}}, 1},
+		{url.Values{"q": {"title:grafana"}}, 0},
+	} {
+		query, err := resultQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _, err := db.ResultPage(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results) != tt.want {
+			t.Errorf("%v: expected %d results, got %d", tt.query, tt.want, len(data.Results))
+			continue
+		}
+		if tt.want == 1 && (data.Results[0].HTTP == nil || data.Results[0].HTTP.Status != 200) {
+			t.Errorf("%v: expected the response on the result, got %+v", tt.query, data.Results[0])
+		}
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/http?ip=192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []scan.HTTPResponse
+	err = json.NewDecoder(res.Body).Decode(&listed)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Status != 301 {
+		t.Errorf("expected the response from 192.0.2.2, got %+v", listed)
+	}
+
+	res, err = http.Get(ts.URL + "/host/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(b), "<strong>Sign in [Jenkins]</strong>") {
+		t.Error("expected the page title on the host page")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// checkIgnoreRule validates an ignore rule and tidies its CIDR and ports.
+func checkIgnoreRule(r *scan.IgnoreRule) error {
+	r.CIDR = strings.TrimSpace(r.CIDR)
+	r.Ports = strings.ReplaceAll(r.Ports, " ", "")
+	if r.CIDR == "" && r.Ports == "" {
+		return errors.New("an ignore rule needs a CIDR, ports or both")
+	}
+	if r.CIDR != "" {
+		_, network, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", r.CIDR)
+		}
+		r.CIDR = network.String()
+	}
+	if r.Ports != "" {
+		if _, err := scan.ParsePortSpec(r.Ports); err != nil {
+			return err
+		}
+	}
+	switch r.Proto {
+	case "", "tcp", "udp":
+	default:
+		return fmt.Errorf("invalid proto %q", r.Proto)
+	}
+	return nil
+}
+
+// auditIgnoreRule records a change to an ignore rule in the audit log.
+func (app *App) auditIgnoreRule(user *User, event string, r scan.IgnoreRule) {
+	info, _ := json.Marshal(r)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditIgnoreRule: error saving %s: %v", event, err)
+	}
+}
+
+// Handler for GET /api/v1/ignore
+func (app *App) listIgnoreRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.db.LoadIgnoreRules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, rules)
+}
+
+// Handler for POST /api/v1/ignore
+// Rules with ingest set only apply to results submitted after they're added.
+func (app *App) newIgnoreRule(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var rule scan.IgnoreRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkIgnoreRule(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.User = user.Email
+	rule.Created = scan.Time{Time: time.Now().UTC()}
+	id, err := app.db.SaveIgnoreRule(rule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rule.ID = id
+	app.auditIgnoreRule(user, "add_ignore_rule", rule)
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, rule)
+}
+
+// Handler for DELETE /api/v1/ignore/{id}
+func (app *App) deleteIgnoreRule(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+	err = app.db.DeleteIgnoreRule(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Ignore rule not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditIgnoreRule(user, "delete_ignore_rule", scan.IgnoreRule{ID: id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
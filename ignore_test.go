@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	db := createDB("TestIgnoreRules")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/api/v1/ignore", "application/json", bytes.NewBufferString(`{"cidr": "192.0.2.0/24"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without a user, got %d", res.StatusCode)
+	}
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "user@example.com"}}}
+
+	var honeypot int64
+	for _, tt := range []struct {
+		rule string
+		code int
+	}{
+		{`{"cidr": "192.0.2.10/32", "reason": "Honeypot"}`, http.StatusCreated},
+		{`{"cidr": "198.51.100.0/24", "ports": "1024-65535", "proto": "udp", "ingest": true, "reason": "NAT pool"}`, http.StatusCreated},
+		{`{"ports": "113"}`, http.StatusCreated},
+		{`{"reason": "Everything"}`, http.StatusBadRequest},
+		{`{"cidr": "192.0.2.0/33"}`, http.StatusBadRequest},
+		{`{"ports": "80-"}`, http.StatusBadRequest},
+		{`{"ports": "80", "proto": "sctp"}`, http.StatusBadRequest},
+	} {
+		res, err := http.Post(ts.URL+"/api/v1/ignore", "application/json", bytes.NewBufferString(tt.rule))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.rule, tt.code, res.StatusCode)
+		}
+		if res.StatusCode == http.StatusCreated && honeypot == 0 {
+			var r scan.IgnoreRule
+			if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+				t.Fatal(err)
+			}
+			honeypot = r.ID
+		}
+		res.Body.Close()
+	}
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 113, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.10", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 53, Proto: "udp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 40000, Proto: "udp", Status: "open"}}},
+	}
+	count, _, err := db.SaveData(results, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("expected the NAT pool port to be dropped, saved %d ports", count)
+	}
+
+	for _, tt := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{}, 2},
+		{url.Values{"ignored": {"true"}}, 4},
+		{url.Values{"ip": {"192.0.2.10"}}, 0},
+	} {
+		query, err := resultQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query.View = sqlite.ViewAll
+		data, _, err := db.ResultPage(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results) != tt.want || data.Total != tt.want {
+			t.Errorf("%v: expected %d results, got %d of %d", tt.query, tt.want, len(data.Results), data.Total)
+		}
+	}
+
+	// Hosts' own pages still show ignored ports
+	res, err = http.Get(ts.URL + "/host/192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 for an ignored host's page, got %d", res.StatusCode)
+	}
+
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/api/v1/ignore/%d", ts.URL, honeypot), nil)
+	for _, code := range []int{http.StatusNoContent, http.StatusNotFound} {
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("expected status %d deleting rule, got %d", code, res.StatusCode)
+		}
+	}
+	data, _, err := db.ResultPage(sqlite.ResultQuery{View: sqlite.ViewAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Total != 3 {
+		t.Errorf("expected the honeypot shown again after deleting its rule, got %d results", data.Total)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// errNotScan is returned for files which aren't scanner output.
+var errNotScan = errors.New("not masscan or nmap output")
+
+// importFile is a scanner output file being imported.
+type importFile struct {
+	path string
+	// time is when the scan finished: its latest result, or when the file
+	// was written if any result has no timestamp
+	time time.Time
+	// modified is when the file was written, used for results without a
+	// timestamp
+	modified time.Time
+}
+
+// readScanFile reads the results in masscan's -oJ or nmap's or masscan's -oX
+// output, which may be gzipped. The format is told from the content.
+func readScanFile(path string) ([]scan.Result, scan.Run, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, scan.Run{}, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, scan.Run{}, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	br := bufio.NewReader(r)
+	var first byte
+	for {
+		if first, err = br.ReadByte(); err == io.EOF {
+			// masscan writes nothing when it finds nothing
+			return nil, scan.Run{Scanner: "masscan"}, nil
+		} else if err != nil {
+			return nil, scan.Run{}, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(first)) {
+			break
+		}
+	}
+	br.UnreadByte()
+
+	switch first {
+	case '<':
+		return scan.ParseNmapXML(br)
+	case '{', '[':
+		var results []scan.Result
+		err := json.NewDecoder(scan.MasscanJSON(br)).Decode(&results)
+		return results, scan.Run{Scanner: "masscan"}, err
+	}
+	return nil, scan.Run{}, errNotScan
+}
+
+// scanTime returns when the scan in a file finished.
+func scanTime(results []scan.Result, modified time.Time) time.Time {
+	var t time.Time
+	for _, r := range results {
+		if r.Timestamp.IsZero() {
+			return modified
+		}
+		if r.Timestamp.After(t) {
+			t = r.Timestamp.Time
+		}
+	}
+	if t.IsZero() {
+		return modified
+	}
+	return t
+}
+
+// runImport implements scan import, which loads old masscan and nmap output
+// from files or directories, so the history starts before Scan was used.
+// Results keep their original timestamps, however old, and each file is
+// recorded as a submission at the time its scan finished. Files are imported
+// oldest first, as if they'd been submitted as they were scanned. They only
+// close ports if given the scope the scans covered. The database is backed
+// up first so the import can be undone.
+func runImport(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(out)
+	dir := fs.String("data.dir", ".", "Data directory `path`")
+	host := fs.String("host", "import", "`Host` to record the submissions as from")
+	scope := fs.String("scope", "", "Comma-separated `ranges` the scans covered")
+	ports := fs.String("ports", "", "`Ports` the scans covered, in masscan's -p syntax, if not all")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no files or directories to import")
+	}
+	var ranges []string
+	if *scope != "" {
+		var err error
+		if ranges, err = parseMasscanRanges(*scope); err != nil {
+			return fmt.Errorf("invalid -scope: %v", err)
+		}
+	}
+	if *ports != "" {
+		if ranges == nil {
+			return errors.New("-ports needs a -scope")
+		}
+		if _, err := scan.ParseMasscanPorts(*ports); err != nil {
+			return fmt.Errorf("invalid -ports: %v", err)
+		}
+	}
+
+	// Find the files and when they were scanned first, so they can be
+	// imported in order without holding every result in memory
+	var files []importFile
+	var skipped int
+	for _, root := range fs.Args() {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			results, _, err := readScanFile(path)
+			if err != nil {
+				fmt.Fprintf(out, "Skipping %s: %v\n", path, err)
+				skipped++
+				return nil
+			}
+			modified := info.ModTime().UTC()
+			files = append(files, importFile{path: path, time: scanTime(results, modified), modified: modified})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(files) == 0 {
+		return errors.New("no masscan or nmap output found")
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].time.Before(files[j].time) })
+
+	dsn, err := sqlite.DefaultOptions.DSN(filepath.Join(*dir, sqlite.DefaultDBFile))
+	if err != nil {
+		return err
+	}
+	db, err := sqlite.Open(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	backup := filepath.Join(*dir, fmt.Sprintf("%s.%s.bak", sqlite.DefaultDBFile, time.Now().UTC().Format("20060102T150405Z")))
+	if err := db.Backup(backup); err != nil {
+		return fmt.Errorf("failed to back up database: %v", err)
+	}
+	fmt.Fprintln(out, "Backed up database to", backup)
+
+	var count int64
+	for _, f := range files {
+		results, run, err := readScanFile(f.path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.path, err)
+		}
+		for i := range results {
+			results[i].IP = scan.NormalizeIP(results[i].IP)
+			results[i].Source = *host
+		}
+		n, _, err := db.SaveData(results, f.modified)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.path, err)
+		}
+		run.Started = runStarted(results, f.modified)
+		run.Scope, run.Ports = ranges, *ports
+		if err := db.SaveSubmission(*host, nil, f.time, run); err != nil {
+			return fmt.Errorf("%s: %v", f.path, err)
+		}
+		count += n
+	}
+
+	fmt.Fprintf(out, "Imported %d files with %d ports, skipped %d\n", len(files), count, skipped)
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+const nmapOutput = `<?xml version="1.0" encoding="UTF-8"?>
+<nmaprun scanner="nmap" args="nmap -sV -oX old.xml 192.0.2.0/24" start="1546300800" version="7.70">
+<host starttime="1546300800" endtime="1546300900"><status state="up"/>
+<address addr="192.0.2.1" addrtype="ipv4"/>
+<ports>
+<port protocol="tcp" portid="22"><state state="open" reason="syn-ack"/><service name="ssh" product="OpenSSH" version="7.4"/></port>
+<port protocol="tcp" portid="23"><state state="closed" reason="reset"/></port>
+</ports>
+</host>
+</nmaprun>
+`
+
+func TestRunImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "archive")
+	if err := os.MkdirAll(filepath.Join(archive, "2020"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(`{"ip": "192.0.2.2", "timestamp": "1590000000", "ports": [{"port": 80, "proto": "tcp", "status": "open"}]},` + "\n{finished: 1}\n"))
+	w.Close()
+	for name, content := range map[string]string{
+		"old.xml":           nmapOutput,
+		"2020/scan.json":    `{"ip": "192.0.2.1", "timestamp": "1580000000", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]},` + "\n",
+		"2020/scan.json.gz": gz.String(),
+		"2020/empty.json":   "",
+		"README.txt":        "Scans from the old scanner",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(archive, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := runImport([]string{"-data.dir", dir, "-scope", "0.0.0.0/0,::/0", archive}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Imported 4 files with 3 ports, skipped 1") {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	if backups, _ := filepath.Glob(filepath.Join(dir, "*.bak")); len(backups) != 1 {
+		t.Errorf("expected a backup, got %v", backups)
+	}
+
+	dsn, _ := sqlite.DefaultOptions.DSN(filepath.Join(dir, sqlite.DefaultDBFile))
+	db, err := sqlite.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string][2]time.Time)
+	for _, r := range data {
+		seen[portKey(r.IP, r.Port, r.Proto)] = [2]time.Time{r.FirstSeen.UTC(), r.LastSeen.UTC()}
+	}
+	want := map[string][2]time.Time{
+		"192.0.2.1:22/tcp": {time.Unix(1546300900, 0).UTC(), time.Unix(1580000000, 0).UTC()},
+		"192.0.2.2:80/tcp": {time.Unix(1590000000, 0).UTC(), time.Unix(1590000000, 0).UTC()},
+	}
+	for k, w := range want {
+		if got := seen[k]; !got[0].Equal(w[0]) || !got[1].Equal(w[1]) {
+			t.Errorf("%s: expected seen %v, got %v", k, w, got)
+		}
+	}
+	if len(seen) != len(want) {
+		t.Errorf("expected only open ports imported, got %v", seen)
+	}
+	if banners, _ := db.LoadBannerHistory("192.0.2.1"); len(banners) != 1 || banners[0].Banner != "OpenSSH 7.4" {
+		t.Errorf("expected nmap's service as a banner, got %+v", banners)
+	}
+
+	// Both scans of 192.0.2.1 count towards its history
+	timelines, err := db.LoadTimelines(sqlite.SQLFilter{Where: []string{"ip = ?", "port = ?"}, Values: []interface{}{"192.0.2.1", 22}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timelines) != 1 || len(timelines[0].Periods) == 0 {
+		t.Fatalf("expected a history for the port, got %+v", timelines)
+	}
+	if p := timelines[0].Periods[0]; !p.From.Equal(time.Unix(1546300900, 0)) || p.Scans != 2 {
+		t.Errorf("expected the history to start with the nmap scan and include both, got %+v", p)
+	}
+
+	if err := runImport([]string{"-data.dir", dir, filepath.Join(archive, "README.txt")}, ioutil.Discard); err == nil {
+		t.Error("expected an error importing nothing")
+	}
+	for _, args := range [][]string{{"-scope", "192.0.2.0/33"}, {"-ports", "22"}, {"-scope", "192.0.2.0/24", "-ports", "ssh"}} {
+		if err := runImport(append(append([]string{"-data.dir", dir}, args...), archive), ioutil.Discard); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+// TestRunImportOlder checks importing old scans into a database with newer
+// results doesn't make them the latest scan.
+func TestRunImportOlder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn, _ := sqlite.DefaultOptions.DSN(filepath.Join(dir, sqlite.DefaultDBFile))
+	db, err := sqlite.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	live := []scan.Result{{IP: "192.0.2.3", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}}}
+	if _, _, err := db.SaveData(live, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, now, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	old := filepath.Join(dir, "old.json")
+	if err := ioutil.WriteFile(old, []byte(`{"ip": "192.0.2.1", "timestamp": "1580000000", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]},`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := runImport([]string{"-data.dir", dir, old}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = sqlite.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sub, err := db.LoadSubmission(sqlite.SQLFilter{Where: []string{"job_id IS NULL"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Host != "192.0.2.100" || !sub.Time.Equal(now) {
+		t.Errorf("expected the live scan to be the latest, got %+v", sub)
+	}
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone := make(map[string]bool)
+	for _, r := range data {
+		gone[r.IP] = r.Gone
+	}
+	if want := map[string]bool{"192.0.2.1": true, "192.0.2.3": false}; !reflect.DeepEqual(gone, want) {
+		t.Errorf("expected only the imported port closed, got %v", gone)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// ingestBatch is the most queued submissions a worker saves together.
+const ingestBatch = 20
+
+// A submission which can't be saved is retried ingestRetries times, waiting
+// longer each time, before it's returned to the spool. Once it's been
+// returned ingestAttempts times it's set aside rather than retried forever.
+// Retries wait in the background so the workers carry on with the queue.
+const (
+	ingestRetries  = 3
+	ingestAttempts = 10
+)
+
+var (
+	errIngestFull   = errors.New("ingest queue is full")
+	errIngestClosed = errors.New("ingest queue is closed")
+)
+
+// ingestJob is a results submission waiting to be saved. It's also the
+// format of spooled submissions.
+type ingestJob struct {
+	Host    string        `json:"host"`
+	Agent   string        `json:"agent,omitempty"`
+	Time    time.Time     `json:"time"`
+	Run     scan.Run      `json:"run"`
+	Results []scan.Result `json:"results"`
+	Payload []byte        `json:"payload,omitempty"`
+	// Attempts counts the times saving it has failed
+	Attempts int `json:"attempts,omitempty"`
+
+	// What's been saved is cleared or marked, so retrying a submission
+	// doesn't save it twice. Results and Payload are cleared once they're
+	// saved, Added is the ports its results added, to notify of, and
+	// Submitted is set once the submission is recorded.
+	Added     []scan.IPInfo `json:"added,omitempty"`
+	Submitted bool          `json:"submitted,omitempty"`
+}
+
+// saved reports whether everything in the submission has been saved.
+func (job *ingestJob) saved() bool {
+	return job.Submitted && job.Payload == nil
+}
+
+// ingester queues results submissions for a pool of workers to save, so
+// scanners don't wait for the database. When the queue is full submissions
+// are spilled to files in spool, if set, and queued again as it empties.
+// Once spoolMax submissions are spooled too, scanners are told to retry.
+type ingester struct {
+	jobs     chan *ingestJob
+	spool    string
+	spoolMax int
+	retry    time.Duration // how long scanners should wait when it's full
+	backoff  time.Duration // how long to wait before saving again after an error
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	seq     int
+	spooled int // submissions waiting in the spool
+}
+
+func newIngester(size int, spool string, spoolMax int, retry time.Duration) (*ingester, error) {
+	in := &ingester{jobs: make(chan *ingestJob, size), spool: spool, spoolMax: spoolMax, retry: retry, backoff: time.Second}
+	if spool != "" {
+		if err := os.MkdirAll(spool, 0700); err != nil {
+			return nil, err
+		}
+		// Count any left from before a restart
+		files, err := filepath.Glob(filepath.Join(spool, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		in.spooled = len(files)
+	}
+	return in, nil
+}
+
+// enqueue queues job, spilling it to the spool if the queue is full. If
+// there's no spool, or it's full too, it returns errIngestFull.
+func (in *ingester) enqueue(job *ingestJob) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.closed {
+		return errIngestClosed
+	}
+	select {
+	case in.jobs <- job:
+		gaugeIngestQueue.Set(float64(len(in.jobs)))
+		return nil
+	default:
+	}
+	if in.spool == "" || in.spooled >= in.spoolMax {
+		return errIngestFull
+	}
+	return in.spill(job)
+}
+
+// spill writes job to a new file in the spool. Files are named so they sort
+// in the order they were spilled. in.mu must be held.
+func (in *ingester) spill(job *ingestJob) error {
+	if _, err := in.spillAs(job, ".json"); err != nil {
+		return err
+	}
+	in.spooled++
+	return nil
+}
+
+// spillAs writes job to a new file in the spool with the given extension,
+// returning its name. in.mu must be held.
+func (in *ingester) spillAs(job *ingestJob, ext string) (string, error) {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return "", err
+	}
+	in.seq++
+	name := filepath.Join(in.spool, fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), in.seq, ext))
+	// Write to a temporary file first so a partial file is never queued
+	if err := ioutil.WriteFile(name+".tmp", b, 0600); err != nil {
+		return "", err
+	}
+	return name, os.Rename(name+".tmp", name)
+}
+
+// requeue returns a submission which couldn't be saved to the spool, to be
+// queued again later. It's already been accepted, so it's returned even if
+// the spool is full. One which has failed ingestAttempts times is kept as a
+// .bad file instead, for an operator to look at. Without a spool it's lost.
+func (in *ingester) requeue(job *ingestJob) error {
+	if in.spool == "" {
+		return errors.New("no spool to return it to, submission lost")
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	job.Attempts++
+	if job.Attempts >= ingestAttempts {
+		name, err := in.spillAs(job, ".json.bad")
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("failed %d times, set aside as %s", job.Attempts, name)
+	}
+	return in.spill(job)
+}
+
+// unspool queues the oldest spooled submission if there's room. It reports
+// whether there was one.
+func (in *ingester) unspool() (bool, error) {
+	files, err := filepath.Glob(filepath.Join(in.spool, "*.json"))
+	if err != nil || len(files) == 0 {
+		return false, err
+	}
+	sort.Strings(files)
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		return false, err
+	}
+	job := new(ingestJob)
+	if err := json.Unmarshal(b, job); err != nil {
+		// Move it aside rather than failing on it forever
+		if os.Rename(files[0], files[0]+".bad") == nil {
+			in.mu.Lock()
+			in.spooled--
+			in.mu.Unlock()
+		}
+		return true, fmt.Errorf("%s: %v", files[0], err)
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.closed {
+		return false, nil
+	}
+	select {
+	case in.jobs <- job:
+		gaugeIngestQueue.Set(float64(len(in.jobs)))
+	default:
+		return false, nil
+	}
+	in.spooled--
+	return true, os.Remove(files[0])
+}
+
+// runSpool queues spooled submissions, including any left from before a
+// restart, whenever the queue is less than half full.
+func (in *ingester) runSpool() {
+	for range time.Tick(time.Second) {
+		for len(in.jobs) < cap(in.jobs)/2 {
+			ok, err := in.unspool()
+			if err != nil {
+				log.Println("runSpool: error queueing spooled submission:", err)
+			}
+			if !ok {
+				break
+			}
+		}
+	}
+}
+
+// close stops queueing submissions and waits for the workers to save those
+// already queued. Spooled submissions are left for the next start.
+func (in *ingester) close() {
+	in.mu.Lock()
+	if !in.closed {
+		in.closed = true
+		close(in.jobs)
+	}
+	in.mu.Unlock()
+	in.wg.Wait()
+}
+
+// startIngest starts n workers saving queued submissions.
+func (app *App) startIngest(n int) {
+	in := app.ingester
+	for i := 0; i < n; i++ {
+		in.wg.Add(1)
+		go func() {
+			defer in.wg.Done()
+			for job := range in.jobs {
+				// Save whatever else is waiting along with it
+				batch := []*ingestJob{job}
+			more:
+				for len(batch) < ingestBatch {
+					select {
+					case job, ok := <-in.jobs:
+						if !ok {
+							break more
+						}
+						batch = append(batch, job)
+					default:
+						break more
+					}
+				}
+				gaugeIngestQueue.Set(float64(len(in.jobs)))
+				app.saveIngested(batch)
+			}
+		}()
+	}
+}
+
+// saveIngested saves a batch of queued submissions. They've already been
+// accepted, so if the batch can't be saved each submission not yet saved is
+// retried on its own, so one which can't be saved doesn't hold up the rest.
+func (app *App) saveIngested(batch []*ingestJob) {
+	err := app.ingest(batch)
+	if err == nil {
+		return
+	}
+	log.Println("ingest: error saving submissions:", err)
+
+	for _, job := range batch {
+		if !job.saved() {
+			app.retryIngest(job, 0, app.ingester.backoff)
+		}
+	}
+}
+
+// retryIngest saves job again in the background after wait, doubling it
+// each time it fails. After ingestRetries retries it's returned to the
+// spool. Closing the ingester waits for pending retries.
+func (app *App) retryIngest(job *ingestJob, retries int, wait time.Duration) {
+	in := app.ingester
+	if retries >= ingestRetries {
+		if err := in.requeue(job); err != nil {
+			log.Printf("ingest: error returning submission from %s to the spool: %v", job.Host, err)
+		}
+		return
+	}
+	in.wg.Add(1)
+	time.AfterFunc(wait, func() {
+		defer in.wg.Done()
+		if err := app.ingest([]*ingestJob{job}); err != nil {
+			log.Printf("ingest: error saving submission from %s: %v", job.Host, err)
+			app.retryIngest(job, retries+1, wait*2)
+		}
+	})
+}
+
+// ingest saves a batch of queued submissions with a single SaveData. What's
+// saved is cleared or marked in each, so if it fails they can be retried
+// without saving anything twice.
+func (app *App) ingest(batch []*ingestJob) error {
+	sort.Slice(batch, func(i, j int) bool { return batch[i].Time.Before(batch[j].Time) })
+
+	var results []scan.Result
+	for _, job := range batch {
+		// Before its results are cleared, in case it's retried
+		if job.Run.Started == nil {
+			job.Run.Started = runStarted(job.Results, job.Time)
+		}
+		for _, r := range job.Results {
+			if len(r.Ports) == 0 {
+				continue
+			}
+			// Results without the scanner's timestamp were seen when
+			// they were submitted, not when they're saved
+			if r.Timestamp.IsZero() {
+				r.Timestamp = scan.Timestamp{Time: job.Time}
+			}
+			results = append(results, r)
+		}
+	}
+	last := batch[len(batch)-1].Time
+	count, added, err := app.db.SaveData(results, last)
+	if err != nil {
+		return dbError("save_data", err)
+	}
+	counterRows.WithLabelValues("insert").Add(float64(len(added)))
+	counterRows.WithLabelValues("update").Add(float64(count - int64(len(added))))
+
+	// Ports are closed by each submission, but which added them isn't
+	// known after saving them together
+	for _, job := range batch {
+		job.Results = nil
+	}
+	newest := batch[len(batch)-1]
+	newest.Added = append(newest.Added, added...)
+
+	for _, job := range batch {
+		if job.saved() {
+			continue
+		}
+		if !job.Submitted {
+			if err := dbError("save_submission", app.db.SaveSubmission(job.Host, nil, job.Time, job.Run)); err != nil {
+				return err
+			}
+			job.Submitted = true
+		}
+		if job.Payload != nil {
+			if err := dbError("save_payload", app.db.SavePayload(job.Host, nil, job.Time, job.Payload)); err != nil {
+				return err
+			}
+			job.Payload = nil
+		}
+		app.agentScan(job.Agent, job.Time)
+		app.notifyChanges(job.Time, job.Added, nil)
+		app.nuclei.enqueue(job.Added)
+		job.Added = nil
+	}
+
+	app.updateResultMetrics(last)
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestIngest(t *testing.T) {
+	db := createDB("TestIngest")
+	defer db.Close()
+
+	in, err := newIngester(1, "", 0, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := App{db: db, ingester: in}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	var retry string
+	post := func(ip string) int {
+		t.Helper()
+		body := `[{"ip": "` + ip + `", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}]`
+		res, err := http.Post(ts.URL+"/results", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		retry = res.Header.Get("Retry-After")
+		return res.StatusCode
+	}
+
+	// Without workers the queue fills up
+	if code := post("192.0.2.1"); code != http.StatusAccepted {
+		t.Errorf("expected status %d, got %d", http.StatusAccepted, code)
+	}
+	if code := post("192.0.2.3"); code != http.StatusTooManyRequests || retry != "10" {
+		t.Errorf("expected status %d with a full queue, got %d with Retry-After %q", http.StatusTooManyRequests, code, retry)
+	}
+
+	// Closing saves what's queued
+	app.startIngest(2)
+	in.close()
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || data[0].IP != "192.0.2.1" {
+		t.Errorf("unexpected results %+v", data)
+	}
+	if sub, err := db.LoadSubmission(sqlite.SQLFilter{}); err != nil || sub.Host != "127.0.0.1" {
+		t.Errorf("submission not saved: %+v %v", sub, err)
+	}
+	if code := post("192.0.2.4"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d once closed, got %d", http.StatusServiceUnavailable, code)
+	}
+}
+
+func TestIngestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	in, err := newIngester(1, dir, 2, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, host := range []string{"first", "second", "third"} {
+		job := &ingestJob{Host: host, Time: now, Results: []scan.Result{{IP: "192.0.2.1"}}}
+		if err := in.enqueue(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("expected 2 spooled submissions, got %d", len(files))
+	}
+	// The spool is full too
+	if err := in.enqueue(&ingestJob{Host: "fourth", Time: now}); err != errIngestFull {
+		t.Errorf("expected %v with a full spool, got %v", errIngestFull, err)
+	}
+
+	// Spooled submissions are queued in order as there's room
+	for _, want := range []string{"first", "second", "third"} {
+		if job := <-in.jobs; job.Host != want || !job.Time.Equal(now) || len(job.Results) != 1 {
+			t.Errorf("expected %s, got %+v", want, job)
+		}
+		if _, err := in.unspool(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("expected an empty spool, got %v", files)
+	}
+}
+
+func TestIngestSpoolFull(t *testing.T) {
+	db := createDB("TestIngestSpoolFull")
+	defer db.Close()
+	dir, err := ioutil.TempDir("", "scan-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	in, err := newIngester(1, dir, 1, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := App{db: db, ingester: in}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	// Without workers the queue fills, then the spool
+	for i, want := range []int{http.StatusAccepted, http.StatusAccepted, http.StatusTooManyRequests} {
+		body := `[{"ip": "192.0.2.1", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}]`
+		res, err := http.Post(ts.URL+"/results", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("submission %d: expected status %d, got %d", i, want, res.StatusCode)
+		}
+		if retry := res.Header.Get("Retry-After"); want == http.StatusTooManyRequests && retry != "30" {
+			t.Errorf("expected Retry-After 30 with a full spool, got %q", retry)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 1 {
+		t.Errorf("expected 1 spooled submission, got %v", files)
+	}
+}
+
+func TestIngestNoPorts(t *testing.T) {
+	db := createDB("TestIngestNoPorts")
+	defer db.Close()
+
+	in, err := newIngester(10, "", 0, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := App{db: db, ingester: in}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	body := `[{"ip": "192.0.2.1", "ports": []}, {"ip": "192.0.2.2"}, {"ip": "192.0.2.3", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}]`
+	res, err := http.Post(ts.URL+"/results", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", http.StatusAccepted, res.StatusCode)
+	}
+	app.startIngest(1)
+	in.close()
+
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || data[0].IP != "192.0.2.3" {
+		t.Errorf("expected only the result with a port saved, got %+v", data)
+	}
+
+	// Spooled from before they were dropped
+	if _, _, err := db.SaveData([]scan.Result{{IP: "192.0.2.4"}}, time.Now()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIngestRequeue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Saving fails once the database is closed
+	db := createDB("TestIngestRequeue")
+	db.Close()
+	in, err := newIngester(1, dir, 100, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.backoff = time.Millisecond
+	app := App{db: db, ingester: in}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	port := []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}
+	first := &ingestJob{Host: "first", Time: now, Results: []scan.Result{{IP: "192.0.2.1", Ports: port}}}
+	last := &ingestJob{Host: "last", Time: now, Results: []scan.Result{{IP: "192.0.2.2", Ports: port}}, Attempts: ingestAttempts - 1}
+	app.saveIngested([]*ingestJob{first, last})
+	// Retries are in the background
+	in.wg.Wait()
+
+	// The first goes back in the spool to be queued again, and the other
+	// has failed too often so is set aside
+	if ok, err := in.unspool(); !ok || err != nil {
+		t.Fatalf("expected a spooled submission, got %v %v", ok, err)
+	}
+	if job := <-in.jobs; job.Host != "first" || job.Attempts != 1 || len(job.Results) != 1 {
+		t.Errorf("expected the first submission requeued, got %+v", job)
+	}
+	bad, _ := filepath.Glob(filepath.Join(dir, "*.bad"))
+	if len(bad) != 1 {
+		t.Errorf("expected a submission set aside, got %v", bad)
+	}
+	if ok, _ := in.unspool(); ok {
+		t.Error("expected nothing else spooled")
+	}
+}
+
+func TestIngestRetrySaved(t *testing.T) {
+	db := createDB("TestIngestRetrySaved")
+	defer db.Close()
+
+	in, err := newIngester(1, "", 0, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := App{db: db, ingester: in}
+
+	// Retrying a submission which was saved doesn't save it again
+	now := time.Now().UTC().Truncate(time.Second)
+	port := []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}
+	job := &ingestJob{Host: "192.0.2.100", Time: now, Results: []scan.Result{{IP: "192.0.2.1", Ports: port}}, Payload: []byte("[]")}
+	for i := 0; i < 2; i++ {
+		if err := app.ingest([]*ingestJob{job}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !job.saved() || job.Results != nil || job.Added != nil {
+		t.Errorf("expected the submission marked saved, got %+v", job)
+	}
+	if subs, err := db.LoadSubmissions(10); err != nil || len(subs) != 1 {
+		t.Errorf("expected one submission, got %+v %v", subs, err)
+	}
+	if payloads, err := db.LoadPayloads(); err != nil || len(payloads) != 1 {
+		t.Errorf("expected one payload, got %+v %v", payloads, err)
+	}
+}
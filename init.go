@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// Files created by scan init in the data directory
+const (
+	initConfigFile = "scan.toml"
+	initTokensFile = "tokens.txt"
+)
+
+// initConfig is the config file written by scan init. It's formatted with the
+// data directory and tokens file.
+const initConfig = `# Written by scan init. Any flag shown by scan -help can be set here.
+data.dir = %q
+retention = "2160h"
+
+[auth]
+tokens = %q
+
+# Only the API tokens above can authenticate until a login method is set up,
+# either Google OAuth2 with client_secret.json in the data directory or LDAP:
+#
+# [ldap]
+# url = "ldaps://ldap.example.com"
+# userdn = "uid=%%s,ou=people,dc=example,dc=com"
+`
+
+// demoResults are saved by scan init -demo, using documentation addresses.
+var demoResults = []struct {
+	ip, proto, service, banner string
+	port                       int
+}{
+	{"192.0.2.10", "tcp", "ssh", "SSH-2.0-OpenSSH_8.2p1", 22},
+	{"192.0.2.10", "tcp", "http", "nginx/1.18.0", 80},
+	{"192.0.2.10", "tcp", "", "", 443},
+	{"192.0.2.20", "tcp", "", "", 3389},
+	{"198.51.100.5", "udp", "", "", 53},
+	{"198.51.100.5", "tcp", "http", "Apache/2.4.41", 8080},
+}
+
+// runInit implements scan init, which sets up a new instance: the database,
+// an admin user with an API token, a config file and optionally some demo
+// results. Existing files are left alone, so it's safe to run again.
+func runInit(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	fs.SetOutput(out)
+	dir := fs.String("data.dir", ".", "Data directory `path` to set up")
+	admin := fs.String("admin", "", "`Email` address of the admin user to create")
+	demo := fs.Bool("demo", false, "Add some example results")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *admin == "" {
+		return errors.New("-admin is required")
+	}
+
+	abs, err := filepath.Abs(*dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(abs, 0700); err != nil {
+		return err
+	}
+
+	dsn, err := sqlite.DefaultOptions.DSN(filepath.Join(abs, sqlite.DefaultDBFile))
+	if err != nil {
+		return err
+	}
+	db, err := sqlite.Open(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	fmt.Fprintln(out, "Database ready in", abs)
+
+	exists, err := db.UserExists(*admin)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := db.SaveUser(*admin); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Added user", *admin)
+	}
+
+	tokensFile := filepath.Join(abs, initTokensFile)
+	if _, err := os.Stat(tokensFile); os.IsNotExist(err) {
+		token, err := addToken(tokensFile, *admin)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "API token for %s, which won't be shown again:\n\n    %s\n\n", *admin, token)
+	} else {
+		fmt.Fprintln(out, "Keeping existing", tokensFile)
+	}
+
+	configFile := filepath.Join(abs, initConfigFile)
+	if _, err := os.Stat(configFile); os.IsNotExist(err) {
+		config := fmt.Sprintf(initConfig, abs, tokensFile)
+		if err := ioutil.WriteFile(configFile, []byte(config), 0600); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Wrote", configFile)
+	} else {
+		fmt.Fprintln(out, "Keeping existing", configFile)
+	}
+
+	if *demo {
+		now := time.Now().UTC()
+		var results []scan.Result
+		for _, d := range demoResults {
+			p := scan.Port{Port: d.port, Proto: d.proto, Status: "open"}
+			results = append(results, scan.Result{IP: d.ip, Ports: []scan.Port{p}})
+			// Like masscan, banners are sent as separate results
+			if d.service != "" {
+				b := scan.Port{Port: d.port, Proto: d.proto}
+				b.Service.Name = d.service
+				b.Service.Banner = d.banner
+				results = append(results, scan.Result{IP: d.ip, Ports: []scan.Port{b}})
+			}
+		}
+		if _, _, err := db.SaveData(results, now); err != nil {
+			return err
+		}
+		if err := db.SaveSubmission("scan-init", nil, now, scan.Run{Scanner: "demo"}); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Added %d demo ports\n", len(demoResults))
+	}
+
+	fmt.Fprintln(out, "Start scan with: scan -config", configFile)
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jamesog/scan/internal/sqlite"
+)
+
+func TestRunInit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	if err := runInit([]string{"-data.dir", dir}, &out); err == nil {
+		t.Error("expected an error without -admin")
+	}
+	args := []string{"-data.dir", dir, "-admin", "admin@example.com", "-demo"}
+	if err := runInit(args, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := loadTokens(filepath.Join(dir, initTokensFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 {
+		t.Errorf("expected 1 token, got %d", len(tokens))
+	}
+	for _, email := range tokens {
+		if email != "admin@example.com" {
+			t.Errorf("token is for %q", email)
+		}
+	}
+
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	dataDir := fs.String("data.dir", ".", "")
+	authTokens := fs.String("auth.tokens", "", "")
+	fs.Duration("retention", 0, "")
+	if err := loadConfig(filepath.Join(dir, initConfigFile), fs); err != nil {
+		t.Fatal(err)
+	}
+	if *dataDir != dir || *authTokens != filepath.Join(dir, initTokensFile) {
+		t.Errorf("unexpected config data.dir %q, auth.tokens %q", *dataDir, *authTokens)
+	}
+
+	db, err := sqlite.Open(filepath.Join(dir, sqlite.DefaultDBFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if ok, err := db.UserExists("admin@example.com"); !ok || err != nil {
+		t.Errorf("admin user not created: %v", err)
+	}
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != len(demoResults) {
+		t.Errorf("expected %d demo ports, got %d", len(demoResults), len(data))
+	}
+
+	// Running it again keeps the existing token and config
+	before, _ := ioutil.ReadFile(filepath.Join(dir, initTokensFile))
+	if err := runInit(args[:4], &out); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := ioutil.ReadFile(filepath.Join(dir, initTokensFile))
+	if !bytes.Equal(before, after) {
+		t.Error("tokens file was overwritten")
+	}
+}
@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00014, down00014)
+}
+
+// Store service banners with when each was seen
+func up00014(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS banner (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, service text NOT NULL, banner text NOT NULL, firstseen datetime, lastseen datetime, UNIQUE (ip, port, proto, service, banner))`)
+	return err
+}
+
+func down00014(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS banner`)
+	return err
+}
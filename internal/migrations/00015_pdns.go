@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00015, down00015)
+}
+
+// Store passive DNS records of hostnames which have pointed at each IP
+func up00015(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS pdns (ip text NOT NULL, hostname text NOT NULL, firstseen datetime, lastseen datetime, UNIQUE (ip, hostname))`)
+	return err
+}
+
+func down00015(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS pdns`)
+	return err
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00016, down00016)
+}
+
+// Hosts or ports which are exempt from pruning. A port of 0 or empty proto
+// matches any.
+func up00016(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS retain (ip text NOT NULL, port integer NOT NULL DEFAULT 0, proto text NOT NULL DEFAULT '', reason text, user text, created datetime, UNIQUE (ip, port, proto))`)
+	return err
+}
+
+func down00016(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS retain`)
+	return err
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00017, down00017)
+}
+
+// Archive of gzip-compressed results payloads, with the details of the
+// submission they came from
+func up00017(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS payload (id integer PRIMARY KEY, host text NOT NULL, job_id integer, submission_time datetime NOT NULL, payload blob NOT NULL)`)
+	return err
+}
+
+func down00017(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS payload`)
+	return err
+}
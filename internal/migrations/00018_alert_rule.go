@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00018, down00018)
+}
+
+// Rules for which notifications are sent where. channels is a
+// comma-separated list of notifier names.
+func up00018(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS alert_rule (id integer PRIMARY KEY, port integer NOT NULL DEFAULT 0, proto text NOT NULL DEFAULT '', cidr text NOT NULL DEFAULT '', service text NOT NULL DEFAULT '', banner text NOT NULL DEFAULT '', action text NOT NULL, channels text NOT NULL DEFAULT '', severity text NOT NULL DEFAULT '')`)
+	return err
+}
+
+func down00018(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS alert_rule`)
+	return err
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00020, down00020)
+}
+
+// Acknowledged ports, which aren't shown as new or alerted on. expires is
+// NULL for acknowledgements which never expire.
+func up00020(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS ack (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, user text NOT NULL, note text NOT NULL DEFAULT '', created datetime NOT NULL, expires datetime, UNIQUE (ip, port, proto))`)
+	return err
+}
+
+func down00020(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS ack`)
+	return err
+}
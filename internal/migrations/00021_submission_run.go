@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00021, down00021)
+}
+
+// Details of the scanner run behind each submission: the scanner and its
+// version, its command line arguments and its packet rate
+func up00021(tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE submission ADD COLUMN scanner text NOT NULL DEFAULT ''`,
+		`ALTER TABLE submission ADD COLUMN args text NOT NULL DEFAULT ''`,
+		`ALTER TABLE submission ADD COLUMN rate integer NOT NULL DEFAULT 0`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func down00021(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE submission_migrate (host text NOT NULL, job_id integer, submission_time datetime DEFAULT CURRENT_TIMESTAMP)`,
+		`INSERT INTO submission_migrate SELECT host, job_id, submission_time FROM submission`,
+		`DROP TABLE submission`,
+		`ALTER TABLE submission_migrate RENAME TO submission`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00022, down00022)
+}
+
+// Custom fields defined by admins, and their values for hosts or single
+// ports. A value with a zero port and empty proto applies to the whole host.
+func up00022(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS field (name text PRIMARY KEY, description text NOT NULL DEFAULT '')`,
+		`CREATE TABLE IF NOT EXISTS field_value (field text NOT NULL, ip text NOT NULL, port integer NOT NULL DEFAULT 0, proto text NOT NULL DEFAULT '', value text NOT NULL, UNIQUE (field, ip, port, proto))`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func down00022(tx *sql.Tx) error {
+	stmts := []string{
+		`DROP TABLE IF EXISTS field_value`,
+		`DROP TABLE IF EXISTS field`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00023, down00023)
+}
+
+// Destructive admin actions waiting for a second admin's approval. resolved
+// is NULL while the action is pending.
+func up00023(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS approval (id integer PRIMARY KEY, action text NOT NULL, params text NOT NULL, requested_by text NOT NULL, requested datetime NOT NULL, resolved_by text, resolved datetime, approved boolean NOT NULL DEFAULT 0)`)
+	return err
+}
+
+func down00023(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS approval`)
+	return err
+}
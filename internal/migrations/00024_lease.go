@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00024, down00024)
+}
+
+// Leases elect which replica runs the periodic tasks
+func up00024(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS lease (name text PRIMARY KEY, holder text NOT NULL, expires datetime NOT NULL)`)
+	return err
+}
+
+func down00024(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS lease`)
+	return err
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00025, down00025)
+}
+
+// Add a unique index on scan so results can be saved with an upsert. Any
+// duplicate rows are merged into the oldest first.
+func up00025(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TEMP TABLE scan_dedup AS SELECT min(rowid) AS id, min(firstseen) AS firstseen, max(lastseen) AS lastseen FROM scan GROUP BY ip, port, proto HAVING count(*) > 1`,
+		`UPDATE scan SET firstseen = (SELECT firstseen FROM scan_dedup WHERE id = scan.rowid), lastseen = (SELECT lastseen FROM scan_dedup WHERE id = scan.rowid) WHERE rowid IN (SELECT id FROM scan_dedup)`,
+		`DELETE FROM scan WHERE rowid NOT IN (SELECT min(rowid) FROM scan GROUP BY ip, port, proto)`,
+		`DROP TABLE scan_dedup`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS scan_ip_port_proto ON scan (ip, port, proto)`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func down00025(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP INDEX IF EXISTS scan_ip_port_proto`)
+	return err
+}
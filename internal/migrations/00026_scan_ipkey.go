@@ -0,0 +1,63 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/jamesog/scan/pkg/scan"
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00026, down00026)
+}
+
+// Store each IP in a form which sorts in address order, so networks can be
+// searched with a range query.
+func up00026(tx *sql.Tx) error {
+	if _, err := tx.Exec(`ALTER TABLE scan ADD COLUMN ipkey blob`); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT DISTINCT ip FROM scan`)
+	if err != nil {
+		return err
+	}
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			rows.Close()
+			return err
+		}
+		ips = append(ips, ip)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if _, err := tx.Exec(`UPDATE scan SET ipkey = ? WHERE ip = ?`, scan.IPKey(ip), ip); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`CREATE INDEX scan_ipkey ON scan (ipkey)`)
+	return err
+}
+
+func down00026(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE scan_migrate (ip text, port integer, proto text, firstseen datetime, lastseen datetime)`,
+		`INSERT INTO scan_migrate SELECT ip, port, proto, firstseen, lastseen FROM scan`,
+		`DROP TABLE scan`,
+		`ALTER TABLE scan_migrate RENAME TO scan`,
+		`CREATE UNIQUE INDEX scan_ip_port_proto ON scan (ip, port, proto)`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/jamesog/scan/pkg/scan"
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00027, down00027)
+}
+
+// Store results' IPs in their canonical form, such as compressed IPv6, as
+// they are now when they're submitted. Results stored under more than one
+// form of the same IP are merged.
+func up00027(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT DISTINCT ip FROM scan UNION SELECT DISTINCT ip FROM banner`)
+	if err != nil {
+		return err
+	}
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			rows.Close()
+			return err
+		}
+		if scan.NormalizeIP(ip) != ip {
+			ips = append(ips, ip)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// WHERE true avoids the ambiguity between the SELECT and the upsert's ON
+	// clause
+	stmts := []string{
+		`INSERT INTO scan (ip, ipkey, port, proto, firstseen, lastseen)
+			SELECT ?1, ipkey, port, proto, firstseen, lastseen FROM scan WHERE ip = ?2 AND true
+			ON CONFLICT (ip, port, proto) DO UPDATE SET firstseen=min(firstseen, excluded.firstseen), lastseen=max(lastseen, excluded.lastseen)`,
+		`DELETE FROM scan WHERE ip = ?2`,
+		`INSERT INTO banner (ip, port, proto, service, banner, firstseen, lastseen)
+			SELECT ?1, port, proto, service, banner, firstseen, lastseen FROM banner WHERE ip = ?2 AND true
+			ON CONFLICT (ip, port, proto, service, banner) DO UPDATE SET firstseen=min(firstseen, excluded.firstseen), lastseen=max(lastseen, excluded.lastseen)`,
+		`DELETE FROM banner WHERE ip = ?2`,
+	}
+	for _, ip := range ips {
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt, scan.NormalizeIP(ip), ip); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func down00027(tx *sql.Tx) error {
+	// The original forms aren't kept, and the canonical ones are still valid
+	return nil
+}
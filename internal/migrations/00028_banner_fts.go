@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00028, down00028)
+}
+
+// Index banners and service names for full-text search. The index is kept up
+// to date by triggers. FTS4 is used as it's built into go-sqlite3 without
+// build tags.
+func up00028(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE VIRTUAL TABLE banner_fts USING fts4(service, banner, tokenize=unicode61)`,
+		`INSERT INTO banner_fts (docid, service, banner) SELECT rowid, service, banner FROM banner`,
+		`CREATE TRIGGER banner_fts_insert AFTER INSERT ON banner BEGIN
+			INSERT INTO banner_fts (docid, service, banner) VALUES (new.rowid, new.service, new.banner);
+		END`,
+		`CREATE TRIGGER banner_fts_update AFTER UPDATE OF service, banner ON banner BEGIN
+			UPDATE banner_fts SET service = new.service, banner = new.banner WHERE docid = old.rowid;
+		END`,
+		`CREATE TRIGGER banner_fts_delete AFTER DELETE ON banner BEGIN
+			DELETE FROM banner_fts WHERE docid = old.rowid;
+		END`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func down00028(tx *sql.Tx) error {
+	stmts := []string{
+		`DROP TRIGGER IF EXISTS banner_fts_insert`,
+		`DROP TRIGGER IF EXISTS banner_fts_update`,
+		`DROP TRIGGER IF EXISTS banner_fts_delete`,
+		`DROP TABLE IF EXISTS banner_fts`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
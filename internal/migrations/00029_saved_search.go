@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00029, down00029)
+}
+
+// Named searches users save to run again from the index page or the API.
+// query is the search as a URL query string.
+func up00029(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS saved_search (id integer PRIMARY KEY, name text NOT NULL UNIQUE, query text NOT NULL, owner text NOT NULL, created datetime NOT NULL)`)
+	return err
+}
+
+func down00029(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS saved_search`)
+	return err
+}
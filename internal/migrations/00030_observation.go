@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00030, down00030)
+}
+
+// Record each time a port is seen, rather than only the first and last time,
+// so its history can be shown. Existing ports are assumed to have been seen by
+// every scan between their first and last observations, as uptime assumes.
+func up00030(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS observation (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, seen datetime NOT NULL, UNIQUE (ip, port, proto, seen))`,
+		`INSERT OR IGNORE INTO observation (ip, port, proto, seen) SELECT ip, port, proto, firstseen FROM scan`,
+		`INSERT OR IGNORE INTO observation (ip, port, proto, seen) SELECT ip, port, proto, lastseen FROM scan`,
+		`INSERT OR IGNORE INTO observation (ip, port, proto, seen)
+			SELECT s.ip, s.port, s.proto, sub.submission_time FROM scan s
+			JOIN submission sub ON sub.job_id IS NULL AND sub.submission_time > s.firstseen AND sub.submission_time < s.lastseen`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func down00030(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS observation`)
+	return err
+}
@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00031, down00031)
+}
+
+// Record each time a service's banner changes. changed is when the new banner
+// was seen, and recorded is when the results were saved, so the changes made
+// by a submission can be found. Existing banners are assumed to have changed
+// when each was first seen after another from the same service.
+func up00031(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS banner_change (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, service text NOT NULL, old text NOT NULL, new text NOT NULL, changed datetime NOT NULL, recorded datetime NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS banner_change_port ON banner_change (ip, port, proto)`,
+		`INSERT INTO banner_change (ip, port, proto, service, old, new, changed, recorded)
+			SELECT ip, port, proto, service, old, banner, firstseen, firstseen FROM (
+				SELECT b.ip, b.port, b.proto, b.service, b.banner, b.firstseen,
+					(SELECT p.banner FROM banner p WHERE p.ip = b.ip AND p.port = b.port AND p.proto = b.proto AND p.service = b.service AND p.firstseen < b.firstseen ORDER BY p.firstseen DESC LIMIT 1) AS old
+				FROM banner b)
+			WHERE old IS NOT NULL`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func down00031(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS banner_change`)
+	return err
+}
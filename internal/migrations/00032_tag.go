@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00032, down00032)
+}
+
+// Tags users put on hosts to group them, such as by network zone or customer
+func up00032(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS tag (ip text NOT NULL, name text NOT NULL, user text NOT NULL, created datetime NOT NULL, UNIQUE (ip, name))`)
+	return err
+}
+
+func down00032(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS tag`)
+	return err
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00033, down00033)
+}
+
+// Notes users leave on hosts, or single ports on them, while triaging. port
+// is 0 and proto empty for a note on the whole host.
+func up00033(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS note (id integer PRIMARY KEY, ip text NOT NULL, port integer NOT NULL DEFAULT 0, proto text NOT NULL DEFAULT '', author text NOT NULL, text text NOT NULL, created datetime NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS note_ip ON note (ip)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func down00033(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS note`)
+	return err
+}
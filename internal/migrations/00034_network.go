@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00034, down00034)
+}
+
+// Named networks, such as "Production DMZ", made up of one or more CIDRs.
+// first and last are the range of ipkeys each CIDR covers, for searching.
+func up00034(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS network (name text PRIMARY KEY, description text NOT NULL DEFAULT '')`,
+		`CREATE TABLE IF NOT EXISTS network_range (network text NOT NULL, cidr text NOT NULL, first blob NOT NULL, last blob NOT NULL, UNIQUE (network, cidr))`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func down00034(tx *sql.Tx) error {
+	for _, table := range []string{"network_range", "network"} {
+		if _, err := tx.Exec(`DROP TABLE IF EXISTS ` + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00035, down00035)
+}
+
+// Rules for known noise, such as honeypots and NAT pools, which is dropped
+// when submitted or hidden from the results. first and last are the range of
+// ipkeys the CIDR covers, and ignore_port holds the ranges in ports.
+func up00035(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS ignore_rule (id integer PRIMARY KEY, cidr text NOT NULL DEFAULT '', first blob, last blob, ports text NOT NULL DEFAULT '', proto text NOT NULL DEFAULT '', ingest boolean NOT NULL DEFAULT 0, reason text NOT NULL DEFAULT '', user text NOT NULL, created datetime NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS ignore_port (rule integer NOT NULL, first integer NOT NULL, last integer NOT NULL)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func down00035(tx *sql.Tx) error {
+	for _, table := range []string{"ignore_port", "ignore_rule"} {
+		if _, err := tx.Exec(`DROP TABLE IF EXISTS ` + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
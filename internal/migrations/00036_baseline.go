@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00036, down00036)
+}
+
+// The baseline of expected exposure. Once it has any entries, open ports
+// which none of them allow are violations. first and last are the range of
+// ipkeys the CIDR covers, and baseline_port holds the ranges in ports.
+func up00036(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS baseline (id integer PRIMARY KEY, cidr text NOT NULL DEFAULT '', first blob, last blob, ports text NOT NULL DEFAULT '', proto text NOT NULL DEFAULT '', reason text NOT NULL DEFAULT '', user text NOT NULL, created datetime NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS baseline_port (entry integer NOT NULL, first integer NOT NULL, last integer NOT NULL)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func down00036(tx *sql.Tx) error {
+	for _, table := range []string{"baseline_port", "baseline"} {
+		if _, err := tx.Exec(`DROP TABLE IF EXISTS ` + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00037, down00037)
+}
+
+// Where each IP is and who runs it, looked up in the GeoIP databases.
+// updated is when it was looked up, so IPs can be looked up again when the
+// databases change.
+func up00037(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS geoip (ip text PRIMARY KEY, country text NOT NULL DEFAULT '', city text NOT NULL DEFAULT '', asn integer NOT NULL DEFAULT 0, org text NOT NULL DEFAULT '', updated datetime NOT NULL)`)
+	return err
+}
+
+func down00037(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS geoip`)
+	return err
+}
@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00038, down00038)
+}
+
+// The PTR records of each IP, space-separated, and when they were looked up.
+// IPs without any have an empty names so they aren't looked up again until
+// they're refreshed.
+func up00038(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS rdns (ip text PRIMARY KEY, names text NOT NULL DEFAULT '', updated datetime NOT NULL)`)
+	return err
+}
+
+func down00038(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS rdns`)
+	return err
+}
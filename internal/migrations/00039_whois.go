@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00039, down00039)
+}
+
+// Who announces and who owns each IP, from WHOIS and RDAP. prefix is the BGP
+// prefix announcing it, and netblock the registry's name for the range it's
+// allocated from, first to last.
+func up00039(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS whois (ip text PRIMARY KEY, asn integer NOT NULL DEFAULT 0, as_name text NOT NULL DEFAULT '', prefix text NOT NULL DEFAULT '', netblock text NOT NULL DEFAULT '', first text NOT NULL DEFAULT '', last text NOT NULL DEFAULT '', owner text NOT NULL DEFAULT '', updated datetime NOT NULL)`)
+	return err
+}
+
+func down00039(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS whois`)
+	return err
+}
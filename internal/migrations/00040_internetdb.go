@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00040, down00040)
+}
+
+// What Shodan's InternetDB has seen of each external IP, as space-separated
+// lists, so it can be compared with our own scans. IPs it knows nothing
+// about have empty lists.
+func up00040(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS internetdb (ip text PRIMARY KEY, ports text NOT NULL DEFAULT '', hostnames text NOT NULL DEFAULT '', tags text NOT NULL DEFAULT '', vulns text NOT NULL DEFAULT '', updated datetime NOT NULL)`)
+	return err
+}
+
+func down00040(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS internetdb`)
+	return err
+}
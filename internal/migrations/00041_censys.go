@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00041, down00041)
+}
+
+// The services Censys has seen on each IP, for comparing with our own. censys
+// records when each IP was looked up, including those Censys knows nothing
+// about. certificate is the SHA-256 fingerprint of the service's certificate.
+func up00041(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS censys (ip text PRIMARY KEY, updated datetime NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS censys_service (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, service text NOT NULL DEFAULT '', banner text NOT NULL DEFAULT '', certificate text NOT NULL DEFAULT '', UNIQUE (ip, port, proto))`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func down00041(tx *sql.Tx) error {
+	for _, table := range []string{"censys_service", "censys"} {
+		if _, err := tx.Exec(`DROP TABLE IF EXISTS ` + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
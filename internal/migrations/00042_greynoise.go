@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00042, down00042)
+}
+
+// GreyNoise's classification of each external IP. noise is set if GreyNoise
+// has seen the IP scanning the internet, and riot if it's a known business
+// service. IPs it knows nothing about have neither.
+func up00042(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS greynoise (ip text PRIMARY KEY, noise boolean NOT NULL DEFAULT 0, riot boolean NOT NULL DEFAULT 0, classification text NOT NULL DEFAULT '', name text NOT NULL DEFAULT '', updated datetime NOT NULL)`)
+	return err
+}
+
+func down00042(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS greynoise`)
+	return err
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00043, down00043)
+}
+
+// AbuseIPDB's abuse confidence score, from 0 to 100, and number of reports
+// for each external IP.
+func up00043(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS abuseipdb (ip text PRIMARY KEY, score integer NOT NULL DEFAULT 0, reports integer NOT NULL DEFAULT 0, updated datetime NOT NULL)`)
+	return err
+}
+
+func down00043(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS abuseipdb`)
+	return err
+}
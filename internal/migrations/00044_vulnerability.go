@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00044, down00044)
+}
+
+// CVEs the product and version in a port's banner may be vulnerable to,
+// replaced each time banners are matched against the CVE feeds. severity is
+// the CVSS severity, e.g. HIGH, and score its base score.
+func up00044(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS vulnerability (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, product text NOT NULL, version text NOT NULL, cve text NOT NULL, severity text NOT NULL DEFAULT '', score real NOT NULL DEFAULT 0, summary text NOT NULL DEFAULT '', UNIQUE (ip, port, proto, cve))`,
+		`CREATE INDEX IF NOT EXISTS vulnerability_cve ON vulnerability (cve)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func down00044(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS vulnerability`)
+	return err
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00045, down00045)
+}
+
+// Findings from follow-up nuclei scans of new ports. template is the nuclei
+// template which matched and matched the URL or address it matched at.
+func up00045(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS finding (id integer PRIMARY KEY, ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, template text NOT NULL, name text NOT NULL DEFAULT '', severity text NOT NULL DEFAULT '', matched text NOT NULL DEFAULT '', found datetime NOT NULL, UNIQUE (ip, port, proto, template, matched))`,
+		`CREATE INDEX IF NOT EXISTS finding_ip ON finding (ip)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func down00045(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS finding`)
+	return err
+}
@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00046, down00046)
+}
+
+// The TLS certificate presented on each port probed. Ports which didn't
+// complete a TLS handshake have an empty fingerprint, so they aren't probed
+// again until they're refreshed. sans are space-separated.
+func up00046(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS certificate (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, fingerprint text NOT NULL DEFAULT '', subject text NOT NULL DEFAULT '', issuer text NOT NULL DEFAULT '', sans text NOT NULL DEFAULT '', not_before datetime, not_after datetime, updated datetime NOT NULL, UNIQUE (ip, port, proto))`)
+	return err
+}
+
+func down00046(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS certificate`)
+	return err
+}
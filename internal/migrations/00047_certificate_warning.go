@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00047, down00047)
+}
+
+// The shortest warning period, in days, each certificate has been warned
+// about expiring within. A renewed certificate has a new fingerprint, so it's
+// warned about afresh.
+func up00047(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS certificate_warning (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, fingerprint text NOT NULL, days integer NOT NULL, warned datetime NOT NULL, UNIQUE (ip, port, proto, fingerprint))`)
+	return err
+}
+
+func down00047(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS certificate_warning`)
+	return err
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00048, down00048)
+}
+
+// expiry, if set, limits a rule to certificates expiring within that many
+// days.
+func up00048(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE alert_rule ADD COLUMN expiry integer NOT NULL DEFAULT 0`)
+	return err
+}
+
+func down00048(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE alert_rule_migrate (id integer PRIMARY KEY, port integer NOT NULL DEFAULT 0, proto text NOT NULL DEFAULT '', cidr text NOT NULL DEFAULT '', service text NOT NULL DEFAULT '', banner text NOT NULL DEFAULT '', action text NOT NULL, channels text NOT NULL DEFAULT '', severity text NOT NULL DEFAULT '', throttle integer NOT NULL DEFAULT 0)`,
+		`INSERT INTO alert_rule_migrate SELECT id, port, proto, cidr, service, banner, action, channels, severity, throttle FROM alert_rule`,
+		`DROP TABLE alert_rule`,
+		`ALTER TABLE alert_rule_migrate RENAME TO alert_rule`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
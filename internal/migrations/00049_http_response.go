@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00049, down00049)
+}
+
+// The response to a request for / on each web port probed. A status of 0
+// means the port didn't answer HTTP or HTTPS. redirects are the
+// space-separated URLs redirected to, in order.
+func up00049(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS http_response (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, url text NOT NULL DEFAULT '', status integer NOT NULL DEFAULT 0, title text NOT NULL DEFAULT '', server text NOT NULL DEFAULT '', redirects text NOT NULL DEFAULT '', updated datetime NOT NULL, UNIQUE (ip, port, proto))`)
+	return err
+}
+
+func down00049(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS http_response`)
+	return err
+}
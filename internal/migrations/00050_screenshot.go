@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00050, down00050)
+}
+
+// A screenshot of each web port, taken of the URL it was probed at, as a PNG
+// with a JPEG thumbnail. Ports which couldn't be captured have no image, so
+// they aren't tried again until they're refreshed.
+func up00050(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS screenshot (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, url text NOT NULL, image blob, thumbnail blob, taken datetime NOT NULL, UNIQUE (ip, port, proto))`)
+	return err
+}
+
+func down00050(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS screenshot`)
+	return err
+}
@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00051, down00051)
+}
+
+// attribute holds the key/value pairs enrichers set on ports, and enrichment
+// when each enricher last ran on each port, whether or not it set anything.
+func up00051(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS attribute (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, enricher text NOT NULL, key text NOT NULL, value text NOT NULL, UNIQUE (ip, port, proto, enricher, key))`,
+		`CREATE TABLE IF NOT EXISTS enrichment (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, enricher text NOT NULL, updated datetime NOT NULL, UNIQUE (ip, port, proto, enricher))`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func down00051(tx *sql.Tx) error {
+	for _, table := range []string{"attribute", "enrichment"} {
+		if _, err := tx.Exec(`DROP TABLE IF EXISTS ` + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00052, down00052)
+}
+
+// Scanning agents, by the name they identify themselves with, with when they
+// last sent a heartbeat and last submitted results.
+func up00052(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS agent (name text PRIMARY KEY, version text NOT NULL DEFAULT '', scanner text NOT NULL DEFAULT '', addr text NOT NULL DEFAULT '', first_seen datetime NOT NULL, last_seen datetime NOT NULL, last_scan datetime)`)
+	return err
+}
+
+func down00052(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS agent`)
+	return err
+}
@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00053, down00053)
+}
+
+// Jobs can set the packet rate and be for a particular agent. Agents claim
+// jobs before running them, recording which agent has the job and when it
+// claimed it.
+func up00053(tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE job ADD COLUMN rate integer NOT NULL DEFAULT 0`,
+		`ALTER TABLE job ADD COLUMN agent text NOT NULL DEFAULT ''`,
+		`ALTER TABLE job ADD COLUMN claimed_by text NOT NULL DEFAULT ''`,
+		`ALTER TABLE job ADD COLUMN claimed datetime`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func down00053(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE job_migrate (id int, cidr text, ports text, proto text, requested_by text, submitted datetime, received datetime, count int)`,
+		// Keep the rowids, which submissions refer to jobs by
+		`INSERT INTO job_migrate (rowid, id, cidr, ports, proto, requested_by, submitted, received, count) SELECT rowid, id, cidr, ports, proto, requested_by, submitted, received, count FROM job`,
+		`DROP TABLE job`,
+		`ALTER TABLE job_migrate RENAME TO job`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00054, down00054)
+}
+
+// target is the inventory of what should be scanned, with who owns it and how
+// often it should be scanned. Jobs queued for a target refer to it.
+func up00054(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS target (id integer PRIMARY KEY, cidr text NOT NULL, ports text NOT NULL, proto text NOT NULL, owner text NOT NULL DEFAULT '', environment text NOT NULL DEFAULT '', frequency text NOT NULL DEFAULT '', description text NOT NULL DEFAULT '', user text NOT NULL, created datetime NOT NULL)`,
+		`ALTER TABLE job ADD COLUMN target integer`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func down00054(tx *sql.Tx) error {
+	stmts := []string{
+		`DROP TABLE IF EXISTS target`,
+		`CREATE TABLE job_migrate (id int, cidr text, ports text, proto text, requested_by text, submitted datetime, received datetime, count int, rate integer NOT NULL DEFAULT 0, agent text NOT NULL DEFAULT '', claimed_by text NOT NULL DEFAULT '', claimed datetime)`,
+		// Keep the rowids, which submissions refer to jobs by
+		`INSERT INTO job_migrate (rowid, id, cidr, ports, proto, requested_by, submitted, received, count, rate, agent, claimed_by, claimed) SELECT rowid, id, cidr, ports, proto, requested_by, submitted, received, count, rate, agent, claimed_by, claimed FROM job`,
+		`DROP TABLE job`,
+		`ALTER TABLE job_migrate RENAME TO job`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00055, down00055)
+}
+
+// network_window holds when a named network may be scanned, and blackout
+// periods when it mustn't be.
+func up00055(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS network_window (network text NOT NULL, blackout boolean NOT NULL DEFAULT 0, spec text NOT NULL)`)
+	return err
+}
+
+func down00055(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS network_window`)
+	return err
+}
@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00056, down00056)
+}
+
+// tenant is an organisation sharing the deployment, owning the address space
+// in tenant_range. Users, targets and jobs belong to a tenant, or to none for
+// the operators of the deployment.
+func up00056(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS tenant (name text PRIMARY KEY, description text NOT NULL DEFAULT '', created datetime NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS tenant_range (tenant text NOT NULL, cidr text NOT NULL, first blob NOT NULL, last blob NOT NULL, UNIQUE (tenant, cidr))`,
+		`ALTER TABLE users ADD COLUMN tenant text NOT NULL DEFAULT ''`,
+		`ALTER TABLE target ADD COLUMN tenant text NOT NULL DEFAULT ''`,
+		`ALTER TABLE job ADD COLUMN tenant text NOT NULL DEFAULT ''`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func down00056(tx *sql.Tx) error {
+	stmts := []string{
+		`DROP TABLE IF EXISTS tenant_range`,
+		`DROP TABLE IF EXISTS tenant`,
+		// Tenants' users would otherwise see every result
+		`CREATE TABLE users_migrate (email text UNIQUE NOT NULL)`,
+		`INSERT INTO users_migrate SELECT email FROM users WHERE tenant = ''`,
+		`DROP TABLE users`,
+		`ALTER TABLE users_migrate RENAME TO users`,
+		`CREATE TABLE target_migrate (id integer PRIMARY KEY, cidr text NOT NULL, ports text NOT NULL, proto text NOT NULL, owner text NOT NULL DEFAULT '', environment text NOT NULL DEFAULT '', frequency text NOT NULL DEFAULT '', description text NOT NULL DEFAULT '', user text NOT NULL, created datetime NOT NULL)`,
+		`INSERT INTO target_migrate SELECT id, cidr, ports, proto, owner, environment, frequency, description, user, created FROM target`,
+		`DROP TABLE target`,
+		`ALTER TABLE target_migrate RENAME TO target`,
+		`CREATE TABLE job_migrate (id int, cidr text, ports text, proto text, requested_by text, submitted datetime, received datetime, count int, rate integer NOT NULL DEFAULT 0, agent text NOT NULL DEFAULT '', claimed_by text NOT NULL DEFAULT '', claimed datetime, target integer)`,
+		// Keep the rowids, which submissions refer to jobs by
+		`INSERT INTO job_migrate (rowid, id, cidr, ports, proto, requested_by, submitted, received, count, rate, agent, claimed_by, claimed, target) SELECT rowid, id, cidr, ports, proto, requested_by, submitted, received, count, rate, agent, claimed_by, claimed, target FROM job`,
+		`DROP TABLE job`,
+		`ALTER TABLE job_migrate RENAME TO job`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
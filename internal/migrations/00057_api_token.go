@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00057, down00057)
+}
+
+// api_token holds hashes of the API tokens managed through the API, with
+// their scope and the CIDRs they're restricted to, separated by commas.
+func up00057(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS api_token (id integer PRIMARY KEY, name text NOT NULL, hash blob NOT NULL UNIQUE, email text NOT NULL, scope text NOT NULL, cidrs text NOT NULL DEFAULT '', created datetime NOT NULL)`)
+	return err
+}
+
+func down00057(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE IF EXISTS api_token`)
+	return err
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00058, down00058)
+}
+
+// Record the source of each observation, the scanner or API token which
+// reported it, so the same port seen by several scanners at once is kept as
+// separate observations. Existing observations have no known source.
+func up00058(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE observation_migrate (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, seen datetime NOT NULL, source text NOT NULL DEFAULT '', UNIQUE (ip, port, proto, seen, source))`,
+		`INSERT INTO observation_migrate (ip, port, proto, seen) SELECT ip, port, proto, seen FROM observation`,
+		`DROP TABLE observation`,
+		`ALTER TABLE observation_migrate RENAME TO observation`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func down00058(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE observation_migrate (ip text NOT NULL, port integer NOT NULL, proto text NOT NULL, seen datetime NOT NULL, UNIQUE (ip, port, proto, seen))`,
+		`INSERT OR IGNORE INTO observation_migrate (ip, port, proto, seen) SELECT ip, port, proto, seen FROM observation`,
+		`DROP TABLE observation`,
+		`ALTER TABLE observation_migrate RENAME TO observation`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
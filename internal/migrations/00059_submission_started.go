@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00059, down00059)
+}
+
+// When the scan behind each submission started, according to the scanner's
+// timestamps. Ports it saw were last seen after then, even though that's
+// before it was submitted. Existing submissions are taken to have started
+// when they were submitted.
+func up00059(tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE submission ADD COLUMN started datetime`,
+		`UPDATE submission SET started = submission_time`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func down00059(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE submission_migrate (host text NOT NULL, job_id integer, submission_time datetime DEFAULT CURRENT_TIMESTAMP, scanner text NOT NULL DEFAULT '', args text NOT NULL DEFAULT '', rate integer NOT NULL DEFAULT 0)`,
+		`INSERT INTO submission_migrate SELECT host, job_id, submission_time, scanner, args, rate FROM submission`,
+		`DROP TABLE submission`,
+		`ALTER TABLE submission_migrate RENAME TO submission`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00060, down00060)
+}
+
+// The CIDRs a submission was restricted to, separated by commas, when it
+// came from a tenant or an API token restricted to CIDRs. It only covers
+// those, so can only close ports in them. Empty for a full scan.
+func up00060(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE submission ADD COLUMN scope text NOT NULL DEFAULT ''`)
+	return err
+}
+
+func down00060(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE submission_migrate (host text NOT NULL, job_id integer, submission_time datetime DEFAULT CURRENT_TIMESTAMP, scanner text NOT NULL DEFAULT '', args text NOT NULL DEFAULT '', rate integer NOT NULL DEFAULT 0, started datetime)`,
+		`INSERT INTO submission_migrate SELECT host, job_id, submission_time, scanner, args, rate, started FROM submission`,
+		`DROP TABLE submission`,
+		`ALTER TABLE submission_migrate RENAME TO submission`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00061, down00061)
+}
+
+// The admin who created each API token. Tokens created before it was
+// recorded have none.
+func up00061(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE api_token ADD COLUMN created_by text NOT NULL DEFAULT ''`)
+	return err
+}
+
+func down00061(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE api_token_migrate (id integer PRIMARY KEY, name text NOT NULL, hash blob NOT NULL UNIQUE, email text NOT NULL, scope text NOT NULL, cidrs text NOT NULL DEFAULT '', created datetime NOT NULL)`,
+		`INSERT INTO api_token_migrate SELECT id, name, hash, email, scope, cidrs, created FROM api_token`,
+		`DROP TABLE api_token`,
+		`ALTER TABLE api_token_migrate RENAME TO api_token`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
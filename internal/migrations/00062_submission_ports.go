@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00062, down00062)
+}
+
+// The ports a submission's scan covered, in masscan's -p syntax, when it
+// didn't scan them all. It can only close ports it covered. Empty for every
+// port.
+func up00062(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE submission ADD COLUMN ports text NOT NULL DEFAULT ''`)
+	return err
+}
+
+func down00062(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE submission_migrate (host text NOT NULL, job_id integer, submission_time datetime DEFAULT CURRENT_TIMESTAMP, scanner text NOT NULL DEFAULT '', args text NOT NULL DEFAULT '', rate integer NOT NULL DEFAULT 0, started datetime, scope text NOT NULL DEFAULT '')`,
+		`INSERT INTO submission_migrate SELECT host, job_id, submission_time, scanner, args, rate, started, scope FROM submission`,
+		`DROP TABLE submission`,
+		`ALTER TABLE submission_migrate RENAME TO submission`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00063, down00063)
+}
+
+// A submission without a scope no longer covers every address, so doesn't
+// close any ports. Existing ones were full scans when they were submitted,
+// so are given the scope of every address.
+func up00063(tx *sql.Tx) error {
+	_, err := tx.Exec(`UPDATE submission SET scope = '0.0.0.0/0,::/0' WHERE job_id IS NULL AND scope = '' AND ports = ''`)
+	return err
+}
+
+func down00063(tx *sql.Tx) error {
+	_, err := tx.Exec(`UPDATE submission SET scope = '' WHERE job_id IS NULL AND scope = '0.0.0.0/0,::/0' AND ports = ''`)
+	return err
+}
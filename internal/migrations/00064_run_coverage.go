@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+	"github.com/pressly/goose"
+)
+
+func init() {
+	goose.AddMigration(up00064, down00064)
+}
+
+// The blocks of addresses and ports each job and scoped submission covered,
+// with when its scan started, so ports it closed can be found in SQL. Existing
+// submissions' coverage is worked out from their jobs and scopes.
+func up00064(tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE run_coverage (submission integer NOT NULL, first blob NOT NULL, last blob NOT NULL, port_first integer NOT NULL, port_last integer NOT NULL, proto text NOT NULL, started datetime NOT NULL)`,
+		`CREATE INDEX run_coverage_started ON run_coverage (started)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	type run struct {
+		id       int64
+		started  time.Time
+		coverage []scan.Coverage
+	}
+	rows, err := tx.Query(`SELECT s.rowid, s.started, s.scope, s.ports, j.cidr, j.ports, j.proto FROM submission s LEFT JOIN job j ON j.rowid = s.job_id
+		WHERE s.job_id IS NOT NULL OR (s.scope != '' AND NOT (s.scope = '0.0.0.0/0,::/0' AND s.ports = ''))`)
+	if err != nil {
+		return err
+	}
+	var runs []run
+	for rows.Next() {
+		var r run
+		var scope, ports string
+		var cidr, jobPorts, proto sql.NullString
+		if err := rows.Scan(&r.id, &r.started, &scope, &ports, &cidr, &jobPorts, &proto); err != nil {
+			rows.Close()
+			return err
+		}
+		if cidr.Valid {
+			job := scan.Job{CIDR: cidr.String, Ports: jobPorts.String, Proto: proto.String}
+			r.coverage = job.Range().Coverage()
+		} else {
+			r.coverage = scan.ScopeCoverage(strings.Split(scope, ","), ports)
+		}
+		runs = append(runs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range runs {
+		for _, c := range r.coverage {
+			_, err := tx.Exec(`INSERT INTO run_coverage (submission, first, last, port_first, port_last, proto, started) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				r.id, c.First, c.Last, c.FirstPort, c.LastPort, c.Proto, r.started.UTC())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func down00064(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE run_coverage`)
+	return err
+}
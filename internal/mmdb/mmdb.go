@@ -0,0 +1,329 @@
+// Package mmdb reads MaxMind DB files, such as the GeoLite2 databases, as
+// described at https://maxmind.github.io/MaxMind-DB/.
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+// metadataStart marks the start of the metadata at the end of the file.
+var metadataStart = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// Data section types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEnd
+	typeBool
+	typeFloat
+)
+
+// Metadata describes a database.
+type Metadata struct {
+	DatabaseType string
+	IPVersion    int
+	BuildEpoch   uint64
+	nodeCount    uint
+	recordSize   uint
+}
+
+// Reader looks up IPs in a database held in memory.
+type Reader struct {
+	Metadata Metadata
+	tree     []byte
+	data     []byte
+	// ipv4Start is the node IPv4 addresses start from in an IPv6 tree
+	ipv4Start uint
+}
+
+// Open reads the database at path.
+func Open(path string) (*Reader, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(b)
+}
+
+// New reads a database from b.
+func New(b []byte) (*Reader, error) {
+	i := bytes.LastIndex(b, metadataStart)
+	if i < 0 {
+		return nil, errors.New("mmdb: metadata not found")
+	}
+	meta := b[i+len(metadataStart):]
+	v, _, err := decode(meta, 0)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: metadata: %v", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("mmdb: metadata isn't a map")
+	}
+	r := &Reader{}
+	r.Metadata.DatabaseType, _ = m["database_type"].(string)
+	r.Metadata.IPVersion = int(toUint(m["ip_version"]))
+	r.Metadata.BuildEpoch = toUint(m["build_epoch"])
+	r.Metadata.nodeCount = uint(toUint(m["node_count"]))
+	r.Metadata.recordSize = uint(toUint(m["record_size"]))
+	switch r.Metadata.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("mmdb: unsupported record size %d", r.Metadata.recordSize)
+	}
+
+	treeSize := r.Metadata.recordSize * 2 / 8 * r.Metadata.nodeCount
+	// The data section follows the tree and 16 bytes of zeros
+	if treeSize+16 > uint(i) {
+		return nil, errors.New("mmdb: search tree is larger than the file")
+	}
+	r.tree = b[:treeSize]
+	r.data = b[treeSize+16 : i]
+
+	if r.Metadata.IPVersion == 6 {
+		node := uint(0)
+		for n := 0; n < 96 && node < r.Metadata.nodeCount; n++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node.
+func (r *Reader) record(node uint, bit uint) uint {
+	switch r.Metadata.recordSize {
+	case 24:
+		b := r.tree[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.tree[node*8+bit*4:]))
+	}
+}
+
+// Lookup returns the data for ip, or nil if the database has none.
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	addr := ip.To4()
+	if addr != nil {
+		if r.Metadata.IPVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else {
+		if r.Metadata.IPVersion == 4 {
+			return nil, nil
+		}
+		addr = ip.To16()
+		if addr == nil {
+			return nil, fmt.Errorf("mmdb: invalid IP %v", ip)
+		}
+	}
+
+	nodes := r.Metadata.nodeCount
+	for i := 0; i < len(addr)*8 && node < nodes; i++ {
+		bit := uint(addr[i/8]>>(7-uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+	switch {
+	case node == nodes:
+		return nil, nil
+	case node < nodes:
+		return nil, errors.New("mmdb: invalid search tree")
+	}
+	offset := node - nodes - 16
+	if offset >= uint(len(r.data)) {
+		return nil, errors.New("mmdb: invalid data pointer")
+	}
+	v, _, err := decode(r.data, offset)
+	return v, err
+}
+
+// decode decodes the value at offset in the data section d, returning the
+// offset after it.
+func decode(d []byte, offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(d)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	ctrl := d[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == typePointer {
+		ptr, next, err := pointer(d, ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := decode(d, ptr)
+		return v, next, err
+	}
+
+	if typ == typeExtended {
+		if offset >= uint(len(d)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		typ = 7 + uint(d[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		var extra uint
+		for _, b := range d[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := decode(d, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key isn't a string")
+			}
+			v, next, err := decode(d, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := decode(d, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEnd:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	b := d[offset : offset+size]
+	offset += size
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case typeInt32:
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), offset, nil
+	case typeUint128:
+		// Too big for any native type, so left as bytes
+		return append([]byte(nil), b...), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
+
+// pointer decodes a pointer whose control byte is ctrl and whose remaining
+// bytes start at offset. It returns the offset pointed to and the offset
+// after the pointer.
+func pointer(d []byte, ctrl byte, offset uint) (uint, uint, error) {
+	n := uint(ctrl>>3&0x3) + 1
+	if offset+n > uint(len(d)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	var p uint
+	if n < 4 {
+		p = uint(ctrl & 0x7)
+	}
+	for _, b := range d[offset : offset+n] {
+		p = p<<8 | uint(b)
+	}
+	switch n {
+	case 2:
+		p += 2048
+	case 3:
+		p += 526336
+	}
+	return p, offset + n, nil
+}
+
+// toUint converts a decoded unsigned integer to a uint64.
+func toUint(v interface{}) uint64 {
+	n, _ := v.(uint64)
+	return n
+}
+
+// Get follows keys through nested maps in v, such as the result of
+// Reader.Lookup, returning nil if any is missing.
+func Get(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
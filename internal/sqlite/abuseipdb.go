@@ -0,0 +1,86 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadAbuseScores retrieves the AbuseIPDB scores matching filter, ordered by
+// IP.
+func (db *DB) LoadAbuseScores(filter SQLFilter) ([]scan.AbuseScore, error) {
+	qry := fmt.Sprintf(`SELECT ip, score, reports, updated FROM abuseipdb %s ORDER BY ip`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := []scan.AbuseScore{}
+	for rows.Next() {
+		var a scan.AbuseScore
+		var updated time.Time
+		if err := rows.Scan(&a.IP, &a.Score, &a.Reports, &updated); err != nil {
+			return nil, err
+		}
+		a.Updated = scan.Time{Time: updated}
+		scores = append(scores, a)
+	}
+
+	return scores, rows.Err()
+}
+
+// loadAbuseScoreMap retrieves the AbuseIPDB score of each IP.
+func (db *DB) loadAbuseScoreMap() (map[string]int, error) {
+	scores, err := db.LoadAbuseScores(SQLFilter{})
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]int, len(scores))
+	for _, a := range scores {
+		m[a.IP] = a.Score
+	}
+	return m, nil
+}
+
+// LoadStaleAbuseIPs returns the IPs in the results which haven't been looked
+// up in AbuseIPDB since before, those never looked up first.
+func (db *DB) LoadStaleAbuseIPs(before time.Time) ([]string, error) {
+	qry := `SELECT s.ip FROM scan s LEFT JOIN abuseipdb a ON a.ip = s.ip WHERE a.ip IS NULL OR a.updated < ?
+		GROUP BY s.ip ORDER BY a.updated IS NOT NULL, a.updated`
+	rows, err := db.Query(qry, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
+// SaveAbuseScores stores AbuseIPDB scores, replacing any already stored for
+// the same IPs.
+func (db *DB) SaveAbuseScores(scores []scan.AbuseScore) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT OR REPLACE INTO abuseipdb (ip, score, reports, updated) VALUES (?, ?, ?, ?)`
+	for _, a := range scores {
+		if _, err := txn.Exec(qry, a.IP, a.Score, a.Reports, a.Updated.UTC()); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,103 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadAcks retrieves the acknowledgements which haven't expired by now.
+func (db *DB) LoadAcks(now time.Time) ([]scan.Ack, error) {
+	rows, err := db.Query(`SELECT ip, port, proto, user, note, created, expires FROM ack WHERE expires IS NULL OR expires > ? ORDER BY ip, port, proto`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	acks := []scan.Ack{}
+	for rows.Next() {
+		var a scan.Ack
+		var created time.Time
+		var expires sql.NullTime
+		if err := rows.Scan(&a.IP, &a.Port, &a.Proto, &a.User, &a.Note, &created, &expires); err != nil {
+			return nil, err
+		}
+		a.Created = scan.Time{Time: created}
+		if expires.Valid {
+			a.Expires = &scan.Time{Time: expires.Time}
+		}
+		acks = append(acks, a)
+	}
+
+	return acks, rows.Err()
+}
+
+// loadAckMap retrieves the acknowledgements which haven't expired by now,
+// keyed by IP, port and protocol.
+func (db *DB) loadAckMap(now time.Time) (map[ackKey]*scan.Ack, error) {
+	acks, err := db.LoadAcks(now)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[ackKey]*scan.Ack, len(acks))
+	for i, a := range acks {
+		m[ackKey{a.IP, a.Port, a.Proto}] = &acks[i]
+	}
+	return m, nil
+}
+
+type ackKey struct {
+	ip    string
+	port  int
+	proto string
+}
+
+// SaveAcks stores acknowledgements in one transaction, replacing any existing
+// ones for the same IP, port and protocol.
+func (db *DB) SaveAcks(acks ...scan.Ack) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	insert, err := txn.Prepare(`INSERT OR REPLACE INTO ack (ip, port, proto, user, note, created, expires) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	for _, a := range acks {
+		var expires interface{}
+		if a.Expires != nil {
+			expires = a.Expires.UTC()
+		}
+		_, err = insert.Exec(a.IP, a.Port, a.Proto, a.User, a.Note, a.Created.UTC(), expires)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+// DeleteAck removes an acknowledgement. It returns sql.ErrNoRows if there is
+// no such acknowledgement.
+func (db *DB) DeleteAck(ip string, port int, proto string) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.Exec(`DELETE FROM ack WHERE ip = ? AND port = ? AND proto = ?`, ip, port, proto)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+
+	return txn.Commit()
+}
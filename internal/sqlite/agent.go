@@ -0,0 +1,66 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadAgents retrieves the registered agents ordered by name.
+func (db *DB) LoadAgents() ([]scan.Agent, error) {
+	rows, err := db.Query(`SELECT name, version, scanner, addr, first_seen, last_seen, last_scan FROM agent ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := []scan.Agent{}
+	for rows.Next() {
+		var a scan.Agent
+		var firstSeen, lastSeen time.Time
+		var lastScan sql.NullTime
+		if err := rows.Scan(&a.Name, &a.Version, &a.Scanner, &a.Addr, &firstSeen, &lastSeen, &lastScan); err != nil {
+			return nil, err
+		}
+		a.FirstSeen = scan.Time{Time: firstSeen}
+		a.LastSeen = scan.Time{Time: lastSeen}
+		if lastScan.Valid {
+			a.LastScan = &scan.Time{Time: lastScan.Time}
+		}
+		agents = append(agents, a)
+	}
+
+	return agents, rows.Err()
+}
+
+// SaveAgentHeartbeat records a heartbeat from an agent at now, registering it
+// if it's new. A registered agent keeps the address it registered from, so
+// another host can't take over its name.
+func (db *DB) SaveAgentHeartbeat(a scan.Agent, now time.Time) error {
+	_, err := db.Exec(`INSERT INTO agent (name, version, scanner, addr, first_seen, last_seen) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET version = excluded.version, scanner = excluded.scanner, last_seen = excluded.last_seen`,
+		a.Name, a.Version, a.Scanner, a.Addr, now.UTC(), now.UTC())
+	return err
+}
+
+// SaveAgentScan records that a registered agent submitted results at now,
+// which is also contact from it. Agents are only registered by heartbeats.
+func (db *DB) SaveAgentScan(name string, now time.Time) error {
+	_, err := db.Exec(`UPDATE agent SET last_seen = max(last_seen, ?), last_scan = ? WHERE name = ?`,
+		now.UTC(), now.UTC(), name)
+	return err
+}
+
+// UpdateAgentAddr changes the address a registered agent is attributed
+// results from. It returns sql.ErrNoRows if there's no such agent.
+func (db *DB) UpdateAgentAddr(name, addr string) error {
+	res, err := db.Exec(`UPDATE agent SET addr = ? WHERE name = ?`, addr, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
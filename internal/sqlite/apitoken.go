@@ -0,0 +1,77 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+const apiTokenColumns = `id, name, email, scope, cidrs, created, created_by`
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (scan.APIToken, error) {
+	var t scan.APIToken
+	var cidrs string
+	var created time.Time
+	if err := row.Scan(&t.ID, &t.Name, &t.Email, &t.Scope, &cidrs, &created, &t.CreatedBy); err != nil {
+		return t, err
+	}
+	t.CIDRs = []string{}
+	if cidrs != "" {
+		t.CIDRs = strings.Split(cidrs, ",")
+	}
+	t.Created = scan.Time{Time: created}
+	return t, nil
+}
+
+// LoadAPITokens retrieves the API tokens, oldest first, without their
+// hashes.
+func (db *DB) LoadAPITokens() ([]scan.APIToken, error) {
+	rows, err := db.Query(`SELECT ` + apiTokenColumns + ` FROM api_token ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []scan.APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// APIToken retrieves the API token with a hash. It returns sql.ErrNoRows if
+// there is no such token.
+func (db *DB) APIToken(hash []byte) (scan.APIToken, error) {
+	return scanAPIToken(db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_token WHERE hash = ?`, hash))
+}
+
+// SaveAPIToken stores a new API token with the hash of its secret, setting
+// its ID.
+func (db *DB) SaveAPIToken(t *scan.APIToken, hash []byte) error {
+	qry := `INSERT INTO api_token (name, hash, email, scope, cidrs, created, created_by) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	res, err := db.Exec(qry, t.Name, hash, t.Email, t.Scope, strings.Join(t.CIDRs, ","), t.Created.UTC(), t.CreatedBy)
+	if err != nil {
+		return err
+	}
+	t.ID, err = res.LastInsertId()
+	return err
+}
+
+// DeleteAPIToken revokes an API token. It returns sql.ErrNoRows if there is
+// no such token.
+func (db *DB) DeleteAPIToken(id int64) error {
+	res, err := db.Exec(`DELETE FROM api_token WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// SaveApproval stores a request for approval of an action and returns its ID.
+func (db *DB) SaveApproval(a scan.Approval) (int64, error) {
+	txn, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	qry := `INSERT INTO approval (action, params, requested_by, requested) VALUES (?, ?, ?, ?)`
+	res, err := txn.Exec(qry, a.Action, string(a.Params), a.RequestedBy, a.Requested.UTC())
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	return id, txn.Commit()
+}
+
+// LoadApprovals retrieves the approvals matching the filter, oldest first.
+func (db *DB) LoadApprovals(filter SQLFilter) ([]scan.Approval, error) {
+	qry := fmt.Sprintf(`SELECT id, action, params, requested_by, requested, IFNULL(resolved_by, ''), resolved, approved FROM approval %s ORDER BY id`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	approvals := []scan.Approval{}
+	for rows.Next() {
+		var a scan.Approval
+		var params string
+		var requested time.Time
+		var resolved sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Action, &params, &a.RequestedBy, &requested, &a.ResolvedBy, &resolved, &a.Approved); err != nil {
+			return nil, err
+		}
+		a.Params = []byte(params)
+		a.Requested = scan.Time{Time: requested}
+		if resolved.Valid {
+			a.Resolved = &scan.Time{Time: resolved.Time}
+		}
+		approvals = append(approvals, a)
+	}
+
+	return approvals, rows.Err()
+}
+
+// ResolveApproval marks a pending approval as approved or rejected by user.
+// It returns sql.ErrNoRows if there is no such pending approval, so an action
+// can only be approved once.
+func (db *DB) ResolveApproval(id int64, user string, approved bool, now time.Time) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `UPDATE approval SET resolved_by = ?, resolved = ?, approved = ? WHERE id = ? AND resolved IS NULL`
+	res, err := txn.Exec(qry, user, now.UTC(), approved, id)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+
+	return txn.Commit()
+}
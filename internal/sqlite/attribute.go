@@ -0,0 +1,86 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadAttributes retrieves the attributes set by enrichers matching filter,
+// ordered by IP, port, protocol, enricher and key.
+func (db *DB) LoadAttributes(filter SQLFilter) ([]scan.Attribute, error) {
+	qry := fmt.Sprintf(`SELECT ip, port, proto, enricher, key, value FROM attribute %s ORDER BY ip, port, proto, enricher, key`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attrs := []scan.Attribute{}
+	for rows.Next() {
+		var a scan.Attribute
+		if err := rows.Scan(&a.IP, &a.Port, &a.Proto, &a.Enricher, &a.Key, &a.Value); err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, a)
+	}
+
+	return attrs, rows.Err()
+}
+
+// LoadStaleEnrichPorts returns up to limit ports in the results which enricher
+// hasn't run on since before, those it has never run on first.
+func (db *DB) LoadStaleEnrichPorts(enricher string, before time.Time, limit int) ([]scan.IPInfo, error) {
+	qry := `SELECT s.ip, s.port, s.proto FROM scan s LEFT JOIN enrichment e ON e.ip = s.ip AND e.port = s.port AND e.proto = s.proto AND e.enricher = ?
+		WHERE e.ip IS NULL OR e.updated < ?
+		GROUP BY s.ip, s.port, s.proto ORDER BY e.updated IS NOT NULL, e.updated LIMIT ?`
+	rows, err := db.Query(qry, enricher, before.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ports []scan.IPInfo
+	for rows.Next() {
+		var r scan.IPInfo
+		if err := rows.Scan(&r.IP, &r.Port, &r.Proto); err != nil {
+			return nil, err
+		}
+		ports = append(ports, r)
+	}
+	return ports, rows.Err()
+}
+
+// SaveAttributes records that enricher ran on a port at updated. If attrs
+// isn't nil it replaces the attributes enricher had set on the port; if it
+// is, they're left as they were.
+func (db *DB) SaveAttributes(enricher string, r scan.IPInfo, attrs map[string]string, updated time.Time) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = txn.Exec(`INSERT OR REPLACE INTO enrichment (ip, port, proto, enricher, updated) VALUES (?, ?, ?, ?, ?)`, r.IP, r.Port, r.Proto, enricher, updated.UTC())
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if attrs == nil {
+		return txn.Commit()
+	}
+	_, err = txn.Exec(`DELETE FROM attribute WHERE ip = ? AND port = ? AND proto = ? AND enricher = ?`, r.IP, r.Port, r.Proto, enricher)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	for key, value := range attrs {
+		_, err := txn.Exec(`INSERT INTO attribute (ip, port, proto, enricher, key, value) VALUES (?, ?, ?, ?, ?, ?)`, r.IP, r.Port, r.Proto, enricher, key, value)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
@@ -1,6 +1,12 @@
 package sqlite
 
-import "time"
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
 
 func (db *DB) SaveAudit(ts time.Time, user, event, info string) error {
 	txn, err := db.Begin()
@@ -17,3 +23,42 @@ func (db *DB) SaveAudit(ts time.Time, user, event, info string) error {
 
 	return txn.Commit()
 }
+
+// LoadLastAudit retrieves the time action was last logged, or the zero time
+// if it never has been.
+func (db *DB) LoadLastAudit(action string) (time.Time, error) {
+	var ts time.Time
+	err := db.QueryRow(`SELECT time FROM audit WHERE action = ? ORDER BY time DESC LIMIT 1`, action).Scan(&ts)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return ts, err
+}
+
+// LoadAudit retrieves the audit log entries matching filter, newest first,
+// a page at a time if filter.Limit is set.
+func (db *DB) LoadAudit(filter SQLFilter) ([]scan.AuditEntry, error) {
+	qry := fmt.Sprintf(`SELECT rowid, time, user, action, COALESCE(info, '') FROM audit %s ORDER BY time DESC, rowid DESC`, filter)
+	values := filter.Values
+	if filter.Limit > 0 {
+		qry += ` LIMIT ? OFFSET ?`
+		values = append(values[:len(values):len(values)], filter.Limit, filter.Offset)
+	}
+	rows, err := db.Query(qry, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []scan.AuditEntry{}
+	for rows.Next() {
+		var e scan.AuditEntry
+		var ts time.Time
+		if err := rows.Scan(&e.ID, &ts, &e.User, &e.Action, &e.Info); err != nil {
+			return nil, err
+		}
+		e.Time = scan.Time{Time: ts}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -8,7 +8,7 @@ import (
 
 // LoadUsers retrieves all users.
 func (db *DB) LoadUsers() ([]string, error) {
-	rows, err := db.Query(`SELECT * FROM users ORDER BY email`)
+	rows, err := db.Query(`SELECT email FROM users ORDER BY email`)
 	if err != nil {
 		log.Printf("error loading users: %v\n", err)
 		return []string{}, err
@@ -55,13 +55,13 @@ func (db *DB) UserExists(email string) (bool, error) {
 	var x string
 	err := db.QueryRow(`SELECT email FROM users WHERE email=?`, email).Scan(&x)
 	switch {
-	case err != nil && err != sql.ErrNoRows:
+	case err == sql.ErrNoRows:
 		return false, nil
-	case err == nil:
-		return true, nil
+	case err != nil:
+		return false, err
 	}
 
-	return false, err
+	return true, nil
 }
 
 // SaveUser stores a new user.
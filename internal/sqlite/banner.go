@@ -0,0 +1,76 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadBannerHistory retrieves every banner seen on the host, ordered by port
+// and then by when each was first seen.
+func (db *DB) LoadBannerHistory(ip string) ([]scan.Banner, error) {
+	rows, err := db.Query(`SELECT ip, port, proto, service, banner, firstseen, lastseen FROM banner WHERE ip = ? ORDER BY port, proto, firstseen, service`, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var banners []scan.Banner
+	for rows.Next() {
+		var b scan.Banner
+		var firstseen, lastseen time.Time
+		if err := rows.Scan(&b.IP, &b.Port, &b.Proto, &b.Service, &b.Banner, &firstseen, &lastseen); err != nil {
+			return nil, err
+		}
+		b.FirstSeen = scan.Time{Time: firstseen.UTC()}
+		b.LastSeen = scan.Time{Time: lastseen.UTC()}
+		banners = append(banners, b)
+	}
+
+	return banners, rows.Err()
+}
+
+// LoadBannerChanges retrieves the banner changes matching filter, oldest
+// first.
+func (db *DB) LoadBannerChanges(filter SQLFilter) ([]scan.BannerChange, error) {
+	qry := fmt.Sprintf(`SELECT ip, port, proto, service, old, new, changed FROM banner_change %s ORDER BY changed, ip, port, proto, service`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []scan.BannerChange{}
+	for rows.Next() {
+		var c scan.BannerChange
+		var changed time.Time
+		if err := rows.Scan(&c.IP, &c.Port, &c.Proto, &c.Service, &c.Old, &c.New, &changed); err != nil {
+			return nil, err
+		}
+		c.Changed = &scan.Time{Time: changed.UTC()}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+// loadChangedPorts retrieves the ports with a banner change recorded since
+// the given time.
+func (db *DB) loadChangedPorts(since time.Time) (map[ackKey]bool, error) {
+	rows, err := db.Query(`SELECT DISTINCT ip, port, proto FROM banner_change WHERE recorded >= ?`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	m := make(map[ackKey]bool)
+	for rows.Next() {
+		var k ackKey
+		if err := rows.Scan(&k.ip, &k.port, &k.proto); err != nil {
+			return nil, err
+		}
+		m[k] = true
+	}
+	return m, rows.Err()
+}
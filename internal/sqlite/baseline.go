@@ -0,0 +1,116 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// violation is a condition matching rows of the table named by the format
+// argument which are outside a baseline with any entries.
+const violation = `EXISTS (SELECT 1 FROM baseline) AND NOT EXISTS (SELECT 1 FROM baseline b WHERE
+	(b.cidr = '' OR %[1]s.ipkey BETWEEN b.first AND b.last)
+	AND (b.proto = '' OR b.proto = %[1]s.proto)
+	AND (b.ports = '' OR EXISTS (SELECT 1 FROM baseline_port p WHERE p.entry = b.id AND %[1]s.port BETWEEN p.first AND p.last)))`
+
+// LoadBaseline retrieves all the baseline entries, oldest first.
+func (db *DB) LoadBaseline() ([]scan.BaselineEntry, error) {
+	rows, err := db.Query(`SELECT id, cidr, ports, proto, reason, user, created FROM baseline ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	baseline := []scan.BaselineEntry{}
+	for rows.Next() {
+		var b scan.BaselineEntry
+		var created time.Time
+		if err := rows.Scan(&b.ID, &b.CIDR, &b.Ports, &b.Proto, &b.Reason, &b.User, &created); err != nil {
+			return nil, err
+		}
+		b.Created = scan.Time{Time: created}
+		baseline = append(baseline, b)
+	}
+
+	return baseline, rows.Err()
+}
+
+// SaveBaselineEntry stores a new baseline entry, returning its ID. The CIDR
+// and ports, if set, must be valid.
+func (db *DB) SaveBaselineEntry(b scan.BaselineEntry) (int64, error) {
+	var first, last []byte
+	if b.CIDR != "" {
+		_, network, err := net.ParseCIDR(b.CIDR)
+		if err != nil {
+			return 0, err
+		}
+		first, last = scan.NetworkKeys(network)
+	}
+	var ports [][2]int
+	if b.Ports != "" {
+		var err error
+		if ports, err = scan.ParsePortSpec(b.Ports); err != nil {
+			return 0, err
+		}
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	qry := `INSERT INTO baseline (cidr, first, last, ports, proto, reason, user, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := txn.Exec(qry, b.CIDR, first, last, b.Ports, b.Proto, b.Reason, b.User, b.Created.UTC())
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	for _, p := range ports {
+		_, err := txn.Exec(`INSERT INTO baseline_port (entry, first, last) VALUES (?, ?, ?)`, id, p[0], p[1])
+		if err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+	}
+
+	return id, txn.Commit()
+}
+
+// DeleteBaselineEntry removes a baseline entry. It returns sql.ErrNoRows if
+// there is no such entry.
+func (db *DB) DeleteBaselineEntry(id int64) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.Exec(`DELETE FROM baseline WHERE id = ?`, id)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+	if _, err := txn.Exec(`DELETE FROM baseline_port WHERE entry = ?`, id); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// violations returns a copy of the filter including only results outside the
+// baseline.
+func (f SQLFilter) violations() SQLFilter {
+	return f.and(fmt.Sprintf(violation, "scan"))
+}
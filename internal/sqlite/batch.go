@@ -0,0 +1,55 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// maxVariables is the most parameters SQLite allows in a statement by
+// default.
+const maxVariables = 999
+
+// batchInsert collects rows into multi-row INSERT statements, as many rows as
+// fit in maxVariables, so large submissions don't need a statement per row.
+type batchInsert struct {
+	txn    *sql.Tx
+	prefix string // the statement up to VALUES
+	suffix string // anything after the values, such as ON CONFLICT
+	row    string // the placeholders for one row
+	cols   int
+	args   []interface{}
+}
+
+func newBatchInsert(txn *sql.Tx, prefix string, cols int, suffix string) *batchInsert {
+	return &batchInsert{
+		txn:    txn,
+		prefix: prefix,
+		suffix: suffix,
+		row:    "(" + strings.TrimSuffix(strings.Repeat("?, ", cols), ", ") + ")",
+		cols:   cols,
+	}
+}
+
+// add queues a row, writing the batch if it's full.
+func (b *batchInsert) add(values ...interface{}) error {
+	b.args = append(b.args, values...)
+	if len(b.args)+b.cols > maxVariables {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush writes any queued rows.
+func (b *batchInsert) flush() error {
+	if len(b.args) == 0 {
+		return nil
+	}
+	rows := make([]string, len(b.args)/b.cols)
+	for i := range rows {
+		rows[i] = b.row
+	}
+	qry := b.prefix + " VALUES " + strings.Join(rows, ", ") + " " + b.suffix
+	_, err := b.txn.Exec(qry, b.args...)
+	b.args = b.args[:0]
+	return err
+}
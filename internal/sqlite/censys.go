@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadCensys retrieves the Censys data for the IPs matching filter, ordered
+// by IP, with each IP's services ordered by port and protocol.
+func (db *DB) LoadCensys(filter SQLFilter) ([]scan.Censys, error) {
+	qry := fmt.Sprintf(`SELECT ip, updated FROM censys %s ORDER BY ip`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []scan.Censys{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var c scan.Censys
+		var updated time.Time
+		if err := rows.Scan(&c.IP, &updated); err != nil {
+			return nil, err
+		}
+		c.Updated = scan.Time{Time: updated}
+		c.Services = []scan.CensysService{}
+		index[c.IP] = len(records)
+		records = append(records, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	qry = fmt.Sprintf(`SELECT ip, port, proto, service, banner, certificate FROM censys_service %s ORDER BY port, proto`, filter)
+	rows, err = db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s scan.CensysService
+		if err := rows.Scan(&s.IP, &s.Port, &s.Proto, &s.Service, &s.Banner, &s.Certificate); err != nil {
+			return nil, err
+		}
+		if i, ok := index[s.IP]; ok {
+			records[i].Services = append(records[i].Services, s)
+		}
+	}
+
+	return records, rows.Err()
+}
+
+// LoadStaleCensysIPs returns the IPs in the results which haven't been
+// looked up in Censys since before, those never looked up first.
+func (db *DB) LoadStaleCensysIPs(before time.Time) ([]string, error) {
+	qry := `SELECT s.ip FROM scan s LEFT JOIN censys c ON c.ip = s.ip WHERE c.ip IS NULL OR c.updated < ?
+		GROUP BY s.ip ORDER BY c.updated IS NOT NULL, c.updated`
+	rows, err := db.Query(qry, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
+// SaveCensys stores Censys data, replacing the services already stored for
+// the same IPs.
+func (db *DB) SaveCensys(records []scan.Censys) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range records {
+		if _, err := txn.Exec(`INSERT OR REPLACE INTO censys (ip, updated) VALUES (?, ?)`, c.IP, c.Updated.UTC()); err != nil {
+			txn.Rollback()
+			return err
+		}
+		if _, err := txn.Exec(`DELETE FROM censys_service WHERE ip = ?`, c.IP); err != nil {
+			txn.Rollback()
+			return err
+		}
+		for _, s := range c.Services {
+			_, err := txn.Exec(`INSERT OR REPLACE INTO censys_service (ip, port, proto, service, banner, certificate) VALUES (?, ?, ?, ?, ?, ?)`,
+				c.IP, s.Port, s.Proto, s.Service, s.Banner, s.Certificate)
+			if err != nil {
+				txn.Rollback()
+				return err
+			}
+		}
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,152 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadCertificates retrieves the certificates matching filter, including
+// ports probed without finding one, ordered by IP, port and protocol.
+func (db *DB) LoadCertificates(filter SQLFilter) ([]scan.Certificate, error) {
+	qry := fmt.Sprintf(`SELECT ip, port, proto, fingerprint, subject, issuer, sans, not_before, not_after, updated FROM certificate %s ORDER BY ip, port, proto`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certs := []scan.Certificate{}
+	for rows.Next() {
+		var c scan.Certificate
+		var sans string
+		var notBefore, notAfter sql.NullTime
+		var updated time.Time
+		if err := rows.Scan(&c.IP, &c.Port, &c.Proto, &c.Fingerprint, &c.Subject, &c.Issuer, &sans, &notBefore, &notAfter, &updated); err != nil {
+			return nil, err
+		}
+		c.SANs = strings.Fields(sans)
+		c.NotBefore = scan.Time{Time: notBefore.Time}
+		c.NotAfter = scan.Time{Time: notAfter.Time}
+		c.Updated = scan.Time{Time: updated}
+		certs = append(certs, c)
+	}
+
+	return certs, rows.Err()
+}
+
+// loadCertificateMap retrieves the certificate on each port which has one.
+func (db *DB) loadCertificateMap() (map[ackKey]*scan.Certificate, error) {
+	certs, err := db.LoadCertificates(SQLFilter{Where: []string{`fingerprint != ''`}})
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[ackKey]*scan.Certificate, len(certs))
+	for i, c := range certs {
+		m[ackKey{c.IP, c.Port, c.Proto}] = &certs[i]
+	}
+	return m, nil
+}
+
+// SaveCertificates stores certificates, replacing any already stored for the
+// same ports.
+func (db *DB) SaveCertificates(certs []scan.Certificate) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT OR REPLACE INTO certificate (ip, port, proto, fingerprint, subject, issuer, sans, not_before, not_after, updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, c := range certs {
+		var notBefore, notAfter interface{}
+		if c.Fingerprint != "" {
+			notBefore, notAfter = c.NotBefore.UTC(), c.NotAfter.UTC()
+		}
+		_, err := txn.Exec(qry, c.IP, c.Port, c.Proto, c.Fingerprint, c.Subject, c.Issuer, strings.Join(c.SANs, " "), notBefore, notAfter, c.Updated.UTC())
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+// LoadStaleCertificatePorts returns the TCP ports in the results whose
+// certificates haven't been collected since before, those never collected
+// first.
+func (db *DB) LoadStaleCertificatePorts(before time.Time) ([]scan.IPInfo, error) {
+	qry := `SELECT s.ip, s.port, s.proto FROM scan s LEFT JOIN certificate c ON c.ip = s.ip AND c.port = s.port AND c.proto = s.proto
+		WHERE s.proto = 'tcp' AND (c.ip IS NULL OR c.updated < ?)
+		GROUP BY s.ip, s.port, s.proto ORDER BY c.updated IS NOT NULL, c.updated`
+	rows, err := db.Query(qry, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ports []scan.IPInfo
+	for rows.Next() {
+		var r scan.IPInfo
+		if err := rows.Scan(&r.IP, &r.Port, &r.Proto); err != nil {
+			return nil, err
+		}
+		ports = append(ports, r)
+	}
+	return ports, rows.Err()
+}
+
+// LoadExpiringCertificates retrieves the certificates on ports in the results
+// which expire before before, including those already expired, ordered by
+// expiry.
+func (db *DB) LoadExpiringCertificates(before time.Time) ([]scan.Certificate, error) {
+	certs, err := db.LoadCertificates(SQLFilter{
+		Where: []string{
+			`fingerprint != ''`,
+			`not_after < ?`,
+			`EXISTS (SELECT 1 FROM scan s WHERE s.ip = certificate.ip AND s.port = certificate.port AND s.proto = certificate.proto)`,
+		},
+		Values: []interface{}{before.UTC()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(certs, func(i, j int) bool { return certs[i].NotAfter.Before(certs[j].NotAfter.Time) })
+	return certs, nil
+}
+
+// LoadCertificateWarning returns the shortest warning period c has been warned
+// about expiring within, or sql.ErrNoRows if it hasn't been.
+func (db *DB) LoadCertificateWarning(c scan.Certificate) (int, error) {
+	var days int
+	err := db.QueryRow(`SELECT days FROM certificate_warning WHERE ip = ? AND port = ? AND proto = ? AND fingerprint = ?`,
+		c.IP, c.Port, c.Proto, c.Fingerprint).Scan(&days)
+	return days, err
+}
+
+// SaveCertificateWarning records that c was warned about expiring within days
+// at warned, forgetting warnings about certificates it replaced on the port.
+func (db *DB) SaveCertificateWarning(c scan.Certificate, days int, warned time.Time) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = txn.Exec(`DELETE FROM certificate_warning WHERE ip = ? AND port = ? AND proto = ? AND fingerprint != ?`, c.IP, c.Port, c.Proto, c.Fingerprint)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	_, err = txn.Exec(`INSERT OR REPLACE INTO certificate_warning (ip, port, proto, fingerprint, days, warned) VALUES (?, ?, ?, ?, ?, ?)`,
+		c.IP, c.Port, c.Proto, c.Fingerprint, days, warned.UTC())
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,98 @@
+package sqlite
+
+import (
+	"database/sql"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadClosedBy retrieves the ports closed by the submission at now, i.e.
+// ports which were open before it, which it covered but which it didn't see.
+// job is the submission's job ID, or nil for a full or scoped scan. A
+// submission which isn't for a job and has no scope didn't say what it
+// covered, so closed nothing.
+func (db *DB) LoadClosedBy(now time.Time, job *int64) ([]scan.IPInfo, error) {
+	// Ports it saw were seen since its scan started. A scoped scan only
+	// covered the addresses and ports in its scope.
+	started := now
+	var scope, ports string
+	err := db.QueryRow(`SELECT started, scope, ports FROM submission WHERE submission_time = ? AND job_id IS ? ORDER BY rowid DESC LIMIT 1`, now, toNullInt64(job)).Scan(&started, &scope, &ports)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if job == nil && scope == "" {
+		return nil, nil
+	}
+	var scoped *jobRun
+	if job == nil && (scope != strings.Join(scan.ScopeAll, ",") || ports != "") {
+		scoped = scopedRun(scope, ports)
+	}
+
+	// Ports not seen since the previous full scan started were already
+	// closed
+	var prev, prevStarted time.Time
+	err = db.QueryRow(`SELECT submission_time, started FROM submission WHERE `+fullScan+` AND submission_time < ? ORDER BY submission_time DESC LIMIT 1`, now).Scan(&prev, &prevStarted)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	filter := SQLFilter{
+		Where:  []string{"lastseen < ?", "lastseen >= ?"},
+		Values: []interface{}{started, prevStarted},
+	}
+	var rng *scan.JobRange
+	if job != nil {
+		var j scan.Job
+		err := db.QueryRow(`SELECT cidr, ports, proto FROM job WHERE rowid = ?`, *job).Scan(&j.CIDR, &j.Ports, &j.Proto)
+		if err != nil {
+			return nil, err
+		}
+		r := j.Range()
+		rng = &r
+		filter.Where = append(filter.Where, "proto = ?")
+		filter.Values = append(filter.Values, j.Proto)
+	}
+
+	// Jobs and scoped scans between the previous full scan and this
+	// submission may have already closed some ports
+	runs, err := db.loadJobRuns(SQLFilter{
+		Where:  []string{"submission.submission_time > ?", "submission.submission_time < ?"},
+		Values: []interface{}{prev, now},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT ip, port, proto, firstseen, lastseen FROM scan `+filter.String()+` ORDER BY port, proto, ip`, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var closed []scan.IPInfo
+	for rows.Next() {
+		var r scan.IPInfo
+		var firstseen, lastseen time.Time
+		if err := rows.Scan(&r.IP, &r.Port, &r.Proto, &firstseen, &lastseen); err != nil {
+			return nil, err
+		}
+		if rng != nil && !rng.Covers(net.ParseIP(r.IP), r.Port, r.Proto) {
+			continue
+		}
+		if scoped != nil && !scoped.covers(net.ParseIP(r.IP), r.Port, r.Proto) {
+			continue
+		}
+		if closedByJob(runs, r.IP, r.Port, r.Proto, lastseen) {
+			continue
+		}
+		r.FirstSeen = scan.Time{Time: firstseen}
+		r.LastSeen = scan.Time{Time: lastseen}
+		r.Gone = true
+		closed = append(closed, r)
+	}
+
+	return closed, rows.Err()
+}
@@ -0,0 +1,131 @@
+package sqlite
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// scanAt returns the time of the latest scan at or before t. If there were
+// no scans before t, t itself is returned.
+func scanAt(subs []time.Time, t time.Time) time.Time {
+	i := sort.Search(len(subs), func(i int) bool { return subs[i].After(t) })
+	if i == 0 {
+		return t
+	}
+	return subs[i-1]
+}
+
+// LoadDiff finds the ports which appeared, disappeared or changed banner
+// between from and to.
+//
+// A port is considered open at a point in time if the latest scan at or
+// before that time falls between when the port was first and last seen.
+func (db *DB) LoadDiff(from, to time.Time) (scan.Diff, error) {
+	diff := scan.Diff{
+		From:        scan.Time{Time: from},
+		To:          scan.Time{Time: to},
+		Appeared:    []scan.IPInfo{},
+		Disappeared: []scan.IPInfo{},
+		Changed:     []scan.BannerChange{},
+	}
+
+	subs, err := db.submissionTimes()
+	if err != nil {
+		return diff, err
+	}
+	fromScan, toScan := scanAt(subs, from), scanAt(subs, to)
+
+	rows, err := db.Query(`SELECT ip, port, proto, firstseen, lastseen FROM scan WHERE firstseen <= ? AND lastseen >= ? ORDER BY port, proto, ip`, toScan, fromScan)
+	if err != nil {
+		return diff, err
+	}
+	defer rows.Close()
+
+	var both []scan.IPInfo
+	for rows.Next() {
+		var r scan.IPInfo
+		var firstseen, lastseen time.Time
+		err := rows.Scan(&r.IP, &r.Port, &r.Proto, &firstseen, &lastseen)
+		if err != nil {
+			return diff, err
+		}
+		r.FirstSeen = scan.Time{Time: firstseen}
+		r.LastSeen = scan.Time{Time: lastseen}
+
+		openFrom := !firstseen.After(fromScan) && !lastseen.Before(fromScan)
+		openTo := !firstseen.After(toScan) && !lastseen.Before(toScan)
+		switch {
+		case openFrom && openTo:
+			both = append(both, r)
+		case openTo:
+			diff.Appeared = append(diff.Appeared, r)
+		case openFrom:
+			r.Gone = true
+			diff.Disappeared = append(diff.Disappeared, r)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return diff, err
+	}
+
+	for _, r := range both {
+		changes, err := db.bannerChanges(r, from, to)
+		if err != nil {
+			return diff, err
+		}
+		diff.Changed = append(diff.Changed, changes...)
+	}
+
+	return diff, nil
+}
+
+// bannerAt returns the most recently seen banner for each service on the
+// port at time t.
+func (db *DB) bannerAt(r scan.IPInfo, t time.Time) (map[string]string, error) {
+	rows, err := db.Query(`SELECT service, banner FROM banner WHERE ip=? AND port=? AND proto=? AND firstseen <= ? ORDER BY firstseen`, r.IP, r.Port, r.Proto, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	banners := make(map[string]string)
+	for rows.Next() {
+		var service, banner string
+		if err := rows.Scan(&service, &banner); err != nil {
+			return nil, err
+		}
+		banners[service] = banner
+	}
+	return banners, rows.Err()
+}
+
+// bannerChanges compares the banners on the port at from and to.
+func (db *DB) bannerChanges(r scan.IPInfo, from, to time.Time) ([]scan.BannerChange, error) {
+	old, err := db.bannerAt(r, from)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := db.bannerAt(r, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []string
+	for service := range cur {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	var changes []scan.BannerChange
+	for _, service := range services {
+		if prev, ok := old[service]; ok && prev != cur[service] {
+			changes = append(changes, scan.BannerChange{
+				IP: r.IP, Port: r.Port, Proto: r.Proto, Service: service,
+				Old: prev, New: cur[service],
+			})
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,159 @@
+package sqlite
+
+import (
+	"database/sql"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadFields retrieves the custom field definitions.
+func (db *DB) LoadFields() ([]scan.Field, error) {
+	rows, err := db.Query(`SELECT name, description FROM field ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fields := []scan.Field{}
+	for rows.Next() {
+		var f scan.Field
+		if err := rows.Scan(&f.Name, &f.Description); err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+
+	return fields, rows.Err()
+}
+
+// SaveField stores a custom field definition, replacing the description of
+// any existing field with the same name.
+func (db *DB) SaveField(f scan.Field) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = txn.Exec(`INSERT OR REPLACE INTO field (name, description) VALUES (?, ?)`, f.Name, f.Description)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// DeleteField removes a custom field and all its values. It returns
+// sql.ErrNoRows if there is no such field.
+func (db *DB) DeleteField(name string) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.Exec(`DELETE FROM field WHERE name = ?`, name)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+	_, err = txn.Exec(`DELETE FROM field_value WHERE field = ?`, name)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// SaveFieldValue sets a custom field on a host or port, replacing any
+// existing value. It returns sql.ErrNoRows if the field isn't defined.
+func (db *DB) SaveFieldValue(v scan.FieldValue) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var x int
+	err = txn.QueryRow(`SELECT 1 FROM field WHERE name = ?`, v.Field).Scan(&x)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	qry := `INSERT OR REPLACE INTO field_value (field, ip, port, proto, value) VALUES (?, ?, ?, ?, ?)`
+	_, err = txn.Exec(qry, v.Field, v.IP, v.Port, v.Proto, v.Value)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// DeleteFieldValue removes a custom field from a host or port. It returns
+// sql.ErrNoRows if it wasn't set.
+func (db *DB) DeleteFieldValue(v scan.FieldValue) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.Exec(`DELETE FROM field_value WHERE field = ? AND ip = ? AND port = ? AND proto = ?`, v.Field, v.IP, v.Port, v.Proto)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+
+	return txn.Commit()
+}
+
+// fieldMap holds custom field values by host or port, with hosts under a
+// zero port and empty proto.
+type fieldMap map[ackKey]map[string]string
+
+func (db *DB) loadFieldMap() (fieldMap, error) {
+	rows, err := db.Query(`SELECT field, ip, port, proto, value FROM field_value`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	m := make(fieldMap)
+	for rows.Next() {
+		var v scan.FieldValue
+		if err := rows.Scan(&v.Field, &v.IP, &v.Port, &v.Proto, &v.Value); err != nil {
+			return nil, err
+		}
+		key := ackKey{v.IP, v.Port, v.Proto}
+		if m[key] == nil {
+			m[key] = make(map[string]string)
+		}
+		m[key][v.Field] = v.Value
+	}
+
+	return m, rows.Err()
+}
+
+// lookup returns the custom fields for a port, including those set on its
+// host.
+func (m fieldMap) lookup(ip string, port int, proto string) map[string]string {
+	host, own := m[ackKey{ip, 0, ""}], m[ackKey{ip, port, proto}]
+	if len(host) == 0 && len(own) == 0 {
+		return nil
+	}
+	fields := make(map[string]string, len(host)+len(own))
+	for k, v := range host {
+		fields[k] = v
+	}
+	for k, v := range own {
+		fields[k] = v
+	}
+	return fields
+}
@@ -0,0 +1,53 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadFindings retrieves the nuclei findings matching filter, ordered by IP,
+// port and protocol, newest first.
+func (db *DB) LoadFindings(filter SQLFilter) ([]scan.Finding, error) {
+	qry := fmt.Sprintf(`SELECT id, ip, port, proto, template, name, severity, matched, found FROM finding %s ORDER BY ip, port, proto, found DESC, id`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	findings := []scan.Finding{}
+	for rows.Next() {
+		var f scan.Finding
+		var found time.Time
+		if err := rows.Scan(&f.ID, &f.IP, &f.Port, &f.Proto, &f.Template, &f.Name, &f.Severity, &f.Matched, &found); err != nil {
+			return nil, err
+		}
+		f.Found = scan.Time{Time: found}
+		findings = append(findings, f)
+	}
+
+	return findings, rows.Err()
+}
+
+// SaveFindings stores nuclei findings. A finding already stored for the same
+// port, template and match has when it was found updated.
+func (db *DB) SaveFindings(findings []scan.Finding) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT INTO finding (ip, port, proto, template, name, severity, matched, found) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ip, port, proto, template, matched) DO UPDATE SET name=excluded.name, severity=excluded.severity, found=excluded.found`
+	for _, f := range findings {
+		_, err := txn.Exec(qry, f.IP, f.Port, f.Proto, f.Template, f.Name, f.Severity, f.Matched, f.Found.UTC())
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
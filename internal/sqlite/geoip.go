@@ -0,0 +1,83 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadGeo retrieves the GeoIP data matching filter, ordered by IP.
+func (db *DB) LoadGeo(filter SQLFilter) ([]scan.Geo, error) {
+	qry := fmt.Sprintf(`SELECT ip, country, city, asn, org, updated FROM geoip %s ORDER BY ip`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	geo := []scan.Geo{}
+	for rows.Next() {
+		var g scan.Geo
+		var updated time.Time
+		if err := rows.Scan(&g.IP, &g.Country, &g.City, &g.ASN, &g.Org, &updated); err != nil {
+			return nil, err
+		}
+		g.Updated = scan.Time{Time: updated}
+		geo = append(geo, g)
+	}
+
+	return geo, rows.Err()
+}
+
+// loadGeoMap retrieves the GeoIP data for each IP.
+func (db *DB) loadGeoMap() (map[string]*scan.Geo, error) {
+	geo, err := db.LoadGeo(SQLFilter{})
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]*scan.Geo, len(geo))
+	for i := range geo {
+		m[geo[i].IP] = &geo[i]
+	}
+	return m, nil
+}
+
+// LoadStaleGeoIPs returns the IPs in the results which haven't been looked
+// up in the GeoIP databases since before.
+func (db *DB) LoadStaleGeoIPs(before time.Time) ([]string, error) {
+	rows, err := db.Query(`SELECT DISTINCT s.ip FROM scan s LEFT JOIN geoip g ON g.ip = s.ip WHERE g.ip IS NULL OR g.updated < ?`, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
+// SaveGeo stores GeoIP data, replacing any already stored for the same IPs.
+func (db *DB) SaveGeo(geo []scan.Geo) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT OR REPLACE INTO geoip (ip, country, city, asn, org, updated) VALUES (?, ?, ?, ?, ?, ?)`
+	for _, g := range geo {
+		_, err := txn.Exec(qry, g.IP, g.Country, g.City, g.ASN, g.Org, g.Updated.UTC())
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
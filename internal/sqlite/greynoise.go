@@ -0,0 +1,74 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadGreyNoise retrieves the GreyNoise classifications matching filter,
+// ordered by IP.
+func (db *DB) LoadGreyNoise(filter SQLFilter) ([]scan.GreyNoise, error) {
+	qry := fmt.Sprintf(`SELECT ip, noise, riot, classification, name, updated FROM greynoise %s ORDER BY ip`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []scan.GreyNoise{}
+	for rows.Next() {
+		var g scan.GreyNoise
+		var updated time.Time
+		if err := rows.Scan(&g.IP, &g.Noise, &g.RIOT, &g.Classification, &g.Name, &updated); err != nil {
+			return nil, err
+		}
+		g.Updated = scan.Time{Time: updated}
+		records = append(records, g)
+	}
+
+	return records, rows.Err()
+}
+
+// LoadStaleGreyNoiseIPs returns the IPs in the results which haven't been
+// looked up in GreyNoise since before, those never looked up first.
+func (db *DB) LoadStaleGreyNoiseIPs(before time.Time) ([]string, error) {
+	qry := `SELECT s.ip FROM scan s LEFT JOIN greynoise g ON g.ip = s.ip WHERE g.ip IS NULL OR g.updated < ?
+		GROUP BY s.ip ORDER BY g.updated IS NOT NULL, g.updated`
+	rows, err := db.Query(qry, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
+// SaveGreyNoise stores GreyNoise classifications, replacing any already
+// stored for the same IPs.
+func (db *DB) SaveGreyNoise(records []scan.GreyNoise) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT OR REPLACE INTO greynoise (ip, noise, riot, classification, name, updated) VALUES (?, ?, ?, ?, ?, ?)`
+	for _, g := range records {
+		_, err := txn.Exec(qry, g.IP, g.Noise, g.RIOT, g.Classification, g.Name, g.Updated.UTC())
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
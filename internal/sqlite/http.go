@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadHTTPResponses retrieves the HTTP probes matching filter, including
+// ports which didn't answer, ordered by IP, port and protocol.
+func (db *DB) LoadHTTPResponses(filter SQLFilter) ([]scan.HTTPResponse, error) {
+	qry := fmt.Sprintf(`SELECT ip, port, proto, url, status, title, server, redirects, updated FROM http_response %s ORDER BY ip, port, proto`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	responses := []scan.HTTPResponse{}
+	for rows.Next() {
+		var r scan.HTTPResponse
+		var redirects string
+		var updated time.Time
+		if err := rows.Scan(&r.IP, &r.Port, &r.Proto, &r.URL, &r.Status, &r.Title, &r.Server, &redirects, &updated); err != nil {
+			return nil, err
+		}
+		r.Redirects = strings.Fields(redirects)
+		r.Updated = scan.Time{Time: updated}
+		responses = append(responses, r)
+	}
+
+	return responses, rows.Err()
+}
+
+// loadHTTPResponseMap retrieves the HTTP response on each port which answered.
+func (db *DB) loadHTTPResponseMap() (map[ackKey]*scan.HTTPResponse, error) {
+	responses, err := db.LoadHTTPResponses(SQLFilter{Where: []string{`status != 0`}})
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[ackKey]*scan.HTTPResponse, len(responses))
+	for i, r := range responses {
+		m[ackKey{r.IP, r.Port, r.Proto}] = &responses[i]
+	}
+	return m, nil
+}
+
+// LoadStaleHTTPPorts returns the TCP ports in the results which haven't been
+// probed over HTTP since before, those never probed first.
+func (db *DB) LoadStaleHTTPPorts(before time.Time) ([]scan.IPInfo, error) {
+	qry := `SELECT s.ip, s.port, s.proto FROM scan s LEFT JOIN http_response h ON h.ip = s.ip AND h.port = s.port AND h.proto = s.proto
+		WHERE s.proto = 'tcp' AND (h.ip IS NULL OR h.updated < ?)
+		GROUP BY s.ip, s.port, s.proto ORDER BY h.updated IS NOT NULL, h.updated`
+	rows, err := db.Query(qry, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ports []scan.IPInfo
+	for rows.Next() {
+		var r scan.IPInfo
+		if err := rows.Scan(&r.IP, &r.Port, &r.Proto); err != nil {
+			return nil, err
+		}
+		ports = append(ports, r)
+	}
+	return ports, rows.Err()
+}
+
+// SaveHTTPResponses stores HTTP probes, replacing any already stored for the
+// same ports.
+func (db *DB) SaveHTTPResponses(responses []scan.HTTPResponse) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT OR REPLACE INTO http_response (ip, port, proto, url, status, title, server, redirects, updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, r := range responses {
+		_, err := txn.Exec(qry, r.IP, r.Port, r.Proto, r.URL, r.Status, r.Title, r.Server, strings.Join(r.Redirects, " "), r.Updated.UTC())
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,163 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// ignored is a condition matching rows of the table named by the format
+// argument which are hidden by an ignore rule.
+const ignored = `EXISTS (SELECT 1 FROM ignore_rule i WHERE NOT i.ingest
+	AND (i.cidr = '' OR %[1]s.ipkey BETWEEN i.first AND i.last)
+	AND (i.proto = '' OR i.proto = %[1]s.proto)
+	AND (i.ports = '' OR EXISTS (SELECT 1 FROM ignore_port p WHERE p.rule = i.id AND %[1]s.port BETWEEN p.first AND p.last)))`
+
+// LoadIgnoreRules retrieves all the ignore rules, oldest first.
+func (db *DB) LoadIgnoreRules() ([]scan.IgnoreRule, error) {
+	rows, err := db.Query(`SELECT id, cidr, ports, proto, ingest, reason, user, created FROM ignore_rule ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []scan.IgnoreRule{}
+	for rows.Next() {
+		var r scan.IgnoreRule
+		var created time.Time
+		if err := rows.Scan(&r.ID, &r.CIDR, &r.Ports, &r.Proto, &r.Ingest, &r.Reason, &r.User, &created); err != nil {
+			return nil, err
+		}
+		r.Created = scan.Time{Time: created}
+		rules = append(rules, r)
+	}
+
+	return rules, rows.Err()
+}
+
+// ingestRule is an ignore rule whose matches are dropped when submitted.
+type ingestRule struct {
+	network *net.IPNet
+	ports   string
+	proto   string
+}
+
+// loadIngestRules retrieves the ignore rules which drop ports when they're
+// submitted.
+func (db *DB) loadIngestRules() ([]ingestRule, error) {
+	rules, err := db.LoadIgnoreRules()
+	if err != nil {
+		return nil, err
+	}
+	var ingest []ingestRule
+	for _, r := range rules {
+		if !r.Ingest {
+			continue
+		}
+		rule := ingestRule{ports: r.Ports, proto: r.Proto}
+		if r.CIDR != "" {
+			if _, rule.network, err = net.ParseCIDR(r.CIDR); err != nil {
+				return nil, err
+			}
+		}
+		ingest = append(ingest, rule)
+	}
+	return ingest, nil
+}
+
+// dropped reports whether any of the rules drops the port.
+func dropped(rules []ingestRule, ip string, port int, proto string) bool {
+	for _, r := range rules {
+		if r.network != nil && !r.network.Contains(net.ParseIP(ip)) {
+			continue
+		}
+		if r.proto != "" && r.proto != proto {
+			continue
+		}
+		if r.ports != "" && !scan.PortInRange(r.ports, port) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// SaveIgnoreRule stores a new ignore rule, returning its ID. The CIDR and
+// ports, if set, must be valid.
+func (db *DB) SaveIgnoreRule(r scan.IgnoreRule) (int64, error) {
+	var first, last []byte
+	if r.CIDR != "" {
+		_, network, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			return 0, err
+		}
+		first, last = scan.NetworkKeys(network)
+	}
+	var ports [][2]int
+	if r.Ports != "" {
+		var err error
+		if ports, err = scan.ParsePortSpec(r.Ports); err != nil {
+			return 0, err
+		}
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	qry := `INSERT INTO ignore_rule (cidr, first, last, ports, proto, ingest, reason, user, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := txn.Exec(qry, r.CIDR, first, last, r.Ports, r.Proto, r.Ingest, r.Reason, r.User, r.Created.UTC())
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	for _, p := range ports {
+		_, err := txn.Exec(`INSERT INTO ignore_port (rule, first, last) VALUES (?, ?, ?)`, id, p[0], p[1])
+		if err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+	}
+
+	return id, txn.Commit()
+}
+
+// DeleteIgnoreRule removes an ignore rule. It returns sql.ErrNoRows if there
+// is no such rule.
+func (db *DB) DeleteIgnoreRule(id int64) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.Exec(`DELETE FROM ignore_rule WHERE id = ?`, id)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+	if _, err := txn.Exec(`DELETE FROM ignore_port WHERE rule = ?`, id); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// notIgnored returns a copy of the filter excluding results hidden by an
+// ignore rule.
+func (f SQLFilter) notIgnored() SQLFilter {
+	return f.and(`NOT ` + fmt.Sprintf(ignored, "scan"))
+}
@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadInternetDB retrieves the InternetDB data matching filter, ordered by IP.
+func (db *DB) LoadInternetDB(filter SQLFilter) ([]scan.InternetDB, error) {
+	qry := fmt.Sprintf(`SELECT ip, ports, hostnames, tags, vulns, updated FROM internetdb %s ORDER BY ip`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []scan.InternetDB{}
+	for rows.Next() {
+		var r scan.InternetDB
+		var ports, hostnames, tags, vulns string
+		var updated time.Time
+		if err := rows.Scan(&r.IP, &ports, &hostnames, &tags, &vulns, &updated); err != nil {
+			return nil, err
+		}
+		for _, p := range strings.Fields(ports) {
+			port, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, err
+			}
+			r.Ports = append(r.Ports, port)
+		}
+		r.Hostnames = strings.Fields(hostnames)
+		r.Tags = strings.Fields(tags)
+		r.Vulns = strings.Fields(vulns)
+		r.Updated = scan.Time{Time: updated}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
+
+// LoadStaleInternetDBIPs returns the IPs in the results which haven't been
+// looked up in InternetDB since before, those never looked up first.
+func (db *DB) LoadStaleInternetDBIPs(before time.Time) ([]string, error) {
+	qry := `SELECT s.ip FROM scan s LEFT JOIN internetdb i ON i.ip = s.ip WHERE i.ip IS NULL OR i.updated < ?
+		GROUP BY s.ip ORDER BY i.updated IS NOT NULL, i.updated`
+	rows, err := db.Query(qry, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
+// SaveInternetDB stores InternetDB data, replacing any already stored for
+// the same IPs.
+func (db *DB) SaveInternetDB(records []scan.InternetDB) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT OR REPLACE INTO internetdb (ip, ports, hostnames, tags, vulns, updated) VALUES (?, ?, ?, ?, ?, ?)`
+	for _, r := range records {
+		ports := make([]string, len(r.Ports))
+		for i, p := range r.Ports {
+			ports[i] = strconv.Itoa(p)
+		}
+		_, err := txn.Exec(qry, r.IP, strings.Join(ports, " "), strings.Join(r.Hostnames, " "),
+			strings.Join(r.Tags, " "), strings.Join(r.Vulns, " "), r.Updated.UTC())
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
@@ -1,9 +1,12 @@
 package sqlite
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
 	"log"
+	"net"
+	"sort"
 	"strings"
 	"time"
 
@@ -12,7 +15,7 @@ import (
 
 // LoadJobs retrives the stored jobs.
 func (db *DB) LoadJobs(filter SQLFilter) ([]scan.Job, error) {
-	qry := fmt.Sprintf(`SELECT rowid, cidr, ports, proto, requested_by, submitted, received, count FROM job %s ORDER BY received DESC, submitted, rowid`, filter)
+	qry := fmt.Sprintf(`SELECT rowid, cidr, ports, proto, rate, agent, target, tenant, requested_by, submitted, received, count, claimed_by, claimed FROM job %s ORDER BY received DESC, submitted, rowid`, filter)
 	rows, err := db.Query(qry, filter.Values...)
 	if err != nil {
 		log.Printf("loadJobs: error scanning table: %v\n", err)
@@ -21,24 +24,26 @@ func (db *DB) LoadJobs(filter SQLFilter) ([]scan.Job, error) {
 
 	defer rows.Close()
 
-	var id int
-	var cidr, ports, proto, requestedBy string
+	var id, rate int
+	var cidr, ports, proto, agent, tenant, requestedBy, claimedBy string
+	var target sql.NullInt64
 	var submitted time.Time
-	var received sql.NullTime
+	var received, claimed sql.NullTime
 	var count sql.NullInt64
 
 	var jobs []scan.Job
 
 	for rows.Next() {
-		err := rows.Scan(&id, &cidr, &ports, &proto, &requestedBy, &submitted, &received, &count)
+		err := rows.Scan(&id, &cidr, &ports, &proto, &rate, &agent, &target, &tenant, &requestedBy, &submitted, &received, &count, &claimedBy, &claimed)
 		if err != nil {
 			return []scan.Job{}, err
 		}
 
 		jobs = append(jobs, scan.Job{
-			ID: id, CIDR: cidr, Ports: ports, Proto: proto,
+			ID: id, CIDR: cidr, Ports: ports, Proto: proto, Rate: rate, Agent: agent, Target: target.Int64, Tenant: tenant,
 			RequestedBy: requestedBy, Submitted: scan.Time{Time: submitted},
-			Received: scan.Time{Time: received.Time}, Count: count.Int64})
+			Received: scan.Time{Time: received.Time}, Count: count.Int64,
+			ClaimedBy: claimedBy, Claimed: scan.Time{Time: claimed.Time}})
 	}
 
 	return jobs, nil
@@ -54,13 +59,23 @@ func (db *DB) LoadJobSubmission() (scan.Submission, error) {
 
 // SaveJob stores a new custom scan job request.
 func (db *DB) SaveJob(cidr, ports, proto, user string) (int64, error) {
+	return db.QueueJob(scan.Job{CIDR: cidr, Ports: ports, Proto: proto, RequestedBy: user})
+}
+
+// QueueJob stores a new custom scan job request, with its rate, the agent
+// it's for, the target it was queued for and its tenant.
+func (db *DB) QueueJob(job scan.Job) (int64, error) {
 	txn, err := db.DB.Begin()
 	if err != nil {
 		return 0, err
 	}
 
-	qry := `INSERT INTO job (cidr, ports, proto, requested_by, submitted) VALUES (?, ?, ?, ?, ?)`
-	res, err := txn.Exec(qry, cidr, ports, strings.ToLower(proto), user, time.Now())
+	var target sql.NullInt64
+	if job.Target != 0 {
+		target = sql.NullInt64{Int64: job.Target, Valid: true}
+	}
+	qry := `INSERT INTO job (cidr, ports, proto, rate, agent, target, tenant, requested_by, submitted) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := txn.Exec(qry, job.CIDR, job.Ports, strings.ToLower(job.Proto), job.Rate, job.Agent, target, job.Tenant, job.RequestedBy, time.Now())
 	if err != nil {
 		txn.Rollback()
 		return 0, err
@@ -79,6 +94,55 @@ func (db *DB) SaveJob(cidr, ports, proto, user string) (int64, error) {
 	return id, nil
 }
 
+// ClaimJob gives agent the oldest job waiting for results which is for it or
+// for any agent, and which nobody has claimed since expired, other than the
+// jobs to skip. It reports false if there's no such job.
+func (db *DB) ClaimJob(agent string, now, expired time.Time, skip ...int64) (scan.Job, bool, error) {
+	txn, err := db.Begin()
+	if err != nil {
+		return scan.Job{}, false, err
+	}
+
+	var id int64
+	qry := `SELECT rowid FROM job WHERE received IS NULL AND agent IN ('', ?) AND (claimed IS NULL OR claimed < ?)`
+	args := []interface{}{agent, expired.UTC()}
+	if len(skip) > 0 {
+		qry += ` AND rowid NOT IN (?` + strings.Repeat(`, ?`, len(skip)-1) + `)`
+		for _, id := range skip {
+			args = append(args, id)
+		}
+	}
+	qry += ` ORDER BY submitted, rowid LIMIT 1`
+	err = txn.QueryRow(qry, args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		txn.Rollback()
+		return scan.Job{}, false, nil
+	}
+	if err != nil {
+		txn.Rollback()
+		return scan.Job{}, false, err
+	}
+	// Another agent may have claimed the job since it was selected
+	res, err := txn.Exec(`UPDATE job SET claimed_by = ?, claimed = ? WHERE rowid = ? AND (claimed IS NULL OR claimed < ?)`, agent, now.UTC(), id, expired.UTC())
+	if err != nil {
+		txn.Rollback()
+		return scan.Job{}, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		txn.Rollback()
+		return scan.Job{}, false, err
+	}
+	if err := txn.Commit(); err != nil {
+		return scan.Job{}, false, err
+	}
+
+	jobs, err := db.LoadJobs(SQLFilter{Where: []string{"rowid=?"}, Values: []interface{}{id}})
+	if err != nil || len(jobs) == 0 {
+		return scan.Job{}, false, err
+	}
+	return jobs[0], true, nil
+}
+
 // UpdateJob updates the given job to mark the number of ports found.
 func (db *DB) UpdateJob(id string, count int64) error {
 	txn, err := db.DB.Begin()
@@ -101,3 +165,145 @@ func (db *DB) UpdateJob(id string, count int64) error {
 
 	return nil
 }
+
+// jobRun is the range a job scanned, or the addresses and ports a scoped
+// submission was restricted to, and the time its scan started.
+type jobRun struct {
+	rng   *scan.JobRange
+	scope []addrRange      // nil for every address
+	ports []scan.PortRange // nil for every port
+	time  time.Time
+}
+
+// scopedRun returns the run of a submission restricted to scope and ports,
+// as they're stored.
+func scopedRun(scope, ports string) *jobRun {
+	run := new(jobRun)
+	if scope != "" {
+		run.scope = parseScope(strings.Split(scope, ","))
+	}
+	if ports != "" {
+		// Invalid ports cover nothing, rather than everything
+		run.ports, _ = scan.ParseMasscanPorts(ports)
+		if run.ports == nil {
+			run.ports = []scan.PortRange{}
+		}
+	}
+	return run
+}
+
+// covers reports whether the run included the given IP, port and protocol.
+func (run jobRun) covers(ip net.IP, port int, proto string) bool {
+	if run.rng != nil {
+		return run.rng.Covers(ip, port, proto)
+	}
+	if run.scope != nil {
+		var in bool
+		for _, r := range run.scope {
+			if r.contains(ip) {
+				in = true
+				break
+			}
+		}
+		if !in {
+			return false
+		}
+	}
+	if run.ports == nil {
+		return true
+	}
+	for _, r := range run.ports {
+		if r.Contains(port, proto) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrRange is the addresses from first to last, both 16 bytes long.
+type addrRange struct {
+	first, last net.IP
+}
+
+// parseScope parses a submission's scope of CIDRs, IPs and first-last
+// ranges, as tenants' address spaces are given, ignoring any which are
+// invalid.
+func parseScope(scope []string) []addrRange {
+	ranges := make([]addrRange, 0, len(scope))
+	for _, s := range scope {
+		if first, last, ok := scan.ParseAddrRange(s); ok {
+			ranges = append(ranges, addrRange{first, last})
+		}
+	}
+	return ranges
+}
+
+func (r addrRange) contains(ip net.IP) bool {
+	ip = ip.To16()
+	return ip != nil && bytes.Compare(r.first, ip) <= 0 && bytes.Compare(ip, r.last) <= 0
+}
+
+// loadJobRuns retrieves the jobs and scoped submissions which have had
+// results submitted, most recently started first. filter applies to both.
+func (db *DB) loadJobRuns(filter SQLFilter) ([]jobRun, error) {
+	qry := fmt.Sprintf(`SELECT job.cidr, job.ports, job.proto, submission.started FROM submission JOIN job ON job.rowid = submission.job_id %s`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []jobRun
+	for rows.Next() {
+		var job scan.Job
+		var run jobRun
+		err := rows.Scan(&job.CIDR, &job.Ports, &job.Proto, &run.time)
+		if err != nil {
+			return nil, err
+		}
+		rng := job.Range()
+		run.rng = &rng
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	scoped := filter.and(`submission.job_id IS NULL AND submission.scope != '' AND NOT (` + fullScan + `)`)
+	rows, err = db.Query(`SELECT submission.scope, submission.ports, submission.started FROM submission `+scoped.String(), scoped.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var scope, ports string
+		var started time.Time
+		if err := rows.Scan(&scope, &ports, &started); err != nil {
+			return nil, err
+		}
+		run := scopedRun(scope, ports)
+		run.time = started
+		runs = append(runs, *run)
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].time.After(runs[j].time) })
+	return runs, rows.Err()
+}
+
+// closedByJob reports whether any job run or scoped submission after
+// lastseen covered the port. runs must be sorted most recent first.
+func closedByJob(runs []jobRun, ip string, port int, proto string, lastseen time.Time) bool {
+	var addr net.IP
+	for _, run := range runs {
+		if !run.time.After(lastseen) {
+			break
+		}
+		if addr == nil {
+			addr = net.ParseIP(ip)
+		}
+		if run.covers(addr, port, proto) {
+			return true
+		}
+	}
+	return false
+}
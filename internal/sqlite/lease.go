@@ -0,0 +1,43 @@
+package sqlite
+
+import (
+	"time"
+)
+
+// AcquireLease takes or renews the named lease for holder until now+ttl. It
+// reports whether holder has the lease, which it won't if another holder's
+// lease hasn't expired yet.
+func (db *DB) AcquireLease(name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	txn, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	now = now.UTC()
+	expires := now.Add(ttl)
+	qry := `UPDATE lease SET holder = ?, expires = ? WHERE name = ? AND (holder = ? OR expires < ?)`
+	res, err := txn.Exec(qry, holder, expires, name, holder, now)
+	if err != nil {
+		txn.Rollback()
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		txn.Rollback()
+		return false, err
+	}
+	if n == 0 {
+		// Either nobody has held the lease yet or someone else holds it
+		res, err = txn.Exec(`INSERT OR IGNORE INTO lease (name, holder, expires) VALUES (?, ?, ?)`, name, holder, expires)
+		if err != nil {
+			txn.Rollback()
+			return false, err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			txn.Rollback()
+			return false, err
+		}
+	}
+
+	return n > 0, txn.Commit()
+}
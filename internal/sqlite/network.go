@@ -0,0 +1,202 @@
+package sqlite
+
+import (
+	"database/sql"
+	"net"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadNetworks retrieves the named networks, ordered by name, with their
+// CIDRs.
+func (db *DB) LoadNetworks() ([]scan.Network, error) {
+	rows, err := db.Query(`SELECT n.name, n.description, r.cidr FROM network n LEFT JOIN network_range r ON r.network = n.name ORDER BY n.name, r.first`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	networks := []scan.Network{}
+	for rows.Next() {
+		var name, description string
+		var cidr sql.NullString
+		if err := rows.Scan(&name, &description, &cidr); err != nil {
+			return nil, err
+		}
+		if len(networks) == 0 || networks[len(networks)-1].Name != name {
+			networks = append(networks, scan.Network{Name: name, Description: description, CIDRs: []string{}})
+		}
+		if cidr.Valid {
+			n := &networks[len(networks)-1]
+			n.CIDRs = append(n.CIDRs, cidr.String)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return networks, db.loadNetworkWindows(networks)
+}
+
+// loadNetworkWindows adds their windows and blackouts to the networks.
+func (db *DB) loadNetworkWindows(networks []scan.Network) error {
+	rows, err := db.Query(`SELECT network, blackout, spec FROM network_window ORDER BY rowid`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byName := make(map[string]*scan.Network)
+	for i := range networks {
+		byName[networks[i].Name] = &networks[i]
+	}
+	for rows.Next() {
+		var name, spec string
+		var blackout bool
+		if err := rows.Scan(&name, &blackout, &spec); err != nil {
+			return err
+		}
+		n, ok := byName[name]
+		switch {
+		case !ok:
+		case blackout:
+			n.Blackouts = append(n.Blackouts, spec)
+		default:
+			n.Windows = append(n.Windows, spec)
+		}
+	}
+	return rows.Err()
+}
+
+// networkSet is every CIDR of the named networks, for finding which networks
+// an IP is in.
+type networkSet []struct {
+	name    string
+	network *net.IPNet
+}
+
+// loadNetworkSet retrieves the CIDRs of all the named networks.
+func (db *DB) loadNetworkSet() (networkSet, error) {
+	networks, err := db.LoadNetworks()
+	if err != nil {
+		return nil, err
+	}
+	var set networkSet
+	for _, n := range networks {
+		for _, cidr := range n.CIDRs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				continue
+			}
+			set = append(set, struct {
+				name    string
+				network *net.IPNet
+			}{n.Name, network})
+		}
+	}
+	return set, nil
+}
+
+// lookup returns the names of the networks containing ip. The set is in
+// name order, so the names are too.
+func (s networkSet) lookup(ip string) []string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil
+	}
+	var names []string
+	for _, n := range s {
+		if !n.network.Contains(addr) {
+			continue
+		}
+		if len(names) > 0 && names[len(names)-1] == n.name {
+			continue
+		}
+		names = append(names, n.name)
+	}
+	return names
+}
+
+// SaveNetwork stores a named network, replacing the description, CIDRs and
+// windows of any existing network with the same name. The CIDRs must be
+// valid.
+func (db *DB) SaveNetwork(n scan.Network) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = txn.Exec(`INSERT OR REPLACE INTO network (name, description) VALUES (?, ?)`, n.Name, n.Description)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	_, err = txn.Exec(`DELETE FROM network_range WHERE network = ?`, n.Name)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	_, err = txn.Exec(`DELETE FROM network_window WHERE network = ?`, n.Name)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	for _, spec := range n.Windows {
+		if _, err := txn.Exec(`INSERT INTO network_window (network, blackout, spec) VALUES (?, 0, ?)`, n.Name, spec); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	for _, spec := range n.Blackouts {
+		if _, err := txn.Exec(`INSERT INTO network_window (network, blackout, spec) VALUES (?, 1, ?)`, n.Name, spec); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	for _, cidr := range n.CIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+		first, last := scan.NetworkKeys(network)
+		_, err = txn.Exec(`INSERT OR IGNORE INTO network_range (network, cidr, first, last) VALUES (?, ?, ?, ?)`, n.Name, network.String(), first, last)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+// DeleteNetwork removes a named network. It returns sql.ErrNoRows if there
+// is no such network.
+func (db *DB) DeleteNetwork(name string) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.Exec(`DELETE FROM network WHERE name = ?`, name)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+	_, err = txn.Exec(`DELETE FROM network_range WHERE network = ?`, name)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	_, err = txn.Exec(`DELETE FROM network_window WHERE network = ?`, name)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,97 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadNotes retrieves the notes matching filter, oldest first.
+func (db *DB) LoadNotes(filter SQLFilter) ([]scan.Note, error) {
+	qry := fmt.Sprintf(`SELECT id, ip, port, proto, author, text, created FROM note %s ORDER BY created, id`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []scan.Note{}
+	for rows.Next() {
+		var n scan.Note
+		var created time.Time
+		if err := rows.Scan(&n.ID, &n.IP, &n.Port, &n.Proto, &n.Author, &n.Text, &created); err != nil {
+			return nil, err
+		}
+		n.Created = scan.Time{Time: created}
+		notes = append(notes, n)
+	}
+
+	return notes, rows.Err()
+}
+
+// loadNoteCounts counts the notes on each port, and on each host under a
+// zero port and empty proto.
+func (db *DB) loadNoteCounts() (map[ackKey]int, error) {
+	rows, err := db.Query(`SELECT ip, port, proto, count(*) FROM note GROUP BY ip, port, proto`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	m := make(map[ackKey]int)
+	for rows.Next() {
+		var k ackKey
+		var n int
+		if err := rows.Scan(&k.ip, &k.port, &k.proto, &n); err != nil {
+			return nil, err
+		}
+		m[k] = n
+	}
+	return m, rows.Err()
+}
+
+// SaveNote stores a new note, returning its ID.
+func (db *DB) SaveNote(n scan.Note) (int64, error) {
+	txn, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	qry := `INSERT INTO note (ip, port, proto, author, text, created) VALUES (?, ?, ?, ?, ?, ?)`
+	res, err := txn.Exec(qry, n.IP, n.Port, n.Proto, n.Author, n.Text, n.Created.UTC())
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	return id, txn.Commit()
+}
+
+// DeleteNote removes a note. It returns sql.ErrNoRows if there is no such
+// note.
+func (db *DB) DeleteNote(id int64) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.Exec(`DELETE FROM note WHERE id = ?`, id)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,295 @@
+package sqlite
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// Views of the results on the index page.
+const (
+	ViewOpen   = ""
+	ViewAll    = "all"
+	ViewClosed = "closed"
+)
+
+// defaultOrder is how results are sorted, and how they're sorted after the
+// column chosen in a ResultQuery.
+const defaultOrder = "port, proto, ipkey, ip, lastseen"
+
+// sortColumns are the columns results can be sorted by, and how. IPs are
+// sorted in address order rather than as text.
+var sortColumns = map[string]string{
+	"ip":        "ipkey",
+	"port":      "port",
+	"proto":     "proto",
+	"firstseen": "firstseen",
+	"lastseen":  "lastseen",
+}
+
+// ValidSort reports whether results can be sorted by column.
+func ValidSort(column string) bool {
+	return sortColumns[column] != ""
+}
+
+// ResultQuery selects a page of results for the index page.
+type ResultQuery struct {
+	// IP, FirstSeen and LastSeen search like ResultData
+	IP, FirstSeen, LastSeen string
+	// Ports are inclusive ranges of ports to include, or all if empty
+	Ports [][2]int
+	// Proto is the protocol to include, or all if empty
+	Proto string
+	// Service and Banner, if set, include only ports with a banner from the
+	// service, or containing the text
+	Service, Banner string
+	// Text is a full-text search of banners and service names, in SQLite's
+	// FTS query syntax
+	Text string
+	// SeenAfter and SeenBefore, if set, include only ports seen at some time
+	// in the window between them
+	SeenAfter, SeenBefore time.Time
+	// Fields are custom field values the results must have
+	Fields map[string]string
+	// Tags are tags the results' hosts must all have
+	Tags []string
+	// Networks are named networks the results must be in at least one of
+	Networks []string
+	// Tenant, if set, includes only results in the tenant's address space
+	Tenant string
+	// CIDRs, if set, include only results in at least one of them. Invalid
+	// CIDRs match nothing.
+	CIDRs []string
+	// Source, if set, includes only ports the scanner or API token has
+	// reported, in the window between SeenAfter and SeenBefore if given
+	Source string
+	// Hostname, if set, includes only IPs with a PTR record or passive DNS
+	// name containing it
+	Hostname string
+	// Country, City and Org, if set, include only IPs with GeoIP data
+	// matching them. Org matches any part of the organisation's name.
+	Country, City, Org string
+	// ASN, if set, includes only IPs announced by that autonomous system,
+	// according to WHOIS or the GeoIP databases
+	ASN uint
+	// Owner, if set, includes only IPs whose netblock, its owner or the AS
+	// announcing it has a name containing it
+	Owner string
+	// MinAbuseScore, if set, includes only IPs with at least this AbuseIPDB
+	// score
+	MinAbuseScore int
+	// CVE, if set, includes only ports which may be vulnerable to it, and
+	// Severity only those which may have a vulnerability at least that
+	// severe
+	CVE, Severity string
+	// Cert, if set, includes only ports with a TLS certificate whose subject
+	// or one of its SANs contains it
+	Cert string
+	// ExpiresBefore, if set, includes only ports with a TLS certificate
+	// expiring before it, including those already expired
+	ExpiresBefore time.Time
+	// Title and HTTPServer, if set, include only web ports whose page title
+	// or Server header contains them
+	Title, HTTPServer string
+	// Violations includes only results outside the baseline
+	Violations bool
+	// Ignored includes results hidden by ignore rules
+	Ignored bool
+	// View is ViewOpen, ViewAll or ViewClosed
+	View string
+	// Sort is a column to sort by before the default order, optionally in
+	// descending order.
+	Sort string
+	Desc bool
+	// Page counts from 1
+	Page, PerPage int
+}
+
+// ResultPage retrieves one page of the results selected by q, along with the
+// totals for all the results matching the search. It also returns how many
+// rows there are to page through.
+func (db *DB) ResultPage(q ResultQuery) (scan.Data, int, error) {
+	if q.Sort != "" && !ValidSort(q.Sort) {
+		return scan.Data{}, 0, fmt.Errorf("can't sort by %q", q.Sort)
+	}
+	filter := resultFilter(q.IP, q.FirstSeen, q.LastSeen)
+	if len(q.Ports) > 0 {
+		var where []string
+		var values []interface{}
+		for _, r := range q.Ports {
+			where = append(where, `port BETWEEN ? AND ?`)
+			values = append(values, r[0], r[1])
+		}
+		filter = filter.and("("+strings.Join(where, " OR ")+")", values...)
+	}
+	if q.Proto != "" {
+		filter = filter.and(`proto = ?`, q.Proto)
+	}
+	if q.Service != "" {
+		filter = filter.and(`EXISTS (SELECT 1 FROM banner b WHERE b.ip = scan.ip AND b.port = scan.port AND b.proto = scan.proto AND b.service = ?)`, q.Service)
+	}
+	if q.Banner != "" {
+		filter = filter.and(`EXISTS (SELECT 1 FROM banner b WHERE b.ip = scan.ip AND b.port = scan.port AND b.proto = scan.proto AND b.banner LIKE ?)`, "%"+q.Banner+"%")
+	}
+	if q.Text != "" {
+		filter = filter.and(`(ip, port, proto) IN (SELECT b.ip, b.port, b.proto FROM banner_fts f JOIN banner b ON b.rowid = f.docid WHERE banner_fts MATCH ?)`, q.Text)
+	}
+	if !q.SeenAfter.IsZero() {
+		filter = filter.and(`lastseen >= ?`, q.SeenAfter.UTC())
+	}
+	if !q.SeenBefore.IsZero() {
+		filter = filter.and(`firstseen < ?`, q.SeenBefore.UTC())
+	}
+	for _, tag := range q.Tags {
+		filter = filter.and(`EXISTS (SELECT 1 FROM tag t WHERE t.ip = scan.ip AND t.name = ?)`, tag)
+	}
+	if len(q.Networks) > 0 {
+		var where []string
+		var values []interface{}
+		for _, name := range q.Networks {
+			where = append(where, `?`)
+			values = append(values, name)
+		}
+		filter = filter.and(`EXISTS (SELECT 1 FROM network_range n WHERE n.network IN (`+strings.Join(where, ", ")+`) AND scan.ipkey BETWEEN n.first AND n.last)`, values...)
+	}
+	if q.Tenant != "" {
+		filter = filter.and(`EXISTS (SELECT 1 FROM tenant_range t WHERE t.tenant = ? AND scan.ipkey BETWEEN t.first AND t.last)`, q.Tenant)
+	}
+	if len(q.CIDRs) > 0 {
+		where := []string{`0`}
+		var values []interface{}
+		for _, cidr := range q.CIDRs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				continue
+			}
+			first, last := scan.NetworkKeys(network)
+			where = append(where, `scan.ipkey BETWEEN ? AND ?`)
+			values = append(values, first, last)
+		}
+		filter = filter.and(`(`+strings.Join(where, " OR ")+`)`, values...)
+	}
+	// Sort the names so the query is the same each time
+	var names []string
+	for name := range q.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Values set on a port override those set on its host. Fields
+		// which aren't set match an empty value.
+		filter = filter.and(`COALESCE(
+			(SELECT value FROM field_value f WHERE f.field = ? AND f.ip = scan.ip AND f.port = scan.port AND f.proto = scan.proto),
+			(SELECT value FROM field_value f WHERE f.field = ? AND f.ip = scan.ip AND f.port = 0 AND f.proto = ''),
+			'') = ?`, name, name, q.Fields[name])
+	}
+
+	if q.Hostname != "" {
+		like := "%" + strings.ToLower(q.Hostname) + "%"
+		filter = filter.and(`(EXISTS (SELECT 1 FROM rdns d WHERE d.ip = scan.ip AND d.names LIKE ?) OR EXISTS (SELECT 1 FROM pdns p WHERE p.ip = scan.ip AND p.hostname LIKE ?))`, like, like)
+	}
+	if q.Country != "" {
+		filter = filter.and(`EXISTS (SELECT 1 FROM geoip g WHERE g.ip = scan.ip AND g.country = ? COLLATE NOCASE)`, q.Country)
+	}
+	if q.City != "" {
+		filter = filter.and(`EXISTS (SELECT 1 FROM geoip g WHERE g.ip = scan.ip AND g.city = ? COLLATE NOCASE)`, q.City)
+	}
+	if q.Org != "" {
+		filter = filter.and(`EXISTS (SELECT 1 FROM geoip g WHERE g.ip = scan.ip AND g.org LIKE ?)`, "%"+q.Org+"%")
+	}
+	if q.ASN != 0 {
+		filter = filter.and(`(EXISTS (SELECT 1 FROM whois w WHERE w.ip = scan.ip AND w.asn = ?) OR EXISTS (SELECT 1 FROM geoip g WHERE g.ip = scan.ip AND g.asn = ?))`, q.ASN, q.ASN)
+	}
+	if q.Source != "" {
+		where := `o.ip = scan.ip AND o.port = scan.port AND o.proto = scan.proto AND o.source = ?`
+		values := []interface{}{q.Source}
+		if !q.SeenAfter.IsZero() {
+			where += ` AND o.seen >= ?`
+			values = append(values, q.SeenAfter)
+		}
+		if !q.SeenBefore.IsZero() {
+			where += ` AND o.seen <= ?`
+			values = append(values, q.SeenBefore)
+		}
+		filter = filter.and(`EXISTS (SELECT 1 FROM observation o WHERE `+where+`)`, values...)
+	}
+	if q.Owner != "" {
+		like := "%" + q.Owner + "%"
+		filter = filter.and(`EXISTS (SELECT 1 FROM whois w WHERE w.ip = scan.ip AND (w.owner LIKE ? OR w.netblock LIKE ? OR w.as_name LIKE ?))`, like, like, like)
+	}
+	if q.MinAbuseScore > 0 {
+		filter = filter.and(`EXISTS (SELECT 1 FROM abuseipdb a WHERE a.ip = scan.ip AND a.score >= ?)`, q.MinAbuseScore)
+	}
+	if q.CVE != "" {
+		filter = filter.and(`EXISTS (SELECT 1 FROM vulnerability v WHERE v.ip = scan.ip AND v.port = scan.port AND v.proto = scan.proto AND v.cve = ? COLLATE NOCASE)`, q.CVE)
+	}
+	if q.Severity != "" {
+		severities := atLeast(q.Severity)
+		filter = filter.and(`EXISTS (SELECT 1 FROM vulnerability v WHERE v.ip = scan.ip AND v.port = scan.port AND v.proto = scan.proto AND v.severity IN (?`+strings.Repeat(", ?", len(severities)-1)+`))`, severities...)
+	}
+	if q.Cert != "" {
+		like := "%" + q.Cert + "%"
+		filter = filter.and(`EXISTS (SELECT 1 FROM certificate c WHERE c.ip = scan.ip AND c.port = scan.port AND c.proto = scan.proto AND (c.subject LIKE ? OR c.sans LIKE ?))`, like, like)
+	}
+	if !q.ExpiresBefore.IsZero() {
+		filter = filter.and(`EXISTS (SELECT 1 FROM certificate c WHERE c.ip = scan.ip AND c.port = scan.port AND c.proto = scan.proto AND c.fingerprint != '' AND c.not_after < ?)`, q.ExpiresBefore.UTC())
+	}
+	if q.Title != "" {
+		filter = filter.and(`EXISTS (SELECT 1 FROM http_response h WHERE h.ip = scan.ip AND h.port = scan.port AND h.proto = scan.proto AND h.title LIKE ?)`, "%"+q.Title+"%")
+	}
+	if q.HTTPServer != "" {
+		filter = filter.and(`EXISTS (SELECT 1 FROM http_response h WHERE h.ip = scan.ip AND h.port = scan.port AND h.proto = scan.proto AND h.server LIKE ?)`, "%"+q.HTTPServer+"%")
+	}
+	if q.Violations {
+		filter = filter.violations()
+	}
+	if !q.Ignored {
+		filter = filter.notIgnored()
+	}
+
+	data, closed, err := db.resultStats(filter)
+	if err != nil {
+		return scan.Data{}, 0, err
+	}
+	if data.Total == 0 {
+		return data, 0, nil
+	}
+
+	switch q.View {
+	case ViewAll:
+	case ViewClosed:
+		filter = filter.gone(closed.before)
+	default:
+		filter = filter.notGone(closed.before)
+	}
+
+	var rows int
+	qry := fmt.Sprintf(`SELECT COUNT(*) FROM scan %s`, filter)
+	if err := db.QueryRow(qry, filter.Values...).Scan(&rows); err != nil {
+		return scan.Data{}, 0, err
+	}
+
+	if q.Sort != "" {
+		dir := "ASC"
+		if q.Desc {
+			dir = "DESC"
+		}
+		filter.Order = fmt.Sprintf("%s %s, %s", sortColumns[q.Sort], dir, defaultOrder)
+	}
+	if q.PerPage > 0 {
+		filter.Limit = q.PerPage
+		if q.Page > 1 {
+			filter.Offset = (q.Page - 1) * q.PerPage
+		}
+	}
+	results, err := db.loadData(filter, closed.lastSeen)
+	if err != nil {
+		return scan.Data{}, 0, err
+	}
+	data.Results = results
+	return data, rows, nil
+}
@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// SavePayload archives the compressed payload of a submission.
+func (db *DB) SavePayload(host string, job *int64, now time.Time, payload []byte) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT INTO payload (host, job_id, submission_time, payload) VALUES (?, ?, ?, ?)`
+	_, err = txn.Exec(qry, host, toNullInt64(job), now, payload)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// LoadPayloads retrieves details of the archived payloads, most recent first.
+func (db *DB) LoadPayloads() ([]scan.Payload, error) {
+	rows, err := db.Query(`SELECT id, submission_time, host, job_id, length(payload) FROM payload ORDER BY submission_time DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payloads := []scan.Payload{}
+	for rows.Next() {
+		var p scan.Payload
+		var t time.Time
+		var job sql.NullInt64
+		if err := rows.Scan(&p.ID, &t, &p.Host, &job, &p.Size); err != nil {
+			return nil, err
+		}
+		p.Time = scan.Time{Time: t.UTC()}
+		p.Job = job.Int64
+		payloads = append(payloads, p)
+	}
+
+	return payloads, rows.Err()
+}
+
+// LoadPayload retrieves an archived payload and the time it was submitted.
+// It returns sql.ErrNoRows if there is no such payload.
+func (db *DB) LoadPayload(id int64) ([]byte, time.Time, error) {
+	var payload []byte
+	var t time.Time
+	err := db.QueryRow(`SELECT payload, submission_time FROM payload WHERE id = ?`, id).Scan(&payload, &t)
+	return payload, t.UTC(), err
+}
+
+// UnarchivedSubmissions counts the submissions with no archived payload, such
+// as those received before archiving was enabled.
+func (db *DB) UnarchivedSubmissions() (int64, error) {
+	var n int64
+	err := db.QueryRow(`SELECT count(*) FROM submission s WHERE NOT EXISTS
+		(SELECT 1 FROM payload p WHERE p.host = s.host AND p.submission_time = s.submission_time)`).Scan(&n)
+	return n, err
+}
+
+// ResetResults deletes every port and everything seen on them, such as
+// banners, so they can be rebuilt from the archived payloads.
+func (db *DB) ResetResults() error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{`DELETE FROM scan`, `DELETE FROM banner`, `DELETE FROM observation`, `DELETE FROM banner_change`} {
+		if _, err := txn.Exec(stmt); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+// Backup writes a copy of the database to path, which mustn't exist.
+func (db *DB) Backup(path string) error {
+	_, err := db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
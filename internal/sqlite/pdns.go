@@ -0,0 +1,109 @@
+package sqlite
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// SavePassiveDNS stores passive DNS records. If a record for the IP and
+// hostname already exists, its first and last seen times are widened.
+func (db *DB) SavePassiveDNS(records []scan.PassiveDNS) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	insert, err := txn.Prepare(`INSERT OR IGNORE INTO pdns (ip, hostname, firstseen, lastseen) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	update, err := txn.Prepare(`UPDATE pdns SET firstseen=min(firstseen, ?), lastseen=max(lastseen, ?) WHERE ip=? AND hostname=?`)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	for _, r := range records {
+		hostname := strings.ToLower(strings.TrimSuffix(r.Hostname, "."))
+		first, last := r.FirstSeen.UTC(), r.LastSeen.UTC()
+		_, err := insert.Exec(r.IP, hostname, first, last)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+		_, err = update.Exec(first, last, r.IP, hostname)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+// LoadPassiveDNS retrieves the passive DNS records for an IP, most recent
+// first.
+func (db *DB) LoadPassiveDNS(ip string) ([]scan.PassiveDNS, error) {
+	rows, err := db.Query(`SELECT ip, hostname, firstseen, lastseen FROM pdns WHERE ip=? ORDER BY lastseen DESC, hostname`, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []scan.PassiveDNS{}
+	for rows.Next() {
+		var r scan.PassiveDNS
+		var firstseen, lastseen time.Time
+		if err := rows.Scan(&r.IP, &r.Hostname, &firstseen, &lastseen); err != nil {
+			return nil, err
+		}
+		r.FirstSeen = scan.Time{Time: firstseen}
+		r.LastSeen = scan.Time{Time: lastseen}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// loadHostnames retrieves the hostnames of every IP: the names in its PTR
+// records, then those seen in passive DNS, most recent first.
+func (db *DB) loadHostnames() (map[string][]string, error) {
+	hostnames := make(map[string][]string)
+
+	ptrs, err := db.LoadRDNS(SQLFilter{})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range ptrs {
+		hostnames[r.IP] = r.Names
+	}
+
+	rows, err := db.Query(`SELECT ip, hostname FROM pdns ORDER BY lastseen DESC, hostname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ip, hostname string
+	for rows.Next() {
+		if err := rows.Scan(&ip, &hostname); err != nil {
+			return nil, err
+		}
+		if !contains(hostnames[ip], hostname) {
+			hostnames[ip] = append(hostnames[ip], hostname)
+		}
+	}
+	return hostnames, rows.Err()
+}
+
+// contains reports whether s is in list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadRDNS retrieves the reverse DNS lookups matching filter, ordered by IP.
+func (db *DB) LoadRDNS(filter SQLFilter) ([]scan.RDNS, error) {
+	qry := fmt.Sprintf(`SELECT ip, names, updated FROM rdns %s ORDER BY ip`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lookups := []scan.RDNS{}
+	for rows.Next() {
+		var r scan.RDNS
+		var names string
+		var updated time.Time
+		if err := rows.Scan(&r.IP, &names, &updated); err != nil {
+			return nil, err
+		}
+		r.Names = strings.Fields(names)
+		r.Updated = scan.Time{Time: updated}
+		lookups = append(lookups, r)
+	}
+
+	return lookups, rows.Err()
+}
+
+// LoadStaleRDNSIPs returns up to limit IPs in the results which haven't been
+// looked up in reverse DNS since before, those never looked up first.
+func (db *DB) LoadStaleRDNSIPs(before time.Time, limit int) ([]string, error) {
+	qry := `SELECT s.ip FROM scan s LEFT JOIN rdns d ON d.ip = s.ip WHERE d.ip IS NULL OR d.updated < ?
+		GROUP BY s.ip ORDER BY d.updated IS NOT NULL, d.updated LIMIT ?`
+	rows, err := db.Query(qry, before.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
+// SaveRDNS stores reverse DNS lookups, replacing any already stored for the
+// same IPs. Names are stored in lower case without the trailing dot.
+func (db *DB) SaveRDNS(lookups []scan.RDNS) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT OR REPLACE INTO rdns (ip, names, updated) VALUES (?, ?, ?)`
+	for _, r := range lookups {
+		names := make([]string, len(r.Names))
+		for i, name := range r.Names {
+			names[i] = strings.ToLower(strings.TrimSuffix(name, "."))
+		}
+		_, err := txn.Exec(qry, r.IP, strings.Join(names, " "), r.Updated.UTC())
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,160 @@
+package sqlite
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// exempt is a condition matching rows of the table named by the format
+// argument which have a retention exemption.
+const exempt = `EXISTS (SELECT 1 FROM retain r WHERE r.ip = %[1]s.ip AND (r.port = 0 OR r.port = %[1]s.port) AND (r.proto = '' OR r.proto = %[1]s.proto))`
+
+// LoadExemptions retrieves all retention exemptions.
+func (db *DB) LoadExemptions() ([]scan.Exemption, error) {
+	rows, err := db.Query(`SELECT ip, port, proto, IFNULL(reason, ''), IFNULL(user, ''), created FROM retain ORDER BY ip, port, proto`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exemptions := []scan.Exemption{}
+	for rows.Next() {
+		var e scan.Exemption
+		var created time.Time
+		if err := rows.Scan(&e.IP, &e.Port, &e.Proto, &e.Reason, &e.User, &created); err != nil {
+			return nil, err
+		}
+		e.Created = scan.Time{Time: created}
+		exemptions = append(exemptions, e)
+	}
+
+	return exemptions, rows.Err()
+}
+
+// SaveExemption stores a retention exemption, replacing any existing one for
+// the same IP, port and protocol.
+func (db *DB) SaveExemption(e scan.Exemption) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT OR REPLACE INTO retain (ip, port, proto, reason, user, created) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = txn.Exec(qry, e.IP, e.Port, e.Proto, e.Reason, e.User, e.Created.UTC())
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// DeleteExemption removes a retention exemption.
+func (db *DB) DeleteExemption(ip string, port int, proto string) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `DELETE FROM retain WHERE ip = ? AND port = ? AND proto = ?`
+	_, err = txn.Exec(qry, ip, port, proto)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// Prune deletes results, banners, observations and banner changes from before
+// the given time, unless they have a retention exemption. It returns the
+// number of results deleted.
+func (db *DB) Prune(before time.Time) (int64, error) {
+	txn, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	before = before.UTC()
+	res, err := txn.Exec(`DELETE FROM scan WHERE lastseen < ? AND NOT `+fmt.Sprintf(exempt, "scan"), before)
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	_, err = txn.Exec(`DELETE FROM banner WHERE lastseen < ? AND NOT `+fmt.Sprintf(exempt, "banner"), before)
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	_, err = txn.Exec(`DELETE FROM observation WHERE seen < ? AND NOT `+fmt.Sprintf(exempt, "observation"), before)
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	_, err = txn.Exec(`DELETE FROM banner_change WHERE changed < ? AND NOT `+fmt.Sprintf(exempt, "banner_change"), before)
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PurgeResults deletes the results, banners, observations and banner changes
+// of the IPs in network, whether or not they have a retention exemption. It
+// returns the number of results deleted.
+func (db *DB) PurgeResults(network *net.IPNet) (int64, error) {
+	tables := []string{"scan", "banner", "observation", "banner_change"}
+	// Only scan has ipkey, so find the IPs in the other tables here
+	ips := make(map[string]bool)
+	for _, table := range tables {
+		rows, err := db.Query(`SELECT DISTINCT ip FROM ` + table)
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var ip string
+			if err := rows.Scan(&ip); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			if addr := net.ParseIP(ip); addr != nil && network.Contains(addr) {
+				ips[ip] = true
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for ip := range ips {
+		for _, table := range tables {
+			res, err := txn.Exec(`DELETE FROM `+table+` WHERE ip = ?`, ip)
+			if err != nil {
+				txn.Rollback()
+				return 0, err
+			}
+			if table == "scan" {
+				n, _ := res.RowsAffected()
+				deleted += n
+			}
+		}
+	}
+
+	if err := txn.Commit(); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
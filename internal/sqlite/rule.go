@@ -0,0 +1,105 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadAlertRules retrieves the alert rules in the order they're evaluated.
+func (db *DB) LoadAlertRules() ([]scan.AlertRule, error) {
+	rows, err := db.Query(`SELECT id, port, proto, cidr, service, banner, action, channels, severity, throttle, expiry FROM alert_rule ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []scan.AlertRule{}
+	for rows.Next() {
+		var r scan.AlertRule
+		var channels string
+		err := rows.Scan(&r.ID, &r.Port, &r.Proto, &r.CIDR, &r.Service, &r.Banner, &r.Action, &channels, &r.Severity, &r.Throttle, &r.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		if channels != "" {
+			r.Channels = strings.Split(channels, ",")
+		}
+		rules = append(rules, r)
+	}
+
+	return rules, rows.Err()
+}
+
+// SaveAlertRule stores a new alert rule, returning its ID.
+func (db *DB) SaveAlertRule(r scan.AlertRule) (int64, error) {
+	txn, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	qry := `INSERT INTO alert_rule (port, proto, cidr, service, banner, action, channels, severity, throttle, expiry) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := txn.Exec(qry, r.Port, r.Proto, r.CIDR, r.Service, r.Banner, r.Action, strings.Join(r.Channels, ","), r.Severity, r.Throttle, r.Expiry)
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	return id, txn.Commit()
+}
+
+// UpdateAlertRule replaces the alert rule with the same ID. It returns
+// sql.ErrNoRows if there is no such rule.
+func (db *DB) UpdateAlertRule(r scan.AlertRule) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `UPDATE alert_rule SET port=?, proto=?, cidr=?, service=?, banner=?, action=?, channels=?, severity=?, throttle=?, expiry=? WHERE id=?`
+	res, err := txn.Exec(qry, r.Port, r.Proto, r.CIDR, r.Service, r.Banner, r.Action, strings.Join(r.Channels, ","), r.Severity, r.Throttle, r.Expiry, r.ID)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+
+	return txn.Commit()
+}
+
+// DeleteAlertRule removes an alert rule. It returns sql.ErrNoRows if there is
+// no such rule.
+func (db *DB) DeleteAlertRule(id int64) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.Exec(`DELETE FROM alert_rule WHERE id = ?`, id)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+
+	return txn.Commit()
+}
+
+// LoadBanners retrieves the current banner of each service seen on a port.
+func (db *DB) LoadBanners(ip string, port int, proto string) (map[string]string, error) {
+	return db.bannerAt(scan.IPInfo{IP: ip, Port: port, Proto: proto}, time.Now().UTC())
+}
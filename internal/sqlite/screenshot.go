@@ -0,0 +1,79 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadScreenshots retrieves the screenshots matching filter, without their
+// images, ordered by IP, port and protocol. Only ports which were captured
+// are included.
+func (db *DB) LoadScreenshots(filter SQLFilter) ([]scan.Screenshot, error) {
+	filter.Where = append(filter.Where, `image IS NOT NULL`)
+	qry := fmt.Sprintf(`SELECT ip, port, proto, url, taken FROM screenshot %s ORDER BY ip, port, proto`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	screenshots := []scan.Screenshot{}
+	for rows.Next() {
+		var s scan.Screenshot
+		var taken time.Time
+		if err := rows.Scan(&s.IP, &s.Port, &s.Proto, &s.URL, &taken); err != nil {
+			return nil, err
+		}
+		s.Taken = scan.Time{Time: taken}
+		screenshots = append(screenshots, s)
+	}
+
+	return screenshots, rows.Err()
+}
+
+// LoadScreenshotImage returns the screenshot of a port as a PNG, or its
+// thumbnail as a JPEG. It returns sql.ErrNoRows if the port hasn't been
+// captured.
+func (db *DB) LoadScreenshotImage(ip string, port int, proto string, thumbnail bool) ([]byte, error) {
+	column := "image"
+	if thumbnail {
+		column = "thumbnail"
+	}
+	var image []byte
+	err := db.QueryRow(`SELECT `+column+` FROM screenshot WHERE ip = ? AND port = ? AND proto = ? AND image IS NOT NULL`, ip, port, proto).Scan(&image)
+	return image, err
+}
+
+// LoadStaleScreenshotPorts returns the web ports which answered an HTTP probe
+// but haven't been captured since before, those never captured first.
+func (db *DB) LoadStaleScreenshotPorts(before time.Time) ([]scan.HTTPResponse, error) {
+	qry := `SELECT h.ip, h.port, h.proto, h.url FROM http_response h LEFT JOIN screenshot s ON s.ip = h.ip AND s.port = h.port AND s.proto = h.proto
+		WHERE h.status != 0 AND (s.ip IS NULL OR s.taken < ?)
+		ORDER BY s.taken IS NOT NULL, s.taken`
+	rows, err := db.Query(qry, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ports []scan.HTTPResponse
+	for rows.Next() {
+		var r scan.HTTPResponse
+		if err := rows.Scan(&r.IP, &r.Port, &r.Proto, &r.URL); err != nil {
+			return nil, err
+		}
+		ports = append(ports, r)
+	}
+	return ports, rows.Err()
+}
+
+// SaveScreenshot stores a screenshot and its thumbnail, replacing any already
+// stored for the port. A nil image records that the port couldn't be
+// captured.
+func (db *DB) SaveScreenshot(s scan.Screenshot, image, thumbnail []byte) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO screenshot (ip, port, proto, url, image, thumbnail, taken) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		s.IP, s.Port, s.Proto, s.URL, image, thumbnail, s.Taken.UTC())
+	return err
+}
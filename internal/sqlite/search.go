@@ -0,0 +1,85 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadSavedSearches retrieves the saved searches in order of name.
+func (db *DB) LoadSavedSearches() ([]scan.SavedSearch, error) {
+	rows, err := db.Query(`SELECT id, name, query, owner, created FROM saved_search ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []scan.SavedSearch{}
+	for rows.Next() {
+		var s scan.SavedSearch
+		var created time.Time
+		if err := rows.Scan(&s.ID, &s.Name, &s.Query, &s.Owner, &created); err != nil {
+			return nil, err
+		}
+		s.Created = scan.Time{Time: created}
+		searches = append(searches, s)
+	}
+
+	return searches, rows.Err()
+}
+
+// LoadSavedSearch retrieves a saved search. It returns sql.ErrNoRows if there
+// is no such search.
+func (db *DB) LoadSavedSearch(id int64) (scan.SavedSearch, error) {
+	var s scan.SavedSearch
+	var created time.Time
+	err := db.QueryRow(`SELECT id, name, query, owner, created FROM saved_search WHERE id = ?`, id).
+		Scan(&s.ID, &s.Name, &s.Query, &s.Owner, &created)
+	s.Created = scan.Time{Time: created}
+	return s, err
+}
+
+// SaveSavedSearch stores a new saved search, returning its ID.
+func (db *DB) SaveSavedSearch(s scan.SavedSearch) (int64, error) {
+	txn, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	qry := `INSERT INTO saved_search (name, query, owner, created) VALUES (?, ?, ?, ?)`
+	res, err := txn.Exec(qry, s.Name, s.Query, s.Owner, s.Created.UTC())
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	return id, txn.Commit()
+}
+
+// DeleteSavedSearch removes a saved search. It returns sql.ErrNoRows if there
+// is no such search.
+func (db *DB) DeleteSavedSearch(id int64) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.Exec(`DELETE FROM saved_search WHERE id = ?`, id)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,94 @@
+package sqlite
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadSources retrieves the sources which have reported observations, most
+// recently seen first. Observations from before sources were recorded aren't
+// included.
+func (db *DB) LoadSources() ([]scan.Source, error) {
+	rows, err := db.Query(`SELECT DISTINCT source FROM observation WHERE source != ''`)
+	if err != nil {
+		return nil, err
+	}
+	var sources []scan.Source
+	for rows.Next() {
+		var s scan.Source
+		if err := rows.Scan(&s.Name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range sources {
+		s := &sources[i]
+		var seen time.Time
+		err := db.QueryRow(`SELECT seen FROM observation WHERE source = ? ORDER BY seen DESC LIMIT 1`, s.Name).Scan(&seen)
+		if err != nil {
+			return nil, err
+		}
+		s.LastSeen = scan.Time{Time: seen.UTC()}
+		err = db.QueryRow(`SELECT count(*) FROM (SELECT DISTINCT ip, port, proto FROM observation WHERE source = ?)`, s.Name).Scan(&s.Ports)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].LastSeen.After(sources[j].LastSeen.Time) })
+	return sources, nil
+}
+
+// CompareSources finds the ports seen by source a since a time which b
+// didn't see in that time, and those seen by b which a didn't. Their first
+// and last seen times are those from the source which saw them.
+func (db *DB) CompareSources(a, b string, since time.Time) (scan.SourceComparison, error) {
+	cmp := scan.SourceComparison{A: a, B: b, Since: scan.Time{Time: since}}
+	var err error
+	if cmp.OnlyA, err = db.seenOnlyBy(a, b, since); err != nil {
+		return cmp, err
+	}
+	if cmp.OnlyB, err = db.seenOnlyBy(b, a, since); err != nil {
+		return cmp, err
+	}
+	err = db.QueryRow(`SELECT count(*) FROM (SELECT DISTINCT ip, port, proto FROM observation o WHERE o.source = ? AND o.seen >= ?
+		AND EXISTS (SELECT 1 FROM observation x WHERE x.ip = o.ip AND x.port = o.port AND x.proto = o.proto AND x.source = ? AND x.seen >= ?))`,
+		a, since, b, since).Scan(&cmp.Both)
+	return cmp, err
+}
+
+// seenOnlyBy retrieves the ports source saw since a time which other didn't.
+func (db *DB) seenOnlyBy(source, other string, since time.Time) ([]scan.IPInfo, error) {
+	rows, err := db.Query(`SELECT o.ip, o.port, o.proto, o.seen FROM observation o WHERE o.source = ? AND o.seen >= ?
+		AND NOT EXISTS (SELECT 1 FROM observation x WHERE x.ip = o.ip AND x.port = o.port AND x.proto = o.proto AND x.source = ? AND x.seen >= ?)
+		ORDER BY o.port, o.proto, o.ip, o.seen`, source, since, other, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ports := []scan.IPInfo{}
+	for rows.Next() {
+		var r scan.IPInfo
+		var seen time.Time
+		if err := rows.Scan(&r.IP, &r.Port, &r.Proto, &seen); err != nil {
+			return nil, err
+		}
+		seen = seen.UTC()
+		if n := len(ports); n > 0 && ports[n-1].IP == r.IP && ports[n-1].Port == r.Port && ports[n-1].Proto == r.Proto {
+			ports[n-1].LastSeen = scan.Time{Time: seen}
+			continue
+		}
+		r.FirstSeen = scan.Time{Time: seen}
+		r.LastSeen = scan.Time{Time: seen}
+		ports = append(ports, r)
+	}
+	return ports, rows.Err()
+}
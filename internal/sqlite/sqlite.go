@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -23,6 +23,7 @@ const DefaultDBFile = "scan.db"
 // DB is the database.
 type DB struct {
 	*sql.DB
+	migration int64 // the latest migration version
 }
 
 func toNullInt64(i *int64) sql.NullInt64 {
@@ -33,6 +34,51 @@ func toNullInt64(i *int64) sql.NullInt64 {
 	return ni
 }
 
+// Options tune how SQLite is opened. They're applied to every connection in
+// the pool, so are passed in the DSN rather than set with PRAGMA statements.
+type Options struct {
+	// JournalMode is the journal_mode, such as WAL, which lets readers
+	// continue while a submission is being written.
+	JournalMode string
+	// BusyTimeout is how long to wait for a lock held by another
+	// connection before failing with "database is locked".
+	BusyTimeout time.Duration
+	// Synchronous is the synchronous level: OFF, NORMAL, FULL or EXTRA.
+	Synchronous string
+}
+
+// DefaultOptions suit concurrent submissions. NORMAL is safe from corruption
+// in WAL mode, though a power loss may lose the last transactions.
+var DefaultOptions = Options{JournalMode: "WAL", BusyTimeout: 5 * time.Second, Synchronous: "NORMAL"}
+
+// DSN returns the DSN for opening the database file at path with o.
+func (o Options) DSN(path string) (string, error) {
+	params := []string{}
+	if o.JournalMode != "" {
+		switch strings.ToUpper(o.JournalMode) {
+		case "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF":
+		default:
+			return "", fmt.Errorf("invalid journal mode %q", o.JournalMode)
+		}
+		params = append(params, "_journal_mode="+strings.ToUpper(o.JournalMode))
+	}
+	if o.BusyTimeout > 0 {
+		params = append(params, "_busy_timeout="+strconv.FormatInt(int64(o.BusyTimeout/time.Millisecond), 10))
+	}
+	if o.Synchronous != "" {
+		switch strings.ToUpper(o.Synchronous) {
+		case "OFF", "NORMAL", "FULL", "EXTRA":
+		default:
+			return "", fmt.Errorf("invalid synchronous level %q", o.Synchronous)
+		}
+		params = append(params, "_synchronous="+strings.ToUpper(o.Synchronous))
+	}
+	if len(params) == 0 {
+		return path, nil
+	}
+	return "file:" + path + "?" + strings.Join(params, "&"), nil
+}
+
 // Open creates a new SQLite database object.
 func Open(dsn string) (*DB, error) {
 	var err error
@@ -50,7 +96,7 @@ func Open(dsn string) (*DB, error) {
 	goose.SetDialect("sqlite3")
 	// Use a temporary directory for goose.Up() - we don't have any .sql files
 	// to run, it's all embedded in the binary
-	tmpdir, err := ioutil.TempDir(filepath.Dir(dsn), "")
+	tmpdir, err := ioutil.TempDir("", "scan")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -68,14 +114,54 @@ func Open(dsn string) (*DB, error) {
 	if err != nil {
 		log.Fatalf("Error running database migrations: %v\n", err)
 	}
+	migrations, err := goose.CollectMigrations(tmpdir, 0, goose.MaxVersion)
+	if err != nil {
+		return nil, err
+	}
+	last, err := migrations.Last()
+	if err != nil {
+		return nil, err
+	}
 
-	return &DB{DB: db}, nil
+	return &DB{DB: db, migration: last.Version}, nil
+}
+
+// Ready checks the database can be reached and has all migrations applied.
+func (db *DB) Ready() error {
+	if err := db.Ping(); err != nil {
+		return err
+	}
+	version, err := goose.GetDBVersion(db.DB)
+	if err != nil {
+		return err
+	}
+	if version != db.migration {
+		return fmt.Errorf("database is at migration %d, expected %d", version, db.migration)
+	}
+	return nil
+}
+
+// Size returns the size of the database in bytes.
+func (db *DB) Size() (int64, error) {
+	var pages, pageSize int64
+	if err := db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pages * pageSize, nil
 }
 
 // SQLFilter is for constructing data filters ("WHERE" clauses) in a SQL statement
 type SQLFilter struct {
 	Where  []string
 	Values []interface{}
+	// Order sorts the rows, and Limit and Offset select a page of them if
+	// Limit is set. They're only used by LoadData, and LoadAudit uses only
+	// Limit and Offset.
+	Order         string
+	Limit, Offset int
 }
 
 // String constructs a SQL WHERE clause.
@@ -88,8 +174,29 @@ func (f SQLFilter) String() string {
 
 // LoadData loads all data for displaying in the browser.
 func (db *DB) LoadData(filter SQLFilter) ([]scan.IPInfo, error) {
-	qry := fmt.Sprintf(`SELECT ip, port, proto, firstseen, lastseen FROM scan %s ORDER BY port, proto, ip, lastseen`, filter)
-	rows, err := db.Query(qry, filter.Values...)
+	var lastSeen time.Time
+	qry := fmt.Sprintf(`SELECT lastseen FROM scan %s ORDER BY lastseen DESC LIMIT 1`, filter)
+	err := db.QueryRow(qry, filter.Values...).Scan(&lastSeen)
+	if err != nil && err != sql.ErrNoRows {
+		return []scan.IPInfo{}, err
+	}
+	return db.loadData(filter, lastSeen)
+}
+
+// loadData loads the data matching filter. lastSeen is the latest time any
+// port matching the search was seen, which may be outside the page loaded.
+func (db *DB) loadData(filter SQLFilter, lastSeen time.Time) ([]scan.IPInfo, error) {
+	order := filter.Order
+	if order == "" {
+		order = defaultOrder
+	}
+	qry := fmt.Sprintf(`SELECT ip, port, proto, firstseen, lastseen FROM scan %s ORDER BY %s`, filter, order)
+	values := filter.Values
+	if filter.Limit > 0 {
+		qry += ` LIMIT ? OFFSET ?`
+		values = append(values[:len(values):len(values)], filter.Limit, filter.Offset)
+	}
+	rows, err := db.Query(qry, values...)
 	if err != nil {
 		return []scan.IPInfo{}, err
 	}
@@ -100,16 +207,100 @@ func (db *DB) LoadData(filter SQLFilter) ([]scan.IPInfo, error) {
 	var ip, proto string
 	var firstseen, lastseen time.Time
 	var port int
-	var latest time.Time
+	latest := lastSeen
 
 	tracerouteIPs, err := db.LoadTracerouteIPs()
 	if err != nil {
 		return []scan.IPInfo{}, err
 	}
 
-	submission, err := db.LoadSubmission(SQLFilter{Where: []string{"job_id IS NULL"}})
-	if err == nil {
-		latest = submission.Time.Time
+	hostnames, err := db.loadHostnames()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	// Acknowledged ports are never new
+	acks, err := db.loadAckMap(time.Now())
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	fields, err := db.loadFieldMap()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	tags, err := db.loadTagMap()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	notes, err := db.loadNoteCounts()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	networks, err := db.loadNetworkSet()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	baseline, err := db.LoadBaseline()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	geo, err := db.loadGeoMap()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	whois, err := db.loadWhoisMap()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	abuse, err := db.loadAbuseScoreMap()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	vulns, err := db.loadVulnMap()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	certs, err := db.loadCertificateMap()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	responses, err := db.loadHTTPResponseMap()
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	// Ports which haven't been seen since the latest full scan started are
+	// closed
+	lastScan, latest, err := db.lastFullScan(latest)
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+	since, err := db.newSince(latest)
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	// Jobs and scoped submissions only scan part of the range, so can only
+	// close ports they cover
+	jobRuns, err := db.loadJobRuns(SQLFilter{})
+	if err != nil {
+		return []scan.IPInfo{}, err
+	}
+
+	changed, err := db.loadChangedPorts(latest)
+	if err != nil {
+		return []scan.IPInfo{}, err
 	}
 
 	for rows.Next() {
@@ -118,22 +309,40 @@ func (db *DB) LoadData(filter SQLFilter) ([]scan.IPInfo, error) {
 			log.Println("loadData: error scanning table:", err)
 			return []scan.IPInfo{}, err
 		}
-		if lastseen.After(latest) {
-			latest = lastseen
-		}
 		var hasTraceroute bool
 		if _, ok := tracerouteIPs[ip]; ok {
 			hasTraceroute = true
 		}
+		gone := lastseen.Before(lastScan)
+		if !gone {
+			gone = closedByJob(jobRuns, ip, port, proto, lastseen)
+		}
+		ack := acks[ackKey{ip, port, proto}]
+		vuln := vulns[ackKey{ip, port, proto}]
 		data = append(data, scan.IPInfo{
 			IP:            ip,
 			Port:          port,
 			Proto:         proto,
 			FirstSeen:     scan.Time{Time: firstseen},
 			LastSeen:      scan.Time{Time: lastseen},
-			New:           firstseen.Equal(lastseen) && lastseen == latest,
-			Gone:          lastseen.Before(latest),
-			HasTraceroute: hasTraceroute})
+			New:           firstseen.Equal(lastseen) && !lastseen.Before(since) && ack == nil,
+			Gone:          gone,
+			HasTraceroute: hasTraceroute,
+			Hostnames:     hostnames[ip],
+			Ack:           ack,
+			Fields:        fields.lookup(ip, port, proto),
+			BannerChanged: changed[ackKey{ip, port, proto}],
+			Tags:          tags[ip],
+			Notes:         notes[ackKey{ip, 0, ""}] + notes[ackKey{ip, port, proto}],
+			Networks:      networks.lookup(ip),
+			Violation:     scan.Violates(baseline, ip, port, proto),
+			Geo:           geo[ip],
+			Whois:         whois[ip],
+			AbuseScore:    abuse[ip],
+			CVEs:          vuln.count,
+			Severity:      vuln.severity,
+			Certificate:   certs[ackKey{ip, port, proto}],
+			HTTP:          responses[ackKey{ip, port, proto}]})
 	}
 
 	return data, nil
@@ -142,10 +351,30 @@ func (db *DB) LoadData(filter SQLFilter) ([]scan.IPInfo, error) {
 // ResultData retrieves stored results. Each argument is optional and allows
 // searching by IP address, first seen and last seen.
 func (db *DB) ResultData(ip, fs, ls string) (scan.Data, error) {
+	filter := resultFilter(ip, fs, ls)
+	data, _, err := db.resultStats(filter)
+	if err != nil {
+		return scan.Data{}, err
+	}
+	data.Results, err = db.LoadData(filter)
+	if err != nil {
+		return scan.Data{}, err
+	}
+	return data, nil
+}
+
+// resultFilter builds the filter for searching by IP address, first seen and
+// last seen. Each argument is optional. An IP address in CIDR notation
+// searches the network, otherwise it's matched as text.
+func resultFilter(ip, fs, ls string) SQLFilter {
 	var filter SQLFilter
-	if ip != "" {
+	if _, network, err := net.ParseCIDR(ip); err == nil {
+		first, last := scan.NetworkKeys(network)
+		filter.Where = append(filter.Where, `ipkey BETWEEN ? AND ?`)
+		filter.Values = append(filter.Values, first, last)
+	} else if ip != "" {
 		filter.Where = append(filter.Where, `ip LIKE ?`)
-		filter.Values = append(filter.Values, fmt.Sprintf("%%%s%%", ip))
+		filter.Values = append(filter.Values, fmt.Sprintf("%%%s%%", scan.NormalizeIP(ip)))
 	}
 	if fs != "" {
 		i, err := strconv.ParseInt(fs, 10, 0)
@@ -167,139 +396,444 @@ func (db *DB) ResultData(ip, fs, ls string) (scan.Data, error) {
 			filter.Values = append(filter.Values, t)
 		}
 	}
+	return filter
+}
 
-	results, err := db.LoadData(filter)
-	if err != nil {
-		return scan.Data{}, err
+// and returns a copy of the filter with another condition added.
+func (f SQLFilter) and(where string, values ...interface{}) SQLFilter {
+	return SQLFilter{
+		Where:  append(f.Where[:len(f.Where):len(f.Where)], where),
+		Values: append(f.Values[:len(f.Values):len(f.Values)], values...),
 	}
+}
+
+// closedByRun matches ports which a job or scoped submission covered after
+// they were last seen, as closedByJob decides for ports already loaded.
+const closedByRun = `EXISTS (SELECT 1 FROM run_coverage c WHERE c.started > scan.lastseen AND scan.ipkey BETWEEN c.first AND c.last AND scan.port BETWEEN c.port_first AND c.port_last AND c.proto IN ('', scan.proto))`
+
+// gone returns a copy of the filter including only closed ports: those last
+// seen before before, when the latest full scan started, or covered by a job
+// or scoped submission since.
+func (f SQLFilter) gone(before time.Time) SQLFilter {
+	return f.and(`(lastseen < ? OR `+closedByRun+`)`, before)
+}
+
+// notGone returns a copy of the filter excluding the ports gone includes.
+func (f SQLFilter) notGone(before time.Time) SQLFilter {
+	return f.and(`NOT (lastseen < ? OR `+closedByRun+`)`, before)
+}
+
+// closedTimes are when ports matching a filter may have closed. Ports last
+// seen before before are closed, as are those covered by a job or scoped
+// submission since. lastSeen is the latest time any of them was seen.
+type closedTimes struct {
+	before, lastSeen time.Time
+}
 
-	data := scan.Data{
-		Results: results,
-		Total:   len(results),
+// resultStats counts the ports matching filter which are open, closed and
+// new, following the same rules as LoadData. The counts are done in SQL so
+// they don't need every row loaded; only ports which might be new are checked
+// individually.
+func (db *DB) resultStats(filter SQLFilter) (scan.Data, closedTimes, error) {
+	// Set LastSeen to Unix(0, 0) rather than the default zero value of the
+	// type to allow tests to receive an actual 0 value rather than a
+	// negative int
+	data := scan.Data{LastSeen: time.Unix(0, 0).Unix()}
+	var closed closedTimes
+
+	err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM scan %s`, filter), filter.Values...).Scan(&data.Total)
+	if err != nil || data.Total == 0 {
+		return data, closed, err
 	}
+	// MAX() would lose the column type, so the time wouldn't be parsed
+	var lastSeen time.Time
+	qry := fmt.Sprintf(`SELECT lastseen FROM scan %s ORDER BY lastseen DESC LIMIT 1`, filter)
+	if err := db.QueryRow(qry, filter.Values...).Scan(&lastSeen); err != nil {
+		return data, closed, err
+	}
+	data.LastSeen = lastSeen.Unix()
+	closed.lastSeen = lastSeen
 
-	// Find all the latest results and store the number in the struct
-	// Set latest to Unix(0, 0) rather than the default zero value of the type
-	// to allow tests to receive an actual 0 value rather than a negative int
-	latest := time.Unix(0, 0)
-	for _, r := range results {
-		last := r.LastSeen.Time
-		if last.After(latest) {
-			latest = last
-		}
+	// See LoadData for how closed and new ports are decided
+	lastScan, latest, err := db.lastFullScan(lastSeen)
+	if err != nil {
+		return data, closed, err
 	}
-	for _, r := range results {
-		if !r.Gone {
-			data.Latest++
-		}
-		if r.New {
+	since, err := db.newSince(latest)
+	if err != nil {
+		return data, closed, err
+	}
+	closed.before = lastScan
+	gone := filter.gone(closed.before)
+	qry = fmt.Sprintf(`SELECT COUNT(*) FROM scan %s`, gone)
+	if err := db.QueryRow(qry, gone.Values...).Scan(&data.Closed); err != nil {
+		return data, closed, err
+	}
+	data.Latest = data.Total - data.Closed
+
+	// Acknowledged ports are never new
+	acks, err := db.loadAckMap(time.Now())
+	if err != nil {
+		return data, closed, err
+	}
+	added := filter.and(`firstseen = lastseen AND lastseen >= ?`, since)
+	err = db.scanPorts(added, func(ip string, port int, proto string, _ time.Time) {
+		if acks[ackKey{ip, port, proto}] == nil {
 			data.New++
 		}
+	})
+	return data, closed, err
+}
+
+// fullScan matches submissions which covered every IP and port: those which
+// weren't for a job and had scan.ScopeAll as their scope and no ports.
+const fullScan = `job_id IS NULL AND scope = '0.0.0.0/0,::/0' AND ports = ''`
+
+// lastFullScan returns when the latest full scan started, and the later of
+// latest, the latest time any port was seen, and when it was submitted. If
+// there hasn't been a full scan, ports are only closed by the jobs and scoped
+// submissions which covered them, so it returns the zero time, unless nothing
+// has been submitted at all. Then ports not seen at latest are closed.
+func (db *DB) lastFullScan(latest time.Time) (time.Time, time.Time, error) {
+	submission, err := db.LoadSubmission(SQLFilter{Where: []string{fullScan}})
+	if err != nil {
+		return time.Time{}, latest, err
+	}
+	if submission.ID == 0 {
+		var any bool
+		err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM submission)`).Scan(&any)
+		if err != nil || any {
+			return time.Time{}, latest, err
+		}
+		return latest, latest, nil
+	}
+	if submission.Time.After(latest) {
+		latest = submission.Time.Time
 	}
-	data.LastSeen = latest.Unix()
+	return submission.Start(), latest, nil
+}
 
-	return data, nil
+// newSince returns the time from which ports seen only once are new, given
+// the latest time any was seen or a scan was submitted. Ports are new if
+// they were first seen by the submission which reported the latest of them,
+// at any time since its scan started.
+func (db *DB) newSince(latest time.Time) (time.Time, error) {
+	var started time.Time
+	err := db.QueryRow(`SELECT started FROM submission WHERE submission_time >= ? ORDER BY submission_time LIMIT 1`, latest.UTC()).Scan(&started)
+	switch {
+	case err == sql.ErrNoRows:
+		return latest, nil
+	case err != nil:
+		return latest, err
+	case started.Before(latest):
+		return started, nil
+	}
+	return latest, nil
 }
 
-// SaveData saves the results posted.
-func (db *DB) SaveData(results []scan.Result, now time.Time) (int64, error) {
-	txn, err := db.Begin()
+// scanPorts calls fn for each port matching filter.
+func (db *DB) scanPorts(filter SQLFilter, fn func(ip string, port int, proto string, lastseen time.Time)) error {
+	qry := fmt.Sprintf(`SELECT ip, port, proto, lastseen FROM scan %s`, filter)
+	rows, err := db.Query(qry, filter.Values...)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ip, proto string
+		var port int
+		var lastseen time.Time
+		if err := rows.Scan(&ip, &port, &proto, &lastseen); err != nil {
+			return err
+		}
+		fn(ip, port, proto, lastseen)
 	}
+	return rows.Err()
+}
 
-	insert, err := txn.Prepare(`INSERT INTO scan (ip, port, proto, firstseen, lastseen) VALUES (?, ?, ?, ?, ?)`)
+// SaveData saves the results posted. It returns the number of ports saved and
+// the ports which hadn't been seen before.
+// Results are recorded as seen at their timestamp, or now if they don't have
+// one. An older timestamp never moves a port's last seen time backwards.
+// Each observation is recorded with the result's source.
+// Ports are staged in a temporary table, in batches of rows, so they can be
+// upserted into scan in one statement after finding which are new with
+// another. Banners are staged too, to record which have changed. Ports and
+// banners matching an ingest ignore rule aren't saved.
+func (db *DB) SaveData(results []scan.Result, now time.Time) (int64, []scan.IPInfo, error) {
+	ingestRules, err := db.loadIngestRules()
 	if err != nil {
-		txn.Rollback()
-		return 0, err
+		return 0, nil, err
 	}
-	qry, err := txn.Prepare(`SELECT 1 FROM scan WHERE ip=? AND port=? AND proto=?`)
+
+	txn, err := db.Begin()
 	if err != nil {
-		txn.Rollback()
-		return 0, err
+		return 0, nil, err
 	}
-	update, err := txn.Prepare(`UPDATE scan SET lastseen=? WHERE ip=? AND port=? AND proto=?`)
-	if err != nil {
-		txn.Rollback()
-		return 0, err
+
+	stmts := []string{
+		`CREATE TEMP TABLE IF NOT EXISTS scan_import (ip text, ipkey blob, port integer, proto text, seen datetime, source text)`,
+		`DELETE FROM scan_import`,
+		`CREATE TEMP TABLE IF NOT EXISTS banner_import (ip text, port integer, proto text, service text, banner text, seen datetime)`,
+		`DELETE FROM banner_import`,
+	}
+	for _, stmt := range stmts {
+		if _, err := txn.Exec(stmt); err != nil {
+			txn.Rollback()
+			return 0, nil, err
+		}
 	}
 
+	stage := newBatchInsert(txn, `INSERT INTO scan_import (ip, ipkey, port, proto, seen, source)`, 6, "")
+	banner := newBatchInsert(txn, `INSERT INTO banner_import (ip, port, proto, service, banner, seen)`, 6, "")
+
 	var count int64
+	// The ports in the order they were submitted, with the range of times
+	// they were seen
+	var ports []scan.IPInfo
+	index := make(map[string]int)
 
 	for _, r := range results {
 		// Although it's an array, only one port is in each
+		if len(r.Ports) == 0 {
+			continue
+		}
 		port := r.Ports[0]
 
-		// Skip results which are (usually) banner-only
-		// While it would be nice to store banners, we need to restructure a
-		// bit to accommodate this and it just inserts duplicate data for now
-		if port.Status == "" || port.Service.Name != "" {
-			continue
+		seen := now
+		if !r.Timestamp.IsZero() {
+			seen = r.Timestamp.UTC()
 		}
 
-		// Search for the IP/port/proto combo
-		// If it exists, update `lastseen`, else insert a new record
+		if dropped(ingestRules, r.IP, port.Port, port.Proto) {
+			continue
+		}
 
-		// Because we have to scan into something
-		var x int
-		err := qry.QueryRow(r.IP, port.Port, port.Proto).Scan(&x)
-		switch {
-		case err == sql.ErrNoRows:
-			_, err = insert.Exec(r.IP, port.Port, port.Proto, now, now)
+		// Banners are sent as separate results without a status. They're
+		// stored separately so they don't duplicate the port data.
+		if port.Service.Name != "" {
+			err := banner.add(r.IP, port.Port, port.Proto, port.Service.Name, port.Service.Banner, seen)
 			if err != nil {
 				txn.Rollback()
-				return 0, err
+				return 0, nil, err
 			}
-			count++
 			continue
-		case err != nil:
+		}
+		if port.Status == "" {
+			continue
+		}
+
+		if err := stage.add(r.IP, scan.IPKey(r.IP), port.Port, port.Proto, seen, r.Source); err != nil {
 			txn.Rollback()
-			return 0, err
+			return 0, nil, err
 		}
+		count++
 
-		_, err = update.Exec(now, r.IP, port.Port, port.Proto)
-		if err != nil {
+		key := fmt.Sprintf("%s %d %s", r.IP, port.Port, port.Proto)
+		if i, ok := index[key]; ok {
+			if seen.Before(ports[i].FirstSeen.Time) {
+				ports[i].FirstSeen = scan.Time{Time: seen}
+			}
+			if seen.After(ports[i].LastSeen.Time) {
+				ports[i].LastSeen = scan.Time{Time: seen}
+			}
+			continue
+		}
+		index[key] = len(ports)
+		ports = append(ports, scan.IPInfo{
+			IP: r.IP, Port: port.Port, Proto: port.Proto,
+			FirstSeen: scan.Time{Time: seen}, LastSeen: scan.Time{Time: seen}})
+	}
+
+	for _, b := range []*batchInsert{stage, banner} {
+		if err := b.flush(); err != nil {
+			txn.Rollback()
+			return 0, nil, err
+		}
+	}
+
+	// Find the ports which aren't in scan yet
+	rows, err := txn.Query(`SELECT DISTINCT i.ip, i.port, i.proto FROM scan_import i
+		LEFT JOIN scan s ON s.ip = i.ip AND s.port = i.port AND s.proto = i.proto WHERE s.ip IS NULL`)
+	if err != nil {
+		txn.Rollback()
+		return 0, nil, err
+	}
+	isNew := make(map[string]bool)
+	for rows.Next() {
+		var ip, proto string
+		var port int
+		if err := rows.Scan(&ip, &port, &proto); err != nil {
+			rows.Close()
 			txn.Rollback()
-			return 0, err
+			return 0, nil, err
 		}
+		isNew[fmt.Sprintf("%s %d %s", ip, port, proto)] = true
+	}
+	if err := rows.Err(); err != nil {
+		txn.Rollback()
+		return 0, nil, err
+	}
 
-		count++
+	// WHERE true avoids the ambiguity between the SELECT's join and the
+	// upsert's ON clause
+	_, err = txn.Exec(`INSERT INTO scan (ip, ipkey, port, proto, firstseen, lastseen)
+		SELECT ip, ipkey, port, proto, min(seen), max(seen) FROM scan_import WHERE true GROUP BY ip, port, proto
+		ON CONFLICT (ip, port, proto) DO UPDATE SET firstseen=min(firstseen, excluded.firstseen), lastseen=max(lastseen, excluded.lastseen)`)
+	if err != nil {
+		txn.Rollback()
+		return 0, nil, err
+	}
+	_, err = txn.Exec(`INSERT OR IGNORE INTO observation (ip, port, proto, seen, source) SELECT ip, port, proto, seen, source FROM scan_import`)
+	if err != nil {
+		txn.Rollback()
+		return 0, nil, err
 	}
 
-	txn.Commit()
-	return count, nil
+	// A banner is a change if it differs from the service's latest banner
+	// and was seen after it
+	_, err = txn.Exec(`INSERT INTO banner_change (ip, port, proto, service, old, new, changed, recorded)
+		SELECT ip, port, proto, service, old, banner, seen, ? FROM (
+			SELECT i.ip, i.port, i.proto, i.service, i.banner, min(i.seen) AS seen,
+				(SELECT b.banner FROM banner b WHERE b.ip = i.ip AND b.port = i.port AND b.proto = i.proto AND b.service = i.service ORDER BY b.lastseen DESC LIMIT 1) AS old,
+				(SELECT max(b.lastseen) FROM banner b WHERE b.ip = i.ip AND b.port = i.port AND b.proto = i.proto AND b.service = i.service) AS last
+			FROM banner_import i GROUP BY i.ip, i.port, i.proto, i.service, i.banner)
+		WHERE old != banner AND seen > last`, now.UTC())
+	if err != nil {
+		txn.Rollback()
+		return 0, nil, err
+	}
+	_, err = txn.Exec(`INSERT INTO banner (ip, port, proto, service, banner, firstseen, lastseen)
+		SELECT ip, port, proto, service, banner, min(seen), max(seen) FROM banner_import WHERE true GROUP BY ip, port, proto, service, banner
+		ON CONFLICT (ip, port, proto, service, banner) DO UPDATE SET firstseen=min(firstseen, excluded.firstseen), lastseen=max(lastseen, excluded.lastseen)`)
+	if err != nil {
+		txn.Rollback()
+		return 0, nil, err
+	}
+
+	for _, stmt := range []string{`DELETE FROM scan_import`, `DELETE FROM banner_import`} {
+		if _, err := txn.Exec(stmt); err != nil {
+			txn.Rollback()
+			return 0, nil, err
+		}
+	}
+
+	var added []scan.IPInfo
+	for _, p := range ports {
+		if isNew[fmt.Sprintf("%s %d %s", p.IP, p.Port, p.Proto)] {
+			added = append(added, p)
+		}
+	}
+
+	return count, added, txn.Commit()
 }
 
-// LoadSubmission retrieves the stored submissions.
-func (db *DB) LoadSubmission(filter SQLFilter) (scan.Submission, error) {
-	var host string
+const submissionColumns = `rowid, host, job_id, submission_time, started, scanner, args, rate, scope, ports`
+
+func scanSubmission(row interface{ Scan(...interface{}) error }) (scan.Submission, error) {
+	var sub scan.Submission
 	var job sql.NullInt64
-	var subTime sql.NullTime
+	var subTime, started sql.NullTime
+	var scope string
+	if err := row.Scan(&sub.ID, &sub.Host, &job, &subTime, &started, &sub.Scanner, &sub.Args, &sub.Rate, &scope, &sub.Ports); err != nil {
+		return sub, err
+	}
+	if scope != "" {
+		sub.Scope = strings.Split(scope, ",")
+	}
+	sub.Job = job.Int64
+	sub.Time = scan.Time{Time: subTime.Time.UTC()}
+	if started.Valid && started.Time.Before(subTime.Time) {
+		sub.Started = &scan.Time{Time: started.Time.UTC()}
+	}
+	return sub, nil
+}
 
-	qry := fmt.Sprintf(`SELECT host, job_id, submission_time FROM submission %s ORDER BY rowid DESC LIMIT 1`, filter)
-	err := db.QueryRow(qry, filter.Values...).Scan(&host, &job, &subTime)
+// LoadSubmission retrieves the most recent submission matching the filter.
+// Submissions are ordered by when they were made rather than saved, as old
+// scans may be imported after newer ones.
+func (db *DB) LoadSubmission(filter SQLFilter) (scan.Submission, error) {
+	qry := fmt.Sprintf(`SELECT %s FROM submission %s ORDER BY submission_time DESC, rowid DESC LIMIT 1`, submissionColumns, filter)
+	sub, err := scanSubmission(db.QueryRow(qry, filter.Values...))
 	if err != nil && err != sql.ErrNoRows {
 		log.Println("loadSubmission: error scanning table:", err)
 		return scan.Submission{}, err
 	}
 
-	return scan.Submission{Host: host, Job: job.Int64, Time: scan.Time{Time: subTime.Time.UTC()}}, nil
+	return sub, nil
 }
 
-// SaveSubmission stores when and which host just submitted data.
-func (db *DB) SaveSubmission(host string, job *int64, now time.Time) error {
+// LoadSubmissions retrieves up to limit submissions, most recent first.
+func (db *DB) LoadSubmissions(limit int) ([]scan.Submission, error) {
+	rows, err := db.Query(`SELECT `+submissionColumns+` FROM submission ORDER BY submission_time DESC, rowid DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []scan.Submission{}
+	for rows.Next() {
+		sub, err := scanSubmission(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// SaveSubmission stores when and which host just submitted data, and the
+// scanner run it came from. The scan is recorded as starting when the run
+// started, or now if it doesn't say. A run only covered its scope and ports,
+// and one without a scope covered nothing, so only a run with scan.ScopeAll
+// and every port is a full scan. What a job or scoped run covered is stored
+// too, so the ports it closed can be found in SQL.
+func (db *DB) SaveSubmission(host string, job *int64, now time.Time, run scan.Run) error {
 	txn, err := db.Begin()
 	if err != nil {
 		return err
 	}
 
-	qry := `INSERT INTO submission (host, job_id, submission_time) VALUES (?, ?, ?)`
-	_, err = txn.Exec(qry, host, toNullInt64(job), now)
+	started := now
+	if run.Started != nil && run.Started.Before(now) {
+		started = run.Started.UTC()
+	}
+	scope := strings.Join(run.Scope, ",")
+	qry := `INSERT INTO submission (host, job_id, submission_time, started, scanner, args, rate, scope, ports) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := txn.Exec(qry, host, toNullInt64(job), now, started, run.Scanner, run.Args, run.Rate, scope, run.Ports)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	id, err := res.LastInsertId()
 	if err != nil {
 		txn.Rollback()
 		return err
 	}
 
+	var coverage []scan.Coverage
+	switch {
+	case job != nil:
+		var j scan.Job
+		err := txn.QueryRow(`SELECT cidr, ports, proto FROM job WHERE rowid = ?`, *job).Scan(&j.CIDR, &j.Ports, &j.Proto)
+		if err != nil && err != sql.ErrNoRows {
+			txn.Rollback()
+			return err
+		}
+		coverage = j.Range().Coverage()
+	case scope != strings.Join(scan.ScopeAll, ",") || run.Ports != "":
+		coverage = scan.ScopeCoverage(run.Scope, run.Ports)
+	}
+	for _, c := range coverage {
+		_, err := txn.Exec(`INSERT INTO run_coverage (submission, first, last, port_first, port_last, proto, started) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, c.First, c.Last, c.FirstPort, c.LastPort, c.Proto, started.UTC())
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
 	err = txn.Commit()
 	if err != nil {
 		return err
@@ -0,0 +1,82 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadTags retrieves the tags matching filter, ordered by IP and name.
+func (db *DB) LoadTags(filter SQLFilter) ([]scan.Tag, error) {
+	qry := fmt.Sprintf(`SELECT ip, name, user, created FROM tag %s ORDER BY ip, name`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []scan.Tag{}
+	for rows.Next() {
+		var t scan.Tag
+		var created time.Time
+		if err := rows.Scan(&t.IP, &t.Name, &t.User, &created); err != nil {
+			return nil, err
+		}
+		t.Created = scan.Time{Time: created}
+		tags = append(tags, t)
+	}
+
+	return tags, rows.Err()
+}
+
+// loadTagMap retrieves the names of the tags on each IP.
+func (db *DB) loadTagMap() (map[string][]string, error) {
+	tags, err := db.LoadTags(SQLFilter{})
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string][]string)
+	for _, t := range tags {
+		m[t.IP] = append(m[t.IP], t.Name)
+	}
+	return m, nil
+}
+
+// SaveTag tags a host. Tagging a host again with the same name does nothing.
+func (db *DB) SaveTag(t scan.Tag) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT OR IGNORE INTO tag (ip, name, user, created) VALUES (?, ?, ?, ?)`
+	if _, err := txn.Exec(qry, t.IP, t.Name, t.User, t.Created.UTC()); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// DeleteTag removes a tag from a host. It returns sql.ErrNoRows if the host
+// doesn't have the tag.
+func (db *DB) DeleteTag(ip, name string) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.Exec(`DELETE FROM tag WHERE ip = ? AND name = ?`, ip, name)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+
+	return txn.Commit()
+}
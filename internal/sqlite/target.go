@@ -0,0 +1,121 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+const targetColumns = `id, cidr, ports, proto, owner, environment, frequency, description, tenant, user, created`
+
+// scanTarget reads a target from a row of targetColumns.
+func scanTarget(row interface{ Scan(...interface{}) error }) (scan.Target, error) {
+	var t scan.Target
+	var created time.Time
+	err := row.Scan(&t.ID, &t.CIDR, &t.Ports, &t.Proto, &t.Owner, &t.Environment, &t.Frequency, &t.Description, &t.Tenant, &t.User, &created)
+	t.Created = scan.Time{Time: created}
+	return t, err
+}
+
+// LoadTargets retrieves the target inventory, oldest first.
+func (db *DB) LoadTargets() ([]scan.Target, error) {
+	rows, err := db.Query(`SELECT ` + targetColumns + ` FROM target ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []scan.Target{}
+	for rows.Next() {
+		t, err := scanTarget(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+
+	return targets, rows.Err()
+}
+
+// LoadTarget retrieves a target. It returns sql.ErrNoRows if there is no
+// such target.
+func (db *DB) LoadTarget(id int64) (scan.Target, error) {
+	return scanTarget(db.QueryRow(`SELECT `+targetColumns+` FROM target WHERE id = ?`, id))
+}
+
+// SaveTarget adds a target to the inventory, returning its ID.
+func (db *DB) SaveTarget(t scan.Target) (int64, error) {
+	qry := `INSERT INTO target (cidr, ports, proto, owner, environment, frequency, description, tenant, user, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := db.Exec(qry, t.CIDR, t.Ports, t.Proto, t.Owner, t.Environment, t.Frequency, t.Description, t.Tenant, t.User, t.Created.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// UpdateTarget replaces a target's details, keeping who added it and when and
+// its tenant.
+// It returns sql.ErrNoRows if there is no such target.
+func (db *DB) UpdateTarget(t scan.Target) error {
+	qry := `UPDATE target SET cidr = ?, ports = ?, proto = ?, owner = ?, environment = ?, frequency = ?, description = ? WHERE id = ?`
+	res, err := db.Exec(qry, t.CIDR, t.Ports, t.Proto, t.Owner, t.Environment, t.Frequency, t.Description, t.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteTarget removes a target from the inventory. Jobs queued for it are
+// kept. It returns sql.ErrNoRows if there is no such target.
+func (db *DB) DeleteTarget(id int64) error {
+	res, err := db.Exec(`DELETE FROM target WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TargetScans is how a target has been scanned by the jobs queued for it:
+// when results for one were last received, and how many are still waiting
+// for results.
+type TargetScans struct {
+	Last    time.Time
+	Pending int
+}
+
+// LoadTargetScans retrieves how each target with jobs queued for it has been
+// scanned, by target ID.
+func (db *DB) LoadTargetScans() (map[int64]TargetScans, error) {
+	// MAX() would lose the column type, so the time wouldn't be parsed
+	rows, err := db.Query(`SELECT target, received FROM job WHERE target IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scans := make(map[int64]TargetScans)
+	for rows.Next() {
+		var id int64
+		var received sql.NullTime
+		if err := rows.Scan(&id, &received); err != nil {
+			return nil, err
+		}
+		s := scans[id]
+		switch {
+		case !received.Valid:
+			s.Pending++
+		case received.Time.After(s.Last):
+			s.Last = received.Time
+		}
+		scans[id] = s
+	}
+
+	return scans, rows.Err()
+}
@@ -0,0 +1,154 @@
+package sqlite
+
+import (
+	"database/sql"
+	"net"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadTenants retrieves the tenants, ordered by name, with their CIDRs and
+// users.
+func (db *DB) LoadTenants() ([]scan.Tenant, error) {
+	rows, err := db.Query(`SELECT name, description, created FROM tenant ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []scan.Tenant{}
+	byName := make(map[string]int)
+	for rows.Next() {
+		t := scan.Tenant{CIDRs: []string{}, Users: []string{}}
+		var created time.Time
+		if err := rows.Scan(&t.Name, &t.Description, &created); err != nil {
+			return nil, err
+		}
+		t.Created = scan.Time{Time: created}
+		byName[t.Name] = len(tenants)
+		tenants = append(tenants, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT tenant, cidr FROM tenant_range ORDER BY tenant, first`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, cidr string
+		if err := rows.Scan(&name, &cidr); err != nil {
+			return nil, err
+		}
+		if i, ok := byName[name]; ok {
+			tenants[i].CIDRs = append(tenants[i].CIDRs, cidr)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT tenant, email FROM users WHERE tenant != '' ORDER BY email`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, email string
+		if err := rows.Scan(&name, &email); err != nil {
+			return nil, err
+		}
+		if i, ok := byName[name]; ok {
+			tenants[i].Users = append(tenants[i].Users, email)
+		}
+	}
+
+	return tenants, rows.Err()
+}
+
+// SaveTenant stores a tenant, replacing the description and CIDRs of any
+// existing tenant with the same name. Its users are set with SetUserTenant.
+// The CIDRs must be valid.
+func (db *DB) SaveTenant(t scan.Tenant) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT INTO tenant (name, description, created) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET description = excluded.description`
+	if _, err := txn.Exec(qry, t.Name, t.Description, t.Created.UTC()); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if _, err := txn.Exec(`DELETE FROM tenant_range WHERE tenant = ?`, t.Name); err != nil {
+		txn.Rollback()
+		return err
+	}
+	for _, cidr := range t.CIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+		first, last := scan.NetworkKeys(network)
+		_, err = txn.Exec(`INSERT OR IGNORE INTO tenant_range (tenant, cidr, first, last) VALUES (?, ?, ?, ?)`, t.Name, network.String(), first, last)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+// DeleteTenant removes a tenant and its users, who would otherwise see every
+// tenant's results. Its targets and jobs are kept. It returns sql.ErrNoRows
+// if there is no such tenant.
+func (db *DB) DeleteTenant(name string) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := txn.Exec(`DELETE FROM tenant WHERE name = ?`, name)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		txn.Rollback()
+		return sql.ErrNoRows
+	}
+	for _, qry := range []string{`DELETE FROM tenant_range WHERE tenant = ?`, `DELETE FROM users WHERE tenant = ?`} {
+		if _, err := txn.Exec(qry, name); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+// UserTenant returns the tenant a user belongs to, or "" if they don't
+// belong to one or aren't in the users table.
+func (db *DB) UserTenant(email string) (string, error) {
+	var tenant string
+	err := db.QueryRow(`SELECT tenant FROM users WHERE email = ?`, email).Scan(&tenant)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return tenant, err
+}
+
+// SetUserTenant adds a user to a tenant, adding them to the users table if
+// they aren't already, or takes them out of one if tenant is "".
+func (db *DB) SetUserTenant(email, tenant string) error {
+	qry := `INSERT INTO users (email, tenant) VALUES (?, ?)
+		ON CONFLICT (email) DO UPDATE SET tenant = excluded.tenant`
+	_, err := db.Exec(qry, email, tenant)
+	return err
+}
@@ -0,0 +1,194 @@
+package sqlite
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// submissionTimes retrieves the time of every full scan submission in
+// ascending order.
+func (db *DB) submissionTimes() ([]time.Time, error) {
+	rows, err := db.Query(`SELECT submission_time FROM submission WHERE ` + fullScan + ` ORDER BY submission_time`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var times []time.Time
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		times = append(times, t.UTC())
+	}
+	return times, rows.Err()
+}
+
+// LoadUptime estimates the availability window of each port matching filter.
+//
+// A port is assumed to have been open between its first and last
+// observations. If a later scan was submitted which didn't see the port, the
+// port is assumed to have closed by the time of that scan. Availability is the
+// fraction of scans since the port was first seen during which it was open.
+func (db *DB) LoadUptime(filter SQLFilter) ([]scan.Window, error) {
+	subs, err := db.submissionTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	qry := fmt.Sprintf(`SELECT ip, port, proto, firstseen, lastseen FROM scan %s ORDER BY ip, port, proto`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var windows []scan.Window
+	for rows.Next() {
+		var w scan.Window
+		var firstseen, lastseen time.Time
+		err := rows.Scan(&w.IP, &w.Port, &w.Proto, &firstseen, &lastseen)
+		if err != nil {
+			return nil, err
+		}
+		firstseen, lastseen = firstseen.UTC(), lastseen.UTC()
+		w.Open = scan.Time{Time: firstseen}
+		w.Close = scan.Time{Time: lastseen}
+
+		// Find the first scan after the port was last seen
+		i := sort.Search(len(subs), func(i int) bool { return subs[i].After(lastseen) })
+		if i < len(subs) {
+			w.ClosedBy = &scan.Time{Time: subs[i]}
+		}
+
+		w.Availability = availability(subs, firstseen, lastseen)
+		windows = append(windows, w)
+	}
+
+	return windows, rows.Err()
+}
+
+// availability calculates the fraction of scans between first and the most
+// recent scan which fall within first and last.
+func availability(subs []time.Time, first, last time.Time) float64 {
+	var seen, total int
+	for _, t := range subs {
+		if t.Before(first) {
+			continue
+		}
+		total++
+		if !t.After(last) {
+			seen++
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(seen) / float64(total)
+}
+
+// LoadTimelines retrieves the history of each port matching filter.
+//
+// Each observation of a port is counted towards the first scan submitted at
+// or after it, which is the scan that reported it. A scan which didn't report
+// the port closes the period it was open, and the next one to report it
+// starts another. Observations since the latest scan, such as from jobs, are
+// counted as a scan still to come. Each period lists the sources which saw
+// the port during it.
+func (db *DB) LoadTimelines(filter SQLFilter) ([]scan.Timeline, error) {
+	subs, err := db.submissionTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	qry := fmt.Sprintf(`SELECT ip, port, proto, seen, source FROM observation %s ORDER BY ip, port, proto, seen, source`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var timelines []scan.Timeline
+	var seen []observation
+	add := func() {
+		if len(timelines) > 0 {
+			timelines[len(timelines)-1].Periods = periods(subs, seen)
+		}
+		seen = nil
+	}
+	for rows.Next() {
+		var ip, proto string
+		var port int
+		var t time.Time
+		var source string
+		if err := rows.Scan(&ip, &port, &proto, &t, &source); err != nil {
+			return nil, err
+		}
+		if n := len(timelines); n == 0 || timelines[n-1].IP != ip || timelines[n-1].Port != port || timelines[n-1].Proto != proto {
+			add()
+			timelines = append(timelines, scan.Timeline{IP: ip, Port: port, Proto: proto})
+		}
+		seen = append(seen, observation{t.UTC(), source})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	add()
+
+	return timelines, nil
+}
+
+// observation is a time a port was seen and the source which saw it.
+type observation struct {
+	seen   time.Time
+	source string
+}
+
+// periods groups the observations of a port, in ascending order of time, into
+// runs of consecutive scans.
+func periods(subs []time.Time, seen []observation) []scan.Period {
+	var ps []scan.Period
+	var p *scan.Period
+	last := -1
+	addSource := func(source string) {
+		if source == "" {
+			return
+		}
+		for _, s := range p.Sources {
+			if s == source {
+				return
+			}
+		}
+		p.Sources = append(p.Sources, source)
+	}
+	for _, o := range seen {
+		t := o.seen
+		i := sort.Search(len(subs), func(i int) bool { return !subs[i].Before(t) })
+		switch {
+		case p != nil && i == last:
+			// Seen again by the same scan, or by another scanner
+			p.To = scan.Time{Time: t}
+			addSource(o.source)
+			continue
+		case p != nil && i == last+1:
+			p.To = scan.Time{Time: t}
+		default:
+			if p != nil {
+				p.ClosedBy = &scan.Time{Time: subs[last+1]}
+			}
+			ps = append(ps, scan.Period{From: scan.Time{Time: t}, To: scan.Time{Time: t}})
+			p = &ps[len(ps)-1]
+		}
+		addSource(o.source)
+		p.Scans++
+		last = i
+	}
+	if p != nil && last+1 < len(subs) {
+		p.ClosedBy = &scan.Time{Time: subs[last+1]}
+	}
+	return ps
+}
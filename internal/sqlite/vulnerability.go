@@ -0,0 +1,129 @@
+package sqlite
+
+import (
+	"fmt"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// severities are the CVSS severities, least severe first.
+var severities = []string{"LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// ValidSeverity reports whether s is a CVSS severity.
+func ValidSeverity(s string) bool {
+	for _, v := range severities {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// atLeast returns the severities at least as severe as s.
+func atLeast(s string) []interface{} {
+	var values []interface{}
+	for i, v := range severities {
+		if v == s {
+			for _, v := range severities[i:] {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// LoadVulnerabilities retrieves the vulnerabilities matching filter, ordered
+// by IP, port and protocol, most severe first.
+func (db *DB) LoadVulnerabilities(filter SQLFilter) ([]scan.Vulnerability, error) {
+	qry := fmt.Sprintf(`SELECT ip, port, proto, product, version, cve, severity, score, summary FROM vulnerability %s ORDER BY ip, port, proto, score DESC, cve`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vulns := []scan.Vulnerability{}
+	for rows.Next() {
+		var v scan.Vulnerability
+		if err := rows.Scan(&v.IP, &v.Port, &v.Proto, &v.Product, &v.Version, &v.CVE, &v.Severity, &v.Score, &v.Summary); err != nil {
+			return nil, err
+		}
+		vulns = append(vulns, v)
+	}
+
+	return vulns, rows.Err()
+}
+
+// portVulns summarises a port's vulnerabilities.
+type portVulns struct {
+	count    int
+	severity string
+	score    float64
+}
+
+// loadVulnMap retrieves how many vulnerabilities each port has, and the most
+// severe. SQLite takes the bare severity column from the row with the
+// highest score.
+func (db *DB) loadVulnMap() (map[ackKey]portVulns, error) {
+	rows, err := db.Query(`SELECT ip, port, proto, count(*), max(score), severity FROM vulnerability GROUP BY ip, port, proto`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	m := make(map[ackKey]portVulns)
+	for rows.Next() {
+		var k ackKey
+		var v portVulns
+		if err := rows.Scan(&k.ip, &k.port, &k.proto, &v.count, &v.score, &v.severity); err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, rows.Err()
+}
+
+// SaveVulnerabilities replaces every vulnerability stored with vulns.
+func (db *DB) SaveVulnerabilities(vulns []scan.Vulnerability) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := txn.Exec(`DELETE FROM vulnerability`); err != nil {
+		txn.Rollback()
+		return err
+	}
+	qry := `INSERT OR IGNORE INTO vulnerability (ip, port, proto, product, version, cve, severity, score, summary) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, v := range vulns {
+		_, err := txn.Exec(qry, v.IP, v.Port, v.Proto, v.Product, v.Version, v.CVE, v.Severity, v.Score, v.Summary)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
+
+// LoadCurrentBanners retrieves the latest banner of each service on each
+// port.
+func (db *DB) LoadCurrentBanners() ([]scan.Banner, error) {
+	rows, err := db.Query(`SELECT ip, port, proto, service, banner FROM banner b
+		WHERE lastseen = (SELECT max(lastseen) FROM banner WHERE ip = b.ip AND port = b.port AND proto = b.proto AND service = b.service)
+		ORDER BY ip, port, proto, service`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var banners []scan.Banner
+	for rows.Next() {
+		var b scan.Banner
+		if err := rows.Scan(&b.IP, &b.Port, &b.Proto, &b.Service, &b.Banner); err != nil {
+			return nil, err
+		}
+		banners = append(banners, b)
+	}
+	return banners, rows.Err()
+}
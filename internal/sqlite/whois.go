@@ -0,0 +1,85 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// LoadWhois retrieves the WHOIS data matching filter, ordered by IP.
+func (db *DB) LoadWhois(filter SQLFilter) ([]scan.Whois, error) {
+	qry := fmt.Sprintf(`SELECT ip, asn, as_name, prefix, netblock, first, last, owner, updated FROM whois %s ORDER BY ip`, filter)
+	rows, err := db.Query(qry, filter.Values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	whois := []scan.Whois{}
+	for rows.Next() {
+		var w scan.Whois
+		var updated time.Time
+		if err := rows.Scan(&w.IP, &w.ASN, &w.ASName, &w.Prefix, &w.Netblock, &w.First, &w.Last, &w.Owner, &updated); err != nil {
+			return nil, err
+		}
+		w.Updated = scan.Time{Time: updated}
+		whois = append(whois, w)
+	}
+
+	return whois, rows.Err()
+}
+
+// loadWhoisMap retrieves the WHOIS data for each IP.
+func (db *DB) loadWhoisMap() (map[string]*scan.Whois, error) {
+	whois, err := db.LoadWhois(SQLFilter{})
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]*scan.Whois, len(whois))
+	for i := range whois {
+		m[whois[i].IP] = &whois[i]
+	}
+	return m, nil
+}
+
+// LoadStaleWhoisIPs returns up to limit IPs in the results which haven't
+// been looked up in WHOIS since before, those never looked up first.
+func (db *DB) LoadStaleWhoisIPs(before time.Time, limit int) ([]string, error) {
+	qry := `SELECT s.ip FROM scan s LEFT JOIN whois w ON w.ip = s.ip WHERE w.ip IS NULL OR w.updated < ?
+		GROUP BY s.ip ORDER BY w.updated IS NOT NULL, w.updated LIMIT ?`
+	rows, err := db.Query(qry, before.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
+// SaveWhois stores WHOIS data, replacing any already stored for the same IPs.
+func (db *DB) SaveWhois(whois []scan.Whois) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	qry := `INSERT OR REPLACE INTO whois (ip, asn, as_name, prefix, netblock, first, last, owner, updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, w := range whois {
+		_, err := txn.Exec(qry, w.IP, w.ASN, w.ASName, w.Prefix, w.Netblock, w.First, w.Last, w.Owner, w.Updated.UTC())
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// internetDBBatch is the most IPs looked up in InternetDB each run.
+const internetDBBatch = 100
+
+// internetDBClient queries Shodan's InternetDB, which needs no API key, for
+// what the internet sees of our external IPs.
+type internetDBClient struct {
+	// url is the URL the IP is appended to
+	url     string
+	refresh time.Duration
+	client  *http.Client
+}
+
+func newInternetDBClient(baseURL string, refresh time.Duration) *internetDBClient {
+	return &internetDBClient{url: baseURL, refresh: refresh, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// query looks up ip. IPs InternetDB knows nothing about have an empty
+// record.
+func (c *internetDBClient) query(ip string) (scan.InternetDB, error) {
+	rec := scan.InternetDB{IP: ip}
+	res, err := c.client.Get(c.url + url.PathEscape(ip))
+	if err != nil {
+		return rec, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return rec, nil
+	default:
+		return rec, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&rec); err != nil {
+		return rec, err
+	}
+	rec.IP = ip
+	sort.Ints(rec.Ports)
+	return rec, nil
+}
+
+// enrichInternetDB looks up the external IPs never looked up, or last looked
+// up longer ago than the refresh interval. Private IPs are never looked up
+// as the internet can't see them. Failed lookups aren't saved, so they're
+// tried again on the next run.
+func (app *App) enrichInternetDB(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	ips, err := app.db.LoadStaleInternetDBIPs(now.Add(-app.internetDB.refresh))
+	if err != nil {
+		log.Println("internetdb: error loading IPs to look up:", err)
+		return
+	}
+	var records []scan.InternetDB
+	for _, ip := range ips {
+		if addr := net.ParseIP(ip); addr == nil || !isPublicIP(addr) {
+			continue
+		}
+		if len(records) == internetDBBatch {
+			break
+		}
+		rec, err := app.internetDB.query(ip)
+		if err != nil {
+			log.Printf("internetdb: error looking up %s: %v", ip, err)
+			continue
+		}
+		rec.Updated = scan.Time{Time: now.UTC()}
+		records = append(records, rec)
+	}
+	if err := app.db.SaveInternetDB(records); err != nil {
+		log.Println("internetdb: error saving lookups:", err)
+		return
+	}
+	if verbose && len(records) > 0 {
+		log.Printf("internetdb: looked up %d IPs", len(records))
+	}
+}
+
+// internetDBDiff compares what InternetDB sees of an IP with our own scans.
+// ShodanOnly are TCP ports InternetDB has seen open which we don't see open,
+// and ScanOnly those we see open which it hasn't.
+type internetDBDiff struct {
+	IP         string    `json:"ip"`
+	ShodanOnly []int     `json:"shodan_only"`
+	ScanOnly   []int     `json:"scan_only"`
+	Vulns      []string  `json:"vulns,omitempty"`
+	Updated    scan.Time `json:"updated"`
+}
+
+// diffInternetDB compares each InternetDB record with the open TCP ports in
+// results.
+func diffInternetDB(records []scan.InternetDB, results []scan.IPInfo) []internetDBDiff {
+	open := make(map[string]map[int]bool)
+	for _, r := range results {
+		if r.Proto != "tcp" || r.Gone {
+			continue
+		}
+		if open[r.IP] == nil {
+			open[r.IP] = make(map[int]bool)
+		}
+		open[r.IP][r.Port] = true
+	}
+
+	diffs := make([]internetDBDiff, 0, len(records))
+	for _, rec := range records {
+		d := internetDBDiff{IP: rec.IP, ShodanOnly: []int{}, ScanOnly: []int{}, Vulns: rec.Vulns, Updated: rec.Updated}
+		seen := make(map[int]bool, len(rec.Ports))
+		for _, p := range rec.Ports {
+			seen[p] = true
+			if !open[rec.IP][p] {
+				d.ShodanOnly = append(d.ShodanOnly, p)
+			}
+		}
+		for p := range open[rec.IP] {
+			if !seen[p] {
+				d.ScanOnly = append(d.ScanOnly, p)
+			}
+		}
+		sort.Ints(d.ScanOnly)
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// Handler for GET /api/v1/internetdb
+// This compares what InternetDB sees of each IP looked up with our scans.
+// Only IPs where they differ are included unless all=true.
+func (app *App) internetDBReport(w http.ResponseWriter, r *http.Request) {
+	records, err := app.db.LoadInternetDB(sqlite.SQLFilter{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, _, err := app.db.ResultPage(sqlite.ResultQuery{Proto: "tcp"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	all := r.URL.Query().Get("all") == "true"
+	diffs := []internetDBDiff{}
+	for _, d := range diffInternetDB(records, data.Results) {
+		if all || len(d.ShodanOnly) > 0 || len(d.ScanOnly) > 0 {
+			diffs = append(diffs, d)
+		}
+	}
+	render.JSON(w, r, diffs)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestInternetDB(t *testing.T) {
+	db := createDB("TestInternetDB")
+	defer db.Close()
+
+	var queried []string
+	shodan := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := strings.TrimPrefix(r.URL.Path, "/")
+		queried = append(queried, ip)
+		if ip != "192.0.2.1" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"detail":"No information available"}`))
+			return
+		}
+		w.Write([]byte(`{"cpes":[],"hostnames":["www.example.com"],"ip":"192.0.2.1","ports":[443,22],"tags":["cloud"],"vulns":["CVE-2018-15473"]}`))
+	}))
+	defer shodan.Close()
+
+	app := App{db: db, internetDB: newInternetDBClient(shodan.URL+"/", 24*time.Hour)}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 8080, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 53, Proto: "udp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "10.0.0.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	app.enrichInternetDB(now)
+	// Private IPs are never looked up
+	if !reflect.DeepEqual(queried, []string{"192.0.2.1", "198.51.100.1"}) {
+		t.Errorf("expected only external IPs looked up, got %v", queried)
+	}
+	records, err := db.LoadInternetDB(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %+v", records)
+	}
+	if r := records[0]; !reflect.DeepEqual(r.Ports, []int{22, 443}) || !reflect.DeepEqual(r.Vulns, []string{"CVE-2018-15473"}) || !reflect.DeepEqual(r.Tags, []string{"cloud"}) {
+		t.Errorf("unexpected record for 192.0.2.1: %+v", r)
+	}
+
+	queried = nil
+	app.enrichInternetDB(now.Add(time.Hour))
+	if len(queried) != 0 {
+		t.Errorf("expected no lookups before the refresh, got %v", queried)
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/internetdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diffs []internetDBDiff
+	err = json.NewDecoder(res.Body).Decode(&diffs)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 198.51.100.1 has 22/tcp open but InternetDB hasn't seen it
+	if len(diffs) != 2 {
+		t.Fatalf("expected 2 discrepancies, got %+v", diffs)
+	}
+	if d := diffs[0]; !reflect.DeepEqual(d.ShodanOnly, []int{443}) || !reflect.DeepEqual(d.ScanOnly, []int{8080}) {
+		t.Errorf("unexpected discrepancy for 192.0.2.1: %+v", d)
+	}
+	if d := diffs[1]; d.IP != "198.51.100.1" || len(d.ShodanOnly) != 0 || !reflect.DeepEqual(d.ScanOnly, []int{22}) {
+		t.Errorf("unexpected discrepancy for 198.51.100.1: %+v", d)
+	}
+
+	res, err = http.Get(ts.URL + "/host/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	for _, want := range []string{"not in our scans: 443/tcp", "not seen by Shodan: 8080/tcp", "CVE-2018-15473"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("expected %q on the host page", want)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
-	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -24,23 +24,13 @@ type jobData struct {
 func (app *App) newJob(w http.ResponseWriter, r *http.Request) {
 	var user User
 	if !authDisabled {
-		session, err := store.Get(r, "user")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if _, ok := session.Values["user"]; !ok {
+		u := currentUser(r)
+		if u == nil {
 			data := jobData{indexData: indexData{URI: r.RequestURI}}
 			tmpl.ExecuteTemplate(w, "job", data)
 			return
 		}
-		v := session.Values["user"]
-		switch v := v.(type) {
-		case string:
-			user.Email = v
-		case User:
-			user = v
-		}
+		user = *u
 	}
 
 	var jobID []string
@@ -57,9 +47,25 @@ func (app *App) newJob(w http.ResponseWriter, r *http.Request) {
 		cidr := f.Get("cidr")
 		ports := f.Get("ports")
 		proto := f["proto"]
+		agent := f.Get("agent")
+		var rate int
+		if s := f.Get("rate"); s != "" {
+			rate, err = strconv.Atoi(s)
+			if err != nil || rate < 0 {
+				http.Error(w, "Invalid rate", http.StatusBadRequest)
+				return
+			}
+		}
+		if agent != "" && !agentName.MatchString(agent) {
+			http.Error(w, "Invalid agent name", http.StatusBadRequest)
+			return
+		}
 
 		if cidr == "" {
 			errors = append(errors, "CIDR")
+		} else if status, err := app.checkTenantCIDR(user.Tenant, cidr); err != nil {
+			http.Error(w, err.Error(), status)
+			return
 		}
 		if ports == "" {
 			errors = append(errors, "Ports")
@@ -72,33 +78,45 @@ func (app *App) newJob(w http.ResponseWriter, r *http.Request) {
 		// Multiple protocols can be submitted. These are saved as separate jobs.
 		if len(errors) == 0 {
 			for i := range proto {
-				id, err := app.db.SaveJob(cidr, ports, proto[i], user.Email)
+				job := scan.Job{
+					CIDR: cidr, Ports: ports, Proto: proto[i], Rate: rate, Agent: agent,
+					Tenant: user.Tenant, RequestedBy: user.Email,
+				}
+				id, err := app.db.QueueJob(job)
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 					return
 				}
+				job.ID = int(id)
+				app.auditJob(&user, "add_job", job)
 				jobID = append(jobID, strconv.FormatInt(id, 10))
 			}
 		}
 	}
 
-	jobs, err := app.db.LoadJobs(sqlite.SQLFilter{})
+	jobs, err := app.db.LoadJobs(tenantJobs(user.Tenant))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	sub, err := app.db.LoadJobSubmission()
-	if err != nil {
-		log.Println("newJob: couldn't load submissions:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	// Submissions and result numbers span tenants, so are only shown to
+	// operators
+	var sub scan.Submission
+	var results scan.Data
+	if user.Tenant == "" {
+		sub, err = app.db.LoadJobSubmission()
+		if err != nil {
+			log.Println("newJob: couldn't load submissions:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-	// Fetch result numbers for display in the navbar
-	// Errors aren't fatal here, we can just display 0 results if something
-	// goes wrong
-	results, _ := app.db.ResultData("", "", "")
+		// Fetch result numbers for display in the navbar
+		// Errors aren't fatal here, we can just display 0 results if
+		// something goes wrong
+		results, _ = app.db.ResultData("", "", "")
+	}
 
 	data := jobData{
 		indexData: indexData{
@@ -116,17 +134,113 @@ func (app *App) newJob(w http.ResponseWriter, r *http.Request) {
 	tmpl.ExecuteTemplate(w, "job", data)
 }
 
+// auditJob records a change to a job in the audit log.
+func (app *App) auditJob(user *User, event string, j scan.Job) {
+	info, _ := json.Marshal(j)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditJob: error saving %s for job %d: %v", event, j.ID, err)
+	}
+}
+
 // Handler for GET /jobs
+//
+// Jobs for a particular agent, and jobs an agent is running, are left to
+// agents to claim.
 func (app *App) jobs(w http.ResponseWriter, r *http.Request) {
-	jobs, err := app.db.LoadJobs(sqlite.SQLFilter{
-		Where: []string{"received IS NULL"},
-	})
+	now := time.Now()
+	filter := tenantJobs(requestTenant(r))
+	filter.Where = append(filter.Where, "received IS NULL", "agent = ''", "(claimed IS NULL OR claimed < ?)")
+	filter.Values = append(filter.Values, now.Add(-app.jobTimeout).UTC())
+	jobs, err := app.db.LoadJobs(filter)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		render.JSON(w, r, err.Error())
+		return
+	}
+	windows, err := app.loadScanWindows()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, err.Error())
+		return
+	}
+
+	// Jobs for networks outside their scan window wait until it opens
+	space := newAddressSpace(requestCIDRs(r))
+	allowed := make([]scan.Job, 0, len(jobs))
+	for _, job := range jobs {
+		if windows.closed(now, job.CIDR) == "" && (space == nil || space.contains(job.CIDR)) {
+			allowed = append(allowed, job)
+		}
 	}
 
-	render.JSON(w, r, jobs)
+	render.JSON(w, r, allowed)
+}
+
+// tenantJobs filters jobs to those of a tenant, or all of them for
+// operators.
+func tenantJobs(tenant string) sqlite.SQLFilter {
+	if tenant == "" {
+		return sqlite.SQLFilter{}
+	}
+	return sqlite.SQLFilter{Where: []string{"tenant = ?"}, Values: []interface{}{tenant}}
+}
+
+// unclaimableJobs returns the IDs of the jobs waiting for results which an
+// agent of tenant may not claim at now: those of other tenants, those outside
+// the space of an API token restricted to CIDRs, and those covering a network
+// outside its scan window or in a blackout.
+func (app *App) unclaimableJobs(now time.Time, tenant string, space addressSpace) ([]int64, error) {
+	windows, err := app.loadScanWindows()
+	if err != nil || (len(windows) == 0 && tenant == "" && space == nil) {
+		return nil, err
+	}
+	jobs, err := app.db.LoadJobs(sqlite.SQLFilter{Where: []string{"received IS NULL"}})
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for _, job := range jobs {
+		if (tenant != "" && job.Tenant != tenant) || (space != nil && !space.contains(job.CIDR)) || windows.closed(now, job.CIDR) != "" {
+			ids = append(ids, int64(job.ID))
+		}
+	}
+	return ids, nil
+}
+
+// Handler for POST /jobs/claim
+//
+// Gives the agent named in the X-Agent header the next job waiting for
+// results, responding with no content if there isn't one. Jobs covering a
+// network outside its scan window wait until it opens. Agents authenticating
+// as a tenant's user only get that tenant's jobs, and those with an API token
+// restricted to CIDRs only jobs within them. The agent submits
+// the results to /results/{id}. If it hasn't within the job timeout, the job
+// can be claimed again.
+func (app *App) claimJob(w http.ResponseWriter, r *http.Request) {
+	agent := r.Header.Get("X-Agent")
+	if !agentName.MatchString(agent) {
+		http.Error(w, "Invalid agent name", http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC()
+	skip, err := app.unclaimableJobs(now, requestTenant(r), newAddressSpace(requestCIDRs(r)))
+	if err != nil {
+		log.Println("claimJob: error loading jobs:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	job, ok, err := app.db.ClaimJob(agent, now, now.Add(-app.jobTimeout), skip...)
+	if err = dbError("claim_job", err); err != nil {
+		log.Println("claimJob: error claiming job:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	log.Printf("claimJob: job %d claimed by %s", job.ID, agent)
+	render.JSON(w, r, job)
 }
 
 // Handler for PUT /results/{id}
@@ -142,7 +256,8 @@ func (app *App) recvJobResults(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if len(jobs) == 0 {
+	space := newAddressSpace(requestCIDRs(r))
+	if len(jobs) == 0 || (requestTenant(r) != "" && jobs[0].Tenant != requestTenant(r)) || (space != nil && !space.contains(jobs[0].CIDR)) {
 		http.Error(w, "Job does not exist", http.StatusBadRequest)
 		return
 	}
@@ -151,34 +266,60 @@ func (app *App) recvJobResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if app.quota.paused() {
+		http.Error(w, "Database is over its quota", http.StatusInsufficientStorage)
+		return
+	}
+	run, err := runMetadata(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := headerScanTime(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	now := time.Now().UTC()
 
 	// Insert the results as normal
-	count, err := app.saveResults(w, r, now)
+	saved, err := app.saveResults(w, r, now)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Update the job
-	err = app.db.UpdateJob(job, count)
+	err = dbError("update_job", app.db.UpdateJob(job, saved.count))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		ip = r.RemoteAddr
-	}
+	ip := remoteIP(r)
 	id, _ := strconv.ParseInt(job, 10, 64)
 
-	err = app.db.SaveSubmission(ip, &id, now)
+	counterSubmissions.WithLabelValues("job").Inc()
+	agent := app.requestAgent(r)
+	app.auditIngest(r, ingestAudit{Host: ip, Agent: agent, Job: id, Ports: saved.count})
+	run.Started = saved.started
+	err = dbError("save_submission", app.db.SaveSubmission(ip, &id, now, run))
 	if err != nil {
 		log.Println("recvJobResults: error saving submission:", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if saved.payload != nil {
+		if err := dbError("save_payload", app.db.SavePayload(ip, &id, now, saved.payload)); err != nil {
+			log.Println("recvJobResults: error archiving payload:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	app.agentScan(agent, now)
+	app.notifyChanges(now, saved.added, &id)
+	app.nuclei.enqueue(saved.added)
 
 	// Finally, update metrics
 	gaugeJobSubmission.Set(float64(now.Unix()))
@@ -186,5 +327,5 @@ func (app *App) recvJobResults(w http.ResponseWriter, r *http.Request) {
 		"id":        strconv.FormatInt(id, 10),
 		"submitted": strconv.FormatInt(time.Now().Unix(), 10),
 		"received":  strconv.FormatInt(time.Now().Unix(), 10),
-	}).Set(float64(count))
+	}).Set(float64(saved.count))
 }
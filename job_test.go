@@ -2,14 +2,17 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
 )
 
 func TestLoadJobsWithNoResults(t *testing.T) {
@@ -137,3 +140,177 @@ func TestJobResultsHandler(t *testing.T) {
 		t.Errorf("expected status 400, got %v", resp.StatusCode)
 	}
 }
+
+func TestClaimJob(t *testing.T) {
+	db := createDB("TestClaimJob")
+	defer db.Close()
+	app := App{db: db, jobTimeout: time.Hour}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	for _, job := range []scan.Job{
+		{CIDR: "192.0.2.0/24", Ports: "22", Proto: "tcp", Agent: "scanner2"},
+		{CIDR: "198.51.100.0/24", Ports: "53", Proto: "udp", Rate: 500},
+	} {
+		if _, err := db.QueueJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claim := func(agent string) (int, scan.Job) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", ts.URL+"/jobs/claim", nil)
+		req.Header.Set("X-Agent", agent)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var job scan.Job
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, job
+	}
+
+	// Agents only get jobs for them or for anyone, and each job once
+	if code, job := claim("scanner1"); code != http.StatusOK || job.ID != 2 || job.Rate != 500 || job.Proto != "udp" {
+		t.Errorf("expected job 2, got %d %+v", code, job)
+	}
+	if code, _ := claim("scanner1"); code != http.StatusNoContent {
+		t.Errorf("expected no job left for scanner1, got %d", code)
+	}
+	if code, job := claim("scanner2"); code != http.StatusOK || job.ID != 1 {
+		t.Errorf("expected job 1, got %d %+v", code, job)
+	}
+	if code, _ := claim("bad name"); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid agent name, got %d", code)
+	}
+
+	// Claimed jobs aren't listed for other scanners
+	resp, err := http.Get(ts.URL + "/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []scan.Job
+	json.NewDecoder(resp.Body).Decode(&listed)
+	resp.Body.Close()
+	if len(listed) != 0 {
+		t.Errorf("expected no jobs listed, got %+v", listed)
+	}
+
+	// A claim which has timed out can be taken by another agent
+	if _, ok, err := db.ClaimJob("scanner3", time.Now(), time.Now().Add(time.Minute)); err != nil || !ok {
+		t.Errorf("expected to claim a timed out job, got %v, %v", ok, err)
+	}
+
+	// Results are recorded against the job and the agent, if it's registered
+	// where they came from
+	if err := db.SaveAgentHeartbeat(scan.Agent{Name: "scanner1", Addr: "127.0.0.1"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("PUT", ts.URL+"/results/2", strings.NewReader(`[{"ip":"198.51.100.1","ports":[{"port":53,"proto":"udp","status":"open"}]}]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Agent", "scanner1")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	jobs, err := db.LoadJobs(sqlite.SQLFilter{Where: []string{"rowid=?"}, Values: []interface{}{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Received.IsZero() || jobs[0].Count != 1 {
+		t.Errorf("expected job 2 received, got %+v", jobs)
+	}
+	agents, err := db.LoadAgents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 1 || agents[0].Name != "scanner1" || agents[0].LastScan == nil {
+		t.Errorf("expected scanner1's scan recorded, got %+v", agents)
+	}
+}
+
+func TestJobCovers(t *testing.T) {
+	tests := []struct {
+		job   scan.Job
+		ip    string
+		port  int
+		proto string
+		want  bool
+	}{
+		{scan.Job{CIDR: "192.0.2.0/24", Ports: "22,80,8000-9000", Proto: "tcp"}, "192.0.2.10", 8080, "tcp", true},
+		{scan.Job{CIDR: "192.0.2.0/24", Ports: "22,80,8000-9000", Proto: "tcp"}, "192.0.2.10", 443, "tcp", false},
+		{scan.Job{CIDR: "192.0.2.0/24", Ports: "22,80,8000-9000", Proto: "tcp"}, "192.0.2.10", 22, "udp", false},
+		{scan.Job{CIDR: "192.0.2.0/24", Ports: "22", Proto: "TCP"}, "198.51.100.1", 22, "tcp", false},
+		{scan.Job{CIDR: "192.0.2.1", Ports: "22", Proto: "tcp"}, "192.0.2.1", 22, "tcp", true},
+		{scan.Job{CIDR: "192.0.2.1", Ports: "22", Proto: "tcp"}, "192.0.2.2", 22, "tcp", false},
+		{scan.Job{CIDR: "2001:db8::/64", Ports: "1-1024", Proto: "tcp"}, "2001:db8::1", 443, "tcp", true},
+		{scan.Job{CIDR: "invalid", Ports: "22", Proto: "tcp"}, "192.0.2.1", 22, "tcp", false},
+	}
+	for _, tt := range tests {
+		if got := tt.job.Covers(tt.ip, tt.port, tt.proto); got != tt.want {
+			t.Errorf("%+v covers %s:%d/%s: expected %v, got %v", tt.job, tt.ip, tt.port, tt.proto, tt.want, got)
+		}
+	}
+}
+
+func TestLoadClosedBy(t *testing.T) {
+	db := createDB("TestLoadClosedBy")
+	defer db.Close()
+
+	first := time.Now().UTC().Truncate(time.Second)
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, first, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A job for port 22 in 192.0.2.0/24 sees nothing, so only closes
+	// 192.0.2.1:22
+	id, err := db.SaveJob("192.0.2.0/24", "22", "tcp", "testuser@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := first.Add(time.Hour)
+	if err := db.SaveSubmission("192.0.2.100", &id, second, scan.Run{}); err != nil {
+		t.Fatal(err)
+	}
+	closed, err := db.LoadClosedBy(second, &id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(closed) != 1 || closed[0].IP != "192.0.2.1" || closed[0].Port != 22 {
+		t.Errorf("expected only 192.0.2.1:22 to be closed by the job, got %+v", closed)
+	}
+
+	// A full scan which only sees port 80 closes the rest, except the port
+	// the job already closed
+	third := second.Add(time.Hour)
+	if _, _, err := db.SaveData(results[1:2], third); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, third, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+	closed, err = db.LoadClosedBy(third, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(closed) != 1 || closed[0].IP != "198.51.100.1" {
+		t.Errorf("expected only 198.51.100.1:22 to be closed by the full scan, got %+v", closed)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/base32"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// kvStore keeps short-lived state which replicas need to share: cached
+// responses, rate limit counters and, with Redis, login sessions. Every key
+// expires.
+type kvStore interface {
+	// get returns the value of key, or nil if it isn't set.
+	get(key string) ([]byte, error)
+	set(key string, value []byte, ttl time.Duration) error
+	del(key string) error
+	// incr increments the counter at key and returns its new value. A new
+	// counter expires after ttl.
+	incr(key string, ttl time.Duration) (int64, error)
+	// ping reports whether the store is reachable.
+	ping() error
+}
+
+// memStore is a kvStore for a single replica, kept in memory.
+type memStore struct {
+	mu      sync.Mutex
+	entries map[string]memEntry
+	swept   time.Time
+}
+
+type memEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func newMemStore() *memStore {
+	return &memStore{entries: make(map[string]memEntry), swept: time.Now()}
+}
+
+// lookup returns the entry at key if it hasn't expired. The caller must hold
+// s.mu.
+func (s *memStore) lookup(key string, now time.Time) (memEntry, bool) {
+	e, ok := s.entries[key]
+	if ok && !now.Before(e.expires) {
+		delete(s.entries, key)
+		return memEntry{}, false
+	}
+	return e, ok
+}
+
+// sweep removes expired entries at most once a minute, so keys which are
+// never read again don't build up. The caller must hold s.mu.
+func (s *memStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	for k, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	s.swept = now
+}
+
+func (s *memStore) get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, _ := s.lookup(key, time.Now())
+	return e.value, nil
+}
+
+func (s *memStore) set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	s.entries[key] = memEntry{value: value, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *memStore) del(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memStore) incr(key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	e, ok := s.lookup(key, now)
+	if !ok {
+		e.expires = now.Add(ttl)
+	}
+	n, _ := strconv.ParseInt(string(e.value), 10, 64)
+	n++
+	e.value = strconv.AppendInt(nil, n, 10)
+	s.entries[key] = e
+	return n, nil
+}
+
+func (s *memStore) ping() error { return nil }
+
+// kvSessionStore keeps login sessions in a kvStore, so they can be revoked
+// and are shared by replicas using the same Redis. The cookie only holds the
+// session's ID, signed like the cookie store's sessions.
+type kvSessionStore struct {
+	kv      kvStore
+	codecs  []securecookie.Codec
+	options *sessions.Options
+}
+
+func newKVSessionStore(kv kvStore, keyPairs ...[]byte) *kvSessionStore {
+	s := &kvSessionStore{
+		kv:      kv,
+		codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		options: &sessions.Options{Path: "/", MaxAge: 86400 * 30},
+	}
+	for _, c := range s.codecs {
+		if sc, ok := c.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(s.options.MaxAge)
+		}
+	}
+	return s
+}
+
+func (s *kvSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session named in the request's cookie, or a new one if
+// there isn't one or it has expired.
+func (s *kvSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.codecs...); err != nil {
+		return session, err
+	}
+	b, err := s.kv.get(s.key(session))
+	if err != nil || b == nil {
+		// A new ID is issued rather than reusing an expired one
+		session.ID = ""
+		return session, err
+	}
+	if err := securecookie.DecodeMulti(name, string(b), &session.Values, s.codecs...); err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save stores the session, or deletes it if its MaxAge is zero or less.
+func (s *kvSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.kv.del(s.key(session)); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+	values, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(session.Options.MaxAge) * time.Second
+	if err := s.kv.set(s.key(session), []byte(values), ttl); err != nil {
+		return err
+	}
+	id, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), id, session.Options))
+	return nil
+}
+
+func (s *kvSessionStore) key(session *sessions.Session) string {
+	return "session:" + session.Name() + ":" + session.ID
+}
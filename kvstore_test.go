@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemStore(t *testing.T) {
+	s := newMemStore()
+
+	if err := s.set("a", []byte("1"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.set("b", []byte("2"), -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := s.get("a"); string(b) != "1" {
+		t.Errorf("expected a = 1, got %q", b)
+	}
+	if b, _ := s.get("b"); b != nil {
+		t.Errorf("expected b expired, got %q", b)
+	}
+	s.del("a")
+	if b, _ := s.get("a"); b != nil {
+		t.Errorf("expected a deleted, got %q", b)
+	}
+
+	for want := int64(1); want <= 3; want++ {
+		if n, err := s.incr("n", time.Hour); err != nil || n != want {
+			t.Errorf("expected %d, got %d %v", want, n, err)
+		}
+	}
+	// An expired counter starts again
+	s.entries["n"] = memEntry{value: []byte("3"), expires: time.Now()}
+	if n, _ := s.incr("n", time.Hour); n != 1 {
+		t.Errorf("expected the expired counter reset, got %d", n)
+	}
+}
+
+func TestKVSessionStore(t *testing.T) {
+	kv := newMemStore()
+	s := newKVSessionStore(kv, []byte("test"))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	session, err := s.New(r, "user")
+	if err != nil || !session.IsNew {
+		t.Fatalf("expected a new session, got %+v %v", session, err)
+	}
+	session.Values["user"] = User{Email: "user@example.com"}
+	w := httptest.NewRecorder()
+	if err := s.Save(r, w, session); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+	if len(kv.entries) != 1 {
+		t.Fatalf("expected the session saved, got %v", kv.entries)
+	}
+
+	load := func() (User, bool) {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		session, err := s.New(r, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, _ := session.Values["user"].(User)
+		return user, session.IsNew
+	}
+	if user, isNew := load(); isNew || user.Email != "user@example.com" {
+		t.Errorf("expected the saved session, got %+v", user)
+	}
+
+	// Logging out deletes the session, so the cookie is no use
+	session.Options.MaxAge = -1
+	if err := s.Save(r, httptest.NewRecorder(), session); err != nil {
+		t.Fatal(err)
+	}
+	if user, isNew := load(); !isNew || user.Email != "" {
+		t.Errorf("expected a new session after logging out, got %+v", user)
+	}
+
+	// A cookie signed with another key is rejected
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "user", Value: cookie.Value})
+	if _, err := newKVSessionStore(kv, []byte("other")).New(r, "user"); err == nil {
+		t.Error("expected an error for a cookie signed with another key")
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// This is a minimal LDAPv3 client, supporting only what's needed to log in:
+// StartTLS, a simple bind and equality or presence searches.
+
+// BER tags used in LDAP messages
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berBoolean     = 0x01
+
+	ldapBindRequest  = 0x60
+	ldapBindResponse = 0x61
+	ldapUnbind       = 0x42
+	ldapSearch       = 0x63
+	ldapSearchEntry  = 0x64
+	ldapSearchDone   = 0x65
+	ldapSearchRef    = 0x73
+	ldapExtended     = 0x77
+	ldapExtendedResp = 0x78
+
+	ldapSimpleAuth     = 0x80
+	ldapRequestName    = 0x80
+	ldapFilterEquality = 0xa3
+	ldapFilterPresent  = 0x87
+)
+
+// ldapStartTLS is the OID of the StartTLS extended operation.
+const ldapStartTLS = "1.3.6.1.4.1.1466.20037"
+
+// LDAP search scopes
+const (
+	ldapScopeBase    = 0
+	ldapScopeSubtree = 2
+)
+
+// errLDAPInvalidCredentials is returned when a bind is rejected because the
+// DN or password is wrong.
+var errLDAPInvalidCredentials = errors.New("invalid credentials")
+
+// berPacket is a decoded BER element. Constructed elements have children
+// instead of a value.
+type berPacket struct {
+	tag      byte
+	value    []byte
+	children []berPacket
+}
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func berEncode(tag byte, content ...[]byte) []byte {
+	var body []byte
+	for _, c := range content {
+		body = append(body, c...)
+	}
+	b := append([]byte{tag}, berLength(len(body))...)
+	return append(b, body...)
+}
+
+func berInt(tag byte, n int) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return berEncode(tag, b)
+}
+
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+// berDecodeLength returns the header size and content length of an element.
+func berDecodeLength(b []byte) (int, int, error) {
+	if len(b) < 2 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	n := int(b[1])
+	if n&0x80 == 0 {
+		return 2, n, nil
+	}
+	size := n & 0x7f
+	if size == 0 || size > 4 {
+		return 0, 0, errors.New("ldap: invalid length")
+	}
+	if len(b) < 2+size {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	n = 0
+	for _, c := range b[2 : 2+size] {
+		n = n<<8 | int(c)
+	}
+	return 2 + size, n, nil
+}
+
+// berDecode parses a single BER element, returning it and the rest of b.
+func berDecode(b []byte) (berPacket, []byte, error) {
+	hdr, n, err := berDecodeLength(b)
+	if err != nil {
+		return berPacket{}, nil, err
+	}
+	if len(b) < hdr+n {
+		return berPacket{}, nil, io.ErrUnexpectedEOF
+	}
+	p := berPacket{tag: b[0]}
+	content, rest := b[hdr:hdr+n], b[hdr+n:]
+	if p.tag&0x20 == 0 {
+		p.value = content
+		return p, rest, nil
+	}
+	for len(content) > 0 {
+		child, r, err := berDecode(content)
+		if err != nil {
+			return p, nil, err
+		}
+		p.children = append(p.children, child)
+		content = r
+	}
+	return p, rest, nil
+}
+
+func (p berPacket) int() int {
+	n := 0
+	for _, c := range p.value {
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+// ldapConn is a connection to an LDAP server.
+type ldapConn struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	msgID int
+}
+
+// dial connects to the server. Unless l.insecure is set an ldap:// URL is
+// upgraded with StartTLS, so passwords are never sent in the clear.
+func (l ldapAuth) dial() (*ldapConn, error) {
+	u, err := url.Parse(l.url)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{}
+	if l.tls != nil {
+		config = l.tls.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	d := &net.Dialer{Timeout: l.timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = d.Dial("tcp", host)
+	case "ldaps":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(d, "tcp", host, config)
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(l.timeout))
+	c := &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+	if u.Scheme == "ldap" && !l.insecure {
+		if err := c.startTLS(config); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// startTLS upgrades the connection to TLS.
+func (c *ldapConn) startTLS(config *tls.Config) error {
+	if err := c.send(berEncode(ldapExtended, berString(ldapRequestName, ldapStartTLS))); err != nil {
+		return err
+	}
+	op, err := c.read()
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapExtendedResp {
+		return fmt.Errorf("ldap: unexpected response %#x to StartTLS", op.tag)
+	}
+	if err := ldapResult(op); err != nil {
+		return fmt.Errorf("ldap: StartTLS: %v", err)
+	}
+	conn := tls.Client(c.conn, config)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	return nil
+}
+
+func (c *ldapConn) Close() error {
+	c.send(berEncode(ldapUnbind))
+	return c.conn.Close()
+}
+
+func (c *ldapConn) send(op []byte) error {
+	c.msgID++
+	_, err := c.conn.Write(berEncode(berSequence, berInt(berInteger, c.msgID), op))
+	return err
+}
+
+// read reads the next message and returns its protocol operation.
+func (c *ldapConn) read() (berPacket, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, hdr); err != nil {
+		return berPacket{}, err
+	}
+	if size := int(hdr[1]); size&0x80 != 0 {
+		ext := make([]byte, size&0x7f)
+		if _, err := io.ReadFull(c.r, ext); err != nil {
+			return berPacket{}, err
+		}
+		hdr = append(hdr, ext...)
+	}
+	_, n, err := berDecodeLength(hdr)
+	if err != nil {
+		return berPacket{}, err
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return berPacket{}, err
+	}
+	msg, _, err := berDecode(append(hdr, body...))
+	if err != nil {
+		return berPacket{}, err
+	}
+	if len(msg.children) < 2 {
+		return berPacket{}, errors.New("ldap: invalid message")
+	}
+	return msg.children[1], nil
+}
+
+// ldapResult checks an LDAPResult, returning an error if it isn't success.
+func ldapResult(op berPacket) error {
+	if len(op.children) < 3 {
+		return errors.New("ldap: invalid result")
+	}
+	switch code := op.children[0].int(); code {
+	case 0:
+		return nil
+	case 49:
+		return errLDAPInvalidCredentials
+	default:
+		return fmt.Errorf("ldap: result code %d: %s", code, op.children[2].value)
+	}
+}
+
+// bind authenticates as dn with a password.
+func (c *ldapConn) bind(dn, password string) error {
+	err := c.send(berEncode(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(ldapSimpleAuth, password),
+	))
+	if err != nil {
+		return err
+	}
+	op, err := c.read()
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapBindResponse {
+		return fmt.Errorf("ldap: unexpected response %#x to bind", op.tag)
+	}
+	return ldapResult(op)
+}
+
+// ldapEntry is an entry returned by a search.
+type ldapEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+// search returns the entries under base matching filter, with the given
+// attributes.
+func (c *ldapConn) search(base string, scope int, filter []byte, attrs ...string) ([]ldapEntry, error) {
+	var attrList [][]byte
+	for _, a := range attrs {
+		attrList = append(attrList, berString(berOctetString, a))
+	}
+	err := c.send(berEncode(ldapSearch,
+		berString(berOctetString, base),
+		berInt(berEnumerated, scope),
+		berInt(berEnumerated, 0),         // never dereference aliases
+		berInt(berInteger, 0),            // no size limit
+		berInt(berInteger, 0),            // no time limit
+		berEncode(berBoolean, []byte{0}), // return values, not just types
+		filter,
+		berEncode(berSequence, attrList...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for {
+		op, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case ldapSearchEntry:
+			if len(op.children) < 2 {
+				return nil, errors.New("ldap: invalid search entry")
+			}
+			e := ldapEntry{dn: string(op.children[0].value), attrs: make(map[string][]string)}
+			for _, attr := range op.children[1].children {
+				if len(attr.children) < 2 {
+					continue
+				}
+				name := strings.ToLower(string(attr.children[0].value))
+				for _, v := range attr.children[1].children {
+					e.attrs[name] = append(e.attrs[name], string(v.value))
+				}
+			}
+			entries = append(entries, e)
+		case ldapSearchRef:
+			// Referrals to other servers aren't followed
+		case ldapSearchDone:
+			return entries, ldapResult(op)
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response %#x to search", op.tag)
+		}
+	}
+}
+
+// ldapEqual is a filter matching entries where attr equals value.
+func ldapEqual(attr, value string) []byte {
+	return berEncode(ldapFilterEquality, berString(berOctetString, attr), berString(berOctetString, value))
+}
+
+// ldapPresent is a filter matching entries which have attr.
+func ldapPresent(attr string) []byte {
+	return berString(ldapFilterPresent, attr)
+}
+
+// escapeDN escapes the characters which are special in a DN attribute value.
+func escapeDN(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, c),
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(s)-1 && c == ' ':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// ldapAuth logs users in by binding to an LDAP directory, such as Active
+// Directory, as them.
+type ldapAuth struct {
+	url       string
+	userDN    string // DN of a user, with %s replaced by their username
+	groupBase string // base DN to search for the user's groups
+	timeout   time.Duration
+	insecure  bool        // bind over ldap:// without StartTLS
+	tls       *tls.Config // nil to verify the server against the system roots
+}
+
+// login binds as the user, returning their details and the DNs of the groups
+// they're a member of.
+func (l ldapAuth) login(username, password string) (*User, []string, error) {
+	// A bind with an empty password is unauthenticated, and succeeds for
+	// any DN
+	if username == "" || password == "" {
+		return nil, nil, errLDAPInvalidCredentials
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	dn := fmt.Sprintf(l.userDN, escapeDN(username))
+	if err := conn.bind(dn, password); err != nil {
+		return nil, nil, err
+	}
+
+	user := &User{Email: username}
+	entries, err := conn.search(dn, ldapScopeBase, ldapPresent("objectClass"), "mail", "cn", "givenName", "sn")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(entries) > 0 {
+		attr := func(name string) string {
+			if v := entries[0].attrs[strings.ToLower(name)]; len(v) > 0 {
+				return v[0]
+			}
+			return ""
+		}
+		if mail := attr("mail"); mail != "" {
+			user.Email = mail
+		}
+		user.Name = attr("cn")
+		user.GivenName = attr("givenName")
+		user.FamilyName = attr("sn")
+	}
+
+	var groups []string
+	if l.groupBase != "" {
+		// "1.1" requests no attributes, only the DNs
+		entries, err := conn.search(l.groupBase, ldapScopeSubtree, ldapEqual("member", dn), "1.1")
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, e := range entries {
+			groups = append(groups, e.dn)
+		}
+	}
+
+	return user, groups, nil
+}
+
+// localRedirect returns u if it's a path on this site, otherwise "/", so the
+// login form can't be used to redirect elsewhere.
+func localRedirect(u string) string {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") || strings.HasPrefix(u, "/\\") {
+		return "/"
+	}
+	return u
+}
+
+// Handler for GET and POST /login when using LDAP
+func (app *App) ldapLoginHandler(w http.ResponseWriter, r *http.Request) {
+	data := indexData{URI: r.URL.Query().Get("redir")}
+
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data.URI = r.Form.Get("redir")
+
+		user, groups, err := app.ldap.login(r.Form.Get("username"), r.Form.Get("password"))
+		switch {
+		case err == errLDAPInvalidCredentials:
+			data.NotAuth = "Invalid username or password"
+			w.WriteHeader(http.StatusUnauthorized)
+		case err != nil:
+			log.Printf("ldapLoginHandler: error logging in %q: %v", r.Form.Get("username"), err)
+			data.NotAuth = "Unable to contact the directory"
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			authorised, err := app.authorised(user, groups)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !authorised {
+				data.NotAuth = fmt.Sprintf("%s is not authorised", user.Email)
+				w.WriteHeader(http.StatusForbidden)
+				break
+			}
+
+			session, err := store.Get(r, "user")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			session.Values["user"] = user
+			session.Save(r, w)
+			app.audit(user.Email, "login", "")
+
+			http.Redirect(w, r, localRedirect(data.URI), http.StatusFound)
+			return
+		}
+	}
+
+	tmpl.ExecuteTemplate(w, "ldap", data)
+}
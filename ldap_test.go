@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// fakeLDAP serves a directory with one user in one group. StartTLS is only
+// supported with a config.
+func fakeLDAP(t *testing.T, config *tls.Config) string {
+	const (
+		userDN  = "uid=alice,ou=people,dc=example,dc=com"
+		groupDN = "cn=scan-users,ou=groups,dc=example,dc=com"
+	)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	result := func(tag byte, code int) []byte {
+		return berEncode(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, ""))
+	}
+	attr := func(name, value string) []byte {
+		return berEncode(berSequence, berString(berOctetString, name), berEncode(0x31, berString(berOctetString, value)))
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				c := &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+				for {
+					op, err := c.read()
+					if err != nil {
+						return
+					}
+					switch op.tag {
+					case ldapBindRequest:
+						code := 49
+						if string(op.children[1].value) == userDN && string(op.children[2].value) == "secret" {
+							code = 0
+						}
+						c.send(result(ldapBindResponse, code))
+					case ldapSearch:
+						base, filter := string(op.children[0].value), op.children[6]
+						switch {
+						case filter.tag == ldapFilterPresent && base == userDN:
+							c.send(berEncode(ldapSearchEntry, berString(berOctetString, userDN),
+								berEncode(berSequence, attr("mail", "alice@example.com"), attr("cn", "Alice Example"))))
+						case filter.tag == ldapFilterEquality && string(filter.children[1].value) == userDN:
+							c.send(berEncode(ldapSearchEntry, berString(berOctetString, groupDN), berEncode(berSequence)))
+						}
+						c.send(result(ldapSearchDone, 0))
+					case ldapExtended:
+						if config == nil {
+							c.send(result(ldapExtendedResp, 2))
+							continue
+						}
+						c.send(result(ldapExtendedResp, 0))
+						conn := tls.Server(conn, config)
+						c.conn, c.r = conn, bufio.NewReader(conn)
+					case ldapUnbind:
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return "ldap://" + l.Addr().String()
+}
+
+func TestLDAPLogin(t *testing.T) {
+	auth := ldapAuth{
+		url:       fakeLDAP(t, nil),
+		userDN:    "uid=%s,ou=people,dc=example,dc=com",
+		groupBase: "ou=groups,dc=example,dc=com",
+		timeout:   5 * time.Second,
+		insecure:  true,
+	}
+
+	user, groups, err := auth.login("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alice@example.com" || user.Name != "Alice Example" {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if len(groups) != 1 || groups[0] != "cn=scan-users,ou=groups,dc=example,dc=com" {
+		t.Errorf("unexpected groups %v", groups)
+	}
+
+	for _, password := range []string{"wrong", ""} {
+		if _, _, err := auth.login("alice", password); err != errLDAPInvalidCredentials {
+			t.Errorf("password %q: expected errLDAPInvalidCredentials, got %v", password, err)
+		}
+	}
+}
+
+func TestLDAPStartTLS(t *testing.T) {
+	// Borrow httptest's certificate for 127.0.0.1
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	config := &tls.Config{Certificates: ts.TLS.Certificates}
+
+	for _, tt := range []struct {
+		name   string
+		server *tls.Config
+		client *tls.Config
+		ok     bool
+	}{
+		{"StartTLS", config, &tls.Config{RootCAs: roots}, true},
+		{"no StartTLS", nil, &tls.Config{RootCAs: roots}, false},
+		{"untrusted", config, nil, false},
+	} {
+		auth := ldapAuth{
+			url:     fakeLDAP(t, tt.server),
+			userDN:  "uid=%s,ou=people,dc=example,dc=com",
+			timeout: 5 * time.Second,
+			tls:     tt.client,
+		}
+		user, _, err := auth.login("alice", "secret")
+		if tt.ok && (err != nil || user.Email != "alice@example.com") {
+			t.Errorf("%s: expected alice logged in, got %+v %v", tt.name, user, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: expected an error, got %+v", tt.name, user)
+		}
+	}
+}
+
+func TestLDAPLoginHandler(t *testing.T) {
+	db := createDB("TestLDAPLoginHandler")
+	defer db.Close()
+	app := &App{db: db, ldap: &ldapAuth{
+		url:       fakeLDAP(t, nil),
+		userDN:    "uid=%s,ou=people,dc=example,dc=com",
+		groupBase: "ou=groups,dc=example,dc=com",
+		timeout:   5 * time.Second,
+		insecure:  true,
+	}}
+	setupTemplates()
+	store = sessions.NewCookieStore([]byte("test"))
+	defer func() { store = nil }()
+
+	login := func(password, redir string) *http.Response {
+		f := url.Values{}
+		f.Set("username", "alice")
+		f.Set("password", password)
+		f.Set("redir", redir)
+		r := httptest.NewRequest("POST", "/login", strings.NewReader(f.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		app.ldapLoginHandler(w, r)
+		return w.Result()
+	}
+
+	if res := login("wrong", ""); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for a wrong password, got %d", res.StatusCode)
+	}
+
+	// alice's group isn't authorised yet
+	if res := login("secret", ""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected status 403 for an unauthorised user, got %d", res.StatusCode)
+	}
+
+	if _, err := db.Exec(`INSERT INTO groups (group_name) VALUES ('CN=scan-users,OU=groups,DC=example,DC=com')`); err != nil {
+		t.Fatal(err)
+	}
+	res := login("secret", "//evil.example.com/")
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("expected status 302, got %d", res.StatusCode)
+	}
+	if loc := res.Header.Get("Location"); loc != "/" {
+		t.Errorf("expected redirect to /, got %q", loc)
+	}
+	if len(res.Cookies()) == 0 {
+		t.Error("expected a session cookie")
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	tests := map[string]string{
+		"alice":        "alice",
+		"a,b=c":        `a\,b\=c`,
+		" #lead":       `\ #lead`,
+		"#hash":        `\#hash`,
+		"trail ":       `trail\ `,
+		`back\slash+1`: `back\\slash\+1`,
+	}
+	for in, want := range tests {
+		if got := escapeDN(in); got != want {
+			t.Errorf("escapeDN(%q): expected %q, got %q", in, want, got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// leaderLease is the name of the lease held by the replica running the
+// periodic tasks.
+const leaderLease = "leader"
+
+// leader elects one of several replicas sharing a database to run the
+// periodic tasks, such as pruning and digests, which would otherwise be done
+// once by every replica. It holds a lease in the database for ttl, renewing it
+// well before it expires, so if the leader goes away another replica takes
+// over within ttl.
+type leader struct {
+	id  string
+	ttl time.Duration
+
+	mu      sync.Mutex
+	leading bool
+}
+
+// leading reports whether this replica should run the periodic tasks. It's
+// always true when leader election is disabled.
+func (app *App) leading() bool {
+	l := app.leader
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leading
+}
+
+// elect tries to take or renew the lease.
+func (app *App) elect(now time.Time) {
+	l := app.leader
+	ok, err := app.db.AcquireLease(leaderLease, l.id, now, l.ttl)
+	if err != nil {
+		log.Println("elect: error acquiring lease:", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ok != l.leading {
+		if ok {
+			log.Printf("elect: %s is now the leader", l.id)
+		} else {
+			log.Printf("elect: %s is no longer the leader", l.id)
+		}
+	}
+	l.leading = ok
+}
+
+// runLeader tries to become or stay the leader every third of the lease.
+func (app *App) runLeader() {
+	for now := range time.Tick(app.leader.ttl / 3) {
+		app.elect(now)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeader(t *testing.T) {
+	db := createDB("TestLeader")
+	defer db.Close()
+
+	// Without leader election every replica runs the periodic tasks
+	if !(&App{}).leading() {
+		t.Error("expected to lead with leader election disabled")
+	}
+
+	ttl := 30 * time.Second
+	a := &App{db: db, leader: &leader{id: "a", ttl: ttl}}
+	b := &App{db: db, leader: &leader{id: "b", ttl: ttl}}
+
+	now := time.Now()
+	a.elect(now)
+	b.elect(now)
+	if !a.leading() || b.leading() {
+		t.Fatalf("expected a to lead, got a %v b %v", a.leading(), b.leading())
+	}
+
+	// a keeps the lease by renewing it
+	now = now.Add(ttl / 3)
+	a.elect(now)
+	b.elect(now.Add(ttl / 2))
+	if !a.leading() || b.leading() {
+		t.Errorf("expected a to keep the lease, got a %v b %v", a.leading(), b.leading())
+	}
+
+	// b takes over once a's lease expires
+	now = now.Add(ttl + time.Second)
+	b.elect(now)
+	a.elect(now)
+	if a.leading() || !b.leading() {
+		t.Errorf("expected b to take over, got a %v b %v", a.leading(), b.leading())
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// masscanHost is the submission host recorded for scans the server runs
+// itself.
+const masscanHost = "localhost"
+
+// masscanRunner runs masscan on the server itself, for deployments without
+// separate scanners.
+type masscanRunner struct {
+	ranges  []string
+	ports   string
+	rate    int
+	timeout time.Duration
+	// run runs masscan with args
+	run func(ctx context.Context, args []string) error
+}
+
+func newMasscanRunner(path string, ranges []string, ports string, rate int, timeout time.Duration) *masscanRunner {
+	return &masscanRunner{
+		ranges:  ranges,
+		ports:   ports,
+		rate:    rate,
+		timeout: timeout,
+		run: func(ctx context.Context, args []string) error {
+			cmd := exec.CommandContext(ctx, path, args...)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				if msg := strings.TrimSpace(stderr.String()); msg != "" {
+					return fmt.Errorf("%v: %s", err, lastLine(msg))
+				}
+				return err
+			}
+			return nil
+		},
+	}
+}
+
+// lastLine returns the last line of s, where masscan puts its errors after
+// its progress output.
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// parseMasscanRanges splits a comma-separated list of the IPs, CIDRs and
+// first-last ranges masscan accepts, so typos are caught at startup rather
+// than on the first scan.
+func parseMasscanRanges(s string) ([]string, error) {
+	var ranges []string
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		var ok bool
+		if _, _, err := net.ParseCIDR(r); err == nil {
+			ok = true
+		} else if bounds := strings.SplitN(r, "-", 2); len(bounds) == 2 {
+			ok = net.ParseIP(bounds[0]) != nil && net.ParseIP(bounds[1]) != nil
+		} else {
+			ok = net.ParseIP(r) != nil
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid range %q", r)
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no ranges to scan")
+	}
+	return ranges, nil
+}
+
+// args returns what to scan as masscan's arguments.
+func (m *masscanRunner) args() []string {
+	args := []string{"-p", m.ports, "--rate", strconv.Itoa(m.rate)}
+	return append(args, m.ranges...)
+}
+
+// scan runs masscan and returns the results.
+func (m *masscanRunner) scan() ([]scan.Result, error) {
+	dir, err := ioutil.TempDir("", "scan-masscan")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "results.json")
+
+	ctx := context.Background()
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+	if err := m.run(ctx, append([]string{"-oJ", file}, m.args()...)); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		// Nothing was found
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMasscanJSON(f)
+}
+
+// parseMasscanJSON parses masscan's -oJ output, which isn't quite JSON.
+func parseMasscanJSON(r io.Reader) ([]scan.Result, error) {
+	var results []scan.Result
+	if err := json.NewDecoder(scan.MasscanJSON(r)).Decode(&results); err != nil {
+		return nil, fmt.Errorf("parsing masscan output: %v", err)
+	}
+	return results, nil
+}
+
+// masscanAllowed reports whether masscan may scan its ranges at now, which
+// it may not if any overlap a network outside its scan window.
+func (app *App) masscanAllowed(now time.Time) bool {
+	windows, err := app.loadScanWindows()
+	if err != nil {
+		log.Println("masscan: error loading scan windows:", err)
+		return false
+	}
+	return windows.closed(now, app.masscan.ranges...) == ""
+}
+
+// runMasscan runs masscan and saves the results as a submission from the
+// server itself, alerting on changes like any other. They're saved as seen
+// when the scan finished rather than when it was due. The submission is
+// scoped to the ranges and ports scanned, so it only closes ports in them.
+func (app *App) runMasscan(time.Time) {
+	if !app.leading() {
+		return
+	}
+	if app.quota.paused() {
+		log.Println("masscan: database is over its quota, skipping scan")
+		return
+	}
+	results, err := app.masscan.scan()
+	if err != nil {
+		log.Println("masscan: error scanning:", err)
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	setSource(results, masscanHost)
+	results = app.prepareResults(results, now)
+	count, added, err := app.db.SaveData(results, now)
+	if err != nil {
+		log.Println("masscan: error saving results:", dbError("save_data", err))
+		return
+	}
+	counterRows.WithLabelValues("insert").Add(float64(len(added)))
+	counterRows.WithLabelValues("update").Add(float64(count - int64(len(added))))
+	counterSubmissions.WithLabelValues("masscan").Inc()
+
+	run := scan.Run{
+		Scanner: "masscan",
+		Args:    strings.Join(app.masscan.args(), " "),
+		Rate:    app.masscan.rate,
+		Started: runStarted(results, now),
+		Scope:   app.masscan.ranges,
+		Ports:   app.masscan.ports,
+	}
+	if err := dbError("save_submission", app.db.SaveSubmission(masscanHost, nil, now, run)); err != nil {
+		log.Println("masscan: error saving submission:", err)
+		return
+	}
+
+	app.notifyChanges(now, added, nil)
+	app.nuclei.enqueue(added)
+	app.updateResultMetrics(now)
+	if verbose {
+		log.Printf("masscan: saved %d results, %d new", count, len(added))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestParseMasscanJSON(t *testing.T) {
+	for _, tt := range []struct {
+		name, output string
+		want         int
+	}{
+		{"empty", "", 0},
+		{"no results", "[\n]\n", 0},
+		{"trailing comma", `[
+{   "ip": "192.0.2.1",   "timestamp": "1600000000", "ports": [ {"port": 22, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] }
+,
+{   "ip": "192.0.2.2",   "timestamp": "1600000001", "ports": [ {"port": 80, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] }
+,
+]
+`, 2},
+		{"leading comma", `[
+{   "ip": "192.0.2.1",   "timestamp": "1600000000", "ports": [ {"port": 22, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] }
+,{   "ip": "192.0.2.2",   "timestamp": "1600000001", "ports": [ {"port": 80, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] }
+]`, 2},
+		{"finished", `{ "ip": "192.0.2.1", "ports": [ {"port": 80, "proto": "tcp", "status": "open"} ] },
+{ "ip": "192.0.2.1", "ports": [ {"port": 443, "proto": "tcp", "status": "open"} ] },
+{finished: 1}
+`, 2},
+	} {
+		results, err := parseMasscanJSON(strings.NewReader(tt.output))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(results) != tt.want {
+			t.Errorf("%s: expected %d results, got %+v", tt.name, tt.want, results)
+		}
+	}
+	if _, err := parseMasscanJSON(strings.NewReader("Starting masscan")); err == nil {
+		t.Error("expected an error parsing something other than JSON")
+	}
+}
+
+func TestParseMasscanRanges(t *testing.T) {
+	ranges, err := parseMasscanRanges("192.0.2.0/24, 198.51.100.1-198.51.100.9,203.0.113.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.0/24", "198.51.100.1-198.51.100.9", "203.0.113.7"}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("expected %v, got %v", want, ranges)
+	}
+	for _, s := range []string{"", "192.0.2.0/33", "example.com", "192.0.2.1-"} {
+		if _, err := parseMasscanRanges(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestRunMasscan(t *testing.T) {
+	db := createDB("TestRunMasscan")
+	defer db.Close()
+
+	var args []string
+	var fail bool
+	m := newMasscanRunner("masscan", []string{"192.0.2.0/24"}, "22,80", 100, time.Minute)
+	m.run = func(ctx context.Context, a []string) error {
+		args = a
+		if fail {
+			return errors.New("exit status 1: FAIL: permission denied")
+		}
+		return ioutil.WriteFile(a[1], []byte(`[
+{   "ip": "192.0.2.1",   "timestamp": "1600000000", "ports": [ {"port": 22, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] }
+,
+{   "ip": "192.0.2.1",   "timestamp": "1600000001", "ports": [ {"port": 22, "proto": "tcp", "service": {"name": "ssh", "banner": "SSH-2.0-OpenSSH_7.4"} } ] }
+]
+`), 0600)
+	}
+	app := App{db: db, masscan: m, maxResultAge: 24 * time.Hour}
+
+	app.runMasscan(time.Now())
+	if !strings.HasSuffix(strings.Join(args, " "), "-p 22,80 --rate 100 192.0.2.0/24") || args[0] != "-oJ" {
+		t.Errorf("unexpected masscan arguments %v", args)
+	}
+	results, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].IP != "192.0.2.1" || results[0].Port != 22 {
+		t.Errorf("expected the open port saved, got %+v", results)
+	}
+	banners, err := db.LoadBanners("192.0.2.1", 22, "tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if banners["ssh"] != "SSH-2.0-OpenSSH_7.4" {
+		t.Errorf("expected the banner saved, got %v", banners)
+	}
+
+	subs, err := db.LoadSubmissions(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := scan.Run{Scanner: "masscan", Args: "-p 22,80 --rate 100 192.0.2.0/24", Rate: 100, Scope: []string{"192.0.2.0/24"}, Ports: "22,80"}
+	if len(subs) != 1 || subs[0].Host != masscanHost || !reflect.DeepEqual(subs[0].Run, want) {
+		t.Errorf("expected the scan recorded as a submission, got %+v", subs)
+	}
+
+	// A failed scan saves nothing
+	fail = true
+	app.runMasscan(time.Now())
+	if subs, _ := db.LoadSubmissions(10); len(subs) != 1 {
+		t.Errorf("expected no submission from a failed scan, got %+v", subs)
+	}
+}
+
+func TestRunMasscanScope(t *testing.T) {
+	db := createDB("TestRunMasscanScope")
+	defer db.Close()
+
+	// Ports seen before the scan, in and out of its ranges and ports
+	before := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	old := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.3", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.4", Ports: []scan.Port{{Port: 53, Proto: "udp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(old, before); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMasscanRunner("masscan", []string{"192.0.2.0/24"}, "22,80", 100, time.Minute)
+	m.run = func(ctx context.Context, a []string) error {
+		return ioutil.WriteFile(a[1], []byte(`[
+{   "ip": "192.0.2.1", "ports": [ {"port": 22, "proto": "tcp", "status": "open"} ] }
+]
+`), 0600)
+	}
+	app := App{db: db, masscan: m}
+	app.runMasscan(time.Now())
+
+	results, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(old) {
+		t.Fatalf("expected %d results, got %+v", len(old), results)
+	}
+	// Only the port it scanned and didn't see is closed
+	for _, r := range results {
+		if want := r.IP == "192.0.2.2"; r.Gone != want {
+			t.Errorf("%s port %d/%s: expected closed %v, got %v", r.IP, r.Port, r.Proto, want, r.Gone)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// targetFilter selects targets from the inventory. Empty fields match any.
+type targetFilter struct {
+	IDs         []int64
+	Environment string
+	Owner       string
+}
+
+func (f targetFilter) matches(t scan.Target) bool {
+	if f.Environment != "" && !strings.EqualFold(f.Environment, t.Environment) {
+		return false
+	}
+	if f.Owner != "" && !strings.EqualFold(f.Owner, t.Owner) {
+		return false
+	}
+	if len(f.IDs) == 0 {
+		return true
+	}
+	for _, id := range f.IDs {
+		if id == t.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTargetIDs parses target IDs given as repeated or comma-separated
+// values.
+func parseTargetIDs(values []string) ([]int64, error) {
+	var ids []int64
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target ID %q", s)
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// mergePorts combines port ranges, joining those which overlap or are
+// adjacent, and formats them as masscan's -p with prefix on each range.
+func mergePorts(ranges [][2]int, prefix string) []string {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	var merged [][2]int
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1]+1 {
+			if r[1] > merged[n-1][1] {
+				merged[n-1][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	var ports []string
+	for _, r := range merged {
+		if r[0] == r[1] {
+			ports = append(ports, prefix+strconv.Itoa(r[0]))
+		} else {
+			ports = append(ports, fmt.Sprintf("%s%d-%d", prefix, r[0], r[1]))
+		}
+	}
+	return ports
+}
+
+// scanExcludes returns the networks the ignore rules say not to scan at all:
+// those of rules ignoring every port and protocol on a network. masscan
+// can't leave out only some ports of a range, so other rules are still
+// scanned.
+func scanExcludes(rules []scan.IgnoreRule) []scan.IgnoreRule {
+	var excludes []scan.IgnoreRule
+	seen := make(map[string]bool)
+	for _, r := range rules {
+		if r.CIDR == "" || r.Ports != "" || r.Proto != "" || seen[r.CIDR] {
+			continue
+		}
+		seen[r.CIDR] = true
+		excludes = append(excludes, r)
+	}
+	return excludes
+}
+
+// writeMasscanExcludes writes an exclude file for masscan's --excludefile
+// from the ignore rules.
+func writeMasscanExcludes(w io.Writer, rules []scan.IgnoreRule) error {
+	fmt.Fprintln(w, "# Generated by scan from its ignore rules")
+	for _, r := range scanExcludes(rules) {
+		if r.Reason != "" {
+			fmt.Fprintf(w, "# %s\n", strings.ReplaceAll(r.Reason, "\n", " "))
+		}
+		if _, err := fmt.Fprintln(w, r.CIDR); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMasscanConfig writes a masscan configuration file, for masscan -c,
+// scanning the targets the filter selects. masscan scans every range on the
+// same ports, so these are all the targets' ports, with UDP ports given as
+// U:port. Networks the ignore rules say not to scan are excluded. A rate of
+// 0 leaves it to masscan's default or command line.
+func writeMasscanConfig(w io.Writer, targets []scan.Target, rules []scan.IgnoreRule, f targetFilter, rate int) error {
+	var tcp, udp [][2]int
+	var ranges []string
+	var n int
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		if !f.matches(t) {
+			continue
+		}
+		n++
+		ports, err := scan.ParsePortSpec(t.Ports)
+		if err != nil {
+			return fmt.Errorf("target %d: %v", t.ID, err)
+		}
+		if t.Proto == "udp" {
+			udp = append(udp, ports...)
+		} else {
+			tcp = append(tcp, ports...)
+		}
+		if !seen[t.CIDR] {
+			seen[t.CIDR] = true
+			ranges = append(ranges, t.CIDR)
+		}
+	}
+	if n == 0 {
+		return errors.New("no targets to scan")
+	}
+
+	fmt.Fprintf(w, "# Generated by scan from %d targets in its inventory\n", n)
+	if rate > 0 {
+		fmt.Fprintf(w, "rate = %d\n", rate)
+	}
+	ports := append(mergePorts(tcp, ""), mergePorts(udp, "U:")...)
+	fmt.Fprintf(w, "ports = %s\n", strings.Join(ports, ","))
+	for _, r := range ranges {
+		fmt.Fprintf(w, "range = %s\n", r)
+	}
+	for _, r := range scanExcludes(rules) {
+		if _, err := fmt.Fprintf(w, "exclude = %s\n", r.CIDR); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler for GET /api/v1/masscan/config
+// Targets can be chosen with target (IDs), environment and owner, and the
+// rate set with rate.
+func (app *App) masscanConfig(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ids, err := parseTargetIDs(q["target"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var rate int
+	if s := q.Get("rate"); s != "" {
+		if rate, err = strconv.Atoi(s); err != nil || rate < 0 {
+			http.Error(w, "Invalid rate", http.StatusBadRequest)
+			return
+		}
+	}
+	targets, err := app.db.LoadTargets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rules, err := app.db.LoadIgnoreRules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var b strings.Builder
+	filter := targetFilter{IDs: ids, Environment: q.Get("environment"), Owner: q.Get("owner")}
+	if err := writeMasscanConfig(&b, targets, rules, filter, rate); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+// Handler for GET /api/v1/masscan/excludes
+func (app *App) masscanExcludes(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.db.LoadIgnoreRules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writeMasscanExcludes(w, rules)
+}
+
+// runMasscanConfig implements scan masscan-config, which writes a masscan
+// configuration or exclude file from the database, like the API.
+func runMasscanConfig(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("masscan-config", flag.ContinueOnError)
+	fs.SetOutput(out)
+	dir := fs.String("data.dir", ".", "Data directory `path`")
+	ids := fs.String("targets", "", "Comma-separated `IDs` of the targets to scan (default all)")
+	environment := fs.String("environment", "", "Only scan targets in this `environment`")
+	owner := fs.String("owner", "", "Only scan targets with this `owner`")
+	rate := fs.Int("rate", 0, "Packets per second to send, or 0 to leave it to masscan")
+	excludes := fs.Bool("excludes", false, "Write an exclude file for --excludefile instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	filter := targetFilter{Environment: *environment, Owner: *owner}
+	var err error
+	if filter.IDs, err = parseTargetIDs([]string{*ids}); err != nil {
+		return err
+	}
+
+	dsn, err := sqlite.DefaultOptions.DSN(filepath.Join(*dir, sqlite.DefaultDBFile))
+	if err != nil {
+		return err
+	}
+	db, err := sqlite.Open(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	rules, err := db.LoadIgnoreRules()
+	if err != nil {
+		return err
+	}
+	if *excludes {
+		return writeMasscanExcludes(out, rules)
+	}
+	targets, err := db.LoadTargets()
+	if err != nil {
+		return err
+	}
+	return writeMasscanConfig(out, targets, rules, filter, *rate)
+}
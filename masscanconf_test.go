@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestMergePorts(t *testing.T) {
+	got := mergePorts([][2]int{{443, 443}, {1, 1024}, {8080, 8080}, {1025, 1030}, {8000, 8079}}, "")
+	want := []string{"1-1030", "8000-8080"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	got = mergePorts([][2]int{{161, 162}, {53, 53}}, "U:")
+	want = []string{"U:53", "U:161-162"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestMasscanConfig(t *testing.T) {
+	db := createDB("TestMasscanConfig")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	now := time.Now().UTC()
+	for _, tg := range []scan.Target{
+		{CIDR: "192.0.2.0/24", Ports: "22,80", Proto: "tcp", Environment: "production"},
+		{CIDR: "192.0.2.0/24", Ports: "53", Proto: "udp", Environment: "production"},
+		{CIDR: "198.51.100.0/24", Ports: "443,8000-9000", Proto: "tcp", Environment: "staging"},
+	} {
+		tg.User, tg.Created = "user@example.com", scan.Time{Time: now}
+		if _, err := db.SaveTarget(tg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []scan.IgnoreRule{
+		{CIDR: "192.0.2.128/25", Reason: "Honeypots"},
+		// Only some ports, which masscan can't exclude
+		{CIDR: "198.51.100.0/24", Ports: "8080"},
+		{Ports: "161"},
+	} {
+		r.User, r.Created = "user@example.com", scan.Time{Time: now}
+		if _, err := db.SaveIgnoreRule(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{
+			"ports = 22,80,443,8000-9000,U:53",
+			"range = 192.0.2.0/24",
+			"range = 198.51.100.0/24",
+			"exclude = 192.0.2.128/25",
+		}},
+		{"?environment=Production&rate=5000", []string{
+			"rate = 5000",
+			"ports = 22,80,U:53",
+			"range = 192.0.2.0/24",
+			"exclude = 192.0.2.128/25",
+		}},
+		{"?target=3", []string{
+			"ports = 443,8000-9000",
+			"range = 198.51.100.0/24",
+			"exclude = 192.0.2.128/25",
+		}},
+	} {
+		code, body := get("/api/v1/masscan/config" + tt.query)
+		if code != http.StatusOK {
+			t.Errorf("%q: expected status 200, got %d: %s", tt.query, code, body)
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		if !strings.HasPrefix(lines[0], "#") || !reflect.DeepEqual(lines[1:], tt.want) {
+			t.Errorf("%q: expected %v, got\n%s", tt.query, tt.want, body)
+		}
+	}
+
+	for _, tt := range []struct {
+		query string
+		code  int
+	}{
+		{"?target=x", http.StatusBadRequest},
+		{"?rate=-1", http.StatusBadRequest},
+		{"?environment=development", http.StatusNotFound},
+	} {
+		if code, _ := get("/api/v1/masscan/config" + tt.query); code != tt.code {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.code, code)
+		}
+	}
+
+	code, body := get("/api/v1/masscan/excludes")
+	if want := "# Generated by scan from its ignore rules\n# Honeypots\n192.0.2.128/25\n"; code != http.StatusOK || body != want {
+		t.Errorf("expected exclude file %q, got %d %q", want, code, body)
+	}
+}
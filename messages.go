@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// defaultLang is used when a notifier's language has no templates.
+const defaultLang = "en"
+
+// defaultMessages are the built-in notification templates for each language.
+// Each defines a template named after the event type it describes.
+var defaultMessages = map[string]string{
+	"en": `{{ define "new_port" }}New port {{ .Port }}/{{ .Proto }} open on {{ .IP }}{{ end }}` +
+		`{{ define "port_gone" }}Port {{ .Port }}/{{ .Proto }} closed on {{ .IP }}{{ end }}` +
+		`{{ define "banner_changed" }}{{ .Change.Service }} banner changed on {{ .IP }} port {{ .Port }}/{{ .Proto }}: {{ .Change.Old }} to {{ .Change.New }}{{ end }}` +
+		`{{ define "baseline_violation" }}Port {{ .Port }}/{{ .Proto }} open on {{ .IP }} is outside the baseline{{ end }}` +
+		`{{ define "summary" }}{{ .New }} new ports open, {{ .Gone }} ports closed{{ if .Changed }}, {{ .Changed }} banners changed{{ end }}{{ if .Violations }}, {{ .Violations }} baseline violations{{ end }}{{ if .Expiring }}, {{ .Expiring }} certificates expiring{{ end }}{{ end }}` +
+		`{{ define "db_quota" }}Database is {{ .Size }} MB, over its quota of {{ .Quota }} MB{{ end }}` +
+		`{{ define "cert_expiring" }}Certificate for {{ .Certificate.Subject }} on {{ .IP }} port {{ .Port }}/{{ .Proto }} expires on {{ .Certificate.NotAfter }}{{ end }}`,
+	"de": `{{ define "new_port" }}Neuer Port {{ .Port }}/{{ .Proto }} offen auf {{ .IP }}{{ end }}` +
+		`{{ define "port_gone" }}Port {{ .Port }}/{{ .Proto }} geschlossen auf {{ .IP }}{{ end }}` +
+		`{{ define "banner_changed" }}{{ .Change.Service }}-Banner geändert auf {{ .IP }} Port {{ .Port }}/{{ .Proto }}: {{ .Change.Old }} zu {{ .Change.New }}{{ end }}` +
+		`{{ define "baseline_violation" }}Port {{ .Port }}/{{ .Proto }} offen auf {{ .IP }} liegt außerhalb der Baseline{{ end }}` +
+		`{{ define "summary" }}{{ .New }} neue Ports offen, {{ .Gone }} Ports geschlossen{{ if .Changed }}, {{ .Changed }} Banner geändert{{ end }}{{ if .Violations }}, {{ .Violations }} Baseline-Verstöße{{ end }}{{ if .Expiring }}, {{ .Expiring }} Zertifikate laufen ab{{ end }}{{ end }}` +
+		`{{ define "db_quota" }}Datenbank ist {{ .Size }} MB groß, über ihrem Kontingent von {{ .Quota }} MB{{ end }}` +
+		`{{ define "cert_expiring" }}Zertifikat für {{ .Certificate.Subject }} auf {{ .IP }} Port {{ .Port }}/{{ .Proto }} läuft ab am {{ .Certificate.NotAfter }}{{ end }}`,
+	"es": `{{ define "new_port" }}Nuevo puerto {{ .Port }}/{{ .Proto }} abierto en {{ .IP }}{{ end }}` +
+		`{{ define "port_gone" }}Puerto {{ .Port }}/{{ .Proto }} cerrado en {{ .IP }}{{ end }}` +
+		`{{ define "banner_changed" }}Banner de {{ .Change.Service }} cambiado en {{ .IP }} puerto {{ .Port }}/{{ .Proto }}: {{ .Change.Old }} a {{ .Change.New }}{{ end }}` +
+		`{{ define "baseline_violation" }}Puerto {{ .Port }}/{{ .Proto }} abierto en {{ .IP }} fuera de la línea base{{ end }}` +
+		`{{ define "summary" }}{{ .New }} puertos nuevos abiertos, {{ .Gone }} puertos cerrados{{ if .Changed }}, {{ .Changed }} banners cambiados{{ end }}{{ if .Violations }}, {{ .Violations }} violaciones de la línea base{{ end }}{{ if .Expiring }}, {{ .Expiring }} certificados por caducar{{ end }}{{ end }}` +
+		`{{ define "db_quota" }}La base de datos ocupa {{ .Size }} MB, por encima de su cuota de {{ .Quota }} MB{{ end }}` +
+		`{{ define "cert_expiring" }}El certificado de {{ .Certificate.Subject }} en {{ .IP }} puerto {{ .Port }}/{{ .Proto }} caduca el {{ .Certificate.NotAfter }}{{ end }}`,
+	"fr": `{{ define "new_port" }}Nouveau port {{ .Port }}/{{ .Proto }} ouvert sur {{ .IP }}{{ end }}` +
+		`{{ define "port_gone" }}Port {{ .Port }}/{{ .Proto }} fermé sur {{ .IP }}{{ end }}` +
+		`{{ define "banner_changed" }}Bannière {{ .Change.Service }} modifiée sur {{ .IP }} port {{ .Port }}/{{ .Proto }} : {{ .Change.Old }} en {{ .Change.New }}{{ end }}` +
+		`{{ define "baseline_violation" }}Port {{ .Port }}/{{ .Proto }} ouvert sur {{ .IP }} hors de la référence{{ end }}` +
+		`{{ define "summary" }}{{ .New }} nouveaux ports ouverts, {{ .Gone }} ports fermés{{ if .Changed }}, {{ .Changed }} bannières modifiées{{ end }}{{ if .Violations }}, {{ .Violations }} violations de la référence{{ end }}{{ if .Expiring }}, {{ .Expiring }} certificats expirant bientôt{{ end }}{{ end }}` +
+		`{{ define "db_quota" }}La base de données fait {{ .Size }} Mo, au-delà de son quota de {{ .Quota }} Mo{{ end }}` +
+		`{{ define "cert_expiring" }}Le certificat de {{ .Certificate.Subject }} sur {{ .IP }} port {{ .Port }}/{{ .Proto }} expire le {{ .Certificate.NotAfter }}{{ end }}`,
+}
+
+// messageTmpl holds the notification templates for each language.
+var messageTmpl map[string]*template.Template
+
+// setupMessages parses the built-in notification templates, then any
+// <lang>.tmpl files in dir. Templates in dir override the built-in ones for
+// the same language and event.
+func setupMessages(dir string) error {
+	messageTmpl = make(map[string]*template.Template)
+	for lang, text := range defaultMessages {
+		messageTmpl[lang] = template.Must(template.New(lang).Parse(text))
+	}
+
+	if dir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		lang := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+		t, ok := messageTmpl[lang]
+		if !ok {
+			t = template.New(lang)
+			messageTmpl[lang] = t
+		}
+		if _, err := t.Parse(string(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// message renders the event in the given language. If the language doesn't
+// have a template for the event, the default language is used.
+func message(lang string, e event) (string, error) {
+	if messageTmpl == nil {
+		if err := setupMessages(""); err != nil {
+			return "", err
+		}
+	}
+	t, ok := messageTmpl[lang]
+	if !ok || t.Lookup(e.Type) == nil {
+		t = messageTmpl[defaultLang]
+	}
+
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, e.Type, e); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// customMessage is a notifier's own message template, which overrides the
+// language templates. It is executed for every event type, so can use .Type
+// to distinguish them.
+type customMessage struct {
+	lang string
+	tmpl *template.Template
+}
+
+// newCustomMessage parses text as a template. If text is empty, messages are
+// rendered from the templates for lang.
+func newCustomMessage(lang, text string) (customMessage, error) {
+	m := customMessage{lang: lang}
+	if text == "" {
+		return m, nil
+	}
+	t, err := template.New("custom").Parse(text)
+	if err != nil {
+		return m, err
+	}
+	m.tmpl = t
+	return m, nil
+}
+
+func (m customMessage) render(e event) (string, error) {
+	if m.tmpl == nil {
+		return message(m.lang, e)
+	}
+	var buf bytes.Buffer
+	if err := m.tmpl.Execute(&buf, e); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMessage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	custom := map[string]string{
+		"en.tmpl": `{{ define "new_port" }}{{ .IP }} has opened {{ .Port }}{{ end }}`,
+		"nl.tmpl": `{{ define "new_port" }}Nieuwe poort {{ .Port }}/{{ .Proto }} open op {{ .IP }}{{ end }}`,
+	}
+	for name, text := range custom {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setupMessages(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer setupMessages("")
+
+	e := event{Type: eventNewPort, IP: "192.0.2.1", Port: 80, Proto: "tcp"}
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"en", "192.0.2.1 has opened 80"},
+		{"de", "Neuer Port 80/tcp offen auf 192.0.2.1"},
+		{"nl", "Nieuwe poort 80/tcp open op 192.0.2.1"},
+		// Unknown languages fall back to English
+		{"xx", "192.0.2.1 has opened 80"},
+	}
+	for _, tt := range tests {
+		got, err := message(tt.lang, e)
+		if err != nil {
+			t.Errorf("%s: %v", tt.lang, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.lang, tt.want, got)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	"github.com/jamesog/scan/internal/sqlite"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -51,6 +54,92 @@ var (
 		Name:      "last_submission_time",
 		Help:      "Last job submission time in seconds since the Unix epoch",
 	})
+
+	gaugeGeoIPUpdate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "scan",
+			Subsystem: "geoip",
+			Name:      "database_time",
+			Help:      "Modification time of each GeoIP database in seconds since the Unix epoch",
+		},
+		[]string{"edition"})
+
+	counterSubmissions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "scan",
+			Name:      "submissions_total",
+			Help:      "Number of result submissions, by whether they were for a job or from masscan run by the server",
+		},
+		[]string{"type"})
+
+	counterResults = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "scan",
+		Name:      "results_received_total",
+		Help:      "Number of results received in submissions",
+	})
+
+	counterRows = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "scan",
+			Subsystem: "db",
+			Name:      "rows_total",
+			Help:      "Number of port rows inserted or updated by submissions",
+		},
+		[]string{"op"})
+
+	counterDBErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "scan",
+			Subsystem: "db",
+			Name:      "errors_total",
+			Help:      "Number of database errors saving submissions, by operation",
+		},
+		[]string{"op"})
+
+	histRequests = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "scan",
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latencies by method, route and status code",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"method", "route", "code"})
+
+	gaugeDBSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "scan",
+		Subsystem: "db",
+		Name:      "size_bytes",
+		Help:      "Size of the database in bytes",
+	})
+
+	gaugeNotifyQueue = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "scan",
+		Subsystem: "notify",
+		Name:      "queue_depth",
+		Help:      "Number of notification events waiting to be sent",
+	})
+
+	gaugeIngestQueue = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "scan",
+		Subsystem: "ingest",
+		Name:      "queue_depth",
+		Help:      "Number of results submissions waiting to be saved",
+	})
+
+	gaugeAgentSeen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "scan",
+		Subsystem: "agent",
+		Name:      "last_seen_timestamp_seconds",
+		Help:      "When each agent last sent a heartbeat or results",
+	}, []string{"agent"})
+
+	gaugeAgentScan = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "scan",
+		Subsystem: "agent",
+		Name:      "last_scan_timestamp_seconds",
+		Help:      "When each agent last submitted results",
+	}, []string{"agent"})
 )
 
 func init() {
@@ -60,6 +149,54 @@ func init() {
 	prometheus.MustRegister(gaugeSubmission)
 	prometheus.MustRegister(gaugeJobs)
 	prometheus.MustRegister(gaugeJobSubmission)
+	prometheus.MustRegister(gaugeGeoIPUpdate)
+	prometheus.MustRegister(counterSubmissions)
+	prometheus.MustRegister(counterResults)
+	prometheus.MustRegister(counterRows)
+	prometheus.MustRegister(counterDBErrors)
+	prometheus.MustRegister(histRequests)
+	prometheus.MustRegister(gaugeDBSize)
+	prometheus.MustRegister(gaugeNotifyQueue)
+	prometheus.MustRegister(gaugeIngestQueue)
+	prometheus.MustRegister(gaugeAgentSeen)
+	prometheus.MustRegister(gaugeAgentScan)
+}
+
+// dbError counts err, if not nil, against the database operation op, and
+// returns it.
+func dbError(op string, err error) error {
+	if err != nil {
+		counterDBErrors.WithLabelValues(op).Inc()
+	}
+	return err
+}
+
+// routeLabel returns the route pattern which matched r, so requests for
+// different IPs or jobs are counted together.
+func routeLabel(r *http.Request) string {
+	rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context)
+	if !ok {
+		return "none"
+	}
+	if pattern := rctx.RoutePattern(); pattern != "" {
+		return pattern
+	}
+	return "none"
+}
+
+// instrument records the latency of each request.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+		histRequests.WithLabelValues(r.Method, routeLabel(r), strconv.Itoa(code)).
+			Observe(time.Since(start).Seconds())
+	})
 }
 
 func (app *App) metrics() http.Handler {
@@ -84,5 +221,13 @@ func (app *App) metrics() http.Handler {
 	sub, _ := app.db.LoadSubmission(sqlite.SQLFilter{})
 	gaugeSubmission.Set(float64(sub.Time.Unix()))
 
+	agents, _ := app.db.LoadAgents()
+	for _, a := range agents {
+		gaugeAgentSeen.WithLabelValues(a.Name).Set(float64(a.LastSeen.Unix()))
+		if a.LastScan != nil {
+			gaugeAgentScan.WithLabelValues(a.Name).Set(float64(a.LastScan.Unix()))
+		}
+	}
+
 	return promhttp.Handler()
 }
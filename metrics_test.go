@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestRouteLabel(t *testing.T) {
+	var got string
+	r := chi.NewRouter()
+	r.Get("/traceroute/{ip}", func(w http.ResponseWriter, r *http.Request) {
+		got = routeLabel(r)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/traceroute/192.0.2.1", nil))
+	if got != "/traceroute/{ip}" {
+		t.Errorf("expected the route pattern, got %q", got)
+	}
+
+	if got := routeLabel(httptest.NewRequest("GET", "/", nil)); got != "none" {
+		t.Errorf("expected none outside a router, got %q", got)
+	}
+}
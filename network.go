@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// networkName allows descriptive names such as "Production DMZ", which are
+// quoted in a search.
+var networkName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 _.-]{0,63}$`)
+
+// checkNetwork validates a named network and tidies its name and CIDRs.
+func checkNetwork(n *scan.Network) error {
+	n.Name = strings.TrimSpace(n.Name)
+	if !networkName.MatchString(n.Name) {
+		return errors.New("network names must be letters, digits, spaces, dots, dashes and underscores")
+	}
+	if len(n.CIDRs) == 0 {
+		return errors.New("a network needs at least one CIDR")
+	}
+	for i, cidr := range n.CIDRs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		n.CIDRs[i] = network.String()
+	}
+	return checkWindows(*n)
+}
+
+// auditNetwork records a change to a named network in the audit log.
+func (app *App) auditNetwork(user *User, event string, n scan.Network) {
+	info, _ := json.Marshal(n)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditNetwork: error saving %s for %s: %v", event, n.Name, err)
+	}
+}
+
+// networkReport summarises the results in a named network.
+type networkReport struct {
+	Network string `json:"network"`
+	Total   int    `json:"total"`
+	Open    int    `json:"open"`
+	New     int    `json:"new"`
+	Closed  int    `json:"closed"`
+}
+
+// Handler for GET /api/v1/networks
+func (app *App) listNetworks(w http.ResponseWriter, r *http.Request) {
+	networks, err := app.db.LoadNetworks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, networks)
+}
+
+// Handler for POST /api/v1/networks
+// Defining an existing network replaces its description and CIDRs.
+func (app *App) newNetwork(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var n scan.Network
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkNetwork(&n); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := app.db.SaveNetwork(n); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditNetwork(user, "add_network", n)
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, n)
+}
+
+// Handler for DELETE /api/v1/networks/{name}
+func (app *App) deleteNetwork(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	n := scan.Network{Name: chi.URLParam(r, "name")}
+	err := app.db.DeleteNetwork(n.Name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Network not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditNetwork(user, "delete_network", n)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for GET /api/v1/networks/report
+// This counts the ports in each named network which are open, new and
+// closed, like the totals on the index page.
+func (app *App) networkReport(w http.ResponseWriter, r *http.Request) {
+	networks, err := app.db.LoadNetworks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report := []networkReport{}
+	for _, n := range networks {
+		data, _, err := app.db.ResultPage(sqlite.ResultQuery{Networks: []string{n.Name}, View: sqlite.ViewAll, PerPage: 1})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		report = append(report, networkReport{
+			Network: n.Name,
+			Total:   data.Total,
+			Open:    data.Latest,
+			New:     data.New,
+			Closed:  data.Closed,
+		})
+	}
+	render.JSON(w, r, report)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestNetworks(t *testing.T) {
+	db := createDB("TestNetworks")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.130", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "2001:db8::1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Post(ts.URL+"/api/v1/networks", "application/json", bytes.NewBufferString(`{"name": "DMZ", "cidrs": ["192.0.2.0/25"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without a user, got %d", res.StatusCode)
+	}
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "user@example.com"}}}
+
+	for _, tt := range []struct {
+		network string
+		code    int
+	}{
+		{`{"name": "Production DMZ", "cidrs": ["192.0.2.0/25", "2001:db8::/32"]}`, http.StatusCreated},
+		{`{"name": "Guest WiFi", "cidrs": ["192.0.2.128/25"]}`, http.StatusCreated},
+		// Host bits are masked
+		{`{"name": "Office", "cidrs": ["198.51.100.7/24"]}`, http.StatusCreated},
+		{`{"name": "Empty", "cidrs": []}`, http.StatusBadRequest},
+		{`{"name": "Bad", "cidrs": ["192.0.2.0/33"]}`, http.StatusBadRequest},
+		{`{"name": "Bad/name", "cidrs": ["192.0.2.0/24"]}`, http.StatusBadRequest},
+	} {
+		res, err := http.Post(ts.URL+"/api/v1/networks", "application/json", bytes.NewBufferString(tt.network))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.network, tt.code, res.StatusCode)
+		}
+	}
+
+	networks, err := db.LoadNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 3 || networks[1].Name != "Office" || networks[1].CIDRs[0] != "198.51.100.0/24" || len(networks[2].CIDRs) != 2 {
+		t.Errorf("unexpected networks %+v", networks)
+	}
+
+	for _, tt := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"network": {"Production DMZ"}}, 2},
+		{url.Values{"q": {`network:"Production DMZ" network:"Guest WiFi"`}}, 3},
+		{url.Values{"q": {`network:Office port:22`}}, 0},
+		{url.Values{"network": {"Nowhere"}}, 0},
+	} {
+		query, err := resultQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query.View = sqlite.ViewAll
+		data, _, err := db.ResultPage(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results) != tt.want {
+			t.Errorf("%v: expected %d results, got %d", tt.query, tt.want, len(data.Results))
+		}
+	}
+
+	data, err := db.LoadData(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{"192.0.2.130"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || len(data[0].Networks) != 1 || data[0].Networks[0] != "Guest WiFi" {
+		t.Errorf("expected 192.0.2.130 in Guest WiFi, got %+v", data)
+	}
+
+	res, err = http.Get(ts.URL + "/api/v1/networks/report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report []networkReport
+	if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	want := map[string]int{"Guest WiFi": 1, "Office": 1, "Production DMZ": 2}
+	if len(report) != len(want) {
+		t.Fatalf("expected a report on %d networks, got %+v", len(want), report)
+	}
+	for _, r := range report {
+		if r.Total != want[r.Network] || r.Open != want[r.Network] {
+			t.Errorf("%s: expected %d open ports, got %+v", r.Network, want[r.Network], r)
+		}
+	}
+
+	req, _ := http.NewRequest("DELETE", ts.URL+"/api/v1/networks/Guest%20WiFi", nil)
+	for _, code := range []int{http.StatusNoContent, http.StatusNotFound} {
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("expected status %d deleting network, got %d", code, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// maxNote limits the length of a note, which is meant for triage context
+// rather than full write-ups.
+const maxNote = 4096
+
+// checkNote validates a note on a host, or a single port on it, and tidies
+// its IP and text.
+func checkNote(n *scan.Note) error {
+	n.IP = scan.NormalizeIP(n.IP)
+	n.Text = strings.TrimSpace(n.Text)
+	if net.ParseIP(n.IP) == nil {
+		return fmt.Errorf("invalid IP %q", n.IP)
+	}
+	if n.Port < 0 || n.Port > 65535 {
+		return fmt.Errorf("invalid port %d", n.Port)
+	}
+	switch n.Proto {
+	case "", "tcp", "udp":
+	default:
+		return fmt.Errorf("invalid proto %q", n.Proto)
+	}
+	if (n.Port == 0) != (n.Proto == "") {
+		return errors.New("port and proto must be set together")
+	}
+	if n.Text == "" {
+		return errors.New("note is empty")
+	}
+	if len(n.Text) > maxNote {
+		return fmt.Errorf("notes are limited to %d bytes", maxNote)
+	}
+	return nil
+}
+
+// auditNote records adding or deleting a note in the audit log.
+func (app *App) auditNote(user *User, event string, n scan.Note) {
+	info, _ := json.Marshal(n)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditNote: error saving %s for %s: %v", event, n.IP, err)
+	}
+}
+
+// addNote saves a note from user, which checkNote has validated.
+func (app *App) addNote(n scan.Note, user *User) (scan.Note, error) {
+	n.Author = user.Email
+	n.Created = scan.Time{Time: time.Now().UTC()}
+	id, err := app.db.SaveNote(n)
+	if err != nil {
+		return n, err
+	}
+	n.ID = id
+	app.auditNote(user, "add_note", n)
+	return n, nil
+}
+
+// removeNote deletes the note with the given ID on behalf of user. Only the
+// author may delete a note, so nobody else's triage context is lost.
+func (app *App) removeNote(id int64, user *User) (int, error) {
+	notes, err := app.db.LoadNotes(sqlite.SQLFilter{Where: []string{`id=?`}, Values: []interface{}{id}})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if len(notes) == 0 {
+		return http.StatusNotFound, errors.New("Note not found")
+	}
+	n := notes[0]
+	if n.Author != user.Email {
+		return http.StatusForbidden, errors.New("Only the author can delete a note")
+	}
+
+	err = app.db.DeleteNote(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, errors.New("Note not found")
+	case err != nil:
+		return http.StatusInternalServerError, err
+	}
+	app.auditNote(user, "delete_note", n)
+	return http.StatusNoContent, nil
+}
+
+// Handler for GET /api/v1/notes
+// Notes can be narrowed with the ip, port and proto query parameters. A port
+// of 0 selects notes on the whole host.
+func (app *App) listNotes(w http.ResponseWriter, r *http.Request) {
+	filter, err := portFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	notes, err := app.db.LoadNotes(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, notes)
+}
+
+// Handler for POST /api/v1/notes
+func (app *App) newNote(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var n scan.Note
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkNote(&n); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n, err := app.addNote(n, user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, n)
+}
+
+// Handler for DELETE /api/v1/notes/{id}
+func (app *App) deleteNote(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	code, err := app.removeNote(id, user)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.WriteHeader(code)
+}
+
+// Handler for POST /host/{ip}/notes
+// This adds the text form value as a note on the host, or on the port given
+// as port/proto, or removes the delete_note form value, then returns to the
+// host's page.
+func (app *App) hostNotesForm(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ip := scan.NormalizeIP(chi.URLParam(r, "ip"))
+
+	if text := r.PostForm.Get("text"); text != "" {
+		n := scan.Note{IP: ip, Text: text}
+		if port := r.PostForm.Get("port"); port != "" {
+			p := strings.SplitN(port, "/", 2)
+			if len(p) != 2 {
+				http.Error(w, "Port must be given as port/proto", http.StatusBadRequest)
+				return
+			}
+			var err error
+			if n.Port, err = strconv.Atoi(p[0]); err != nil {
+				http.Error(w, "Invalid port", http.StatusBadRequest)
+				return
+			}
+			n.Proto = p[1]
+		}
+		if err := checkNote(&n); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := app.addNote(n, user); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if del := r.PostForm.Get("delete_note"); del != "" {
+		id, err := strconv.ParseInt(del, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid note ID", http.StatusBadRequest)
+			return
+		}
+		if code, err := app.removeNote(id, user); err != nil && code != http.StatusNotFound {
+			http.Error(w, err.Error(), code)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/host/"+ip, http.StatusSeeOther)
+}
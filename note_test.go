@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestNotes(t *testing.T) {
+	db := createDB("TestNotes")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Post(ts.URL+"/api/v1/notes", "application/json", bytes.NewBufferString(`{"ip": "192.0.2.1", "text": "Bastion"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without a user, got %d", res.StatusCode)
+	}
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "user@example.com"}}}
+
+	for _, tt := range []struct {
+		note string
+		code int
+	}{
+		{`{"ip": "192.0.2.1", "text": "Bastion host"}`, http.StatusCreated},
+		{`{"ip": "192.0.2.1", "port": 22, "proto": "tcp", "text": "Key auth only"}`, http.StatusCreated},
+		{`{"ip": "192.0.2.1", "port": 22, "text": "No proto"}`, http.StatusBadRequest},
+		{`{"ip": "192.0.2.1", "text": "  "}`, http.StatusBadRequest},
+		{`{"ip": "192.0.2.300", "text": "Bad IP"}`, http.StatusBadRequest},
+	} {
+		res, err := http.Post(ts.URL+"/api/v1/notes", "application/json", bytes.NewBufferString(tt.note))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.note, tt.code, res.StatusCode)
+		}
+	}
+
+	res, err = http.Get(ts.URL + "/api/v1/notes?ip=192.0.2.1&port=22&proto=tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notes []scan.Note
+	if err := json.NewDecoder(res.Body).Decode(&notes); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(notes) != 1 || notes[0].Text != "Key auth only" || notes[0].Author != "user@example.com" {
+		t.Fatalf("expected a note on 22/tcp by user@example.com, got %+v", notes)
+	}
+	portNote := notes[0].ID
+
+	// Ports count their own notes and the host's
+	data, err := db.LoadData(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{"192.0.2.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range data {
+		want := 1
+		if d.Port == 22 {
+			want = 2
+		}
+		if d.Notes != want {
+			t.Errorf("expected %d notes on port %d, got %d", want, d.Port, d.Notes)
+		}
+	}
+
+	// The host page's form adds notes to a port
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err = client.PostForm(ts.URL+"/host/192.0.2.1/notes", url.Values{"text": {"Default vhost"}, "port": {"80/tcp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/host/192.0.2.1" {
+		t.Errorf("expected redirect to the host page, got %d %s", res.StatusCode, res.Header.Get("Location"))
+	}
+	notes, err = db.LoadNotes(sqlite.SQLFilter{Where: []string{`port=?`}, Values: []interface{}{80}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].Text != "Default vhost" {
+		t.Errorf("expected a note on 80/tcp, got %+v", notes)
+	}
+
+	// Only the author can delete a note
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "other@example.com"}}}
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/api/v1/notes/%d", ts.URL, portNote), nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected status 403 deleting someone else's note, got %d", res.StatusCode)
+	}
+
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "user@example.com"}}}
+	for _, code := range []int{http.StatusNoContent, http.StatusNotFound} {
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("expected status %d deleting note, got %d", code, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// Event types sent to notifiers
+const (
+	eventNewPort       = "new_port"
+	eventPortGone      = "port_gone"
+	eventBannerChanged = "banner_changed"
+	eventViolation     = "baseline_violation"
+	eventQuota         = "db_quota"
+	eventCertExpiring  = "cert_expiring"
+)
+
+// event describes a change in the results which notifiers are told about.
+// Banners holds the current banner of each service seen on the port, and
+// Severity is set by the alert rule the event matched, if any. A banner
+// change event describes the Change, and a certificate expiry event the
+// Certificate.
+// A summary event has no port; instead it counts the New and Gone ports,
+// Changed banners, baseline Violations and Expiring certificates, in Events.
+// A quota event has no port either, only the database Size and Quota in
+// megabytes.
+type event struct {
+	Type        string             `json:"event"`
+	Time        time.Time          `json:"time"`
+	IP          string             `json:"ip,omitempty"`
+	Port        int                `json:"port,omitempty"`
+	Proto       string             `json:"proto,omitempty"`
+	Banners     map[string]string  `json:"banners,omitempty"`
+	Change      *scan.BannerChange `json:"change,omitempty"`
+	Certificate *scan.Certificate  `json:"certificate,omitempty"`
+	Severity    string             `json:"severity,omitempty"`
+	New         int                `json:"new,omitempty"`
+	Gone        int                `json:"gone,omitempty"`
+	Changed     int                `json:"changed,omitempty"`
+	Violations  int                `json:"violations,omitempty"`
+	Expiring    int                `json:"expiring,omitempty"`
+	Events      []event            `json:"events,omitempty"`
+	Size        int64              `json:"size,omitempty"`
+	Quota       int64              `json:"quota,omitempty"`
+}
+
+// notifier sends events to an external service. Alert rules select notifiers
+// by name.
+type notifier interface {
+	name() string
+	notify(e event) error
+}
+
+// notify sends events to notifiers in the background, as decided by the
+// alert rules. Events for acknowledged ports are dropped. Events are sent in
+// order, and a failure to send to one notifier doesn't stop the others. If
+// the app has a batcher, events are queued in batches instead of being sent
+// straight away.
+func (app *App) notify(events []event) {
+	if len(app.notifiers) == 0 || len(events) == 0 {
+		return
+	}
+	rules := app.alertRules()
+	acked := app.ackedPorts(time.Now())
+
+	type delivery struct {
+		event
+		rule     *alertRule
+		channels map[string]bool
+	}
+	var deliveries []delivery
+	for _, e := range events {
+		if acked[portKey(e.IP, e.Port, e.Proto)] {
+			continue
+		}
+		if rules.needBanners() {
+			banners, err := app.db.LoadBanners(e.IP, e.Port, e.Proto)
+			if err != nil {
+				log.Printf("notify: error loading banners for %s:%d/%s: %v", e.IP, e.Port, e.Proto, err)
+			}
+			e.Banners = banners
+		}
+		rule := rules.match(e)
+		if rule == nil {
+			deliveries = append(deliveries, delivery{event: e})
+			continue
+		}
+		if rule.Action == actionIgnore {
+			continue
+		}
+		e.Severity = rule.Severity
+		deliveries = append(deliveries, delivery{e, rule, rule.channels()})
+	}
+
+	if app.batcher != nil {
+		now := time.Now()
+		for _, d := range deliveries {
+			for _, n := range app.notifiers {
+				if d.channels == nil || d.channels[n.name()] {
+					app.batcher.add(now, n.name(), d.rule, d.event)
+				}
+			}
+		}
+		app.flushNotifications(now)
+		return
+	}
+
+	go func() {
+		for _, d := range deliveries {
+			e := d.event
+			for _, n := range app.notifiers {
+				if d.channels != nil && !d.channels[n.name()] {
+					continue
+				}
+				if err := n.notify(e); err != nil {
+					log.Printf("notify: error sending %s event for %s:%d/%s: %v", e.Type, e.IP, e.Port, e.Proto, err)
+				}
+			}
+		}
+	}()
+}
+
+// portKey uniquely identifies a port on a host.
+func portKey(ip string, port int, proto string) string {
+	return fmt.Sprintf("%s:%d/%s", ip, port, proto)
+}
+
+// notifyChanges sends an event for each port added by the submission at now,
+// for each port it closed and for each banner it changed, and a violation
+// event for each added port outside the baseline. job is the
+// submission's job ID, or nil for a full scan.
+func (app *App) notifyChanges(now time.Time, added []scan.IPInfo, job *int64) {
+	if len(app.notifiers) == 0 {
+		return
+	}
+	closed, err := app.db.LoadClosedBy(now, job)
+	if err != nil {
+		log.Println("notifyChanges: error loading closed ports:", err)
+	}
+	var events []event
+	for _, r := range added {
+		events = append(events, event{Type: eventNewPort, Time: now, IP: r.IP, Port: r.Port, Proto: r.Proto})
+	}
+	events = append(events, app.violationEvents(now, added)...)
+	for _, r := range closed {
+		events = append(events, event{Type: eventPortGone, Time: now, IP: r.IP, Port: r.Port, Proto: r.Proto})
+	}
+	changes, err := app.db.LoadBannerChanges(sqlite.SQLFilter{Where: []string{`recorded = ?`}, Values: []interface{}{now.UTC()}})
+	if err != nil {
+		log.Println("notifyChanges: error loading banner changes:", err)
+	}
+	for i, c := range changes {
+		events = append(events, event{Type: eventBannerChanged, Time: now, IP: c.IP, Port: c.Port, Proto: c.Proto, Change: &changes[i]})
+	}
+	app.notify(events)
+}
+
+// postJSON POSTs v as JSON to rawurl, and returns an error if the response
+// status isn't 2xx. Chat services put secrets in their webhook URLs, so
+// errors refer to the service by name and never include the URL.
+func postJSON(client *http.Client, name, rawurl string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	res, err := client.Post(rawurl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", name, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// nucleiQueueSize is how many new ports can wait to be scanned. Ports
+// beyond that are skipped rather than holding up submissions.
+const nucleiQueueSize = 1000
+
+// nucleiScanner runs nuclei against new HTTP(S) ports.
+type nucleiScanner struct {
+	// ports are the ranges of TCP ports scanned
+	ports   [][2]int
+	timeout time.Duration
+	queue   chan scan.IPInfo
+	// args are passed to nuclei before the target
+	args []string
+	// run runs nuclei with args and returns its output
+	run func(ctx context.Context, args []string) ([]byte, error)
+}
+
+func newNucleiScanner(path string, templates []string, ports [][2]int, timeout time.Duration) *nucleiScanner {
+	args := []string{"-jsonl", "-silent"}
+	for _, t := range templates {
+		args = append(args, "-t", t)
+	}
+	return &nucleiScanner{
+		ports:   ports,
+		timeout: timeout,
+		queue:   make(chan scan.IPInfo, nucleiQueueSize),
+		args:    args,
+		run: func(ctx context.Context, args []string) ([]byte, error) {
+			return exec.CommandContext(ctx, path, args...).Output()
+		},
+	}
+}
+
+// enqueue queues the HTTP(S) ports among added to be scanned. It does
+// nothing if nuclei isn't configured.
+func (n *nucleiScanner) enqueue(added []scan.IPInfo) {
+	if n == nil {
+		return
+	}
+	for _, r := range added {
+		if r.Proto != "tcp" || !inPortRanges(n.ports, r.Port) {
+			continue
+		}
+		select {
+		case n.queue <- r:
+		default:
+			log.Printf("nuclei: queue full, not scanning %s port %d", r.IP, r.Port)
+		}
+	}
+}
+
+// inPortRanges reports whether port is in any of ranges.
+func inPortRanges(ranges [][2]int, port int) bool {
+	for _, r := range ranges {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// nucleiResult is the part of a line of nuclei's JSONL output which is used.
+type nucleiResult struct {
+	TemplateID string `json:"template-id"`
+	Info       struct {
+		Name     string `json:"name"`
+		Severity string `json:"severity"`
+	} `json:"info"`
+	MatchedAt string `json:"matched-at"`
+}
+
+// scan runs nuclei against a port. The target has no scheme so nuclei
+// probes for HTTP and HTTPS itself.
+func (n *nucleiScanner) scan(r scan.IPInfo, now time.Time) ([]scan.Finding, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	target := net.JoinHostPort(r.IP, strconv.Itoa(r.Port))
+	out, err := n.run(ctx, append(append([]string{}, n.args...), "-u", target))
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []scan.Finding
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		var res nucleiResult
+		if err := json.Unmarshal(s.Bytes(), &res); err != nil || res.TemplateID == "" {
+			continue
+		}
+		findings = append(findings, scan.Finding{
+			IP:       r.IP,
+			Port:     r.Port,
+			Proto:    r.Proto,
+			Template: res.TemplateID,
+			Name:     res.Info.Name,
+			Severity: res.Info.Severity,
+			Matched:  res.MatchedAt,
+			Found:    scan.Time{Time: now.UTC()},
+		})
+	}
+	return findings, s.Err()
+}
+
+// runNuclei scans queued ports one at a time.
+func (app *App) runNuclei() {
+	for r := range app.nuclei.queue {
+		app.nucleiScan(r, time.Now())
+	}
+}
+
+// nucleiScan scans a port and saves what nuclei finds.
+func (app *App) nucleiScan(r scan.IPInfo, now time.Time) {
+	findings, err := app.nuclei.scan(r, now)
+	if err != nil {
+		log.Printf("nuclei: error scanning %s port %d: %v", r.IP, r.Port, err)
+		return
+	}
+	if err := app.db.SaveFindings(findings); err != nil {
+		log.Printf("nuclei: error saving findings for %s port %d: %v", r.IP, r.Port, err)
+		return
+	}
+	if verbose {
+		log.Printf("nuclei: %d findings on %s port %d", len(findings), r.IP, r.Port)
+	}
+}
+
+// Handler for GET /api/v1/findings
+// Findings can be narrowed with the ip, port and severity query parameters.
+func (app *App) listFindings(w http.ResponseWriter, r *http.Request) {
+	var filter sqlite.SQLFilter
+	q := r.URL.Query()
+	if ip := q.Get("ip"); ip != "" {
+		filter.Where = append(filter.Where, `ip = ?`)
+		filter.Values = append(filter.Values, scan.NormalizeIP(ip))
+	}
+	if port := q.Get("port"); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			http.Error(w, "invalid port", http.StatusBadRequest)
+			return
+		}
+		filter.Where = append(filter.Where, `port = ?`)
+		filter.Values = append(filter.Values, p)
+	}
+	if severity := q.Get("severity"); severity != "" {
+		filter.Where = append(filter.Where, `severity = ?`)
+		filter.Values = append(filter.Values, severity)
+	}
+	findings, err := app.db.LoadFindings(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, findings)
+}
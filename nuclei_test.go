@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestNuclei(t *testing.T) {
+	db := createDB("TestNuclei")
+	defer db.Close()
+
+	ports, _ := scan.ParsePortSpec("80,443,8000-8999")
+	nuclei := newNucleiScanner("nuclei", []string{"http/exposures", "http/misconfiguration"}, ports, time.Minute)
+	var ran [][]string
+	nuclei.run = func(ctx context.Context, args []string) ([]byte, error) {
+		ran = append(ran, args)
+		return []byte(`{"template-id":"git-config","info":{"name":"Git Config - Detect","severity":"medium"},"type":"http","host":"http://192.0.2.1:8080","matched-at":"http://192.0.2.1:8080/.git/config","timestamp":"2020-06-01T12:00:00Z"}
+not json
+{"template-id":"tech-detect","info":{"name":"Wappalyzer Technology Detection","severity":"info"},"type":"http","host":"http://192.0.2.1:8080","matched-at":"http://192.0.2.1:8080"}
+`), nil
+	}
+	app := App{db: db, nuclei: nuclei}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 8080, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 443, Proto: "udp", Status: "open"}}},
+	}
+	now := time.Now().UTC()
+	_, added, err := db.SaveData(results, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only new HTTP(S) TCP ports are queued
+	app.nuclei.enqueue(added)
+	var queued []scan.IPInfo
+	for len(app.nuclei.queue) > 0 {
+		queued = append(queued, <-app.nuclei.queue)
+	}
+	if len(queued) != 1 || queued[0].Port != 8080 {
+		t.Fatalf("expected only 8080/tcp queued, got %+v", queued)
+	}
+	// A scanner which isn't configured does nothing
+	var disabled *nucleiScanner
+	disabled.enqueue(added)
+
+	app.nucleiScan(queued[0], now)
+	want := []string{"-jsonl", "-silent", "-t", "http/exposures", "-t", "http/misconfiguration", "-u", "192.0.2.1:8080"}
+	if len(ran) != 1 || !reflect.DeepEqual(ran[0], want) {
+		t.Errorf("expected nuclei run with %v, got %v", want, ran)
+	}
+	findings, err := db.LoadFindings(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 2 {
+		t.Fatalf("expected 2 findings, got %+v", findings)
+	}
+	if f := findings[0]; f.Template != "git-config" || f.Severity != "medium" || f.Matched != "http://192.0.2.1:8080/.git/config" || f.Port != 8080 {
+		t.Errorf("unexpected finding %+v", f)
+	}
+
+	// Scanning again updates the findings rather than duplicating them
+	app.nucleiScan(queued[0], now.Add(time.Hour))
+	if findings, _ := db.LoadFindings(sqlite.SQLFilter{}); len(findings) != 2 {
+		t.Errorf("expected findings updated, got %+v", findings)
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/findings?ip=192.0.2.1&severity=medium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []scan.Finding
+	err = json.NewDecoder(res.Body).Decode(&listed)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Template != "git-config" {
+		t.Errorf("expected the medium finding, got %+v", listed)
+	}
+
+	res, err = http.Get(ts.URL + "/host/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	for _, want := range []string{"Git Config - Detect", "<code>git-config</code>", "label-warning\">medium"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("expected %q on the host page", want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// criticalRule matches ports which are critical exposures. A zero port or an
+// empty proto matches any, and a nil network matches any public IP. Internal
+// hosts often listen on ports such as SMB legitimately, so they're only
+// critical when a rule names their network.
+type criticalRule struct {
+	port    int
+	proto   string
+	network *net.IPNet
+}
+
+// parseCriticalRules parses a comma-separated list of rules in the form
+// port[/proto][@cidr], e.g. "3389,445/tcp@192.0.2.0/24".
+func parseCriticalRules(s string) ([]criticalRule, error) {
+	var rules []criticalRule
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		var rule criticalRule
+		if i := strings.Index(spec, "@"); i >= 0 {
+			_, network, err := net.ParseCIDR(spec[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %v", spec, err)
+			}
+			rule.network = network
+			spec = spec[:i]
+		}
+		if i := strings.Index(spec, "/"); i >= 0 {
+			rule.proto = spec[i+1:]
+			spec = spec[:i]
+		}
+		port, err := strconv.Atoi(spec)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("rule %q: invalid port", spec)
+		}
+		rule.port = port
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r criticalRule) match(e event) bool {
+	if r.port != 0 && r.port != e.Port {
+		return false
+	}
+	if r.proto != "" && r.proto != e.Proto {
+		return false
+	}
+	ip := net.ParseIP(e.IP)
+	if ip == nil {
+		return false
+	}
+	if r.network == nil {
+		return isPublicIP(ip)
+	}
+	return r.network.Contains(ip)
+}
+
+// pagerDuty sends events for critical ports to the PagerDuty Events API v2.
+// A new port triggers an incident, which is resolved when the port closes.
+type pagerDuty struct {
+	url        string
+	routingKey string
+	rules      []criticalRule
+	client     *http.Client
+}
+
+func newPagerDuty(routingKey string, rules []criticalRule) *pagerDuty {
+	return &pagerDuty{
+		url:        pagerDutyEventsURL,
+		routingKey: routingKey,
+		rules:      rules,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// critical reports whether the event is critical, either because an alert
+// rule says so or it matches one of the critical port rules.
+func (pd *pagerDuty) critical(e event) bool {
+	if e.Severity == "critical" {
+		return true
+	}
+	for _, r := range pd.rules {
+		if r.match(e) {
+			return true
+		}
+	}
+	return false
+}
+
+type pagerDutyPayload struct {
+	Summary   string    `json:"summary"`
+	Source    string    `json:"source"`
+	Severity  string    `json:"severity"`
+	Timestamp time.Time `json:"timestamp"`
+	Component string    `json:"component"`
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+func (pd *pagerDuty) name() string { return "pagerduty" }
+
+func (pd *pagerDuty) notify(e event) error {
+	// Incidents are per port, so summaries are sent as their events
+	if e.Type == eventSummary {
+		for _, se := range e.Events {
+			if err := pd.notify(se); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !pd.critical(e) {
+		return nil
+	}
+	pe := pagerDutyEvent{
+		RoutingKey: pd.routingKey,
+		DedupKey:   portKey(e.IP, e.Port, e.Proto),
+	}
+	switch e.Type {
+	case eventNewPort:
+		msg, err := message(defaultLang, e)
+		if err != nil {
+			return err
+		}
+		pe.EventAction = "trigger"
+		pe.Payload = &pagerDutyPayload{
+			Summary:   msg,
+			Source:    e.IP,
+			Severity:  "critical",
+			Timestamp: e.Time,
+			Component: fmt.Sprintf("%d/%s", e.Port, e.Proto),
+		}
+	case eventPortGone:
+		pe.EventAction = "resolve"
+	default:
+		return nil
+	}
+	return postJSON(pd.client, "pagerduty", pd.url, pe)
+}
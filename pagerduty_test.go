@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseCriticalRules(t *testing.T) {
+	rules, err := parseCriticalRules("3389, 445/tcp@192.0.2.0/24, 445@10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		e    event
+		want bool
+	}{
+		{event{IP: "198.51.100.1", Port: 3389, Proto: "tcp"}, true},
+		{event{IP: "198.51.100.1", Port: 3389, Proto: "udp"}, true},
+		{event{IP: "192.0.2.1", Port: 445, Proto: "tcp"}, true},
+		{event{IP: "192.0.2.1", Port: 445, Proto: "udp"}, false},
+		{event{IP: "198.51.100.1", Port: 445, Proto: "tcp"}, false},
+		{event{IP: "192.0.2.1", Port: 22, Proto: "tcp"}, false},
+		{event{IP: "10.0.0.1", Port: 3389, Proto: "tcp"}, false},
+		{event{IP: "fd00::1", Port: 3389, Proto: "tcp"}, false},
+		{event{IP: "10.1.0.1", Port: 445, Proto: "tcp"}, true},
+		{event{IP: "10.2.0.1", Port: 445, Proto: "tcp"}, false},
+	}
+	pd := newPagerDuty("key", rules)
+	for _, tt := range tests {
+		if got := pd.critical(tt.e); got != tt.want {
+			t.Errorf("critical(%+v): expected %v, got %v", tt.e, tt.want, got)
+		}
+	}
+
+	for _, bad := range []string{"ssh", "70000", "22@192.0.2.1"} {
+		if _, err := parseCriticalRules(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestPagerDuty(t *testing.T) {
+	events := make(chan pagerDutyEvent, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e pagerDutyEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		events <- e
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	rules, _ := parseCriticalRules("3389")
+	pd := newPagerDuty("key", rules)
+	pd.url = ts.URL
+
+	now := time.Now().UTC()
+	for _, e := range []event{
+		{Type: eventNewPort, Time: now, IP: "192.0.2.1", Port: 22, Proto: "tcp"},
+		{Type: eventNewPort, Time: now, IP: "192.0.2.1", Port: 3389, Proto: "tcp"},
+		{Type: eventPortGone, Time: now, IP: "192.0.2.1", Port: 3389, Proto: "tcp"},
+	} {
+		if err := pd.notify(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(events)
+
+	var got []pagerDutyEvent
+	for e := range events {
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %d: %+v", len(got), got)
+	}
+	if got[0].EventAction != "trigger" || got[0].Payload == nil || got[0].Payload.Severity != "critical" {
+		t.Errorf("expected critical trigger, got %+v", got[0])
+	}
+	if got[1].EventAction != "resolve" || got[1].DedupKey != got[0].DedupKey {
+		t.Errorf("expected resolve of %q, got %+v", got[0].DedupKey, got[1])
+	}
+	if got[0].RoutingKey != "key" {
+		t.Errorf("expected routing key %q, got %q", "key", got[0].RoutingKey)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// pdnsProvider queries a passive DNS provider which returns results in the
+// Passive DNS Common Output Format, such as CIRCL.
+type pdnsProvider struct {
+	url    string
+	user   string
+	pass   string
+	client *http.Client
+}
+
+// newPDNSProvider configures a provider. The IP is appended to baseURL to
+// form the query URL. auth is optional and is in the form user:password.
+func newPDNSProvider(baseURL, auth string) *pdnsProvider {
+	p := &pdnsProvider{url: baseURL, client: &http.Client{Timeout: 30 * time.Second}}
+	if auth != "" {
+		kv := strings.SplitN(auth, ":", 2)
+		p.user = kv[0]
+		if len(kv) > 1 {
+			p.pass = kv[1]
+		}
+	}
+	return p
+}
+
+// cofRecord is a Passive DNS Common Output Format record.
+type cofRecord struct {
+	RRName    string `json:"rrname"`
+	RRType    string `json:"rrtype"`
+	RData     string `json:"rdata"`
+	TimeFirst int64  `json:"time_first"`
+	TimeLast  int64  `json:"time_last"`
+}
+
+// query fetches the hostnames which have resolved to ip.
+func (p *pdnsProvider) query(ip string) ([]scan.PassiveDNS, error) {
+	req, err := http.NewRequest("GET", p.url+url.PathEscape(ip), nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.user != "" {
+		req.SetBasicAuth(p.user, p.pass)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	// Results are newline-delimited JSON objects
+	var records []scan.PassiveDNS
+	dec := json.NewDecoder(res.Body)
+	for {
+		var rec cofRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if (rec.RRType != "A" && rec.RRType != "AAAA") || rec.RData != ip {
+			continue
+		}
+		records = append(records, scan.PassiveDNS{
+			IP:        ip,
+			Hostname:  rec.RRName,
+			FirstSeen: scan.Time{Time: time.Unix(rec.TimeFirst, 0).UTC()},
+			LastSeen:  scan.Time{Time: time.Unix(rec.TimeLast, 0).UTC()},
+		})
+	}
+	return records, nil
+}
+
+var errInvalidPDNS = errors.New("each record requires a valid ip and hostname")
+
+// Handler for POST /pdns
+// The data is expected to be a JSON array of records. firstseen and lastseen
+// are optional and default to now.
+func (app *App) recvPassiveDNS(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "invalid Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var records []scan.PassiveDNS
+	if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, rec := range records {
+		if net.ParseIP(rec.IP) == nil || rec.Hostname == "" {
+			http.Error(w, errInvalidPDNS.Error(), http.StatusBadRequest)
+			return
+		}
+		records[i].IP = scan.NormalizeIP(rec.IP)
+		if rec.LastSeen.IsZero() {
+			records[i].LastSeen = scan.Time{Time: now}
+		}
+		if rec.FirstSeen.IsZero() {
+			records[i].FirstSeen = records[i].LastSeen
+		}
+	}
+
+	if err := app.db.SavePassiveDNS(records); err != nil {
+		log.Println("recvPassiveDNS: error saving records:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Handler for GET /api/v1/pdns/{ip}
+// If a provider is configured it is queried first, and any results are
+// stored alongside the records which have been submitted.
+func (app *App) passiveDNS(w http.ResponseWriter, r *http.Request) {
+	ip := scan.NormalizeIP(chi.URLParam(r, "ip"))
+	if net.ParseIP(ip) == nil {
+		http.Error(w, "Invalid IP", http.StatusBadRequest)
+		return
+	}
+
+	if app.pdnsProvider != nil {
+		records, err := app.pdnsProvider.query(ip)
+		if err != nil {
+			log.Printf("passiveDNS: error querying provider for %s: %v", ip, err)
+		} else if err := app.db.SavePassiveDNS(records); err != nil {
+			log.Printf("passiveDNS: error saving records for %s: %v", ip, err)
+		}
+	}
+
+	records, err := app.db.LoadPassiveDNS(ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, records)
+}
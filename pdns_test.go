@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPassiveDNS(t *testing.T) {
+	db := createDB("TestPassiveDNS")
+	defer db.Close()
+	app := App{db: db}
+
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintln(w, `{"rrname":"old.example.com","rrtype":"A","rdata":"192.0.2.1","time_first":1500000000,"time_last":1500086400}`)
+		fmt.Fprintln(w, `{"rrname":"example.com","rrtype":"MX","rdata":"mail.example.com","time_first":1500000000,"time_last":1500086400}`)
+	}))
+	defer provider.Close()
+	app.pdnsProvider = newPDNSProvider(provider.URL+"/", "user:secret")
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	data := []byte(`[{"ip":"192.0.2.1","hostname":"WWW.example.com."}]`)
+	resp, err := http.Post(ts.URL+"/pdns", "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %v", resp.StatusCode)
+	}
+
+	resp, err = http.Post(ts.URL+"/pdns", "application/json", bytes.NewReader([]byte(`[{"ip":"bogus","hostname":"example.com"}]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %v", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/api/v1/pdns/192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []struct{ Hostname string }
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Hostname != "www.example.com" || records[1].Hostname != "old.example.com" {
+		t.Errorf("expected www.example.com and old.example.com, got %+v", records)
+	}
+}
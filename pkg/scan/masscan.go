@@ -0,0 +1,66 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+)
+
+// masscanFinished matches the last line older versions of masscan write.
+var masscanFinished = regexp.MustCompile(`^\{\s*"?finished"?\s*:\s*1\s*\}$`)
+
+// masscanReader fixes up masscan's -oJ output as it's read.
+type masscanReader struct {
+	r   *bufio.Reader
+	buf []byte
+	n   int
+	eof bool
+}
+
+// MasscanJSON returns a reader of masscan's -oJ output from r as a JSON
+// array of results. masscan writes one result per line, but the commas
+// between them may trail the last, and older versions leave out the brackets
+// and end with {finished: 1}. Output with no results reads as [].
+func MasscanJSON(r io.Reader) io.Reader {
+	return &masscanReader{r: bufio.NewReader(r)}
+}
+
+func (m *masscanReader) Read(p []byte) (int, error) {
+	for len(m.buf) == 0 {
+		if m.eof {
+			return 0, io.EOF
+		}
+		line, err := m.r.ReadBytes('\n')
+		m.add(line)
+		switch err {
+		case nil:
+		case io.EOF:
+			m.eof = true
+			if m.n == 0 {
+				m.buf = append(m.buf, '[')
+			}
+			m.buf = append(m.buf, "]\n"...)
+		default:
+			return 0, err
+		}
+	}
+	n := copy(p, m.buf)
+	m.buf = m.buf[n:]
+	return n, nil
+}
+
+// add adds a line of masscan's output to the buffer, if it's a result.
+func (m *masscanReader) add(line []byte) {
+	line = bytes.Trim(line, ", \t\r\n")
+	if len(line) == 0 || bytes.Equal(line, []byte("[")) || bytes.Equal(line, []byte("]")) || masscanFinished.Match(line) {
+		return
+	}
+	if m.n == 0 {
+		m.buf = append(m.buf, '[')
+	} else {
+		m.buf = append(m.buf, ",\n"...)
+	}
+	m.buf = append(m.buf, line...)
+	m.n++
+}
@@ -0,0 +1,90 @@
+package scan
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+)
+
+// nmapRun is the part of nmap's -oX output which is imported. masscan's -oX
+// output uses the same format.
+type nmapRun struct {
+	Scanner string `xml:"scanner,attr"`
+	Version string `xml:"version,attr"`
+	Args    string `xml:"args,attr"`
+	Start   int64  `xml:"start,attr"`
+	Hosts   []struct {
+		StartTime int64 `xml:"starttime,attr"`
+		EndTime   int64 `xml:"endtime,attr"`
+		Addresses []struct {
+			Addr     string `xml:"addr,attr"`
+			AddrType string `xml:"addrtype,attr"`
+		} `xml:"address"`
+		Ports []struct {
+			Protocol string `xml:"protocol,attr"`
+			PortID   int    `xml:"portid,attr"`
+			State    struct {
+				State string `xml:"state,attr"`
+			} `xml:"state"`
+			Service struct {
+				Name      string `xml:"name,attr"`
+				Product   string `xml:"product,attr"`
+				Version   string `xml:"version,attr"`
+				ExtraInfo string `xml:"extrainfo,attr"`
+				Banner    string `xml:"banner,attr"`
+			} `xml:"service"`
+		} `xml:"ports>port"`
+	} `xml:"host"`
+}
+
+// ParseNmapXML reads nmap's or masscan's -oX output as results, one per open
+// port like masscan's JSON, timestamped with when the host was scanned.
+// Services are added as banner results, with masscan's banner or nmap's
+// product and version. It also returns the scanner and its arguments.
+func ParseNmapXML(r io.Reader) ([]Result, Run, error) {
+	var doc nmapRun
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, Run{}, err
+	}
+	run := Run{Scanner: strings.TrimSpace(doc.Scanner + " " + doc.Version), Args: doc.Args}
+
+	var results []Result
+	for _, h := range doc.Hosts {
+		var ip string
+		for _, a := range h.Addresses {
+			if a.AddrType == "ipv4" || a.AddrType == "ipv6" {
+				ip = a.Addr
+				break
+			}
+		}
+		if ip == "" {
+			continue
+		}
+		var ts Timestamp
+		for _, secs := range []int64{h.EndTime, h.StartTime, doc.Start} {
+			if secs > 0 {
+				ts = Timestamp{time.Unix(secs, 0).UTC()}
+				break
+			}
+		}
+		for _, p := range h.Ports {
+			if p.State.State != "open" {
+				continue
+			}
+			results = append(results, Result{IP: ip, Timestamp: ts, Ports: []Port{{Port: p.PortID, Proto: p.Protocol, Status: "open"}}})
+
+			banner := p.Service.Banner
+			if banner == "" {
+				banner = strings.Join(strings.Fields(p.Service.Product+" "+p.Service.Version+" "+p.Service.ExtraInfo), " ")
+			}
+			if p.Service.Name == "" || banner == "" {
+				continue
+			}
+			port := Port{Port: p.PortID, Proto: p.Protocol}
+			port.Service.Name, port.Service.Banner = p.Service.Name, banner
+			results = append(results, Result{IP: ip, Timestamp: ts, Ports: []Port{port}})
+		}
+	}
+	return results, run, nil
+}
@@ -1,6 +1,12 @@
 package scan
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,9 +22,40 @@ type Port struct {
 }
 
 // Result data posted from masscan.
+// Timestamp is when the scanner saw the result, if it says.
 type Result struct {
-	IP    string `json:"ip"`
-	Ports []Port `json:"ports"`
+	IP        string    `json:"ip"`
+	Timestamp Timestamp `json:"timestamp"`
+	Ports     []Port    `json:"ports"`
+	// Source is the scanner or API token which reported the result. It's
+	// set by the server, replacing any sent by the scanner.
+	Source string `json:"source,omitempty"`
+}
+
+// Timestamp is a time sent by a scanner. masscan sends seconds since the Unix
+// epoch as a string; a number or an RFC 3339 string are accepted too.
+type Timestamp struct {
+	time.Time
+}
+
+// UnmarshalJSON parses any of the accepted forms of timestamp. A null or
+// empty timestamp is the zero time.
+func (t *Timestamp) UnmarshalJSON(b []byte) error {
+	s := string(bytes.Trim(b, `"`))
+	if s == "" || s == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		t.Time = time.Unix(0, int64(secs*float64(time.Second))).UTC()
+		return nil
+	}
+	ts, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return err
+	}
+	t.Time = ts.UTC()
+	return nil
 }
 
 // Time wraps time.Time to implement a custom String method.
@@ -36,15 +73,49 @@ func (t Time) String() string {
 }
 
 // IPInfo is data retrieved from the database for display.
+// Gone is set when a completed scan covering the IP, port and protocol has
+// happened since the port was last seen, i.e. the port is now closed.
+// Reputation holds the names of any blocklists the IP appears on.
+// Hostnames are the names in the IP's PTR records followed by those passive
+// DNS has seen pointing at it.
+// Ack is set if the port has been acknowledged, in which case it's never New.
+// Self is set if the IP is one of the scan server's own addresses.
+// Fields holds the values of custom fields set on the host or port.
+// BannerChanged is set if the latest scan saw a service's banner change.
+// Tags are the names of the host's tags, and Notes counts the notes on the
+// host and port. Networks are the names of the networks containing the IP.
+// Violation is set if the port is outside the baseline, Geo is where the IP
+// is and Whois who announces and owns it, if they've been looked up.
+// AbuseScore is AbuseIPDB's abuse confidence score for the IP. CVEs counts
+// the CVEs the port's banner may be vulnerable to, and Severity is the most
+// severe of them. Certificate is the TLS certificate on the port, if it's
+// been probed and has one, and HTTP the port's response to a web request.
 type IPInfo struct {
-	IP            string
-	Port          int
-	Proto         string
-	FirstSeen     Time
-	LastSeen      Time
-	New           bool
-	Gone          bool
-	HasTraceroute bool
+	IP            string            `json:"ip"`
+	Port          int               `json:"port"`
+	Proto         string            `json:"proto"`
+	FirstSeen     Time              `json:"firstseen"`
+	LastSeen      Time              `json:"lastseen"`
+	New           bool              `json:"new"`
+	Gone          bool              `json:"closed"`
+	HasTraceroute bool              `json:"-"`
+	Reputation    []string          `json:"reputation,omitempty"`
+	Hostnames     []string          `json:"hostnames,omitempty"`
+	Ack           *Ack              `json:"ack,omitempty"`
+	Self          bool              `json:"self,omitempty"`
+	Fields        map[string]string `json:"fields,omitempty"`
+	BannerChanged bool              `json:"banner_changed,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Notes         int               `json:"notes,omitempty"`
+	Networks      []string          `json:"networks,omitempty"`
+	Violation     bool              `json:"violation,omitempty"`
+	Geo           *Geo              `json:"geo,omitempty"`
+	Whois         *Whois            `json:"whois,omitempty"`
+	AbuseScore    int               `json:"abuse_score,omitempty"`
+	CVEs          int               `json:"cves,omitempty"`
+	Severity      string            `json:"severity,omitempty"`
+	Certificate   *Certificate      `json:"certificate,omitempty"`
+	HTTP          *HTTPResponse     `json:"http,omitempty"`
 }
 
 // Data is used for display in the UI. It contains a summary of the number of
@@ -53,26 +124,813 @@ type Data struct {
 	Total    int
 	Latest   int
 	New      int
+	Closed   int
 	LastSeen int64
 	Results  []IPInfo
 }
 
 // Submission is used for display in the UI to show when and which host last
-// submitted results.
+// submitted results, and with which scanner run.
 type Submission struct {
-	Host string
-	Job  int64
-	Time Time
+	ID   int64  `json:"id"`
+	Host string `json:"host"`
+	Job  int64  `json:"job,omitempty"`
+	Time Time   `json:"time"`
+	Run
 }
 
+// Start returns when the submission's scan started. Ports it saw were last
+// seen at or after then.
+func (s Submission) Start() time.Time {
+	if s.Started != nil {
+		return s.Started.Time
+	}
+	return s.Time.Time
+}
+
+// Run describes the scanner run which produced a submission, as reported by
+// the scanner: its name and version, command line arguments and packet rate.
+// Started is when it saw its first result, if that was before the submission.
+// Scope is the addresses it scanned, as CIDRs, IPs or ranges, or empty if the
+// scanner didn't say, in which case it doesn't close any ports. Ports are the
+// ports it scanned, in masscan's -p syntax, or empty if it scanned them all.
+type Run struct {
+	Scanner string   `json:"scanner,omitempty"`
+	Args    string   `json:"args,omitempty"`
+	Rate    int      `json:"rate,omitempty"`
+	Started *Time    `json:"started,omitempty"`
+	Scope   []string `json:"scope,omitempty"`
+	Ports   string   `json:"ports,omitempty"`
+}
+
+// ScopeAll is the scope of a run which scanned every address.
+var ScopeAll = []string{"0.0.0.0/0", "::/0"}
+
 // Job represents a job to be sent to and received from scanning nodes,
+// Rate is the packet rate to scan at, or 0 for the scanner's own. Agent is
+// the agent the job is for, or empty for any. Target is the inventory entry
+// the job scans, if it was queued for one. Agents claim jobs before running
+// them.
 type Job struct {
 	ID          int    `json:"id"`
 	CIDR        string `json:"cidr"`
 	Ports       string `json:"ports"`
 	Proto       string `json:"proto"`
+	Rate        int    `json:"rate,omitempty"`
+	Agent       string `json:"agent,omitempty"`
+	Target      int64  `json:"target,omitempty"`
+	Tenant      string `json:"-"`
 	RequestedBy string `json:"-"`
 	Submitted   Time   `json:"-"`
 	Received    Time   `json:"-"`
 	Count       int64  `json:"-"`
+	ClaimedBy   string `json:"-"`
+	Claimed     Time   `json:"-"`
+}
+
+// Window is an estimated period during which a port was available. Open and
+// Close are the first and last times the port was observed. ClosedBy is the
+// time of the first scan which no longer saw the port, if there has been one.
+type Window struct {
+	IP           string  `json:"ip"`
+	Port         int     `json:"port"`
+	Proto        string  `json:"proto"`
+	Open         Time    `json:"open"`
+	Close        Time    `json:"close"`
+	ClosedBy     *Time   `json:"closed_by,omitempty"`
+	Availability float64 `json:"availability"`
+}
+
+// Period is a stretch of consecutive scans which saw a port open, from the
+// first observation in it to the last. ClosedBy is the time of the next scan,
+// which didn't see the port, or nil if none has since. Sources are the
+// scanners which saw it during the period, if known.
+type Period struct {
+	From     Time     `json:"from"`
+	To       Time     `json:"to"`
+	Scans    int      `json:"scans"`
+	ClosedBy *Time    `json:"closed_by,omitempty"`
+	Sources  []string `json:"sources,omitempty"`
+}
+
+// Timeline is the history of a port, as the periods it was seen open.
+type Timeline struct {
+	IP      string   `json:"ip"`
+	Port    int      `json:"port"`
+	Proto   string   `json:"proto"`
+	Periods []Period `json:"periods"`
+}
+
+// Source is a scanner or API token which has reported results, with how many
+// ports it has seen and when it last saw one.
+type Source struct {
+	Name     string `json:"name"`
+	Ports    int64  `json:"ports"`
+	LastSeen Time   `json:"last_seen"`
+}
+
+// SourceComparison is the ports seen by one source since a time but not by
+// another, each way round, such as ports reachable from outside a firewall
+// but not from inside. Both counts the ports they both saw.
+type SourceComparison struct {
+	A     string   `json:"a"`
+	B     string   `json:"b"`
+	Since Time     `json:"since"`
+	OnlyA []IPInfo `json:"only_a"`
+	OnlyB []IPInfo `json:"only_b"`
+	Both  int      `json:"both"`
+}
+
+// Covers reports whether the job's scan range includes the given IP, port and
+// protocol. To check many ports against the same job, use Range instead.
+func (j Job) Covers(ip string, port int, proto string) bool {
+	return j.Range().Covers(net.ParseIP(ip), port, proto)
+}
+
+// JobRange is a job's scan range, parsed once so that many ports can be
+// checked against it cheaply.
+type JobRange struct {
+	proto   string
+	network *net.IPNet
+	ports   [][2]int
+}
+
+// Range parses the job's CIDR and port specification. A job with an invalid
+// CIDR covers nothing.
+func (j Job) Range() JobRange {
+	r := JobRange{proto: strings.ToLower(j.Proto)}
+	if _, network, err := net.ParseCIDR(j.CIDR); err == nil {
+		r.network = network
+	} else if ip := net.ParseIP(j.CIDR); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		r.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+	r.ports = parsePortSpec(j.Ports)
+	return r
+}
+
+// Covers reports whether the range includes the given IP, port and protocol.
+func (r JobRange) Covers(ip net.IP, port int, proto string) bool {
+	if r.network == nil || ip == nil || r.proto != strings.ToLower(proto) {
+		return false
+	}
+	if !r.network.Contains(ip) {
+		return false
+	}
+	for _, p := range r.ports {
+		if port >= p[0] && port <= p[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeIP returns the canonical form of an IP address, such as the
+// compressed form of an IPv6 address, so each address is always stored the
+// same way. Invalid addresses are returned unchanged.
+func NormalizeIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
+// IPKey returns an IP address in a form which sorts in address order, so
+// ranges of addresses can be searched. IPv4 addresses are mapped into IPv6.
+// It returns nil if ip isn't valid.
+func IPKey(ip string) []byte {
+	return net.ParseIP(ip).To16()
+}
+
+// NetworkKeys returns the first and last IPKey in a network.
+func NetworkKeys(n *net.IPNet) (first, last []byte) {
+	first = n.IP.Mask(n.Mask).To16()
+	last = make([]byte, len(first))
+	copy(last, first)
+	offset := len(last) - len(n.Mask)
+	for i, m := range n.Mask {
+		last[offset+i] |= ^m
+	}
+	return first, last
+}
+
+// PortInRange reports whether port is included in a Masscan-style port
+// specification, such as "22,80,8000-9000".
+func PortInRange(spec string, port int) bool {
+	for _, p := range parsePortSpec(spec) {
+		if port >= p[0] && port <= p[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePortSpec parses a Masscan-style port specification, such as
+// "22,80,8000-9000", into inclusive ranges. Unlike a job's specification,
+// any invalid entry is an error.
+func ParsePortSpec(spec string) ([][2]int, error) {
+	ranges := parsePortSpec(spec)
+	if len(ranges) != len(strings.Split(spec, ",")) {
+		return nil, fmt.Errorf("invalid port specification %q", spec)
+	}
+	for _, r := range ranges {
+		if r[0] < 0 || r[1] > 65535 || r[0] > r[1] {
+			return nil, fmt.Errorf("invalid port range %d-%d", r[0], r[1])
+		}
+	}
+	return ranges, nil
+}
+
+// PortRange is an inclusive range of ports of one protocol.
+type PortRange struct {
+	Proto       string
+	First, Last int
+}
+
+// Contains reports whether the range includes port of proto.
+func (r PortRange) Contains(port int, proto string) bool {
+	return r.Proto == strings.ToLower(proto) && port >= r.First && port <= r.Last
+}
+
+// ParseMasscanPorts parses ports in masscan's -p syntax, such as
+// "22,80,8000-9000,U:53". Ports without a U: prefix are TCP.
+func ParseMasscanPorts(spec string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		proto := "tcp"
+		switch {
+		case strings.HasPrefix(p, "U:"):
+			proto, p = "udp", p[2:]
+		case strings.HasPrefix(p, "T:"):
+			p = p[2:]
+		}
+		r, err := ParsePortSpec(p)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, PortRange{Proto: proto, First: r[0][0], Last: r[0][1]})
+	}
+	return ranges, nil
+}
+
+// ParseAddrRange parses a CIDR, IP or first-last range into the IPKeys of its
+// first and last addresses.
+func ParseAddrRange(s string) (first, last []byte, ok bool) {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		first, last = NetworkKeys(network)
+		return first, last, true
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		first, last := net.ParseIP(s[:i]), net.ParseIP(s[i+1:])
+		if first == nil || last == nil {
+			return nil, nil, false
+		}
+		return first.To16(), last.To16(), true
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, nil, false
+	}
+	return ip.To16(), ip.To16(), true
+}
+
+// Coverage is a block of addresses and ports a scan covered: the IPKeys from
+// First to Last, the ports from FirstPort to LastPort, and Proto, or every
+// protocol if it's empty.
+type Coverage struct {
+	First, Last         []byte
+	FirstPort, LastPort int
+	Proto               string
+}
+
+// Coverage returns the blocks of addresses and ports the job's range covers.
+func (r JobRange) Coverage() []Coverage {
+	if r.network == nil {
+		return nil
+	}
+	first, last := NetworkKeys(r.network)
+	var c []Coverage
+	for _, p := range r.ports {
+		c = append(c, Coverage{First: first, Last: last, FirstPort: p[0], LastPort: p[1], Proto: r.proto})
+	}
+	return c
+}
+
+// ScopeCoverage returns the blocks of addresses and ports covered by a scan
+// of scope, as CIDRs, IPs and first-last ranges, and of ports, in masscan's
+// -p syntax, or every port if it's empty. Invalid ranges and ports cover
+// nothing.
+func ScopeCoverage(scope []string, ports string) []Coverage {
+	portRanges := []PortRange{{First: 0, Last: 65535}}
+	if ports != "" {
+		var err error
+		if portRanges, err = ParseMasscanPorts(ports); err != nil {
+			return nil
+		}
+	}
+	var c []Coverage
+	for _, s := range scope {
+		first, last, ok := ParseAddrRange(s)
+		if !ok {
+			continue
+		}
+		for _, p := range portRanges {
+			c = append(c, Coverage{First: first, Last: last, FirstPort: p.First, LastPort: p.Last, Proto: p.Proto})
+		}
+	}
+	return c
+}
+
+// parsePortSpec parses a port specification into inclusive ranges, skipping
+// any invalid entries.
+func parsePortSpec(spec string) [][2]int {
+	var ranges [][2]int
+	for _, r := range strings.Split(spec, ",") {
+		r = strings.TrimSpace(r)
+		lo, hi := r, r
+		if i := strings.Index(r, "-"); i >= 0 {
+			lo, hi = r[:i], r[i+1:]
+		}
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			continue
+		}
+		end, err := strconv.Atoi(hi)
+		if err != nil {
+			continue
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges
+}
+
+// Banner is a banner seen from a service on a port, and when.
+type Banner struct {
+	IP        string `json:"ip"`
+	Port      int    `json:"port"`
+	Proto     string `json:"proto"`
+	Service   string `json:"service"`
+	Banner    string `json:"banner"`
+	FirstSeen Time   `json:"firstseen"`
+	LastSeen  Time   `json:"lastseen"`
+}
+
+// BannerChange is a service banner which differs between two points in time.
+// Changed is when the new banner was first seen, if known.
+type BannerChange struct {
+	IP      string `json:"ip"`
+	Port    int    `json:"port"`
+	Proto   string `json:"proto"`
+	Service string `json:"service"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	Changed *Time  `json:"changed,omitempty"`
+}
+
+// Diff is the set of changes between two points in time.
+type Diff struct {
+	From        Time           `json:"from"`
+	To          Time           `json:"to"`
+	Appeared    []IPInfo       `json:"appeared"`
+	Disappeared []IPInfo       `json:"disappeared"`
+	Changed     []BannerChange `json:"changed"`
+}
+
+// PassiveDNS records that a hostname resolved to an IP between two times.
+type PassiveDNS struct {
+	IP        string `json:"ip"`
+	Hostname  string `json:"hostname"`
+	FirstSeen Time   `json:"firstseen"`
+	LastSeen  Time   `json:"lastseen"`
+}
+
+// RDNS is the names in an IP's PTR records, looked up at Updated.
+type RDNS struct {
+	IP      string   `json:"ip"`
+	Names   []string `json:"names"`
+	Updated Time     `json:"updated"`
+}
+
+// Exemption marks a host, or a single port on it, to be retained forever
+// rather than pruned. A zero Port or empty Proto matches any.
+type Exemption struct {
+	IP      string `json:"ip"`
+	Port    int    `json:"port,omitempty"`
+	Proto   string `json:"proto,omitempty"`
+	Reason  string `json:"reason"`
+	User    string `json:"user"`
+	Created Time   `json:"created"`
+}
+
+// IgnoreRule marks known noise, such as honeypots, other scanners and NAT
+// pools, by network, ports, protocol or a combination. Ingest rules drop
+// matching ports when they're submitted; others hide them from the results.
+// Empty fields match any.
+type IgnoreRule struct {
+	ID      int64  `json:"id"`
+	CIDR    string `json:"cidr,omitempty"`
+	Ports   string `json:"ports,omitempty"`
+	Proto   string `json:"proto,omitempty"`
+	Ingest  bool   `json:"ingest"`
+	Reason  string `json:"reason"`
+	User    string `json:"user"`
+	Created Time   `json:"created"`
+}
+
+// Target is an entry in the inventory of what should be scanned: a network
+// or host, the ports and protocol to scan on it, who owns it and what it's
+// for. Frequency is how often it should be scanned, as a task schedule such
+// as "@daily", or empty if it's only scanned by hand. Tenant is the tenant it
+// belongs to, if any.
+type Target struct {
+	ID          int64  `json:"id"`
+	CIDR        string `json:"cidr"`
+	Ports       string `json:"ports"`
+	Proto       string `json:"proto"`
+	Owner       string `json:"owner,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Frequency   string `json:"frequency,omitempty"`
+	Description string `json:"description,omitempty"`
+	Tenant      string `json:"tenant,omitempty"`
+	User        string `json:"user"`
+	Created     Time   `json:"created"`
+}
+
+// BaselineEntry allows a network, ports, protocol or a combination of them
+// to be open. Once the baseline has any entries, open ports which none of
+// them allow are violations. Empty fields match any.
+type BaselineEntry struct {
+	ID      int64  `json:"id"`
+	CIDR    string `json:"cidr,omitempty"`
+	Ports   string `json:"ports,omitempty"`
+	Proto   string `json:"proto,omitempty"`
+	Reason  string `json:"reason"`
+	User    string `json:"user"`
+	Created Time   `json:"created"`
+}
+
+// Allows reports whether the entry allows the port to be open.
+func (b BaselineEntry) Allows(ip string, port int, proto string) bool {
+	if b.CIDR != "" {
+		_, network, err := net.ParseCIDR(b.CIDR)
+		if err != nil || !network.Contains(net.ParseIP(ip)) {
+			return false
+		}
+	}
+	if b.Proto != "" && b.Proto != proto {
+		return false
+	}
+	return b.Ports == "" || PortInRange(b.Ports, port)
+}
+
+// Violates reports whether a port open on ip is outside the baseline. Nothing
+// violates an empty baseline.
+func Violates(baseline []BaselineEntry, ip string, port int, proto string) bool {
+	if len(baseline) == 0 {
+		return false
+	}
+	for _, b := range baseline {
+		if b.Allows(ip, port, proto) {
+			return false
+		}
+	}
+	return true
+}
+
+// Ack acknowledges a port as a known exposure, so it isn't shown as new or
+// alerted on. A nil Expires never expires.
+type Ack struct {
+	IP      string `json:"ip"`
+	Port    int    `json:"port"`
+	Proto   string `json:"proto"`
+	User    string `json:"user"`
+	Note    string `json:"note,omitempty"`
+	Created Time   `json:"created"`
+	Expires *Time  `json:"expires,omitempty"`
+}
+
+// Payload describes an archived results submission. Size is the length of
+// the compressed payload in bytes.
+type Payload struct {
+	ID   int64  `json:"id"`
+	Time Time   `json:"time"`
+	Host string `json:"host"`
+	Job  int64  `json:"job,omitempty"`
+	Size int64  `json:"size"`
+}
+
+// AlertRule decides how notifications for matching ports are handled. Empty
+// fields match anything, and Banner is a regular expression. Rules are
+// evaluated in order of ID and the first match wins.
+// Action is either "notify", to send to the named Channels (or every channel
+// if none are given) with the given Severity, or "ignore".
+// Throttle is the minimum number of seconds between notifications for the
+// rule; events in between are sent together afterwards.
+// Expiry, if set, limits the rule to certificates expiring within that many
+// days.
+type AlertRule struct {
+	ID       int64    `json:"id"`
+	Port     int      `json:"port,omitempty"`
+	Proto    string   `json:"proto,omitempty"`
+	CIDR     string   `json:"cidr,omitempty"`
+	Service  string   `json:"service,omitempty"`
+	Banner   string   `json:"banner,omitempty"`
+	Action   string   `json:"action"`
+	Channels []string `json:"channels,omitempty"`
+	Severity string   `json:"severity,omitempty"`
+	Throttle int      `json:"throttle,omitempty"`
+	Expiry   int      `json:"expiry,omitempty"`
+}
+
+// Field is a custom attribute admins define for hosts and ports, such as a
+// cost centre or compliance scope.
+type Field struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// FieldValue sets a custom field on a host, or a single port on it. A zero
+// Port and empty Proto set it for the whole host; a port's own value takes
+// precedence.
+type FieldValue struct {
+	Field string `json:"field"`
+	IP    string `json:"ip"`
+	Port  int    `json:"port,omitempty"`
+	Proto string `json:"proto,omitempty"`
+	Value string `json:"value"`
+}
+
+// Approval is a destructive admin action, such as deleting a user, waiting for
+// a second admin to approve it. Params describes what the action applies to.
+// Resolved is nil while it's pending.
+type Approval struct {
+	ID          int64           `json:"id"`
+	Action      string          `json:"action"`
+	Params      json.RawMessage `json:"params"`
+	RequestedBy string          `json:"requested_by"`
+	Requested   Time            `json:"requested"`
+	ResolvedBy  string          `json:"resolved_by,omitempty"`
+	Resolved    *Time           `json:"resolved,omitempty"`
+	Approved    bool            `json:"approved"`
+}
+
+// Tag is a label on a host, such as the network zone or customer it belongs
+// to.
+type Tag struct {
+	IP      string `json:"ip"`
+	Name    string `json:"name"`
+	User    string `json:"user,omitempty"`
+	Created Time   `json:"created"`
+}
+
+// Geo is where an IP is, and the organisation announcing it, from the GeoIP
+// databases. Country is an ISO 3166 code.
+type Geo struct {
+	IP      string `json:"ip,omitempty"`
+	Country string `json:"country,omitempty"`
+	City    string `json:"city,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"`
+	Updated Time   `json:"updated"`
+}
+
+// Whois is who announces and who owns an IP. ASN and ASName are the
+// autonomous system announcing Prefix, and Netblock is the name the registry
+// gives the range, First to Last, the IP is allocated from, and Owner the
+// organisation it's registered to.
+type Whois struct {
+	IP       string `json:"ip,omitempty"`
+	ASN      uint   `json:"asn,omitempty"`
+	ASName   string `json:"as_name,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+	Netblock string `json:"netblock,omitempty"`
+	First    string `json:"first,omitempty"`
+	Last     string `json:"last,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Updated  Time   `json:"updated"`
+}
+
+// InternetDB is what Shodan's InternetDB has seen of an IP: the TCP ports it
+// found open, its hostnames, Shodan's tags for it, such as "cloud", and the
+// CVEs it may be vulnerable to.
+type InternetDB struct {
+	IP        string   `json:"ip"`
+	Ports     []int    `json:"ports"`
+	Hostnames []string `json:"hostnames"`
+	Tags      []string `json:"tags"`
+	Vulns     []string `json:"vulns"`
+	Updated   Time     `json:"updated"`
+}
+
+// Censys is what Censys has seen running on an IP.
+type Censys struct {
+	IP       string          `json:"ip"`
+	Services []CensysService `json:"services"`
+	Updated  Time            `json:"updated"`
+}
+
+// CensysService is a service Censys has seen. Certificate is the SHA-256
+// fingerprint of its certificate, if it has one.
+type CensysService struct {
+	IP          string `json:"-"`
+	Port        int    `json:"port"`
+	Proto       string `json:"proto"`
+	Service     string `json:"service,omitempty"`
+	Banner      string `json:"banner,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// GreyNoise is GreyNoise's view of an IP. Noise is set if it's been seen
+// scanning the internet and RIOT if it's a known business service, and
+// Classification is "benign", "malicious" or "unknown". Name is who runs
+// it, e.g. "Shodan.io".
+type GreyNoise struct {
+	IP             string `json:"ip"`
+	Noise          bool   `json:"noise"`
+	RIOT           bool   `json:"riot"`
+	Classification string `json:"classification,omitempty"`
+	Name           string `json:"name,omitempty"`
+	Updated        Time   `json:"updated"`
+}
+
+// AbuseScore is AbuseIPDB's confidence, from 0 to 100, that an IP is
+// abusive, and how many times it's been reported.
+type AbuseScore struct {
+	IP      string `json:"ip"`
+	Score   int    `json:"score"`
+	Reports int    `json:"reports"`
+	Updated Time   `json:"updated"`
+}
+
+// Vulnerability is a CVE the product and version in a port's banner may be
+// vulnerable to. Severity is the CVSS severity, e.g. HIGH, and Score its base
+// score.
+type Vulnerability struct {
+	IP       string  `json:"ip"`
+	Port     int     `json:"port"`
+	Proto    string  `json:"proto"`
+	Product  string  `json:"product"`
+	Version  string  `json:"version"`
+	CVE      string  `json:"cve"`
+	Severity string  `json:"severity"`
+	Score    float64 `json:"score"`
+	Summary  string  `json:"summary"`
+}
+
+// Finding is something a nuclei template found on a port. Severity is the
+// template's, e.g. "high", and Matched is the URL or address it matched at.
+type Finding struct {
+	ID       int64  `json:"id"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Proto    string `json:"proto"`
+	Template string `json:"template"`
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+	Matched  string `json:"matched"`
+	Found    Time   `json:"found"`
+}
+
+// Certificate is the TLS certificate presented on a port. Fingerprint is the
+// SHA-256 fingerprint of the certificate, and is empty if the port was probed
+// but didn't complete a TLS handshake. Subject and Issuer are common names,
+// or the full distinguished names if they have none.
+type Certificate struct {
+	IP          string   `json:"ip"`
+	Port        int      `json:"port"`
+	Proto       string   `json:"proto"`
+	Fingerprint string   `json:"fingerprint,omitempty"`
+	Subject     string   `json:"subject,omitempty"`
+	Issuer      string   `json:"issuer,omitempty"`
+	SANs        []string `json:"sans,omitempty"`
+	NotBefore   Time     `json:"not_before"`
+	NotAfter    Time     `json:"not_after"`
+	Updated     Time     `json:"updated"`
+}
+
+// HTTPResponse is the response to a request for / on a web port, over HTTPS
+// if the port answered it and HTTP otherwise. URL is the URL requested, and
+// Redirects the URLs it redirected to in order. Status is 0 if the port
+// didn't answer either.
+type HTTPResponse struct {
+	IP        string   `json:"ip"`
+	Port      int      `json:"port"`
+	Proto     string   `json:"proto"`
+	URL       string   `json:"url,omitempty"`
+	Status    int      `json:"status"`
+	Title     string   `json:"title,omitempty"`
+	Server    string   `json:"server,omitempty"`
+	Redirects []string `json:"redirects,omitempty"`
+	Updated   Time     `json:"updated"`
+}
+
+// Screenshot is a capture of the page at URL on a web port. The image itself
+// is served separately.
+type Screenshot struct {
+	IP    string `json:"ip"`
+	Port  int    `json:"port"`
+	Proto string `json:"proto"`
+	URL   string `json:"url"`
+	Taken Time   `json:"taken"`
+}
+
+// Attribute is a key/value pair an enricher set on a port.
+type Attribute struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Proto    string `json:"proto"`
+	Enricher string `json:"enricher"`
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+}
+
+// Network is a named set of CIDRs, such as "Production DMZ" or "Guest WiFi".
+// Results are in every network with a CIDR containing their IP. Windows are
+// when the network may be scanned, or any time if there are none, and
+// blackouts when it mustn't be, such as "Mon-Fri 01:00-05:00" or
+// "2026-12-24/2026-12-26".
+type Network struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	CIDRs       []string `json:"cidrs"`
+	Windows     []string `json:"windows,omitempty"`
+	Blackouts   []string `json:"blackouts,omitempty"`
+}
+
+// Tenant is an organisation sharing the deployment, such as a team or a
+// customer. It owns the address space in its CIDRs, which no other tenant's
+// overlap, and its users only see results in it.
+type Tenant struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	CIDRs       []string `json:"cidrs"`
+	Users       []string `json:"users"`
+	Created     Time     `json:"created"`
+}
+
+// AuditEntry is an action recorded in the audit log: who did it, when, and
+// what to. Info is usually the JSON of what changed. User is empty for
+// actions Scan takes itself, such as pruning old results.
+type AuditEntry struct {
+	ID     int64  `json:"id"`
+	Time   Time   `json:"time"`
+	User   string `json:"user"`
+	Action string `json:"action"`
+	Info   string `json:"info"`
+}
+
+// APIToken is a bearer token for the API, acting as the user with its email.
+// Its scope is "ingest", to submit results and claim jobs, "read", to view
+// them, or "admin" for everything. Ingest and read tokens may be restricted to
+// results in CIDRs. Only a hash of the token is stored, so Token is only set
+// when it's created. CreatedBy is the admin who created it.
+type APIToken struct {
+	ID        int64    `json:"id"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email"`
+	Scope     string   `json:"scope"`
+	CIDRs     []string `json:"cidrs"`
+	Created   Time     `json:"created"`
+	CreatedBy string   `json:"created_by"`
+	Token     string   `json:"token,omitempty"`
+}
+
+// Note is a comment left on a host, or a single port on it, such as while
+// triaging it. A zero Port and empty Proto is a note on the whole host.
+type Note struct {
+	ID      int64  `json:"id"`
+	IP      string `json:"ip"`
+	Port    int    `json:"port,omitempty"`
+	Proto   string `json:"proto,omitempty"`
+	Author  string `json:"author"`
+	Text    string `json:"text"`
+	Created Time   `json:"created"`
+}
+
+// SavedSearch is a named search of the results, such as a network and ports,
+// which can be run again from the index page or the API. Query is the search
+// as a URL query string, as used by the index page.
+type SavedSearch struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Query   string `json:"query"`
+	Owner   string `json:"owner"`
+	Created Time   `json:"created"`
+}
+
+// Agent is a scanning agent registered with the server by its heartbeats.
+// Down is set when it hasn't sent one for longer than the server allows.
+type Agent struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Scanner   string `json:"scanner"`
+	Addr      string `json:"addr"`
+	FirstSeen Time   `json:"firstseen"`
+	LastSeen  Time   `json:"lastseen"`
+	LastScan  *Time  `json:"last_scan,omitempty"`
+	Down      bool   `json:"down"`
 }
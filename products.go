@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+func init() {
+	registerEnricher("products", "Record the products and versions in port banners as attributes", func() (enricher, error) {
+		return productEnricher{}, nil
+	})
+}
+
+// productEnricher sets an attribute for each product found in a port's
+// banners, named vendor:product with the version as its value.
+type productEnricher struct{}
+
+func (productEnricher) enrich(ctx context.Context, t enrichTarget) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for _, banner := range t.Banners {
+		for _, v := range parseBanner(banner) {
+			attrs[strings.Join([]string{v.vendor, v.product}, ":")] = v.version
+		}
+	}
+	return attrs, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// Results shown on each page of the index, unless set with per_page
+const (
+	defaultPerPage = 100
+	maxPerPage     = 1000
+)
+
+// pager links to the other pages of the index.
+type pager struct {
+	Page, Pages int
+	Prev, Next  string
+}
+
+// pageParams parses the page and per_page query parameters.
+func pageParams(q url.Values) (page, perPage int) {
+	page, _ = strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ = strconv.Atoi(q.Get("per_page"))
+	switch {
+	case perPage < 1:
+		perPage = defaultPerPage
+	case perPage > maxPerPage:
+		perPage = maxPerPage
+	}
+	return page, perPage
+}
+
+// newPager returns the pager for page of rows, linking to the other pages
+// with the same search.
+func newPager(u *url.URL, page, perPage, rows int) pager {
+	p := pager{Page: page, Pages: (rows + perPage - 1) / perPage}
+	link := func(page int) string {
+		q := u.Query()
+		q.Set("page", strconv.Itoa(page))
+		return "?" + q.Encode()
+	}
+	if page > 1 {
+		p.Prev = link(page - 1)
+	}
+	if page < p.Pages {
+		p.Next = link(page + 1)
+	}
+	return p
+}
+
+// resultQuery parses the query parameters which search and sort results on
+// the index and API: ip, firstseen, lastseen, port, proto, service, banner,
+// text, seen_after, seen_before, field=name:value, tag, network, source,
+// hostname, country, city, org, owner, asn, abuse, cve, severity, cert,
+// expires, title, server, violation, ignored, sort and dir. A search box
+// query in q sets the parameters it stands for; see parseSearch.
+func resultQuery(q url.Values) (sqlite.ResultQuery, error) {
+	if s := q.Get("q"); s != "" {
+		params, err := parseSearch(s)
+		if err != nil {
+			return sqlite.ResultQuery{}, err
+		}
+		merged := make(url.Values)
+		for k, v := range q {
+			merged[k] = v
+		}
+		for k, v := range params {
+			if k == "field" || k == "tag" || k == "network" {
+				merged[k] = append(merged[k], v...)
+			} else {
+				merged[k] = v
+			}
+		}
+		q = merged
+	}
+	query := sqlite.ResultQuery{
+		IP:         q.Get("ip"),
+		FirstSeen:  q.Get("firstseen"),
+		LastSeen:   q.Get("lastseen"),
+		Service:    q.Get("service"),
+		Banner:     q.Get("banner"),
+		Text:       q.Get("text"),
+		Fields:     fieldFilters(q),
+		Networks:   q["network"],
+		Source:     q.Get("source"),
+		Hostname:   q.Get("hostname"),
+		Country:    q.Get("country"),
+		City:       q.Get("city"),
+		Org:        q.Get("org"),
+		Owner:      q.Get("owner"),
+		Cert:       q.Get("cert"),
+		Title:      q.Get("title"),
+		HTTPServer: q.Get("server"),
+	}
+	for _, tag := range q["tag"] {
+		query.Tags = append(query.Tags, strings.ToLower(tag))
+	}
+	for param, b := range map[string]*bool{"violation": &query.Violations, "ignored": &query.Ignored} {
+		if v := q.Get(param); v != "" {
+			var err error
+			if *b, err = strconv.ParseBool(v); err != nil {
+				return query, fmt.Errorf("invalid %s %q", param, v)
+			}
+		}
+	}
+	if asn := q.Get("asn"); asn != "" {
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+		if err != nil {
+			return query, fmt.Errorf("invalid asn %q", asn)
+		}
+		query.ASN = uint(n)
+	}
+	if abuse := q.Get("abuse"); abuse != "" {
+		n, err := strconv.Atoi(abuse)
+		if err != nil || n < 0 || n > 100 {
+			return query, fmt.Errorf("invalid abuse score %q", abuse)
+		}
+		query.MinAbuseScore = n
+	}
+	if cve := q.Get("cve"); cve != "" {
+		query.CVE = strings.ToUpper(cve)
+	}
+	if severity := q.Get("severity"); severity != "" {
+		query.Severity = strings.ToUpper(severity)
+		if !sqlite.ValidSeverity(query.Severity) {
+			return query, fmt.Errorf("invalid severity %q", severity)
+		}
+	}
+	if expires := q.Get("expires"); expires != "" {
+		days, err := strconv.Atoi(expires)
+		if err != nil || days < 0 {
+			return query, fmt.Errorf("invalid expires %q", expires)
+		}
+		query.ExpiresBefore = time.Now().AddDate(0, 0, days)
+	}
+	if port := q.Get("port"); port != "" {
+		ports, err := scan.ParsePortSpec(port)
+		if err != nil {
+			return query, err
+		}
+		query.Ports = ports
+	}
+	switch proto := q.Get("proto"); proto {
+	case "", "tcp", "udp":
+		query.Proto = proto
+	default:
+		return query, fmt.Errorf("invalid proto %q", proto)
+	}
+	for param, t := range map[string]*time.Time{"seen_after": &query.SeenAfter, "seen_before": &query.SeenBefore} {
+		if v := q.Get(param); v != "" {
+			var err error
+			if *t, err = parseTime(v); err != nil {
+				return query, fmt.Errorf("%s: %v", param, err)
+			}
+		}
+	}
+	var err error
+	query.Sort, query.Desc, err = sortParams(q)
+	return query, err
+}
+
+// sortParams parses the sort and dir query parameters.
+func sortParams(q url.Values) (column string, desc bool, err error) {
+	column = q.Get("sort")
+	if column != "" && !sqlite.ValidSort(column) {
+		return "", false, fmt.Errorf("can't sort by %q", column)
+	}
+	switch dir := q.Get("dir"); dir {
+	case "", "asc":
+	case "desc":
+		desc = true
+	default:
+		return "", false, fmt.Errorf("invalid sort direction %q", dir)
+	}
+	return column, desc, nil
+}
+
+// sortLinks returns links to sort the index by each column, starting from the
+// first page. The column it's sorted by links to sorting the other way.
+func sortLinks(u *url.URL, column string, desc bool) map[string]string {
+	links := make(map[string]string)
+	for _, c := range []string{"ip", "port", "proto", "firstseen", "lastseen"} {
+		q := u.Query()
+		q.Del("page")
+		q.Set("sort", c)
+		q.Del("dir")
+		if c == column && !desc {
+			q.Set("dir", "desc")
+		}
+		links[c] = "?" + q.Encode()
+	}
+	return links
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestPageParams(t *testing.T) {
+	tests := []struct {
+		query         string
+		page, perPage int
+	}{
+		{"", 1, defaultPerPage},
+		{"page=3&per_page=50", 3, 50},
+		{"page=-1&per_page=0", 1, defaultPerPage},
+		{"page=x&per_page=100000", 1, maxPerPage},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		page, perPage := pageParams(q)
+		if page != tt.page || perPage != tt.perPage {
+			t.Errorf("%q: want page %d of %d, got %d of %d", tt.query, tt.page, tt.perPage, page, perPage)
+		}
+	}
+}
+
+func TestNewPager(t *testing.T) {
+	u, _ := url.Parse("/?ip=192.0.2&page=2")
+	p := newPager(u, 2, 10, 25)
+	want := pager{Page: 2, Pages: 3, Prev: "?ip=192.0.2&page=1", Next: "?ip=192.0.2&page=3"}
+	if p != want {
+		t.Errorf("want %+v, got %+v", want, p)
+	}
+
+	p = newPager(u, 1, 10, 10)
+	if p.Pages != 1 || p.Prev != "" || p.Next != "" {
+		t.Errorf("expected a single page, got %+v", p)
+	}
+}
+
+func TestResultQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		ports [][2]int
+		err   bool
+	}{
+		{"", nil, false},
+		{"port=22", [][2]int{{22, 22}}, false},
+		{"port=22,8000-9000", [][2]int{{22, 22}, {8000, 9000}}, false},
+		{"port=ssh", nil, true},
+		{"port=22,", nil, true},
+		{"port=9000-8000", nil, true},
+		{"port=70000", nil, true},
+		{"proto=udp", nil, false},
+		{"proto=icmp", nil, true},
+		{"seen_after=2020-03-01&seen_before=2020-03-08T00:00:00Z", nil, false},
+		{"seen_after=last+week", nil, true},
+		{"q=port:22+port:80&port=443", [][2]int{{22, 22}, {80, 80}}, false},
+		{"q=port:x", nil, true},
+		{"sort=ip&dir=desc", nil, false},
+		{"sort=secret", nil, true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		query, err := resultQuery(q)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: unexpected error %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(query.Ports, tt.ports) {
+			t.Errorf("%q: want ports %v, got %v", tt.query, tt.ports, query.Ports)
+		}
+	}
+}
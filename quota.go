@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// dbQuota is a soft limit on the size of the database, in megabytes. When the
+// database grows past it notifiers are alerted once, until it shrinks again,
+// and if pause is set results submissions are refused in the meantime.
+type dbQuota struct {
+	limit int64
+	pause bool
+
+	mu   sync.Mutex
+	over bool
+}
+
+// paused reports whether results submissions should be refused. It's safe to
+// call on a nil quota.
+func (q *dbQuota) paused() bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pause && q.over
+}
+
+// update records the database size in bytes and reports whether it has just
+// gone over the quota.
+func (q *dbQuota) update(size int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	over := size > q.limit<<20
+	crossed := over && !q.over
+	q.over = over
+	return crossed
+}
+
+// checkQuota compares the database size against the quota, alerting the
+// notifiers if it has just gone over.
+func (app *App) checkQuota(now time.Time) {
+	size, err := app.db.Size()
+	if err != nil {
+		log.Println("checkQuota: error fetching database size:", err)
+		return
+	}
+	gaugeDBSize.Set(float64(size))
+	// Every replica tracks the quota, but only the leader alerts
+	if !app.quota.update(size) || !app.leading() {
+		return
+	}
+
+	log.Printf("checkQuota: database is %d MB, over its quota of %d MB", size>>20, app.quota.limit)
+	e := event{Type: eventQuota, Time: now, Size: size >> 20, Quota: app.quota.limit}
+	for _, n := range app.notifiers {
+		if err := n.notify(e); err != nil {
+			log.Printf("checkQuota: error sending %s event to %s: %v", e.Type, n.name(), err)
+		}
+	}
+}
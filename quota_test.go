@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	db := createDB("TestQuota")
+	defer db.Close()
+	slack := testNotifier{"slack", make(chan event, 10)}
+	app := App{db: db, notifiers: []notifier{slack}, quota: &dbQuota{limit: 1, pause: true}}
+
+	size, err := db.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size == 0 {
+		t.Fatal("expected a non-zero database size")
+	}
+
+	// Well under the quota
+	app.quota.limit = 1 + size>>20
+	app.checkQuota(time.Now())
+	if app.quota.paused() {
+		t.Error("expected submissions to be accepted under the quota")
+	}
+
+	// Over the quota alerts once and pauses submissions
+	app.quota.limit = 0
+	for i := 0; i < 2; i++ {
+		app.checkQuota(time.Now())
+	}
+	select {
+	case e := <-slack.events:
+		if e.Type != eventQuota || e.Quota != 0 {
+			t.Errorf("unexpected event %+v", e)
+		}
+		if msg, _ := message("en", e); msg != "Database is 0 MB, over its quota of 0 MB" {
+			t.Errorf("unexpected message %q", msg)
+		}
+	default:
+		t.Fatal("expected a quota alert")
+	}
+	if len(slack.events) != 0 {
+		t.Error("expected only one quota alert")
+	}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+	res, err := http.Post(ts.URL+"/results", "application/json", bytes.NewBufferString("[]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusInsufficientStorage {
+		t.Errorf("expected status 507 while paused, got %d", res.StatusCode)
+	}
+
+	// A nil quota never pauses
+	if (*dbQuota)(nil).paused() {
+		t.Error("expected a nil quota not to pause")
+	}
+}
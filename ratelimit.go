@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateClient returns who a request counts against for -ratelimit: the API
+// token or user it authenticated as, or else the address it came from.
+func rateClient(r *http.Request) string {
+	if u := currentUser(r); u != nil {
+		if u.Token != "" {
+			return "token:" + u.Email + ":" + u.Token
+		}
+		return "user:" + u.Email
+	}
+	return "addr:" + peerAddr(r)
+}
+
+// rateLimit is a middleware limiting each client to app.requestLimit
+// requests a minute, counted in app.kv so the limit holds across replicas
+// sharing Redis. Health checks and static assets aren't counted.
+func (app *App) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.kv == nil || app.requestLimit <= 0 || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		window := now.Truncate(time.Minute)
+		key := "rate:" + rateClient(r) + ":" + strconv.FormatInt(window.Unix(), 10)
+		n, err := app.kv.incr(key, time.Minute)
+		if err != nil {
+			// Requests aren't refused because the store is unavailable
+			log.Println("rateLimit: error counting request:", err)
+		} else if n > app.requestLimit {
+			retry := window.Add(time.Minute).Sub(now)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
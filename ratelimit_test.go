@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	db := createDB("TestRateLimit")
+	defer db.Close()
+	app := App{db: db, kv: newMemStore(), requestLimit: 2}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	get := func(email, path string) *http.Response {
+		t.Helper()
+		app.authProviders = []authProvider{staticAuth{user: &User{Email: email}}}
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	for i := 0; i < 2; i++ {
+		if res := get("user@example.com", "/api/v1/results"); res.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d", i, res.StatusCode)
+		}
+	}
+	res := get("user@example.com", "/api/v1/results")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 over the limit, got %d", res.StatusCode)
+	}
+	if retry, err := strconv.Atoi(res.Header.Get("Retry-After")); err != nil || retry < 1 || retry > 60 {
+		t.Errorf("expected Retry-After within the minute, got %q", res.Header.Get("Retry-After"))
+	}
+
+	// Other users have their own limit, and health checks aren't limited
+	if res := get("other@example.com", "/api/v1/results"); res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 for another user, got %d", res.StatusCode)
+	}
+	if res := get("user@example.com", "/healthz"); res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 for a health check, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// rdnsBatch is the most IPs looked up in reverse DNS each run.
+const rdnsBatch = 500
+
+// rdnsWorkers is how many reverse DNS lookups are made at once.
+const rdnsWorkers = 8
+
+// rdnsTimeout is how long to wait for each reverse DNS lookup.
+const rdnsTimeout = 5 * time.Second
+
+// rdnsResolver looks up the PTR records of the IPs in the results.
+type rdnsResolver struct {
+	// refresh is how long lookups are kept before they're repeated
+	refresh time.Duration
+	// lookup returns the names in an IP's PTR records
+	lookup func(ctx context.Context, ip string) ([]string, error)
+}
+
+func newRDNSResolver(refresh time.Duration) *rdnsResolver {
+	return &rdnsResolver{
+		refresh: refresh,
+		lookup:  net.DefaultResolver.LookupAddr,
+	}
+}
+
+// resolve looks up ip, returning ok false if the lookup failed in a way
+// worth retrying. An IP without PTR records has no names.
+func (r *rdnsResolver) resolve(ip string) (names []string, ok bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), rdnsTimeout)
+	defer cancel()
+	names, err := r.lookup(ctx, ip)
+	if err != nil {
+		if dnsErr, isDNS := err.(*net.DNSError); isDNS && dnsErr.IsNotFound {
+			return nil, true
+		}
+		if verbose {
+			log.Printf("rdns: error looking up %s: %v", ip, err)
+		}
+		return nil, false
+	}
+	return names, true
+}
+
+// enrichRDNS looks up the PTR records of IPs never looked up, or last looked
+// up longer ago than the refresh interval. Lookups which fail with anything
+// other than the name not existing aren't saved, so they're tried again on
+// the next run.
+func (app *App) enrichRDNS(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	ips, err := app.db.LoadStaleRDNSIPs(now.Add(-app.rdns.refresh), rdnsBatch)
+	if err != nil {
+		log.Println("rdns: error loading IPs to look up:", err)
+		return
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	lookups := make([]scan.RDNS, 0, len(ips))
+	work := make(chan string)
+	for i := 0; i < rdnsWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range work {
+				names, ok := app.rdns.resolve(ip)
+				if !ok {
+					continue
+				}
+				mu.Lock()
+				lookups = append(lookups, scan.RDNS{IP: ip, Names: names, Updated: scan.Time{Time: now.UTC()}})
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, ip := range ips {
+		work <- ip
+	}
+	close(work)
+	wg.Wait()
+
+	if err := app.db.SaveRDNS(lookups); err != nil {
+		log.Println("rdns: error saving lookups:", err)
+		return
+	}
+	if verbose && len(lookups) > 0 {
+		log.Printf("rdns: looked up %d IPs", len(lookups))
+	}
+}
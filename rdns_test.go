@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestReverseDNS(t *testing.T) {
+	db := createDB("TestReverseDNS")
+	defer db.Close()
+
+	ptrs := map[string][]string{
+		"192.0.2.1": {"Web1.Example.com."},
+	}
+	fail := true
+	lookups := 0
+	resolver := newRDNSResolver(24 * time.Hour)
+	resolver.lookup = func(ctx context.Context, ip string) ([]string, error) {
+		lookups++
+		if ip == "203.0.113.1" && fail {
+			return nil, errors.New("timeout")
+		}
+		names, ok := ptrs[ip]
+		if !ok {
+			return nil, &net.DNSError{Err: "no such host", Name: ip, IsNotFound: true}
+		}
+		return names, nil
+	}
+	app := App{db: db, rdns: resolver}
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "203.0.113.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SavePassiveDNS([]scan.PassiveDNS{{IP: "192.0.2.1", Hostname: "www.example.com"}, {IP: "192.0.2.1", Hostname: "web1.example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	app.enrichRDNS(now)
+	rdns, err := db.LoadRDNS(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The failed lookup isn't saved so it's tried again
+	if len(rdns) != 2 {
+		t.Fatalf("expected 2 lookups saved, got %+v", rdns)
+	}
+	if !reflect.DeepEqual(rdns[0].Names, []string{"web1.example.com"}) {
+		t.Errorf("expected PTR stored normalised, got %v", rdns[0].Names)
+	}
+	if rdns[1].IP != "198.51.100.1" || len(rdns[1].Names) != 0 {
+		t.Errorf("expected no names for 198.51.100.1, got %+v", rdns[1])
+	}
+
+	fail = false
+	lookups = 0
+	app.enrichRDNS(now.Add(time.Minute))
+	if lookups != 1 {
+		t.Errorf("expected only the failed IP looked up again, got %d lookups", lookups)
+	}
+
+	// Lookups are refreshed once they're older than the refresh interval
+	lookups = 0
+	app.enrichRDNS(now.Add(25 * time.Hour))
+	if lookups != 3 {
+		t.Errorf("expected every IP refreshed, got %d lookups", lookups)
+	}
+
+	for _, tt := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"hostname": {"web1"}}, 1},
+		{url.Values{"q": {"hostname:www.example"}}, 1},
+		{url.Values{"hostname": {"example.net"}}, 0},
+	} {
+		query, err := resultQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query.View = sqlite.ViewAll
+		data, _, err := db.ResultPage(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results) != tt.want {
+			t.Errorf("%v: expected %d results, got %d", tt.query, tt.want, len(data.Results))
+			continue
+		}
+		// PTR names come first, and aren't repeated from passive DNS
+		if tt.want > 0 && !reflect.DeepEqual(data.Results[0].Hostnames, []string{"web1.example.com", "www.example.com"}) {
+			t.Errorf("%v: unexpected hostnames %v", tt.query, data.Results[0].Hostnames)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This is a minimal Redis client, supporting only the commands redisStore
+// needs. Replies are decoded as string (simple strings), int64, []byte (nil
+// for a missing value) or redisError. Arrays aren't supported.
+
+// redisKeyPrefix is prepended to every key, so scan can share a Redis
+// database with other applications.
+const redisKeyPrefix = "scan:"
+
+// redisMaxIdle is how many idle connections are kept for reuse.
+const redisMaxIdle = 8
+
+// redisIncr increments a counter, setting it to expire when it's created, in
+// one step so a counter can't be left without an expiry.
+const redisIncr = `local n = redis.call('INCR', KEYS[1])
+if n == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[1]) end
+return n`
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisStore is a kvStore shared by replicas in Redis.
+type redisStore struct {
+	url     string
+	timeout time.Duration
+	tls     *tls.Config // nil to verify the server against the system roots
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+// newRedisStore checks rawurl, a redis:// or rediss:// URL with an optional
+// password and database number, such as redis://:secret@localhost:6379/0,
+// and connects to it.
+func newRedisStore(rawurl string, timeout time.Duration) (*redisStore, error) {
+	s := &redisStore{url: rawurl, timeout: timeout}
+	c, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	s.release(c)
+	return s, nil
+}
+
+// redisConn is a connection to a Redis server.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (s *redisStore) dial() (*redisConn, error) {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	d := &net.Dialer{Timeout: s.timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "redis":
+		conn, err = d.Dial("tcp", host)
+	case "rediss":
+		config := &tls.Config{}
+		if s.tls != nil {
+			config = s.tls.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		conn, err = tls.DialWithDialer(d, "tcp", host, config)
+	default:
+		return nil, fmt.Errorf("redis: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+
+	var setup [][]string
+	if password, ok := u.User.Password(); ok {
+		if user := u.User.Username(); user != "" {
+			setup = append(setup, []string{"AUTH", user, password})
+		} else {
+			setup = append(setup, []string{"AUTH", password})
+		}
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if _, err := strconv.Atoi(db); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis: invalid database %q", db)
+		}
+		setup = append(setup, []string{"SELECT", db})
+	}
+	for _, args := range setup {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+		if _, err := c.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// conn returns an idle connection, or a new one if there aren't any.
+func (s *redisStore) conn() (c *redisConn, idle bool, err error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, true, nil
+	}
+	s.mu.Unlock()
+	c, err = s.dial()
+	return c, false, err
+}
+
+// release keeps a connection for reuse.
+func (s *redisStore) release(c *redisConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) >= redisMaxIdle {
+		c.conn.Close()
+		return
+	}
+	s.idle = append(s.idle, c)
+}
+
+// do runs a command, returning its reply. An error reply is returned as a
+// redisError.
+func (s *redisStore) do(args ...string) (interface{}, error) {
+	for retry := true; ; retry = false {
+		c, idle, err := s.conn()
+		if err != nil {
+			return nil, err
+		}
+		c.conn.SetDeadline(time.Now().Add(s.timeout))
+		reply, err := c.do(args...)
+		if _, ok := err.(redisError); err != nil && !ok {
+			// The connection is in an unknown state. An idle one may
+			// have been closed by the server, so the command is tried
+			// once more on another.
+			c.conn.Close()
+			if idle && retry {
+				continue
+			}
+			return nil, err
+		}
+		s.release(c)
+		return reply, err
+	}
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read reads a reply.
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("redis: invalid reply")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+}
+
+// redisTTL returns ttl in milliseconds, for PX and PEXPIRE. It's at least 1,
+// as Redis rejects 0.
+func redisTTL(ttl time.Duration) string {
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+func (s *redisStore) get(key string) ([]byte, error) {
+	reply, err := s.do("GET", redisKeyPrefix+key)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := reply.([]byte)
+	return b, nil
+}
+
+func (s *redisStore) set(key string, value []byte, ttl time.Duration) error {
+	_, err := s.do("SET", redisKeyPrefix+key, string(value), "PX", redisTTL(ttl))
+	return err
+}
+
+func (s *redisStore) del(key string) error {
+	_, err := s.do("DEL", redisKeyPrefix+key)
+	return err
+}
+
+func (s *redisStore) incr(key string, ttl time.Duration) (int64, error) {
+	reply, err := s.do("EVAL", redisIncr, "1", redisKeyPrefix+key, redisTTL(ttl))
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply %v to EVAL", reply)
+	}
+	return n, nil
+}
+
+func (s *redisStore) ping() error {
+	_, err := s.do("PING")
+	return err
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves the commands redisStore uses, requiring a password. Expiry
+// times are recorded, and returned by ttl, but not enforced.
+func fakeRedis(t *testing.T, password string) (addr string, ttl func(key string) time.Duration) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var mu sync.Mutex
+	values := make(map[string]string)
+	ttls := make(map[string]time.Duration)
+	ms := func(s string) time.Duration {
+		n, _ := strconv.Atoi(s)
+		return time.Duration(n) * time.Millisecond
+	}
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		authed := false
+		// length reads a line such as "*2\r\n"
+		length := func(prefix string) (int, error) {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return 0, err
+			}
+			return strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, prefix), "\r\n"))
+		}
+		for {
+			n, err := length("*")
+			if err != nil {
+				return
+			}
+			args := make([]string, n)
+			for i := range args {
+				size, err := length("$")
+				if err != nil {
+					return
+				}
+				b := make([]byte, size+2)
+				if _, err := io.ReadFull(r, b); err != nil {
+					return
+				}
+				args[i] = string(b[:size])
+			}
+
+			mu.Lock()
+			var reply string
+			switch cmd := strings.ToUpper(args[0]); {
+			case cmd == "AUTH":
+				authed = args[len(args)-1] == password
+				reply = "+OK\r\n"
+				if !authed {
+					reply = "-WRONGPASS invalid password\r\n"
+				}
+			case !authed:
+				reply = "-NOAUTH Authentication required.\r\n"
+			case cmd == "PING":
+				reply = "+PONG\r\n"
+			case cmd == "SELECT":
+				reply = "+OK\r\n"
+			case cmd == "GET":
+				if v, ok := values[args[1]]; ok {
+					reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+				} else {
+					reply = "$-1\r\n"
+				}
+			case cmd == "SET":
+				values[args[1]], ttls[args[1]] = args[2], ms(args[4])
+				reply = "+OK\r\n"
+			case cmd == "DEL":
+				delete(values, args[1])
+				reply = ":1\r\n"
+			case cmd == "EVAL":
+				key := args[3]
+				v, _ := strconv.Atoi(values[key])
+				v++
+				values[key] = strconv.Itoa(v)
+				if v == 1 {
+					ttls[key] = ms(args[4])
+				}
+				reply = fmt.Sprintf(":%d\r\n", v)
+			default:
+				reply = "-ERR unknown command\r\n"
+			}
+			mu.Unlock()
+			io.WriteString(conn, reply)
+		}
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return l.Addr().String(), func(key string) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		return ttls[key]
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	addr, ttl := fakeRedis(t, "secret")
+
+	for _, u := range []string{
+		"redis://:wrong@" + addr,
+		"redis://" + addr + "/db",
+		"http://" + addr,
+	} {
+		if _, err := newRedisStore(u, 5*time.Second); err == nil {
+			t.Errorf("%s: expected an error", u)
+		}
+	}
+
+	s, err := newRedisStore("redis://:secret@"+addr+"/1", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ping(); err != nil {
+		t.Error(err)
+	}
+	if b, err := s.get("a"); err != nil || b != nil {
+		t.Errorf("expected no value, got %q %v", b, err)
+	}
+	if err := s.set("a", []byte("one\r\ntwo"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := s.get("a"); err != nil || string(b) != "one\r\ntwo" {
+		t.Errorf("expected the value set, got %q %v", b, err)
+	}
+	if got := ttl("scan:a"); got != time.Minute {
+		t.Errorf("expected scan:a to expire in a minute, got %v", got)
+	}
+	if err := s.del("a"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := s.get("a"); b != nil {
+		t.Errorf("expected the value deleted, got %q", b)
+	}
+	for want := int64(1); want <= 2; want++ {
+		if n, err := s.incr("n", time.Second); err != nil || n != want {
+			t.Errorf("expected %d, got %d %v", want, n, err)
+		}
+	}
+	if got := ttl("scan:n"); got != time.Second {
+		t.Errorf("expected scan:n to expire in a second, got %v", got)
+	}
+
+	// A connection closed while idle is replaced
+	s.mu.Lock()
+	for _, c := range s.idle {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	if err := s.ping(); err != nil {
+		t.Errorf("expected a new connection, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// decodePayload decodes the results in an archived payload.
+func decodePayload(payload []byte) ([]scan.Result, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	var results []scan.Result
+	err = json.NewDecoder(gz).Decode(&results)
+	return results, err
+}
+
+// runReplay implements scan replay, which rebuilds the ports and banners from
+// the archived payloads, as if every submission had been received again. The
+// database is backed up first so the replay can be undone.
+func runReplay(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(out)
+	dir := fs.String("data.dir", ".", "Data directory `path`")
+	maxResultAge := fs.Duration("results.maxage", 7*24*time.Hour, "Accept scanners' timestamps for results up to this `duration` older than their submission")
+	force := fs.Bool("force", false, "Replay even if some submissions weren't archived, losing their results")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	dsn, err := sqlite.DefaultOptions.DSN(filepath.Join(*dir, sqlite.DefaultDBFile))
+	if err != nil {
+		return err
+	}
+	db, err := sqlite.Open(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	missing, err := db.UnarchivedSubmissions()
+	if err != nil {
+		return err
+	}
+	if missing > 0 && !*force {
+		return fmt.Errorf("%d submissions have no archived payload and their results would be lost; use -force to replay anyway", missing)
+	}
+
+	backup := filepath.Join(*dir, fmt.Sprintf("%s.%s.bak", sqlite.DefaultDBFile, time.Now().UTC().Format("20060102T150405Z")))
+	if err := db.Backup(backup); err != nil {
+		return fmt.Errorf("failed to back up database: %v", err)
+	}
+	fmt.Fprintln(out, "Backed up database to", backup)
+
+	payloads, err := db.LoadPayloads()
+	if err != nil {
+		return err
+	}
+	if err := db.ResetResults(); err != nil {
+		return err
+	}
+
+	// Timestamps are checked against when the payload was submitted, as
+	// they were originally
+	app := &App{db: db, maxResultAge: *maxResultAge}
+	var count int64
+	for i := len(payloads) - 1; i >= 0; i-- {
+		p := payloads[i]
+		payload, t, err := db.LoadPayload(p.ID)
+		if err != nil {
+			return err
+		}
+		results, err := decodePayload(payload)
+		if err != nil {
+			return fmt.Errorf("payload %d: %v", p.ID, err)
+		}
+		// Only the host is archived, not the agent or token
+		setSource(results, p.Host)
+		app.checkTimestamps(results, t)
+		n, _, err := db.SaveData(results, t)
+		if err != nil {
+			return fmt.Errorf("payload %d: %v", p.ID, err)
+		}
+		count += n
+	}
+
+	fmt.Fprintf(out, "Replayed %d payloads with %d ports\n", len(payloads), count)
+	return nil
+}
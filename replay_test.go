@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestRunReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	open := func() *sqlite.DB {
+		t.Helper()
+		dsn, _ := sqlite.DefaultOptions.DSN(filepath.Join(dir, sqlite.DefaultDBFile))
+		db, err := sqlite.Open(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+
+	db := open()
+	app := App{db: db, archivePayloads: true}
+	ts := httptest.NewServer(app.setupRouter())
+	for _, body := range []string{
+		`[{"ip": "192.0.2.1", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}]`,
+		`[{"ip": "192.0.2.1", "ports": [{"port": 22, "proto": "tcp", "service": {"name": "ssh", "banner": "SSH-2.0-OpenSSH_8.2p1"}}]},
+		  {"ip": "192.0.2.1", "ports": [{"port": 443, "proto": "tcp", "status": "open"}]}]`,
+	} {
+		res, err := http.Post(ts.URL+"/results", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	ts.Close()
+
+	// Lose the derived data
+	if err := db.ResetResults(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	var out bytes.Buffer
+	if err := runReplay([]string{"-data.dir", dir}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := filepath.Glob(filepath.Join(dir, "*.bak")); len(backups) != 1 {
+		t.Errorf("expected a backup, got %v", backups)
+	}
+
+	db = open()
+	defer db.Close()
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 {
+		t.Errorf("expected 2 ports after replaying, got %+v", data)
+	}
+	banners, err := db.LoadBanners("192.0.2.1", 22, "tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if banners["ssh"] == "" {
+		t.Errorf("expected the ssh banner after replaying, got %v", banners)
+	}
+
+	// Submissions without a payload would be lost
+	if err := db.SaveSubmission("192.0.2.99", nil, data[0].LastSeen.Time, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runReplay([]string{"-data.dir", dir}, &out); err == nil {
+		t.Error("expected an error with unarchived submissions")
+	}
+}
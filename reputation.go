@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// reputationFeed is a blocklist of IPs or CIDRs published at a URL.
+type reputationFeed struct {
+	name string
+	url  string
+}
+
+// reputationLists holds the most recently fetched contents of each feed.
+type reputationLists struct {
+	feeds  []reputationFeed
+	client *http.Client
+
+	mu       sync.RWMutex
+	networks map[string][]*net.IPNet
+}
+
+// newReputationLists parses a comma-separated list of name=url feeds.
+func newReputationLists(feeds string) (*reputationLists, error) {
+	l := &reputationLists{
+		client:   &http.Client{Timeout: time.Minute},
+		networks: make(map[string][]*net.IPNet),
+	}
+	for _, f := range strings.Split(feeds, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid reputation feed %q: must be name=url", f)
+		}
+		l.feeds = append(l.feeds, reputationFeed{name: kv[0], url: kv[1]})
+	}
+	return l, nil
+}
+
+// run fetches all feeds immediately and then every interval.
+func (l *reputationLists) run(interval time.Duration) {
+	l.updateAll()
+	for range time.Tick(interval) {
+		l.updateAll()
+	}
+}
+
+func (l *reputationLists) updateAll() {
+	for _, feed := range l.feeds {
+		networks, err := l.fetch(feed.url)
+		if err != nil {
+			// Keep the previous list rather than dropping all matches
+			log.Printf("reputation: error fetching %s: %v", feed.name, err)
+			continue
+		}
+		l.mu.Lock()
+		l.networks[feed.name] = networks
+		l.mu.Unlock()
+		if verbose {
+			log.Printf("reputation: loaded %d entries from %s", len(networks), feed.name)
+		}
+	}
+}
+
+func (l *reputationLists) fetch(url string) ([]*net.IPNet, error) {
+	res, err := l.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return parseBlocklist(res.Body)
+}
+
+// parseBlocklist reads one IP or CIDR per line. Comments starting with # or ;
+// are ignored, as is anything after the first field, which covers the formats
+// used by Spamhaus and abuse.ch.
+func parseBlocklist(r io.Reader) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(fields[0]); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		if ip := net.ParseIP(fields[0]); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return networks, s.Err()
+}
+
+// privateNetworks are never looked up as they can't appear on public lists.
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+		"169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16",
+		"::1/128", "fc00::/7", "fe80::/10",
+	} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+func isPublicIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns the names of the feeds listing ip.
+func (l *reputationLists) lookup(ip string) []string {
+	addr := net.ParseIP(ip)
+	if addr == nil || !isPublicIP(addr) {
+		return nil
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var listed []string
+	for name, networks := range l.networks {
+		for _, network := range networks {
+			if network.Contains(addr) {
+				listed = append(listed, name)
+				break
+			}
+		}
+	}
+	sort.Strings(listed)
+	return listed
+}
+
+// annotate sets the reputation lists for each result.
+func (l *reputationLists) annotate(results []scan.IPInfo) {
+	if l == nil {
+		return
+	}
+	cache := make(map[string][]string)
+	for i := range results {
+		ip := results[i].IP
+		listed, ok := cache[ip]
+		if !ok {
+			listed = l.lookup(ip)
+			cache[ip] = listed
+		}
+		results[i].Reputation = listed
+	}
+}
+
+// Handler for GET /api/v1/reputation
+// This lists the open ports on hosts which appear on reputation lists.
+func (app *App) reputation(w http.ResponseWriter, r *http.Request) {
+	data, err := app.db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.reputationLists.annotate(data)
+
+	listed := []scan.IPInfo{}
+	for _, r := range data {
+		if len(r.Reputation) > 0 {
+			listed = append(listed, r)
+		}
+	}
+	render.JSON(w, r, listed)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestReputationLookup(t *testing.T) {
+	feeds := map[string]string{
+		// Spamhaus DROP format
+		"drop": "; Spamhaus DROP List\n203.0.113.0/24 ; SBL000001\n10.0.0.0/8 ; SBL000002\n",
+		// abuse.ch format
+		"feodo": "# Feodo Tracker\n198.51.100.7\n203.0.113.1\n",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(feeds[strings.TrimPrefix(r.URL.Path, "/")]))
+	}))
+	defer ts.Close()
+
+	l, err := newReputationLists("drop=" + ts.URL + "/drop,feodo=" + ts.URL + "/feodo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.updateAll()
+
+	tests := []struct {
+		ip   string
+		want []string
+	}{
+		{"203.0.113.1", []string{"drop", "feodo"}},
+		{"203.0.113.2", []string{"drop"}},
+		{"198.51.100.7", []string{"feodo"}},
+		{"198.51.100.8", nil},
+		// Private addresses are never looked up
+		{"10.0.0.1", nil},
+	}
+	for _, tt := range tests {
+		if got := l.lookup(tt.ip); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.ip, tt.want, got)
+		}
+	}
+
+	if _, err := newReputationLists("https://example.com/drop.txt"); err == nil {
+		t.Error("expected error for feed without a name")
+	}
+}
+
+func TestReputationHandler(t *testing.T) {
+	db := createDB("TestReputationHandler")
+	defer db.Close()
+
+	l, _ := newReputationLists("")
+	l.networks["test"], _ = parseBlocklist(strings.NewReader("192.0.2.1\n"))
+	app := App{db: db, reputationLists: l}
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/api/v1/reputation", nil)
+	w := httptest.NewRecorder()
+	app.reputation(w, r)
+
+	var listed []scan.IPInfo
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].IP != "192.0.2.1" || listed[0].Reputation[0] != "test" {
+		t.Errorf("expected only 192.0.2.1 listed on test, got %+v", listed)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// requestIDHeader carries the request ID in both directions. Scanners can
+// send their own ID to correlate their logs with ours.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID restricts the IDs accepted from clients, as they're written
+// to logs and responses.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID gives each request an ID, taken from the X-Request-ID header if
+// the client sent a valid one. It's stored where middleware.Logger includes it
+// in the request log, returned in the X-Request-ID response header, and
+// appended to plain text error responses.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), middleware.RequestIDKey, id)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+		if ww.Status() >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+			fmt.Fprintf(ww, "Request ID: %s\n", id)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+func TestRequestID(t *testing.T) {
+	var got string
+	h := requestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = middleware.GetReqID(r.Context())
+		if r.URL.Path == "/fail" {
+			http.Error(w, "Bad things", http.StatusBadRequest)
+		}
+	}))
+
+	for _, tt := range []struct {
+		path, sent string
+		keep       bool
+	}{
+		{"/", "", false},
+		{"/", "scanner-1234", true},
+		{"/", "bad id\n", false},
+		{"/fail", "scanner-5678", true},
+	} {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.sent != "" {
+			req.Header.Set(requestIDHeader, tt.sent)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		id := w.Header().Get(requestIDHeader)
+		if id == "" || id != got {
+			t.Errorf("%q: response ID %q doesn't match context ID %q", tt.sent, id, got)
+		}
+		if (id == tt.sent) != tt.keep {
+			t.Errorf("%q: unexpected ID %q", tt.sent, id)
+		}
+		body, _ := ioutil.ReadAll(w.Body)
+		if hasID := strings.Contains(string(body), "Request ID: "+id); hasID != (tt.path == "/fail") {
+			t.Errorf("%q: unexpected body %q", tt.sent, body)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+type retentionData struct {
+	indexData
+	Retention  time.Duration
+	Exemptions []scan.Exemption
+}
+
+func (d *retentionData) AddError(err string) {
+	d.Errors = append(d.Errors, err)
+}
+
+// Handler for GET and POST /retention
+func (app *App) retentionHandler(w http.ResponseWriter, r *http.Request) {
+	// Exemptions are audited, so need a user to attribute them to
+	if authDisabled {
+		http.Error(w, "Retention interface not available when authentication is disabled.", http.StatusNotImplemented)
+		return
+	}
+
+	u := currentUser(r)
+	if u == nil {
+		tmpl.ExecuteTemplate(w, "index", loginData(w, r))
+		return
+	}
+	user := *u
+
+	data := retentionData{
+		indexData: indexData{Authenticated: true, User: user},
+		Retention: app.retention,
+	}
+
+	if r.Method == "POST" {
+		err := r.ParseForm()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		err = app.retentionFormProcess(r.Form, user)
+		switch {
+		case err == errInvalidExemption:
+			data.AddError(invalidExemption)
+			w.WriteHeader(http.StatusBadRequest)
+		case err == errApprovalRequested:
+			data.Notice = approvalRequested
+			w.WriteHeader(http.StatusAccepted)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	exemptions, err := app.db.LoadExemptions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.Exemptions = exemptions
+
+	tmpl.ExecuteTemplate(w, "retention", data)
+}
+
+var (
+	invalidExemption    = "An exemption needs a valid IP and an optional port and protocol"
+	errInvalidExemption = errors.New("invalid exemption")
+)
+
+// parseExemption reads the IP, port and protocol of an exemption from the
+// form fields with the given prefix.
+func parseExemption(f url.Values, prefix string) (scan.Exemption, error) {
+	e := scan.Exemption{
+		IP:    scan.NormalizeIP(f.Get(prefix + "ip")),
+		Proto: f.Get(prefix + "proto"),
+	}
+	if net.ParseIP(e.IP) == nil {
+		return e, errInvalidExemption
+	}
+	if port := f.Get(prefix + "port"); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 0 || p > 65535 {
+			return e, errInvalidExemption
+		}
+		e.Port = p
+	}
+	switch e.Proto {
+	case "", "tcp", "udp":
+	default:
+		return e, errInvalidExemption
+	}
+	return e, nil
+}
+
+func (app *App) retentionFormProcess(f url.Values, user User) error {
+	if f.Get("add_ip") != "" {
+		e, err := parseExemption(f, "add_")
+		if err != nil {
+			return err
+		}
+		e.Reason = f.Get("add_reason")
+		e.User = user.Email
+		e.Created = scan.Time{Time: time.Now()}
+		if err := app.db.SaveExemption(e); err != nil {
+			return err
+		}
+		app.audit(user.Email, "add_exemption", portKey(e.IP, e.Port, e.Proto))
+	}
+
+	if f.Get("delete_ip") != "" {
+		e, err := parseExemption(f, "delete_")
+		if err != nil {
+			return err
+		}
+		if app.approvals {
+			return app.requestApproval(user.Email, "delete_exemption", e)
+		}
+		if err := app.db.DeleteExemption(e.IP, e.Port, e.Proto); err != nil {
+			return err
+		}
+		app.audit(user.Email, "delete_exemption", portKey(e.IP, e.Port, e.Proto))
+	}
+
+	return nil
+}
+
+// prune deletes results last seen longer ago than the retention period.
+func (app *App) prune(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	n, err := app.db.Prune(now.Add(-app.retention))
+	if err != nil {
+		log.Println("prune: error deleting old results:", err)
+		return
+	}
+	if n > 0 {
+		before := now.Add(-app.retention).Format(time.RFC3339)
+		log.Printf("prune: deleted %d results last seen before %s", n, before)
+		info, _ := json.Marshal(map[string]interface{}{"results": n, "before": before})
+		if err := app.audit("", "prune", string(info)); err != nil {
+			log.Println("prune: error saving audit:", err)
+		}
+	}
+}
+
+// purge is a request to delete the results in a CIDR, and how many were.
+type purge struct {
+	CIDR    string `json:"cidr"`
+	Results int64  `json:"results"`
+}
+
+// purgeResults deletes the results in p's CIDR, setting how many were, and
+// returns the info recorded in the audit log.
+func (app *App) purgeResults(p *purge) (string, error) {
+	_, network, err := net.ParseCIDR(p.CIDR)
+	if err != nil {
+		return "", err
+	}
+	if p.Results, err = app.db.PurgeResults(network); err != nil {
+		return "", err
+	}
+	info, _ := json.Marshal(p)
+	return string(info), nil
+}
+
+// Handler for DELETE /api/v1/results
+// Deletes every result in the cidr parameter, along with its banners and
+// history, regardless of retention exemptions. With -approvals they're only
+// deleted once another admin approves it.
+func (app *App) deleteResults(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	_, network, err := net.ParseCIDR(r.URL.Query().Get("cidr"))
+	if err != nil {
+		http.Error(w, "A valid cidr is required", http.StatusBadRequest)
+		return
+	}
+	p := purge{CIDR: network.String()}
+	if app.approvals {
+		err := app.requestApproval(user.Email, "purge_results", p)
+		if err != errApprovalRequested {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	info, err := app.purgeResults(&p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := app.audit(user.Email, "purge_results", info); err != nil {
+		log.Printf("deleteResults: error saving audit for %s: %v", p.CIDR, err)
+	}
+
+	render.JSON(w, r, p)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestRetentionFormProcess(t *testing.T) {
+	db := createDB("TestRetentionFormProcess")
+	defer db.Close()
+	app := &App{db: db}
+	user := User{Email: "admin@example.com"}
+
+	f := url.Values{}
+	f.Set("add_ip", "192.0.2.1")
+	f.Set("add_port", "22")
+	f.Set("add_proto", "tcp")
+	f.Set("add_reason", "PCI evidence")
+	if err := app.retentionFormProcess(f, user); err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+
+	exemptions, err := db.LoadExemptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exemptions) != 1 {
+		t.Fatalf("expected 1 exemption, got %d", len(exemptions))
+	}
+	e := exemptions[0]
+	if e.IP != "192.0.2.1" || e.Port != 22 || e.Proto != "tcp" || e.Reason != "PCI evidence" || e.User != user.Email {
+		t.Errorf("unexpected exemption %+v", e)
+	}
+
+	f = url.Values{}
+	f.Set("add_ip", "not-an-ip")
+	if err := app.retentionFormProcess(f, user); err != errInvalidExemption {
+		t.Errorf("expected errInvalidExemption; got %v", err)
+	}
+
+	f = url.Values{}
+	f.Set("delete_ip", "192.0.2.1")
+	f.Set("delete_port", "22")
+	f.Set("delete_proto", "tcp")
+	if err := app.retentionFormProcess(f, user); err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+	exemptions, err = db.LoadExemptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exemptions) != 0 {
+		t.Errorf("expected no exemptions, got %+v", exemptions)
+	}
+}
+
+func TestRetentionHandlerAuthDisabled(t *testing.T) {
+	db := createDB("TestRetentionHandlerAuthDisabled")
+	defer db.Close()
+	app := &App{db: db}
+
+	f := url.Values{}
+	f.Set("add_ip", "192.0.2.1")
+	r := httptest.NewRequest("POST", "/retention", strings.NewReader(f.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	app.retentionHandler(w, r)
+
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("expected status 501, got %d", w.Code)
+	}
+	exemptions, err := db.LoadExemptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exemptions) != 0 {
+		t.Errorf("expected no exemptions, got %+v", exemptions)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	db := createDB("TestPrune")
+	defer db.Close()
+	app := &App{db: db, retention: 24 * time.Hour}
+
+	old := time.Now().UTC().Add(-48 * time.Hour).Truncate(time.Second)
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}, {Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.3", Ports: []scan.Port{{Port: 25, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, old); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	if _, _, err := db.SaveData(results[2:], now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retain one port on the first host and all of the second
+	for _, e := range []scan.Exemption{
+		{IP: "192.0.2.1", Port: 22, Proto: "tcp"},
+		{IP: "192.0.2.2"},
+	} {
+		if err := db.SaveExemption(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app.prune(now)
+
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, r := range data {
+		got[portKey(r.IP, r.Port, r.Proto)] = true
+	}
+	want := []string{"192.0.2.1:22/tcp", "192.0.2.2:443/tcp", "192.0.2.3:25/tcp"}
+	if len(got) != len(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	for _, k := range want {
+		if !got[k] {
+			t.Errorf("expected %s to be retained", k)
+		}
+	}
+}
+
+func TestDeleteResults(t *testing.T) {
+	db := createDB("TestDeleteResults")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	banner := scan.Port{Port: 22, Proto: "tcp"}
+	banner.Service.Name, banner.Service.Banner = "ssh", "SSH-2.0-OpenSSH"
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{banner}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	// Purging ignores exemptions
+	if err := db.SaveExemption(scan.Exemption{IP: "192.0.2.2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "admin@example.com"}}}
+	do := func(query string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("DELETE", ts.URL+"/api/v1/results"+query, nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	for _, query := range []string{"", "?cidr=192.0.2.0"} {
+		if res := do(query); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", query, res.StatusCode)
+		}
+	}
+
+	// With approvals nothing is deleted until it's approved
+	app.approvals = true
+	if res := do("?cidr=192.0.2.0/24"); res.StatusCode != http.StatusAccepted {
+		t.Errorf("expected status 202 with approvals, got %d", res.StatusCode)
+	}
+	if data, _ := db.LoadData(sqlite.SQLFilter{}); len(data) != 3 {
+		t.Errorf("expected nothing deleted before approval, got %d results", len(data))
+	}
+
+	app.approvals = false
+	if res := do("?cidr=192.0.2.7/24"); res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", res.StatusCode)
+	}
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || data[0].IP != "198.51.100.1" {
+		t.Errorf("expected only the result outside the CIDR left, got %+v", data)
+	}
+	if banners, _ := db.LoadBannerHistory("192.0.2.1"); len(banners) != 0 {
+		t.Errorf("expected the banners purged, got %+v", banners)
+	}
+	if ts, err := db.LoadLastAudit("purge_results"); err != nil || ts.IsZero() {
+		t.Errorf("expected the purge audited (%v)", err)
+	}
+}
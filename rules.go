@@ -0,0 +1,312 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// Alert rule actions
+const (
+	actionNotify = "notify"
+	actionIgnore = "ignore"
+)
+
+// Names of the notifiers, which alert rules use to choose where events go.
+var channelNames = map[string]bool{
+	"webhook":   true,
+	"slack":     true,
+	"discord":   true,
+	"teams":     true,
+	"telegram":  true,
+	"pagerduty": true,
+	"email":     true,
+}
+
+// Severities follow PagerDuty's.
+var severities = map[string]bool{
+	"":         true,
+	"info":     true,
+	"warning":  true,
+	"error":    true,
+	"critical": true,
+}
+
+// alertRule is a validated rule ready for matching.
+type alertRule struct {
+	scan.AlertRule
+	network *net.IPNet
+	banner  *regexp.Regexp
+}
+
+// compileRule validates a rule and parses its CIDR and banner expression.
+func compileRule(r scan.AlertRule) (alertRule, error) {
+	rule := alertRule{AlertRule: r}
+	if r.Port < 0 || r.Port > 65535 {
+		return rule, fmt.Errorf("invalid port %d", r.Port)
+	}
+	switch r.Proto {
+	case "", "tcp", "udp":
+	default:
+		return rule, fmt.Errorf("invalid proto %q", r.Proto)
+	}
+	if r.CIDR != "" {
+		_, network, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			return rule, err
+		}
+		rule.network = network
+	}
+	if r.Banner != "" {
+		re, err := regexp.Compile(r.Banner)
+		if err != nil {
+			return rule, fmt.Errorf("invalid banner expression: %v", err)
+		}
+		rule.banner = re
+	}
+	switch r.Action {
+	case actionNotify, actionIgnore:
+	default:
+		return rule, fmt.Errorf("action must be %s or %s", actionNotify, actionIgnore)
+	}
+	for _, c := range r.Channels {
+		if !channelNames[c] {
+			return rule, fmt.Errorf("unknown channel %q", c)
+		}
+	}
+	if !severities[r.Severity] {
+		return rule, fmt.Errorf("invalid severity %q", r.Severity)
+	}
+	if r.Throttle < 0 {
+		return rule, fmt.Errorf("invalid throttle %d", r.Throttle)
+	}
+	if r.Expiry < 0 {
+		return rule, fmt.Errorf("invalid expiry %d", r.Expiry)
+	}
+	return rule, nil
+}
+
+func (r alertRule) match(e event) bool {
+	if r.Port != 0 && r.Port != e.Port {
+		return false
+	}
+	if r.Proto != "" && r.Proto != e.Proto {
+		return false
+	}
+	if r.network != nil && !r.network.Contains(net.ParseIP(e.IP)) {
+		return false
+	}
+	if r.Expiry != 0 && (e.Certificate == nil || e.Certificate.NotAfter.Sub(e.Time) > time.Duration(r.Expiry)*24*time.Hour) {
+		return false
+	}
+	if r.Service != "" {
+		if _, ok := e.Banners[r.Service]; !ok {
+			return false
+		}
+	}
+	if r.banner != nil {
+		matched := false
+		for service, banner := range e.Banners {
+			if (r.Service == "" || r.Service == service) && r.banner.MatchString(banner) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// channels returns the set of notifiers the rule sends to, or nil for all.
+func (r alertRule) channels() map[string]bool {
+	if len(r.Channels) == 0 {
+		return nil
+	}
+	m := make(map[string]bool)
+	for _, c := range r.Channels {
+		m[c] = true
+	}
+	return m
+}
+
+type ruleSet []alertRule
+
+// match returns the first rule matching the event, or nil if none do.
+func (rs ruleSet) match(e event) *alertRule {
+	for i := range rs {
+		if rs[i].match(e) {
+			return &rs[i]
+		}
+	}
+	return nil
+}
+
+// needBanners reports whether any rule matches on service banners.
+func (rs ruleSet) needBanners() bool {
+	for _, r := range rs {
+		if r.Service != "" || r.banner != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// alertRules loads the alert rules from the database. Invalid rules are
+// logged and skipped.
+func (app *App) alertRules() ruleSet {
+	rules, err := app.db.LoadAlertRules()
+	if err != nil {
+		log.Println("alertRules: error loading rules:", err)
+		return nil
+	}
+	var rs ruleSet
+	for _, r := range rules {
+		rule, err := compileRule(r)
+		if err != nil {
+			log.Printf("alertRules: skipping rule %d: %v", r.ID, err)
+			continue
+		}
+		rs = append(rs, rule)
+	}
+	return rs
+}
+
+// Handler for GET /api/v1/rules
+func (app *App) listAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.db.LoadAlertRules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, rules)
+}
+
+// editor returns the user making a change through the API, such as to the
+// alert rules. These decide whether anyone is told about new ports, so
+// changes always need an authenticated user and are refused when
+// authentication is disabled.
+func editor(w http.ResponseWriter, r *http.Request) *User {
+	user := currentUser(r)
+	if user == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+	}
+	return user
+}
+
+// decodeAlertRule reads and validates a rule from the request body.
+func decodeAlertRule(w http.ResponseWriter, r *http.Request) (scan.AlertRule, bool) {
+	var rule scan.AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return rule, false
+	}
+	if _, err := compileRule(rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return rule, false
+	}
+	return rule, true
+}
+
+// auditRule records a change to an alert rule in the audit log.
+func (app *App) auditRule(user *User, event string, rule scan.AlertRule) {
+	info, _ := json.Marshal(rule)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditRule: error saving %s for rule %d: %v", event, rule.ID, err)
+	}
+}
+
+// Handler for POST /api/v1/rules
+func (app *App) newAlertRule(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	rule, ok := decodeAlertRule(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := app.db.SaveAlertRule(rule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rule.ID = id
+	app.auditRule(user, "add_rule", rule)
+
+	w.Header().Set("Location", fmt.Sprintf("%s/%d", strings.TrimSuffix(r.URL.Path, "/"), id))
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, rule)
+}
+
+// Handler for PUT /api/v1/rules/{id}
+// The rule is replaced, keeping its ID and so its place in the order.
+func (app *App) updateAlertRule(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+	rule, ok := decodeAlertRule(w, r)
+	if !ok {
+		return
+	}
+	rule.ID = id
+
+	err = app.db.UpdateAlertRule(rule)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditRule(user, "update_rule", rule)
+
+	render.JSON(w, r, rule)
+}
+
+// Handler for DELETE /api/v1/rules/{id}
+func (app *App) deleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	err = app.db.DeleteAlertRule(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditRule(user, "delete_rule", scan.AlertRule{ID: id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
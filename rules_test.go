@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// testNotifier records the events it's sent.
+type testNotifier struct {
+	n      string
+	events chan event
+}
+
+func (t testNotifier) name() string { return t.n }
+
+func (t testNotifier) notify(e event) error {
+	t.events <- e
+	return nil
+}
+
+func TestAlertRules(t *testing.T) {
+	db := createDB("TestAlertRules")
+	defer db.Close()
+
+	slack := testNotifier{"slack", make(chan event, 10)}
+	email := testNotifier{"email", make(chan event, 10)}
+	app := App{db: db, notifiers: []notifier{slack, email}}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	// Changing rules needs an authenticated user
+	res, err := http.Post(ts.URL+"/api/v1/rules", "application/json", bytes.NewBufferString(`{"action": "ignore"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without a user, got %d", res.StatusCode)
+	}
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "admin@example.com"}}}
+
+	for _, tt := range []struct {
+		rule string
+		code int
+	}{
+		{`{"port": 22, "action": "ignore"}`, http.StatusCreated},
+		{`{"cidr": "192.0.2.0/24", "service": "http", "banner": "nginx/1\\.1[0-9]", "action": "notify", "channels": ["slack"], "severity": "warning"}`, http.StatusCreated},
+		{`{"action": "page"}`, http.StatusBadRequest},
+		{`{"banner": "(", "action": "notify"}`, http.StatusBadRequest},
+		{`{"action": "notify", "channels": ["carrier-pigeon"]}`, http.StatusBadRequest},
+	} {
+		res, err := http.Post(ts.URL+"/api/v1/rules", "application/json", bytes.NewBufferString(tt.rule))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.rule, tt.code, res.StatusCode)
+		}
+	}
+
+	res, err = http.Get(ts.URL + "/api/v1/rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules []scan.AlertRule
+	if err := json.NewDecoder(res.Body).Decode(&rules); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(rules))
+	}
+
+	// Narrow the second rule from the whole /24 to a single host
+	req, _ := http.NewRequest("PUT", ts.URL+"/api/v1/rules/2", bytes.NewBufferString(
+		`{"cidr": "192.0.2.1/32", "service": "http", "banner": "nginx/1\\.1[0-9]", "action": "notify", "channels": ["slack"], "severity": "warning"}`))
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 updating rule, got %d", res.StatusCode)
+	}
+	rules, err = db.LoadAlertRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules[1].CIDR != "192.0.2.1/32" {
+		t.Errorf("expected updated CIDR 192.0.2.1/32, got %q", rules[1].CIDR)
+	}
+	req, _ = http.NewRequest("PUT", ts.URL+"/api/v1/rules/99", bytes.NewBufferString(`{"action": "ignore"}`))
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 updating missing rule, got %d", res.StatusCode)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	banner := scan.Port{Port: 80, Proto: "tcp"}
+	banner.Service.Name = "http"
+	banner.Service.Banner = "Server: nginx/1.18.0"
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{banner}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+	}
+	_, added, err := db.SaveData(results, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.notifyChanges(now, added, nil)
+
+	// Port 22 is ignored, port 80 only goes to Slack and port 443 matches no
+	// rules so goes everywhere
+	want := map[testNotifier][]string{
+		slack: {"192.0.2.1:80/tcp", "198.51.100.1:443/tcp"},
+		email: {"198.51.100.1:443/tcp"},
+	}
+	for n, keys := range want {
+		for _, k := range keys {
+			select {
+			case e := <-n.events:
+				if got := portKey(e.IP, e.Port, e.Proto); got != k {
+					t.Errorf("%s: expected %s, got %s", n.n, k, got)
+				}
+				if k == "192.0.2.1:80/tcp" && e.Severity != "warning" {
+					t.Errorf("%s: expected severity warning, got %q", k, e.Severity)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: timed out waiting for %s", n.n, k)
+			}
+		}
+	}
+	select {
+	case e := <-email.events:
+		t.Errorf("unexpected email event %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	req, _ = http.NewRequest("DELETE", ts.URL+"/api/v1/rules/1", nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status 204 deleting rule, got %d", res.StatusCode)
+	}
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 deleting missing rule, got %d", res.StatusCode)
+	}
+
+	// Every change was audited
+	var count int
+	err = db.QueryRow(`SELECT count(*) FROM audit WHERE user = 'admin@example.com' AND action IN ('add_rule', 'update_rule', 'delete_rule')`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("expected 4 audited rule changes, got %d", count)
+	}
+}
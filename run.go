@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// maxRunHeader is the longest scanner run detail accepted.
+const maxRunHeader = 1024
+
+// runMetadata reads the details of the scanner run from the X-Scanner,
+// X-Scanner-Args and X-Scanner-Rate headers of a results submission, and what
+// it scanned from the X-Scan-Scope and X-Scan-Ports headers. They're all
+// optional, but a run without a scope doesn't close any ports.
+func runMetadata(r *http.Request) (scan.Run, error) {
+	run := scan.Run{
+		Scanner: r.Header.Get("X-Scanner"),
+		Args:    r.Header.Get("X-Scanner-Args"),
+	}
+	if len(run.Scanner) > maxRunHeader || len(run.Args) > maxRunHeader {
+		return run, fmt.Errorf("scanner details are limited to %d bytes", maxRunHeader)
+	}
+	if rate := r.Header.Get("X-Scanner-Rate"); rate != "" {
+		n, err := strconv.Atoi(rate)
+		if err != nil || n < 0 {
+			return run, fmt.Errorf("invalid X-Scanner-Rate %q", rate)
+		}
+		run.Rate = n
+	}
+	if scope := r.Header.Get("X-Scan-Scope"); scope != "" {
+		ranges, err := parseMasscanRanges(scope)
+		if err != nil {
+			return run, fmt.Errorf("invalid X-Scan-Scope: %v", err)
+		}
+		run.Scope = ranges
+	}
+	if ports := r.Header.Get("X-Scan-Ports"); ports != "" {
+		if run.Scope == nil {
+			return run, fmt.Errorf("X-Scan-Ports needs an X-Scan-Scope")
+		}
+		if len(ports) > maxRunHeader {
+			return run, fmt.Errorf("X-Scan-Ports is limited to %d bytes", maxRunHeader)
+		}
+		if _, err := scan.ParseMasscanPorts(ports); err != nil {
+			return run, fmt.Errorf("invalid X-Scan-Ports: %v", err)
+		}
+		run.Ports = ports
+	}
+	return run, nil
+}
+
+// headerScanTime returns the time in the request's X-Scan-Time header, when
+// the scanner saw results which don't have their own timestamp, or the zero
+// time if it isn't set. It takes the same forms as results' timestamps.
+func headerScanTime(r *http.Request) (time.Time, error) {
+	h := r.Header.Get("X-Scan-Time")
+	if h == "" {
+		return time.Time{}, nil
+	}
+	var ts scan.Timestamp
+	if err := ts.UnmarshalJSON([]byte(h)); err != nil {
+		return time.Time{}, fmt.Errorf("invalid X-Scan-Time %q", h)
+	}
+	return ts.Time, nil
+}
+
+// runStarted returns when the scanner saw the earliest of results, which is
+// when the run started, or nil if none has a timestamp before now.
+func runStarted(results []scan.Result, now time.Time) *scan.Time {
+	started := now
+	for _, r := range results {
+		if !r.Timestamp.IsZero() && r.Timestamp.Before(started) {
+			started = r.Timestamp.Time
+		}
+	}
+	if !started.Before(now) {
+		return nil
+	}
+	return &scan.Time{Time: started.UTC()}
+}
+
+// Handler for GET /api/v1/runs
+// The most recent 100 submissions are listed with their scanner runs.
+func (app *App) runs(w http.ResponseWriter, r *http.Request) {
+	subs, err := app.db.LoadSubmissions(100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, subs)
+}
+
+// Handler for GET /api/v1/runs/{id}
+func (app *App) run(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+	sub, err := app.db.LoadSubmission(sqlite.SQLFilter{
+		Where:  []string{"rowid=?"},
+		Values: []interface{}{id},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if sub.ID == 0 {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+	render.JSON(w, r, sub)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestRuns(t *testing.T) {
+	db := createDB("TestRuns")
+	defer db.Close()
+	app := App{db: db}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	post := func(rate, scope, ports string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("POST", ts.URL+"/results", bytes.NewBufferString("[]"))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Scanner", "masscan 1.3.2")
+		req.Header.Set("X-Scanner-Args", "-p1-65535 --rate 10000 192.0.2.0/24")
+		req.Header.Set("X-Scanner-Rate", rate)
+		req.Header.Set("X-Scan-Scope", scope)
+		req.Header.Set("X-Scan-Ports", ports)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	for _, tt := range [][3]string{
+		{"fast", "192.0.2.0/24", "1-65535"},
+		{"10000", "192.0.2.0/33", "1-65535"},
+		{"10000", "192.0.2.0/24", "ssh"},
+		{"10000", "", "1-65535"},
+	} {
+		if res := post(tt[0], tt[1], tt[2]); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v: expected status 400, got %d", tt, res.StatusCode)
+		}
+	}
+	if res := post("10000", "192.0.2.0/24", "1-65535"); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs []scan.Submission
+	json.NewDecoder(res.Body).Decode(&runs)
+	res.Body.Close()
+	want := scan.Run{Scanner: "masscan 1.3.2", Args: "-p1-65535 --rate 10000 192.0.2.0/24", Rate: 10000, Scope: []string{"192.0.2.0/24"}, Ports: "1-65535"}
+	if len(runs) != 1 || !reflect.DeepEqual(runs[0].Run, want) {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+
+	for path, code := range map[string]int{
+		"/api/v1/runs/1":   http.StatusOK,
+		"/api/v1/runs/2":   http.StatusNotFound,
+		"/api/v1/runs/one": http.StatusBadRequest,
+	} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("%s: expected status %d, got %d", path, code, res.StatusCode)
+		}
+	}
+}
+
+func TestScanTimeHeader(t *testing.T) {
+	db := createDB("TestScanTimeHeader")
+	defer db.Close()
+	app := App{db: db, maxResultAge: 24 * time.Hour}
+
+	scanned := time.Now().UTC().Truncate(time.Second).Add(-3 * time.Hour)
+	own := scanned.Add(-time.Hour)
+	post := func(header string) int {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/results", bytes.NewBufferString(fmt.Sprintf(`[
+			{"ip": "192.0.2.1", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]},
+			{"ip": "192.0.2.2", "timestamp": "%d", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}
+		]`, own.Unix())))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Scan-Time", header)
+		w := httptest.NewRecorder()
+		app.recvResults(w, r)
+		return w.Code
+	}
+
+	if code := post("yesterday"); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid time, got %d", code)
+	}
+	// Rather than being taken as seen now
+	if code := post(scanned.Add(-48 * time.Hour).Format(time.RFC3339)); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a time older than the maximum age, got %d", code)
+	}
+	if code := post(scanned.Format(time.RFC3339)); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header is only used for results without their own timestamp
+	want := map[string]time.Time{"192.0.2.1": scanned, "192.0.2.2": own}
+	if len(data) != len(want) {
+		t.Fatalf("expected %d results, got %+v", len(want), data)
+	}
+	for _, r := range data {
+		if !r.FirstSeen.Equal(want[r.IP]) || !r.LastSeen.Equal(want[r.IP]) {
+			t.Errorf("%s: expected seen %v, got %v and %v", r.IP, want[r.IP], r.FirstSeen, r.LastSeen)
+		}
+		// They were seen by the scan just submitted, however long ago
+		if r.Gone || !r.New {
+			t.Errorf("%s: expected a new open port, got gone %v and new %v", r.IP, r.Gone, r.New)
+		}
+	}
+	sub, err := db.LoadSubmission(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sub.Start().Equal(own) {
+		t.Errorf("expected the scan recorded as started at %v, got %v", own, sub.Start())
+	}
+}
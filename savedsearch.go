@@ -0,0 +1,202 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// maxSearchName is the longest name a saved search can have.
+const maxSearchName = 100
+
+// searchQuery returns the search in q as a query string to save, leaving out
+// the page so a saved search always starts at the first.
+func searchQuery(q url.Values) string {
+	s := make(url.Values)
+	for k, v := range q {
+		if k != "page" {
+			s[k] = v
+		}
+	}
+	return s.Encode()
+}
+
+// checkSavedSearch validates a search to save and tidies its name and query.
+func checkSavedSearch(s *scan.SavedSearch) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(s.Name) > maxSearchName {
+		return fmt.Errorf("name is longer than %d characters", maxSearchName)
+	}
+	q, err := url.ParseQuery(strings.TrimPrefix(s.Query, "?"))
+	if err != nil {
+		return fmt.Errorf("invalid query: %v", err)
+	}
+	if _, err := resultQuery(q); err != nil {
+		return err
+	}
+	s.Query = searchQuery(q)
+	return nil
+}
+
+// saveSearch validates and stores a search for user. It returns the HTTP
+// status for any error, as the name may already be taken.
+func (app *App) saveSearch(s scan.SavedSearch, user *User) (scan.SavedSearch, int, error) {
+	if err := checkSavedSearch(&s); err != nil {
+		return s, http.StatusBadRequest, err
+	}
+	searches, err := app.db.LoadSavedSearches()
+	if err != nil {
+		return s, http.StatusInternalServerError, err
+	}
+	for _, saved := range searches {
+		if saved.Name == s.Name {
+			return s, http.StatusConflict, fmt.Errorf("a search named %q already exists", s.Name)
+		}
+	}
+	s.Owner = user.Email
+	s.Created = scan.Time{Time: time.Now().UTC()}
+	id, err := app.db.SaveSavedSearch(s)
+	if err != nil {
+		return s, http.StatusInternalServerError, err
+	}
+	s.ID = id
+	app.auditSearch(user, "add_search", s)
+	return s, 0, nil
+}
+
+// auditSearch records a change to the saved searches in the audit log.
+func (app *App) auditSearch(user *User, event string, s scan.SavedSearch) {
+	info, _ := json.Marshal(s)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditSearch: error saving %s for search %d: %v", event, s.ID, err)
+	}
+}
+
+// Handler for GET /api/v1/searches
+func (app *App) listSavedSearches(w http.ResponseWriter, r *http.Request) {
+	searches, err := app.db.LoadSavedSearches()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, searches)
+}
+
+// Handler for POST /api/v1/searches
+func (app *App) newSavedSearch(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var s scan.SavedSearch
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, status, err := app.saveSearch(s, user)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s/%d", strings.TrimSuffix(r.URL.Path, "/"), s.ID))
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, s)
+}
+
+// Handler for POST /searches
+// This saves the search on the index page and returns to it.
+func (app *App) saveSearchForm(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s := scan.SavedSearch{Name: r.PostForm.Get("name"), Query: r.PostForm.Get("query")}
+	s, status, err := app.saveSearch(s, user)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	http.Redirect(w, r, "/?"+s.Query, http.StatusSeeOther)
+}
+
+// savedSearchID parses the ID of a saved search from the URL.
+func savedSearchID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid search ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// Handler for DELETE /api/v1/searches/{id}
+func (app *App) deleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	id, ok := savedSearchID(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.db.DeleteSavedSearch(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Search not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditSearch(user, "delete_search", scan.SavedSearch{ID: id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for GET /api/v1/searches/{id}/results
+// This runs a saved search, returning results like GET /api/v1/results. The
+// format can be chosen with format=csv.
+func (app *App) savedSearchResults(w http.ResponseWriter, r *http.Request) {
+	id, ok := savedSearchID(w, r)
+	if !ok {
+		return
+	}
+	s, err := app.db.LoadSavedSearch(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Search not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	q, err := url.ParseQuery(s.Query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "" {
+		q.Set("format", format)
+	}
+	app.writeResults(w, r, q)
+}
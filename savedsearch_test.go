@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestSavedSearches(t *testing.T) {
+	db := createDB("TestSavedSearches")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	search := `{"name": "SSH", "query": "q=port:22"}`
+	res, err := http.Post(ts.URL+"/api/v1/searches", "application/json", bytes.NewBufferString(search))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without a user, got %d", res.StatusCode)
+	}
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "user@example.com"}}}
+
+	for _, tt := range []struct {
+		search string
+		code   int
+	}{
+		{search, http.StatusCreated},
+		{`{"name": "Web", "query": "?q=192.0.2.0/24+port:80,443&all"}`, http.StatusCreated},
+		{`{"name": "SSH", "query": "q=port:2222"}`, http.StatusConflict},
+		{`{"name": " ", "query": "q=port:22"}`, http.StatusBadRequest},
+		{`{"name": "Bad port", "query": "q=port:http"}`, http.StatusBadRequest},
+		{`{"name": "Bad proto", "query": "proto=icmp"}`, http.StatusBadRequest},
+	} {
+		res, err := http.Post(ts.URL+"/api/v1/searches", "application/json", bytes.NewBufferString(tt.search))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.search, tt.code, res.StatusCode)
+		}
+	}
+
+	res, err = http.Get(ts.URL + "/api/v1/searches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var searches []scan.SavedSearch
+	if err := json.NewDecoder(res.Body).Decode(&searches); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(searches) != 2 {
+		t.Fatalf("expected 2 searches, got %d", len(searches))
+	}
+	// Searches are listed by name
+	if searches[0].Name != "SSH" || searches[1].Name != "Web" {
+		t.Errorf("expected searches SSH and Web, got %q and %q", searches[0].Name, searches[1].Name)
+	}
+	if searches[0].Owner != "user@example.com" {
+		t.Errorf("expected owner user@example.com, got %q", searches[0].Owner)
+	}
+	if want := "all=&q=192.0.2.0%2F24+port%3A80%2C443"; searches[1].Query != want {
+		t.Errorf("expected query %q, got %q", want, searches[1].Query)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Running the Web search only finds port 80, as the other web server is
+	// outside the network
+	res, err = http.Get(ts.URL + "/api/v1/searches/2/results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []scan.IPInfo
+	if err := json.NewDecoder(res.Body).Decode(&found); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(found) != 1 || found[0].IP != "192.0.2.1" || found[0].Port != 80 {
+		t.Errorf("expected 192.0.2.1 port 80, got %+v", found)
+	}
+
+	res, err = http.Get(ts.URL + "/api/v1/searches/1/results?format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	body.ReadFrom(res.Body)
+	res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("expected CSV, got %q", ct)
+	}
+	if lines := strings.Split(strings.TrimSpace(body.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "192.0.2.1,22,tcp,") {
+		t.Errorf("expected a row for port 22, got %q", body.String())
+	}
+
+	res, err = http.Get(ts.URL + "/api/v1/searches/99/results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 running a missing search, got %d", res.StatusCode)
+	}
+
+	// Searches saved from the index page return to it
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err = client.PostForm(ts.URL+"/searches", url.Values{"name": {"Page two"}, "query": {"q=port:22&page=2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		t.Errorf("expected status 303 saving from the index page, got %d", res.StatusCode)
+	}
+	if loc := res.Header.Get("Location"); loc != "/?q=port%3A22" {
+		t.Errorf("expected redirect to the first page of the search, got %q", loc)
+	}
+
+	req, _ := http.NewRequest("DELETE", ts.URL+"/api/v1/searches/1", nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status 204 deleting search, got %d", res.StatusCode)
+	}
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 deleting missing search, got %d", res.StatusCode)
+	}
+
+	searches, err = db.LoadSavedSearches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(searches) != 2 {
+		t.Errorf("expected 2 searches after deleting one, got %d", len(searches))
+	}
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"database/sql"
 	"encoding/json"
@@ -17,9 +18,12 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/crypto/acme/autocert"
@@ -38,6 +42,7 @@ var (
 	credsFile    string
 	dataDir      string
 	httpsAddr    string
+	sessionKey   string
 	verbose      bool
 
 	// HTML templates
@@ -47,15 +52,20 @@ var (
 type storage interface {
 	LoadData(filter sqlite.SQLFilter) ([]scan.IPInfo, error)
 	ResultData(ip, fs, ls string) (scan.Data, error)
-	SaveData(results []scan.Result, now time.Time) (int64, error)
+	ResultPage(q sqlite.ResultQuery) (scan.Data, int, error)
+	SaveData(results []scan.Result, now time.Time) (int64, []scan.IPInfo, error)
+	LoadClosedBy(now time.Time, job *int64) ([]scan.IPInfo, error)
 	LoadSubmission(filter sqlite.SQLFilter) (scan.Submission, error)
-	SaveSubmission(host string, job *int64, now time.Time) error
+	LoadSubmissions(limit int) ([]scan.Submission, error)
+	SaveSubmission(host string, job *int64, now time.Time, run scan.Run) error
 	LoadTracerouteIPs() (map[string]struct{}, error)
 	LoadTraceroute(dest string) (string, error)
 	SaveTraceroute(dest, trace string) error
 	LoadJobs(filter sqlite.SQLFilter) ([]scan.Job, error)
 	LoadJobSubmission() (scan.Submission, error)
 	SaveJob(cidr, ports, proto, user string) (int64, error)
+	QueueJob(job scan.Job) (int64, error)
+	ClaimJob(agent string, now, expired time.Time, skip ...int64) (scan.Job, bool, error)
 	UpdateJob(id string, count int64) error
 	LoadUsers() ([]string, error)
 	LoadGroups() ([]string, error)
@@ -63,151 +73,632 @@ type storage interface {
 	SaveUser(email string) error
 	DeleteUser(email string) error
 	SaveAudit(ts time.Time, user, event, info string) error
+	LoadLastAudit(action string) (time.Time, error)
+	LoadAudit(filter sqlite.SQLFilter) ([]scan.AuditEntry, error)
+	PurgeResults(network *net.IPNet) (int64, error)
+	LoadUptime(filter sqlite.SQLFilter) ([]scan.Window, error)
+	LoadTimelines(filter sqlite.SQLFilter) ([]scan.Timeline, error)
+	LoadDiff(from, to time.Time) (scan.Diff, error)
+	LoadSources() ([]scan.Source, error)
+	CompareSources(a, b string, since time.Time) (scan.SourceComparison, error)
+	LoadPassiveDNS(ip string) ([]scan.PassiveDNS, error)
+	SavePassiveDNS(records []scan.PassiveDNS) error
+	LoadExemptions() ([]scan.Exemption, error)
+	SaveExemption(e scan.Exemption) error
+	DeleteExemption(ip string, port int, proto string) error
+	LoadAcks(now time.Time) ([]scan.Ack, error)
+	SaveAcks(acks ...scan.Ack) error
+	DeleteAck(ip string, port int, proto string) error
+	LoadFields() ([]scan.Field, error)
+	SaveField(f scan.Field) error
+	DeleteField(name string) error
+	SaveFieldValue(v scan.FieldValue) error
+	DeleteFieldValue(v scan.FieldValue) error
+	LoadApprovals(filter sqlite.SQLFilter) ([]scan.Approval, error)
+	SaveApproval(a scan.Approval) (int64, error)
+	ResolveApproval(id int64, user string, approved bool, now time.Time) error
+	Prune(before time.Time) (int64, error)
+	SavePayload(host string, job *int64, now time.Time, payload []byte) error
+	LoadPayloads() ([]scan.Payload, error)
+	LoadPayload(id int64) ([]byte, time.Time, error)
+	LoadAlertRules() ([]scan.AlertRule, error)
+	SaveAlertRule(r scan.AlertRule) (int64, error)
+	UpdateAlertRule(r scan.AlertRule) error
+	DeleteAlertRule(id int64) error
+	LoadSavedSearches() ([]scan.SavedSearch, error)
+	LoadSavedSearch(id int64) (scan.SavedSearch, error)
+	SaveSavedSearch(s scan.SavedSearch) (int64, error)
+	DeleteSavedSearch(id int64) error
+	LoadBanners(ip string, port int, proto string) (map[string]string, error)
+	LoadBannerHistory(ip string) ([]scan.Banner, error)
+	LoadBannerChanges(filter sqlite.SQLFilter) ([]scan.BannerChange, error)
+	LoadTags(filter sqlite.SQLFilter) ([]scan.Tag, error)
+	SaveTag(t scan.Tag) error
+	DeleteTag(ip, name string) error
+	LoadBaseline() ([]scan.BaselineEntry, error)
+	SaveBaselineEntry(b scan.BaselineEntry) (int64, error)
+	DeleteBaselineEntry(id int64) error
+	LoadGeo(filter sqlite.SQLFilter) ([]scan.Geo, error)
+	LoadStaleGeoIPs(before time.Time) ([]string, error)
+	SaveGeo(geo []scan.Geo) error
+	LoadRDNS(filter sqlite.SQLFilter) ([]scan.RDNS, error)
+	LoadStaleRDNSIPs(before time.Time, limit int) ([]string, error)
+	SaveRDNS(lookups []scan.RDNS) error
+	LoadWhois(filter sqlite.SQLFilter) ([]scan.Whois, error)
+	LoadStaleWhoisIPs(before time.Time, limit int) ([]string, error)
+	SaveWhois(whois []scan.Whois) error
+	LoadInternetDB(filter sqlite.SQLFilter) ([]scan.InternetDB, error)
+	LoadStaleInternetDBIPs(before time.Time) ([]string, error)
+	SaveInternetDB(records []scan.InternetDB) error
+	LoadCensys(filter sqlite.SQLFilter) ([]scan.Censys, error)
+	LoadStaleCensysIPs(before time.Time) ([]string, error)
+	SaveCensys(records []scan.Censys) error
+	LoadGreyNoise(filter sqlite.SQLFilter) ([]scan.GreyNoise, error)
+	LoadStaleGreyNoiseIPs(before time.Time) ([]string, error)
+	SaveGreyNoise(records []scan.GreyNoise) error
+	LoadAbuseScores(filter sqlite.SQLFilter) ([]scan.AbuseScore, error)
+	LoadStaleAbuseIPs(before time.Time) ([]string, error)
+	SaveAbuseScores(scores []scan.AbuseScore) error
+	LoadVulnerabilities(filter sqlite.SQLFilter) ([]scan.Vulnerability, error)
+	LoadCurrentBanners() ([]scan.Banner, error)
+	SaveVulnerabilities(vulns []scan.Vulnerability) error
+	LoadFindings(filter sqlite.SQLFilter) ([]scan.Finding, error)
+	SaveFindings(findings []scan.Finding) error
+	LoadCertificates(filter sqlite.SQLFilter) ([]scan.Certificate, error)
+	LoadStaleCertificatePorts(before time.Time) ([]scan.IPInfo, error)
+	LoadExpiringCertificates(before time.Time) ([]scan.Certificate, error)
+	LoadCertificateWarning(c scan.Certificate) (int, error)
+	SaveCertificateWarning(c scan.Certificate, days int, warned time.Time) error
+	LoadHTTPResponses(filter sqlite.SQLFilter) ([]scan.HTTPResponse, error)
+	LoadStaleHTTPPorts(before time.Time) ([]scan.IPInfo, error)
+	SaveHTTPResponses(responses []scan.HTTPResponse) error
+	LoadScreenshots(filter sqlite.SQLFilter) ([]scan.Screenshot, error)
+	LoadScreenshotImage(ip string, port int, proto string, thumbnail bool) ([]byte, error)
+	LoadStaleScreenshotPorts(before time.Time) ([]scan.HTTPResponse, error)
+	SaveScreenshot(s scan.Screenshot, image, thumbnail []byte) error
+	LoadAttributes(filter sqlite.SQLFilter) ([]scan.Attribute, error)
+	LoadStaleEnrichPorts(enricher string, before time.Time, limit int) ([]scan.IPInfo, error)
+	SaveAttributes(enricher string, r scan.IPInfo, attrs map[string]string, updated time.Time) error
+	LoadAgents() ([]scan.Agent, error)
+	SaveAgentHeartbeat(a scan.Agent, now time.Time) error
+	SaveAgentScan(name string, now time.Time) error
+	UpdateAgentAddr(name, addr string) error
+	SaveCertificates(certs []scan.Certificate) error
+	LoadIgnoreRules() ([]scan.IgnoreRule, error)
+	SaveIgnoreRule(r scan.IgnoreRule) (int64, error)
+	DeleteIgnoreRule(id int64) error
+	LoadNetworks() ([]scan.Network, error)
+	SaveNetwork(n scan.Network) error
+	DeleteNetwork(name string) error
+	LoadNotes(filter sqlite.SQLFilter) ([]scan.Note, error)
+	SaveNote(n scan.Note) (int64, error)
+	DeleteNote(id int64) error
+	LoadTargets() ([]scan.Target, error)
+	LoadTarget(id int64) (scan.Target, error)
+	SaveTarget(t scan.Target) (int64, error)
+	UpdateTarget(t scan.Target) error
+	DeleteTarget(id int64) error
+	LoadTargetScans() (map[int64]sqlite.TargetScans, error)
+	LoadTenants() ([]scan.Tenant, error)
+	SaveTenant(t scan.Tenant) error
+	DeleteTenant(name string) error
+	UserTenant(email string) (string, error)
+	SetUserTenant(email, tenant string) error
+	LoadAPITokens() ([]scan.APIToken, error)
+	APIToken(hash []byte) (scan.APIToken, error)
+	SaveAPIToken(t *scan.APIToken, hash []byte) error
+	DeleteAPIToken(id int64) error
+	AcquireLease(name, holder string, now time.Time, ttl time.Duration) (bool, error)
+	Ready() error
+	Size() (int64, error)
+	Close() error
 }
 
 type indexData struct {
 	NotAuth       string
 	Errors        []string
+	Notice        string
 	Authenticated bool
 	User          User
 	URI           string
 	AllResults    bool
+	ClosedOnly    bool
+	Fields        []scan.Field
+	SavedSearches []scan.SavedSearch
+	Query         string
 	Submission    scan.Submission
+	Pager         pager
+	Sort          string
+	SortDesc      bool
+	SortLinks     map[string]string
 	scan.Data
 }
 
 type App struct {
-	db storage
+	db              storage
+	reputationLists *reputationLists
+	pdnsProvider    *pdnsProvider
+	geoLocator      *geoLocator
+	rdns            *rdnsResolver
+	whois           *whoisClient
+	internetDB      *internetDBClient
+	censys          *censysClient
+	greyNoise       *greyNoiseClient
+	abuseIPDB       *abuseClient
+	cves            *cveMatcher
+	nuclei          *nucleiScanner
+	certs           *certProber
+	httpProbe       *httpProber
+	screenshots     *screenshotter
+	pipeline        *enrichPipeline
+	scheduler       *scheduler
+	masscan         *masscanRunner
+	notifiers       []notifier
+	retention       time.Duration
+	maxResultAge    time.Duration
+	agentTimeout    time.Duration
+	jobTimeout      time.Duration
+	archivePayloads bool
+	approvals       bool
+	authProviders   []authProvider
+	ldap            *ldapAuth
+	batcher         *batcher
+	quota           *dbQuota
+	self            *selfAddrs
+	leader          *leader
+	ingester        *ingester
+	kv              kvStore
+	cacheTTL        time.Duration
+	requestLimit    int64
 }
 
 // Handler for GET /
 func (app *App) index(w http.ResponseWriter, r *http.Request) {
 	var user User
 	if !authDisabled {
-		session, err := store.Get(r, "user")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if _, ok := session.Values["user"]; !ok {
-			data := indexData{URI: r.RequestURI}
-			if flash := session.Flashes("unauth_flash"); len(flash) > 0 {
-				data.NotAuth = flash[0].(string)
-				w.WriteHeader(http.StatusUnauthorized)
-				session.Save(r, w)
-			}
-			tmpl.ExecuteTemplate(w, "index", data)
+		u := currentUser(r)
+		if u == nil {
+			tmpl.ExecuteTemplate(w, "index", loginData(w, r))
 			return
 		}
-		v := session.Values["user"]
-		switch v := v.(type) {
-		case string:
-			user.Email = v
-		case User:
-			user = v
-		}
+		user = *u
 	}
 
 	q := r.URL.Query()
-	ip := q.Get("ip")
-	firstSeen := q.Get("firstseen")
-	lastSeen := q.Get("lastseen")
 	_, allResults := q["all"]
-
-	results, err := app.db.ResultData(ip, firstSeen, lastSeen)
+	_, closedOnly := q["closed"]
+	query, err := resultQuery(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.Page, query.PerPage = pageParams(q)
+	query.Tenant, query.CIDRs = user.Tenant, user.CIDRs
+	switch {
+	case closedOnly:
+		query.View = sqlite.ViewClosed
+	case allResults:
+		query.View = sqlite.ViewAll
+	}
+	results, rows, err := app.db.ResultPage(query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	app.reputationLists.annotate(results.Results)
+	app.self.annotate(results.Results)
 
-	sub, err := app.db.LoadSubmission(sqlite.SQLFilter{})
+	fields, err := app.db.LoadFields()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Saved searches and submissions span tenants, so are only shown to
+	// operators
+	var searches []scan.SavedSearch
+	var sub scan.Submission
+	if user.Tenant == "" && len(user.CIDRs) == 0 {
+		searches, err = app.db.LoadSavedSearches()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sub, err = app.db.LoadSubmission(sqlite.SQLFilter{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	data := indexData{
 		Authenticated: true,
 		User:          user,
 		URI:           r.URL.Path,
 		AllResults:    allResults,
+		ClosedOnly:    closedOnly,
+		Fields:        fields,
+		SavedSearches: searches,
+		Query:         searchQuery(q),
 		Submission:    sub,
+		Pager:         newPager(r.URL, query.Page, query.PerPage, rows),
+		Sort:          query.Sort,
+		SortDesc:      query.Desc,
+		SortLinks:     sortLinks(r.URL, query.Sort, query.Desc),
 		Data:          results,
 	}
 	tmpl.ExecuteTemplate(w, "index", data)
 }
 
 // Handler for GET /ips.json
-// This is used as the prefetch for Typeahead.js
+// This is used as the prefetch for Typeahead.js. Tenants and API tokens
+// restricted to CIDRs only get the IPs they can see.
 func (app *App) ips(w http.ResponseWriter, r *http.Request) {
-	data, err := app.db.LoadData(sqlite.SQLFilter{})
+	data, _, err := app.db.ResultPage(sqlite.ResultQuery{
+		View:   sqlite.ViewAll,
+		Tenant: requestTenant(r),
+		CIDRs:  requestCIDRs(r),
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	var ips []string
-	for _, r := range data {
+	for _, r := range data.Results {
 		ips = append(ips, r.IP)
 	}
 	render.JSON(w, r, ips)
 }
 
-func (app *App) saveResults(w http.ResponseWriter, r *http.Request, now time.Time) (int64, error) {
+// Handler for GET /api/v1/closed
+// This lists ports which weren't seen by the latest scan covering them.
+func (app *App) closedPorts(w http.ResponseWriter, r *http.Request) {
+	data, err := app.db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	closed := []scan.IPInfo{}
+	for _, r := range data {
+		if r.Gone {
+			closed = append(closed, r)
+		}
+	}
+	app.self.annotate(closed)
+	render.JSON(w, r, closed)
+}
+
+// clockSkew is how far ahead of the server a scanner's clock may be. Results
+// from slightly in the future are treated as seen now.
+const clockSkew = 5 * time.Minute
+
+// checkTimestamps makes sure the scanner's timestamp for each result is
+// plausible. Timestamps more than clockSkew in the future or older than the
+// app's maxResultAge are cleared so the result is seen now, and they're all
+// cleared if maxResultAge is 0. It returns how many were cleared.
+func (app *App) checkTimestamps(results []scan.Result, now time.Time) int {
+	var n int
+	for i, r := range results {
+		ts := r.Timestamp.Time
+		switch {
+		case ts.IsZero():
+			continue
+		case ts.After(now.Add(clockSkew)), now.Sub(ts) > app.maxResultAge:
+			n++
+			results[i].Timestamp = scan.Timestamp{}
+		case ts.After(now):
+			results[i].Timestamp = scan.Timestamp{Time: now}
+		}
+	}
+	return n
+}
+
+// staleResults counts the results with timestamps older than the app's
+// maxResultAge. Their timestamps would be cleared, so they'd be taken as seen
+// now by a scan which closes every port it didn't see.
+func (app *App) staleResults(results []scan.Result, now time.Time) int {
+	var n int
+	for _, r := range results {
+		if ts := r.Timestamp.Time; !ts.IsZero() && app.maxResultAge > 0 && now.Sub(ts) > app.maxResultAge {
+			n++
+		}
+	}
+	return n
+}
+
+// savedResults describes the results saved from a submission.
+type savedResults struct {
+	count   int64
+	added   []scan.IPInfo // ports which hadn't been seen before
+	payload []byte        // compressed request body, if archiving payloads
+	started *scan.Time    // when the scanner saw the earliest result
+}
+
+// saveResults stores the results in the request body.
+func (app *App) saveResults(w http.ResponseWriter, r *http.Request, now time.Time) (savedResults, error) {
+	var saved savedResults
+	res, payload, err := app.decodeResults(w, r, now)
+	if err != nil {
+		return saved, err
+	}
+	saved.payload = payload
+	saved.started = runStarted(res, now)
+
+	saved.count, saved.added, err = app.db.SaveData(res, now)
+	if err != nil {
+		return saved, dbError("save_data", err)
+	}
+	counterRows.WithLabelValues("insert").Add(float64(len(saved.added)))
+	counterRows.WithLabelValues("update").Add(float64(saved.count - int64(len(saved.added))))
+	return saved, nil
+}
+
+// prepareResults drops results without a port, normalises the IPs in
+// results, checks their timestamps and filters the server's own addresses.
+func (app *App) prepareResults(res []scan.Result, now time.Time) []scan.Result {
+	kept := res[:0]
+	for _, r := range res {
+		if len(r.Ports) == 0 {
+			continue
+		}
+		r.IP = scan.NormalizeIP(r.IP)
+		kept = append(kept, r)
+	}
+	if n := len(res) - len(kept); n > 0 {
+		log.Printf("saveResults: dropped %d results without a port", n)
+	}
+	res = kept
+	if n := app.checkTimestamps(res, now); n > 0 {
+		log.Printf("saveResults: ignored %d timestamps outside the accepted range", n)
+	}
+	var dropped int
+	res, dropped = app.self.filter(res)
+	if dropped > 0 {
+		log.Printf("saveResults: dropped %d results for the server's own addresses", dropped)
+	}
+
+	counterResults.Add(float64(len(res)))
+	return res
+}
+
+// resultSource returns the source to record for results submitted in the
+// request: the name of the API token it used or the user who sent it, or
+// else the agent named in X-Agent if it's registered at the address the
+// request came from, or else that address. The address is the connection's
+// peer, which clients can't change with X-Forwarded-For.
+func (app *App) resultSource(r *http.Request) string {
+	if user := currentUser(r); user != nil {
+		if user.Token != "" {
+			return user.Token
+		}
+		if user.Email != "" {
+			return user.Email
+		}
+	}
+	if agent := app.requestAgent(r); agent != "" {
+		return agent
+	}
+	return peerAddr(r)
+}
+
+// setSource sets the source of each result.
+func setSource(results []scan.Result, source string) {
+	for i := range results {
+		results[i].Source = source
+	}
+}
+
+// decodeResults reads the results in the request body, taking the
+// X-Scan-Time header as the timestamp of any without one, setting their
+// source, checking their timestamps and filtering the server's own
+// addresses, and those outside the address space of the tenant submitting
+// them or the CIDRs of their API token. If archiving payloads it also
+// returns the compressed body.
+func (app *App) decodeResults(w http.ResponseWriter, r *http.Request, now time.Time) ([]scan.Result, []byte, error) {
 	if r.Header.Get("Content-Type") != "application/json" {
 		w.WriteHeader(http.StatusUnsupportedMediaType)
-		return 0, errors.New("invalid Content-Type")
+		return nil, nil, errors.New("invalid Content-Type")
 	}
 
 	res := new([]scan.Result)
 
-	err := json.NewDecoder(r.Body).Decode(&res)
-	if err != nil {
-		return 0, err
+	body := io.Reader(r.Body)
+	var archive *payloadArchive
+	if app.archivePayloads {
+		archive = newPayloadArchive()
+		body = io.TeeReader(r.Body, archive)
 	}
 
-	count, err := app.db.SaveData(*res, now)
+	// The handlers have already rejected an invalid X-Scan-Time
+	seen, _ := headerScanTime(r)
+	err := json.NewDecoder(body).Decode(&res)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
+	}
+	if !seen.IsZero() {
+		for i := range *res {
+			if (*res)[i].Timestamp.IsZero() {
+				(*res)[i].Timestamp = scan.Timestamp{Time: seen}
+			}
+		}
+	}
+	if n := app.staleResults(*res, now); n > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, nil, fmt.Errorf("%d results are older than %v; load old scans with scan import", n, app.maxResultAge)
+	}
+	setSource(*res, app.resultSource(r))
+	*res = app.prepareResults(*res, now)
+	if tenant := requestTenant(r); tenant != "" {
+		scope, err := app.loadTenantScope(tenant)
+		if err != nil {
+			return nil, nil, err
+		}
+		var dropped int
+		if *res, dropped = scope.filter(*res); dropped > 0 {
+			log.Printf("saveResults: dropped %d results outside tenant %s's address space", dropped, tenant)
+		}
+	}
+	if cidrs := requestCIDRs(r); len(cidrs) > 0 {
+		var dropped int
+		if *res, dropped = newAddressSpace(cidrs).filter(*res); dropped > 0 {
+			log.Printf("saveResults: dropped %d results outside the API token's CIDRs", dropped)
+		}
+	}
+
+	if archive == nil {
+		return *res, nil, nil
+	}
+	// Make sure the whole payload is archived, not just what the decoder
+	// read
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return nil, nil, err
 	}
+	payload, err := archive.Bytes()
+	return *res, payload, err
+}
 
-	return count, nil
+// errOutsideScope is returned for results whose scope includes addresses
+// outside those the tenant or API token submitting them may see, whose ports
+// they could close.
+var errOutsideScope = errors.New("scope includes addresses outside those results may be submitted for")
+
+// checkScope checks a results submission's scope is within the CIDRs of its
+// API token and the address space of its tenant, if it has either.
+func (app *App) checkScope(r *http.Request, scope []string) error {
+	if len(scope) == 0 {
+		return nil
+	}
+	var spaces []addressSpace
+	if cidrs := requestCIDRs(r); len(cidrs) > 0 {
+		spaces = append(spaces, newAddressSpace(cidrs))
+	}
+	if tenant := requestTenant(r); tenant != "" {
+		space, err := app.loadTenantScope(tenant)
+		if err != nil {
+			return err
+		}
+		spaces = append(spaces, space)
+	}
+	for _, space := range spaces {
+		for _, s := range scope {
+			if !space.contains(s) {
+				return errOutsideScope
+			}
+		}
+	}
+	return nil
 }
 
 // Handler for POST /results
 func (app *App) recvResults(w http.ResponseWriter, r *http.Request) {
-	now := time.Now().UTC().Truncate(time.Second)
-	_, err := app.saveResults(w, r, now)
+	if app.quota.paused() {
+		http.Error(w, "Database is over its quota", http.StatusInsufficientStorage)
+		return
+	}
+	run, err := runMetadata(r)
 	if err != nil {
-		log.Println("recvResults: error saving results:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := headerScanTime(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A submission only closes ports in its scope, so mustn't claim to
+	// have scanned anything it can't see
+	switch err := app.checkScope(r, run.Scope); err {
+	case nil:
+	case errOutsideScope, sql.ErrNoRows:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	default:
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	now := time.Now().UTC().Truncate(time.Second)
+	ip := remoteIP(r)
+	agent := app.requestAgent(r)
+
+	if app.ingester != nil {
+		results, payload, err := app.decodeResults(w, r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		job := &ingestJob{Host: ip, Agent: agent, Time: now, Run: run, Results: results, Payload: payload}
+		switch err := app.ingester.enqueue(job); err {
+		case nil:
+		case errIngestFull:
+			// Tell the scanner to back off rather than queueing
+			// without limit
+			w.Header().Set("Retry-After", strconv.Itoa(int(app.ingester.retry/time.Second)))
+			http.Error(w, "Too many submissions waiting to be saved", http.StatusTooManyRequests)
+			return
+		case errIngestClosed:
+			http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+			return
+		default:
+			log.Println("recvResults: error spooling results:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		counterSubmissions.WithLabelValues("results").Inc()
+		var ports int64
+		for _, res := range results {
+			ports += int64(len(res.Ports))
+		}
+		app.auditIngest(r, ingestAudit{Host: ip, Agent: agent, Ports: ports, Queued: true})
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	saved, err := app.saveResults(w, r, now)
 	if err != nil {
-		ip = r.RemoteAddr
+		log.Println("recvResults: error saving results:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	err = app.db.SaveSubmission(ip, nil, now)
+	counterSubmissions.WithLabelValues("results").Inc()
+	app.auditIngest(r, ingestAudit{Host: ip, Agent: agent, Ports: saved.count})
+	run.Started = saved.started
+	err = dbError("save_submission", app.db.SaveSubmission(ip, nil, now, run))
 	if err != nil {
 		log.Println("recvResults: error saving submission:", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if saved.payload != nil {
+		if err := dbError("save_payload", app.db.SavePayload(ip, nil, now, saved.payload)); err != nil {
+			log.Println("recvResults: error archiving payload:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	app.agentScan(agent, now)
 
-	// Update metrics with latest data
+	app.notifyChanges(now, saved.added, nil)
+	app.nuclei.enqueue(saved.added)
+	app.updateResultMetrics(now)
+}
+
+// updateResultMetrics updates the results metrics after a submission at now.
+func (app *App) updateResultMetrics(now time.Time) {
 	results, err := app.db.ResultData("", "", "")
 	if err != nil {
-		log.Printf("saveResults: error fetching results for metrics update: %v\n", err)
-	} else {
-		gaugeSubmission.Set(float64(now.Unix()))
-		gaugeTotal.Set(float64(results.Total))
-		gaugeLatest.Set(float64(results.Latest))
-		gaugeNew.Set(float64(results.New))
+		log.Printf("updateResultMetrics: error fetching results: %v\n", err)
+		return
 	}
+	gaugeSubmission.Set(float64(now.Unix()))
+	gaugeTotal.Set(float64(results.Total))
+	gaugeLatest.Set(float64(results.Latest))
+	gaugeNew.Set(float64(results.New))
 }
 
 // Handler for POST /traceroute
 func (app *App) recvTraceroute(w http.ResponseWriter, r *http.Request) {
-	dest := r.FormValue("dest")
+	dest := scan.NormalizeIP(r.FormValue("dest"))
 	f, _, err := r.FormFile("traceroute")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -231,7 +722,7 @@ func (app *App) recvTraceroute(w http.ResponseWriter, r *http.Request) {
 
 // Handler for GET /traceroute/{ip}
 func (app *App) traceroute(w http.ResponseWriter, r *http.Request) {
-	ip := chi.URLParam(r, "ip")
+	ip := scan.NormalizeIP(chi.URLParam(r, "ip"))
 
 	path, err := app.db.LoadTraceroute(ip)
 	switch {
@@ -313,8 +804,14 @@ func staticHandler(w http.ResponseWriter, r *http.Request) {
 
 func (app *App) setupRouter(middlewares ...func(http.Handler) http.Handler) *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(rememberPeer)
 	r.Use(middleware.RealIP)
+	r.Use(requestID)
 	r.Use(middleware.Logger)
+	r.Use(instrument)
+	r.Use(app.authenticate)
+	r.Use(tokenScopes)
+	r.Use(app.rateLimit)
 	for _, mw := range middlewares {
 		r.Use(mw)
 	}
@@ -322,24 +819,177 @@ func (app *App) setupRouter(middlewares ...func(http.Handler) http.Handler) *chi
 	assets = loadAssetsFromDir("static")
 
 	r.Get("/", app.index)
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(requireUser)
+		r.With(app.cached).Get("/results", app.results)
+		r.With(operatorsOnly).Delete("/results", app.deleteResults)
+		// Any user can manage their own tokens
+		r.Route("/tokens", func(r chi.Router) {
+			r.Get("/", app.listAPITokens)
+			r.Post("/", app.newAPIToken)
+			r.Delete("/{id}", app.deleteAPIToken)
+		})
+		r.Route("/targets", func(r chi.Router) {
+			r.Use(unrestricted)
+			r.Get("/", app.listTargets)
+			r.Post("/", app.newTarget)
+			r.Get("/coverage", app.targetCoverage)
+			r.Put("/{id}", app.updateTarget)
+			r.Delete("/{id}", app.deleteTarget)
+			r.Post("/{id}/scan", app.newTargetJob)
+		})
+		// Everything else spans tenants
+		r.Group(func(r chi.Router) {
+			r.Use(operatorsOnly)
+			r.Post("/ack", app.batchAck)
+			r.Route("/acks", func(r chi.Router) {
+				r.Get("/", app.listAcks)
+				r.Post("/", app.newAck)
+				r.Delete("/{ip}/{port}/{proto}", app.deleteAck)
+			})
+			r.Route("/approvals", func(r chi.Router) {
+				r.Get("/", app.listApprovals)
+				r.Post("/{id}", app.approve)
+				r.Delete("/{id}", app.reject)
+			})
+			r.Get("/audit", app.listAudit)
+			r.Get("/banners", app.bannerChanges)
+			r.Get("/censys/{ip}", app.censysHost)
+			r.Get("/closed", app.closedPorts)
+			r.Get("/diff", app.diff)
+			r.Route("/fields", func(r chi.Router) {
+				r.Get("/", app.listFields)
+				r.Post("/", app.newField)
+				r.Delete("/{name}", app.deleteField)
+				r.Post("/{name}/values", app.setFieldValue)
+				r.Delete("/{name}/values", app.deleteFieldValue)
+			})
+			r.Get("/findings", app.listFindings)
+			r.Get("/certificates", app.listCertificates)
+			r.Get("/http", app.listHTTPResponses)
+			r.Get("/screenshots", app.listScreenshots)
+			r.Get("/screenshots/{ip}/{port}/{proto}", app.screenshotImage)
+			r.Get("/attributes", app.listAttributes)
+			r.Get("/agents", app.listAgents)
+			r.Put("/agents/{name}", app.updateAgent)
+			r.Get("/history", app.history)
+			r.Get("/internetdb", app.internetDBReport)
+			r.Get("/pdns/{ip}", app.passiveDNS)
+			r.Get("/payloads", app.payloads)
+			r.Get("/payloads/{id}", app.payload)
+			r.Get("/reputation", app.reputation)
+			r.Get("/runs", app.runs)
+			r.Get("/runs/{id}", app.run)
+			r.Get("/sources", app.listSources)
+			r.Get("/sources/compare", app.compareSources)
+			r.Route("/searches", func(r chi.Router) {
+				r.Get("/", app.listSavedSearches)
+				r.Post("/", app.newSavedSearch)
+				r.Delete("/{id}", app.deleteSavedSearch)
+				r.Get("/{id}/results", app.savedSearchResults)
+			})
+			r.Route("/baseline", func(r chi.Router) {
+				r.Get("/", app.listBaseline)
+				r.Post("/", app.newBaselineEntry)
+				r.Get("/violations", app.baselineViolations)
+				r.Delete("/{id}", app.deleteBaselineEntry)
+			})
+			r.Get("/compliance", app.complianceReport)
+			r.Get("/vulnerabilities", app.listVulnerabilities)
+			r.Route("/ignore", func(r chi.Router) {
+				r.Get("/", app.listIgnoreRules)
+				r.Post("/", app.newIgnoreRule)
+				r.Delete("/{id}", app.deleteIgnoreRule)
+			})
+			r.Route("/masscan", func(r chi.Router) {
+				r.Get("/config", app.masscanConfig)
+				r.Get("/excludes", app.masscanExcludes)
+			})
+			r.Route("/networks", func(r chi.Router) {
+				r.Get("/", app.listNetworks)
+				r.Post("/", app.newNetwork)
+				r.Get("/report", app.networkReport)
+				r.Delete("/{name}", app.deleteNetwork)
+			})
+			r.Route("/notes", func(r chi.Router) {
+				r.Get("/", app.listNotes)
+				r.Post("/", app.newNote)
+				r.Delete("/{id}", app.deleteNote)
+			})
+			r.Route("/rules", func(r chi.Router) {
+				r.Get("/", app.listAlertRules)
+				r.Post("/", app.newAlertRule)
+				r.Put("/{id}", app.updateAlertRule)
+				r.Delete("/{id}", app.deleteAlertRule)
+			})
+			r.Route("/tags", func(r chi.Router) {
+				r.Get("/", app.listTags)
+				r.Post("/", app.newTag)
+				r.Delete("/{ip}/{name}", app.deleteTag)
+			})
+			r.Route("/tasks", func(r chi.Router) {
+				r.Get("/", app.listTasks)
+				r.Post("/{name}", app.runTask)
+			})
+			r.Route("/tenants", func(r chi.Router) {
+				r.Get("/", app.listTenants)
+				r.Post("/", app.newTenant)
+				r.Delete("/{name}", app.deleteTenant)
+			})
+			r.Get("/uptime", app.uptime)
+		})
+	})
+	r.Route("/agents", func(r chi.Router) {
+		r.With(loginPage, operatorsOnly).Get("/", app.agentsPage)
+		r.With(requireUser).Post("/", app.agentHeartbeat)
+	})
 	r.Route("/admin", func(r chi.Router) {
+		r.Use(loginPage, operatorsOnly)
 		r.Get("/", app.adminHandler)
 		r.Post("/", app.adminHandler)
 	})
 	r.Get("/auth", app.authHandler)
-	r.Get("/ips.json", app.ips)
+	r.Get("/healthz", healthz)
+	r.Get("/host/{ip}", app.host)
+	r.With(operatorsOnly).Post("/host/{ip}/notes", app.hostNotesForm)
+	r.With(operatorsOnly).Post("/host/{ip}/tags", app.hostTagsForm)
+	r.With(requireUser, app.cached).Get("/ips.json", app.ips)
 	r.Route("/job", func(r chi.Router) {
+		r.Use(unrestricted)
 		r.Get("/", app.newJob)
 		r.Post("/", app.newJob)
 	})
-	r.Get("/jobs", app.jobs)
-	r.Get("/login", app.loginHandler)
+	r.Route("/jobs", func(r chi.Router) {
+		r.Get("/", app.jobs)
+		r.Post("/claim", app.claimJob)
+	})
+	if app.ldap != nil {
+		r.Get("/login", app.ldapLoginHandler)
+		r.Post("/login", app.ldapLoginHandler)
+	} else {
+		r.Get("/login", app.loginHandler)
+	}
 	r.Get("/logout", app.logoutHandler)
+	r.With(operatorsOnly).Post("/pdns", app.recvPassiveDNS)
+	r.Get("/readyz", app.readyz)
 	r.Post("/results", app.recvResults)
 	r.Put("/results/{id}", app.recvJobResults)
+	r.Route("/retention", func(r chi.Router) {
+		r.Use(loginPage, operatorsOnly)
+		r.Get("/", app.retentionHandler)
+		r.Post("/", app.retentionHandler)
+	})
+	r.With(operatorsOnly).Post("/searches", app.saveSearchForm)
+	r.With(loginPage, operatorsOnly).Get("/screenshots", app.screenshotsPage)
+	r.With(loginPage, operatorsOnly).Get("/sources", app.sourcesPage)
 	r.Get("/static/*", staticHandler)
-	r.Post("/traceroute", app.recvTraceroute)
-	r.Get("/traceroute/{ip}", app.traceroute)
+	r.Route("/targets", func(r chi.Router) {
+		r.Use(unrestricted)
+		r.Get("/", app.targetsPage)
+		r.Post("/", app.targetsPage)
+	})
+	r.With(operatorsOnly).Post("/traceroute", app.recvTraceroute)
+	r.With(operatorsOnly).Get("/traceroute/{ip}", app.traceroute)
 
 	return r
 }
@@ -349,6 +999,28 @@ func setupTemplates() {
 		"join": func(sep string, s []string) string {
 			return strings.Join(s, sep)
 		},
+		"percent": func(f float64) string {
+			return fmt.Sprintf("%.0f%%", f*100)
+		},
+		"upper": strings.ToUpper,
+		"severityLabel": func(severity string) string {
+			switch severity {
+			case "CRITICAL", "HIGH":
+				return "label-danger"
+			case "MEDIUM":
+				return "label-warning"
+			}
+			return "label-default"
+		},
+		"expiryLabel": func(t scan.Time) string {
+			switch left := time.Until(t.Time); {
+			case left < 0:
+				return "label-danger"
+			case left < certExpirySoon:
+				return "label-warning"
+			}
+			return "label-default"
+		},
 	}
 
 	tmpl = template.New("").Funcs(funcMap)
@@ -370,21 +1042,196 @@ func setupTemplates() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string, io.Writer) error{
+			"gen-client":     runGenClient,
+			"import":         runImport,
+			"init":           runInit,
+			"masscan-config": runMasscanConfig,
+			"replay":         runReplay,
+		}
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("%s: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	configFile := flag.String("config", "", "Read settings from this TOML `file`; flags and SCAN_* environment variables override it")
 	flag.BoolVar(&authDisabled, "no-auth", false, "Disable authentication")
 	flag.StringVar(&credsFile, "credentials", "client_secret.json",
 		"OAuth 2.0 credentials `file`\n"+
 			"Relative paths are taken as relative to -data.dir")
+	authTokens := flag.String("auth.tokens", "", "`File` of static API tokens, one \"token email\" pair per line")
+	authHeader := flag.String("auth.header", "", "Trust the username in this `header`, e.g. X-Remote-User, from an authenticating proxy")
+	authHeaderGroups := flag.String("auth.header.groups", "", "`Header` listing the proxy-authenticated user's groups, comma-separated")
+	authProxies := flag.String("auth.proxies", "127.0.0.1,::1", "Comma-separated `IPs` or CIDRs of proxies trusted to set -auth.header")
+	ldapURL := flag.String("ldap.url", "", "Log in with the LDAP server at this `URL` instead of Google, e.g. ldaps://ldap.example.com")
+	ldapUserDN := flag.String("ldap.userdn", "", "`DN` of LDAP users, with %s replaced by the username\n"+
+		"e.g. uid=%s,ou=people,dc=example,dc=com")
+	ldapGroupBase := flag.String("ldap.groupbase", "", "Base `DN` to search for the groups LDAP users are members of")
+	ldapInsecure := flag.Bool("ldap.insecure", false, "Bind to an ldap:// URL in plaintext instead of with StartTLS")
 	flag.StringVar(&dataDir, "data.dir", ".", "Data directory `path`")
+	redisURL := flag.String("redis.url", "", "Share cached responses, rate limits and login sessions between replicas in Redis at this `URL`\n"+
+		"e.g. redis://:password@localhost:6379/0, or rediss:// for TLS")
+	cacheTTL := flag.Duration("cache.ttl", 0, "Cache results API responses for this `duration`, or 0 not to")
+	requestLimit := flag.Int64("ratelimit", 0, "Limit each user, API token or anonymous address to this many `requests` a minute, or 0 for no limit")
+	flag.StringVar(&sessionKey, "session.key", "", "Base64 `key` of at least 32 bytes for signing session cookies\n"+
+		"Replicas behind a load balancer need the same key; by default one is generated in -data.dir")
+	geoipAccount := flag.String("geoip.account", "", "MaxMind account `ID` for downloading GeoIP databases")
+	geoipLicense := flag.String("geoip.license", "", "MaxMind license `key` for downloading GeoIP databases\n"+
+		"GeoIP databases are only downloaded if this and -geoip.account are set")
+	geoipEditions := flag.String("geoip.editions", "GeoLite2-City,GeoLite2-ASN", "Comma-separated GeoIP database `editions` to download")
+	geoipInterval := flag.Duration("geoip.interval", 24*time.Hour, "GeoIP database update `interval`")
+	geoipLookup := flag.Duration("geoip.lookup", 5*time.Minute, "How often to look up new IPs in the GeoIP databases, or 0 not to\n"+
+		"IPs are looked up if any of the -geoip.editions are in the data directory")
+	rdns := flag.Bool("rdns", false, "Look up the PTR records of IPs in the results")
+	rdnsInterval := flag.Duration("rdns.interval", 5*time.Minute, "How often to look up new IPs in reverse DNS")
+	rdnsRefresh := flag.Duration("rdns.refresh", 24*time.Hour, "How long to keep reverse DNS lookups before repeating them")
+	whois := flag.Bool("whois", false, "Look up the AS and netblock owner of IPs in the results")
+	whoisServer := flag.String("whois.server", "whois.cymru.com:43", "Bulk WHOIS server `host:port` to look up ASNs with")
+	whoisRDAP := flag.String("whois.rdap", "https://rdap.org/ip/", "RDAP `URL` to look up netblocks with, to which the IP is appended")
+	whoisInterval := flag.Duration("whois.interval", 5*time.Minute, "How often to look up new IPs in WHOIS")
+	whoisRefresh := flag.Duration("whois.refresh", 7*24*time.Hour, "How long to keep WHOIS lookups before repeating them")
+	internetDB := flag.Bool("internetdb", false, "Compare what Shodan's InternetDB sees of external IPs with the results")
+	internetDBURL := flag.String("internetdb.url", "https://internetdb.shodan.io/", "InternetDB `URL`, to which the IP is appended")
+	internetDBInterval := flag.Duration("internetdb.interval", 5*time.Minute, "How often to look up new IPs in InternetDB")
+	internetDBRefresh := flag.Duration("internetdb.refresh", 24*time.Hour, "How long to keep InternetDB lookups before repeating them")
+	censysID := flag.String("censys.id", "", "Censys API `ID`\n"+
+		"IPs are looked up in Censys if this and -censys.secret are set")
+	censysSecret := flag.String("censys.secret", "", "Censys API `secret`")
+	censysURL := flag.String("censys.url", "https://search.censys.io/api/v2/hosts/", "Censys hosts API `URL`, to which the IP is appended")
+	censysInterval := flag.Duration("censys.interval", time.Hour, "How often to look up new IPs in Censys")
+	censysRefresh := flag.Duration("censys.refresh", 30*24*time.Hour, "How long to keep Censys lookups before repeating them")
+	greyNoise := flag.Bool("greynoise", false, "Tag external IPs GreyNoise classifies as benign scanners")
+	greyNoiseKey := flag.String("greynoise.key", "", "GreyNoise API `key`, optional but lookups are heavily rate limited without one")
+	greyNoiseURL := flag.String("greynoise.url", "https://api.greynoise.io/v3/community/", "GreyNoise Community API `URL`, to which the IP is appended")
+	greyNoiseTag := flag.String("greynoise.tag", "benign-scanner", "`Tag` added to benign scanners")
+	greyNoiseInterval := flag.Duration("greynoise.interval", 5*time.Minute, "How often to look up new IPs in GreyNoise")
+	greyNoiseRefresh := flag.Duration("greynoise.refresh", 7*24*time.Hour, "How long to keep GreyNoise lookups before repeating them")
+	abuseIPDBKey := flag.String("abuseipdb.key", "", "AbuseIPDB API `key`\n"+
+		"External IPs are checked with AbuseIPDB if this is set")
+	abuseIPDBURL := flag.String("abuseipdb.url", "https://api.abuseipdb.com/api/v2/check", "AbuseIPDB check `URL`")
+	abuseIPDBInterval := flag.Duration("abuseipdb.interval", 15*time.Minute, "How often to check new IPs with AbuseIPDB")
+	abuseIPDBRefresh := flag.Duration("abuseipdb.refresh", 24*time.Hour, "How long to keep AbuseIPDB scores before checking again")
+	cveDir := flag.String("cve.dir", "", "Directory `path` of NVD JSON 1.1 feeds to match service banners against")
+	cveInterval := flag.Duration("cve.interval", time.Hour, "How often to match service banners against the CVE feeds")
+	nucleiPath := flag.String("nuclei", "", "`Path` to nuclei, to scan new HTTP(S) ports with")
+	nucleiTemplates := flag.String("nuclei.templates", "", "Comma-separated nuclei `templates` or template directories, or nuclei's defaults if empty")
+	nucleiPorts := flag.String("nuclei.ports", "80,443,8000,8080,8443,8888", "TCP `ports` to scan with nuclei when they're first seen open")
+	nucleiTimeout := flag.Duration("nuclei.timeout", 10*time.Minute, "How long each nuclei scan can run for")
+	enableCerts := flag.Bool("certs", false, "Collect the TLS certificates on open ports")
+	certPorts := flag.String("certs.ports", "443,465,636,993,995,8443", "TCP `ports` to collect TLS certificates from")
+	certInterval := flag.Duration("certs.interval", 5*time.Minute, "How often to collect certificates from new ports")
+	certRefresh := flag.Duration("certs.refresh", 24*time.Hour, "How long to keep certificates before collecting them again")
+	certTimeout := flag.Duration("certs.timeout", 10*time.Second, "How long to wait for each TLS handshake")
+	certWarn := flag.String("certs.warn", "30,7,1", "Comma-separated `days` before certificates expire to warn about them")
+	enableHTTPProbe := flag.Bool("httpprobe", false, "Request the front page of open web ports, recording their titles, status codes, Server headers and redirects")
+	httpProbePorts := flag.String("httpprobe.ports", "80,443,3000,5000,8000,8008,8080,8443,8888,9000", "TCP `ports` to probe over HTTP(S)")
+	httpProbeInterval := flag.Duration("httpprobe.interval", 5*time.Minute, "How often to probe new web ports")
+	httpProbeRefresh := flag.Duration("httpprobe.refresh", 24*time.Hour, "How long to keep web responses before probing again")
+	httpProbeTimeout := flag.Duration("httpprobe.timeout", 10*time.Second, "How long to wait for each web request")
+	screenshotChrome := flag.String("screenshots", "", "`Path` to Chrome or Chromium, to capture web ports found by -httpprobe with")
+	screenshotInterval := flag.Duration("screenshots.interval", 5*time.Minute, "How often to capture new web ports")
+	screenshotRefresh := flag.Duration("screenshots.refresh", 7*24*time.Hour, "How long to keep screenshots before capturing ports again")
+	screenshotTimeout := flag.Duration("screenshots.timeout", 30*time.Second, "How long each screenshot can take")
+	masscanPath := flag.String("masscan", "", "`Path` to masscan, to scan -masscan.ranges from the server itself")
+	masscanRanges := flag.String("masscan.ranges", "", "Comma-separated IPs, CIDRs or first-last `ranges` for -masscan to scan")
+	masscanPorts := flag.String("masscan.ports", "1-65535", "`Ports` for -masscan to scan, as masscan's -p")
+	masscanRate := flag.Int("masscan.rate", 1000, "Packets per second for -masscan to send")
+	masscanInterval := flag.Duration("masscan.interval", 24*time.Hour, "How often to run -masscan")
+	masscanTimeout := flag.Duration("masscan.timeout", 12*time.Hour, "How long each -masscan scan can take, or 0 for no limit")
+	enrichInterval := flag.Duration("enrich.interval", 5*time.Minute, "How often to run enrichers on new ports")
+	enrichRefresh := flag.Duration("enrich.refresh", 24*time.Hour, "How long to keep enricher attributes before running enrichers again")
+	defineEnricherFlags(flag.CommandLine)
+	scheduleSpecs := flag.String("schedule", "", "Semicolon-separated task `schedules` as name=schedule, overriding their intervals\n"+
+		"e.g. prune=0 3 * * *;certs=@every 1h")
+	scheduleConcurrency := flag.String("schedule.concurrency", "", "Comma-separated number of overlapping runs each task may have as `name=n` (default 1)")
+	reputationFeeds := flag.String("reputation.feeds", "", "Comma-separated IP reputation `feeds` as name=url\n"+
+		"e.g. spamhaus-drop=https://www.spamhaus.org/drop/drop.txt")
+	reputationInterval := flag.Duration("reputation.interval", 6*time.Hour, "IP reputation feed update `interval`")
+	pdnsURL := flag.String("pdns.url", "", "Passive DNS provider `URL`, to which the IP is appended\n"+
+		"e.g. https://www.circl.lu/pdns/query/")
+	pdnsAuth := flag.String("pdns.auth", "", "Passive DNS provider credentials as `user:password`")
+	webhookURLs := flag.String("webhook.url", "", "Comma-separated `URLs` to POST new port events to")
+	webhookSecret := flag.String("webhook.secret", "", "`Secret` for signing webhook payloads")
+	webhookLang := flag.String("webhook.lang", defaultLang, "`Language` of webhook messages")
+	notifyTemplates := flag.String("notify.templates", "", "`Directory` of <lang>.tmpl notification message templates")
+	notifyWindow := flag.Duration("notify.window", 0, "Collect notifications for this `duration` before sending them")
+	notifyBatch := flag.Int("notify.batch", 20, "Send a single summary instead of more than this `number` of notifications at once\n"+
+		"Every notification is sent individually if this is 0")
+	slackURL := flag.String("slack.url", "", "Slack incoming webhook `URL` for port events")
+	slackChannel := flag.String("slack.channel", "", "Slack `channel` to post to, overriding the webhook's default")
+	slackLang := flag.String("slack.lang", defaultLang, "`Language` of Slack messages")
+	slackTemplate := flag.String("slack.template", "", "Slack message `template`, overriding -slack.lang")
+	discordURL := flag.String("discord.url", "", "Discord webhook `URL` for port events")
+	discordLang := flag.String("discord.lang", defaultLang, "`Language` of Discord messages")
+	discordTemplate := flag.String("discord.template", "", "Discord message `template`, overriding -discord.lang")
+	teamsURL := flag.String("teams.url", "", "Microsoft Teams incoming webhook `URL` for port events")
+	teamsLang := flag.String("teams.lang", defaultLang, "`Language` of Teams messages")
+	teamsTemplate := flag.String("teams.template", "", "Teams message `template`, overriding -teams.lang")
+	telegramToken := flag.String("telegram.token", "", "Telegram bot `token` for new port alerts")
+	telegramChat := flag.String("telegram.chat", "", "Telegram chat `ID` to send alerts to")
+	telegramLang := flag.String("telegram.lang", defaultLang, "`Language` of Telegram messages")
+	telegramTemplate := flag.String("telegram.template", "", "Telegram message `template`, overriding -telegram.lang")
+	pagerDutyKey := flag.String("pagerduty.key", "", "PagerDuty Events API v2 integration `key` for critical ports")
+	pagerDutyCritical := flag.String("pagerduty.critical", "3389,445", "Comma-separated critical port `rules`, as port[/proto][@cidr]; rules without a CIDR only match public IPs")
+	smtpAddr := flag.String("smtp.addr", "", "SMTP server `address`:port for email alerts and digests")
+	smtpUser := flag.String("smtp.user", "", "SMTP `username`")
+	smtpPassword := flag.String("smtp.password", "", "SMTP `password`")
+	smtpFrom := flag.String("smtp.from", "scan@localhost", "Email sender `address`")
+	smtpTo := flag.String("smtp.to", "", "Comma-separated email `recipients`")
+	smtpAlerts := flag.Bool("smtp.alerts", true, "Email an alert for each new port")
+	smtpDigest := flag.String("smtp.digest", "", "Email a digest of changes `daily` or `weekly`")
+	smtpLang := flag.String("smtp.lang", defaultLang, "`Language` of email alerts")
+	dbJournal := flag.String("db.journal", sqlite.DefaultOptions.JournalMode, "SQLite journal `mode`; WAL lets pages be read while results are saved")
+	dbTimeout := flag.Duration("db.timeout", sqlite.DefaultOptions.BusyTimeout, "How long to wait for the database to be unlocked before failing")
+	dbSynchronous := flag.String("db.synchronous", sqlite.DefaultOptions.Synchronous, "SQLite synchronous `level`, OFF, NORMAL, FULL or EXTRA")
+	quotaMB := flag.Int64("db.quota", 0, "Alert when the database grows beyond this many `megabytes`")
+	quotaPause := flag.Bool("db.quota.pause", false, "Refuse results submissions while the database is over -db.quota")
+	selfMode := flag.String("self", selfFlag, "What to do with results for the server's own addresses, `mode` keep, flag or drop")
+	selfIPs := flag.String("self.ips", "", "Comma-separated `IPs` the server is also known by, such as a NAT address")
+	maxResultAge := flag.Duration("results.maxage", 7*24*time.Hour, "Accept scanners' timestamps for results up to this `duration` old\n"+
+		"Older results are treated as seen when they're received, as are all results if this is 0")
+	agentTimeout := flag.Duration("agents.timeout", 5*time.Minute, "Show agents as down when they haven't sent a heartbeat for this `duration`")
+	jobTimeout := flag.Duration("jobs.timeout", 12*time.Hour, "Offer a job to other agents when the agent which claimed it hasn't submitted results within this `duration`")
+	ingestWorkers := flag.Int("ingest.workers", 0, "Save results submissions in the background with this many `workers`\n"+
+		"Submissions are saved before responding if this is 0")
+	ingestQueue := flag.Int("ingest.queue", 100, "How many `submissions` may wait to be saved with -ingest.workers")
+	ingestRetry := flag.Duration("ingest.retry", 10*time.Second, "How long scanners are told to wait before retrying when the -ingest.queue and -ingest.spool are full")
+	ingestSpool := flag.Bool("ingest.spool", false, "Spill submissions to disk in -data.dir when the -ingest.queue is full, and return those which can't be saved\n"+
+		"Required with -ingest.workers")
+	ingestSpoolMax := flag.Int("ingest.spool.max", 1000, "How many `submissions` may be spilled to disk with -ingest.spool")
+	archivePayloads := flag.Bool("archive", false, "Archive the raw payload of each results submission")
+	approvals := flag.Bool("approvals", false, "Require a second admin to approve deleting users, exemptions and custom fields, and API tokens for other users")
+	retention := flag.Duration("retention", 0, "Delete results not seen for this `duration`, unless exempt\n"+
+		"Results are kept forever if this is 0")
 	httpAddr := flag.String("http.addr", ":80", "HTTP `address`:port")
 	flag.StringVar(&httpsAddr, "https.addr", ":443", "HTTPS `address`:port")
 	metricsAddr := flag.String("metrics.addr", "localhost:3000", "Metrics `address`:port")
+	debugAddr := flag.String("debug.addr", "", "Serve pprof profiles on this loopback `address`:port, such as 127.0.0.1:6060")
 	metricsTLS := flag.Bool("metrics.tls", false, "Enable AutoTLS for metrics, if -tls enabled\n"+
 		"This is useful when exposing metrics on a public interface")
 	enableTLS := flag.Bool("tls", false, "Enable AutoTLS")
 	tlsHostname := flag.String("tls.hostname", "", "(Optional) Restrict AutoTLS to `hostname`")
+	leaderElect := flag.Bool("leader.elect", false, "Elect one replica sharing the database to run periodic tasks such as pruning and digests")
+	leaderID := flag.String("leader.id", "", "`Name` of this replica for -leader.elect, defaulting to the hostname")
+	shutdownTimeout := flag.Duration("shutdown.timeout", 30*time.Second, "How long to wait for in-flight requests when shutting down")
 	flag.BoolVar(&verbose, "v", false, "Enable verbose logging")
 	flag.Parse()
 
+	// The command line takes precedence over the environment, which takes
+	// precedence over the config file
+	if err := loadEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		log.Fatalf("invalid environment: %v", err)
+	}
+	if *configFile != "" {
+		if err := loadConfig(*configFile, flag.CommandLine); err != nil {
+			log.Fatalf("failed to load -config: %v", err)
+		}
+	}
+
 	// Disable TLS on metrics if TLS wasn't generally enabled as autocert
 	// isn't set up.
 	if !*enableTLS && *metricsTLS {
@@ -396,15 +1243,328 @@ func main() {
 		credsFile = filepath.Join(dataDir, credsFile)
 	}
 
+	// Without Redis each replica caches and counts requests itself, and
+	// sessions are kept in cookies
+	var kv kvStore = newMemStore()
+	var sessionKV kvStore
+	if *redisURL != "" {
+		redis, err := newRedisStore(*redisURL, 5*time.Second)
+		if err != nil {
+			log.Fatalf("failed to connect to -redis.url: %v", err)
+		}
+		kv, sessionKV = redis, redis
+	}
+
+	var authProviders []authProvider
+	var ldap *ldapAuth
 	if !authDisabled {
-		oauthConfig()
+		if *ldapURL != "" {
+			if !strings.Contains(*ldapUserDN, "%s") {
+				log.Fatalf("-ldap.userdn must contain %%s for the username")
+			}
+			sessionConfig(sessionKV)
+			ldap = &ldapAuth{url: *ldapURL, userDN: *ldapUserDN, groupBase: *ldapGroupBase, timeout: 10 * time.Second, insecure: *ldapInsecure}
+		} else if *authHeader != "" {
+			// Users log in with the proxy instead of Google
+			sessionConfig(sessionKV)
+		} else if _, err := os.Stat(credsFile); os.IsNotExist(err) && *authTokens != "" {
+			// Without a login method only API tokens can authenticate,
+			// as set up by scan init
+			log.Println("Info: No credentials file, only API tokens can authenticate")
+			sessionConfig(sessionKV)
+		} else {
+			oauthConfig(sessionKV)
+		}
+		authProviders = append(authProviders, sessionAuth{})
+		if *authTokens != "" {
+			tokens, err := loadTokens(*authTokens)
+			if err != nil {
+				log.Fatalf("failed to load -auth.tokens: %v", err)
+			}
+			authProviders = append(authProviders, tokens)
+		}
 	}
 
-	db, err := sqlite.Open(filepath.Join(dataDir, sqlite.DefaultDBFile))
+	dsn, err := sqlite.Options{
+		JournalMode: *dbJournal,
+		BusyTimeout: *dbTimeout,
+		Synchronous: *dbSynchronous,
+	}.DSN(filepath.Join(dataDir, sqlite.DefaultDBFile))
+	if err != nil {
+		log.Fatalf("invalid database options: %v", err)
+	}
+	db, err := sqlite.Open(dsn)
 	if err != nil {
 		log.Fatalf("failed to open database: %v", err)
 	}
-	app := &App{db: db}
+	sched, err := newScheduler(*scheduleSpecs, *scheduleConcurrency)
+	if err != nil {
+		log.Fatalf("invalid -schedule: %v", err)
+	}
+	app := &App{
+		db:              db,
+		scheduler:       sched,
+		retention:       *retention,
+		maxResultAge:    *maxResultAge,
+		agentTimeout:    *agentTimeout,
+		jobTimeout:      *jobTimeout,
+		archivePayloads: *archivePayloads,
+		approvals:       *approvals,
+		authProviders:   authProviders,
+		ldap:            ldap,
+		kv:              kv,
+		cacheTTL:        *cacheTTL,
+		requestLimit:    *requestLimit,
+	}
+
+	if !authDisabled && *authHeader != "" {
+		h, err := newHeaderAuth(app, *authHeader, *authHeaderGroups, *authProxies)
+		if err != nil {
+			log.Fatalf("invalid -auth.proxies: %v", err)
+		}
+		// The proxy has already authenticated the user, so it comes first
+		app.authProviders = append([]authProvider{h}, app.authProviders...)
+	}
+	if !authDisabled {
+		// Tokens which aren't in the database are left to the -auth.tokens
+		// file
+		app.authProviders = append([]authProvider{apiTokenAuth{app: app}}, app.authProviders...)
+	}
+
+	if *leaderElect {
+		id := *leaderID
+		if id == "" {
+			if id, err = os.Hostname(); err != nil {
+				log.Fatalf("failed to find hostname for -leader.id: %v", err)
+			}
+		}
+		app.leader = &leader{id: id, ttl: 30 * time.Second}
+		// Elect before starting the periodic tasks so the leader runs them
+		// straight away
+		app.elect(time.Now())
+		go app.runLeader()
+	}
+
+	if app.retention > 0 {
+		app.scheduler.add("prune", time.Hour, app.prune)
+	}
+	app.scheduler.add("targets", time.Minute, app.scheduleTargets)
+
+	if err := setupMessages(*notifyTemplates); err != nil {
+		log.Fatalf("failed to load notification templates: %v", err)
+	}
+
+	for _, u := range strings.Split(*webhookURLs, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			app.notifiers = append(app.notifiers, newWebhook(u, *webhookSecret, *webhookLang))
+		}
+	}
+
+	if *slackURL != "" {
+		msg, err := newCustomMessage(*slackLang, *slackTemplate)
+		if err != nil {
+			log.Fatalf("invalid -slack.template: %v", err)
+		}
+		app.notifiers = append(app.notifiers, newSlack(*slackURL, *slackChannel, msg))
+	}
+
+	if *discordURL != "" {
+		msg, err := newCustomMessage(*discordLang, *discordTemplate)
+		if err != nil {
+			log.Fatalf("invalid -discord.template: %v", err)
+		}
+		app.notifiers = append(app.notifiers, newDiscord(*discordURL, msg))
+	}
+
+	if *teamsURL != "" {
+		msg, err := newCustomMessage(*teamsLang, *teamsTemplate)
+		if err != nil {
+			log.Fatalf("invalid -teams.template: %v", err)
+		}
+		app.notifiers = append(app.notifiers, newTeams(*teamsURL, msg))
+	}
+
+	if *telegramToken != "" && *telegramChat != "" {
+		msg, err := newCustomMessage(*telegramLang, *telegramTemplate)
+		if err != nil {
+			log.Fatalf("invalid -telegram.template: %v", err)
+		}
+		app.notifiers = append(app.notifiers, newTelegram(*telegramToken, *telegramChat, msg))
+	}
+
+	if *pagerDutyKey != "" {
+		rules, err := parseCriticalRules(*pagerDutyCritical)
+		if err != nil {
+			log.Fatalf("invalid -pagerduty.critical: %v", err)
+		}
+		app.notifiers = append(app.notifiers, newPagerDuty(*pagerDutyKey, rules))
+	}
+
+	if *smtpAddr != "" && *smtpTo != "" {
+		m := newMailer(*smtpAddr, *smtpUser, *smtpPassword, *smtpFrom, *smtpTo)
+		if *smtpAlerts {
+			app.notifiers = append(app.notifiers, emailAlert{mailer: m, lang: *smtpLang})
+		}
+		if *smtpDigest != "" {
+			period, ok := digestPeriods[*smtpDigest]
+			if !ok {
+				log.Fatalf("invalid -smtp.digest %q: must be daily or weekly", *smtpDigest)
+			}
+			app.scheduler.add("digest", time.Minute, app.digestTask(m, period))
+		}
+	}
+
+	if len(app.notifiers) > 0 {
+		app.batcher = newBatcher(*notifyWindow, *notifyBatch)
+		go app.runNotifications()
+	}
+	if *selfMode != selfKeep {
+		app.self, err = newSelfAddrs(*selfMode, *selfIPs)
+		if err != nil {
+			log.Fatalf("invalid -self or -self.ips: %v", err)
+		}
+	}
+	if *quotaMB > 0 {
+		app.quota = &dbQuota{limit: *quotaMB, pause: *quotaPause}
+		app.scheduler.add("quota", time.Minute, app.checkQuota)
+	}
+	if *ingestWorkers > 0 {
+		// Submissions are accepted before they're saved, so one which
+		// can't be saved would be lost without a spool to return it to
+		if !*ingestSpool {
+			log.Fatal("-ingest.workers needs -ingest.spool, so submissions which can't be saved aren't lost")
+		}
+		spool := filepath.Join(dataDir, "spool")
+		app.ingester, err = newIngester(*ingestQueue, spool, *ingestSpoolMax, *ingestRetry)
+		if err != nil {
+			log.Fatalf("failed to create -ingest.spool: %v", err)
+		}
+		app.startIngest(*ingestWorkers)
+		go app.ingester.runSpool()
+	}
+
+	if *pdnsURL != "" {
+		app.pdnsProvider = newPDNSProvider(*pdnsURL, *pdnsAuth)
+	}
+
+	if *reputationFeeds != "" {
+		app.reputationLists, err = newReputationLists(*reputationFeeds)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.scheduler.add("reputation", *reputationInterval, func(time.Time) { app.reputationLists.updateAll() })
+	}
+
+	if *geoipLicense != "" {
+		if *geoipAccount == "" {
+			log.Fatal("-geoip.account is required with -geoip.license")
+		}
+		u := newGeoIPUpdater(*geoipAccount, *geoipLicense, *geoipEditions, dataDir)
+		app.scheduler.add("geoip.update", *geoipInterval, func(time.Time) { u.updateAll() })
+	}
+	if *geoipLookup > 0 {
+		app.geoLocator = newGeoLocator(*geoipEditions, dataDir)
+		app.scheduler.add("geoip", *geoipLookup, app.enrichGeo)
+	}
+	if *rdns {
+		app.rdns = newRDNSResolver(*rdnsRefresh)
+		app.scheduler.add("rdns", *rdnsInterval, app.enrichRDNS)
+	}
+	if *whois {
+		app.whois = newWhoisClient(*whoisServer, *whoisRDAP, *whoisRefresh)
+		app.scheduler.add("whois", *whoisInterval, app.enrichWhois)
+	}
+	if *internetDB {
+		app.internetDB = newInternetDBClient(*internetDBURL, *internetDBRefresh)
+		app.scheduler.add("internetdb", *internetDBInterval, app.enrichInternetDB)
+	}
+	if *censysID != "" {
+		if *censysSecret == "" {
+			log.Fatal("-censys.secret is required with -censys.id")
+		}
+		app.censys = newCensysClient(*censysURL, *censysID, *censysSecret, *censysRefresh)
+		app.scheduler.add("censys", *censysInterval, app.enrichCensys)
+	}
+	if *nucleiPath != "" {
+		ports, err := scan.ParsePortSpec(*nucleiPorts)
+		if err != nil {
+			log.Fatal("-nuclei.ports: ", err)
+		}
+		var templates []string
+		if *nucleiTemplates != "" {
+			templates = strings.Split(*nucleiTemplates, ",")
+		}
+		app.nuclei = newNucleiScanner(*nucleiPath, templates, ports, *nucleiTimeout)
+		go app.runNuclei()
+	}
+	if *enableCerts {
+		ports, err := scan.ParsePortSpec(*certPorts)
+		if err != nil {
+			log.Fatal("-certs.ports: ", err)
+		}
+		warn, err := parseWarnDays(*certWarn)
+		if err != nil {
+			log.Fatal("-certs.warn: ", err)
+		}
+		app.certs = newCertProber(ports, *certRefresh, *certTimeout, warn)
+		app.scheduler.add("certs", *certInterval, app.refreshCerts)
+	}
+	if *enableHTTPProbe {
+		ports, err := scan.ParsePortSpec(*httpProbePorts)
+		if err != nil {
+			log.Fatal("-httpprobe.ports: ", err)
+		}
+		app.httpProbe = newHTTPProber(ports, *httpProbeRefresh, *httpProbeTimeout)
+		app.scheduler.add("httpprobe", *httpProbeInterval, app.enrichHTTP)
+	}
+	if *screenshotChrome != "" {
+		if app.httpProbe == nil {
+			log.Fatal("-screenshots needs -httpprobe to find web ports")
+		}
+		app.screenshots = newScreenshotter(*screenshotChrome, *screenshotRefresh, *screenshotTimeout)
+		app.scheduler.add("screenshots", *screenshotInterval, app.captureScreenshots)
+	}
+	if *masscanPath != "" {
+		ranges, err := parseMasscanRanges(*masscanRanges)
+		if err != nil {
+			log.Fatal("-masscan.ranges: ", err)
+		}
+		if _, err := scan.ParseMasscanPorts(*masscanPorts); err != nil {
+			log.Fatal("-masscan.ports: ", err)
+		}
+		if *masscanRate < 1 {
+			log.Fatal("-masscan.rate must be at least 1")
+		}
+		app.masscan = newMasscanRunner(*masscanPath, ranges, *masscanPorts, *masscanRate, *masscanTimeout)
+		app.scheduler.add("masscan", *masscanInterval, app.runMasscan).allowed = app.masscanAllowed
+	}
+	pipeline, err := newEnrichPipeline(*enrichRefresh)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pipeline != nil {
+		app.pipeline = pipeline
+		app.scheduler.add("enrich", *enrichInterval, app.enrichPorts)
+	}
+	if *cveDir != "" {
+		app.cves = newCVEMatcher(*cveDir)
+		app.scheduler.add("cve", *cveInterval, func(time.Time) { app.matchCVEs() })
+	}
+	if *abuseIPDBKey != "" {
+		app.abuseIPDB = newAbuseClient(*abuseIPDBURL, *abuseIPDBKey, *abuseIPDBRefresh)
+		app.scheduler.add("abuseipdb", *abuseIPDBInterval, app.enrichAbuseIPDB)
+	}
+	if *greyNoise {
+		if !tagName.MatchString(*greyNoiseTag) {
+			log.Fatal("-greynoise.tag must be letters, digits, dots, dashes and underscores")
+		}
+		app.greyNoise = newGreyNoiseClient(*greyNoiseURL, *greyNoiseKey, *greyNoiseTag, *greyNoiseRefresh)
+		app.scheduler.add("greynoise", *greyNoiseInterval, app.enrichGreyNoise)
+	}
+
+	if err := app.scheduler.start(); err != nil {
+		log.Fatalf("invalid -schedule: %v", err)
+	}
 
 	setupTemplates()
 
@@ -456,9 +1616,28 @@ func main() {
 		IdleTimeout:  idleTimeout,
 	}
 
+	servers := []*http.Server{httpSrv, metricsSrv}
+
+	if *debugAddr != "" {
+		if err := checkLoopback(*debugAddr); err != nil {
+			log.Fatalf("invalid -debug.addr: %v", err)
+		}
+		// No write timeout, as CPU profiles and traces run for as long as
+		// requested
+		debugSrv := &http.Server{
+			Addr:        *debugAddr,
+			Handler:     debugMux(),
+			ReadTimeout: readTimeout,
+			IdleTimeout: idleTimeout,
+		}
+		log.Println("Debug HTTP server starting on", debugSrv.Addr)
+		go serve(debugSrv.ListenAndServe)
+		servers = append(servers, debugSrv)
+	}
+
 	if !*metricsTLS {
 		log.Println("Metrics HTTP server starting on", metricsSrv.Addr)
-		go func() { log.Fatal(metricsSrv.ListenAndServe()) }()
+		go serve(metricsSrv.ListenAndServe)
 	}
 
 	if *enableTLS {
@@ -493,12 +1672,22 @@ func main() {
 			metricsSrv.Handler = metricsMux
 			metricsSrv.TLSConfig = tlsConfig
 			log.Println("Metrics HTTPS server starting on", metricsSrv.Addr)
-			go func() { log.Fatal(metricsSrv.ListenAndServeTLS("", "")) }()
+			go serve(func() error { return metricsSrv.ListenAndServeTLS("", "") })
 		}
 		log.Println("HTTPS server starting on", httpsSrv.Addr)
-		go func() { log.Fatal(httpsSrv.ListenAndServeTLS("", "")) }()
+		go serve(func() error { return httpsSrv.ListenAndServeTLS("", "") })
+		servers = append(servers, httpsSrv)
 	}
 
 	log.Println("HTTP server starting on", httpSrv.Addr)
-	log.Fatal(httpSrv.ListenAndServe())
+	go serve(httpSrv.ListenAndServe)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	log.Printf("Received %s, shutting down", <-sig)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := app.shutdown(ctx, servers...); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -2,13 +2,17 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -31,6 +35,44 @@ func createDB(test string) *sqlite.DB {
 	return db
 }
 
+func TestDBOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dsn, err := sqlite.DefaultOptions.DSN(filepath.Join(dir, sqlite.DefaultDBFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlite.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for pragma, want := range map[string]string{
+		"journal_mode": "wal",
+		"busy_timeout": "5000",
+		"synchronous":  "1", // NORMAL
+	} {
+		var got string
+		if err := db.QueryRow("PRAGMA " + pragma).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("expected %s %s, got %s", pragma, want, got)
+		}
+	}
+
+	for _, o := range []sqlite.Options{{JournalMode: "fast"}, {Synchronous: "sometimes"}} {
+		if _, err := o.DSN("scan.db"); err == nil {
+			t.Errorf("expected an error for %+v", o)
+		}
+	}
+}
+
 func TestLoadDataWithNoResults(t *testing.T) {
 	db := createDB("TestLoadDataWithNoResults")
 	defer db.Close()
@@ -63,7 +105,7 @@ func TestSaveData(t *testing.T) {
 		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
 		{IP: "192.0.2.3", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
 	}
-	count, err := db.SaveData(results, time.Now().UTC())
+	count, _, err := db.SaveData(results, time.Now().UTC())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -71,6 +113,146 @@ func TestSaveData(t *testing.T) {
 		t.Errorf("expected count %d, got %d", len(results), count)
 	}
 
+	// Only ports which haven't been seen are new, once each
+	results = []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+	}
+	_, added, err := db.SaveData(results, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0].Port != 443 {
+		t.Errorf("expected 443/tcp to be new, got %+v", added)
+	}
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4 {
+		t.Errorf("expected 4 ports, got %d", len(data))
+	}
+}
+
+func TestSaveDataBatches(t *testing.T) {
+	db := createDB("TestSaveDataBatches")
+	defer db.Close()
+
+	// Enough ports and banners to need several statements each
+	var results []scan.Result
+	for i := 0; i < 600; i++ {
+		ip := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		results = append(results, scan.Result{IP: ip, Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}})
+		banner := scan.Port{Port: 22, Proto: "tcp"}
+		banner.Service.Name = "ssh"
+		banner.Service.Banner = "SSH-2.0-OpenSSH_8.2p1"
+		results = append(results, scan.Result{IP: ip, Ports: []scan.Port{banner}})
+	}
+	count, added, err := db.SaveData(results, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 600 || len(added) != 600 {
+		t.Errorf("expected 600 ports saved and new, got %d and %d", count, len(added))
+	}
+	var banners int
+	if err := db.QueryRow(`SELECT count(*) FROM banner`).Scan(&banners); err != nil {
+		t.Fatal(err)
+	}
+	if banners != 600 {
+		t.Errorf("expected 600 banners, got %d", banners)
+	}
+}
+
+func TestSaveDataTimestamps(t *testing.T) {
+	db := createDB("TestSaveDataTimestamps")
+	defer db.Close()
+	app := App{db: db, maxResultAge: 24 * time.Hour}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var results []scan.Result
+	body := fmt.Sprintf(`[
+		{"ip": "192.0.2.1", "timestamp": "%d", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]},
+		{"ip": "192.0.2.2", "timestamp": %d, "ports": [{"port": 22, "proto": "tcp", "status": "open"}]},
+		{"ip": "192.0.2.3", "timestamp": "%s", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]},
+		{"ip": "192.0.2.4", "timestamp": "%d", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]},
+		{"ip": "192.0.2.5", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}
+	]`, now.Add(-time.Hour).Unix(), now.Add(time.Minute).Unix(), now.Add(-48*time.Hour).Format(time.RFC3339), now.Add(time.Hour).Unix())
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	// Too old and too far in the future are ignored, and slight skew is
+	// treated as now
+	if n := app.checkTimestamps(results, now); n != 2 {
+		t.Errorf("expected 2 timestamps ignored, got %d", n)
+	}
+	want := []time.Time{now.Add(-time.Hour), now, {}, {}, {}}
+	for i, r := range results {
+		if !r.Timestamp.Equal(want[i]) {
+			t.Errorf("%s: expected timestamp %v, got %v", r.IP, want[i], r.Timestamp)
+		}
+	}
+
+	_, added, err := db.SaveData(results, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, now, scan.Run{Started: runStarted(results, now)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ports were seen before the scan was submitted, but they're new
+	// rather than gone
+	data, err := db.LoadData(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != len(results) {
+		t.Fatalf("expected %d results, got %+v", len(results), data)
+	}
+	for _, r := range data {
+		if r.Gone || !r.New {
+			t.Errorf("%s: expected a new open port, got gone %v and new %v", r.IP, r.Gone, r.New)
+		}
+	}
+	events := testNotifier{"webhook", make(chan event, 10)}
+	app.notifiers = []notifier{events}
+	app.notifyChanges(now, added, nil)
+	for range results {
+		select {
+		case e := <-events.events:
+			if e.Type != eventNewPort {
+				t.Errorf("unexpected event %+v", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+	}
+	select {
+	case e := <-events.events:
+		t.Errorf("unexpected event %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// A delayed upload of an older observation doesn't move last seen back
+	late := []scan.Result{{IP: "192.0.2.1", Timestamp: scan.Timestamp{Time: now.Add(-2 * time.Hour)},
+		Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}}}
+	if _, _, err := db.SaveData(late, now); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = db.LoadData(sqlite.SQLFilter{Where: []string{"ip=?"}, Values: []interface{}{"192.0.2.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 {
+		t.Fatalf("expected one result, got %+v", data)
+	}
+	if !data[0].FirstSeen.Equal(now.Add(-2*time.Hour)) || !data[0].LastSeen.Equal(now.Add(-time.Hour)) {
+		t.Errorf("unexpected first seen %v and last seen %v", data[0].FirstSeen, data[0].LastSeen)
+	}
 }
 
 func TestResultData(t *testing.T) {
@@ -86,6 +268,242 @@ func TestResultData(t *testing.T) {
 	}
 }
 
+// TestResultDataCounts checks the counts done in SQL agree with the results.
+func TestResultDataCounts(t *testing.T) {
+	db := createDB("TestResultDataCounts")
+	defer db.Close()
+
+	first := time.Now().UTC().Truncate(time.Second)
+	second := first.Add(time.Hour)
+	port := func(ip string, p int) scan.Result {
+		return scan.Result{IP: ip, Ports: []scan.Port{{Port: p, Proto: "tcp", Status: "open"}}}
+	}
+	if _, _, err := db.SaveData([]scan.Result{port("192.0.2.1", 22), port("192.0.2.1", 80), port("198.51.100.1", 80)}, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, first, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+	// 192.0.2.1:80 closes, and two new ports are found, one acknowledged
+	if _, _, err := db.SaveData([]scan.Result{port("192.0.2.1", 22), port("192.0.2.2", 443), port("192.0.2.3", 443), port("198.51.100.1", 80)}, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, second, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveAcks(scan.Ack{IP: "192.0.2.3", Port: 443, Proto: "tcp", User: "user@example.com", Created: scan.Time{Time: second}}); err != nil {
+		t.Fatal(err)
+	}
+	// A job then finds 198.51.100.1:80 closed
+	id, err := db.SaveJob("198.51.100.0/24", "80", "tcp", "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", &id, second.Add(time.Minute), scan.Run{}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := db.ResultData("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := scan.Data{Total: len(data.Results), LastSeen: second.Unix()}
+	for _, r := range data.Results {
+		if r.Gone {
+			want.Closed++
+		} else {
+			want.Latest++
+		}
+		if r.New {
+			want.New++
+		}
+	}
+	if want.Total != 5 || want.Closed != 2 || want.New != 1 {
+		t.Errorf("unexpected results %+v", data.Results)
+	}
+	got := data
+	got.Results = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	// Counts are of the filtered results
+	data, err = db.ResultData("192.0.2.1", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Total != 2 || data.Closed != 1 || data.Latest != 1 || data.New != 0 {
+		t.Errorf("unexpected filtered counts %+v", data)
+	}
+}
+
+func TestResultPage(t *testing.T) {
+	db := createDB("TestResultPage")
+	defer db.Close()
+
+	first := time.Now().UTC().Truncate(time.Second)
+	var results []scan.Result
+	for i := 1; i <= 5; i++ {
+		results = append(results, scan.Result{IP: fmt.Sprintf("192.0.2.%d", i), Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}})
+	}
+	if _, _, err := db.SaveData(results, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, first, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+	// 192.0.2.5 closes
+	second := first.Add(time.Hour)
+	if _, _, err := db.SaveData(results[:4], second); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, second, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveField(scan.Field{Name: "owner"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveFieldValue(scan.FieldValue{Field: "owner", IP: "192.0.2.2", Value: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	banner := scan.Port{Port: 22, Proto: "tcp"}
+	banner.Service.Name = "ssh"
+	banner.Service.Banner = "SSH-2.0-OpenSSH_8.2p1"
+	if _, _, err := db.SaveData([]scan.Result{{IP: "192.0.2.3", Ports: []scan.Port{banner}}}, second); err != nil {
+		t.Fatal(err)
+	}
+
+	ips := func(data scan.Data) []string {
+		var ips []string
+		for _, r := range data.Results {
+			ips = append(ips, r.IP)
+		}
+		return ips
+	}
+	tests := []struct {
+		name string
+		q    sqlite.ResultQuery
+		ips  []string
+		rows int
+	}{
+		{"FirstPage", sqlite.ResultQuery{Page: 1, PerPage: 3}, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, 4},
+		{"LastPage", sqlite.ResultQuery{Page: 2, PerPage: 3}, []string{"192.0.2.4"}, 4},
+		{"All", sqlite.ResultQuery{View: sqlite.ViewAll, Page: 2, PerPage: 3}, []string{"192.0.2.4", "192.0.2.5"}, 5},
+		{"Closed", sqlite.ResultQuery{View: sqlite.ViewClosed, Page: 1, PerPage: 3}, []string{"192.0.2.5"}, 1},
+		{"Field", sqlite.ResultQuery{Fields: map[string]string{"owner": "web"}, PerPage: 3}, []string{"192.0.2.2"}, 1},
+		{"Unpaged", sqlite.ResultQuery{View: sqlite.ViewAll}, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5"}, 5},
+		{"Ports", sqlite.ResultQuery{View: sqlite.ViewAll, Ports: [][2]int{{1, 21}, {22, 22}}}, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5"}, 5},
+		{"OtherPorts", sqlite.ResultQuery{View: sqlite.ViewAll, Ports: [][2]int{{23, 65535}}}, nil, 0},
+		{"Proto", sqlite.ResultQuery{View: sqlite.ViewAll, Proto: "tcp", PerPage: 1}, []string{"192.0.2.1"}, 5},
+		{"OtherProto", sqlite.ResultQuery{View: sqlite.ViewAll, Proto: "udp"}, nil, 0},
+		{"SeenAfter", sqlite.ResultQuery{View: sqlite.ViewAll, SeenAfter: second}, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"}, 4},
+		{"SeenBefore", sqlite.ResultQuery{View: sqlite.ViewAll, SeenBefore: first}, nil, 0},
+		{"SeenWindow", sqlite.ResultQuery{View: sqlite.ViewAll, SeenAfter: first, SeenBefore: first.Add(time.Second), Ports: [][2]int{{22, 22}}}, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5"}, 5},
+		{"CIDR", sqlite.ResultQuery{IP: "192.0.2.0/30"}, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, 3},
+		{"HostCIDR", sqlite.ResultQuery{View: sqlite.ViewAll, IP: "192.0.2.5/32"}, []string{"192.0.2.5"}, 1},
+		{"OtherCIDR", sqlite.ResultQuery{IP: "10.0.0.0/8"}, nil, 0},
+		{"Service", sqlite.ResultQuery{Service: "ssh"}, []string{"192.0.2.3"}, 1},
+		{"Banner", sqlite.ResultQuery{Banner: "openssh"}, []string{"192.0.2.3"}, 1},
+		{"OtherBanner", sqlite.ResultQuery{Banner: "nginx"}, nil, 0},
+		{"Text", sqlite.ResultQuery{Text: "openssh 8.2*"}, []string{"192.0.2.3"}, 1},
+		{"TextService", sqlite.ResultQuery{Text: "service:ssh"}, []string{"192.0.2.3"}, 1},
+		{"OtherText", sqlite.ResultQuery{Text: "jenkins"}, nil, 0},
+		{"SortDesc", sqlite.ResultQuery{Sort: "ip", Desc: true, PerPage: 2}, []string{"192.0.2.4", "192.0.2.3"}, 4},
+		{"SortLastSeen", sqlite.ResultQuery{View: sqlite.ViewAll, Sort: "lastseen", PerPage: 2}, []string{"192.0.2.5", "192.0.2.1"}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, rows, err := db.ResultPage(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ips(data); !reflect.DeepEqual(got, tt.ips) || rows != tt.rows {
+				t.Errorf("want %v of %d rows, got %v of %d", tt.ips, tt.rows, got, rows)
+			}
+		})
+	}
+
+	// Totals cover every page
+	data, _, err := db.ResultPage(sqlite.ResultQuery{Page: 2, PerPage: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Total != 5 || data.Latest != 4 || data.Closed != 1 {
+		t.Errorf("unexpected totals %+v", data)
+	}
+}
+
+// TestResultPageClosedByRuns tests that pages of open and closed ports are
+// full when a job and a scoped submission closed ports across a page boundary.
+func TestResultPageClosedByRuns(t *testing.T) {
+	db := createDB("TestResultPageClosedByRuns")
+	defer db.Close()
+
+	first := time.Now().UTC().Truncate(time.Second)
+	var results []scan.Result
+	for i := 1; i <= 6; i++ {
+		results = append(results, scan.Result{IP: fmt.Sprintf("192.0.2.%d", i), Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}})
+	}
+	if _, _, err := db.SaveData(results, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, first, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A job of 192.0.2.0/30 only sees 192.0.2.1, closing 192.0.2.2 and
+	// 192.0.2.3
+	id, err := db.SaveJob("192.0.2.0/30", "22", "tcp", "sysadmin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := first.Add(time.Hour)
+	if _, _, err := db.SaveData(results[:1], second); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", &id, second, scan.Run{}); err != nil {
+		t.Fatal(err)
+	}
+	// A scan of 192.0.2.5 closes it
+	third := second.Add(time.Hour)
+	if err := db.SaveSubmission("192.0.2.100", nil, third, scan.Run{Scope: []string{"192.0.2.5"}, Ports: "22"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ips := func(data scan.Data) []string {
+		var ips []string
+		for _, r := range data.Results {
+			ips = append(ips, r.IP)
+		}
+		return ips
+	}
+	tests := []struct {
+		name string
+		q    sqlite.ResultQuery
+		ips  []string
+		rows int
+	}{
+		{"OpenFirstPage", sqlite.ResultQuery{Page: 1, PerPage: 2}, []string{"192.0.2.1", "192.0.2.4"}, 3},
+		{"OpenLastPage", sqlite.ResultQuery{Page: 2, PerPage: 2}, []string{"192.0.2.6"}, 3},
+		{"ClosedFirstPage", sqlite.ResultQuery{View: sqlite.ViewClosed, Page: 1, PerPage: 2}, []string{"192.0.2.2", "192.0.2.3"}, 3},
+		{"ClosedLastPage", sqlite.ResultQuery{View: sqlite.ViewClosed, Page: 2, PerPage: 2}, []string{"192.0.2.5"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, rows, err := db.ResultPage(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ips(data); !reflect.DeepEqual(got, tt.ips) || rows != tt.rows {
+				t.Errorf("want %v of %d rows, got %v of %d", tt.ips, tt.rows, got, rows)
+			}
+			if data.Total != 6 || data.Latest != 3 || data.Closed != 3 {
+				t.Errorf("unexpected totals %+v", data)
+			}
+		})
+	}
+}
+
 // TestIndexHandlerWithoutAuth tests fetching the index page with
 // authentication disabled
 func TestIndexHandlerWithoutAuth(t *testing.T) {
@@ -104,6 +522,42 @@ func TestIndexHandlerWithoutAuth(t *testing.T) {
 	}
 }
 
+func TestIndexSortAndPages(t *testing.T) {
+	db := createDB("TestIndexSortAndPages")
+	defer db.Close()
+	app := App{db: db}
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) (int, string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		app.index(w, httptest.NewRequest("GET", "/?"+query, nil))
+		return w.Code, w.Body.String()
+	}
+	code, body := get("sort=ip&dir=desc&per_page=1")
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", code, body)
+	}
+	if !strings.Contains(body, "192.0.2.2") || strings.Contains(body, ">192.0.2.1<") {
+		t.Error("expected only 192.0.2.2 on the first page")
+	}
+	if !strings.Contains(body, "Page 1 of 2") {
+		t.Error("pager not shown")
+	}
+	for _, query := range []string{"sort=secret", "sort=ip&dir=sideways"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, code)
+		}
+	}
+}
+
 // TestIPsHandler tests that we get expected JSON data
 func TestIPsHandler(t *testing.T) {
 	db := createDB("TestIPsHandler")
@@ -125,6 +579,86 @@ func TestIPsHandler(t *testing.T) {
 	}
 }
 
+// TestIPsHandlerAuth tests that the IPs are only listed to users and tokens
+// which may read results
+func TestIPsHandlerAuth(t *testing.T) {
+	db := createDB("TestIPsHandlerAuth")
+	defer db.Close()
+	app := App{db: db}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+
+	for _, tt := range []struct {
+		user *User
+		code int
+	}{
+		{nil, http.StatusUnauthorized},
+		{&User{Email: "scanner@example.com", Token: "scanner", Scope: scopeIngest}, http.StatusForbidden},
+		{&User{Email: "reader@example.com", Token: "reader", Scope: scopeRead}, http.StatusOK},
+	} {
+		app.authProviders = nil
+		if tt.user != nil {
+			app.authProviders = []authProvider{staticAuth{user: tt.user}}
+		}
+		res, err := http.Get(ts.URL + "/ips.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%+v: expected status %d, got %d", tt.user, tt.code, res.StatusCode)
+		}
+	}
+}
+
+func TestIPv6Results(t *testing.T) {
+	db := createDB("TestIPv6Results")
+	defer db.Close()
+	app := App{db: db}
+
+	data := bytes.NewBufferString(`[
+		{"ip": "2001:0db8:0000:0000:0000:0000:0000:0010", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]},
+		{"ip": "2001:db8::9", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]},
+		{"ip": "192.0.2.1", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}
+	]`)
+	r := httptest.NewRequest("POST", "/results", data)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	app.recvResults(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name string
+		q    sqlite.ResultQuery
+		ips  []string
+	}{
+		// Sorted by address, not text, and stored in compressed form
+		{"Sort", sqlite.ResultQuery{Sort: "ip"}, []string{"192.0.2.1", "2001:db8::9", "2001:db8::10"}},
+		{"CIDR", sqlite.ResultQuery{IP: "2001:db8::/120"}, []string{"2001:db8::9", "2001:db8::10"}},
+		{"Search", sqlite.ResultQuery{IP: "2001:0db8::0010"}, []string{"2001:db8::10"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _, err := db.ResultPage(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ips []string
+			for _, r := range data.Results {
+				ips = append(ips, r.IP)
+			}
+			if !reflect.DeepEqual(ips, tt.ips) {
+				t.Errorf("want %v, got %v", tt.ips, ips)
+			}
+		})
+	}
+}
+
 func TestResultsHandler(t *testing.T) {
 	db := createDB("TestResultsHandler")
 	defer db.Close()
@@ -248,3 +782,105 @@ traceroute to 192.0.2.1 (192.0.2.1), 64 hops max, 52 byte packets
 		t.Errorf("expect %q, got %q", route, string(body))
 	}
 }
+
+// TestClosedPorts tests that ports are only marked closed by scans which
+// cover them.
+func TestClosedPorts(t *testing.T) {
+	db := createDB("TestClosedPorts")
+	defer db.Close()
+
+	first := time.Now().UTC().Truncate(time.Second)
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, first, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A job covering only 198.51.100.0/24 finds nothing
+	id, err := db.SaveJob("198.51.100.0/24", "1-1024", "tcp", "sysadmin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", &id, first.Add(time.Minute), scan.Run{}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := db.ResultData("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Closed != 1 {
+		t.Errorf("expected 1 closed port, got %d", data.Closed)
+	}
+	for _, r := range data.Results {
+		if want := r.IP == "198.51.100.1"; r.Gone != want {
+			t.Errorf("%s: expected closed %v, got %v", r.IP, want, r.Gone)
+		}
+	}
+}
+
+// TestUnscopedSubmission tests that results submitted without a scope don't
+// close any ports, while those with one close the ports in it.
+func TestUnscopedSubmission(t *testing.T) {
+	db := createDB("TestUnscopedSubmission")
+	defer db.Close()
+	app := App{db: db}
+
+	seen := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, seen); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveSubmission("192.0.2.100", nil, seen, scan.Run{Scope: scan.ScopeAll}); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(scope string) {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/results", strings.NewReader(`[{"ip": "192.0.2.1", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}]`))
+		r.Header.Set("Content-Type", "application/json")
+		if scope != "" {
+			r.Header.Set("X-Scan-Scope", scope)
+		}
+		w := httptest.NewRecorder()
+		app.recvResults(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+	}
+	gone := func() []string {
+		t.Helper()
+		data, err := db.ResultData("", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ips []string
+		for _, r := range data.Results {
+			if r.Gone {
+				ips = append(ips, r.IP)
+			}
+		}
+		return ips
+	}
+
+	// Without a scope the scanner didn't say what it covered
+	post("")
+	if ips := gone(); len(ips) != 0 {
+		t.Errorf("expected no ports closed by an unscoped submission, got %v", ips)
+	}
+	// The next scan covered 192.0.2.0/24, so closes the port it didn't see
+	// there
+	post("192.0.2.0/24")
+	if ips := gone(); !reflect.DeepEqual(ips, []string{"192.0.2.2"}) {
+		t.Errorf("expected only 192.0.2.2 closed, got %v", ips)
+	}
+}
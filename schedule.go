@@ -0,0 +1,443 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// A schedule says when a task next runs.
+type schedule interface {
+	next(after time.Time) time.Time
+}
+
+// every runs a task at a fixed interval.
+type every time.Duration
+
+func (e every) next(after time.Time) time.Time {
+	return after.Add(time.Duration(e))
+}
+
+// cronSchedule runs a task at the minutes matching a crontab(5) expression.
+// Each field is a bit set of the values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// anyDay is set when either day field is *, so only the other has to
+	// match. Otherwise either matching is enough, as in cron.
+	anyDay bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseSchedule parses "@every <duration>", one of the @hourly style
+// aliases, or a five field cron expression of minute, hour, day of month,
+// month and day of week. Fields may be *, a value, a range, a list of them,
+// and have a /step.
+func parseSchedule(spec string) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if s := strings.TrimPrefix(spec, "@every "); s != spec {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("interval must be positive: %s", spec)
+		}
+		return every(d), nil
+	}
+	if alias, ok := cronAliases[spec]; ok {
+		spec = alias
+	}
+	f := strings.Fields(spec)
+	if len(f) != len(cronFields) {
+		return nil, fmt.Errorf("expected %d fields: %q", len(cronFields), spec)
+	}
+	var sets [5]uint64
+	for i, field := range f {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", cronFields[i].name, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		anyDay: f[2] == "*" || f[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				// 5/15 means from 5 to the end in steps of 15
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first matching minute after after, in its time zone, or
+// the zero time if none does within five years, e.g. for 30 February.
+func (c *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	loc := t.Location()
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			// Jump to the next matching minute in the hour, if any
+			if m := c.minute >> uint(t.Minute()+1); m != 0 && t.Minute()+1+bits.TrailingZeros64(m) < 60 {
+				t = t.Add(time.Duration(1+bits.TrailingZeros64(m)) * time.Minute)
+			} else {
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			}
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// task is a background job run on a schedule, such as an enrichment refresh
+// or a retention purge.
+type task struct {
+	name     string
+	spec     string
+	schedule schedule
+	// concurrency is how many runs may overlap. A run due while that many
+	// are still going is skipped.
+	concurrency int
+	fn          func(now time.Time)
+	// allowed, if set, says whether the task may run at now. Runs due when
+	// it may not are deferred until it may.
+	allowed func(now time.Time) bool
+
+	mu           sync.Mutex
+	running      int
+	runs         int
+	skipped      int
+	deferred     int
+	lastStart    time.Time
+	lastFinish   time.Time
+	lastDuration time.Duration
+	nextRun      time.Time
+}
+
+// start runs the task in the background, unless it's already running as
+// many times as it may, and reports whether it did.
+func (t *task) start(now time.Time) bool {
+	t.mu.Lock()
+	if t.running >= t.concurrency {
+		t.skipped++
+		t.mu.Unlock()
+		if verbose {
+			log.Printf("schedule: %s is still running, skipping", t.name)
+		}
+		return false
+	}
+	t.running++
+	t.runs++
+	t.lastStart = now
+	t.mu.Unlock()
+
+	go func() {
+		begin := time.Now()
+		t.fn(now)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.running--
+		t.lastFinish = time.Now()
+		t.lastDuration = t.lastFinish.Sub(begin)
+	}()
+	return true
+}
+
+// run starts the task whenever it's due. Tasks on an interval also run
+// straight away, as they always have; those on a cron expression wait for
+// the first matching minute.
+func (t *task) run() {
+	if _, ok := t.schedule.(every); ok {
+		t.start(time.Now())
+	}
+	for {
+		next := t.schedule.next(time.Now())
+		t.mu.Lock()
+		t.nextRun = next
+		t.mu.Unlock()
+		if next.IsZero() {
+			log.Printf("schedule: %s never runs again", t.name)
+			return
+		}
+		time.Sleep(time.Until(next))
+		if t.allowed != nil && !t.allowed(next) {
+			t.mu.Lock()
+			t.deferred++
+			t.mu.Unlock()
+			log.Printf("schedule: %s is outside its scan window, deferring", t.name)
+			for !t.allowed(time.Now()) {
+				time.Sleep(windowCheck)
+			}
+			next = time.Now()
+		}
+		t.start(next)
+	}
+}
+
+// windowCheck is how often a deferred task checks whether it may run.
+var windowCheck = time.Minute
+
+// taskStatus is a task as listed by the API.
+type taskStatus struct {
+	Name         string     `json:"name"`
+	Schedule     string     `json:"schedule"`
+	Concurrency  int        `json:"concurrency"`
+	Running      int        `json:"running"`
+	Runs         int        `json:"runs"`
+	Skipped      int        `json:"skipped"`
+	Deferred     int        `json:"deferred"`
+	LastStart    *time.Time `json:"last_start,omitempty"`
+	LastFinish   *time.Time `json:"last_finish,omitempty"`
+	LastDuration float64    `json:"last_duration_seconds"`
+	Next         *time.Time `json:"next,omitempty"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (t *task) status() taskStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return taskStatus{
+		Name:         t.name,
+		Schedule:     t.spec,
+		Concurrency:  t.concurrency,
+		Running:      t.running,
+		Runs:         t.runs,
+		Skipped:      t.skipped,
+		Deferred:     t.deferred,
+		LastStart:    optionalTime(t.lastStart),
+		LastFinish:   optionalTime(t.lastFinish),
+		LastDuration: t.lastDuration.Seconds(),
+		Next:         optionalTime(t.nextRun),
+	}
+}
+
+// scheduler runs the background tasks. Their schedules and concurrency can
+// be overridden by name with -schedule and -schedule.concurrency.
+type scheduler struct {
+	specs       map[string]string
+	concurrency map[string]int
+
+	mu    sync.Mutex
+	tasks []*task
+}
+
+// newScheduler parses the overrides: specs as name=schedule separated by
+// semicolons, as cron expressions contain commas, and concurrency as
+// comma-separated name=n.
+func newScheduler(specs, concurrency string) (*scheduler, error) {
+	s := &scheduler{specs: make(map[string]string), concurrency: make(map[string]int)}
+	for _, kv := range strings.Split(specs, ";") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("expected name=schedule: %q", kv)
+		}
+		name, spec := strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])
+		if _, err := parseSchedule(spec); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		s.specs[name] = spec
+	}
+	for _, kv := range strings.Split(concurrency, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("expected name=n: %q", kv)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[i+1:]))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%s: concurrency must be at least 1", kv[:i])
+		}
+		s.concurrency[strings.TrimSpace(kv[:i])] = n
+	}
+	return s, nil
+}
+
+// add creates a task run every interval, unless -schedule says otherwise.
+// Each run gets the time it was due.
+func (s *scheduler) add(name string, interval time.Duration, fn func(now time.Time)) *task {
+	spec := "@every " + interval.String()
+	if override, ok := s.specs[name]; ok {
+		spec = override
+	}
+	// Already checked by newScheduler
+	sched, _ := parseSchedule(spec)
+	t := &task{name: name, spec: spec, schedule: sched, concurrency: 1, fn: fn}
+	if n, ok := s.concurrency[name]; ok {
+		t.concurrency = n
+	}
+	s.mu.Lock()
+	s.tasks = append(s.tasks, t)
+	s.mu.Unlock()
+	return t
+}
+
+// task returns the task called name, or nil.
+func (s *scheduler) task(name string) *task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// start runs every task on its schedule. It returns an error if an
+// override names a task which doesn't exist, most likely a typo or a
+// feature which isn't enabled.
+func (s *scheduler) start() error {
+	for name := range s.specs {
+		if s.task(name) == nil {
+			return fmt.Errorf("no task called %s", name)
+		}
+	}
+	for name := range s.concurrency {
+		if s.task(name) == nil {
+			return fmt.Errorf("no task called %s", name)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		go t.run()
+	}
+	return nil
+}
+
+// Handler for GET /api/v1/tasks
+//
+// Lists the background tasks with when they last ran, how long they took and
+// when they next run.
+func (app *App) listTasks(w http.ResponseWriter, r *http.Request) {
+	statuses := []taskStatus{}
+	app.scheduler.mu.Lock()
+	for _, t := range app.scheduler.tasks {
+		statuses = append(statuses, t.status())
+	}
+	app.scheduler.mu.Unlock()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	render.JSON(w, r, statuses)
+}
+
+// Handler for POST /api/v1/tasks/{name}
+//
+// Runs a task now, rather than waiting for its schedule, unless it's
+// outside its scan window.
+func (app *App) runTask(w http.ResponseWriter, r *http.Request) {
+	t := app.scheduler.task(chi.URLParam(r, "name"))
+	if t == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	if t.allowed != nil && !t.allowed(now) {
+		http.Error(w, "Task is outside its scan window", http.StatusConflict)
+		return
+	}
+	if !t.start(now) {
+		http.Error(w, "Task is already running", http.StatusConflict)
+		return
+	}
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, t.status())
+}
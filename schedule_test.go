@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2021, 3, 17, 10, 7, 30, 0, time.UTC)
+	for _, tt := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"@every 5m", from.Add(5 * time.Minute)},
+		{"* * * * *", time.Date(2021, 3, 17, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 3, 17, 10, 15, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2021, 3, 17, 10, 25, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2021, 3, 18, 3, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 3, 17, 11, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2021, 3, 18, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2021, 3, 21, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		// Either day field matching is enough when both are set
+		{"0 0 20 * 4", time.Date(2021, 3, 18, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		s, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tt.spec, err)
+			continue
+		}
+		if got := s.next(from); !got.Equal(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.spec, tt.want, got)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@every -1m", "@every soon", "@fortnightly"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	if _, err := newScheduler("prune=0 3 * * * *", ""); err == nil {
+		t.Error("expected an invalid schedule to be rejected")
+	}
+	if _, err := newScheduler("", "prune=0"); err == nil {
+		t.Error("expected a concurrency of 0 to be rejected")
+	}
+	s, err := newScheduler("prune=0 3 * * *;typo=@daily", "certs=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	started := make(chan time.Time, 10)
+	wait := func(now time.Time) {
+		started <- now
+		<-release
+	}
+	prune := s.add("prune", time.Hour, wait)
+	certs := s.add("certs", 5*time.Minute, wait)
+	if prune.spec != "0 3 * * *" || certs.spec != "@every 5m0s" || certs.concurrency != 2 {
+		t.Errorf("expected the overrides applied, got %q and %q with %d", prune.spec, certs.spec, certs.concurrency)
+	}
+	if err := s.start(); err == nil {
+		t.Error("expected a schedule for a task which doesn't exist to be rejected")
+	}
+
+	now := time.Now()
+	if !prune.start(now) {
+		t.Fatal("expected the task started")
+	}
+	if got := <-started; !got.Equal(now) {
+		t.Errorf("expected the task run with the time it was due, got %v", got)
+	}
+	if prune.start(now) {
+		t.Error("expected a run skipped while the last is still going")
+	}
+	if !certs.start(now) || !certs.start(now) {
+		t.Error("expected two overlapping runs allowed")
+	}
+	<-started
+	<-started
+	if certs.start(now) {
+		t.Error("expected a third overlapping run skipped")
+	}
+
+	app := App{scheduler: s}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/api/v1/tasks/prune", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("expected a running task not started again, got %s", res.Status)
+	}
+	close(release)
+
+	var statuses []taskStatus
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		statuses = nil
+		res, err := http.Get(ts.URL + "/api/v1/tasks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.NewDecoder(res.Body).Decode(&statuses)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(statuses) == 2 && statuses[0].Running == 0 && statuses[1].Running == 0 {
+			break
+		}
+	}
+	if len(statuses) != 2 || statuses[0].Name != "certs" || statuses[1].Name != "prune" {
+		t.Fatalf("expected both tasks listed by name, got %+v", statuses)
+	}
+	if st := statuses[0]; st.Runs != 2 || st.Skipped != 1 || st.Running != 0 || st.LastFinish == nil {
+		t.Errorf("unexpected status of certs %+v", st)
+	}
+	if st := statuses[1]; st.Runs != 1 || st.Skipped != 2 || st.Schedule != "0 3 * * *" {
+		t.Errorf("unexpected status of prune %+v", st)
+	}
+
+	res, err = http.Post(ts.URL+"/api/v1/tasks/prune", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("expected the task started, got %s", res.Status)
+	}
+	<-started
+	res, err = http.Post(ts.URL+"/api/v1/tasks/nothing", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected an unknown task not found, got %s", res.Status)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png" // Chrome saves screenshots as PNGs
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// screenshotBatch is the most web ports captured each run. Chrome is run for
+// one port at a time.
+const screenshotBatch = 20
+
+// thumbnailWidth is the width in pixels of screenshot thumbnails.
+const thumbnailWidth = 320
+
+// screenshotter captures web ports with headless Chrome.
+type screenshotter struct {
+	// refresh is how long screenshots are kept before ports are captured
+	// again
+	refresh time.Duration
+	timeout time.Duration
+	// run runs Chrome with args
+	run func(ctx context.Context, args []string) error
+}
+
+func newScreenshotter(chrome string, refresh, timeout time.Duration) *screenshotter {
+	return &screenshotter{
+		refresh: refresh,
+		timeout: timeout,
+		run: func(ctx context.Context, args []string) error {
+			return exec.CommandContext(ctx, chrome, args...).Run()
+		},
+	}
+}
+
+// capture returns a PNG screenshot of the page at rawurl. Each capture uses
+// a new Chrome profile, so nothing is kept between pages.
+func (s *screenshotter) capture(rawurl string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "scan-screenshot")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "screenshot.png")
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	args := []string{
+		"--headless",
+		"--disable-gpu",
+		"--hide-scrollbars",
+		"--ignore-certificate-errors",
+		"--window-size=1280,800",
+		"--user-data-dir=" + filepath.Join(dir, "profile"),
+		"--screenshot=" + file,
+		rawurl,
+	}
+	if err := s.run(ctx, args); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(file)
+}
+
+// thumbnail scales a screenshot down to thumbnailWidth, averaging the pixels
+// each thumbnail pixel covers, and encodes it as a JPEG.
+func thumbnail(screenshot []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(screenshot))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil, errors.New("empty screenshot")
+	}
+	width := thumbnailWidth
+	if b.Dx() < width {
+		width = b.Dx()
+	}
+	height := b.Dy() * width / b.Dx()
+	if height == 0 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/height, b.Min.Y+(y+1)*b.Dy()/height
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/width, b.Min.X+(x+1)*b.Dx()/width
+			var r, g, bl, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, _ := src.At(sx, sy).RGBA()
+					r, g, bl, n = r+pr, g+pg, bl+pb, n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), 0xffff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// captureScreenshots captures the web ports never captured, or last
+// captured longer ago than the refresh interval. Ports which fail are
+// recorded without an image, so they're tried again after the refresh
+// interval rather than on every run.
+func (app *App) captureScreenshots(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	ports, err := app.db.LoadStaleScreenshotPorts(now.Add(-app.screenshots.refresh))
+	if err != nil {
+		log.Println("screenshots: error loading ports to capture:", err)
+		return
+	}
+	if len(ports) > screenshotBatch {
+		ports = ports[:screenshotBatch]
+	}
+	var captured int
+	for _, p := range ports {
+		s := scan.Screenshot{IP: p.IP, Port: p.Port, Proto: p.Proto, URL: p.URL, Taken: scan.Time{Time: now.UTC()}}
+		img, err := app.screenshots.capture(p.URL)
+		if err != nil && verbose {
+			log.Printf("screenshots: error capturing %s: %v", p.URL, err)
+		}
+		var thumb []byte
+		if img != nil {
+			if thumb, err = thumbnail(img); err != nil {
+				log.Printf("screenshots: error making thumbnail of %s: %v", p.URL, err)
+				img = nil
+			}
+		}
+		if err := app.db.SaveScreenshot(s, img, thumb); err != nil {
+			log.Printf("screenshots: error saving %s: %v", p.URL, err)
+			continue
+		}
+		if img != nil {
+			captured++
+		}
+	}
+	if verbose && len(ports) > 0 {
+		log.Printf("screenshots: captured %d of %d ports", captured, len(ports))
+	}
+}
+
+// Handler for GET /api/v1/screenshots
+//
+// Lists the screenshots taken, optionally only those of ip.
+func (app *App) listScreenshots(w http.ResponseWriter, r *http.Request) {
+	var filter sqlite.SQLFilter
+	if ip := r.URL.Query().Get("ip"); ip != "" {
+		filter.Where = append(filter.Where, `ip = ?`)
+		filter.Values = append(filter.Values, scan.NormalizeIP(ip))
+	}
+	screenshots, err := app.db.LoadScreenshots(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, screenshots)
+}
+
+// Handler for GET /api/v1/screenshots/{ip}/{port}/{proto}
+//
+// Serves the screenshot of a port as a PNG, or its thumbnail as a JPEG with
+// thumbnail=true.
+func (app *App) screenshotImage(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.Atoi(chi.URLParam(r, "port"))
+	if err != nil {
+		http.Error(w, "invalid port", http.StatusBadRequest)
+		return
+	}
+	thumb, _ := strconv.ParseBool(r.URL.Query().Get("thumbnail"))
+	img, err := app.db.LoadScreenshotImage(scan.NormalizeIP(chi.URLParam(r, "ip")), port, chi.URLParam(r, "proto"), thumb)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Screenshot not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if thumb {
+		w.Header().Set("Content-Type", "image/jpeg")
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+	}
+	// Pages are rendered from untrusted hosts, so the image must never be
+	// treated as anything else
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(img)
+}
+
+type screenshotsData struct {
+	indexData
+	Screenshots []scan.Screenshot
+}
+
+// Handler for GET /screenshots
+//
+// Shows the thumbnails of every web port captured, for triaging them at a
+// glance.
+func (app *App) screenshotsPage(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if !authDisabled {
+		u := currentUser(r)
+		if u == nil {
+			tmpl.ExecuteTemplate(w, "index", loginData(w, r))
+			return
+		}
+		user = *u
+	}
+
+	screenshots, err := app.db.LoadScreenshots(sqlite.SQLFilter{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := screenshotsData{
+		indexData:   indexData{Authenticated: true, User: user, URI: r.URL.Path},
+		Screenshots: screenshots,
+	}
+	tmpl.ExecuteTemplate(w, "screenshots", data)
+}
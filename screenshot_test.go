@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestScreenshots(t *testing.T) {
+	db := createDB("TestScreenshots")
+	defer db.Close()
+
+	var captured []string
+	screenshots := newScreenshotter("chrome", 24*time.Hour, time.Second)
+	screenshots.run = func(ctx context.Context, args []string) error {
+		url := args[len(args)-1]
+		captured = append(captured, url)
+		if strings.Contains(url, "192.0.2.2") {
+			return errors.New("net::ERR_CONNECTION_REFUSED")
+		}
+		var file string
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "--screenshot=") {
+				file = strings.TrimPrefix(arg, "--screenshot=")
+			}
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 640, 400))
+		for y := 0; y < 400; y++ {
+			for x := 0; x < 640; x++ {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(file, buf.Bytes(), 0600)
+	}
+
+	app := App{db: db, screenshots: screenshots}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	now := time.Now().UTC()
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 8080, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.3", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, now); err != nil {
+		t.Fatal(err)
+	}
+	err := db.SaveHTTPResponses([]scan.HTTPResponse{
+		{IP: "192.0.2.1", Port: 8080, Proto: "tcp", URL: "http://192.0.2.1:8080/", Status: 200, Updated: scan.Time{Time: now}},
+		{IP: "192.0.2.2", Port: 443, Proto: "tcp", URL: "https://192.0.2.2:443/", Status: 200, Updated: scan.Time{Time: now}},
+		{IP: "192.0.2.3", Port: 80, Proto: "tcp", Updated: scan.Time{Time: now}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.captureScreenshots(now)
+	if len(captured) != 2 {
+		t.Errorf("expected only ports which answered HTTP captured, got %v", captured)
+	}
+	captured = nil
+	app.captureScreenshots(now.Add(time.Hour))
+	if len(captured) != 0 {
+		t.Errorf("expected no captures before the refresh, got %v", captured)
+	}
+
+	res, err := http.Get(ts.URL + "/api/v1/screenshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []scan.Screenshot
+	err = json.NewDecoder(res.Body).Decode(&listed)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].URL != "http://192.0.2.1:8080/" {
+		t.Errorf("expected only the captured port listed, got %+v", listed)
+	}
+
+	for _, tt := range []struct {
+		path        string
+		code        int
+		contentType string
+		width       int
+	}{
+		{"/api/v1/screenshots/192.0.2.1/8080/tcp", http.StatusOK, "image/png", 640},
+		{"/api/v1/screenshots/192.0.2.1/8080/tcp?thumbnail=true", http.StatusOK, "image/jpeg", thumbnailWidth},
+		{"/api/v1/screenshots/192.0.2.2/443/tcp", http.StatusNotFound, "", 0},
+		{"/api/v1/screenshots/192.0.2.1/http/tcp", http.StatusBadRequest, "", 0},
+	} {
+		res, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.code, res.StatusCode)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		if ct := res.Header.Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%s: expected %s, got %s", tt.path, tt.contentType, ct)
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		if cfg.Width != tt.width {
+			t.Errorf("%s: expected width %d, got %d", tt.path, tt.width, cfg.Width)
+		}
+	}
+
+	thumb, _ := db.LoadScreenshotImage("192.0.2.1", 8080, "tcp", true)
+	img, err := jpeg.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := img.At(10, 10).RGBA(); r > 0x1000 || g > 0x1000 || b < 0xf000 {
+		t.Errorf("expected the thumbnail to keep the screenshot's colour, got %x %x %x", r, g, b)
+	}
+
+	for _, path := range []string{"/host/192.0.2.1", "/screenshots"} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if !strings.Contains(string(b), `src="/api/v1/screenshots/192.0.2.1/8080/tcp?thumbnail=true"`) {
+			t.Errorf("%s: expected the thumbnail", path)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// searchKeys are the terms understood in the search box, and the query
+// parameter each sets.
+var searchKeys = map[string]string{
+	"ip":       "ip",
+	"port":     "port",
+	"proto":    "proto",
+	"service":  "service",
+	"banner":   "banner",
+	"text":     "text",
+	"tag":      "tag",
+	"network":  "network",
+	"hostname": "hostname",
+	"source":   "source",
+	"country":  "country",
+	"city":     "city",
+	"org":      "org",
+	"asn":      "asn",
+	"owner":    "owner",
+	"abuse":    "abuse",
+	"cve":      "cve",
+	"severity": "severity",
+	"cert":     "cert",
+	"expires":  "expires",
+	"title":    "title",
+	"server":   "server",
+	"after":    "seen_after",
+	"before":   "seen_before",
+}
+
+// searchTokens splits a search into terms on spaces, keeping spaces inside
+// double quotes. The quotes are removed.
+func searchTokens(s string) ([]string, error) {
+	var tokens []string
+	var b strings.Builder
+	var quoted, started bool
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case unicode.IsSpace(r) && !quoted:
+			if started {
+				tokens = append(tokens, b.String())
+				b.Reset()
+				started = false
+			}
+		default:
+			b.WriteRune(r)
+			started = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote in search")
+	}
+	if started {
+		tokens = append(tokens, b.String())
+	}
+	return tokens, nil
+}
+
+// parseSearch parses a search such as
+//
+//	ip:10.0.0.0/8 port:443 proto:tcp service:http banner:"nginx"
+//
+// into the query parameters it stands for. A term without a key searches
+// IPs, so an address or network can be searched for on its own. Custom
+// fields are searched with field.name:value. Ports from more than one port
+// term are combined, more than one tag term must all match, and more than one
+// network term matches any of them.
+func parseSearch(s string) (url.Values, error) {
+	tokens, err := searchTokens(s)
+	if err != nil {
+		return nil, err
+	}
+	params := make(url.Values)
+	for _, token := range tokens {
+		key, value := "", token
+		// IPv6 addresses contain colons too, so only known keys count
+		if i := strings.Index(token, ":"); i > 0 {
+			k := strings.ToLower(token[:i])
+			if _, ok := searchKeys[k]; ok || strings.HasPrefix(k, "field.") {
+				key, value = k, token[i+1:]
+			}
+		}
+		switch {
+		case key == "":
+			key = "ip"
+		case strings.HasPrefix(key, "field."):
+			params.Add("field", strings.TrimPrefix(key, "field.")+":"+value)
+			continue
+		case value == "":
+			return nil, fmt.Errorf("search term %q has no value", key)
+		}
+		param := searchKeys[key]
+		if param == "tag" || param == "network" {
+			params.Add(param, value)
+			continue
+		}
+		if param == "port" {
+			if v := params.Get(param); v != "" {
+				value = v + "," + value
+			}
+		} else if params.Get(param) != "" {
+			return nil, fmt.Errorf("search term %q can only be given once", key)
+		}
+		params.Set(param, value)
+	}
+	return params, nil
+}
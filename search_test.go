@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		search string
+		want   url.Values
+		err    bool
+	}{
+		{"", url.Values{}, false},
+		{
+			`ip:10.0.0.0/8 port:443 proto:tcp service:http banner:"nginx 1.18"`,
+			url.Values{"ip": {"10.0.0.0/8"}, "port": {"443"}, "proto": {"tcp"}, "service": {"http"}, "banner": {"nginx 1.18"}},
+			false,
+		},
+		{"2001:db8::/32 PORT:22 port:80-90", url.Values{"ip": {"2001:db8::/32"}, "port": {"22,80-90"}}, false},
+		{"after:2020-03-01 field.owner:web field.env:prod", url.Values{"seen_after": {"2020-03-01"}, "field": {"owner:web", "env:prod"}}, false},
+		{"tag:dmz tag:customer-x port:443", url.Values{"tag": {"dmz", "customer-x"}, "port": {"443"}}, false},
+		{`banner:"unterminated`, nil, true},
+		{"port:", nil, true},
+		{"192.0.2.1 192.0.2.2", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSearch(tt.search)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: unexpected error %v", tt.search, err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: want %v, got %v", tt.search, tt.want, got)
+		}
+	}
+}
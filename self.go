@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// What to do with results for the server's own addresses
+const (
+	selfKeep = "keep"
+	selfFlag = "flag"
+	selfDrop = "drop"
+)
+
+// selfAddrs are the scan server's own IP addresses. Scanning from the server
+// itself, common in lab setups, reports its own ports along with the targets.
+type selfAddrs struct {
+	ips  map[string]bool
+	mode string
+}
+
+// newSelfAddrs finds the server's addresses from its interfaces, plus any in
+// extra, a comma-separated list for addresses the server is known by but
+// can't see, such as a NAT address.
+func newSelfAddrs(mode, extra string) (*selfAddrs, error) {
+	switch mode {
+	case selfKeep, selfFlag, selfDrop:
+	default:
+		return nil, fmt.Errorf("must be %s, %s or %s", selfKeep, selfFlag, selfDrop)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	s := &selfAddrs{ips: make(map[string]bool), mode: mode}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok {
+			s.ips[ipnet.IP.String()] = true
+		}
+	}
+	for _, ip := range strings.Split(extra, ",") {
+		ip = strings.TrimSpace(ip)
+		if ip == "" {
+			continue
+		}
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, fmt.Errorf("invalid IP %q", ip)
+		}
+		s.ips[parsed.String()] = true
+	}
+	return s, nil
+}
+
+// is reports whether ip is one of the server's addresses.
+func (s *selfAddrs) is(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && s.ips[parsed.String()]
+}
+
+// filter removes results for the server's own addresses, if they're being
+// dropped. It returns the results to keep and how many were dropped.
+func (s *selfAddrs) filter(results []scan.Result) ([]scan.Result, int) {
+	if s == nil || s.mode != selfDrop {
+		return results, 0
+	}
+	kept := results[:0]
+	for _, r := range results {
+		if !s.is(r.IP) {
+			kept = append(kept, r)
+		}
+	}
+	return kept, len(results) - len(kept)
+}
+
+// annotate marks the results for the server's own addresses, if they're being
+// flagged.
+func (s *selfAddrs) annotate(results []scan.IPInfo) {
+	if s == nil || s.mode != selfFlag {
+		return
+	}
+	for i := range results {
+		results[i].Self = s.is(results[i].IP)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestSelfAddrs(t *testing.T) {
+	if _, err := newSelfAddrs("ignore", ""); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+	if _, err := newSelfAddrs(selfDrop, "gateway"); err == nil {
+		t.Error("expected an error for an invalid IP")
+	}
+
+	s, err := newSelfAddrs(selfDrop, "198.51.100.1, 2001:db8::0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.is("127.0.0.1") {
+		t.Error("expected the loopback address to be found on an interface")
+	}
+	if !s.is("2001:db8::1") || !s.is("198.51.100.1") {
+		t.Error("expected the extra addresses, compared in canonical form")
+	}
+
+	// Interfaces vary, so only use the extra address from here
+	s = &selfAddrs{ips: map[string]bool{"198.51.100.1": true}, mode: selfDrop}
+
+	results := []scan.Result{{IP: "192.0.2.1"}, {IP: "198.51.100.1"}, {IP: "192.0.2.2"}}
+	kept, dropped := s.filter(results)
+	if dropped != 1 || len(kept) != 2 || kept[0].IP != "192.0.2.1" || kept[1].IP != "192.0.2.2" {
+		t.Errorf("unexpected results %+v with %d dropped", kept, dropped)
+	}
+
+	info := []scan.IPInfo{{IP: "192.0.2.1"}, {IP: "198.51.100.1"}}
+	s.annotate(info)
+	if info[1].Self {
+		t.Error("expected results not to be flagged when dropping")
+	}
+	s.mode = selfFlag
+	if _, dropped := s.filter([]scan.Result{{IP: "198.51.100.1"}}); dropped != 0 {
+		t.Error("expected results to be kept when flagging")
+	}
+	s.annotate(info)
+	if info[0].Self || !info[1].Self {
+		t.Errorf("unexpected flags %+v", info)
+	}
+
+	// Without a list of addresses, nothing is done
+	var none *selfAddrs
+	none.annotate(info)
+	results = []scan.Result{{IP: "192.0.2.1"}, {IP: "198.51.100.1"}}
+	if kept, _ := none.filter(results); len(kept) != 2 {
+		t.Errorf("unexpected results %+v", kept)
+	}
+}
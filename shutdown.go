@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// serve runs a server's listen function, exiting if it fails for any reason
+// other than being shut down.
+func serve(listen func() error) {
+	if err := listen(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// shutdown stops the servers accepting connections and waits for in-flight
+// requests to finish, so a submission isn't cut off half way through saving.
+// Queued submissions are saved, notifications still waiting in a batch are
+// then sent and the database is closed.
+func (app *App) shutdown(ctx context.Context, servers ...*http.Server) error {
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			return fmt.Errorf("error shutting down server on %s: %v", srv.Addr, err)
+		}
+	}
+	if app.ingester != nil {
+		app.ingester.close()
+	}
+	if app.batcher != nil {
+		app.sendBatches(app.batcher.drain(time.Now()))
+	}
+	return app.db.Close()
+}
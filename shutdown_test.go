@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	db := createDB("TestShutdown")
+	slack := testNotifier{"slack", make(chan event, 10)}
+	app := App{db: db, notifiers: []notifier{slack}, batcher: newBatcher(time.Hour, 0)}
+
+	// A notification waiting for its batch window is sent on shutdown
+	now := time.Now()
+	app.batcher.add(now, "slack", nil, event{Type: eventNewPort, Time: now, IP: "192.0.2.1", Port: 22, Proto: "tcp"})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	})}
+	go srv.Serve(l)
+
+	done := make(chan int)
+	go func() {
+		res, err := http.Post("http://"+l.Addr().String(), "application/json", nil)
+		if err != nil {
+			t.Error(err)
+			close(done)
+			return
+		}
+		res.Body.Close()
+		done <- res.StatusCode
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := app.shutdown(ctx, srv); err != nil {
+		t.Fatal(err)
+	}
+	if code := <-done; code != http.StatusCreated {
+		t.Errorf("expected the in-flight request to finish with 201, got %d", code)
+	}
+	select {
+	case e := <-slack.events:
+		if e.Port != 22 {
+			t.Errorf("unexpected event %+v", e)
+		}
+	default:
+		t.Error("expected the pending notification to be sent")
+	}
+	if err := db.Ping(); err == nil {
+		t.Error("expected the database to be closed")
+	}
+}
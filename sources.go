@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// defaultCompareWindow is how far back sources are compared unless since is
+// given.
+const defaultCompareWindow = 7 * 24 * time.Hour
+
+// compareParams parses the a, b and since query parameters of a comparison
+// of two sources.
+func compareParams(q url.Values) (a, b string, since time.Time, err error) {
+	a, b = q.Get("a"), q.Get("b")
+	if a == "" || b == "" {
+		return a, b, since, errors.New("a and b are required")
+	}
+	if a == b {
+		return a, b, since, errors.New("a and b must be different sources")
+	}
+	since = time.Now().UTC().Add(-defaultCompareWindow).Truncate(time.Second)
+	if s := q.Get("since"); s != "" {
+		if since, err = parseTime(s); err != nil {
+			return a, b, since, err
+		}
+	}
+	return a, b, since, nil
+}
+
+// Handler for GET /api/v1/sources
+// This lists the scanners and API tokens which have reported results.
+func (app *App) listSources(w http.ResponseWriter, r *http.Request) {
+	sources, err := app.db.LoadSources()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if sources == nil {
+		sources = []scan.Source{}
+	}
+
+	render.JSON(w, r, sources)
+}
+
+// Handler for GET /api/v1/sources/compare
+// This shows the ports seen by source a but not b since a time, and the other
+// way round. a and b are required, since defaults to a week ago.
+func (app *App) compareSources(w http.ResponseWriter, r *http.Request) {
+	a, b, since, err := compareParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cmp, err := app.db.CompareSources(a, b, since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, cmp)
+}
+
+type sourcesData struct {
+	indexData
+	Sources    []scan.Source
+	Comparison *scan.SourceComparison
+}
+
+// Handler for GET /sources
+// This lists the sources and compares the two chosen, if any.
+func (app *App) sourcesPage(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if !authDisabled {
+		u := currentUser(r)
+		if u == nil {
+			tmpl.ExecuteTemplate(w, "index", loginData(w, r))
+			return
+		}
+		user = *u
+	}
+
+	sources, err := app.db.LoadSources()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := sourcesData{
+		indexData: indexData{Authenticated: true, User: user, URI: r.URL.Path},
+		Sources:   sources,
+	}
+
+	q := r.URL.Query()
+	if q.Get("a") != "" || q.Get("b") != "" {
+		a, b, since, err := compareParams(q)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			data.Errors = append(data.Errors, err.Error())
+		} else {
+			cmp, err := app.db.CompareSources(a, b, since)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			data.Comparison = &cmp
+		}
+	}
+	tmpl.ExecuteTemplate(w, "sources", data)
+}
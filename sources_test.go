@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestCompareSources(t *testing.T) {
+	db := createDB("TestCompareSources")
+	defer db.Close()
+	app := App{db: db}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	port := func(source string, p int, seen time.Time) scan.Result {
+		return scan.Result{IP: "192.0.2.1", Source: source, Timestamp: scan.Timestamp{Time: seen},
+			Ports: []scan.Port{{Port: p, Proto: "tcp", Status: "open"}}}
+	}
+	// 22 is seen from both sides of the firewall, 443 only from outside and
+	// 3306 only from inside. 8080 was seen from outside too long ago.
+	results := []scan.Result{
+		port("external", 22, now.Add(-time.Hour)),
+		port("external", 443, now.Add(-2*time.Hour)),
+		port("external", 443, now.Add(-time.Hour)),
+		port("external", 8080, now.AddDate(0, 0, -30)),
+		port("internal", 22, now.Add(-time.Hour)),
+		port("internal", 3306, now.Add(-time.Hour)),
+		port("internal", 8080, now.Add(-time.Hour)),
+	}
+	if _, _, err := db.SaveData(results, now); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/api/v1/sources", nil)
+	w := httptest.NewRecorder()
+	app.listSources(w, r)
+	var sources []scan.Source
+	if err := json.NewDecoder(w.Body).Decode(&sources); err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("expected 2 sources, got %+v", sources)
+	}
+	for _, s := range sources {
+		if s.Ports != 3 || !s.LastSeen.Equal(now.Add(-time.Hour)) {
+			t.Errorf("expected %s to have seen 3 ports an hour ago, got %+v", s.Name, s)
+		}
+	}
+
+	r = httptest.NewRequest("GET", "/api/v1/sources/compare?a=external&b=internal", nil)
+	w = httptest.NewRecorder()
+	app.compareSources(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	var cmp scan.SourceComparison
+	if err := json.NewDecoder(w.Body).Decode(&cmp); err != nil {
+		t.Fatal(err)
+	}
+	if len(cmp.OnlyA) != 1 || cmp.OnlyA[0].Port != 443 || !cmp.OnlyA[0].FirstSeen.Equal(now.Add(-2*time.Hour)) || !cmp.OnlyA[0].LastSeen.Equal(now.Add(-time.Hour)) {
+		t.Errorf("expected only 443 seen from outside, got %+v", cmp.OnlyA)
+	}
+	var inside []int
+	for _, p := range cmp.OnlyB {
+		inside = append(inside, p.Port)
+	}
+	if len(inside) != 2 || inside[0] != 3306 || inside[1] != 8080 {
+		t.Errorf("expected 3306 and 8080 seen only from inside, got %v", inside)
+	}
+	if cmp.Both != 1 {
+		t.Errorf("expected 1 port seen by both, got %d", cmp.Both)
+	}
+
+	// Going back far enough, 8080 was seen by both
+	cmp, err := db.CompareSources("external", "internal", now.AddDate(0, 0, -31))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmp.OnlyB) != 1 || cmp.Both != 2 {
+		t.Errorf("expected 8080 seen by both since a month ago, got %+v", cmp)
+	}
+
+	for _, q := range []string{"", "?a=external", "?a=external&b=external", "?a=external&b=internal&since=yesterday"} {
+		r = httptest.NewRequest("GET", "/api/v1/sources/compare"+q, nil)
+		w = httptest.NewRecorder()
+		app.compareSources(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", q, w.Code)
+		}
+	}
+
+	r = httptest.NewRequest("GET", "/sources?a=external&b=internal", nil)
+	w = httptest.NewRecorder()
+	app.sourcesPage(w, r)
+	if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, "Seen by external but not internal") || !strings.Contains(body, "3306") {
+		t.Errorf("expected the comparison shown, got %d: %s", w.Code, body)
+	}
+
+	// Results and history can be narrowed to a source
+	data, _, err := db.ResultPage(sqlite.ResultQuery{Source: "internal", SeenAfter: now.AddDate(0, 0, -1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Results) != 3 {
+		t.Errorf("expected 3 ports seen from inside, got %+v", data.Results)
+	}
+	r = httptest.NewRequest("GET", "/api/v1/history?source=external&port=8080", nil)
+	w = httptest.NewRecorder()
+	app.history(w, r)
+	var timelines []scan.Timeline
+	if err := json.NewDecoder(w.Body).Decode(&timelines); err != nil {
+		t.Fatal(err)
+	}
+	if len(timelines) != 1 || len(timelines[0].Periods) != 1 || !timelines[0].Periods[0].From.Equal(now.AddDate(0, 0, -30)) {
+		t.Errorf("expected only the external observation of 8080, got %+v", timelines)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// tagName restricts tags to ones which can be typed in a search without
+// quoting.
+var tagName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// checkTag validates a tag and tidies its IP and name.
+func checkTag(t *scan.Tag) error {
+	t.IP = scan.NormalizeIP(t.IP)
+	t.Name = strings.ToLower(strings.TrimSpace(t.Name))
+	if net.ParseIP(t.IP) == nil {
+		return fmt.Errorf("invalid IP %q", t.IP)
+	}
+	if !tagName.MatchString(t.Name) {
+		return errors.New("tags must be letters, digits, dots, dashes and underscores")
+	}
+	return nil
+}
+
+// auditTag records tagging or untagging a host in the audit log.
+func (app *App) auditTag(user *User, event string, t scan.Tag) {
+	info, _ := json.Marshal(t)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditTag: error saving %s for %s: %v", event, t.IP, err)
+	}
+}
+
+// addTag saves a tag from user, which checkTag has validated.
+func (app *App) addTag(t scan.Tag, user *User) (scan.Tag, error) {
+	t.User = user.Email
+	t.Created = scan.Time{Time: time.Now().UTC()}
+	if err := app.db.SaveTag(t); err != nil {
+		return t, err
+	}
+	app.auditTag(user, "add_tag", t)
+	return t, nil
+}
+
+// Handler for GET /api/v1/tags
+// Tags can be narrowed with the ip and name query parameters.
+func (app *App) listTags(w http.ResponseWriter, r *http.Request) {
+	var filter sqlite.SQLFilter
+	q := r.URL.Query()
+	if ip := q.Get("ip"); ip != "" {
+		filter.Where = append(filter.Where, `ip = ?`)
+		filter.Values = append(filter.Values, scan.NormalizeIP(ip))
+	}
+	if name := q.Get("name"); name != "" {
+		filter.Where = append(filter.Where, `name = ?`)
+		filter.Values = append(filter.Values, strings.ToLower(name))
+	}
+	tags, err := app.db.LoadTags(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, tags)
+}
+
+// Handler for POST /api/v1/tags
+func (app *App) newTag(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var t scan.Tag
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkTag(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t, err := app.addTag(t, user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, t)
+}
+
+// Handler for DELETE /api/v1/tags/{ip}/{name}
+func (app *App) deleteTag(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	t := scan.Tag{IP: chi.URLParam(r, "ip"), Name: chi.URLParam(r, "name")}
+	if err := checkTag(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := app.db.DeleteTag(t.IP, t.Name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditTag(user, "delete_tag", t)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for POST /host/{ip}/tags
+// This adds the add_tag or removes the delete_tag form value from the host,
+// then returns to its page.
+func (app *App) hostTagsForm(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ip := scan.NormalizeIP(chi.URLParam(r, "ip"))
+
+	if name := r.PostForm.Get("add_tag"); name != "" {
+		t := scan.Tag{IP: ip, Name: name}
+		if err := checkTag(&t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := app.addTag(t, user); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if name := r.PostForm.Get("delete_tag"); name != "" {
+		t := scan.Tag{IP: ip, Name: name}
+		if err := checkTag(&t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := app.db.DeleteTag(t.IP, t.Name)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		default:
+			app.auditTag(user, "delete_tag", t)
+		}
+	}
+
+	http.Redirect(w, r, "/host/"+ip, http.StatusSeeOther)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestTags(t *testing.T) {
+	db := createDB("TestTags")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.3", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Post(ts.URL+"/api/v1/tags", "application/json", bytes.NewBufferString(`{"ip": "192.0.2.1", "name": "dmz"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without a user, got %d", res.StatusCode)
+	}
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "user@example.com"}}}
+
+	for _, tt := range []struct {
+		tag  string
+		code int
+	}{
+		{`{"ip": "192.0.2.1", "name": "dmz"}`, http.StatusCreated},
+		{`{"ip": "192.0.2.1", "name": "Customer-X"}`, http.StatusCreated},
+		{`{"ip": "192.0.2.2", "name": "dmz"}`, http.StatusCreated},
+		// Tagging twice is harmless
+		{`{"ip": "192.0.2.2", "name": "dmz"}`, http.StatusCreated},
+		{`{"ip": "192.0.2.300", "name": "dmz"}`, http.StatusBadRequest},
+		{`{"ip": "192.0.2.1", "name": "two words"}`, http.StatusBadRequest},
+	} {
+		res, err := http.Post(ts.URL+"/api/v1/tags", "application/json", bytes.NewBufferString(tt.tag))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.tag, tt.code, res.StatusCode)
+		}
+	}
+
+	res, err = http.Get(ts.URL + "/api/v1/tags?name=dmz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []scan.Tag
+	if err := json.NewDecoder(res.Body).Decode(&tags); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(tags) != 2 || tags[0].IP != "192.0.2.1" || tags[0].User != "user@example.com" {
+		t.Errorf("expected 2 hosts tagged dmz by user@example.com, got %+v", tags)
+	}
+
+	for _, tt := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"tag": {"dmz"}}, 2},
+		{url.Values{"q": {"tag:dmz tag:customer-x"}}, 1},
+		{url.Values{"q": {"tag:DMZ 192.0.2.2"}}, 1},
+		{url.Values{"tag": {"decommissioning"}}, 0},
+	} {
+		query, err := resultQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query.View = sqlite.ViewAll
+		data, _, err := db.ResultPage(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results) != tt.want {
+			t.Errorf("%v: expected %d results, got %d", tt.query, tt.want, len(data.Results))
+		}
+	}
+
+	data, err := db.LoadData(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{"192.0.2.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || len(data[0].Tags) != 2 || data[0].Tags[0] != "customer-x" || data[0].Tags[1] != "dmz" {
+		t.Errorf("expected 192.0.2.1 tagged customer-x and dmz, got %+v", data)
+	}
+
+	// The host page's form adds and removes tags
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	for _, form := range []url.Values{{"add_tag": {"decommissioning"}}, {"delete_tag": {"dmz"}}} {
+		res, err := client.PostForm(ts.URL+"/host/192.0.2.3/tags", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/host/192.0.2.3" {
+			t.Errorf("%v: expected redirect to the host page, got %d %s", form, res.StatusCode, res.Header.Get("Location"))
+		}
+	}
+	tags, err = db.LoadTags(sqlite.SQLFilter{Where: []string{`ip=?`}, Values: []interface{}{"192.0.2.3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "decommissioning" {
+		t.Errorf("expected 192.0.2.3 tagged decommissioning, got %+v", tags)
+	}
+
+	req, _ := http.NewRequest("DELETE", ts.URL+"/api/v1/tags/192.0.2.1/dmz", nil)
+	for _, code := range []int{http.StatusNoContent, http.StatusNotFound} {
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("expected status %d deleting tag, got %d", code, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// maxTargetDetail is the longest owner, environment or description a target
+// can have.
+const maxTargetDetail = 256
+
+// checkTarget validates a target and tidies its CIDR and ports. A single
+// host is stored as a CIDR of just that address. Targets scan all TCP ports
+// unless they say otherwise.
+func checkTarget(t *scan.Target) error {
+	t.CIDR = strings.TrimSpace(t.CIDR)
+	if ip := net.ParseIP(t.CIDR); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			bits = 8 * net.IPv4len
+		}
+		t.CIDR = fmt.Sprintf("%s/%d", t.CIDR, bits)
+	}
+	_, network, err := net.ParseCIDR(t.CIDR)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %q", t.CIDR)
+	}
+	t.CIDR = network.String()
+
+	t.Ports = strings.ReplaceAll(t.Ports, " ", "")
+	if t.Ports == "" {
+		t.Ports = "1-65535"
+	}
+	if _, err := scan.ParsePortSpec(t.Ports); err != nil {
+		return err
+	}
+	t.Proto = strings.ToLower(t.Proto)
+	switch t.Proto {
+	case "":
+		t.Proto = "tcp"
+	case "tcp", "udp":
+	default:
+		return fmt.Errorf("invalid proto %q", t.Proto)
+	}
+
+	t.Frequency = strings.TrimSpace(t.Frequency)
+	if t.Frequency != "" {
+		if _, err := parseSchedule(t.Frequency); err != nil {
+			return fmt.Errorf("invalid frequency: %v", err)
+		}
+	}
+	t.Owner = strings.TrimSpace(t.Owner)
+	t.Environment = strings.TrimSpace(t.Environment)
+	t.Description = strings.TrimSpace(t.Description)
+	if len(t.Owner) > maxTargetDetail || len(t.Environment) > maxTargetDetail || len(t.Description) > maxTargetDetail {
+		return fmt.Errorf("owner, environment and description must be at most %d characters", maxTargetDetail)
+	}
+	return nil
+}
+
+// auditTarget records a change to the target inventory in the audit log.
+func (app *App) auditTarget(user *User, event string, t scan.Target) {
+	info, _ := json.Marshal(t)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditTarget: error saving %s for target %d: %v", event, t.ID, err)
+	}
+}
+
+// addTarget validates and stores a new target for user, in their tenant's
+// address space if they have one. It returns the HTTP status for any error.
+func (app *App) addTarget(t scan.Target, user *User) (scan.Target, int, error) {
+	if err := checkTarget(&t); err != nil {
+		return t, http.StatusBadRequest, err
+	}
+	// Operators can add targets for a tenant, but tenants only for
+	// themselves
+	if user.Tenant != "" {
+		t.Tenant = user.Tenant
+	}
+	if status, err := app.checkTenantCIDR(t.Tenant, t.CIDR); err != nil {
+		return t, status, err
+	}
+	t.User = user.Email
+	t.Created = scan.Time{Time: time.Now().UTC()}
+	id, err := app.db.SaveTarget(t)
+	if err != nil {
+		return t, http.StatusInternalServerError, err
+	}
+	t.ID = id
+	app.auditTarget(user, "add_target", t)
+	return t, 0, nil
+}
+
+// loadUserTarget retrieves a target user may see: any for operators, or
+// their tenant's. It returns the HTTP status for any error, as there may be
+// no such target.
+func (app *App) loadUserTarget(id int64, user *User) (scan.Target, int, error) {
+	t, err := app.db.LoadTarget(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows), err == nil && user.Tenant != "" && t.Tenant != user.Tenant:
+		return t, http.StatusNotFound, errors.New("Target not found")
+	case err != nil:
+		return t, http.StatusInternalServerError, err
+	}
+	return t, 0, nil
+}
+
+// scanTarget queues a job to scan a target. It returns the HTTP status for
+// any error, as there may be no such target.
+func (app *App) scanTarget(id int64, user *User) (scan.Job, int, error) {
+	t, status, err := app.loadUserTarget(id, user)
+	if err != nil {
+		return scan.Job{}, status, err
+	}
+	job := scan.Job{CIDR: t.CIDR, Ports: t.Ports, Proto: t.Proto, Target: t.ID, Tenant: t.Tenant, RequestedBy: user.Email}
+	jobID, err := app.db.QueueJob(job)
+	if err != nil {
+		return job, http.StatusInternalServerError, err
+	}
+	job.ID = int(jobID)
+	app.auditTarget(user, "scan_target", t)
+	return job, 0, nil
+}
+
+// targetCoverage is how well a target is being scanned: when results were
+// last received from a job for it, how many jobs for it are waiting, when it
+// should next be scanned and whether that's passed, and how many ports are
+// open in it.
+type targetCoverage struct {
+	scan.Target
+	LastScan *scan.Time `json:"last_scan,omitempty"`
+	Pending  int        `json:"pending"`
+	Due      *scan.Time `json:"due,omitempty"`
+	Overdue  bool       `json:"overdue"`
+	Open     int        `json:"open"`
+}
+
+// targetDue returns when a target last scanned at last should next be
+// scanned, and false if it's only scanned by hand. A target which has never
+// been scanned is due now.
+func targetDue(t scan.Target, last, now time.Time) (time.Time, bool) {
+	if t.Frequency == "" {
+		return time.Time{}, false
+	}
+	sched, err := parseSchedule(t.Frequency)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if last.IsZero() {
+		return now, true
+	}
+	return sched.next(last), true
+}
+
+// scheduleTargets queues a job for each target due to be scanned at now by
+// its frequency, unless one is already waiting for results.
+func (app *App) scheduleTargets(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	targets, err := app.db.LoadTargets()
+	if err != nil {
+		log.Println("scheduleTargets: error loading targets:", err)
+		return
+	}
+	scans, err := app.db.LoadTargetScans()
+	if err != nil {
+		log.Println("scheduleTargets: error loading target scans:", err)
+		return
+	}
+	for _, t := range targets {
+		due, ok := targetDue(t, scans[t.ID].Last, now)
+		if !ok || due.After(now) || scans[t.ID].Pending > 0 {
+			continue
+		}
+		job := scan.Job{CIDR: t.CIDR, Ports: t.Ports, Proto: t.Proto, Target: t.ID, Tenant: t.Tenant, RequestedBy: "scheduler"}
+		id, err := app.db.QueueJob(job)
+		if err != nil {
+			log.Printf("scheduleTargets: error queueing job for target %d: %v", t.ID, err)
+			continue
+		}
+		log.Printf("scheduleTargets: queued job %d for target %d (%s)", id, t.ID, t.Frequency)
+	}
+}
+
+// coverage reports how well each target is being scanned at now, with
+// overdue targets first. A tenant only sees its own targets.
+func (app *App) coverage(now time.Time, tenant string) ([]targetCoverage, error) {
+	targets, err := app.loadTargets(tenant)
+	if err != nil {
+		return nil, err
+	}
+	scans, err := app.db.LoadTargetScans()
+	if err != nil {
+		return nil, err
+	}
+	report := []targetCoverage{}
+	for _, t := range targets {
+		c := targetCoverage{Target: t, Pending: scans[t.ID].Pending}
+		last := scans[t.ID].Last
+		if !last.IsZero() {
+			c.LastScan = &scan.Time{Time: last}
+		}
+		if due, ok := targetDue(t, last, now); ok {
+			c.Due = &scan.Time{Time: due}
+			c.Overdue = !due.After(now)
+		}
+		data, _, err := app.db.ResultPage(sqlite.ResultQuery{IP: t.CIDR, View: sqlite.ViewAll, PerPage: 1})
+		if err != nil {
+			return nil, err
+		}
+		c.Open = data.Latest
+		report = append(report, c)
+	}
+	sort.SliceStable(report, func(i, j int) bool {
+		return report[i].Overdue && !report[j].Overdue
+	})
+	return report, nil
+}
+
+// loadTargets retrieves the targets of a tenant, or all of them for
+// operators.
+func (app *App) loadTargets(tenant string) ([]scan.Target, error) {
+	targets, err := app.db.LoadTargets()
+	if err != nil || tenant == "" {
+		return targets, err
+	}
+	own := []scan.Target{}
+	for _, t := range targets {
+		if t.Tenant == tenant {
+			own = append(own, t)
+		}
+	}
+	return own, nil
+}
+
+// targetID parses the ID of a target from the URL.
+func targetID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid target ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// Handler for GET /api/v1/targets
+func (app *App) listTargets(w http.ResponseWriter, r *http.Request) {
+	targets, err := app.loadTargets(requestTenant(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, targets)
+}
+
+// Handler for POST /api/v1/targets
+func (app *App) newTarget(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var t scan.Target
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t, status, err := app.addTarget(t, user)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s/%d", strings.TrimSuffix(r.URL.Path, "/"), t.ID))
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, t)
+}
+
+// Handler for PUT /api/v1/targets/{id}
+// This replaces the target's details.
+func (app *App) updateTarget(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	id, ok := targetID(w, r)
+	if !ok {
+		return
+	}
+	var t scan.Target
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkTarget(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	old, status, err := app.loadUserTarget(id, user)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if status, err := app.checkTenantCIDR(old.Tenant, t.CIDR); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	t.ID = id
+	err = app.db.UpdateTarget(t)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Target not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if t, err = app.db.LoadTarget(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditTarget(user, "update_target", t)
+
+	render.JSON(w, r, t)
+}
+
+// Handler for DELETE /api/v1/targets/{id}
+func (app *App) deleteTarget(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	id, ok := targetID(w, r)
+	if !ok {
+		return
+	}
+	if _, status, err := app.loadUserTarget(id, user); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	err := app.db.DeleteTarget(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Target not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditTarget(user, "delete_target", scan.Target{ID: id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for POST /api/v1/targets/{id}/scan
+// This queues a job to scan the target, returning the job.
+func (app *App) newTargetJob(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	id, ok := targetID(w, r)
+	if !ok {
+		return
+	}
+	job, status, err := app.scanTarget(id, user)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, job)
+}
+
+// Handler for GET /api/v1/targets/coverage
+func (app *App) targetCoverage(w http.ResponseWriter, r *http.Request) {
+	report, err := app.coverage(time.Now().UTC(), requestTenant(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, report)
+}
+
+type targetsData struct {
+	indexData
+	Targets []targetCoverage
+}
+
+// Handler for GET and POST /targets
+//
+// Shows the inventory with its coverage. The form adds a target, deletes
+// one or queues a job to scan one.
+func (app *App) targetsPage(w http.ResponseWriter, r *http.Request) {
+	// Changes are audited, so need a user to attribute them to
+	if authDisabled {
+		http.Error(w, "Target interface not available when authentication is disabled.", http.StatusNotImplemented)
+		return
+	}
+
+	u := currentUser(r)
+	if u == nil {
+		tmpl.ExecuteTemplate(w, "index", loginData(w, r))
+		return
+	}
+	user := *u
+
+	data := targetsData{
+		indexData: indexData{Authenticated: true, User: user, URI: r.URL.Path},
+	}
+
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		status, err := app.targetsFormProcess(r.PostForm, &user)
+		if err != nil {
+			if status == http.StatusInternalServerError {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			data.Errors = append(data.Errors, err.Error())
+			w.WriteHeader(status)
+		}
+	}
+
+	report, err := app.coverage(time.Now().UTC(), user.Tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.Targets = report
+
+	tmpl.ExecuteTemplate(w, "targets", data)
+}
+
+// targetsFormProcess makes the change submitted with the targets form. It
+// returns the HTTP status for any error.
+func (app *App) targetsFormProcess(f url.Values, user *User) (int, error) {
+	parseID := func(s string) (int64, error) {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, errors.New("Invalid target ID")
+		}
+		return id, nil
+	}
+
+	switch {
+	case f.Get("delete_id") != "":
+		id, err := parseID(f.Get("delete_id"))
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if _, status, err := app.loadUserTarget(id, user); err != nil {
+			return status, err
+		}
+		err = app.db.DeleteTarget(id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return http.StatusNotFound, errors.New("Target not found")
+		case err != nil:
+			return http.StatusInternalServerError, err
+		}
+		app.auditTarget(user, "delete_target", scan.Target{ID: id})
+	case f.Get("scan_id") != "":
+		id, err := parseID(f.Get("scan_id"))
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if _, status, err := app.scanTarget(id, user); err != nil {
+			return status, err
+		}
+	default:
+		t := scan.Target{
+			CIDR:        f.Get("add_cidr"),
+			Ports:       f.Get("add_ports"),
+			Proto:       f.Get("add_proto"),
+			Owner:       f.Get("add_owner"),
+			Environment: f.Get("add_environment"),
+			Frequency:   f.Get("add_frequency"),
+			Description: f.Get("add_description"),
+		}
+		if _, status, err := app.addTarget(t, user); err != nil {
+			return status, err
+		}
+	}
+	return 0, nil
+}
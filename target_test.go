@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestTargets(t *testing.T) {
+	db := createDB("TestTargets")
+	defer db.Close()
+	app := App{db: db}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Post(ts.URL+"/api/v1/targets", "application/json", bytes.NewBufferString(`{"cidr": "192.0.2.0/24"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without a user, got %d", res.StatusCode)
+	}
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "user@example.com"}}}
+
+	for _, tt := range []struct {
+		target string
+		code   int
+	}{
+		{`{"cidr": "192.0.2.7/24", "owner": "web team", "environment": "production", "frequency": "@daily"}`, http.StatusCreated},
+		{`{"cidr": "198.51.100.1", "ports": "80, 443", "proto": "udp", "frequency": "@every 168h"}`, http.StatusCreated},
+		{`{"cidr": "203.0.113.0/24"}`, http.StatusCreated},
+		{`{"cidr": "192.0.2.0/33"}`, http.StatusBadRequest},
+		{`{"cidr": "192.0.2.0/24", "ports": "http"}`, http.StatusBadRequest},
+		{`{"cidr": "192.0.2.0/24", "proto": "icmp"}`, http.StatusBadRequest},
+		{`{"cidr": "192.0.2.0/24", "frequency": "sometimes"}`, http.StatusBadRequest},
+	} {
+		res, err := http.Post(ts.URL+"/api/v1/targets", "application/json", bytes.NewBufferString(tt.target))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.target, tt.code, res.StatusCode)
+		}
+	}
+
+	targets, err := db.LoadTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 3 {
+		t.Fatalf("expected 3 targets, got %+v", targets)
+	}
+	if tg := targets[0]; tg.CIDR != "192.0.2.0/24" || tg.Ports != "1-65535" || tg.Proto != "tcp" || tg.User != "user@example.com" {
+		t.Errorf("unexpected target %+v", tg)
+	}
+	if tg := targets[1]; tg.CIDR != "198.51.100.1/32" || tg.Ports != "80,443" || tg.Proto != "udp" {
+		t.Errorf("unexpected target %+v", tg)
+	}
+
+	req, _ := http.NewRequest("PUT", ts.URL+"/api/v1/targets/3", strings.NewReader(`{"cidr": "203.0.113.0/25", "owner": "ops"}`))
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 updating target, got %d", res.StatusCode)
+	}
+	if tg, err := db.LoadTarget(3); err != nil || tg.CIDR != "203.0.113.0/25" || tg.Owner != "ops" || tg.User != "user@example.com" {
+		t.Errorf("unexpected updated target %+v, %v", tg, err)
+	}
+
+	// Scanning a target queues a job for it
+	res, err = http.Post(ts.URL+"/api/v1/targets/1/scan", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job scan.Job
+	json.NewDecoder(res.Body).Decode(&job)
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated || job.CIDR != "192.0.2.0/24" || job.Ports != "1-65535" || job.Target != 1 {
+		t.Errorf("expected a job for target 1, got %d %+v", res.StatusCode, job)
+	}
+	if res, _ := http.Post(ts.URL+"/api/v1/targets/99/scan", "", nil); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 scanning a missing target, got %d", res.StatusCode)
+	}
+
+	now := time.Now().UTC()
+	report, err := app.coverage(now, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 3 {
+		t.Fatalf("expected coverage of 3 targets, got %+v", report)
+	}
+	// Never scanned targets with a frequency are overdue and listed first
+	for i, want := range []struct {
+		id      int64
+		overdue bool
+		pending int
+		open    int
+	}{{1, true, 1, 2}, {2, true, 0, 1}, {3, false, 0, 0}} {
+		c := report[i]
+		if c.ID != want.id || c.Overdue != want.overdue || c.Pending != want.pending || c.Open != want.open || c.LastScan != nil {
+			t.Errorf("%d: unexpected coverage %+v", i, c)
+		}
+	}
+
+	// Once results are received the target is due again after its
+	// frequency
+	if err := db.UpdateJob(strconv.Itoa(job.ID), 2); err != nil {
+		t.Fatal(err)
+	}
+	report, err = app.coverage(now, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := report[1]; c.ID != 1 || c.Overdue || c.Pending != 0 || c.LastScan == nil || c.Due == nil || !c.Due.After(now) {
+		t.Errorf("expected target 1 scanned and not due, got %+v", c)
+	}
+
+	res, err = http.Get(ts.URL + "/api/v1/targets/coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromAPI []targetCoverage
+	json.NewDecoder(res.Body).Decode(&fromAPI)
+	res.Body.Close()
+	if len(fromAPI) != 3 {
+		t.Errorf("expected coverage of 3 targets, got %+v", fromAPI)
+	}
+
+	// The page adds and deletes targets and queues scans
+	for _, form := range []url.Values{
+		{"add_cidr": {"2001:db8::/64"}, "add_ports": {"22"}, "add_proto": {"tcp"}, "add_frequency": {"@weekly"}},
+		{"scan_id": {"4"}},
+		{"delete_id": {"3"}},
+	} {
+		res, err := http.PostForm(ts.URL+"/targets", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%v: expected status 200, got %d", form, res.StatusCode)
+		}
+	}
+	res, err = http.PostForm(ts.URL+"/targets", url.Values{"add_cidr": {"nowhere"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400 adding an invalid target, got %d", res.StatusCode)
+	}
+	jobs, err := db.LoadJobs(sqlite.SQLFilter{Where: []string{"target=?"}, Values: []interface{}{4}})
+	if err != nil || len(jobs) != 1 {
+		t.Errorf("expected a job queued for target 4, got %+v, %v", jobs, err)
+	}
+
+	req, _ = http.NewRequest("DELETE", ts.URL+"/api/v1/targets/2", nil)
+	for _, code := range []int{http.StatusNoContent, http.StatusNotFound} {
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("expected status %d deleting target, got %d", code, res.StatusCode)
+		}
+	}
+	if targets, _ := db.LoadTargets(); len(targets) != 2 {
+		t.Errorf("expected 2 targets left, got %+v", targets)
+	}
+}
+
+func TestScheduleTargets(t *testing.T) {
+	db := createDB("TestScheduleTargets")
+	defer db.Close()
+	app := App{db: db}
+
+	now := time.Now().UTC()
+	for _, tg := range []scan.Target{
+		{CIDR: "192.0.2.0/24", Ports: "1-65535", Proto: "tcp", Frequency: "@every 24h"},
+		{CIDR: "198.51.100.0/24", Ports: "1-65535", Proto: "tcp", Frequency: "@weekly"},
+		{CIDR: "203.0.113.0/24", Ports: "1-65535", Proto: "tcp"},
+	} {
+		tg.User, tg.Created = "user@example.com", scan.Time{Time: now}
+		if _, err := db.SaveTarget(tg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending := func(target int64) []scan.Job {
+		t.Helper()
+		jobs, err := db.LoadJobs(sqlite.SQLFilter{Where: []string{"target=?", "received IS NULL"}, Values: []interface{}{target}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jobs
+	}
+
+	// Targets never scanned are due straight away, and only get one job
+	// while it's waiting for results
+	app.scheduleTargets(now)
+	app.scheduleTargets(now)
+	for target, want := range map[int64]int{1: 1, 2: 1, 3: 0} {
+		if jobs := pending(target); len(jobs) != want {
+			t.Errorf("target %d: expected %d jobs, got %+v", target, want, jobs)
+		}
+	}
+	job := pending(1)[0]
+	if job.CIDR != "192.0.2.0/24" || job.RequestedBy != "scheduler" {
+		t.Errorf("unexpected job %+v", job)
+	}
+
+	// Once scanned, the next is due after the target's frequency
+	if err := db.UpdateJob(strconv.Itoa(job.ID), 0); err != nil {
+		t.Fatal(err)
+	}
+	app.scheduleTargets(now.Add(time.Hour))
+	if jobs := pending(1); len(jobs) != 0 {
+		t.Errorf("expected target 1 not due yet, got %+v", jobs)
+	}
+	app.scheduleTargets(now.Add(25 * time.Hour))
+	if jobs := pending(1); len(jobs) != 1 {
+		t.Errorf("expected target 1 due again, got %+v", jobs)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// operatorsOnly is a middleware which refuses requests from tenants' users
+// and API tokens restricted to CIDRs, and those without a user. Everything
+// which isn't scoped to a tenant is behind it, so nothing one tenant does is
+// visible to another.
+func operatorsOnly(next http.Handler) http.Handler {
+	return requireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestTenant(r) != "" {
+			http.Error(w, "Not available to tenants", http.StatusForbidden)
+			return
+		}
+		unrestricted(next).ServeHTTP(w, r)
+	}))
+}
+
+// requestTenant returns the tenant of the user making the request, or "" if
+// they're an operator of the deployment or nobody has authenticated.
+func requestTenant(r *http.Request) string {
+	if u := currentUser(r); u != nil {
+		return u.Tenant
+	}
+	return ""
+}
+
+// addressSpace is the address space a tenant or restricted API token may
+// see.
+type addressSpace []ipRange
+
+// newAddressSpace returns the address space of CIDRs, ignoring any which are
+// invalid, or nil if there are none.
+func newAddressSpace(cidrs []string) addressSpace {
+	if len(cidrs) == 0 {
+		return nil
+	}
+	s := make(addressSpace, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if r, ok := parseIPRange(cidr); ok {
+			s = append(s, r)
+		}
+	}
+	return s
+}
+
+// loadTenantScope retrieves the address space of a tenant. It returns
+// sql.ErrNoRows if there is no such tenant.
+func (app *App) loadTenantScope(name string) (addressSpace, error) {
+	tenants, err := app.db.LoadTenants()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tenants {
+		if t.Name != name {
+			continue
+		}
+		return newAddressSpace(t.CIDRs), nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// contains reports whether all of a CIDR, IP or range is in the address
+// space.
+func (s addressSpace) contains(target string) bool {
+	r, ok := parseIPRange(target)
+	if !ok {
+		return false
+	}
+	for _, own := range s {
+		if bytes.Compare(own.first, r.first) <= 0 && bytes.Compare(r.last, own.last) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the results in the address space, and how many weren't.
+func (s addressSpace) filter(results []scan.Result) ([]scan.Result, int) {
+	kept := results[:0]
+	for _, r := range results {
+		if s.contains(r.IP) {
+			kept = append(kept, r)
+		}
+	}
+	return kept, len(results) - len(kept)
+}
+
+// checkTenantCIDR checks a target or job's CIDR is in its tenant's address
+// space. Operators' have no tenant and can be anywhere. It returns the HTTP
+// status for any error.
+func (app *App) checkTenantCIDR(tenant, cidr string) (int, error) {
+	if tenant == "" {
+		return 0, nil
+	}
+	scope, err := app.loadTenantScope(tenant)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusBadRequest, fmt.Errorf("no tenant called %q", tenant)
+	case err != nil:
+		return http.StatusInternalServerError, err
+	}
+	if !scope.contains(cidr) {
+		return http.StatusForbidden, fmt.Errorf("%s is outside the tenant's address space", cidr)
+	}
+	return 0, nil
+}
+
+// checkTenant validates a tenant and tidies its name and CIDRs, which
+// mustn't overlap those of any of the other tenants.
+func checkTenant(t *scan.Tenant, others []scan.Tenant) error {
+	t.Name = strings.TrimSpace(t.Name)
+	if !networkName.MatchString(t.Name) {
+		return errors.New("tenant names must be letters, digits, spaces, dots, dashes and underscores")
+	}
+	if len(t.CIDRs) == 0 {
+		return errors.New("a tenant needs at least one CIDR")
+	}
+	for i, cidr := range t.CIDRs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		t.CIDRs[i] = network.String()
+		r, _ := parseIPRange(t.CIDRs[i])
+		for _, o := range others {
+			if o.Name == t.Name {
+				continue
+			}
+			for _, theirs := range o.CIDRs {
+				if other, ok := parseIPRange(theirs); ok && r.overlaps(other) {
+					return fmt.Errorf("%s overlaps %s of tenant %s", t.CIDRs[i], theirs, o.Name)
+				}
+			}
+		}
+	}
+	for i, email := range t.Users {
+		if t.Users[i] = strings.TrimSpace(email); t.Users[i] == "" {
+			return errors.New("users must have an email address")
+		}
+	}
+	return nil
+}
+
+// auditTenant records a change to a tenant in the audit log.
+func (app *App) auditTenant(user *User, event string, t scan.Tenant) {
+	info, _ := json.Marshal(t)
+	if err := app.audit(user.Email, event, string(info)); err != nil {
+		log.Printf("auditTenant: error saving %s for %s: %v", event, t.Name, err)
+	}
+}
+
+// Handler for GET /api/v1/tenants
+func (app *App) listTenants(w http.ResponseWriter, r *http.Request) {
+	tenants, err := app.db.LoadTenants()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, tenants)
+}
+
+// Handler for POST /api/v1/tenants
+// Defining an existing tenant replaces its description, CIDRs and users.
+// Users no longer listed are removed, rather than becoming operators.
+func (app *App) newTenant(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	var t scan.Tenant
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tenants, err := app.db.LoadTenants()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := checkTenant(&t, tenants); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t.Created = scan.Time{Time: time.Now().UTC()}
+	if err := app.db.SaveTenant(t); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	listed := make(map[string]bool)
+	for _, email := range t.Users {
+		listed[email] = true
+		if err := app.db.SetUserTenant(email, t.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, old := range tenants {
+		if old.Name != t.Name {
+			continue
+		}
+		t.Created = old.Created
+		for _, email := range old.Users {
+			if listed[email] {
+				continue
+			}
+			if err := app.db.DeleteUser(email); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	if t.Users == nil {
+		t.Users = []string{}
+	}
+	app.auditTenant(user, "add_tenant", t)
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, t)
+}
+
+// Handler for DELETE /api/v1/tenants/{name}
+// This also removes the tenant's users.
+func (app *App) deleteTenant(w http.ResponseWriter, r *http.Request) {
+	user := editor(w, r)
+	if user == nil {
+		return
+	}
+	t := scan.Tenant{Name: chi.URLParam(r, "name")}
+	err := app.db.DeleteTenant(t.Name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.auditTenant(user, "delete_tenant", t)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestTenants(t *testing.T) {
+	db := createDB("TestTenants")
+	defer db.Close()
+	app := App{db: db, jobTimeout: time.Hour}
+
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+		{IP: "198.51.100.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	as := func(email string) {
+		app.authProviders = []authProvider{staticAuth{user: &User{Email: email}}}
+	}
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var b bytes.Buffer
+		b.ReadFrom(res.Body)
+		return res.StatusCode, b.String()
+	}
+
+	as("operator@example.com")
+	for _, tt := range []struct {
+		tenant string
+		code   int
+	}{
+		{`{"name": "Web team", "cidrs": ["192.0.2.0/24"], "users": ["web@example.com"]}`, http.StatusCreated},
+		{`{"name": "Mail team", "cidrs": ["198.51.100.7/24"], "users": ["mail@example.com"]}`, http.StatusCreated},
+		{`{"name": "Overlapping", "cidrs": ["192.0.2.128/25"]}`, http.StatusBadRequest},
+		{`{"name": "Empty", "cidrs": []}`, http.StatusBadRequest},
+	} {
+		if code, body := do("POST", "/api/v1/tenants", tt.tenant); code != tt.code {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.tenant, tt.code, code, body)
+		}
+	}
+	tenants, err := db.LoadTenants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants) != 2 || tenants[0].Name != "Mail team" || tenants[0].CIDRs[0] != "198.51.100.0/24" || tenants[1].Users[0] != "web@example.com" {
+		t.Errorf("unexpected tenants %+v", tenants)
+	}
+
+	// Tenants only see results in their address space, and nothing which
+	// spans tenants
+	as("web@example.com")
+	code, body := do("GET", "/api/v1/results", "")
+	var got []scan.IPInfo
+	json.Unmarshal([]byte(body), &got)
+	if code != http.StatusOK || len(got) != 1 || got[0].IP != "192.0.2.1" {
+		t.Errorf("expected only the web team's result, got %d %s", code, body)
+	}
+	if code, body := do("GET", "/ips.json", ""); code != http.StatusOK || strings.TrimSpace(body) != `["192.0.2.1"]` {
+		t.Errorf("expected only the web team's IP, got %d %s", code, body)
+	}
+	for path, want := range map[string]int{
+		"/host/192.0.2.1":     http.StatusOK,
+		"/host/198.51.100.1":  http.StatusNotFound,
+		"/api/v1/networks":    http.StatusForbidden,
+		"/api/v1/tenants":     http.StatusForbidden,
+		"/api/v1/diff":        http.StatusForbidden,
+		"/admin":              http.StatusForbidden,
+		"/api/v1/targets":     http.StatusOK,
+		"/traceroute/1.2.3.4": http.StatusForbidden,
+	} {
+		if code, _ := do("GET", path, ""); code != want {
+			t.Errorf("%s: expected status %d, got %d", path, want, code)
+		}
+	}
+
+	// Targets are kept to the tenant's address space and hidden from other
+	// tenants
+	if code, _ := do("POST", "/api/v1/targets", `{"cidr": "198.51.100.0/24"}`); code != http.StatusForbidden {
+		t.Errorf("expected status 403 adding a target outside the tenant, got %d", code)
+	}
+	code, body = do("POST", "/api/v1/targets", `{"cidr": "192.0.2.0/25", "tenant": "Mail team"}`)
+	var target scan.Target
+	json.Unmarshal([]byte(body), &target)
+	if code != http.StatusCreated || target.Tenant != "Web team" {
+		t.Errorf("expected a target for the web team, got %d %s", code, body)
+	}
+	if code, _ := do("POST", "/api/v1/targets/1/scan", ""); code != http.StatusCreated {
+		t.Errorf("expected status 201 scanning the tenant's target, got %d", code)
+	}
+
+	as("mail@example.com")
+	if code, body := do("GET", "/api/v1/targets", ""); code != http.StatusOK || strings.TrimSpace(body) != "[]" {
+		t.Errorf("expected no targets for the mail team, got %d %s", code, body)
+	}
+	for _, req := range [][2]string{{"PUT", "/api/v1/targets/1"}, {"DELETE", "/api/v1/targets/1"}, {"POST", "/api/v1/targets/1/scan"}} {
+		if code, _ := do(req[0], req[1], `{"cidr": "198.51.100.0/24"}`); code != http.StatusNotFound {
+			t.Errorf("%s %s: expected status 404 for another tenant's target, got %d", req[0], req[1], code)
+		}
+	}
+
+	// Agents of a tenant only claim its jobs
+	req, _ := http.NewRequest("POST", ts.URL+"/jobs/claim", nil)
+	req.Header.Set("X-Agent", "mail-scanner")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected no jobs for the mail team, got %d", res.StatusCode)
+	}
+
+	// Results submitted by a tenant outside its address space are dropped,
+	// and it can't claim to have scanned another tenant's
+	submit := func(scope string) int {
+		t.Helper()
+		submission := `[{"ip":"198.51.100.2","ports":[{"port":25,"proto":"tcp","status":"open"}]},{"ip":"192.0.2.2","ports":[{"port":25,"proto":"tcp","status":"open"}]}]`
+		req, _ := http.NewRequest("POST", ts.URL+"/results", strings.NewReader(submission))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Scan-Scope", scope)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := submit("198.51.100.0/24,192.0.2.0/24"); code != http.StatusForbidden {
+		t.Errorf("expected status 403 submitting a scope outside the tenant, got %d", code)
+	}
+	if code := submit("198.51.100.0/24"); code != http.StatusOK {
+		t.Errorf("expected status 200 submitting results, got %d", code)
+	}
+	as("operator@example.com")
+	_, body = do("GET", "/api/v1/results", "")
+	got = nil
+	json.Unmarshal([]byte(body), &got)
+	if len(got) != 3 {
+		t.Errorf("expected operators to see every result but the dropped one, got %s", body)
+	}
+	// It only scanned the tenant's address space, so doesn't close ports
+	// outside it
+	for _, r := range got {
+		if r.IP == "192.0.2.1" && r.Gone {
+			t.Errorf("expected the web team's port left open by the mail team's scan, got %+v", r)
+		}
+	}
+	sub, err := db.LoadSubmission(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sub.Scope, []string{"198.51.100.0/24"}) {
+		t.Errorf("expected the submission scoped to the mail team, got %+v", sub)
+	}
+
+	// Users no longer listed are removed
+	if code, _ := do("POST", "/api/v1/tenants", `{"name": "Mail team", "cidrs": ["198.51.100.0/24"], "users": []}`); code != http.StatusCreated {
+		t.Errorf("expected status 201 updating tenant, got %d", code)
+	}
+	if ok, _ := db.UserExists("mail@example.com"); ok {
+		t.Error("expected the removed user deleted")
+	}
+	for _, code := range []int{http.StatusNoContent, http.StatusNotFound} {
+		if got, _ := do("DELETE", "/api/v1/tenants/Web%20team", ""); got != code {
+			t.Errorf("expected status %d deleting tenant, got %d", code, got)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/render"
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// portFilter narrows results to the ip, port and proto query parameters.
+func portFilter(q url.Values) (sqlite.SQLFilter, error) {
+	var filter sqlite.SQLFilter
+	if ip := q.Get("ip"); ip != "" {
+		filter.Where = append(filter.Where, `ip=?`)
+		filter.Values = append(filter.Values, scan.NormalizeIP(ip))
+	}
+	if port := q.Get("port"); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return filter, errors.New("Invalid port")
+		}
+		filter.Where = append(filter.Where, `port=?`)
+		filter.Values = append(filter.Values, p)
+	}
+	if proto := q.Get("proto"); proto != "" {
+		filter.Where = append(filter.Where, `proto=?`)
+		filter.Values = append(filter.Values, proto)
+	}
+	return filter, nil
+}
+
+// Handler for GET /api/v1/uptime
+// Results can be narrowed with the ip, port and proto query parameters.
+func (app *App) uptime(w http.ResponseWriter, r *http.Request) {
+	filter, err := portFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	windows, err := app.db.LoadUptime(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, windows)
+}
+
+// Handler for GET /api/v1/history
+// This shows when each port was seen open, and when it was closed in between.
+// Ports can be chosen with the ip, port and proto query parameters, and
+// narrowed to what one scanner saw with source.
+func (app *App) history(w http.ResponseWriter, r *http.Request) {
+	filter, err := portFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if source := r.URL.Query().Get("source"); source != "" {
+		filter.Where = append(filter.Where, `source=?`)
+		filter.Values = append(filter.Values, source)
+	}
+
+	timelines, err := app.db.LoadTimelines(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if timelines == nil {
+		timelines = []scan.Timeline{}
+	}
+
+	render.JSON(w, r, timelines)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestLoadUptime(t *testing.T) {
+	db := createDB("TestLoadUptime")
+	defer db.Close()
+
+	first := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	third := second.Add(time.Hour)
+
+	// 192.0.2.1 is seen in every scan, 192.0.2.2 only in the first
+	both := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+	}
+	for i, now := range []time.Time{first, second, third} {
+		results := both
+		if i > 0 {
+			results = both[:1]
+		}
+		if _, _, err := db.SaveData(results, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveSubmission("192.0.2.100", nil, now, scan.Run{Scope: scan.ScopeAll}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	windows, err := db.LoadUptime(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 2 {
+		t.Fatalf("expected 2 windows, got %d", len(windows))
+	}
+
+	up, gone := windows[0], windows[1]
+	if up.ClosedBy != nil {
+		t.Errorf("expected %s to still be open, closed by %v", up.IP, up.ClosedBy)
+	}
+	if up.Availability != 1 {
+		t.Errorf("expected %s availability 1, got %v", up.IP, up.Availability)
+	}
+	if gone.ClosedBy == nil || !gone.ClosedBy.Equal(second) {
+		t.Errorf("expected %s to be closed by %v, got %v", gone.IP, second, gone.ClosedBy)
+	}
+	if want := 1.0 / 3; gone.Availability != want {
+		t.Errorf("expected %s availability %v, got %v", gone.IP, want, gone.Availability)
+	}
+}
+
+func TestUptimeHandler(t *testing.T) {
+	db := createDB("TestUptimeHandler")
+	defer db.Close()
+	app := App{db: db}
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/api/v1/uptime?ip=192.0.2.2", nil)
+	w := httptest.NewRecorder()
+	app.uptime(w, r)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %v", resp.StatusCode)
+	}
+	var windows []scan.Window
+	if err := json.NewDecoder(resp.Body).Decode(&windows); err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 1 || windows[0].Port != 443 {
+		t.Errorf("expected only 192.0.2.2 port 443, got %+v", windows)
+	}
+
+	r = httptest.NewRequest("GET", "/api/v1/uptime?port=http", nil)
+	w = httptest.NewRecorder()
+	app.uptime(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %v", w.Code)
+	}
+}
+
+func TestLoadTimelines(t *testing.T) {
+	db := createDB("TestLoadTimelines")
+	defer db.Close()
+	app := App{db: db}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	scans := []time.Time{start, start.Add(time.Hour), start.Add(2 * time.Hour), start.Add(3 * time.Hour)}
+
+	// 8080 appears in the first two scans, is closed in the third and comes
+	// back in the fourth
+	for i, now := range scans {
+		results := []scan.Result{{IP: "192.0.2.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}}}
+		if i != 2 {
+			results = append(results, scan.Result{IP: "192.0.2.1", Ports: []scan.Port{{Port: 8080, Proto: "tcp", Status: "open"}}})
+		}
+		if _, _, err := db.SaveData(results, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveSubmission("192.0.2.100", nil, now, scan.Run{Scope: scan.ScopeAll}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/api/v1/history?ip=192.0.2.1&port=8080", nil)
+	w := httptest.NewRecorder()
+	app.history(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	var timelines []scan.Timeline
+	if err := json.NewDecoder(w.Body).Decode(&timelines); err != nil {
+		t.Fatal(err)
+	}
+	if len(timelines) != 1 {
+		t.Fatalf("expected 1 timeline, got %d", len(timelines))
+	}
+	periods := timelines[0].Periods
+	if len(periods) != 2 {
+		t.Fatalf("expected 2 periods, got %+v", periods)
+	}
+	if !periods[0].From.Equal(scans[0]) || !periods[0].To.Equal(scans[1]) || periods[0].Scans != 2 {
+		t.Errorf("expected first period from %v to %v in 2 scans, got %+v", scans[0], scans[1], periods[0])
+	}
+	if periods[0].ClosedBy == nil || !periods[0].ClosedBy.Equal(scans[2]) {
+		t.Errorf("expected first period closed by %v, got %v", scans[2], periods[0].ClosedBy)
+	}
+	if !periods[1].From.Equal(scans[3]) || periods[1].ClosedBy != nil {
+		t.Errorf("expected second period from %v and still open, got %+v", scans[3], periods[1])
+	}
+
+	timelines, err := db.LoadTimelines(sqlite.SQLFilter{Where: []string{`port=?`}, Values: []interface{}{22}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timelines) != 1 || len(timelines[0].Periods) != 1 || timelines[0].Periods[0].Scans != 4 {
+		t.Errorf("expected port 22 open for all 4 scans, got %+v", timelines)
+	}
+
+	r = httptest.NewRequest("GET", "/api/v1/history?port=ssh", nil)
+	w = httptest.NewRecorder()
+	app.history(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid port, got %d", w.Code)
+	}
+}
+
+func TestObservationSources(t *testing.T) {
+	db := createDB("TestObservationSources")
+	defer db.Close()
+	app := App{db: db, maxResultAge: 24 * time.Hour}
+
+	// Two agents and a scanner with neither see the port at the same time.
+	// An agent which hasn't registered from the scanner's address is
+	// recorded as the address.
+	seen := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	for _, agent := range []scan.Agent{{Name: "external", Addr: "192.0.2.1"}, {Name: "internal", Addr: "192.0.2.1"}, {Name: "elsewhere", Addr: "198.51.100.1"}} {
+		if err := db.SaveAgentHeartbeat(agent, seen); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, agent := range []string{"external", "internal", "", "external", "elsewhere", "unregistered"} {
+		r := httptest.NewRequest("POST", "/results", strings.NewReader(fmt.Sprintf(
+			`[{"ip": "192.0.2.1", "timestamp": "%d", "source": "spoofed", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}]`, seen.Unix())))
+		r.Header.Set("Content-Type", "application/json")
+		if agent != "" {
+			r.Header.Set("X-Agent", agent)
+		}
+		w := httptest.NewRecorder()
+		app.recvResults(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+	}
+
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM observation`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("expected an observation from each source, got %d", n)
+	}
+
+	timelines, err := db.LoadTimelines(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timelines) != 1 || len(timelines[0].Periods) != 1 {
+		t.Fatalf("expected one period, got %+v", timelines)
+	}
+	p := timelines[0].Periods[0]
+	if want := []string{"192.0.2.1", "external", "internal"}; !reflect.DeepEqual(p.Sources, want) || p.Scans != 1 {
+		t.Errorf("expected one scan seen by %v, got %+v", want, p)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhook POSTs each event as JSON to a URL, along with a human-readable
+// message in the webhook's language. If a secret is set, the body is signed
+// with HMAC-SHA256 and the hex digest sent in the X-Scan-Signature header so
+// the receiver can verify it.
+type webhook struct {
+	url    string
+	secret string
+	lang   string
+	client *http.Client
+}
+
+func newWebhook(url, secret, lang string) *webhook {
+	return &webhook{url: url, secret: secret, lang: lang, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (wh *webhook) name() string { return "webhook" }
+
+func (wh *webhook) notify(e event) error {
+	msg, err := message(wh.lang, e)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(struct {
+		event
+		Message string `json:"message"`
+	}{e, msg})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", wh.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.secret != "" {
+		mac := hmac.New(sha256.New, []byte(wh.secret))
+		mac.Write(body)
+		req.Header.Set("X-Scan-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	res, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %s", wh.url, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestWebhook(t *testing.T) {
+	db := createDB("TestWebhook")
+	defer db.Close()
+
+	events := make(chan event, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		if r.Header.Get("X-Scan-Signature") != hex.EncodeToString(mac.Sum(nil)) {
+			t.Error("invalid webhook signature")
+		}
+		var e event
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Error(err)
+		}
+		events <- e
+	}))
+	defer ts.Close()
+
+	app := App{db: db, notifiers: []notifier{newWebhook(ts.URL, "secret", "en")}}
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 80, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+	}
+	first := time.Now().UTC().Truncate(time.Second)
+	_, added, err := db.SaveData(results, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.notifyChanges(first, added, nil)
+
+	for i := 0; i < len(results); i++ {
+		select {
+		case e := <-events:
+			if e.Type != eventNewPort || e.IP != results[i].IP || e.Port != results[i].Ports[0].Port {
+				t.Errorf("unexpected event %+v", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for webhook")
+		}
+	}
+
+	// Seeing the same ports again shouldn't send any events
+	second := first.Add(time.Minute)
+	_, added, err = db.SaveData(results, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.notifyChanges(second, added, nil)
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event for existing port: %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
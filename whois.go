@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// whoisBatch is the most IPs looked up in WHOIS each run.
+const whoisBatch = 100
+
+// errRDAPNotFound is returned when the registry has no netblock for an IP.
+var errRDAPNotFound = errors.New("rdap: not found")
+
+// whoisClient looks up the AS announcing each IP with Team Cymru's bulk
+// WHOIS service, and the netblock it's allocated from with RDAP.
+type whoisClient struct {
+	// server is the host:port of the bulk WHOIS server
+	server string
+	// rdap is the URL the IP is appended to for RDAP queries
+	rdap    string
+	refresh time.Duration
+	client  *http.Client
+}
+
+func newWhoisClient(server, rdap string, refresh time.Duration) *whoisClient {
+	return &whoisClient{
+		server:  server,
+		rdap:    rdap,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// origins looks up the AS announcing each IP. IPs which aren't announced
+// have no ASN.
+func (c *whoisClient) origins(ips []string) (map[string]scan.Whois, error) {
+	conn, err := net.DialTimeout("tcp", c.server, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	var req bytes.Buffer
+	req.WriteString("begin\nverbose\n")
+	for _, ip := range ips {
+		req.WriteString(ip + "\n")
+	}
+	req.WriteString("end\n")
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	// Each line is AS | IP | BGP Prefix | CC | Registry | Allocated | AS Name,
+	// with NA for anything unknown, after a "Bulk mode" header.
+	origins := make(map[string]scan.Whois, len(ips))
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		cols := strings.Split(s.Text(), "|")
+		if len(cols) < 7 {
+			continue
+		}
+		for i := range cols {
+			if cols[i] = strings.TrimSpace(cols[i]); cols[i] == "NA" {
+				cols[i] = ""
+			}
+		}
+		if net.ParseIP(cols[1]) == nil {
+			continue
+		}
+		ip := scan.NormalizeIP(cols[1])
+		w := scan.Whois{IP: ip, Prefix: cols[2], ASName: cols[6]}
+		if asn, err := strconv.ParseUint(cols[0], 10, 32); err == nil {
+			w.ASN = uint(asn)
+		}
+		origins[ip] = w
+	}
+	return origins, s.Err()
+}
+
+// rdapNetwork is the part of an RDAP IP network response which is used.
+type rdapNetwork struct {
+	Name         string       `json:"name"`
+	StartAddress string       `json:"startAddress"`
+	EndAddress   string       `json:"endAddress"`
+	Entities     []rdapEntity `json:"entities"`
+}
+
+// rdapEntity is a contact for a network. vCard is a jCard: ["vcard",
+// [[name, params, type, value], ...]].
+type rdapEntity struct {
+	Roles    []string          `json:"roles"`
+	VCard    []json.RawMessage `json:"vcardArray"`
+	Entities []rdapEntity      `json:"entities"`
+}
+
+// name returns the entity's formatted name from its vCard.
+func (e rdapEntity) name() string {
+	if len(e.VCard) < 2 {
+		return ""
+	}
+	var props [][]interface{}
+	if err := json.Unmarshal(e.VCard[1], &props); err != nil {
+		return ""
+	}
+	for _, p := range props {
+		if len(p) == 4 && p[0] == "fn" {
+			name, _ := p[3].(string)
+			return name
+		}
+	}
+	return ""
+}
+
+// owner returns the name of the network's registrant, or failing that of
+// any contact.
+func (n rdapNetwork) owner() string {
+	var fallback string
+	for _, e := range n.Entities {
+		name := e.name()
+		if name == "" {
+			continue
+		}
+		for _, role := range e.Roles {
+			if role == "registrant" {
+				return name
+			}
+		}
+		if fallback == "" {
+			fallback = name
+		}
+	}
+	return fallback
+}
+
+// netblock looks up the netblock ip is allocated from.
+func (c *whoisClient) netblock(ip string) (rdapNetwork, error) {
+	var n rdapNetwork
+	req, err := http.NewRequest("GET", c.rdap+url.PathEscape(ip), nil)
+	if err != nil {
+		return n, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	res, err := c.client.Do(req)
+	if err != nil {
+		return n, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return n, errRDAPNotFound
+	default:
+		return n, fmt.Errorf("rdap: unexpected status %s", res.Status)
+	}
+	err = json.NewDecoder(res.Body).Decode(&n)
+	return n, err
+}
+
+// netblockContains reports whether ip is in the netblock from first to last.
+func netblockContains(first, last, ip string) bool {
+	a, b, addr := net.ParseIP(first), net.ParseIP(last), net.ParseIP(ip)
+	if a == nil || b == nil || addr == nil {
+		return false
+	}
+	if (a.To4() == nil) != (addr.To4() == nil) {
+		return false
+	}
+	return bytes.Compare(addr.To16(), a.To16()) >= 0 && bytes.Compare(addr.To16(), b.To16()) <= 0
+}
+
+// lookup looks up the AS and netblock of each IP. IPs whose netblock lookup
+// fails are left out, so they're tried again later. Netblocks are only
+// looked up once per call, as IPs are often in the same one.
+func (c *whoisClient) lookup(ips []string, now time.Time) ([]scan.Whois, error) {
+	origins, err := c.origins(ips)
+	if err != nil {
+		return nil, err
+	}
+
+	var seen []rdapNetwork
+	whois := make([]scan.Whois, 0, len(ips))
+ips:
+	for _, ip := range ips {
+		w := origins[ip]
+		w.IP = ip
+		w.Updated = scan.Time{Time: now.UTC()}
+		for _, n := range seen {
+			if netblockContains(n.StartAddress, n.EndAddress, ip) {
+				w.Netblock, w.First, w.Last, w.Owner = n.Name, n.StartAddress, n.EndAddress, n.owner()
+				whois = append(whois, w)
+				continue ips
+			}
+		}
+		n, err := c.netblock(ip)
+		switch {
+		case err == errRDAPNotFound:
+		case err != nil:
+			if verbose {
+				log.Printf("whois: error looking up netblock of %s: %v", ip, err)
+			}
+			continue
+		default:
+			seen = append(seen, n)
+			w.Netblock, w.First, w.Last, w.Owner = n.Name, n.StartAddress, n.EndAddress, n.owner()
+		}
+		whois = append(whois, w)
+	}
+	return whois, nil
+}
+
+// enrichWhois looks up the AS and netblock of IPs never looked up, or last
+// looked up longer ago than the refresh interval.
+func (app *App) enrichWhois(now time.Time) {
+	if !app.leading() {
+		return
+	}
+	ips, err := app.db.LoadStaleWhoisIPs(now.Add(-app.whois.refresh), whoisBatch)
+	if err != nil {
+		log.Println("whois: error loading IPs to look up:", err)
+		return
+	}
+	if len(ips) == 0 {
+		return
+	}
+	whois, err := app.whois.lookup(ips, now)
+	if err != nil {
+		log.Println("whois: error looking up IPs:", err)
+		return
+	}
+	if err := app.db.SaveWhois(whois); err != nil {
+		log.Println("whois: error saving lookups:", err)
+		return
+	}
+	if verbose {
+		log.Printf("whois: looked up %d IPs", len(whois))
+	}
+}
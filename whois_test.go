@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/internal/sqlite"
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// cymruServer answers bulk WHOIS queries like whois.cymru.com, with
+// 192.0.2.0/24 announced by AS64496.
+func cymruServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s := bufio.NewScanner(conn)
+			conn.Write([]byte("Bulk mode; whois.cymru.com [2020-06-01 12:00:00 +0000]\n"))
+			for s.Scan() {
+				line := s.Text()
+				switch {
+				case line == "end":
+					conn.Close()
+				case strings.HasPrefix(line, "192.0.2."):
+					conn.Write([]byte("64496   | " + line + "        | 192.0.2.0/24        | NL | ripencc  | 2010-01-01 | EXAMPLE-AS Example Hosting, NL\n"))
+				case net.ParseIP(line) != nil:
+					conn.Write([]byte("NA      | " + line + "        | NA                  |    | other    |            | NA\n"))
+				}
+			}
+		}
+	}()
+	return ln
+}
+
+func TestWhois(t *testing.T) {
+	db := createDB("TestWhois")
+	defer db.Close()
+
+	ln := cymruServer(t)
+	defer ln.Close()
+
+	queries := 0
+	rdap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		if !strings.HasPrefix(r.URL.Path, "/ip/192.0.2.") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(`{"name": "EXAMPLE-NET", "startAddress": "192.0.2.0", "endAddress": "192.0.2.255",
+			"entities": [
+				{"roles": ["abuse"], "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Abuse Team"]]]},
+				{"roles": ["registrant"], "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Example Cloud Ltd"]]]}
+			]}`))
+	}))
+	defer rdap.Close()
+
+	app := App{db: db, whois: newWhoisClient(ln.Addr().String(), rdap.URL+"/ip/", 7*24*time.Hour)}
+
+	results := []scan.Result{
+		{IP: "192.0.2.1", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "192.0.2.2", Ports: []scan.Port{{Port: 443, Proto: "tcp", Status: "open"}}},
+		{IP: "203.0.113.1", Ports: []scan.Port{{Port: 22, Proto: "tcp", Status: "open"}}},
+	}
+	if _, _, err := db.SaveData(results, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	app.enrichWhois(now)
+	whois, err := db.LoadWhois(sqlite.SQLFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(whois) != 3 {
+		t.Fatalf("expected 3 lookups, got %+v", whois)
+	}
+	want := scan.Whois{IP: "192.0.2.1", ASN: 64496, ASName: "EXAMPLE-AS Example Hosting, NL", Prefix: "192.0.2.0/24",
+		Netblock: "EXAMPLE-NET", First: "192.0.2.0", Last: "192.0.2.255", Owner: "Example Cloud Ltd"}
+	w := whois[0]
+	w.Updated = scan.Time{}
+	if w != want {
+		t.Errorf("unexpected lookup for 192.0.2.1:\n got %+v\nwant %+v", w, want)
+	}
+	if w := whois[2]; w.IP != "203.0.113.1" || w.ASN != 0 || w.Owner != "" {
+		t.Errorf("expected nothing found for 203.0.113.1, got %+v", w)
+	}
+	// 192.0.2.2 is in the netblock already looked up
+	if queries != 2 {
+		t.Errorf("expected 2 RDAP queries, got %d", queries)
+	}
+
+	// Nothing is looked up again until the lookups are due a refresh
+	queries = 0
+	app.enrichWhois(now.Add(time.Hour))
+	if queries != 0 {
+		t.Errorf("expected no lookups before the refresh, got %d", queries)
+	}
+	app.enrichWhois(now.Add(8 * 24 * time.Hour))
+	if queries != 2 {
+		t.Errorf("expected lookups refreshed, got %d RDAP queries", queries)
+	}
+
+	for _, tt := range []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"asn": {"64496"}}, 2},
+		{url.Values{"q": {"asn:AS64496"}}, 2},
+		{url.Values{"owner": {"example cloud"}}, 2},
+		{url.Values{"q": {"owner:EXAMPLE-NET"}}, 2},
+		{url.Values{"asn": {"64497"}}, 0},
+	} {
+		query, err := resultQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query.View = sqlite.ViewAll
+		data, _, err := db.ResultPage(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results) != tt.want {
+			t.Errorf("%v: expected %d results, got %d", tt.query, tt.want, len(data.Results))
+			continue
+		}
+		if tt.want > 0 && (data.Results[0].Whois == nil || data.Results[0].Whois.ASN != 64496) {
+			t.Errorf("%v: expected results with WHOIS data, got %+v", tt.query, data.Results[0])
+		}
+	}
+
+	if _, err := resultQuery(url.Values{"asn": {"example"}}); err == nil {
+		t.Error("expected an error for an invalid ASN")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+// A window is a period of time, recurring or not.
+type window interface {
+	contains(t time.Time) bool
+}
+
+// dailyWindow recurs every day, or on some days of the week, from start to
+// end minutes past midnight. A window ending before it starts runs past
+// midnight into the next day.
+type dailyWindow struct {
+	// days is a bit set of the weekdays the window starts on, or 0 for
+	// every day
+	days       uint8
+	start, end int
+}
+
+func (w dailyWindow) startsOn(d time.Weekday) bool {
+	return w.days == 0 || w.days&(1<<uint(d)) != 0
+}
+
+func (w dailyWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return w.startsOn(t.Weekday()) && m >= w.start && m < w.end
+	}
+	yesterday := (t.Weekday() + 6) % 7
+	return (w.startsOn(t.Weekday()) && m >= w.start) || (w.startsOn(yesterday) && m < w.end)
+}
+
+// dateWindow is the whole of the days from first to last.
+type dateWindow struct {
+	first, last time.Time
+}
+
+func (w dateWindow) contains(t time.Time) bool {
+	return !t.Before(w.first) && t.Before(w.last.AddDate(0, 0, 1))
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseDays parses days of the week, such as "Mon-Fri" or "Sat,Sun", into a
+// bit set.
+func parseDays(s string) (uint8, error) {
+	var days uint8
+	for _, part := range strings.Split(s, ",") {
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+		first, ok := weekdays[strings.ToLower(lo)]
+		if !ok {
+			return 0, fmt.Errorf("invalid day %q", lo)
+		}
+		last, ok := weekdays[strings.ToLower(hi)]
+		if !ok {
+			return 0, fmt.Errorf("invalid day %q", hi)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days |= 1 << uint(d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses a time of day as minutes past midnight. 24:00 is the
+// end of the day.
+func parseClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseWindow parses a window of times of day, "01:00-05:00", optionally
+// only starting on some days, "Mon-Fri 22:00-02:00", or a range of dates,
+// "2026-12-24/2026-12-26". Times are in loc.
+func parseWindow(spec string, loc *time.Location) (window, error) {
+	spec = strings.TrimSpace(spec)
+	if i := strings.Index(spec, "/"); i >= 0 {
+		first, err := time.ParseInLocation("2006-01-02", spec[:i], loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", spec[:i])
+		}
+		last, err := time.ParseInLocation("2006-01-02", spec[i+1:], loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", spec[i+1:])
+		}
+		if last.Before(first) {
+			return nil, fmt.Errorf("%s ends before it starts", spec)
+		}
+		return dateWindow{first: first, last: last}, nil
+	}
+
+	var w dailyWindow
+	f := strings.Fields(spec)
+	switch len(f) {
+	case 1:
+	case 2:
+		days, err := parseDays(f[0])
+		if err != nil {
+			return nil, err
+		}
+		w.days = days
+		f = f[1:]
+	default:
+		return nil, fmt.Errorf("invalid window %q", spec)
+	}
+	i := strings.Index(f[0], "-")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid window %q", spec)
+	}
+	var err error
+	if w.start, err = parseClock(f[0][:i]); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseClock(f[0][i+1:]); err != nil {
+		return nil, err
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("%s is empty", spec)
+	}
+	return w, nil
+}
+
+// checkWindows validates a network's windows and blackouts.
+func checkWindows(n scan.Network) error {
+	for _, spec := range append(n.Windows, n.Blackouts...) {
+		if _, err := parseWindow(spec, time.Local); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ipRange is the addresses from first to last, both 16 bytes long.
+type ipRange struct {
+	first, last net.IP
+}
+
+// parseIPRange parses a CIDR, an IP or a first-last range, as masscan
+// accepts.
+func parseIPRange(s string) (ipRange, bool) {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		last := make(net.IP, len(network.IP))
+		for i := range network.IP {
+			last[i] = network.IP[i] | ^network.Mask[i]
+		}
+		return ipRange{network.IP.To16(), last.To16()}, true
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		first, last := net.ParseIP(s[:i]), net.ParseIP(s[i+1:])
+		if first == nil || last == nil {
+			return ipRange{}, false
+		}
+		return ipRange{first.To16(), last.To16()}, true
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ipRange{}, false
+	}
+	return ipRange{ip.To16(), ip.To16()}, true
+}
+
+func (r ipRange) overlaps(o ipRange) bool {
+	return bytes.Compare(r.first, o.last) <= 0 && bytes.Compare(o.first, r.last) <= 0
+}
+
+// scanWindow is when a named network may be scanned.
+type scanWindow struct {
+	name      string
+	ranges    []ipRange
+	windows   []window
+	blackouts []window
+}
+
+// open reports whether the network may be scanned at t.
+func (w scanWindow) open(t time.Time) bool {
+	t = t.In(time.Local)
+	for _, b := range w.blackouts {
+		if b.contains(t) {
+			return false
+		}
+	}
+	if len(w.windows) == 0 {
+		return true
+	}
+	for _, win := range w.windows {
+		if win.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanWindows is the windows of every named network which has any.
+type scanWindows []scanWindow
+
+// loadScanWindows retrieves the windows of the named networks.
+func (app *App) loadScanWindows() (scanWindows, error) {
+	networks, err := app.db.LoadNetworks()
+	if err != nil {
+		return nil, err
+	}
+	var windows scanWindows
+	for _, n := range networks {
+		if len(n.Windows) == 0 && len(n.Blackouts) == 0 {
+			continue
+		}
+		w := scanWindow{name: n.Name}
+		for _, cidr := range n.CIDRs {
+			if r, ok := parseIPRange(cidr); ok {
+				w.ranges = append(w.ranges, r)
+			}
+		}
+		for _, spec := range n.Windows {
+			if win, err := parseWindow(spec, time.Local); err == nil {
+				w.windows = append(w.windows, win)
+			}
+		}
+		for _, spec := range n.Blackouts {
+			if win, err := parseWindow(spec, time.Local); err == nil {
+				w.blackouts = append(w.blackouts, win)
+			}
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// closed returns the name of a network overlapping any of the CIDRs, IPs or
+// ranges which may not be scanned at t, or "" if they may all be scanned.
+func (ws scanWindows) closed(t time.Time, targets ...string) string {
+	for _, w := range ws {
+		if w.open(t) {
+			continue
+		}
+		for _, target := range targets {
+			r, ok := parseIPRange(target)
+			if !ok {
+				continue
+			}
+			for _, network := range w.ranges {
+				if network.overlaps(r) {
+					return w.name
+				}
+			}
+		}
+	}
+	return ""
+}
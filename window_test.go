@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jamesog/scan/pkg/scan"
+)
+
+func TestParseWindow(t *testing.T) {
+	// Monday 12 October 2026
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 10, day, hour, min, 0, 0, time.Local)
+	}
+	for _, tt := range []struct {
+		spec string
+		in   []time.Time
+		out  []time.Time
+	}{
+		{"01:00-05:00", []time.Time{at(12, 1, 0), at(17, 4, 59)}, []time.Time{at(12, 0, 59), at(12, 5, 0)}},
+		// Overnight windows belong to the day they start
+		{"Mon-Fri 22:00-02:00", []time.Time{at(12, 23, 0), at(13, 1, 0), at(17, 1, 59)}, []time.Time{at(12, 1, 0), at(17, 23, 0), at(18, 1, 0)}},
+		{"Sat,Sun 00:00-24:00", []time.Time{at(17, 0, 0), at(18, 23, 59)}, []time.Time{at(16, 23, 59), at(19, 0, 0)}},
+		{"Fri-Mon 09:00-17:00", []time.Time{at(16, 9, 0), at(19, 16, 59)}, []time.Time{at(13, 12, 0), at(15, 12, 0)}},
+		{"2026-10-12/2026-10-13", []time.Time{at(12, 0, 0), at(13, 23, 59)}, []time.Time{at(11, 23, 59), at(14, 0, 0)}},
+	} {
+		w, err := parseWindow(tt.spec, time.Local)
+		if err != nil {
+			t.Errorf("%s: %v", tt.spec, err)
+			continue
+		}
+		for _, in := range tt.in {
+			if !w.contains(in) {
+				t.Errorf("%s: expected %v in the window", tt.spec, in)
+			}
+		}
+		for _, out := range tt.out {
+			if w.contains(out) {
+				t.Errorf("%s: expected %v outside the window", tt.spec, out)
+			}
+		}
+	}
+
+	for _, spec := range []string{"", "01:00", "25:00-05:00", "01:00-01:00", "Someday 01:00-05:00", "Mon 01:00-05:00 UTC", "2026-10-13/2026-10-12", "2026-13-01/2026-13-02"} {
+		if _, err := parseWindow(spec, time.Local); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestScanWindows(t *testing.T) {
+	db := createDB("TestScanWindows")
+	defer db.Close()
+	app := App{db: db, jobTimeout: time.Hour, masscan: &masscanRunner{ranges: []string{"198.51.100.1-198.51.100.10"}}}
+	ts := httptest.NewServer(app.setupRouter())
+	defer ts.Close()
+
+	authDisabled = false
+	defer func() { authDisabled = true }()
+	app.authProviders = []authProvider{staticAuth{user: &User{Email: "user@example.com"}}}
+
+	today := time.Now().Format("2006-01-02")
+	for _, tt := range []struct {
+		network string
+		code    int
+	}{
+		{`{"name": "Production", "cidrs": ["198.51.100.0/24"], "blackouts": ["` + today + `/` + today + `"]}`, http.StatusCreated},
+		{`{"name": "Office", "cidrs": ["192.0.2.0/24"], "windows": ["00:00-24:00"]}`, http.StatusCreated},
+		{`{"name": "Bad", "cidrs": ["203.0.113.0/24"], "windows": ["sometimes"]}`, http.StatusBadRequest},
+	} {
+		res, err := http.Post(ts.URL+"/api/v1/networks", "application/json", bytes.NewBufferString(tt.network))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.network, tt.code, res.StatusCode)
+		}
+	}
+	networks, err := db.LoadNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range networks {
+		if n.Name == "Production" && (len(n.Blackouts) != 1 || len(n.Windows) != 0) {
+			t.Errorf("expected a blackout saved, got %+v", n)
+		}
+		if n.Name == "Office" && (len(n.Windows) != 1 || len(n.Blackouts) != 0) {
+			t.Errorf("expected a window saved, got %+v", n)
+		}
+	}
+
+	for _, job := range []scan.Job{
+		{CIDR: "198.51.100.0/25", Ports: "22", Proto: "tcp"},
+		{CIDR: "192.0.2.1/32", Ports: "22", Proto: "tcp"},
+	} {
+		if _, err := db.QueueJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Jobs in the blackout are neither listed nor claimed
+	res, err := http.Get(ts.URL + "/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []scan.Job
+	json.NewDecoder(res.Body).Decode(&listed)
+	res.Body.Close()
+	if len(listed) != 1 || listed[0].ID != 2 {
+		t.Errorf("expected only job 2 listed, got %+v", listed)
+	}
+	for _, want := range []int{http.StatusOK, http.StatusNoContent} {
+		req, _ := http.NewRequest("POST", ts.URL+"/jobs/claim", nil)
+		req.Header.Set("X-Agent", "scanner1")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var job scan.Job
+		json.NewDecoder(res.Body).Decode(&job)
+		res.Body.Close()
+		if res.StatusCode != want || (want == http.StatusOK && job.ID != 2) {
+			t.Errorf("expected status %d claiming, got %d %+v", want, res.StatusCode, job)
+		}
+	}
+
+	// The masscan task's ranges are in the blackout too
+	now := time.Now()
+	if app.masscanAllowed(now) {
+		t.Error("expected masscan deferred by the blackout")
+	}
+	s, err := newScheduler("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.add("masscan", time.Hour, func(time.Time) {}).allowed = app.masscanAllowed
+	app.scheduler = s
+	res, err = http.Post(ts.URL+"/api/v1/tasks/masscan", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("expected status 409 running masscan in a blackout, got %d", res.StatusCode)
+	}
+
+	app.masscan.ranges = []string{"192.0.2.0/24"}
+	if !app.masscanAllowed(now) {
+		t.Error("expected masscan allowed in the office's window")
+	}
+}